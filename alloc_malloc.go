@@ -0,0 +1,179 @@
+// Completion: 100% - One malloc/free pair per allocation with --alloc=malloc
+package main
+
+import "fmt"
+
+// alloc_malloc.go - Allocations that Valgrind and ASan can see
+//
+//	c67 --alloc=malloc app.c67 -o app
+//	valgrind ./app
+//	LD_PRELOAD=libasan.so.8 ./app
+//
+// Arenas hand out pieces of large mmap'd buffers, so a tool that watches
+// malloc and free sees nothing, and an overrun only runs into the next
+// allocation. With --alloc=malloc, c67_arena_alloc gets every block from
+// malloc instead, with a 16-byte header in front of it:
+//
+//	[next block of the arena][size][the size bytes that were asked for]
+//
+// The header links the block into a list that starts at [arena+32], and
+// resetting or destroying the arena frees every block in the list, as does
+// program exit for the arenas that are left. Memory that is read after its
+// arena was reset, read before it was written, or written past its end is
+// then reported by the tool, in the runtime and in user code alike.
+//
+// ASan only checks memory that libc touches (memcpy, strlen, ...) and the
+// frees themselves, since the generated code is not instrumented. Blocks
+// are never reused, so a program that resets an arena in a loop uses as
+// much memory as it would with malloc and free in C, and arena blocks in
+// @@ loops keep their blocks until the thread arena is destroyed.
+
+// mallocArenaList is the offset of the list of blocks in an arena struct
+const mallocArenaList = 32
+
+// mallocOutOfMemoryMsg is printed when malloc returns NULL
+const mallocOutOfMemoryMsg = "Error: malloc failed (out of memory)\n"
+
+// mallocAllocEnabled reports whether every allocation comes from malloc
+func (fc *C67Compiler) mallocAllocEnabled() bool {
+	return AllocFlag == "malloc" && fc.eb.target.OS() == OSLinux && fc.eb.target.Arch() == ArchX86_64
+}
+
+// emitMallocAllocInit checks --alloc=malloc at program entry and makes
+// malloc and free part of the first pass, which decides what is linked
+func (fc *C67Compiler) emitMallocAllocInit() {
+	if AllocFlag != "malloc" {
+		return
+	}
+	if !fc.mallocAllocEnabled() {
+		compilerError("--alloc=malloc is only supported on Linux x86-64")
+	}
+	if GCFlag {
+		compilerError("--alloc=malloc and --gc can not be combined")
+	}
+	if NoMainFlag {
+		compilerError("--alloc=malloc needs libc, which --no-main does not link")
+	}
+	fc.eb.Define("_c67_malloc_oom_msg", mallocOutOfMemoryMsg)
+	fc.trackFunctionCall("malloc")
+	fc.trackFunctionCall("free")
+}
+
+// emitMallocAllocDispatch sends every c67_arena_alloc call to
+// _c67_malloc_alloc. It is emitted at the entry of c67_arena_alloc.
+func (fc *C67Compiler) emitMallocAllocDispatch() {
+	fc.out.CallSymbol("_c67_malloc_alloc")
+	fc.out.Ret()
+}
+
+// emitMallocFreeAll frees the blocks of the arena in rdi, from
+// c67_arena_reset, c67_arena_destroy and program exit. Preserves every
+// register.
+func (fc *C67Compiler) emitMallocFreeAll() {
+	if fc.mallocAllocEnabled() {
+		fc.out.CallSymbol("_c67_malloc_free_all")
+	}
+}
+
+// generateMallocAllocHelpers emits _c67_malloc_alloc and _c67_malloc_free_all
+func (fc *C67Compiler) generateMallocAllocHelpers() {
+	fc.generateMallocAlloc()
+	fc.generateMallocFreeAll()
+}
+
+// emitSaveSSE saves the SSE registers, which malloc and free may change
+// where c67_arena_alloc does not, on an aligned part of the stack. The
+// frame must be restored from rbp afterwards.
+func (fc *C67Compiler) emitSaveSSE() {
+	fc.out.AndRegWithImm("rsp", -16)
+	fc.out.SubImmFromReg("rsp", 512)
+	fc.out.Emit([]byte{0x48, 0x0f, 0xae, 0x04, 0x24}) // fxsave64 [rsp]
+}
+
+func (fc *C67Compiler) emitRestoreSSE() {
+	fc.out.Emit([]byte{0x48, 0x0f, 0xae, 0x0c, 0x24}) // fxrstor64 [rsp]
+}
+
+// generateMallocAlloc emits _c67_malloc_alloc(rdi=arena, rsi=size) -> rax.
+// Clobbers rcx, rdx, rsi, rdi and r8-r10, like c67_arena_alloc.
+func (fc *C67Compiler) generateMallocAlloc() {
+	fc.eb.MarkFunction("_c67_malloc_alloc")
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r11")
+	fc.out.PushReg("r12")
+	fc.out.MovRegToReg("rbx", "rdi") // rbx = arena
+	fc.out.MovRegToReg("r12", "rsi") // r12 = size
+	fc.emitSaveSSE()
+
+	fc.out.LeaMemToReg("rdi", "r12", 16)
+	fc.trackFunctionCall("malloc")
+	fc.eb.GenerateCallInstruction("malloc")
+	fc.out.TestRegReg("rax", "rax")
+	allocated := fc.forwardJump(JumpNotEqual)
+	fc.out.MovImmToReg("rdi", "2")
+	fc.out.LeaSymbolToReg("rsi", "_c67_malloc_oom_msg")
+	fc.out.MovImmToReg("rdx", fmt.Sprint(len(mallocOutOfMemoryMsg)))
+	fc.out.MovImmToReg("rax", "1") // write
+	fc.out.Syscall()
+	fc.out.MovImmToReg("rdi", "1")
+	fc.out.MovImmToReg("rax", "231") // exit_group
+	fc.out.Syscall()
+	fc.landForwardJump(allocated)
+
+	// Link the block in front of the list of the arena
+	fc.out.MovRegToMem("r12", "rax", 8)
+	fc.out.MovMemToReg("rcx", "rbx", mallocArenaList)
+	fc.out.MovRegToMem("rcx", "rax", 0)
+	fc.out.MovRegToMem("rax", "rbx", mallocArenaList)
+	fc.out.AddImmToReg("rax", 16)
+
+	fc.emitRestoreSSE()
+	fc.out.LeaMemToReg("rsp", "rbp", -24)
+	fc.out.PopReg("r12")
+	fc.out.PopReg("r11")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// mallocFreeAllSaved are the registers _c67_malloc_free_all preserves on
+// the stack, besides the SSE registers
+var mallocFreeAllSaved = []string{"rax", "rcx", "rdx", "rsi", "rdi", "r8", "r9", "r10", "r11", "rbx"}
+
+// generateMallocFreeAll emits _c67_malloc_free_all(rdi=arena), which frees
+// the blocks of the arena and empties its list. rdi may be 0.
+func (fc *C67Compiler) generateMallocFreeAll() {
+	fc.eb.MarkFunction("_c67_malloc_free_all")
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	for _, reg := range mallocFreeAllSaved {
+		fc.out.PushReg(reg)
+	}
+	fc.out.XorRegWithReg("rbx", "rbx")
+	fc.out.TestRegReg("rdi", "rdi") // meta-arena slots that were never used
+	unused := fc.forwardJump(JumpEqual)
+	fc.out.MovMemToReg("rbx", "rdi", mallocArenaList)
+	fc.out.MovImmToMem(0, "rdi", mallocArenaList)
+	fc.landForwardJump(unused)
+	fc.emitSaveSSE()
+
+	loop := fc.eb.text.Len()
+	fc.out.TestRegReg("rbx", "rbx")
+	done := fc.forwardJump(JumpEqual)
+	fc.out.MovRegToReg("rdi", "rbx")
+	fc.out.MovMemToReg("rbx", "rbx", 0)
+	fc.trackFunctionCall("free")
+	fc.eb.GenerateCallInstruction("free")
+	fc.out.JumpUnconditional(int32(loop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(done)
+
+	fc.emitRestoreSSE()
+	fc.out.LeaMemToReg("rsp", "rbp", -8*len(mallocFreeAllSaved))
+	for i := len(mallocFreeAllSaved) - 1; i >= 0; i-- {
+		fc.out.PopReg(mallocFreeAllSaved[i])
+	}
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"debug/elf"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestAllocMalloc tests that --alloc=malloc links malloc and free and
+// gives the same results as arenas
+func TestAllocMalloc(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("--alloc=malloc is only supported on Linux x86-64")
+	}
+	defer func() { AllocFlag = "arena" }()
+	AllocFlag = "malloc"
+
+	source := `words := [f"{i}" : @@ i in 0..<8]
+total := 0
+@ k in 0..<2000 {
+    arena {
+        xs := [k, k + 1, k + 2]
+        s := f"n{k}"
+        total <- total + xs[2] - xs[0] + #s
+    }
+}
+w: str = words[5]
+println(total)
+println(w)
+`
+	binary := compileTestCode(t, source)
+	f, err := elf.Open(binary)
+	if err != nil {
+		t.Fatalf("Failed to open ELF: %v", err)
+	}
+	symbols, _ := f.ImportedSymbols()
+	f.Close()
+	imported := map[string]bool{}
+	for _, symbol := range symbols {
+		imported[symbol.Name] = true
+	}
+	if !imported["malloc"] || !imported["free"] {
+		t.Errorf("Expected malloc and free to be imported, got %v", symbols)
+	}
+
+	stdout, stderr, exitCode := runCommandSeparate(exec.Command(binary))
+	if stdout != "12890\n5\n" || exitCode != 0 {
+		t.Errorf("Expected \"12890\\n5\\n\" and exit code 0, got %q and %d: %s", stdout, exitCode, stderr)
+	}
+}
+
+// TestAllocMallocRejects tests that --alloc=malloc is not combined with --gc
+func TestAllocMallocRejects(t *testing.T) {
+	defer func() { AllocFlag = "arena"; GCFlag = false }()
+	AllocFlag, GCFlag = "malloc", true
+	if _, err := compileTestCodeAllowError(t, "println(1)\n"); err == nil || !strings.Contains(err.Error(), "can not be combined") {
+		t.Errorf("Expected an error about --gc, got %v", err)
+	}
+}
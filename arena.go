@@ -293,14 +293,10 @@ func (fc *C67Compiler) callArenaAlloc() {
 	// Save size to stack first (rdi will be overwritten)
 	fc.out.PushReg("rdi")
 
-	// Load arena pointer from meta-arena[currentArena-1]
+	// Load arena pointer from meta-arena[currentArena-1], or the thread arena
+	// in a parallel loop body
 	// currentArena is 1-based (1 = meta-arena[0], the default arena)
-	arenaIndex := fc.currentArena - 1
-	offset := arenaIndex * 8
-
-	fc.out.LeaSymbolToReg("rdi", "_c67_arena_meta")
-	fc.out.MovMemToReg("rdi", "rdi", 0)      // rdi = meta-arena array pointer
-	fc.out.MovMemToReg("rdi", "rdi", offset) // rdi = arena struct pointer
+	fc.loadArenaPointer("rdi")
 
 	// Restore size to rsi
 	fc.out.PopReg("rsi") // rsi = size
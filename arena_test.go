@@ -95,3 +95,99 @@ func TestArenaListAllocation(t *testing.T) {
 		t.Fatalf("Expected '1\\n2\\n3\\n', got %q", result)
 	}
 }
+
+func TestArenaDeeplyNestedBlocks(t *testing.T) {
+	code := `
+		arena {
+			arena {
+				arena {
+					arena {
+						arena {
+							arena {
+								xs := [1, 2, 3]
+								println(xs[2])
+							}
+						}
+					}
+				}
+			}
+		}
+	`
+	result := compileAndRun(t, code)
+	if result != "3\n" {
+		t.Fatalf("Expected '3\\n', got %q", result)
+	}
+}
+
+func TestArenaInParallelLoop(t *testing.T) {
+	code := `
+		total := [0, 0, 0, 0, 0, 0, 0, 0]
+		@@ i in 0..<8 {
+			@ j in 0..<20000 {
+				arena {
+					ys := [j, j, j, j]
+					scratch := alloc(256)
+				}
+			}
+			xs := [i, i * 2, i * 3]
+			total[i] <- xs[0] + xs[1] + xs[2]
+		}
+		println(total[7])
+		println(total[3])
+	`
+	result := compileAndRun(t, code)
+	if result != "42\n18\n" {
+		t.Fatalf("Expected '42\\n18\\n', got %q", result)
+	}
+}
+
+func TestArenaValuesOutliveBlock(t *testing.T) {
+	code := `
+		make_list = n -> {
+			arena {
+				xs := [n, n + 1, n + 2]
+				ret xs
+			}
+			ret [0]
+		}
+		kept := [0, 0]
+		a: list = make_list(5)
+		row := arena {
+			ys := [a[0], a[1], a[2]]
+			kept <- ys
+			ys
+		}
+		arena {
+			junk := [90, 91, 92, 93, 94, 95, 96, 97]
+		}
+		println(a[2])
+		println(row[1])
+		println(kept[0])
+	`
+	result := compileAndRun(t, code)
+	if result != "7\n6\n5\n" {
+		t.Fatalf("Expected '7\\n6\\n5\\n', got %q", result)
+	}
+}
+
+func TestArenaOnfree(t *testing.T) {
+	code := `
+		cleanup = h -> {
+			println(f"free {h}")
+		}
+		arena {
+			a := onfree(1, cleanup)
+			b := onfree(2, h -> println(f"close {h}"))
+			println(a + b)
+		}
+		x := arena {
+			c := onfree(3, cleanup)
+			c * 10
+		}
+		println(x)
+	`
+	result := compileAndRun(t, code)
+	if result != "3\nclose 2\nfree 1\nfree 3\n30\n" {
+		t.Fatalf("Expected '3\\nclose 2\\nfree 1\\nfree 3\\n30\\n', got %q", result)
+	}
+}
@@ -0,0 +1,253 @@
+// Completion: 100% - args() and flags() command-line access
+package main
+
+// args.go - Command-line arguments
+//
+// The entry code saves argc and argv in _c67_argv before anything else runs:
+// on Linux they sit on the initial stack, on macOS LC_MAIN passes them in rdi
+// and rsi. args() returns them as a list of strings, program name first.
+//
+// flags() parses the arguments after the program name into a map:
+//
+//	--name=value   m.name is the string "value"
+//	--name         m.name is 1
+//	--             every later argument is positional
+//	anything else  positional, stored under 0, 1, 2, ...
+//
+// Flag names are hashed with the same 64-bit FNV-1a as hashStringKey, so
+// m.name and m[hash("name")] both read the flag. A flag that is given twice
+// keeps its last value, and a flag that is not given reads as 0.
+
+// emitSaveProgramArgs stores argc and argv in _c67_argv. Must be the first
+// code at the entry point, while rsp, rdi and rsi are still untouched.
+func (fc *C67Compiler) emitSaveProgramArgs() {
+	fc.eb.DefineWritable("_c67_argv", "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	switch fc.eb.target.OS() {
+	case OSLinux:
+		fc.out.MovMemToReg("rdi", "rsp", 0) // argc
+		fc.out.MovRegToReg("rsi", "rsp")
+		fc.out.AddImmToReg("rsi", 8) // argv
+	case OSDarwin:
+		// rdi = argc, rsi = argv already
+	default:
+		return
+	}
+	fc.out.LeaSymbolToReg("rax", "_c67_argv")
+	fc.out.MovRegToMem("rdi", "rax", 0)
+	fc.out.MovRegToMem("rsi", "rax", 8)
+}
+
+// compileArgsCall compiles args() and flags()
+func (fc *C67Compiler) compileArgsCall(call *CallExpr) {
+	if len(call.Args) != 0 {
+		compilerError("%s() takes no arguments", call.Function)
+	}
+	if fc.eb.target.OS() == OSWindows {
+		compilerError("%s() is not supported on Windows", call.Function)
+	}
+	fc.usesArgs = true
+	fc.trackFunctionCall("strlen") // cstr_to_c67_string
+	fc.out.SubImmFromReg("rsp", StackSlotSize)
+	fc.out.CallSymbol("_c67_" + call.Function)
+	fc.out.AddImmToReg("rsp", StackSlotSize)
+	fc.out.MovqRegToXmm("xmm0", "rax")
+}
+
+// generateArgsHelpers emits the runtime helpers behind args() and flags()
+func (fc *C67Compiler) generateArgsHelpers() {
+	fc.generateArgsList()
+	fc.generateFlagsMap()
+}
+
+// generateArgsList emits _c67_args() -> rax, a list of every argument
+func (fc *C67Compiler) generateArgsList() {
+	fc.eb.MarkFunction("_c67_args")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+
+	fc.out.LeaSymbolToReg("rax", "_c67_argv")
+	fc.out.MovMemToReg("r12", "rax", 0) // r12 = argc
+	fc.out.MovMemToReg("r13", "rax", 8) // r13 = argv
+
+	// Allocate count (8) + argc * 16 bytes
+	fc.out.MovRegToReg("rdi", "r12")
+	fc.out.ShlImmReg("rdi", 4)
+	fc.out.AddImmToReg("rdi", 8)
+	fc.callArenaAlloc()
+	fc.out.MovRegToReg("rbx", "rax") // rbx = list
+	fc.out.Cvtsi2sd("xmm0", "r12")
+	fc.out.MovXmmToMem("xmm0", "rbx", 0)
+
+	fc.out.XorRegWithReg("r14", "r14") // r14 = index
+	loopStart := fc.eb.text.Len()
+	fc.out.CmpRegToReg("r14", "r12")
+	done := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovRegToReg("rax", "r14")
+	fc.out.ShlImmReg("rax", 3)
+	fc.out.AddRegToReg("rax", "r13")
+	fc.out.MovMemToReg("rdi", "rax", 0)
+	fc.out.CallSymbol("cstr_to_c67_string")
+	fc.out.MovRegToReg("rcx", "r14")
+	fc.out.ShlImmReg("rcx", 4)
+	fc.out.AddRegToReg("rcx", "rbx")
+	fc.out.Cvtsi2sd("xmm1", "r14")
+	fc.out.MovXmmToMem("xmm1", "rcx", 8)
+	fc.out.MovXmmToMem("xmm0", "rcx", 16)
+	fc.out.IncReg("r14")
+	fc.out.JumpUnconditional(int32(loopStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(done)
+
+	fc.out.MovRegToReg("rax", "rbx")
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generateFlagsMap emits _c67_flags() -> rax, the map described at the top
+// of this file
+func (fc *C67Compiler) generateFlagsMap() {
+	fc.eb.MarkFunction("_c67_flags")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+	fc.out.PushReg("r15")
+	// [rsp] = next positional index, [rsp+8] = seen "--", [rsp+16] = key
+	fc.out.SubImmFromReg("rsp", 24)
+	fc.out.MovImmToMem(0, "rsp", 0)
+	fc.out.MovImmToMem(0, "rsp", 8)
+
+	fc.out.LeaSymbolToReg("rax", "_c67_argv")
+	fc.out.MovMemToReg("r12", "rax", 0) // r12 = argc
+	fc.out.MovMemToReg("r13", "rax", 8) // r13 = argv
+
+	// At most argc - 1 entries
+	fc.out.MovRegToReg("rdi", "r12")
+	fc.out.ShlImmReg("rdi", 4)
+	fc.out.AddImmToReg("rdi", 8)
+	fc.callArenaAlloc()
+	fc.out.MovRegToReg("rbx", "rax") // rbx = map
+
+	fc.out.XorRegWithReg("r15", "r15") // r15 = entries
+	fc.out.MovImmToReg("r14", "1")     // r14 = argument index
+	loopStart := fc.eb.text.Len()
+	fc.out.CmpRegToReg("r14", "r12")
+	done := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovRegToReg("rax", "r14")
+	fc.out.ShlImmReg("rax", 3)
+	fc.out.AddRegToReg("rax", "r13")
+	fc.out.MovMemToReg("rdi", "rax", 0) // rdi = argument
+
+	// Positional unless it starts with "--" and no "--" came before
+	var positional []int
+	fc.out.MovMemToReg("rax", "rsp", 8)
+	fc.out.TestRegReg("rax", "rax")
+	positional = append(positional, fc.forwardJump(JumpNotEqual))
+	for i := 0; i < 2; i++ {
+		fc.out.MovU8MemToReg("r8", "rdi", i)
+		fc.out.CmpRegToImm("r8", '-')
+		positional = append(positional, fc.forwardJump(JumpNotEqual))
+	}
+	fc.out.MovU8MemToReg("r8", "rdi", 2)
+	fc.out.TestRegReg("r8", "r8")
+	named := fc.forwardJump(JumpNotEqual)
+	fc.out.MovImmToMem(1, "rsp", 8)
+	next := []int{fc.forwardJumpAlways()}
+
+	// Hash the name up to '=' or the end, as hashStringKey does
+	fc.landForwardJump(named)
+	fc.out.MovRegToReg("rdx", "rdi")
+	fc.out.AddImmToReg("rdx", 2)
+	fc.out.MovImmToReg("rax", "14695981039346656037") // FNV-1a 64-bit offset basis
+	fc.out.MovImmToReg("r11", "1099511628211")        // FNV-1a 64-bit prime
+	hashLoop := fc.eb.text.Len()
+	fc.out.MovU8MemToReg("r8", "rdx", 0)
+	fc.out.TestRegReg("r8", "r8")
+	hashEnd := fc.forwardJump(JumpEqual)
+	fc.out.CmpRegToImm("r8", '=')
+	hashEq := fc.forwardJump(JumpEqual)
+	fc.out.XorRegWithReg("rax", "r8")
+	fc.out.ImulRegWithReg("rax", "r11")
+	fc.out.IncReg("rdx")
+	fc.out.JumpUnconditional(int32(hashLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(hashEnd)
+	fc.landForwardJump(hashEq)
+	fc.emitSymbolKeyFold()
+	fc.out.MovRegToMem("rax", "rsp", 16)
+
+	// --name=value is the string after '=', --name is 1
+	fc.out.MovU8MemToReg("r8", "rdx", 0)
+	fc.out.TestRegReg("r8", "r8")
+	boolFlag := fc.forwardJump(JumpEqual)
+	fc.out.MovRegToReg("rdi", "rdx")
+	fc.out.IncReg("rdi")
+	fc.out.CallSymbol("cstr_to_c67_string")
+	store := []int{fc.forwardJumpAlways()}
+	fc.landForwardJump(boolFlag)
+	fc.out.MovImmToReg("rax", "1")
+	fc.out.Cvtsi2sd("xmm0", "rax")
+	store = append(store, fc.forwardJumpAlways())
+
+	for _, pos := range positional {
+		fc.landForwardJump(pos)
+	}
+	fc.out.CallSymbol("cstr_to_c67_string")
+	fc.out.MovMemToReg("rax", "rsp", 0)
+	fc.out.MovRegToMem("rax", "rsp", 16)
+	fc.out.IncReg("rax")
+	fc.out.MovRegToMem("rax", "rsp", 0)
+
+	// Store xmm0 under the key, replacing an earlier entry with the same key
+	for _, pos := range store {
+		fc.landForwardJump(pos)
+	}
+	fc.out.MovMemToReg("rax", "rsp", 16)
+	fc.out.Cvtsi2sd("xmm1", "rax")
+	fc.out.MovRegToReg("rcx", "rbx")
+	fc.out.AddImmToReg("rcx", 8)
+	fc.out.XorRegWithReg("rdx", "rdx")
+	findLoop := fc.eb.text.Len()
+	fc.out.CmpRegToReg("rdx", "r15")
+	notFound := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovMemToXmm("xmm2", "rcx", 0)
+	fc.out.Ucomisd("xmm1", "xmm2")
+	found := fc.forwardJump(JumpEqual)
+	fc.out.AddImmToReg("rcx", 16)
+	fc.out.IncReg("rdx")
+	fc.out.JumpUnconditional(int32(findLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(notFound)
+	fc.out.IncReg("r15")
+	fc.landForwardJump(found)
+	fc.out.MovXmmToMem("xmm1", "rcx", 0)
+	fc.out.MovXmmToMem("xmm0", "rcx", 8)
+
+	for _, pos := range next {
+		fc.landForwardJump(pos)
+	}
+	fc.out.IncReg("r14")
+	fc.out.JumpUnconditional(int32(loopStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(done)
+
+	fc.out.Cvtsi2sd("xmm0", "r15")
+	fc.out.MovXmmToMem("xmm0", "rbx", 0)
+	fc.out.MovRegToReg("rax", "rbx")
+	fc.out.AddImmToReg("rsp", 24)
+	fc.out.PopReg("r15")
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
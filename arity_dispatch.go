@@ -0,0 +1,213 @@
+// Completion: 100% - Compile-time arity dispatch for multi-clause lambdas
+package main
+
+// arity_dispatch.go - Select lambda clauses by argument count at compile time
+//
+// A function can be defined with clauses that take different numbers of
+// arguments:
+//
+//	area = (r) -> 3.14159 * r * r, (w, h) -> w * h
+//	log = msg -> println(msg), (level, msg) -> printf("%v: %v\n", level, msg)
+//
+// Before code generation the definition is split into one function per
+// argument count, and each call is bound to the function for its number of
+// arguments. Clauses that can never be selected (a second lambda for the same
+// argument count, or a pattern clause shadowed by an earlier one) are
+// reported as errors instead of silently losing to the first match.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// arityOverload maps argument counts to the functions split from one definition
+type arityOverload struct {
+	fixed    map[int]string // Argument count -> function name
+	variadic string         // Function for other argument counts, if any
+	minArgs  int            // Fewest arguments the variadic function takes
+}
+
+// arityVariantName names the function holding the clauses for one argument count
+func arityVariantName(name string, arity int) string {
+	return fmt.Sprintf("%s_arity%d", name, arity)
+}
+
+// resolve returns the function to call with argCount arguments
+func (o *arityOverload) resolve(argCount int) (string, bool) {
+	if name, ok := o.fixed[argCount]; ok {
+		return name, true
+	}
+	if o.variadic != "" && argCount >= o.minArgs {
+		return o.variadic, true
+	}
+	return "", false
+}
+
+// arities describes the accepted argument counts for error messages
+func (o *arityOverload) arities() string {
+	counts := make([]int, 0, len(o.fixed))
+	for count := range o.fixed {
+		counts = append(counts, count)
+	}
+	sort.Ints(counts)
+	parts := make([]string, 0, len(counts)+1)
+	for _, count := range counts {
+		parts = append(parts, fmt.Sprintf("%d", count))
+	}
+	if o.variadic != "" {
+		parts = append(parts, fmt.Sprintf("%d or more", o.minArgs))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// splitArityOverloads checks multi-clause lambda definitions for clauses that
+// can never match and splits those with several arities into one definition
+// per argument count. Definitions inside function bodies are handled too.
+func (fc *C67Compiler) splitArityOverloads(stmts []Statement) ([]Statement, error) {
+	result := make([]Statement, 0, len(stmts))
+	for _, stmt := range stmts {
+		assign, ok := stmt.(*AssignStmt)
+		if !ok {
+			result = append(result, stmt)
+			continue
+		}
+
+		switch value := assign.Value.(type) {
+		case *LambdaExpr:
+			if block, ok := value.Body.(*BlockExpr); ok {
+				body, err := fc.splitArityOverloads(block.Statements)
+				if err != nil {
+					return nil, err
+				}
+				block.Statements = body
+			}
+			result = append(result, stmt)
+			continue
+		case *PatternLambdaExpr:
+			if err := checkPatternClauseOverlap(assign.Name, value.Clauses); err != nil {
+				return nil, err
+			}
+		case *MultiLambdaExpr:
+		default:
+			result = append(result, stmt)
+			continue
+		}
+
+		variants, overload, err := splitByArity(assign.Name, assign.Value)
+		if err != nil {
+			return nil, err
+		}
+		if overload == nil {
+			// All clauses take the same number of arguments
+			result = append(result, stmt)
+			continue
+		}
+		fc.arityOverloads[assign.Name] = overload
+		for _, variant := range variants {
+			variant.Mutable = assign.Mutable
+			variant.IsUpdate = assign.IsUpdate
+			result = append(result, variant)
+		}
+	}
+	return result, nil
+}
+
+// splitByArity returns one assignment per argument count, or a nil overload
+// if every clause takes the same number of arguments
+func splitByArity(name string, value Expression) ([]*AssignStmt, *arityOverload, error) {
+	overload := &arityOverload{fixed: make(map[int]string)}
+	var variants []*AssignStmt
+
+	switch e := value.(type) {
+	case *PatternLambdaExpr:
+		groups := make(map[int][]*PatternClause)
+		var order []int
+		for _, clause := range e.Clauses {
+			arity := len(clause.Patterns)
+			if _, seen := groups[arity]; !seen {
+				order = append(order, arity)
+			}
+			groups[arity] = append(groups[arity], clause)
+		}
+		if len(order) == 1 {
+			return nil, nil, nil
+		}
+		for _, arity := range order {
+			variantName := arityVariantName(name, arity)
+			overload.fixed[arity] = variantName
+			variants = append(variants, &AssignStmt{
+				Name:  variantName,
+				Value: &PatternLambdaExpr{Clauses: groups[arity], IsNestedLambda: e.IsNestedLambda},
+			})
+		}
+
+	case *MultiLambdaExpr:
+		for _, lambda := range e.Lambdas {
+			arity := len(lambda.Params)
+			if lambda.VariadicParam != "" {
+				if overload.variadic != "" {
+					return nil, nil, fmt.Errorf("ambiguous definition of '%s': more than one clause takes a variable number of arguments", name)
+				}
+				overload.variadic = arityVariantName(name, arity) + "_plus"
+				overload.minArgs = arity
+				variants = append(variants, &AssignStmt{Name: overload.variadic, Value: lambda})
+				continue
+			}
+			if _, exists := overload.fixed[arity]; exists {
+				return nil, nil, fmt.Errorf("duplicate clause in '%s': %s takes %d argument(s), like an earlier clause", name, lambda, arity)
+			}
+			overload.fixed[arity] = arityVariantName(name, arity)
+			variants = append(variants, &AssignStmt{Name: overload.fixed[arity], Value: lambda})
+		}
+	}
+	return variants, overload, nil
+}
+
+// checkPatternClauseOverlap reports clauses that an earlier clause with the
+// same number of arguments always matches first
+func checkPatternClauseOverlap(name string, clauses []*PatternClause) error {
+	for j, later := range clauses {
+		for i, earlier := range clauses[:j] {
+			if len(earlier.Patterns) != len(later.Patterns) {
+				continue
+			}
+			if patternsSubsume(earlier.Patterns, later.Patterns) {
+				return fmt.Errorf("unreachable clause in '%s': %s can never match, %s always matches first (clauses %d and %d)",
+					name, later, earlier, j+1, i+1)
+			}
+		}
+	}
+	return nil
+}
+
+// patternsSubsume reports whether every argument list matching later also matches earlier
+func patternsSubsume(earlier, later []Pattern) bool {
+	for i, p := range earlier {
+		switch e := p.(type) {
+		case *VarPattern, *WildcardPattern:
+			continue
+		case *LiteralPattern:
+			l, ok := later[i].(*LiteralPattern)
+			if !ok || !sameLiteral(e.Value, l.Value) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// sameLiteral reports whether two pattern literals match the same value
+func sameLiteral(a, b Expression) bool {
+	switch x := a.(type) {
+	case *NumberExpr:
+		y, ok := b.(*NumberExpr)
+		return ok && x.Value == y.Value
+	case *StringExpr:
+		y, ok := b.(*StringExpr)
+		return ok && x.Value == y.Value
+	}
+	return false
+}
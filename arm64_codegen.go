@@ -3,6 +3,7 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"strings"
 	"unsafe"
@@ -28,6 +29,8 @@ type ARM64CodeGen struct {
 	cConstants        map[string]*CHeaderConstants // C constants from imports
 	currentArena      int                          // Arena depth (0=none, 1=first arena, 2=nested, etc.)
 	usesArenas        bool                         // Track if program uses any arena blocks
+	usesVecMath       bool                         // Track if program calls vector builtins (vadd, dot, mat4mul, ...)
+	usesReduce        map[string]bool              // sum/min/max/mean reductions the program calls on lists
 	currentAssignName string                       // Name of variable being assigned (for lambda self-reference)
 	deferredExprs     [][]Expression               // Stack of deferred expressions per scope (LIFO order)
 }
@@ -86,15 +89,13 @@ func (acg *ARM64CodeGen) CompileProgram(program *Program) error {
 	// PHASE 1: Compile program to calculate needed stack size
 	// Save the current text buffer position to patch prologue later
 	prologueStart := acg.eb.text.Len()
+	acg.eb.MarkFunction("c67.main")
 
 	// Emit placeholder prologue (we'll patch this later with correct stack size)
 	// Reserve space for: sub sp, sp, #SIZE (4 bytes)
 	acg.out.out.writer.WriteBytes([]byte{0xff, 0x43, 0x04, 0xd1}) // placeholder: sub sp, sp, #0x110
 	// Save frame pointer and link register
-	if err := acg.out.StrImm64("x29", "sp", 0); err != nil {
-		return err
-	}
-	if err := acg.out.StrImm64("x30", "sp", 8); err != nil {
+	if err := acg.out.StpImm64("x29", "x30", "sp", 0); err != nil {
 		return err
 	}
 	// Set frame pointer
@@ -195,10 +196,7 @@ func (acg *ARM64CodeGen) CompileProgram(program *Program) error {
 		// Don't return here - continue to generate lambdas and helpers
 	} else {
 		// Dynamic builds or macOS: restore frame and return
-		if err := acg.out.LdrImm64("x30", "sp", 8); err != nil {
-			return err
-		}
-		if err := acg.out.LdrImm64("x29", "sp", 0); err != nil {
+		if err := acg.out.LdpImm64("x29", "x30", "sp", 0); err != nil {
 			return err
 		}
 		if err := acg.out.AddImm64("sp", "sp", uint32(acg.stackFrameSize)); err != nil {
@@ -302,10 +300,7 @@ func (acg *ARM64CodeGen) compileJumpStatement(stmt *JumpStmt) error {
 		}
 
 		// Restore frame pointer and link register, then return
-		if err := acg.out.LdrImm64("x30", "sp", 8); err != nil {
-			return err
-		}
-		if err := acg.out.LdrImm64("x29", "sp", 0); err != nil {
+		if err := acg.out.LdpImm64("x29", "x30", "sp", 0); err != nil {
 			return err
 		}
 		// Add back stack frame size
@@ -719,9 +714,10 @@ func (acg *ARM64CodeGen) compileExpression(expr Expression) error {
 		}
 
 		// Push d0 onto stack to save left operand (maintain 16-byte alignment)
-		acg.out.SubImm64("sp", "sp", 16)
-		// str d0, [sp]
-		acg.out.out.writer.WriteBytes([]byte{0xe0, 0x03, 0x00, 0xfd}) // str d0, [sp]
+		// str d0, [sp, #-16]!
+		if err := acg.out.StrPreIndex64Double("d0", "sp", -16); err != nil {
+			return err
+		}
 
 		// Compile right operand (result in d0)
 		if err := acg.compileExpression(e.Right); err != nil {
@@ -733,8 +729,10 @@ func (acg *ARM64CodeGen) compileExpression(expr Expression) error {
 		acg.out.out.writer.WriteBytes([]byte{0x01, 0x40, 0x60, 0x1e})
 
 		// Pop left operand into d0
-		acg.out.out.writer.WriteBytes([]byte{0xe0, 0x03, 0x40, 0xfd}) // ldr d0, [sp]
-		acg.out.AddImm64("sp", "sp", 16)
+		// ldr d0, [sp], #16
+		if err := acg.out.LdrPostIndex64Double("d0", "sp", 16); err != nil {
+			return err
+		}
 
 		// Perform operation: d0 = d0 op d1
 		switch e.Operator {
@@ -744,8 +742,8 @@ func (acg *ARM64CodeGen) compileExpression(expr Expression) error {
 		case "-":
 			// fsub d0, d0, d1
 			acg.out.out.writer.WriteBytes([]byte{0x00, 0x38, 0x61, 0x1e})
-		case "*":
-			// fmul d0, d0, d1
+		case "*", "*+":
+			// fmul d0, d0, d1 (a *+ b + c becomes an FMAExpr in the parser)
 			acg.out.out.writer.WriteBytes([]byte{0x00, 0x08, 0x61, 0x1e})
 		case "/":
 			// fdiv d0, d0, d1
@@ -990,6 +988,10 @@ func (acg *ARM64CodeGen) compileExpression(expr Expression) error {
 		acg.out.out.writer.WriteBytes([]byte{0x00, 0x00, 0x62, 0x9e}) // scvtf d0, x0
 
 	case *IndexExpr:
+		if acg.getExprType(e.List) == "map" {
+			return acg.compileMapLookup(e)
+		}
+
 		// Compile the list/map expression
 		if err := acg.compileExpression(e.List); err != nil {
 			return err
@@ -1200,6 +1202,10 @@ func (acg *ARM64CodeGen) compileExpression(expr Expression) error {
 		if err := acg.out.MovImm64("x2", 0); err != nil {
 			return err
 		}
+		// x3 = address of the first element (skip count)
+		if err := acg.out.AddImm64("x3", "x0", 8); err != nil {
+			return err
+		}
 
 		// Load search value into d2
 		acg.out.out.writer.WriteBytes([]byte{0xe2, 0x03, 0x40, 0xfd}) // ldr d2, [sp]
@@ -1214,19 +1220,11 @@ func (acg *ARM64CodeGen) compileExpression(expr Expression) error {
 		notFoundJumpPos := acg.eb.text.Len()
 		acg.out.BranchCond("ge", 0) // Placeholder
 
-		// Calculate element address: x0 + 8 + (x2 * 8)
-		// x3 = x2 * 8
-		acg.out.out.writer.WriteBytes([]byte{0x43, 0xf0, 0x7d, 0xd3}) // lsl x3, x2, #3
-		// x3 = x0 + x3
-		acg.out.out.writer.WriteBytes([]byte{0x03, 0x00, 0x00, 0x8b}) // add x3, x0, x3
-		// x3 = x3 + 8 (skip count)
-		if err := acg.out.AddImm64("x3", "x3", 8); err != nil {
+		// Load element into d3 and advance to the next one: ldr d3, [x3], #8
+		if err := acg.out.LdrPostIndex64Double("d3", "x3", 8); err != nil {
 			return err
 		}
 
-		// Load element into d3
-		acg.out.out.writer.WriteBytes([]byte{0x63, 0x00, 0x40, 0xfd}) // ldr d3, [x3]
-
 		// Compare element with search value: fcmp d2, d3
 		acg.out.out.writer.WriteBytes([]byte{0x40, 0x20, 0x63, 0x1e})
 
@@ -1272,6 +1270,12 @@ func (acg *ARM64CodeGen) compileExpression(expr Expression) error {
 	case *ParallelExpr:
 		return acg.compileParallelExpr(e)
 
+	case *VectorExpr:
+		return acg.compileVectorExpr(e)
+
+	case *FMAExpr:
+		return acg.compileFMA(e.A, e.B, e.C, e.IsSub)
+
 	case *NamespacedIdentExpr:
 		// Handle namespaced identifiers like sdl.SDL_INIT_VIDEO or data.field
 		// Check if this is a C constant
@@ -1494,6 +1498,37 @@ func (acg *ARM64CodeGen) compileExpression(expr Expression) error {
 	return nil
 }
 
+// compileFMA compiles a * b + c (or a * b - c) to a single FMADD/FNMSUB.
+// Scalar FMA is part of the base ARMv8 FP instruction set, so no fallback is needed.
+func (acg *ARM64CodeGen) compileFMA(a, b, c Expression, isSub bool) error {
+	if err := acg.compileExpression(a); err != nil {
+		return err
+	}
+	if err := acg.out.StrPreIndex64Double("d0", "sp", -16); err != nil { // str d0, [sp, #-16]!
+		return err
+	}
+
+	if err := acg.compileExpression(b); err != nil {
+		return err
+	}
+	acg.out.out.writer.WriteBytes([]byte{0xe0, 0x07, 0x00, 0xfd}) // str d0, [sp, #8]
+
+	if err := acg.compileExpression(c); err != nil {
+		return err
+	}
+	acg.out.out.writer.WriteBytes([]byte{0x02, 0x40, 0x60, 0x1e})              // fmov d2, d0
+	if err := acg.out.LdpPostIndex64Double("d0", "d1", "sp", 16); err != nil { // ldp d0, d1, [sp], #16
+		return err
+	}
+
+	if isSub {
+		acg.out.out.writer.WriteBytes([]byte{0x00, 0x88, 0x61, 0x1f}) // fnmsub d0, d0, d1, d2 (d0*d1 - d2)
+	} else {
+		acg.out.out.writer.WriteBytes([]byte{0x00, 0x08, 0x41, 0x1f}) // fmadd d0, d0, d1, d2
+	}
+	return nil
+}
+
 // compileAssignment compiles an assignment statement
 func (acg *ARM64CodeGen) compileAssignment(assign *AssignStmt) error {
 	// Validate assignment semantics
@@ -1682,6 +1717,100 @@ func (acg *ARM64CodeGen) compileMatchExpr(expr *MatchExpr) error {
 	return nil
 }
 
+// compileMapLookup compiles map[key] for maps laid out as [count][key0][val0][key1][val1]...
+// Uses NEON to compare 2 keys per iteration: LD2 de-interleaves keys and values
+// into separate vectors, so no shuffling is needed. Result (or 0.0) is in d0.
+func (acg *ARM64CodeGen) compileMapLookup(e *IndexExpr) error {
+	// Compile the map expression
+	if err := acg.compileExpression(e.List); err != nil {
+		return err
+	}
+
+	// Convert to integer pointer and save it (maintain 16-byte stack alignment)
+	acg.out.out.writer.WriteBytes([]byte{0x00, 0x00, 0x78, 0x9e}) // fcvtzs x0, d0
+	acg.out.SubImm64("sp", "sp", 16)
+	acg.out.StrImm64("x0", "sp", 0)
+
+	// Compile key expression (stays in d0)
+	if err := acg.compileExpression(e.Index); err != nil {
+		return err
+	}
+
+	// Restore map pointer
+	acg.out.LdrImm64("x0", "sp", 0)
+	acg.out.AddImm64("sp", "sp", 16)
+
+	// x2 = count, x0 = first key
+	acg.out.LdrImm64Double("d1", "x0", 0)
+	acg.out.FcvtzsDoubleToInt64("x2", "d1")
+	acg.out.AddImm64("x0", "x0", 8)
+
+	// Broadcast search key to both lanes of v0 (d0 keeps the scalar key)
+	acg.out.out.writer.WriteBytes([]byte{0x00, 0x04, 0x08, 0x4e}) // dup v0.2d, v0.d[0]
+
+	// ============ NEON PATH (2 keys/iteration) ============
+	neonLoopStart := acg.eb.text.Len()
+	acg.out.CmpImm64("x2", 2)
+	neonSkipJump := acg.eb.text.Len()
+	acg.out.BranchCond("lt", 0) // Remaining keys < 2: scalar loop
+
+	acg.out.out.writer.WriteBytes([]byte{0x01, 0x8c, 0xdf, 0x4c}) // ld2 {v1.2d, v2.2d}, [x0], #32 (v1 = keys, v2 = values)
+	acg.out.out.writer.WriteBytes([]byte{0x23, 0xe4, 0x60, 0x4e}) // fcmeq v3.2d, v1.2d, v0.2d
+	acg.out.out.writer.WriteBytes([]byte{0x63, 0x3c, 0x08, 0x4e}) // mov x3, v3.d[0]
+	lane0FoundJump := acg.eb.text.Len()
+	acg.out.CompareAndBranchNonZero64("x3", 0)
+	acg.out.out.writer.WriteBytes([]byte{0x63, 0x3c, 0x18, 0x4e}) // mov x3, v3.d[1]
+	lane1FoundJump := acg.eb.text.Len()
+	acg.out.CompareAndBranchNonZero64("x3", 0)
+	acg.out.SubImm64("x2", "x2", 2)
+	acg.out.Branch(int32(neonLoopStart - acg.eb.text.Len()))
+
+	// Lane 0 matched - value is v2.d[0]
+	lane0FoundPos := acg.eb.text.Len()
+	acg.patchJumpOffset(lane0FoundJump, int32(lane0FoundPos-lane0FoundJump))
+	acg.out.out.writer.WriteBytes([]byte{0x40, 0x40, 0x60, 0x1e}) // fmov d0, d2
+	lane0DoneJump := acg.eb.text.Len()
+	acg.out.Branch(0)
+
+	// Lane 1 matched - value is v2.d[1]
+	lane1FoundPos := acg.eb.text.Len()
+	acg.patchJumpOffset(lane1FoundJump, int32(lane1FoundPos-lane1FoundJump))
+	acg.out.out.writer.WriteBytes([]byte{0x40, 0x44, 0x08, 0x6e}) // mov v0.d[0], v2.d[1]
+	lane1DoneJump := acg.eb.text.Len()
+	acg.out.Branch(0)
+
+	// ============ SCALAR PATH (remaining key) ============
+	scalarLoopStart := acg.eb.text.Len()
+	acg.patchJumpOffset(neonSkipJump, int32(scalarLoopStart-neonSkipJump))
+	notFoundJump := acg.eb.text.Len()
+	acg.out.CompareAndBranchZero64("x2", 0)
+	acg.out.LdrImm64Double("d1", "x0", 0)
+	acg.out.FcmpScalar64("d1", "d0")
+	scalarFoundJump := acg.eb.text.Len()
+	acg.out.BranchCond("eq", 0)
+	acg.out.AddImm64("x0", "x0", 16)
+	acg.out.SubImm64("x2", "x2", 1)
+	acg.out.Branch(int32(scalarLoopStart - acg.eb.text.Len()))
+
+	// Scalar match - load value at [x0+8]
+	scalarFoundPos := acg.eb.text.Len()
+	acg.patchJumpOffset(scalarFoundJump, int32(scalarFoundPos-scalarFoundJump))
+	acg.out.LdrImm64Double("d0", "x0", 8)
+	scalarDoneJump := acg.eb.text.Len()
+	acg.out.Branch(0)
+
+	// Not found - return 0.0
+	notFoundPos := acg.eb.text.Len()
+	acg.patchJumpOffset(notFoundJump, int32(notFoundPos-notFoundJump))
+	acg.out.out.writer.WriteBytes([]byte{0xe0, 0x03, 0x67, 0x9e}) // fmov d0, xzr
+
+	donePos := acg.eb.text.Len()
+	acg.patchJumpOffset(lane0DoneJump, int32(donePos-lane0DoneJump))
+	acg.patchJumpOffset(lane1DoneJump, int32(donePos-lane1DoneJump))
+	acg.patchJumpOffset(scalarDoneJump, int32(donePos-scalarDoneJump))
+	return nil
+}
+
 // patchJumpOffset patches a branch instruction's offset
 func (acg *ARM64CodeGen) patchJumpOffset(pos int, offset int32) {
 	// ARM64 branch offsets are in words (4 bytes), not bytes
@@ -1701,10 +1830,16 @@ func (acg *ARM64CodeGen) patchJumpOffset(pos int, offset int32) {
 	// Check if it's a conditional branch (B.cond) or unconditional branch (B)
 	if (instr & 0xff000010) == 0x54000000 {
 		// Conditional branch: B.cond - imm19 at bits [23:5]
+		checkReach("the B.cond", pos, int64(offset), 19, 4)
 		instr = (instr & 0xff00001f) | ((uint32(imm) & 0x7ffff) << 5)
 	} else if (instr & 0xfc000000) == 0x14000000 {
 		// Unconditional branch: B - imm26 at bits [25:0]
+		checkReach("the B", pos, int64(offset), 26, 4)
 		instr = (instr & 0xfc000000) | (uint32(imm) & 0x3ffffff)
+	} else if (instr & 0x7e000000) == 0x34000000 {
+		// Compare and branch: CBZ/CBNZ - imm19 at bits [23:5]
+		checkReach("the CBZ/CBNZ", pos, int64(offset), 19, 4)
+		instr = (instr & 0xff00001f) | ((uint32(imm) & 0x7ffff) << 5)
 	}
 
 	// Write back patched instruction
@@ -1950,6 +2085,17 @@ func (acg *ARM64CodeGen) compileCall(call *CallExpr) error {
 		return acg.compileFFICall(call)
 	case "alloc":
 		return acg.compileAlloc(call)
+	case "vadd", "vsub", "vmul", "vdiv", "vscale", "dot", "cross", "normalize", "mat4mul":
+		return acg.compileVectorCall(call)
+	case "sum", "min", "max", "mean":
+		return acg.compileReduceCall(call)
+	case "popcount", "clz", "ctz", "bswap":
+		return acg.compileBitCall(call)
+	case "fma":
+		if len(call.Args) != 3 {
+			return fmt.Errorf("fma() requires exactly 3 arguments: fma(a, b, c) = a * b + c")
+		}
+		return acg.compileFMA(call.Args[0], call.Args[1], call.Args[2], false)
 	case "string_concat":
 		// Internal string concatenation function
 		// Arguments should already be in x0 and x1
@@ -2004,36 +2150,77 @@ func (acg *ARM64CodeGen) compileCall(call *CallExpr) error {
 	}
 }
 
-// compileSelfRecursiveCall compiles a self-recursive call within a lambda
-func (acg *ARM64CodeGen) compileSelfRecursiveCall(call *CallExpr) error {
-	// Evaluate all arguments and save to stack
-	for _, arg := range call.Args {
-		if err := acg.compileExpression(arg); err != nil {
+// compileLambdaArgs evaluates the arguments of a lambda call into d0-d7 (see
+// lambdaArgRegs). Numbers and immutable variables cannot call anything, so
+// they are loaded last, straight into their register. The other arguments
+// are evaluated in order, and all but the last of them wait on the stack.
+func (acg *ARM64CodeGen) compileLambdaArgs(args []Expression) error {
+	regs := lambdaArgRegs(ArchARM64)
+	var computed []int
+	for i, arg := range args {
+		if !acg.isDirectLambdaArg(arg) {
+			computed = append(computed, i)
+		}
+	}
+	for k, i := range computed {
+		if err := acg.compileExpression(args[i]); err != nil {
+			return err
+		}
+		if k < len(computed)-1 {
+			if err := acg.out.StrPreIndex64Double("d0", "sp", -16); err != nil { // str d0, [sp, #-16]!
+				return err
+			}
+		} else if i != 0 {
+			if err := acg.out.FmovDouble(regs[i], "d0"); err != nil {
+				return err
+			}
+		}
+	}
+	for k := len(computed) - 2; k >= 0; k-- {
+		if err := acg.out.LdrPostIndex64Double(regs[computed[k]], "sp", 16); err != nil { // ldr dN, [sp], #16
 			return err
 		}
-		// Result in d0, save to stack
-		acg.out.SubImm64("sp", "sp", 8)
-		// str d0, [sp]
-		acg.out.out.writer.WriteBytes([]byte{0xe0, 0x03, 0x00, 0xfd})
 	}
 
-	// Load arguments from stack into d0-d7 registers (in reverse order)
-	// ARM64 AAPCS64 passes float args in d0-d7
-	if len(call.Args) > 8 {
-		return fmt.Errorf("too many arguments to recursive call (max 8)")
+	for i, arg := range args {
+		var err error
+		switch a := arg.(type) {
+		case *NumberExpr:
+			if err = acg.out.MovImm64("x0", math.Float64bits(a.Value)); err == nil {
+				err = acg.out.FmovGPToDouble(regs[i], "x0")
+			}
+		case *IdentExpr:
+			if acg.isDirectLambdaArg(a) {
+				err = acg.out.LdrImm64Double(regs[i], "x29", int32(16+acg.stackVars[a.Name]-8))
+			}
+		}
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	for i := len(call.Args) - 1; i >= 0; i-- {
-		// ldr dN, [sp]
-		regNum := uint32(i)
-		instr := uint32(0xfd400000) | (regNum) | (31 << 5) // ldr dN, [sp, #0]
-		acg.out.out.writer.WriteBytes([]byte{
-			byte(instr),
-			byte(instr >> 8),
-			byte(instr >> 16),
-			byte(instr >> 24),
-		})
-		acg.out.AddImm64("sp", "sp", 8)
+// isDirectLambdaArg reports whether compileLambdaArgs loads arg straight
+// into its register: a number, or an immutable variable
+func (acg *ARM64CodeGen) isDirectLambdaArg(arg Expression) bool {
+	switch a := arg.(type) {
+	case *NumberExpr:
+		return true
+	case *IdentExpr:
+		_, exists := acg.stackVars[a.Name]
+		return exists && !acg.mutableVars[a.Name]
+	}
+	return false
+}
+
+// compileSelfRecursiveCall compiles a self-recursive call within a lambda
+func (acg *ARM64CodeGen) compileSelfRecursiveCall(call *CallExpr) error {
+	if len(call.Args) > maxLambdaArgs {
+		return fmt.Errorf("too many arguments to recursive call (max %d)", maxLambdaArgs)
+	}
+	if err := acg.compileLambdaArgs(call.Args); err != nil {
+		return err
 	}
 
 	// Call the current lambda function recursively
@@ -2480,6 +2667,10 @@ func (acg *ARM64CodeGen) compileLoopStatement(stmt *LoopStmt) error {
 
 // compileRangeExprLoop compiles a range expression loop (@ i in 1..<10 { ... })
 func (acg *ARM64CodeGen) compileRangeExprLoop(stmt *LoopStmt, rangeExpr *RangeExpr) error {
+	if rangeExpr.StepValue() != 1 {
+		return fmt.Errorf("unsupported range for ARM64: %s (only ascending ranges with step 1)", rangeExpr)
+	}
+
 	// Increment label counter for uniqueness
 	acg.labelCounter++
 
@@ -2710,15 +2901,10 @@ func (acg *ARM64CodeGen) compileListLoop(stmt *LoopStmt) error {
 	}
 	acg.activeLoops = append(acg.activeLoops, loopInfo)
 
-	// Load index: ldr x0, [x29, #offset] (positive offset)
-	offset = int32(16 + indexOffset - 8)
-	if err := acg.out.LdrImm64("x0", "x29", offset); err != nil {
-		return err
-	}
-
-	// Load length: ldr x1, [x29, #offset] (positive offset)
+	// Load length and index, which are in adjacent slots:
+	// ldp x1, x0, [x29, #offset] (positive offset)
 	offset = int32(16 + lengthOffset - 8)
-	if err := acg.out.LdrImm64("x1", "x29", offset); err != nil {
+	if err := acg.out.LdpImm64("x1", "x0", "x29", offset); err != nil {
 		return err
 	}
 
@@ -2937,19 +3123,19 @@ func (acg *ARM64CodeGen) compileTailCall(call *CallExpr) error {
 		if err := acg.compileExpression(arg); err != nil {
 			return err
 		}
-		// Push d0 onto stack
-		acg.out.SubImm64("sp", "sp", 8)
-		// str d0, [sp]
-		acg.out.out.writer.WriteBytes([]byte{0xe0, 0x03, 0x00, 0xfd})
+		// Push d0 onto stack: str d0, [sp, #-8]!
+		if err := acg.out.StrPreIndex64Double("d0", "sp", -8); err != nil {
+			return err
+		}
 	}
 
 	// Pop arguments from stack and store them in parameter locations
 	// Parameters are stored at [x29, #16 + paramOffset - 8]
 	for i := len(call.Args) - 1; i >= 0; i-- {
-		// Pop d0 from stack
-		// ldr d0, [sp]
-		acg.out.out.writer.WriteBytes([]byte{0xe0, 0x03, 0x40, 0xfd})
-		acg.out.AddImm64("sp", "sp", 8)
+		// Pop d0 from stack: ldr d0, [sp], #8
+		if err := acg.out.LdrPostIndex64Double("d0", "sp", 8); err != nil {
+			return err
+		}
 
 		// Get parameter offset
 		paramName := acg.currentLambda.Params[i]
@@ -3011,34 +3197,12 @@ func (acg *ARM64CodeGen) compileDirectCall(call *DirectCallExpr) error {
 		return err
 	}
 
-	// Evaluate all arguments and save to stack
-	for _, arg := range call.Args {
-		if err := acg.compileExpression(arg); err != nil {
-			return err
-		}
-		// Result in d0, save to stack
-		acg.out.SubImm64("sp", "sp", 8)
-		// str d0, [sp]
-		acg.out.out.writer.WriteBytes([]byte{0xe0, 0x03, 0x00, 0xfd})
-	}
-
-	// Load arguments from stack into d0-d7 registers (in reverse order)
-	// ARM64 AAPCS64 passes float args in d0-d7
-	if len(call.Args) > 8 {
-		return fmt.Errorf("too many arguments to direct call (max 8)")
+	// Evaluate the arguments into d0-d7
+	if len(call.Args) > maxLambdaArgs {
+		return fmt.Errorf("too many arguments to direct call (max %d)", maxLambdaArgs)
 	}
-
-	for i := len(call.Args) - 1; i >= 0; i-- {
-		// ldr dN, [sp]
-		regNum := uint32(i)
-		instr := uint32(0xfd400000) | (regNum) | (31 << 5) // ldr dN, [sp, #0]
-		acg.out.out.writer.WriteBytes([]byte{
-			byte(instr),
-			byte(instr >> 8),
-			byte(instr >> 16),
-			byte(instr >> 24),
-		})
-		acg.out.AddImm64("sp", "sp", 8)
+	if err := acg.compileLambdaArgs(call.Args); err != nil {
+		return err
 	}
 
 	// Load function pointer from stack to x16 (temporary register)
@@ -3681,7 +3845,7 @@ func (acg *ARM64CodeGen) generateLambdaFunctions() error {
 		}
 
 		// Mark the start of the lambda function with a label
-		acg.eb.MarkLabel(lambda.Name)
+		acg.eb.MarkFunction(lambda.Name)
 
 		// Record where the function starts (including prologue, for recursion)
 		funcStart := acg.eb.text.Len()
@@ -3698,10 +3862,7 @@ func (acg *ARM64CodeGen) generateLambdaFunctions() error {
 		if err := acg.out.SubImm64("sp", "sp", frameSize); err != nil {
 			return err
 		}
-		if err := acg.out.StrImm64("x29", "sp", 0); err != nil {
-			return err
-		}
-		if err := acg.out.StrImm64("x30", "sp", 8); err != nil {
+		if err := acg.out.StpImm64("x29", "x30", "sp", 0); err != nil {
 			return err
 		}
 		// Set frame pointer
@@ -3728,22 +3889,28 @@ func (acg *ARM64CodeGen) generateLambdaFunctions() error {
 		// Store parameters from d0-d7 registers to stack
 		// Parameters come in d0, d1, d2, d3, d4, d5, d6, d7 (AAPCS64)
 		// Store them at positive offsets after saved registers (like regular variables)
+		if len(lambda.Params) > maxLambdaArgs {
+			return fmt.Errorf("lambda has too many parameters (max %d)", maxLambdaArgs)
+		}
 		for i, paramName := range lambda.Params {
-			if i >= 8 {
-				return fmt.Errorf("lambda has too many parameters (max 8)")
-			}
-
 			// Allocate stack space for parameter (8 bytes for float64)
 			acg.stackSize += 8
 			paramOffset := acg.stackSize
 			acg.stackVars[paramName] = paramOffset
 
-			// Store parameter from d register to stack at positive offset
+			// Parameters are in adjacent slots, so store them two at a time
 			// x29 points to saved fp, variables start at offset 16
-			// str dN, [x29, #(16 + paramOffset - 8)]
+			// stp dN, dN+1, [x29, #(16 + paramOffset - 8)]
+			if i%2 == 1 {
+				continue
+			}
 			regName := fmt.Sprintf("d%d", i)
 			offset := int32(16 + paramOffset - 8)
-			if err := acg.out.StrImm64Double(regName, "x29", offset); err != nil {
+			if i+1 < len(lambda.Params) {
+				if err := acg.out.StpImm64Double(regName, fmt.Sprintf("d%d", i+1), "x29", offset); err != nil {
+					return err
+				}
+			} else if err := acg.out.StrImm64Double(regName, "x29", offset); err != nil {
 				return err
 			}
 		}
@@ -3771,10 +3938,7 @@ func (acg *ARM64CodeGen) generateLambdaFunctions() error {
 
 		// Function epilogue - ARM64 ABI
 		// Restore registers and return
-		if err := acg.out.LdrImm64("x30", "sp", 8); err != nil {
-			return err
-		}
-		if err := acg.out.LdrImm64("x29", "sp", 0); err != nil {
+		if err := acg.out.LdpImm64("x29", "x30", "sp", 0); err != nil {
 			return err
 		}
 		if err := acg.out.AddImm64("sp", "sp", frameSize); err != nil {
@@ -3800,7 +3964,7 @@ func (acg *ARM64CodeGen) getExprType(expr Expression) string {
 		return "string"
 	case *NumberExpr:
 		return "number"
-	case *ListExpr:
+	case *ListExpr, *VectorExpr:
 		return "list"
 	case *MapExpr:
 		return "map"
@@ -3833,6 +3997,9 @@ func (acg *ARM64CodeGen) getExprType(expr Expression) string {
 		if stringFuncs[e.Function] {
 			return "string"
 		}
+		if vectorListFuncs[e.Function] {
+			return "list"
+		}
 		// Other functions return numbers by default
 		return "number"
 	case *SliceExpr:
@@ -3848,12 +4015,24 @@ func (acg *ARM64CodeGen) getExprType(expr Expression) string {
 
 // generateRuntimeHelpers generates ARM64 runtime helper functions
 func (acg *ARM64CodeGen) generateRuntimeHelpers() error {
+	if acg.usesVecMath {
+		if err := acg.generateVecMathHelpers(); err != nil {
+			return err
+		}
+	}
+
+	if len(acg.usesReduce) > 0 {
+		if err := acg.generateReduceHelpers(); err != nil {
+			return err
+		}
+	}
+
 	// Generate _c67_list_concat(left_ptr, right_ptr) -> new_ptr
 	// Arguments: x0 = left_ptr, x1 = right_ptr
 	// Returns: x0 = pointer to new concatenated list
 	// List format: [length (8 bytes)][elem0 (8 bytes)][elem1 (8 bytes)]...
 
-	acg.eb.MarkLabel("_c67_list_concat")
+	acg.eb.MarkFunction("_c67_list_concat")
 
 	// Function prologue
 	// stp x29, x30, [sp, #-N]! (save fp and lr, pre-decrement sp by N)
@@ -3923,15 +4102,13 @@ func (acg *ARM64CodeGen) generateRuntimeHelpers() error {
 	leftSkipJumpPos := acg.eb.text.Len()
 	acg.out.out.writer.WriteBytes([]byte{0x0a, 0x00, 0x00, 0xb4}) // cbz x10, +0 (placeholder)
 
-	// ldr d0, [x11], str d0, [x12], increment pointers
-	if err := acg.out.LdrImm64Double("d0", "x11", 0); err != nil {
+	// ldr d0, [x11], #8 and str d0, [x12], #8 (post-index: advance both pointers)
+	if err := acg.out.LdrPostIndex64Double("d0", "x11", 8); err != nil {
 		return err
 	}
-	if err := acg.out.StrImm64Double("d0", "x12", 0); err != nil {
+	if err := acg.out.StrPostIndex64Double("d0", "x12", 8); err != nil {
 		return err
 	}
-	acg.out.AddImm64("x11", "x11", 8) // add x11, x11, #8
-	acg.out.AddImm64("x12", "x12", 8) // add x12, x12, #8
 	acg.out.SubImm64("x10", "x10", 1) // sub x10, x10, #1
 
 	// Branch back to loop start
@@ -3955,15 +4132,13 @@ func (acg *ARM64CodeGen) generateRuntimeHelpers() error {
 	rightSkipJumpPos := acg.eb.text.Len()
 	acg.out.out.writer.WriteBytes([]byte{0x0a, 0x00, 0x00, 0xb4}) // cbz x10, +0 (placeholder)
 
-	// ldr d0, [x11], str d0, [x12], increment pointers
-	if err := acg.out.LdrImm64Double("d0", "x11", 0); err != nil {
+	// ldr d0, [x11], #8 and str d0, [x12], #8 (post-index: advance both pointers)
+	if err := acg.out.LdrPostIndex64Double("d0", "x11", 8); err != nil {
 		return err
 	}
-	if err := acg.out.StrImm64Double("d0", "x12", 0); err != nil {
+	if err := acg.out.StrPostIndex64Double("d0", "x12", 8); err != nil {
 		return err
 	}
-	acg.out.AddImm64("x11", "x11", 8) // add x11, x11, #8
-	acg.out.AddImm64("x12", "x12", 8) // add x12, x12, #8
 	acg.out.SubImm64("x10", "x10", 1) // sub x10, x10, #1
 
 	// Branch back to loop start
@@ -3989,7 +4164,7 @@ func (acg *ARM64CodeGen) generateRuntimeHelpers() error {
 	// Returns: x0 = pointer to new concatenated string
 	// String format (map): [count (8 bytes)][key0 (8)][val0 (8)]...
 
-	acg.eb.MarkLabel("_c67_string_concat")
+	acg.eb.MarkFunction("_c67_string_concat")
 
 	// Function prologue - same as list concat
 	acg.out.out.writer.WriteBytes([]byte{0xfd, 0x7b, 0xbc, 0xa9}) // stp x29, x30, [sp, #-64]!
@@ -4050,21 +4225,13 @@ func (acg *ARM64CodeGen) generateRuntimeHelpers() error {
 	strLeftSkipJumpPos := acg.eb.text.Len()
 	acg.out.out.writer.WriteBytes([]byte{0x0a, 0x00, 0x00, 0xb4}) // cbz x10, +0 (placeholder)
 
-	// Copy key and value (16 bytes total)
-	if err := acg.out.LdrImm64Double("d0", "x11", 0); err != nil {
+	// Copy key and value (16 bytes total): ldp d0, d1, [x11], #16 and stp d0, d1, [x12], #16
+	if err := acg.out.LdpPostIndex64Double("d0", "d1", "x11", 16); err != nil {
 		return err
 	}
-	if err := acg.out.StrImm64Double("d0", "x12", 0); err != nil {
+	if err := acg.out.StpPostIndex64Double("d0", "d1", "x12", 16); err != nil {
 		return err
 	}
-	if err := acg.out.LdrImm64Double("d0", "x11", 8); err != nil {
-		return err
-	}
-	if err := acg.out.StrImm64Double("d0", "x12", 8); err != nil {
-		return err
-	}
-	acg.out.AddImm64("x11", "x11", 16)
-	acg.out.AddImm64("x12", "x12", 16)
 	acg.out.SubImm64("x10", "x10", 1)
 
 	// Branch back
@@ -4086,26 +4253,18 @@ func (acg *ARM64CodeGen) generateRuntimeHelpers() error {
 	strRightSkipJumpPos := acg.eb.text.Len()
 	acg.out.out.writer.WriteBytes([]byte{0x0a, 0x00, 0x00, 0xb4}) // cbz x10, +0 (placeholder)
 
-	// Load key, add offset, store
-	if err := acg.out.LdrImm64Double("d0", "x11", 0); err != nil {
+	// Load key and value, add offset to the key, store both
+	// ldp d0, d2, [x11], #16
+	if err := acg.out.LdpPostIndex64Double("d0", "d2", "x11", 16); err != nil {
 		return err
 	}
 	acg.out.out.writer.WriteBytes([]byte{0xa1, 0x02, 0x62, 0x9e}) // scvtf d1, x21 (convert offset to float)
 	acg.out.out.writer.WriteBytes([]byte{0x00, 0x28, 0x61, 0x1e}) // fadd d0, d0, d1
-	if err := acg.out.StrImm64Double("d0", "x12", 0); err != nil {
-		return err
-	}
-
-	// Copy value
-	if err := acg.out.LdrImm64Double("d0", "x11", 8); err != nil {
-		return err
-	}
-	if err := acg.out.StrImm64Double("d0", "x12", 8); err != nil {
+	// stp d0, d2, [x12], #16
+	if err := acg.out.StpPostIndex64Double("d0", "d2", "x12", 16); err != nil {
 		return err
 	}
 
-	acg.out.AddImm64("x11", "x11", 16)
-	acg.out.AddImm64("x12", "x12", 16)
 	acg.out.SubImm64("x10", "x10", 1)
 
 	// Branch back
@@ -4136,7 +4295,7 @@ func (acg *ARM64CodeGen) generateRuntimeHelpers() error {
 	// Converts integer in x0 to decimal string
 	// Returns: x1 = buffer pointer (global), x2 = length
 	// Uses global _itoa_buffer, builds string backwards
-	acg.eb.MarkLabel("_c67_itoa")
+	acg.eb.MarkFunction("_c67_itoa")
 
 	// Prologue: save link register (no stack allocation needed)
 	acg.out.out.writer.WriteBytes([]byte{0xfd, 0x7b, 0xbe, 0xa9}) // stp x29, x30, [sp, #-32]!
@@ -5205,7 +5364,7 @@ func (acg *ARM64CodeGen) generateArenaRuntimeARM64() error {
 
 	// _c67_arena_ensure_capacity(depth) - Ensure meta-arena can hold depth arenas
 	// Simplified stub: just return (arena allocation is done directly by alloc())
-	acg.eb.MarkLabel("_c67_arena_ensure_capacity")
+	acg.eb.MarkFunction("_c67_arena_ensure_capacity")
 	if err := acg.out.Return("x30"); err != nil {
 		return err
 	}
@@ -5214,7 +5373,7 @@ func (acg *ARM64CodeGen) generateArenaRuntimeARM64() error {
 	// Creates a new arena with the specified capacity
 	// Argument: x0 = capacity
 	// Returns: x0 = arena pointer
-	acg.eb.MarkLabel("c67_arena_create")
+	acg.eb.MarkFunction("c67_arena_create")
 	// Save link register
 	// stp x29, x30, [sp, #-16]!
 	acg.out.out.writer.WriteBytes([]byte{0xfd, 0x7b, 0xbf, 0xa9})
@@ -5237,7 +5396,7 @@ func (acg *ARM64CodeGen) generateArenaRuntimeARM64() error {
 	// Allocates memory from the arena
 	// Arguments: x0 = arena_ptr, x1 = size
 	// Returns: x0 = allocated memory pointer
-	acg.eb.MarkLabel("c67_arena_alloc")
+	acg.eb.MarkFunction("c67_arena_alloc")
 	// Save link register
 	// stp x29, x30, [sp, #-16]!
 	acg.out.out.writer.WriteBytes([]byte{0xfd, 0x7b, 0xbf, 0xa9})
@@ -5258,7 +5417,7 @@ func (acg *ARM64CodeGen) generateArenaRuntimeARM64() error {
 	// c67_arena_reset(arena_ptr)
 	// Resets the arena offset to 0
 	// Argument: x0 = arena_ptr
-	acg.eb.MarkLabel("c67_arena_reset")
+	acg.eb.MarkFunction("c67_arena_reset")
 	// No-op for now
 	if err := acg.out.Return("x30"); err != nil {
 		return err
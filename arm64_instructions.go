@@ -292,18 +292,8 @@ func (a *ARM64Out) CompareAndBranchZero64(reg string, offset int32) error {
 		return fmt.Errorf("invalid ARM64 register: %s", reg)
 	}
 
-	if offset%4 != 0 {
-		return fmt.Errorf("branch offset must be word-aligned: %d", offset)
-	}
-	imm19 := offset >> 2
-	if imm19 < -(1<<18) || imm19 >= (1<<18) {
-		return fmt.Errorf("branch offset out of range: %d", offset)
-	}
-
 	// CBZ (64-bit): sf=1, op=0, imm19, Rt
-	instr := uint32(0xb4000000) | (uint32(imm19&0x7ffff) << 5) | rt
-	a.encodeInstr(instr)
-	return nil
+	return a.compareAndBranch(0xb4000000, rt, offset)
 }
 
 // CBNZ (compare and branch if non-zero): CBNZ Xt, label
@@ -313,17 +303,23 @@ func (a *ARM64Out) CompareAndBranchNonZero64(reg string, offset int32) error {
 		return fmt.Errorf("invalid ARM64 register: %s", reg)
 	}
 
+	// CBNZ (64-bit): sf=1, op=1, imm19, Rt
+	return a.compareAndBranch(0xb5000000, rt, offset)
+}
+
+// compareAndBranch emits CBZ or CBNZ (op) on Xt. Beyond the ±1MB of imm19,
+// it emits the inverse over a B (see jumprange.go).
+func (a *ARM64Out) compareAndBranch(op, rt uint32, offset int32) error {
 	if offset%4 != 0 {
 		return fmt.Errorf("branch offset must be word-aligned: %d", offset)
 	}
 	imm19 := offset >> 2
 	if imm19 < -(1<<18) || imm19 >= (1<<18) {
-		return fmt.Errorf("branch offset out of range: %d", offset)
+		// CBZ and CBNZ differ in bit 24; skip the B when the inverse holds
+		a.encodeInstr((op ^ 0x01000000) | (2 << 5) | rt)
+		return a.Branch(offset - 4)
 	}
-
-	// CBNZ (64-bit): sf=1, op=1, imm19, Rt
-	instr := uint32(0xb5000000) | (uint32(imm19&0x7ffff) << 5) | rt
-	a.encodeInstr(instr)
+	a.encodeInstr(op | (uint32(imm19&0x7ffff) << 5) | rt)
 	return nil
 }
 
@@ -409,10 +405,6 @@ func (a *ARM64Out) BranchCond(cond string, offset int32) error {
 	if offset%4 != 0 {
 		return fmt.Errorf("branch offset must be word-aligned: %d", offset)
 	}
-	imm19 := offset >> 2
-	if imm19 < -(1<<18) || imm19 >= (1<<18) {
-		return fmt.Errorf("branch offset out of range: %d", offset)
-	}
 
 	// Map condition codes to their values
 	condMap := map[string]uint32{
@@ -426,6 +418,17 @@ func (a *ARM64Out) BranchCond(cond string, offset int32) error {
 		return fmt.Errorf("invalid condition code: %s", cond)
 	}
 
+	imm19 := offset >> 2
+	if imm19 < -(1<<18) || imm19 >= (1<<18) {
+		// Out of reach of imm19: the inverse condition skips a B, which
+		// reaches ±128MB (see jumprange.go). Codes differ in their low bit.
+		if condCode == 0xe {
+			return a.Branch(offset)
+		}
+		a.encodeInstr(uint32(0x54000000) | (2 << 5) | (condCode ^ 1))
+		return a.Branch(offset - 4)
+	}
+
 	// B.cond: 01010100 | imm19 | 0 | cond
 	instr := uint32(0x54000000) | (uint32(imm19&0x7ffff) << 5) | condCode
 	a.encodeInstr(instr)
@@ -928,6 +931,23 @@ func (a *ARM64Out) FcvtzsDoubleToInt64(dest, src string) error {
 	return nil
 }
 
+// FMOV (register): FMOV Dd, Dn (move double to double)
+func (a *ARM64Out) FmovDouble(dest, src string) error {
+	rd, ok := arm64FPRegs[dest]
+	if !ok {
+		return fmt.Errorf("invalid ARM64 FP register: %s", dest)
+	}
+	rn, ok := arm64FPRegs[src]
+	if !ok {
+		return fmt.Errorf("invalid ARM64 FP register: %s", src)
+	}
+
+	// FMOV (register): M=0, S=0, type=01, opc=00
+	instr := uint32(0x1e604000) | (rn << 5) | rd
+	a.encodeInstr(instr)
+	return nil
+}
+
 // FMOV (register, FP to GP): FMOV Xd, Dn (move double to GP register)
 func (a *ARM64Out) FmovDoubleToGP(dest, src string) error {
 	rd, ok := arm64GPRegs[dest]
@@ -962,6 +982,52 @@ func (a *ARM64Out) FmovGPToDouble(dest, src string) error {
 	return nil
 }
 
+// dataProc1Src64 encodes a 64-bit data-processing (1 source) instruction
+// such as CLZ, RBIT or REV: Xd = op(Xn)
+func (a *ARM64Out) dataProc1Src64(name string, base uint32, dest, src string) error {
+	rd, ok := arm64GPRegs[dest]
+	if !ok {
+		return fmt.Errorf("invalid ARM64 GP register for %s: %s", name, dest)
+	}
+	rn, ok := arm64GPRegs[src]
+	if !ok {
+		return fmt.Errorf("invalid ARM64 GP register for %s: %s", name, src)
+	}
+	a.encodeInstr(base | (rn << 5) | rd)
+	return nil
+}
+
+// CLZ: CLZ Xd, Xn (count leading zeros, 64 for 0)
+func (a *ARM64Out) Clz64(dest, src string) error {
+	return a.dataProc1Src64("clz", 0xdac01000, dest, src)
+}
+
+// RBIT: RBIT Xd, Xn (reverse the bits)
+func (a *ARM64Out) Rbit64(dest, src string) error {
+	return a.dataProc1Src64("rbit", 0xdac00000, dest, src)
+}
+
+// REV: REV Xd, Xn (reverse the bytes)
+func (a *ARM64Out) Rev64(dest, src string) error {
+	return a.dataProc1Src64("rev", 0xdac00c00, dest, src)
+}
+
+// CNT + ADDV: counts the set bits of Dn into the low byte of Dd, zeroing
+// the rest (cnt vN.8b, vN.8b; addv bD, vN.8b). Dn is overwritten.
+func (a *ARM64Out) PopcountDouble(dest, src string) error {
+	rd, ok := arm64FPRegs[dest]
+	if !ok {
+		return fmt.Errorf("invalid ARM64 FP register: %s", dest)
+	}
+	rn, ok := arm64FPRegs[src]
+	if !ok {
+		return fmt.Errorf("invalid ARM64 FP register: %s", src)
+	}
+	a.encodeInstr(0x0e205800 | (rn << 5) | rn) // cnt vn.8b, vn.8b
+	a.encodeInstr(0x0e31b800 | (rn << 5) | rd) // addv bd, vn.8b
+	return nil
+}
+
 // FCMP (scalar): FCMP Dn, Dm (floating-point compare, sets flags)
 func (a *ARM64Out) FcmpScalar64(op1, op2 string) error {
 	rn, ok := arm64FPRegs[op1]
@@ -979,8 +1045,99 @@ func (a *ARM64Out) FcmpScalar64(op1, op2 string) error {
 	return nil
 }
 
+// encodePairFP encodes LDP/STP of two D registers. base is the opcode for
+// the addressing mode: signed offset, post-index or pre-index.
+func (a *ARM64Out) encodePairFP(name string, base uint32, r1, r2, baseReg string, offset int32) error {
+	rt1, ok := arm64FPRegs[r1]
+	if !ok {
+		return fmt.Errorf("invalid ARM64 FP register: %s", r1)
+	}
+	rt2, ok := arm64FPRegs[r2]
+	if !ok {
+		return fmt.Errorf("invalid ARM64 FP register: %s", r2)
+	}
+	rn, ok := arm64GPRegs[baseReg]
+	if !ok {
+		return fmt.Errorf("invalid ARM64 register: %s", baseReg)
+	}
+
+	if offset%8 != 0 {
+		return fmt.Errorf("%s offset not 8-byte aligned: %d", name, offset)
+	}
+
+	imm7 := offset / 8
+	if imm7 < -64 || imm7 >= 64 {
+		return fmt.Errorf("%s offset out of range: %d", name, offset)
+	}
+
+	instr := base | (uint32(imm7&0x7f) << 15) | (rt2 << 10) | (rn << 5) | rt1
+	a.encodeInstr(instr)
+	return nil
+}
+
+// STP (FP/SIMD): STP Dt1, Dt2, [Xn{, #offset}]
+func (a *ARM64Out) StpImm64Double(src1, src2, base string, offset int32) error {
+	// STP (signed offset, 64-bit FP/SIMD): opc=01, V=1, L=0
+	return a.encodePairFP("STP", 0x6d000000, src1, src2, base, offset)
+}
+
+// LDP (FP/SIMD): LDP Dt1, Dt2, [Xn{, #offset}]
+func (a *ARM64Out) LdpImm64Double(dest1, dest2, base string, offset int32) error {
+	// LDP (signed offset, 64-bit FP/SIMD): opc=01, V=1, L=1
+	return a.encodePairFP("LDP", 0x6d400000, dest1, dest2, base, offset)
+}
+
+// STP (FP/SIMD, post-index): STP Dt1, Dt2, [Xn], #offset
+func (a *ARM64Out) StpPostIndex64Double(src1, src2, base string, offset int32) error {
+	return a.encodePairFP("STP", 0x6c800000, src1, src2, base, offset)
+}
+
+// LDP (FP/SIMD, post-index): LDP Dt1, Dt2, [Xn], #offset
+func (a *ARM64Out) LdpPostIndex64Double(dest1, dest2, base string, offset int32) error {
+	return a.encodePairFP("LDP", 0x6cc00000, dest1, dest2, base, offset)
+}
+
+// encodeIndexedFP encodes LDR/STR of a D register with writeback of the base
+// register. base is the opcode for the addressing mode.
+func (a *ARM64Out) encodeIndexedFP(name string, base uint32, reg, baseReg string, offset int32) error {
+	rt, ok := arm64FPRegs[reg]
+	if !ok {
+		return fmt.Errorf("invalid ARM64 FP register: %s", reg)
+	}
+	rn, ok := arm64GPRegs[baseReg]
+	if !ok {
+		return fmt.Errorf("invalid ARM64 register: %s", baseReg)
+	}
+
+	if offset < -256 || offset > 255 {
+		return fmt.Errorf("%s offset out of range: %d", name, offset)
+	}
+
+	imm9 := uint32(offset) & 0x1ff
+	instr := base | (imm9 << 12) | (rn << 5) | rt
+	a.encodeInstr(instr)
+	return nil
+}
+
+// LDR (FP/SIMD, post-index): LDR Dt, [Xn], #offset
+func (a *ARM64Out) LdrPostIndex64Double(dest, base string, offset int32) error {
+	// LDR (immediate, post-index): size=11, V=1, opc=01
+	return a.encodeIndexedFP("LDR", 0xfc400400, dest, base, offset)
+}
+
+// STR (FP/SIMD, post-index): STR Dt, [Xn], #offset
+func (a *ARM64Out) StrPostIndex64Double(src, base string, offset int32) error {
+	// STR (immediate, post-index): size=11, V=1, opc=00
+	return a.encodeIndexedFP("STR", 0xfc000400, src, base, offset)
+}
+
+// STR (FP/SIMD, pre-index): STR Dt, [Xn, #offset]!
+func (a *ARM64Out) StrPreIndex64Double(src, base string, offset int32) error {
+	// STR (immediate, pre-index): size=11, V=1, opc=00
+	return a.encodeIndexedFP("STR", 0xfc000c00, src, base, offset)
+}
+
 // Future enhancements:
 // - SIMD/NEON vector instructions (for parallel operations)
-// - Advanced addressing modes (pre/post-index)
 // - Atomic operations (LDXR, STXR, CAS, etc.)
 // - More conversion instructions (FCVT between precisions)
@@ -0,0 +1,143 @@
+// Completion: 100% - Module complete
+package main
+
+import "fmt"
+
+// arm64_reduce.go - NEON versions of the list reductions (see reduce.go)
+//
+// ARM64 lists store their values packed after the count, so two values load
+// straight into one 128-bit register with ld1 and a single pairwise
+// instruction folds the lanes at the end.
+
+// reduceArgError is error("arg") as the bits of a float64
+const reduceArgError = errorNaNBase | 0x61726700
+
+// compileReduceCall compiles sum(), min(), max() and mean()
+func (acg *ARM64CodeGen) compileReduceCall(call *CallExpr) error {
+	if (call.Function == "min" || call.Function == "max") && len(call.Args) == 2 {
+		if err := acg.compileExpression(call.Args[0]); err != nil {
+			return err
+		}
+		acg.out.SubImm64("sp", "sp", 16)
+		acg.out.out.writer.WriteBytes([]byte{0xe0, 0x03, 0x00, 0xfd}) // str d0, [sp]
+		if err := acg.compileExpression(call.Args[1]); err != nil {
+			return err
+		}
+		acg.out.out.writer.WriteBytes([]byte{0x01, 0x40, 0x60, 0x1e}) // fmov d1, d0
+		acg.out.out.writer.WriteBytes([]byte{0xe0, 0x03, 0x40, 0xfd}) // ldr d0, [sp]
+		acg.out.AddImm64("sp", "sp", 16)
+		if call.Function == "min" {
+			acg.out.out.writer.WriteBytes([]byte{0x00, 0x58, 0x61, 0x1e}) // fmin d0, d0, d1
+		} else {
+			acg.out.out.writer.WriteBytes([]byte{0x00, 0x48, 0x61, 0x1e}) // fmax d0, d0, d1
+		}
+		return nil
+	}
+	if len(call.Args) != 1 {
+		return fmt.Errorf("%s() requires exactly 1 argument (a list)", call.Function)
+	}
+
+	if err := acg.compileExpression(call.Args[0]); err != nil {
+		return err
+	}
+	acg.out.out.writer.WriteBytes([]byte{0x00, 0x00, 0x78, 0x9e}) // fcvtzs x0, d0
+
+	if acg.usesReduce == nil {
+		acg.usesReduce = make(map[string]bool)
+	}
+	acg.usesReduce[call.Function] = true
+	return acg.eb.GenerateCallInstruction("_c67_" + call.Function)
+}
+
+// generateReduceHelpers generates the helpers for every reduction in use
+func (acg *ARM64CodeGen) generateReduceHelpers() error {
+	for _, op := range []string{"sum", "min", "max", "mean"} {
+		if acg.usesReduce[op] {
+			if err := acg.generateReduce(op); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// generateReduce generates _c67_<op>(list) -> number
+// Arguments: x0 = list
+// Returns: d0 = the reduction, error("arg") for min, max and mean of an empty list
+func (acg *ARM64CodeGen) generateReduce(op string) error {
+	acg.eb.MarkFunction("_c67_" + op)
+
+	acg.out.LdrImm64Double("d0", "x0", 0)
+	acg.out.FcvtzsDoubleToInt64("x12", "d0")
+	acg.out.AddImm64("x9", "x0", 8)
+
+	emptyJump := -1
+	if op == "sum" || op == "mean" {
+		acg.out.out.writer.WriteBytes([]byte{0x02, 0xe4, 0x00, 0x6f}) // movi v2.2d, #0
+		if op == "mean" {
+			emptyJump = acg.eb.text.Len()
+			acg.out.CompareAndBranchZero64("x12", 0)
+		}
+	} else {
+		emptyJump = acg.eb.text.Len()
+		acg.out.CompareAndBranchZero64("x12", 0)
+		acg.out.LdrImm64Double("d2", "x9", 0)
+		acg.out.out.writer.WriteBytes([]byte{0x42, 0x04, 0x08, 0x4e}) // dup v2.2d, v2.d[0]
+	}
+
+	// Two values per iteration
+	pairLoop := acg.eb.text.Len()
+	acg.out.CmpImm64("x12", 2)
+	foldJump := acg.eb.text.Len()
+	acg.out.BranchCond("lt", 0)
+	acg.out.out.writer.WriteBytes([]byte{0x20, 0x7d, 0xdf, 0x4c}) // ld1 {v0.2d}, [x9], #16
+	switch op {
+	case "min":
+		acg.out.out.writer.WriteBytes([]byte{0x42, 0xf4, 0xe0, 0x4e}) // fmin v2.2d, v2.2d, v0.2d
+	case "max":
+		acg.out.out.writer.WriteBytes([]byte{0x42, 0xf4, 0x60, 0x4e}) // fmax v2.2d, v2.2d, v0.2d
+	default:
+		acg.out.out.writer.WriteBytes([]byte{0x42, 0xd4, 0x60, 0x4e}) // fadd v2.2d, v2.2d, v0.2d
+	}
+	acg.out.SubImm64("x12", "x12", 2)
+	acg.out.Branch(int32(pairLoop - acg.eb.text.Len()))
+
+	// Fold the lanes, then the odd value
+	foldPos := acg.eb.text.Len()
+	acg.patchJumpOffset(foldJump, int32(foldPos-foldJump))
+	switch op {
+	case "min":
+		acg.out.out.writer.WriteBytes([]byte{0x40, 0xf8, 0xf0, 0x7e}) // fminp d0, v2.2d
+	case "max":
+		acg.out.out.writer.WriteBytes([]byte{0x40, 0xf8, 0x70, 0x7e}) // fmaxp d0, v2.2d
+	default:
+		acg.out.out.writer.WriteBytes([]byte{0x40, 0xd8, 0x70, 0x7e}) // faddp d0, v2.2d
+	}
+	oddJump := acg.eb.text.Len()
+	acg.out.CompareAndBranchZero64("x12", 0)
+	acg.out.LdrImm64Double("d1", "x9", 0)
+	switch op {
+	case "min":
+		acg.out.out.writer.WriteBytes([]byte{0x00, 0x58, 0x61, 0x1e}) // fmin d0, d0, d1
+	case "max":
+		acg.out.out.writer.WriteBytes([]byte{0x00, 0x48, 0x61, 0x1e}) // fmax d0, d0, d1
+	default:
+		acg.out.FaddScalar64("d0", "d0", "d1")
+	}
+	acg.patchJumpOffset(oddJump, int32(acg.eb.text.Len()-oddJump))
+	if op == "mean" {
+		acg.out.LdrImm64Double("d1", "x0", 0)
+		acg.out.FdivScalar64("d0", "d0", "d1")
+	}
+	acg.out.Return("x30")
+
+	if emptyJump >= 0 {
+		acg.patchJumpOffset(emptyJump, int32(acg.eb.text.Len()-emptyJump))
+		if err := acg.out.MovImm64("x9", reduceArgError); err != nil {
+			return err
+		}
+		acg.out.out.writer.WriteBytes([]byte{0x20, 0x01, 0x67, 0x9e}) // fmov d0, x9
+		acg.out.Return("x30")
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/binary"
 	"os"
 	"path/filepath"
 	"testing"
@@ -24,6 +25,11 @@ func TestARM64BasicCompilation(t *testing.T) {
 			code:     "exit(42)",
 			wantText: 40,
 		},
+		{
+			name:     "paired_and_indexed_access",
+			code:     "f = (a, b, c) -> a * b + c\nxs = [1, 2] + [3]\nexit(f(2, 3, 4) + (2 in xs))",
+			wantText: 40,
+		},
 	}
 
 	for _, tt := range tests {
@@ -73,6 +79,76 @@ func TestARM64BasicCompilation(t *testing.T) {
 	}
 }
 
+// TestARM64IndexedAndPairedAccess checks the encodings of LDP/STP of D
+// registers and of the post-index and pre-index forms of LDR/STR
+func TestARM64IndexedAndPairedAccess(t *testing.T) {
+	eb, _ := New("arm64")
+	out := &ARM64Out{out: NewOut(eb.target, eb.TextWriter(), eb)}
+
+	out.StrPreIndex64Double("d0", "sp", -16)        // str d0, [sp, #-16]!
+	out.LdrPostIndex64Double("d0", "sp", 16)        // ldr d0, [sp], #16
+	out.LdrPostIndex64Double("d3", "x3", 8)         // ldr d3, [x3], #8
+	out.LdpPostIndex64Double("d0", "d1", "sp", 16)  // ldp d0, d1, [sp], #16
+	out.StpPostIndex64Double("d0", "d1", "x12", 16) // stp d0, d1, [x12], #16
+	out.StpImm64Double("d0", "d1", "x29", 16)       // stp d0, d1, [x29, #16]
+	out.LdpImm64("x1", "x0", "x29", 16)             // ldp x1, x0, [x29, #16]
+	want := []uint32{0xfc1f0fe0, 0xfc4107e0, 0xfc408463, 0x6cc107e0, 0x6c810580, 0x6d0107a0, 0xa94103a1}
+
+	text := eb.text.Bytes()
+	if len(text) != 4*len(want) {
+		t.Fatalf("Expected %d instructions, got %d bytes", len(want), len(text))
+	}
+	for i, w := range want {
+		if got := binary.LittleEndian.Uint32(text[4*i:]); got != w {
+			t.Errorf("Instruction %d: expected %08x, got %08x", i, w, got)
+		}
+	}
+
+	if err := out.LdrPostIndex64Double("d0", "sp", 256); err == nil {
+		t.Errorf("Expected an error for a post-index offset out of range")
+	}
+	if err := out.StpImm64Double("d0", "d1", "sp", 12); err == nil {
+		t.Errorf("Expected an error for an unaligned pair offset")
+	}
+}
+
+// TestARM64LambdaArgs tests that lambda arguments are evaluated straight into
+// d0-d7, and only go through the stack while later arguments are computed
+func TestARM64LambdaArgs(t *testing.T) {
+	eb, _ := New("arm64")
+	acg := NewARM64CodeGen(eb, nil)
+	acg.stackVars["k"] = 8
+	acg.stackVars["m"] = 16
+	acg.mutableVars["m"] = true
+
+	// f(m, 1, k, m): the mutable m is evaluated in order, 1 and k are loaded last
+	args := []Expression{&IdentExpr{Name: "m"}, &NumberExpr{Value: 1}, &IdentExpr{Name: "k"}, &IdentExpr{Name: "m"}}
+	if err := acg.compileLambdaArgs(args); err != nil {
+		t.Fatal(err)
+	}
+	want := []uint32{
+		0xfd400fa0, // ldr d0, [x29, #24]
+		0xfc1f0fe0, // str d0, [sp, #-16]!
+		0xfd400fa0, // ldr d0, [x29, #24]
+		0x1e604003, // fmov d3, d0
+		0xfc4107e0, // ldr d0, [sp], #16
+		0xd2800000, // movz x0, #0
+		0xf2e7fe00, // movk x0, #0x3ff0, lsl #48
+		0x9e670001, // fmov d1, x0
+		0xfd400ba2, // ldr d2, [x29, #16]
+	}
+
+	text := eb.text.Bytes()
+	if len(text) != 4*len(want) {
+		t.Fatalf("Expected %d instructions, got %d bytes", len(want), len(text))
+	}
+	for i, w := range want {
+		if got := binary.LittleEndian.Uint32(text[4*i:]); got != w {
+			t.Errorf("Instruction %d: expected %08x, got %08x", i, w, got)
+		}
+	}
+}
+
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > len(substr) && findSubstring(s, substr))
 }
@@ -0,0 +1,383 @@
+// Completion: 100% - Module complete
+package main
+
+import "fmt"
+
+// arm64_vecmath.go - NEON versions of the vector builtins (see vecmath.go)
+//
+// ARM64 lists store their values packed after the count:
+//
+//	[count][v0][v1][v2]...
+//
+// so two components load straight into one 128-bit register with ld1.
+
+// compileVectorExpr compiles vec2/vec3/vec4 into a list
+func (acg *ARM64CodeGen) compileVectorExpr(e *VectorExpr) error {
+	allConstant := true
+	for _, comp := range e.Components {
+		if _, ok := comp.(*NumberExpr); !ok {
+			allConstant = false
+			break
+		}
+	}
+	if allConstant {
+		return acg.compileExpression(&ListExpr{Elements: e.Components})
+	}
+
+	// malloc(8 + 8*n) and keep the pointer on the stack while components are compiled
+	if err := acg.out.MovImm64("x0", uint64(8+8*len(e.Components))); err != nil {
+		return err
+	}
+	if err := acg.eb.GenerateCallInstruction("malloc"); err != nil {
+		return err
+	}
+	acg.out.SubImm64("sp", "sp", 16)
+	acg.out.StrImm64("x0", "sp", 0)
+
+	for i, comp := range e.Components {
+		if err := acg.compileExpression(comp); err != nil {
+			return err
+		}
+		acg.out.LdrImm64("x9", "sp", 0)
+		acg.out.StrImm64Double("d0", "x9", int32(8+8*i))
+	}
+
+	// Count, then return the pointer in d0
+	acg.out.LdrImm64("x0", "sp", 0)
+	acg.out.AddImm64("sp", "sp", 16)
+	acg.out.MovImm64("x9", uint64(len(e.Components)))
+	acg.out.ScvtfInt64ToDouble("d1", "x9")
+	acg.out.StrImm64Double("d1", "x0", 0)
+	acg.out.out.writer.WriteBytes([]byte{0x00, 0x00, 0x62, 0x9e}) // scvtf d0, x0
+	return nil
+}
+
+// compileVectorCall compiles a call to one of the vector builtins
+func (acg *ARM64CodeGen) compileVectorCall(call *CallExpr) error {
+	if call.Function == "normalize" {
+		if len(call.Args) != 1 {
+			return fmt.Errorf("normalize() requires exactly 1 argument")
+		}
+	} else if len(call.Args) != 2 {
+		return fmt.Errorf("%s() requires exactly 2 arguments", call.Function)
+	}
+
+	if err := acg.compileExpression(call.Args[0]); err != nil {
+		return err
+	}
+	acg.out.out.writer.WriteBytes([]byte{0x00, 0x00, 0x78, 0x9e}) // fcvtzs x0, d0
+
+	if call.Function != "normalize" {
+		acg.out.SubImm64("sp", "sp", 16)
+		acg.out.StrImm64("x0", "sp", 0)
+		if err := acg.compileExpression(call.Args[1]); err != nil {
+			return err
+		}
+		if call.Function != "vscale" {
+			// The scale factor stays in d0, everything else is a list
+			acg.out.out.writer.WriteBytes([]byte{0x01, 0x00, 0x78, 0x9e}) // fcvtzs x1, d0
+		}
+		acg.out.LdrImm64("x0", "sp", 0)
+		acg.out.AddImm64("sp", "sp", 16)
+	}
+
+	helper := map[string]string{
+		"vadd": "_c67_vec_add", "vsub": "_c67_vec_sub",
+		"vmul": "_c67_vec_mul", "vdiv": "_c67_vec_div",
+		"vscale": "_c67_vec_scale", "dot": "_c67_vec_dot",
+		"cross": "_c67_vec_cross", "normalize": "_c67_vec_normalize",
+		"mat4mul": "_c67_mat4_mul",
+	}[call.Function]
+
+	acg.usesVecMath = true
+	if err := acg.eb.GenerateCallInstruction(helper); err != nil {
+		return err
+	}
+
+	// dot returns a number in d0, the others a list pointer in x0
+	if call.Function != "dot" {
+		acg.out.out.writer.WriteBytes([]byte{0x00, 0x00, 0x62, 0x9e}) // scvtf d0, x0
+	}
+	return nil
+}
+
+// generateVecMathHelpers generates the NEON runtime helpers behind the vector builtins
+func (acg *ARM64CodeGen) generateVecMathHelpers() error {
+	binops := []struct {
+		label  string
+		packed []byte
+		scalar func(dest, op1, op2 string) error
+	}{
+		{"_c67_vec_add", []byte{0x00, 0xd4, 0x61, 0x4e}, acg.out.FaddScalar64}, // fadd v0.2d, v0.2d, v1.2d
+		{"_c67_vec_sub", []byte{0x00, 0xd4, 0xe1, 0x4e}, acg.out.FsubScalar64}, // fsub v0.2d, v0.2d, v1.2d
+		{"_c67_vec_mul", []byte{0x00, 0xdc, 0x61, 0x6e}, acg.out.FmulScalar64}, // fmul v0.2d, v0.2d, v1.2d
+		{"_c67_vec_div", []byte{0x00, 0xfc, 0x61, 0x6e}, acg.out.FdivScalar64}, // fdiv v0.2d, v0.2d, v1.2d
+		{"_c67_vec_scale", []byte{0x00, 0xdc, 0x61, 0x6e}, acg.out.FmulScalar64},
+	}
+	for _, op := range binops {
+		if err := acg.generateVecBinop(op.label, op.packed, op.scalar); err != nil {
+			return err
+		}
+	}
+	acg.generateVecDot()
+	if err := acg.generateVecNormalize(); err != nil {
+		return err
+	}
+	if err := acg.generateVecCross(); err != nil {
+		return err
+	}
+	return acg.generateMat4Mul()
+}
+
+// emitVecFramePush saves x29, x30 and x19-x22 (48 bytes)
+func (acg *ARM64CodeGen) emitVecFramePush() {
+	acg.out.out.writer.WriteBytes([]byte{0xfd, 0x7b, 0xbd, 0xa9}) // stp x29, x30, [sp, #-48]!
+	acg.out.out.writer.WriteBytes([]byte{0xf3, 0x53, 0x01, 0xa9}) // stp x19, x20, [sp, #16]
+	acg.out.out.writer.WriteBytes([]byte{0xf5, 0x5b, 0x02, 0xa9}) // stp x21, x22, [sp, #32]
+	acg.out.out.writer.WriteBytes([]byte{0xfd, 0x03, 0x00, 0x91}) // mov x29, sp
+}
+
+// emitVecFramePop restores the registers saved by emitVecFramePush and returns x22
+func (acg *ARM64CodeGen) emitVecFramePop() {
+	acg.out.MovReg64("x0", "x22")
+	acg.out.out.writer.WriteBytes([]byte{0xf5, 0x5b, 0x42, 0xa9}) // ldp x21, x22, [sp, #32]
+	acg.out.out.writer.WriteBytes([]byte{0xf3, 0x53, 0x41, 0xa9}) // ldp x19, x20, [sp, #16]
+	acg.out.out.writer.WriteBytes([]byte{0xfd, 0x7b, 0xc3, 0xa8}) // ldp x29, x30, [sp], #48
+	acg.out.Return("x30")
+}
+
+// emitVecAllocResult allocates a list with x21 components into x22 and writes its count
+func (acg *ARM64CodeGen) emitVecAllocResult() error {
+	acg.out.out.writer.WriteBytes([]byte{0xa0, 0xf2, 0x7d, 0xd3}) // lsl x0, x21, #3
+	acg.out.AddImm64("x0", "x0", 8)
+	if err := acg.eb.GenerateCallInstruction("malloc"); err != nil {
+		return err
+	}
+	acg.out.MovReg64("x22", "x0")
+	acg.out.ScvtfInt64ToDouble("d1", "x21")
+	return acg.out.StrImm64Double("d1", "x22", 0)
+}
+
+// generateVecBinop generates label(a, b) -> new list with a[i] op b[i]
+// Arguments: x0 = a, x1 = b (for _c67_vec_scale: x0 = v, d0 = scale)
+// Returns: x0 = new list
+func (acg *ARM64CodeGen) generateVecBinop(label string, packedOp []byte, scalarOp func(dest, op1, op2 string) error) error {
+	scale := label == "_c67_vec_scale"
+
+	acg.eb.MarkFunction(label)
+	acg.emitVecFramePush()
+
+	acg.out.MovReg64("x19", "x0")
+	acg.out.LdrImm64Double("d1", "x19", 0)
+	acg.out.FcvtzsDoubleToInt64("x21", "d1")
+	if scale {
+		acg.out.out.writer.WriteBytes([]byte{0x14, 0x00, 0x66, 0x9e}) // fmov x20, d0 (keep scale across malloc)
+	} else {
+		// Use the shorter length
+		acg.out.MovReg64("x20", "x1")
+		acg.out.LdrImm64Double("d1", "x20", 0)
+		acg.out.FcvtzsDoubleToInt64("x9", "d1")
+		acg.out.CmpReg64("x21", "x9")
+		acg.out.out.writer.WriteBytes([]byte{0xb5, 0xd2, 0x89, 0x9a}) // csel x21, x21, x9, le
+	}
+
+	if err := acg.emitVecAllocResult(); err != nil {
+		return err
+	}
+
+	// x9 = a values, x10 = b values, x11 = result values, x12 = components left
+	acg.out.AddImm64("x9", "x19", 8)
+	if scale {
+		acg.out.out.writer.WriteBytes([]byte{0x81, 0x02, 0x67, 0x9e}) // fmov d1, x20
+		acg.out.out.writer.WriteBytes([]byte{0x21, 0x04, 0x08, 0x4e}) // dup v1.2d, v1.d[0]
+	} else {
+		acg.out.AddImm64("x10", "x20", 8)
+	}
+	acg.out.AddImm64("x11", "x22", 8)
+	acg.out.MovReg64("x12", "x21")
+
+	// Two components per iteration
+	pairLoop := acg.eb.text.Len()
+	acg.out.CmpImm64("x12", 2)
+	tailJump := acg.eb.text.Len()
+	acg.out.BranchCond("lt", 0)
+	acg.out.out.writer.WriteBytes([]byte{0x20, 0x7d, 0xdf, 0x4c}) // ld1 {v0.2d}, [x9], #16
+	if !scale {
+		acg.out.out.writer.WriteBytes([]byte{0x41, 0x7d, 0xdf, 0x4c}) // ld1 {v1.2d}, [x10], #16
+	}
+	acg.out.out.writer.WriteBytes(packedOp)
+	acg.out.out.writer.WriteBytes([]byte{0x60, 0x7d, 0x9f, 0x4c}) // st1 {v0.2d}, [x11], #16
+	acg.out.SubImm64("x12", "x12", 2)
+	acg.out.Branch(int32(pairLoop - acg.eb.text.Len()))
+
+	// Odd component left over
+	tailPos := acg.eb.text.Len()
+	acg.patchJumpOffset(tailJump, int32(tailPos-tailJump))
+	doneJump := acg.eb.text.Len()
+	acg.out.CompareAndBranchZero64("x12", 0)
+	acg.out.LdrImm64Double("d0", "x9", 0)
+	if !scale {
+		acg.out.LdrImm64Double("d1", "x10", 0)
+	}
+	if err := scalarOp("d0", "d0", "d1"); err != nil {
+		return err
+	}
+	acg.out.StrImm64Double("d0", "x11", 0)
+	acg.patchJumpOffset(doneJump, int32(acg.eb.text.Len()-doneJump))
+
+	acg.emitVecFramePop()
+	return nil
+}
+
+// generateVecDot generates _c67_vec_dot(a, b) -> number
+// Arguments: x0 = a, x1 = b
+// Returns: d0 = sum of a[i] * b[i] over the shorter length
+// Leaf function: clobbers only x9, x10, x12, x13 and v0-v3.
+func (acg *ARM64CodeGen) generateVecDot() {
+	acg.eb.MarkFunction("_c67_vec_dot")
+
+	acg.out.LdrImm64Double("d0", "x0", 0)
+	acg.out.FcvtzsDoubleToInt64("x12", "d0")
+	acg.out.LdrImm64Double("d0", "x1", 0)
+	acg.out.FcvtzsDoubleToInt64("x13", "d0")
+	acg.out.CmpReg64("x12", "x13")
+	acg.out.out.writer.WriteBytes([]byte{0x8c, 0xd1, 0x8d, 0x9a}) // csel x12, x12, x13, le
+
+	acg.out.AddImm64("x9", "x0", 8)
+	acg.out.AddImm64("x10", "x1", 8)
+	acg.out.out.writer.WriteBytes([]byte{0x02, 0xe4, 0x00, 0x6f}) // movi v2.2d, #0
+
+	pairLoop := acg.eb.text.Len()
+	acg.out.CmpImm64("x12", 2)
+	sumJump := acg.eb.text.Len()
+	acg.out.BranchCond("lt", 0)
+	acg.out.out.writer.WriteBytes([]byte{0x20, 0x7d, 0xdf, 0x4c}) // ld1 {v0.2d}, [x9], #16
+	acg.out.out.writer.WriteBytes([]byte{0x41, 0x7d, 0xdf, 0x4c}) // ld1 {v1.2d}, [x10], #16
+	acg.out.out.writer.WriteBytes([]byte{0x02, 0xcc, 0x61, 0x4e}) // fmla v2.2d, v0.2d, v1.2d
+	acg.out.SubImm64("x12", "x12", 2)
+	acg.out.Branch(int32(pairLoop - acg.eb.text.Len()))
+
+	// Horizontal sum, then the odd component
+	sumPos := acg.eb.text.Len()
+	acg.patchJumpOffset(sumJump, int32(sumPos-sumJump))
+	acg.out.out.writer.WriteBytes([]byte{0x40, 0xd8, 0x70, 0x7e}) // faddp d0, v2.2d
+	retJump := acg.eb.text.Len()
+	acg.out.CompareAndBranchZero64("x12", 0)
+	acg.out.LdrImm64Double("d1", "x9", 0)
+	acg.out.LdrImm64Double("d3", "x10", 0)
+	acg.out.out.writer.WriteBytes([]byte{0x20, 0x00, 0x43, 0x1f}) // fmadd d0, d1, d3, d0
+	acg.patchJumpOffset(retJump, int32(acg.eb.text.Len()-retJump))
+	acg.out.Return("x30")
+}
+
+// generateVecNormalize generates _c67_vec_normalize(v) -> new list
+// Arguments: x0 = v
+// Returns: x0 = v scaled to length 1, or all zeros if v has length 0
+func (acg *ARM64CodeGen) generateVecNormalize() error {
+	acg.eb.MarkFunction("_c67_vec_normalize")
+	acg.emitVecFramePush()
+
+	acg.out.MovReg64("x19", "x0")
+	acg.out.MovReg64("x1", "x0")
+	if err := acg.eb.GenerateCallInstruction("_c67_vec_dot"); err != nil {
+		return err
+	}
+	acg.out.FsqrtScalar64("d0", "d0")
+
+	// Scale by 1/length, or by 0 for the zero vector
+	acg.out.out.writer.WriteBytes([]byte{0x08, 0x20, 0x60, 0x1e}) // fcmp d0, #0.0
+	zeroJump := acg.eb.text.Len()
+	acg.out.BranchCond("eq", 0)
+	acg.out.out.writer.WriteBytes([]byte{0x01, 0x10, 0x6e, 0x1e}) // fmov d1, #1.0
+	acg.out.FdivScalar64("d0", "d1", "d0")
+	scaleJump := acg.eb.text.Len()
+	acg.out.Branch(0)
+	zeroPos := acg.eb.text.Len()
+	acg.patchJumpOffset(zeroJump, int32(zeroPos-zeroJump))
+	acg.out.out.writer.WriteBytes([]byte{0xe0, 0x03, 0x67, 0x9e}) // fmov d0, xzr
+	acg.patchJumpOffset(scaleJump, int32(acg.eb.text.Len()-scaleJump))
+
+	acg.out.MovReg64("x0", "x19")
+	if err := acg.eb.GenerateCallInstruction("_c67_vec_scale"); err != nil {
+		return err
+	}
+	acg.out.MovReg64("x22", "x0")
+	acg.emitVecFramePop()
+	return nil
+}
+
+// generateVecCross generates _c67_vec_cross(a, b) -> new vec3
+// Arguments: x0 = a, x1 = b (first three components are used)
+// Returns: x0 = [a1*b2 - a2*b1, a2*b0 - a0*b2, a0*b1 - a1*b0]
+func (acg *ARM64CodeGen) generateVecCross() error {
+	acg.eb.MarkFunction("_c67_vec_cross")
+	acg.emitVecFramePush()
+
+	acg.out.MovReg64("x19", "x0")
+	acg.out.MovReg64("x20", "x1")
+	acg.out.MovImm64("x21", 3)
+	if err := acg.emitVecAllocResult(); err != nil {
+		return err
+	}
+
+	// d0-d2 = a, d3-d5 = b
+	for i := 0; i < 3; i++ {
+		acg.out.LdrImm64Double(fmt.Sprintf("d%d", i), "x19", int32(8+8*i))
+		acg.out.LdrImm64Double(fmt.Sprintf("d%d", i+3), "x20", int32(8+8*i))
+	}
+	acg.out.out.writer.WriteBytes([]byte{0x26, 0x08, 0x65, 0x1e}) // fmul d6, d1, d5
+	acg.out.out.writer.WriteBytes([]byte{0x46, 0x98, 0x44, 0x1f}) // fmsub d6, d2, d4, d6
+	acg.out.out.writer.WriteBytes([]byte{0x47, 0x08, 0x63, 0x1e}) // fmul d7, d2, d3
+	acg.out.out.writer.WriteBytes([]byte{0x07, 0x9c, 0x45, 0x1f}) // fmsub d7, d0, d5, d7
+	acg.out.out.writer.WriteBytes([]byte{0x10, 0x08, 0x64, 0x1e}) // fmul d16, d0, d4
+	acg.out.out.writer.WriteBytes([]byte{0x30, 0xc0, 0x43, 0x1f}) // fmsub d16, d1, d3, d16
+	acg.out.StrImm64Double("d6", "x22", 8)
+	acg.out.StrImm64Double("d7", "x22", 16)
+	acg.out.StrImm64Double("d16", "x22", 24)
+
+	acg.emitVecFramePop()
+	return nil
+}
+
+// generateMat4Mul generates _c67_mat4_mul(a, b) -> new 16-element list
+// Arguments: x0 = a, x1 = b (row-major 4x4 matrices)
+// Returns: x0 = a * b
+// Each result row is accumulated in v4 (columns 0-1) and v5 (columns 2-3)
+// with fmla by element: row += a[row][k] * row k of b.
+func (acg *ARM64CodeGen) generateMat4Mul() error {
+	acg.eb.MarkFunction("_c67_mat4_mul")
+	acg.emitVecFramePush()
+
+	acg.out.MovReg64("x19", "x0")
+	acg.out.MovReg64("x20", "x1")
+	acg.out.MovImm64("x21", 16)
+	if err := acg.emitVecAllocResult(); err != nil {
+		return err
+	}
+
+	// x9 = a[row][k], x11 = result row, x12 = rows left
+	acg.out.AddImm64("x9", "x19", 8)
+	acg.out.AddImm64("x11", "x22", 8)
+	acg.out.MovImm64("x12", 4)
+
+	rowLoop := acg.eb.text.Len()
+	acg.out.out.writer.WriteBytes([]byte{0x04, 0xe4, 0x00, 0x6f}) // movi v4.2d, #0
+	acg.out.out.writer.WriteBytes([]byte{0x05, 0xe4, 0x00, 0x6f}) // movi v5.2d, #0
+	acg.out.AddImm64("x10", "x20", 8)                             // x10 = row k of b
+	acg.out.MovImm64("x13", 4)
+
+	kLoop := acg.eb.text.Len()
+	acg.out.out.writer.WriteBytes([]byte{0x20, 0x85, 0x40, 0xfc}) // ldr d0, [x9], #8
+	acg.out.out.writer.WriteBytes([]byte{0x46, 0xad, 0xdf, 0x4c}) // ld1 {v6.2d, v7.2d}, [x10], #32
+	acg.out.out.writer.WriteBytes([]byte{0xc4, 0x10, 0xc0, 0x4f}) // fmla v4.2d, v6.2d, v0.d[0]
+	acg.out.out.writer.WriteBytes([]byte{0xe5, 0x10, 0xc0, 0x4f}) // fmla v5.2d, v7.2d, v0.d[0]
+	acg.out.out.writer.WriteBytes([]byte{0xad, 0x05, 0x00, 0xf1}) // subs x13, x13, #1
+	acg.out.BranchCond("ne", int32(kLoop-acg.eb.text.Len()))
+
+	acg.out.out.writer.WriteBytes([]byte{0x64, 0xad, 0x9f, 0x4c}) // st1 {v4.2d, v5.2d}, [x11], #32
+	acg.out.out.writer.WriteBytes([]byte{0x8c, 0x05, 0x00, 0xf1}) // subs x12, x12, #1
+	acg.out.BranchCond("ne", int32(rowLoop-acg.eb.text.Len()))
+
+	acg.emitVecFramePop()
+	return nil
+}
@@ -16,6 +16,7 @@ type Program struct {
 	ExportMode         string            // "*" for export all without prefix, "" for require prefix
 	ExportedFuncs      []string          // Specific functions to export (only if ExportMode is not "*")
 	FunctionNamespaces map[string]string // function name -> namespace (for imports)
+	NamespaceAliases   map[string]string // alias -> namespace of a file imported under both
 }
 
 func (p *Program) String() string {
@@ -40,6 +41,8 @@ type AssignStmt struct {
 	IsReuseMutable bool     // true when = is used to update existing mutable variable
 	Precision      string   // Legacy type annotation: "b64", "f32", etc. (empty if none)
 	TypeAnnotation *C67Type // Type annotation: num, str, cstring, cptr, etc. (nil if none)
+	Doc            string   // "## text" doc comment above a module-level assignment
+	Inline         string   // "inline" or "noinline" before the definition (empty if none)
 }
 
 type MultipleAssignStmt struct {
@@ -70,6 +73,9 @@ func (a *AssignStmt) String() string {
 		op = ":="
 	}
 	result := a.Name
+	if a.Inline != "" {
+		result = a.Inline + " " + result
+	}
 	if a.Precision != "" {
 		result += ":" + a.Precision
 	}
@@ -91,6 +97,17 @@ func (m *MapUpdateStmt) String() string {
 }
 func (m *MapUpdateStmt) statementNode() {}
 
+// SliceUpdateStmt copies a list into a range of a mutable list: xs[2:5] <- other
+type SliceUpdateStmt struct {
+	Target *SliceExpr // Destination range, Target.List is the list variable
+	Value  Expression // List whose elements are copied into the range
+}
+
+func (s *SliceUpdateStmt) String() string {
+	return fmt.Sprintf("%s <- %s", s.Target.String(), s.Value.String())
+}
+func (s *SliceUpdateStmt) statementNode() {}
+
 type UseStmt struct {
 	Path string // Import path: "./file.c67" or "package_name"
 }
@@ -155,6 +172,7 @@ type CStructDecl struct {
 	Packed bool           // true if #[packed] - no padding
 	Align  int            // Custom alignment (0 = natural alignment)
 	Size   int            // Total struct size in bytes (calculated)
+	Doc    string         // "## text" doc comment above the declaration
 }
 
 func (c *CStructDecl) String() string {
@@ -261,6 +279,7 @@ func (e *ExpressionStmt) statementNode() {}
 type LoopStmt struct {
 	// No explicit label - determined by nesting depth when created with @
 	Iterator      string     // Variable name (e.g., "i")
+	KeyIterator   string     // Key variable in @ k, v in m loops, empty otherwise
 	Iterable      Expression // Expression to iterate over (e.g., range(10))
 	Body          []Statement
 	MaxIterations int64       // Maximum allowed iterations (math.MaxInt64 for infinite)
@@ -268,6 +287,7 @@ type LoopStmt struct {
 	BaseOffset    int         // Stack offset before loop body (set during collectSymbols)
 	NumThreads    int         // Number of threads for parallel execution (0 = sequential, -1 = all cores, N = specific count)
 	Reducer       *LambdaExpr // Optional reduction lambda for parallel loops: | a,b | { a + b }
+	Unroll        int         // Copies of the body per jump back, from unroll N (0 = not given, see unroll.go)
 }
 
 type WhileStmt struct {
@@ -324,6 +344,10 @@ func (l *LoopStmt) String() string {
 	} else {
 		out.WriteString("@ ")
 	}
+	if l.KeyIterator != "" {
+		out.WriteString(l.KeyIterator)
+		out.WriteString(", ")
+	}
 	out.WriteString(l.Iterator)
 	out.WriteString(" in ")
 	out.WriteString(l.Iterable.String())
@@ -398,12 +422,21 @@ func (s *StringExpr) expressionNode() {}
 // Parts alternates between string literals and expressions
 // Example: f"Hello {name}" -> Parts = [StringExpr("Hello "), IdentExpr("name")]
 type FStringExpr struct {
-	Parts []Expression // Alternating string literals and expressions
+	Parts     []Expression // Alternating string literals and expressions
+	Precision []int        // Digits after the point per part from {x:.N}, -1 = shortest
 }
 
 func (f *FStringExpr) String() string  { return "f\"...\"" }
 func (f *FStringExpr) expressionNode() {}
 
+// PrecisionOf returns the precision specifier of part i, or -1 if it has none
+func (f *FStringExpr) PrecisionOf(i int) int {
+	if i < len(f.Precision) {
+		return f.Precision[i]
+	}
+	return -1
+}
+
 type AddressLiteralExpr struct {
 	Value string // The full address like "&8080" or "&localhost:8080"
 }
@@ -469,7 +502,8 @@ func (b *BinaryExpr) String() string {
 func (b *BinaryExpr) expressionNode() {}
 
 // FMAExpr represents a Fused Multiply-Add pattern: a * b + c or a * b - c
-// This is recognized by the optimizer and can be compiled to FMA instructions (VFMADD/VFMSUB)
+// Created by the optimizer for (a * b) + c and by the parser for a *+ b + c; always compiled
+// to a scalar FMA instruction (VFMADD/VFMSUB, FMADD/FNMSUB, FMADD.D/FMSUB.D)
 type FMAExpr struct {
 	A        Expression // First multiplicand
 	B        Expression // Second multiplicand
@@ -519,6 +553,16 @@ func (m *MoveExpr) String() string {
 }
 func (m *MoveExpr) expressionNode() {}
 
+// TryExpr: f(x)! (returns an error result to the caller, otherwise yields the value)
+type TryExpr struct {
+	Expr Expression // The call whose result is checked
+}
+
+func (t *TryExpr) String() string {
+	return t.Expr.String() + "!"
+}
+func (t *TryExpr) expressionNode() {}
+
 type InExpr struct {
 	Value     Expression // Value to search for
 	Container Expression // List or map to search in
@@ -567,7 +611,8 @@ func (m *MatchExpr) String() string {
 func (m *MatchExpr) expressionNode() {}
 
 type BlockExpr struct {
-	Statements []Statement
+	Statements    []Statement
+	Comprehension bool // Desugared comprehension: evaluated in place, never inferred as a lambda
 }
 
 func (b *BlockExpr) String() string {
@@ -682,17 +727,52 @@ func (s *SliceExpr) expressionNode() {}
 
 // RangeExpr represents a range like 0..<10 or 0..=10
 type RangeExpr struct {
-	Start     Expression
-	End       Expression
-	Inclusive bool // true for ..=, false for ..<
+	Start      Expression
+	End        Expression
+	Step       int64 // Constant step from a 'by' clause, 0 for the default step
+	Inclusive  bool  // true for ..=, false for ..< and ..>
+	Descending bool  // true for ..> or a negative step
 }
 
 func (r *RangeExpr) String() string {
 	op := "..<"
 	if r.Inclusive {
 		op = "..="
+	} else if r.Descending {
+		op = "..>"
+	}
+	s := r.Start.String() + op + r.End.String()
+	if r.Step != 0 {
+		s += fmt.Sprintf(" by %d", r.Step)
 	}
-	return r.Start.String() + op + r.End.String()
+	return s
+}
+
+// StepValue returns the amount the loop counter changes by per iteration
+func (r *RangeExpr) StepValue() int64 {
+	if r.Step != 0 {
+		return r.Step
+	}
+	if r.Descending {
+		return -1
+	}
+	return 1
+}
+
+// TripCount returns how many iterations the range takes between start and end
+func (r *RangeExpr) TripCount(start, end int64) int64 {
+	step := r.StepValue()
+	span := end - start
+	if step < 0 {
+		span, step = -span, -step
+	}
+	if span < 0 || (span == 0 && !r.Inclusive) {
+		return 0
+	}
+	if r.Inclusive {
+		return span/step + 1
+	}
+	return (span + step - 1) / step
 }
 func (r *RangeExpr) expressionNode() {}
 
@@ -1049,10 +1129,10 @@ func (a *ArenaExpr) String() string {
 }
 func (a *ArenaExpr) expressionNode() {}
 
-// VectorExpr represents a SIMD vector literal: vec2(x, y) or vec4(x, y, z, w)
+// VectorExpr represents a SIMD vector literal: vec2(x, y), vec3(x, y, z) or vec4(x, y, z, w)
 type VectorExpr struct {
-	Components []Expression // 2 or 4 components
-	Size       int          // 2 or 4
+	Components []Expression // 2, 3 or 4 components
+	Size       int          // 2, 3 or 4
 }
 
 func (v *VectorExpr) String() string {
@@ -1112,3 +1192,16 @@ type AliasStmt struct {
 
 func (a *AliasStmt) String() string { return "alias " + a.NewName + "=" + a.TargetName }
 func (a *AliasStmt) statementNode() {}
+
+// MacroDecl represents a macro definition: macro times(n, body) { ... }
+// Macros are expanded right after parsing (see macros.go)
+type MacroDecl struct {
+	Name   string
+	Params []string
+	Body   []Statement
+}
+
+func (m *MacroDecl) String() string {
+	return "macro " + m.Name + "(" + strings.Join(m.Params, ", ") + ") { ... }"
+}
+func (m *MacroDecl) statementNode() {}
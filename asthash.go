@@ -0,0 +1,142 @@
+// Completion: 100% - c67 hash
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+)
+
+// asthash.go - Canonical hashes of syntax trees
+//
+// hashAST hashes the structure and the literals of a node: the type of
+// every node, its fields in declaration order and their values, with map
+// entries sorted by key. The parser keeps no positions in the tree, so
+// whitespace, comments and layout do not change the hash; doc comments are
+// left out as well. Two files with the same hash compile to the same
+// program, which is what a build cache needs to know, and a golden test of
+// the optimizer can compare hashes instead of printed trees.
+//
+// `c67 hash file.c67...` prints the hash of each file as parsed, so after
+// macro expansion and the optimizations of the parser, in the format of
+// sha256sum.
+
+// astHashIgnored are the fields that do not change what a program means
+var astHashIgnored = map[string]bool{"Doc": true}
+
+// hashAST returns the canonical hash of a node, as hex
+func hashAST(node any) string {
+	h := &astHasher{h: sha256.New(), seen: make(map[uintptr]bool)}
+	h.value(reflect.ValueOf(node))
+	return hex.EncodeToString(h.h.Sum(nil))
+}
+
+// astHasher writes the canonical encoding of a tree to a hash
+type astHasher struct {
+	h    hash.Hash
+	seen map[uintptr]bool // pointers on the path, so a cycle ends
+}
+
+func (a *astHasher) str(s string) {
+	a.num(uint64(len(s)))
+	a.h.Write([]byte(s))
+}
+
+func (a *astHasher) num(n uint64) {
+	a.h.Write(binary.LittleEndian.AppendUint64(nil, n))
+}
+
+func (a *astHasher) value(v reflect.Value) {
+	if !v.IsValid() {
+		a.str("nil")
+		return
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			a.str("nil")
+			return
+		}
+		if a.seen[v.Pointer()] {
+			a.str("cycle")
+			return
+		}
+		a.seen[v.Pointer()] = true
+		a.value(v.Elem())
+		delete(a.seen, v.Pointer())
+	case reflect.Interface:
+		if v.IsNil() {
+			a.str("nil")
+			return
+		}
+		a.value(v.Elem())
+	case reflect.Struct:
+		t := v.Type()
+		a.str(t.Name())
+		for i := 0; i < v.NumField(); i++ {
+			if astHashIgnored[t.Field(i).Name] {
+				continue
+			}
+			a.str(t.Field(i).Name)
+			a.value(v.Field(i))
+		}
+	case reflect.Slice, reflect.Array:
+		a.num(uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			a.value(v.Index(i))
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+		a.num(uint64(len(keys)))
+		for _, key := range keys {
+			a.value(key)
+			a.value(v.MapIndex(key))
+		}
+	case reflect.String:
+		a.str(v.String())
+	case reflect.Bool:
+		if v.Bool() {
+			a.num(1)
+		} else {
+			a.num(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		a.num(uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		a.num(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		a.num(math.Float64bits(v.Float()))
+	default:
+		// Functions and channels are not part of a tree
+		a.str(v.Kind().String())
+	}
+}
+
+// cmdHash prints the canonical hash of each file
+func cmdHash(ctx *CommandContext, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: c67 hash <file.c67>...")
+	}
+	for _, filename := range args {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		usedFiles = newUseState()
+		program, err := Parse(string(content))
+		if err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+		fmt.Printf("%s  %s\n", hashAST(program), filename)
+	}
+	return nil
+}
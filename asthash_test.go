@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestHashASTIgnoresFormatting(t *testing.T) {
+	hashOf := func(src string) string {
+		t.Helper()
+		program, err := Parse(src)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", src, err)
+		}
+		return hashAST(program)
+	}
+
+	base := hashOf("## adds\nadd = (a, b) -> a + b\nprintln(add(1, 2))\n")
+	tests := []struct {
+		src  string
+		same bool
+	}{
+		{"add = (a,b)->a+b\n\n// a comment\nprintln(add(1,2))   // trailing\n", true},
+		{"add = (a, b) -> a + b\nprintln(add(1, 2))", true},
+		{"add = (a, b) -> a - b\nprintln(add(1, 2))\n", false},
+		{"add = (a, b) -> a + b\nprintln(add(1, 3))\n", false},
+		{"plus = (a, b) -> a + b\nprintln(plus(1, 2))\n", false},
+	}
+	for _, tt := range tests {
+		if got := hashOf(tt.src) == base; got != tt.same {
+			t.Errorf("same hash = %v, expected %v for %q", got, tt.same, tt.src)
+		}
+	}
+
+	src := "m := {name: \"Ada\", age: 36}\n@ k, v in m { println(v) }\n"
+	if hashOf(src) != hashOf(src) {
+		t.Error("hash is not deterministic")
+	}
+}
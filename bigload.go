@@ -0,0 +1,282 @@
+// Completion: 100% - bigload() for file-backed numeric lists
+package main
+
+import "fmt"
+
+// bigload.go - Large numeric datasets
+//
+// bigload(path) maps a file of raw little-endian float64s with mmap and
+// returns them as a list. bigload(path, "lines") reads one number per line
+// instead, skipping lines that do not start with a number.
+//
+// The file is mapped read-only and walked front to back with
+// MADV_SEQUENTIAL, so the kernel pages it in on demand and can drop pages
+// that have been read. The list itself lives in its own MAP_NORESERVE
+// anonymous mapping rather than in an arena: its pages are only committed
+// as they are written, arena growth never has to copy it, and it stays
+// valid until the program exits. A file that cannot be opened or mapped
+// gives an empty list.
+
+// mmap flags for the list mapping: MAP_PRIVATE|MAP_ANONYMOUS|MAP_NORESERVE
+const (
+	bigloadMapFlagsLinux  = 0x2 | 0x20 | 0x4000
+	bigloadMapFlagsDarwin = 0x2 | 0x1000 | 0x40
+)
+
+// bigloadLineMax is the longest line prefix handed to strtod
+const bigloadLineMax = 63
+
+// compileBigloadCall compiles bigload(path) and bigload(path, "lines")
+func (fc *C67Compiler) compileBigloadCall(call *CallExpr) {
+	if len(call.Args) != 1 && len(call.Args) != 2 {
+		compilerError("bigload() requires 1 or 2 arguments (path, optional format)")
+	}
+	if fc.eb.target.OS() != OSLinux && fc.eb.target.OS() != OSDarwin {
+		compilerError("bigload() is only supported on Linux and macOS")
+	}
+	lines := false
+	if len(call.Args) == 2 {
+		format, ok := call.Args[1].(*StringExpr)
+		if !ok || (format.Value != "lines" && format.Value != "f64") {
+			compilerError("bigload() format must be the string \"lines\" or \"f64\"")
+		}
+		lines = format.Value == "lines"
+	}
+	for _, name := range []string{"open", "lseek", "close", "mmap", "munmap", "madvise", "strtod"} {
+		fc.trackFunctionCall(name)
+	}
+	fc.usesBigload = true
+
+	fc.compileExpression(call.Args[0])
+	fc.out.CallSymbol("c67_string_to_cstr")
+	fc.out.MovRegToReg("rdi", "rax")
+	if lines {
+		fc.out.MovImmToReg("rsi", "1")
+	} else {
+		fc.out.XorRegWithReg("rsi", "rsi")
+	}
+	fc.out.SubImmFromReg("rsp", StackSlotSize)
+	fc.out.CallSymbol("_c67_bigload")
+	fc.out.AddImmToReg("rsp", StackSlotSize)
+	fc.out.MovqRegToXmm("xmm0", "rax")
+}
+
+// emitBigloadCall calls the libc function name with al cleared, as
+// variadic functions such as open expect
+func (fc *C67Compiler) emitBigloadCall(name string) {
+	fc.out.XorRegWithReg("rax", "rax")
+	fc.eb.GenerateCallInstruction(name)
+}
+
+// generateBigloadHelper emits _c67_bigload(rdi=path, rsi=1 for lines) ->
+// rax, the list described at the top of this file
+func (fc *C67Compiler) generateBigloadHelper() {
+	fc.eb.MarkFunction("_c67_bigload")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+	fc.out.PushReg("r15")
+	// [rsp] = fd, [rsp+8] = strtod end, [rsp+16] = line buffer,
+	// [rsp+80] = lines flag, [rsp+88] = end of the current line
+	fc.out.SubImmFromReg("rsp", 104)
+	fc.out.AndRegWithImm("rsp", -16)
+	fc.out.MovRegToMem("rsi", "rsp", 80)
+
+	// open(path, O_RDONLY)
+	fc.out.XorRegWithReg("rsi", "rsi")
+	fc.emitBigloadCall("open")
+	fc.out.Emit([]byte{0x48, 0x63, 0xc0}) // movsxd rax, eax
+	fc.out.TestRegReg("rax", "rax")
+	openFailed := fc.forwardJump(JumpLess)
+	fc.out.MovRegToMem("rax", "rsp", 0)
+
+	// r13 = size, from lseek(fd, 0, SEEK_END)
+	fc.out.MovRegToReg("rdi", "rax")
+	fc.out.XorRegWithReg("rsi", "rsi")
+	fc.out.MovImmToReg("rdx", "2")
+	fc.emitBigloadCall("lseek")
+	fc.out.MovRegToReg("r13", "rax")
+	fc.out.TestRegReg("r13", "r13")
+	emptyFile := fc.forwardJump(JumpLessOrEqual)
+
+	// r12 = mmap(NULL, size, PROT_READ, MAP_PRIVATE, fd, 0)
+	fc.out.XorRegWithReg("rdi", "rdi")
+	fc.out.MovRegToReg("rsi", "r13")
+	fc.out.MovImmToReg("rdx", "1")
+	fc.out.MovImmToReg("rcx", "2")
+	fc.out.MovMemToReg("r8", "rsp", 0)
+	fc.out.XorRegWithReg("r9", "r9")
+	fc.emitBigloadCall("mmap")
+	fc.out.MovRegToReg("r12", "rax")
+	fc.out.CmpRegToImm("r12", -1)
+	mapFailed := fc.forwardJump(JumpEqual)
+
+	// The mapping keeps the file alive
+	fc.out.MovMemToReg("rdi", "rsp", 0)
+	fc.emitBigloadCall("close")
+	fc.out.MovRegToReg("rdi", "r12")
+	fc.out.MovRegToReg("rsi", "r13")
+	fc.out.MovImmToReg("rdx", "2") // MADV_SEQUENTIAL
+	fc.emitBigloadCall("madvise")
+
+	// r14 = most entries the file can hold: size / 8 for raw float64s,
+	// newlines + 1 for lines
+	fc.out.MovMemToReg("rax", "rsp", 80)
+	fc.out.TestRegReg("rax", "rax")
+	countLines := fc.forwardJump(JumpNotEqual)
+	fc.out.MovRegToReg("r14", "r13")
+	fc.out.ShrRegByImm("r14", 3)
+	counted := fc.forwardJumpAlways()
+	fc.landForwardJump(countLines)
+	fc.out.MovImmToReg("r14", "1")
+	fc.out.MovRegToReg("rcx", "r12")
+	fc.out.MovRegToReg("rdx", "r12")
+	fc.out.AddRegToReg("rdx", "r13")
+	scanStart := fc.eb.text.Len()
+	fc.out.CmpRegToReg("rcx", "rdx")
+	scanDone := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovU8MemToReg("rax", "rcx", 0)
+	fc.out.CmpRegToImm("rax", '\n')
+	notNewline := fc.forwardJump(JumpNotEqual)
+	fc.out.IncReg("r14")
+	fc.landForwardJump(notNewline)
+	fc.out.IncReg("rcx")
+	fc.out.JumpUnconditional(int32(scanStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(scanDone)
+	fc.landForwardJump(counted)
+
+	// rbx = mmap(NULL, 8 + r14 * 16, PROT_READ|PROT_WRITE, flags, -1, 0)
+	mapFlags := bigloadMapFlagsLinux
+	if fc.eb.target.OS() == OSDarwin {
+		mapFlags = bigloadMapFlagsDarwin
+	}
+	fc.out.XorRegWithReg("rdi", "rdi")
+	fc.out.MovRegToReg("rsi", "r14")
+	fc.out.ShlImmReg("rsi", 4)
+	fc.out.AddImmToReg("rsi", 8)
+	fc.out.MovImmToReg("rdx", "3")
+	fc.out.MovImmToReg("rcx", fmt.Sprintf("%d", mapFlags))
+	fc.out.MovImmToReg("r8", "-1")
+	fc.out.XorRegWithReg("r9", "r9")
+	fc.emitBigloadCall("mmap")
+	fc.out.MovRegToReg("rbx", "rax")
+	fc.out.CmpRegToImm("rbx", -1)
+	listFailed := fc.forwardJump(JumpEqual)
+
+	fc.out.MovMemToReg("rax", "rsp", 80)
+	fc.out.TestRegReg("rax", "rax")
+	parseLines := fc.forwardJump(JumpNotEqual)
+
+	// Raw float64s: entry i is [i][src[i]]
+	fc.out.XorRegWithReg("rcx", "rcx")
+	rawStart := fc.eb.text.Len()
+	fc.out.CmpRegToReg("rcx", "r14")
+	rawDone := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovRegToReg("rax", "rcx")
+	fc.out.ShlImmReg("rax", 3)
+	fc.out.AddRegToReg("rax", "r12")
+	fc.out.MovMemToXmm("xmm0", "rax", 0)
+	fc.out.MovRegToReg("rdx", "rcx")
+	fc.out.ShlImmReg("rdx", 4)
+	fc.out.AddRegToReg("rdx", "rbx")
+	fc.out.Cvtsi2sd("xmm1", "rcx")
+	fc.out.MovXmmToMem("xmm1", "rdx", 8)
+	fc.out.MovXmmToMem("xmm0", "rdx", 16)
+	fc.out.IncReg("rcx")
+	fc.out.JumpUnconditional(int32(rawStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(rawDone)
+	filled := fc.forwardJumpAlways()
+
+	// Lines: copy each line (up to bigloadLineMax bytes) to the line buffer
+	// and keep it if strtod reads a number from it. r15 = cursor,
+	// r14 = entries so far
+	fc.landForwardJump(parseLines)
+	fc.out.MovRegToReg("r15", "r12")
+	fc.out.XorRegWithReg("r14", "r14")
+	lineStart := fc.eb.text.Len()
+	fc.out.MovRegToReg("rdx", "r12")
+	fc.out.AddRegToReg("rdx", "r13")
+	fc.out.CmpRegToReg("r15", "rdx")
+	linesDone := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovRegToReg("rcx", "r15")
+	lineEndStart := fc.eb.text.Len()
+	fc.out.CmpRegToReg("rcx", "rdx")
+	lineEndFound := []int{fc.forwardJump(JumpGreaterOrEqual)}
+	fc.out.MovU8MemToReg("rax", "rcx", 0)
+	fc.out.CmpRegToImm("rax", '\n')
+	lineEndFound = append(lineEndFound, fc.forwardJump(JumpEqual))
+	fc.out.IncReg("rcx")
+	fc.out.JumpUnconditional(int32(lineEndStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	for _, pos := range lineEndFound {
+		fc.landForwardJump(pos)
+	}
+	fc.out.MovRegToMem("rcx", "rsp", 88)
+	fc.out.SubRegFromReg("rcx", "r15")
+	fc.out.CmpRegToImm("rcx", bigloadLineMax)
+	short := fc.forwardJump(JumpLessOrEqual)
+	fc.out.MovImmToReg("rcx", fmt.Sprintf("%d", bigloadLineMax))
+	fc.landForwardJump(short)
+	fc.out.MovRegToReg("rsi", "r15")
+	fc.out.LeaMemToReg("rdi", "rsp", 16)
+	fc.out.RepMovsb()
+	fc.out.XorRegWithReg("rax", "rax")
+	fc.out.MovU8RegToMem("rax", "rdi", 0)
+	fc.out.LeaMemToReg("rdi", "rsp", 16)
+	fc.out.LeaMemToReg("rsi", "rsp", 8)
+	fc.eb.GenerateCallInstruction("strtod")
+	fc.out.LeaMemToReg("rax", "rsp", 16)
+	fc.out.MovMemToReg("rcx", "rsp", 8)
+	fc.out.CmpRegToReg("rcx", "rax")
+	notNumber := fc.forwardJump(JumpEqual)
+	fc.out.MovRegToReg("rdx", "r14")
+	fc.out.ShlImmReg("rdx", 4)
+	fc.out.AddRegToReg("rdx", "rbx")
+	fc.out.Cvtsi2sd("xmm1", "r14")
+	fc.out.MovXmmToMem("xmm1", "rdx", 8)
+	fc.out.MovXmmToMem("xmm0", "rdx", 16)
+	fc.out.IncReg("r14")
+	fc.landForwardJump(notNumber)
+	fc.out.MovMemToReg("r15", "rsp", 88)
+	fc.out.IncReg("r15")
+	fc.out.JumpUnconditional(int32(lineStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(linesDone)
+
+	fc.landForwardJump(filled)
+	fc.out.Cvtsi2sd("xmm0", "r14")
+	fc.out.MovXmmToMem("xmm0", "rbx", 0)
+	fc.out.MovRegToReg("rdi", "r12")
+	fc.out.MovRegToReg("rsi", "r13")
+	fc.emitBigloadCall("munmap")
+	fc.out.MovRegToReg("rax", "rbx")
+	done := fc.forwardJumpAlways()
+
+	// Nothing to map: an empty list from the arena
+	fc.landForwardJump(listFailed)
+	fc.out.MovRegToReg("rdi", "r12")
+	fc.out.MovRegToReg("rsi", "r13")
+	fc.emitBigloadCall("munmap")
+	noMapping := fc.forwardJumpAlways()
+	fc.landForwardJump(emptyFile)
+	fc.landForwardJump(mapFailed)
+	fc.out.MovMemToReg("rdi", "rsp", 0)
+	fc.emitBigloadCall("close")
+	fc.landForwardJump(openFailed)
+	fc.landForwardJump(noMapping)
+	fc.out.MovImmToReg("rdi", "8")
+	fc.callArenaAlloc()
+	fc.out.MovImmToMem(0, "rax", 0)
+	fc.landForwardJump(done)
+
+	fc.out.LeaMemToReg("rsp", "rbp", -40)
+	fc.out.PopReg("r15")
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
@@ -0,0 +1,219 @@
+// Completion: 100% - popcount, clz, ctz and bswap
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// bits.go - Bit manipulation builtins
+//
+// popcount(x), clz(x), ctz(x) and bswap(x) work on x truncated to a signed
+// 64-bit integer, like the bitwise operators, and give a number:
+//
+//	popcount(255)   // 8, the set bits
+//	clz(1)          // 63, the zeros above the highest set bit; 64 for 0
+//	ctz(8)          // 3, the zeros below the lowest set bit; 64 for 0
+//	bswap(1)        // 72057594037927936 (1 << 56), the 8 bytes reversed
+//
+// bswap gives the swapped bits as a signed integer, which is exact as long
+// as its set bits span no more than 53 bits, so bswap(bswap(x)) is x for
+// the byte patterns of hashes and file formats that fit.
+//
+// Each has the instruction of its target when the CPU has it:
+//
+//	          x86-64              ARM64          RISC-V (--zbb)
+//	popcount  POPCNT              CNT + ADDV     cpop
+//	clz       LZCNT               CLZ            clz
+//	ctz       TZCNT (BMI1)        RBIT + CLZ     ctz
+//	bswap     BSWAP               REV            rev8
+//
+// x86-64 checks the CPUID flags at runtime and falls back to BSR, BSF and
+// a loop. ARM64 has them all in the baseline. RISC-V uses Zbb only with
+// --zbb, since older cores trap on it, and short loops otherwise.
+
+// compileBitCall compiles popcount, clz, ctz and bswap
+func (fc *C67Compiler) compileBitCall(call *CallExpr) {
+	if len(call.Args) != 1 {
+		compilerError("%s() requires exactly 1 argument", call.Function)
+	}
+	fc.compileExpression(call.Args[0])
+	fc.out.Cvttsd2si("rax", "xmm0")
+
+	switch call.Function {
+	case "popcount":
+		missing := fc.jumpIfCPUFlagClear("cpu_has_popcnt")
+		fc.out.Emit([]byte{0xf3, 0x48, 0x0f, 0xb8, 0xc0}) // popcnt rax, rax
+		done := fc.forwardJumpAlways()
+
+		// rcx = count, rdx = the bits left
+		fc.landForwardJump(missing)
+		fc.out.XorRegWithReg("rcx", "rcx")
+		fc.out.MovRegToReg("rdx", "rax")
+		loop := fc.eb.text.Len()
+		fc.out.TestRegReg("rdx", "rdx")
+		end := fc.forwardJump(JumpEqual)
+		fc.out.MovRegToReg("rax", "rdx")
+		fc.out.AndRegWithImm("rax", 1)
+		fc.out.AddRegToReg("rcx", "rax")
+		fc.out.ShrRegByImm("rdx", 1)
+		fc.out.JumpUnconditional(int32(loop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+		fc.landForwardJump(end)
+		fc.out.MovRegToReg("rax", "rcx")
+		fc.landForwardJump(done)
+
+	case "clz":
+		missing := fc.jumpIfCPUFlagClear("cpu_has_lzcnt")
+		fc.out.Emit([]byte{0xf3, 0x48, 0x0f, 0xbd, 0xc0}) // lzcnt rax, rax
+		done := fc.forwardJumpAlways()
+
+		// 63 - the index of the highest set bit, which BSR leaves undefined for 0
+		fc.landForwardJump(missing)
+		fc.out.MovImmToReg("rcx", "64")
+		fc.out.TestRegReg("rax", "rax")
+		zero := fc.forwardJump(JumpEqual)
+		fc.out.Emit([]byte{0x48, 0x0f, 0xbd, 0xd0}) // bsr rdx, rax
+		fc.out.MovImmToReg("rcx", "63")
+		fc.out.SubRegFromReg("rcx", "rdx")
+		fc.landForwardJump(zero)
+		fc.out.MovRegToReg("rax", "rcx")
+		fc.landForwardJump(done)
+
+	case "ctz":
+		missing := fc.jumpIfCPUFlagClear("cpu_has_bmi1")
+		fc.out.Emit([]byte{0xf3, 0x48, 0x0f, 0xbc, 0xc0}) // tzcnt rax, rax
+		done := fc.forwardJumpAlways()
+
+		// The index of the lowest set bit, which BSF leaves undefined for 0
+		fc.landForwardJump(missing)
+		fc.out.TestRegReg("rax", "rax")
+		nonzero := fc.forwardJump(JumpNotEqual)
+		fc.out.MovImmToReg("rax", "64")
+		zeroDone := fc.forwardJumpAlways()
+		fc.landForwardJump(nonzero)
+		fc.out.Emit([]byte{0x48, 0x0f, 0xbc, 0xc0}) // bsf rax, rax
+		fc.landForwardJump(zeroDone)
+		fc.landForwardJump(done)
+
+	case "bswap":
+		fc.out.Emit([]byte{0x48, 0x0f, 0xc8}) // bswap rax
+	}
+	fc.out.Cvtsi2sd("xmm0", "rax")
+}
+
+// jumpIfCPUFlagClear emits a jump taken when the cpu_has_* flag is 0 and
+// returns its position. Clobbers rcx.
+func (fc *C67Compiler) jumpIfCPUFlagClear(flag string) int {
+	fc.out.LeaSymbolToReg("rcx", flag)
+	fc.out.Emit([]byte{0x80, 0x39, 0x00}) // cmp byte [rcx], 0
+	return fc.forwardJump(JumpEqual)
+}
+
+// compileBitCall compiles popcount, clz, ctz and bswap for ARM64
+func (acg *ARM64CodeGen) compileBitCall(call *CallExpr) error {
+	if len(call.Args) != 1 {
+		return fmt.Errorf("%s() requires exactly 1 argument", call.Function)
+	}
+	if err := acg.compileExpression(call.Args[0]); err != nil {
+		return err
+	}
+	if err := acg.out.FcvtzsDoubleToInt64("x0", "d0"); err != nil {
+		return err
+	}
+
+	var err error
+	switch call.Function {
+	case "popcount":
+		if err = acg.out.FmovGPToDouble("d0", "x0"); err == nil {
+			if err = acg.out.PopcountDouble("d0", "d0"); err == nil {
+				err = acg.out.FmovDoubleToGP("x0", "d0")
+			}
+		}
+	case "clz":
+		err = acg.out.Clz64("x0", "x0")
+	case "ctz":
+		if err = acg.out.Rbit64("x0", "x0"); err == nil {
+			err = acg.out.Clz64("x0", "x0")
+		}
+	case "bswap":
+		err = acg.out.Rev64("x0", "x0")
+	}
+	if err != nil {
+		return err
+	}
+	return acg.out.ScvtfInt64ToDouble("d0", "x0")
+}
+
+// compileBitCall compiles popcount, clz, ctz and bswap for RISC-V, on the
+// integer in a0. The loops use t0-t2 and have fixed branch offsets, which
+// compression adjusts. The arguments of errors.Join are emitted in order.
+func (rcg *RiscvCodeGen) compileBitCall(call *CallExpr) error {
+	if len(call.Args) != 1 {
+		return fmt.Errorf("%s() requires exactly 1 argument", call.Function)
+	}
+	if err := rcg.compileExpression(call.Args[0]); err != nil {
+		return err
+	}
+	o := rcg.out
+
+	if RiscvZbbFlag {
+		switch call.Function {
+		case "popcount":
+			return o.Cpop("a0", "a0")
+		case "clz":
+			return o.Clz("a0", "a0")
+		case "ctz":
+			return o.Ctz("a0", "a0")
+		}
+		return o.Rev8("a0", "a0")
+	}
+
+	switch call.Function {
+	case "popcount":
+		// t0 = count; while a0 != 0 { t0 += a0 & 1; a0 >>= 1 }
+		return errors.Join(
+			o.AddImm("t0", "zero", 0),
+			o.BranchEqual("a0", "zero", 20),
+			o.Andi("t1", "a0", 1),
+			o.Add("t0", "t0", "t1"),
+			o.Srli("a0", "a0", 1),
+			o.JumpAndLink("zero", -16),
+			o.Move("a0", "t0"),
+		)
+	case "clz":
+		// 64 - the number of bits up to the highest set one
+		return errors.Join(
+			o.AddImm("t0", "zero", 64),
+			o.BranchEqual("a0", "zero", 16),
+			o.AddImm("t0", "t0", -1),
+			o.Srli("a0", "a0", 1),
+			o.JumpAndLink("zero", -12),
+			o.Move("a0", "t0"),
+		)
+	case "ctz":
+		// 64 for 0, else the zeros shifted out below the lowest set bit
+		return errors.Join(
+			o.AddImm("t0", "zero", 64),
+			o.BranchEqual("a0", "zero", 28),
+			o.AddImm("t0", "zero", 0),
+			o.Andi("t1", "a0", 1),
+			o.BranchNotEqual("t1", "zero", 16),
+			o.AddImm("t0", "t0", 1),
+			o.Srli("a0", "a0", 1),
+			o.JumpAndLink("zero", -16),
+			o.Move("a0", "t0"),
+		)
+	}
+	// 8 times: t0 = t0 << 8 | a0 & 0xff; a0 >>= 8
+	return errors.Join(
+		o.AddImm("t0", "zero", 0),
+		o.AddImm("t2", "zero", 8),
+		o.Slli("t0", "t0", 8),
+		o.Andi("t1", "a0", 0xff),
+		o.Or("t0", "t0", "t1"),
+		o.Srli("a0", "a0", 8),
+		o.AddImm("t2", "t2", -1),
+		o.BranchNotEqual("t2", "zero", -20),
+		o.Move("a0", "t0"),
+	)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// TestARM64BitInstructions tests the encodings behind popcount, clz, ctz and bswap
+func TestARM64BitInstructions(t *testing.T) {
+	eb, _ := New("arm64")
+	out := NewARM64CodeGen(eb, nil).out
+
+	out.Clz64("x0", "x0")          // clz x0, x0
+	out.Rbit64("x1", "x2")         // rbit x1, x2
+	out.Rev64("x0", "x0")          // rev x0, x0
+	out.PopcountDouble("d0", "d0") // cnt v0.8b, v0.8b; addv b0, v0.8b
+	want := []uint32{0xdac01000, 0xdac00041, 0xdac00c00, 0x0e205800, 0x0e31b800}
+
+	text := eb.text.Bytes()
+	if len(text) != 4*len(want) {
+		t.Fatalf("Expected %d instructions, got %d bytes", len(want), len(text))
+	}
+	for i, w := range want {
+		if got := binary.LittleEndian.Uint32(text[4*i:]); got != w {
+			t.Errorf("Instruction %d: expected %08x, got %08x", i, w, got)
+		}
+	}
+}
+
+// TestRiscvZbb tests the Zbb encodings, and that popcount, clz, ctz and
+// bswap are single instructions with --zbb
+func TestRiscvZbb(t *testing.T) {
+	eb, _ := New("riscv64")
+	out := NewRiscvCodeGen(eb).out
+
+	out.Clz("a0", "a0")  // clz a0, a0
+	out.Ctz("a0", "a0")  // ctz a0, a0
+	out.Cpop("a0", "a0") // cpop a0, a0
+	out.Rev8("a0", "a0") // rev8 a0, a0
+	want := []uint32{0x60051513, 0x60151513, 0x60251513, 0x6b855513}
+	got := riscvWords(eb.text.Bytes())
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d instructions, got %08x", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Instruction %d: expected %08x, got %08x", i, want[i], got[i])
+		}
+	}
+
+	oldZbb := RiscvZbbFlag
+	defer func() { RiscvZbbFlag = oldZbb }()
+	for _, name := range []string{"popcount", "clz", "ctz", "bswap"} {
+		call := &CallExpr{Function: name, Args: []Expression{&NumberExpr{Value: 5}}}
+		sizes := make(map[bool]int)
+		for _, zbb := range []bool{false, true} {
+			RiscvZbbFlag = zbb
+			eb, _ := New("riscv64")
+			if err := NewRiscvCodeGen(eb).compileBitCall(call); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			sizes[zbb] = eb.text.Len()
+		}
+		if sizes[true] != 8 || sizes[false] <= sizes[true] {
+			t.Errorf("%s: expected li and one instruction with Zbb and a loop without, got %d and %d bytes", name, sizes[true], sizes[false])
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Completion: 100% - Build ID note and .comment section
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+)
+
+// buildid.go - Tracing an executable back to its build
+//
+// Every ELF executable has a .comment section with the version of the
+// compiler, as GCC and Clang write theirs, and a .note.gnu.build-id note
+// with a build ID, the SHA-1 of the executable with the ID left as zeros,
+// as ld --build-id=sha1 computes it:
+//
+//	$ readelf -n -p .comment app
+//	  Build ID: 5c0e3a...
+//	  [     0]  c67 1.5.2
+//
+// The same sources, compiler and flags give the same ID, so the ID names a
+// build, and can be looked up in the manifest of --emit-manifest, which
+// records it next to the sources and Git commits, or be used to find the
+// debug information of a deployed executable. Like .note.c67, both are
+// after the last segment and are not loaded, so they are not in core dumps.
+
+// buildIDNoteName and buildIDNoteType identify the note, as NT_GNU_BUILD_ID
+const (
+	buildIDNoteName = "GNU"
+	buildIDNoteType = 3
+	buildIDSize     = sha1.Size
+)
+
+// buildIDNote returns the build ID note with the ID zeroed, and where in
+// it the ID starts
+func buildIDNote() (note []byte, idOffset int) {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint32(len(buildIDNoteName)+1))
+	binary.Write(&b, binary.LittleEndian, uint32(buildIDSize))
+	binary.Write(&b, binary.LittleEndian, uint32(buildIDNoteType))
+	b.WriteString(buildIDNoteName + "\x00") // 4 bytes, so the ID is aligned
+	idOffset = b.Len()
+	b.Write(make([]byte, buildIDSize))
+	return b.Bytes(), idOffset
+}
+
+// setBuildID fills in the ID at offset in the finished ELF
+func (eb *ExecutableBuilder) setBuildID(offset int) {
+	sum := sha1.Sum(eb.elf.Bytes())
+	copy(eb.elf.Bytes()[offset:], sum[:])
+}
+
+// readBuildInfo returns the build ID, in hex, and the compiler from
+// .comment of the ELF executable at path, or "" for what it does not have
+func readBuildInfo(path string) (buildID, compiler string) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+	if section := f.Section(".note.gnu.build-id"); section != nil {
+		if note, err := section.Data(); err == nil && len(note) >= 16 {
+			descSize := int(binary.LittleEndian.Uint32(note[4:]))
+			descStart := 12 + (int(binary.LittleEndian.Uint32(note))+3)&^3
+			if binary.LittleEndian.Uint32(note[8:]) == buildIDNoteType && descStart+descSize <= len(note) {
+				buildID = hex.EncodeToString(note[descStart : descStart+descSize])
+			}
+		}
+	}
+	if section := f.Section(".comment"); section != nil {
+		if data, err := section.Data(); err == nil {
+			compiler, _, _ = strings.Cut(string(data), "\x00")
+		}
+	}
+	return buildID, compiler
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestBuildID tests that executables have the compiler in .comment and a
+// build ID that is the SHA-1 of the executable without it
+func TestBuildID(t *testing.T) {
+	for _, arch := range []Arch{ArchX86_64, ArchARM64} {
+		tmpDir := t.TempDir()
+		ids := make(map[string]string)
+		for _, name := range []string{"a", "b", "c"} {
+			source := "println(1)\n"
+			if name == "c" {
+				source = "println(2)\n"
+			}
+			srcFile := filepath.Join(tmpDir, name+".c67")
+			exePath := filepath.Join(tmpDir, name)
+			if err := os.WriteFile(srcFile, []byte(source), 0644); err != nil {
+				t.Fatalf("Failed to write source file: %v", err)
+			}
+			if err := CompileC67(srcFile, exePath, Platform{Arch: arch, OS: OSLinux}); err != nil {
+				t.Fatalf("%s: compilation failed: %v", arch, err)
+			}
+
+			buildID, compiler := readBuildInfo(exePath)
+			if compiler != versionString {
+				t.Errorf("%s: expected .comment %q, got %q", arch, versionString, compiler)
+			}
+			id, err := hex.DecodeString(buildID)
+			if err != nil || len(id) != sha1.Size {
+				t.Fatalf("%s: expected a SHA-1 build ID, got %q", arch, buildID)
+			}
+			executable, err := os.ReadFile(exePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Count(executable, id) != 1 {
+				t.Fatalf("%s: the build ID is not in the executable once", arch)
+			}
+			sum := sha1.Sum(bytes.Replace(executable, id, make([]byte, sha1.Size), 1))
+			if !bytes.Equal(sum[:], id) {
+				t.Errorf("%s: the build ID is not the SHA-1 of the executable", arch)
+			}
+			ids[name] = buildID
+		}
+		if ids["a"] != ids["b"] || ids["a"] == ids["c"] {
+			t.Errorf("%s: expected the same build ID for the same program only, got %v", arch, ids)
+		}
+	}
+}
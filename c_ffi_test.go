@@ -1,6 +1,7 @@
 package main
 
 import (
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -156,3 +157,118 @@ println(Vec3.z.offset)
 		t.Errorf("Expected Vec3.z offset, got: %s", output)
 	}
 }
+
+// TestLazyFFI tests --lazy-ffi, which resolves namespaced C calls at their first call
+func TestLazyFFI(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("--lazy-ffi is only supported on Linux x86-64")
+	}
+	defer func() { LazyFFIFlag = false }()
+	LazyFFIFlag = true
+
+	code := `import m as libm
+
+total := 0.0
+@ i in 0..<1000 {
+    total <- total + libm.cos(0.0)
+}
+println(total)
+println(libm.pow(2.0, 10.0))
+`
+	output := compileAndRun(t, code)
+	if !strings.Contains(output, "1000\n1024\n") {
+		t.Errorf("Expected '1000\\n1024\\n', got: %s", output)
+	}
+
+	output = compileAndRun(t, "import m as libm\n\nprintln(libm.no_such_function(1.0))\n")
+	if !strings.Contains(output, "ERROR: --lazy-ffi:") || !strings.Contains(output, "no_such_function") {
+		t.Errorf("Expected a --lazy-ffi error naming the missing symbol, got: %s", output)
+	}
+}
+
+// TestAutoCStringArguments tests that strings passed to char* parameters
+// are converted to C strings without "as cstr", also when their type is
+// only known at runtime, while C pointers pass through unchanged
+func TestAutoCStringArguments(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs the libc headers")
+	}
+	code := `import c as libc
+
+copy = (dst, src) -> libc.strcpy(dst, src)
+buf = c.malloc(32)
+copy(buf, "abc")
+copy(buf + 3, "déf")
+println(libc.strlen(buf))
+println(libc.strcmp(buf, "abcdéf"))
+name := "wo"
+name <- name + "rld"
+println(libc.strlen(name))
+`
+	output := compileAndRun(t, code)
+	if !strings.Contains(output, "7\n0\n5\n") {
+		t.Errorf("Expected '7\\n0\\n5\\n', got: %s", output)
+	}
+}
+
+// TestCReturnTypeConversion tests that C results are read with the width
+// and signedness of their return type
+func TestCReturnTypeConversion(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs the libc headers")
+	}
+	code := `import c as libc
+
+println(libc.strcmp("a", "b") < 0)
+println(libc.memcmp("b", "a", 1) > 0)
+println(libc.strtoul("4294967295", 0, 10))
+println(libc.strtoull("18446744073709551615", 0, 10) > 0)
+println(libc.strlen("abcd"))
+`
+	output := compileAndRun(t, code)
+	if !strings.Contains(output, "1\n1\n4294967295\n1\n4\n") {
+		t.Errorf("Expected '1\\n1\\n4294967295\\n1\\n4\\n', got: %s", output)
+	}
+}
+
+// TestCErrno tests cerrno() and cerrstr() after failing C calls
+func TestCErrno(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs the libc headers")
+	}
+	code := `import c as libc
+
+f = libc.fopen("/nonexistent/file", "r")
+code = cerrno()
+why = cerrstr()
+println(f)
+println(code)
+println(why)
+println(libc.chdir("/nonexistent") < 0)
+println(cerrno())
+println(cerrstr(13))
+`
+	output := compileAndRun(t, code)
+	expected := "0\n2\nNo such file or directory\n1\n2\nPermission denied\n"
+	if !strings.Contains(output, expected) {
+		t.Errorf("Expected %q, got: %s", expected, output)
+	}
+}
+
+func TestCVariables(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("needs x86-64 Linux")
+	}
+	code := `import c as libc
+
+libc.fputs("to stderr\n", libc.stderr)
+println(libc.stdout != 0)
+println(libc.optind)
+println(c.opterr)
+`
+	output := compileAndRun(t, code)
+	expected := "to stderr\n1\n1\n1\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q", expected, output)
+	}
+}
@@ -151,6 +151,28 @@ func GetCallingConvention(target Target) CallingConvention {
 	}
 }
 
+// Calls between C67 lambdas use their own convention, the same on every OS:
+// the arguments, all float64, go in the first maxLambdaArgs float registers
+// (xmm0-xmm7, d0-d7, fa0-fa7) and the result comes back in the first. On
+// x86-64, r15 carries the closure environment and r14 the number of
+// variadic arguments. The platform conventions above only apply where C
+// code is called or calls back.
+
+// maxLambdaArgs is the number of arguments a lambda can take
+const maxLambdaArgs = 8
+
+// lambdaArgRegs returns the registers of the arguments of a lambda call
+func lambdaArgRegs(arch Arch) []string {
+	switch arch {
+	case ArchARM64:
+		return []string{"d0", "d1", "d2", "d3", "d4", "d5", "d6", "d7"}
+	case ArchRiscv64:
+		return []string{"fa0", "fa1", "fa2", "fa3", "fa4", "fa5", "fa6", "fa7"}
+	default:
+		return []string{"xmm0", "xmm1", "xmm2", "xmm3", "xmm4", "xmm5", "xmm6", "xmm7"}
+	}
+}
+
 // CallSiteManager helps manage register state around function calls
 type CallSiteManager struct {
 	cc           CallingConvention
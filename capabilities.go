@@ -0,0 +1,186 @@
+// Completion: 100% - Capability note and c67 inspect
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// capabilities.go - What an executable can do, recorded in the executable
+//
+// The compiler records the features a program uses that reach outside of
+// it in a .note.c67 section of the ELF, so they can be checked before the
+// executable is run, without its source:
+//
+//	$ c67 inspect server
+//	server:
+//	  compiler   c67 1.5.2
+//	  build-id   5c0e3a...
+//	  threads    yes
+//	  processes  no
+//	  ffi        sqlite3, libc
+//	  network    yes
+//	  files      yes
+//	  unsafe     no
+//	  sandbox    no
+//
+// The note is not loaded, like .symtab, and readelf -n shows it too. Its
+// description is one capability per line, "ffi" followed by the library.
+// The capabilities come from the program as written, so a feature is
+// recorded when the code that uses it is there, whether or not it runs.
+
+// capabilityNames are the capabilities, in the order inspect prints them
+var capabilityNames = []string{"threads", "processes", "ffi", "network", "files", "unsafe", "sandbox"}
+
+// capabilityCalls are the built-ins that give a capability
+var capabilityCalls = map[string]string{
+	"read_file": "files", "readfile": "files", "write_file": "files", "bigload": "files",
+	"run":     "processes",
+	"syscall": "unsafe",
+	"dlopen":  "ffi libdl",
+}
+
+// capabilityNoteName and capabilityNoteType identify the note
+const (
+	capabilityNoteName = "C67"
+	capabilityNoteType = 1
+)
+
+// programCapabilities returns the capabilities program uses, sorted
+func programCapabilities(program *Program) []string {
+	found := make(map[string]bool)
+	visitAST(reflect.ValueOf(program.Statements), func(node any) {
+		switch n := node.(type) {
+		case *LoopStmt:
+			found["threads"] = found["threads"] || n.NumThreads != 0
+		case *WhileStmt:
+			found["threads"] = found["threads"] || n.NumThreads != 0
+		case *LoopExpr:
+			found["threads"] = found["threads"] || n.NumThreads != 0
+		case *ParallelExpr:
+			found["threads"] = true
+		case *SpawnStmt, *BackgroundExpr:
+			found["processes"] = true
+		case *CImportStmt:
+			found["ffi "+n.Library] = true
+		case *SendExpr, *ReceiveExpr, *ReceiveLoopStmt:
+			found["network"] = true
+		case *UnsafeExpr, *SyscallStmt:
+			found["unsafe"] = true
+		case *CallExpr:
+			if n.IsCFFI {
+				found["ffi libc"] = true
+			} else if capability, ok := capabilityCalls[n.Function]; ok {
+				found[capability] = true
+			}
+		}
+	})
+	if SandboxFlag {
+		found["sandbox"] = true
+	}
+
+	var capabilities []string
+	for capability, ok := range found {
+		if ok {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	sort.Strings(capabilities)
+	return capabilities
+}
+
+// capabilityNote returns the ELF note with capabilities
+func capabilityNote(capabilities []string) []byte {
+	var note bytes.Buffer
+	desc := strings.Join(capabilities, "\n")
+	binary.Write(&note, binary.LittleEndian, uint32(len(capabilityNoteName)+1))
+	binary.Write(&note, binary.LittleEndian, uint32(len(desc)))
+	binary.Write(&note, binary.LittleEndian, uint32(capabilityNoteType))
+	note.WriteString(capabilityNoteName + "\x00")
+	for note.Len()%4 != 0 {
+		note.WriteByte(0)
+	}
+	note.WriteString(desc)
+	for note.Len()%4 != 0 {
+		note.WriteByte(0)
+	}
+	return note.Bytes()
+}
+
+// readCapabilities returns the capabilities in the note of the executable
+// at path
+func readCapabilities(path string) ([]string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	section := f.Section(".note.c67")
+	if section == nil {
+		return nil, fmt.Errorf("%s was not built by a c67 that records capabilities", path)
+	}
+	note, err := section.Data()
+	if err != nil {
+		return nil, err
+	}
+	if len(note) < 12 {
+		return nil, fmt.Errorf("%s: the .note.c67 section is truncated", path)
+	}
+	nameSize := int(binary.LittleEndian.Uint32(note))
+	descSize := int(binary.LittleEndian.Uint32(note[4:]))
+	descStart := 12 + (nameSize+3)&^3
+	if descStart+descSize > len(note) {
+		return nil, fmt.Errorf("%s: the .note.c67 section is truncated", path)
+	}
+	desc := string(note[descStart : descStart+descSize])
+	if desc == "" {
+		return nil, nil
+	}
+	return strings.Split(desc, "\n"), nil
+}
+
+// cmdInspect prints the capabilities of executables built by c67
+func cmdInspect(ctx *CommandContext, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: c67 inspect <executable>...")
+	}
+	for _, path := range args {
+		capabilities, err := readCapabilities(path)
+		if err != nil {
+			return err
+		}
+		has := make(map[string]bool)
+		var libraries []string
+		for _, capability := range capabilities {
+			if library, ok := strings.CutPrefix(capability, "ffi "); ok {
+				libraries = append(libraries, library)
+			}
+			has[capability] = true
+		}
+
+		fmt.Printf("%s:\n", path)
+		buildID, compiler := readBuildInfo(path)
+		if compiler != "" {
+			fmt.Printf("  %-10s %s\n", "compiler", compiler)
+		}
+		if buildID != "" {
+			fmt.Printf("  %-10s %s\n", "build-id", buildID)
+		}
+		for _, name := range capabilityNames {
+			value := "no"
+			switch {
+			case name == "ffi" && len(libraries) > 0:
+				value = strings.Join(libraries, ", ")
+			case has[name]:
+				value = "yes"
+			}
+			fmt.Printf("  %-10s %s\n", name, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestCapabilityNote tests that the capabilities of a program are recorded
+// in .note.c67 and read back by inspect
+func TestCapabilityNote(t *testing.T) {
+	sources := map[string][]string{
+		"println(1)\n": nil,
+		"@@ i in 0..<2 { println(i) }\ns = read_file(\"/etc/hostname\")\np = c.malloc(8)\n": {"ffi libc", "files", "threads"},
+	}
+	for _, arch := range []Arch{ArchX86_64, ArchARM64} {
+		for source, want := range sources {
+			if arch == ArchARM64 && want != nil {
+				continue // the ARM64 backend has no @@ loops
+			}
+			tmpDir := t.TempDir()
+			srcFile := filepath.Join(tmpDir, "caps.c67")
+			exePath := filepath.Join(tmpDir, "caps")
+			if err := os.WriteFile(srcFile, []byte(source), 0644); err != nil {
+				t.Fatalf("Failed to write source file: %v", err)
+			}
+			if err := CompileC67(srcFile, exePath, Platform{Arch: arch, OS: OSLinux}); err != nil {
+				t.Fatalf("%s: compilation failed: %v", arch, err)
+			}
+			got, err := readCapabilities(exePath)
+			if err != nil {
+				t.Fatalf("%s: %v", arch, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %q: expected %q, got %q", arch, source, want, got)
+			}
+		}
+	}
+}
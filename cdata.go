@@ -0,0 +1,66 @@
+// Completion: 100% - C global variables through imports
+package main
+
+import (
+	"sync"
+)
+
+// cdata.go - Reading global variables exported by C libraries
+//
+//	import c
+//	c.fputs("to stderr\n", c.stderr)
+//	println(c.optind)
+//
+// alias.name reads a data object that the imported library exports, and
+// c.name one from libc. The variable gets a GOT slot that the dynamic
+// linker fills with its address at startup. A variable of 1, 2, 4 or 8
+// bytes gives its value as a signed integer, which makes pointers such as
+// stdout usable as C arguments. A larger one, such as a struct or an
+// array, gives its address, to read with read_u32() and friends. Headers
+// are not consulted, so a float or double variable gives its raw bits.
+
+// libcVariables are the data objects libc exports, read once
+var libcVariables = sync.OnceValue(func() map[string]uint64 {
+	for _, path := range sharedObjectPaths("libc.so.6") {
+		if variables, err := ExtractDataSymbolsFromSo(path); err == nil {
+			return variables
+		}
+	}
+	return nil
+})
+
+// libcVariableSize returns the size of the libc variable for c.name, if e
+// is such a reference and c is not a variable of the program
+func (fc *C67Compiler) libcVariableSize(e *NamespacedIdentExpr) (uint64, bool) {
+	if e.Namespace != "c" {
+		return 0, false
+	}
+	if _, isVar := fc.variables["c"]; isVar {
+		return 0, false
+	}
+	size, ok := libcVariables()[e.Name]
+	return size, ok
+}
+
+// compileCVariable loads the C variable name from the library libName into
+// xmm0, its value if it fits in a register, otherwise its address
+func (fc *C67Compiler) compileCVariable(libName, name string, size uint64) {
+	if fc.eb.target.OS() != OSLinux || fc.eb.target.Arch() != ArchX86_64 {
+		compilerError("C variable '%s' can only be read on Linux x86-64", name)
+	}
+	if libName != "" && libName != "c" {
+		fc.cLibHandles[libName] = "linked"
+	}
+	fc.emitDataSymbolAddress("rax", name)
+	switch size {
+	case 1:
+		fc.out.Emit([]byte{0x48, 0x0f, 0xbe, 0x00}) // movsx rax, byte [rax]
+	case 2:
+		fc.out.Emit([]byte{0x48, 0x0f, 0xbf, 0x00}) // movsx rax, word [rax]
+	case 4:
+		fc.out.Emit([]byte{0x48, 0x63, 0x00}) // movsxd rax, dword [rax]
+	case 8:
+		fc.out.MovMemToReg("rax", "rax", 0)
+	}
+	fc.out.Cvtsi2sd("xmm0", "rax")
+}
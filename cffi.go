@@ -687,6 +687,72 @@ func isPointerType(cType string) bool {
 	return strings.Contains(cType, "*") || strings.HasSuffix(cType, "Ptr")
 }
 
+// isCStringType checks if a C type is a char pointer, i.e. a C string
+// parameter such as const char*. char** and other pointers to pointers
+// are not C strings.
+func isCStringType(cType string) bool {
+	t := cType
+	for _, qualifier := range []string{"const", "__restrict", "restrict", " "} {
+		t = strings.ReplaceAll(t, qualifier, "")
+	}
+	return t == "char*" || t == "signedchar*" || t == "unsignedchar*"
+}
+
+// cIntegerKinds maps integer C return types to how their value is read from
+// rax: "i"/"u" for signed/unsigned and the width in bits. long is 32 bits
+// on Windows and is handled by cReturnKind.
+var cIntegerKinds = map[string]string{
+	"char": "i8", "signed char": "i8", "int8_t": "i8", "Sint8": "i8",
+	"unsigned char": "u8", "uint8_t": "u8", "Uint8": "u8",
+	"short": "i16", "short int": "i16", "int16_t": "i16", "Sint16": "i16",
+	"unsigned short": "u16", "unsigned short int": "u16", "uint16_t": "u16", "Uint16": "u16",
+	"int": "i32", "signed": "i32", "signed int": "i32", "int32_t": "i32", "Sint32": "i32", "pid_t": "i32",
+	"unsigned": "u32", "unsigned int": "u32", "uint32_t": "u32", "Uint32": "u32", "DWORD": "u32", "UINT": "u32",
+	"long long": "i64", "long long int": "i64", "int64_t": "i64", "Sint64": "i64",
+	"ssize_t": "i64", "intptr_t": "i64", "ptrdiff_t": "i64", "off_t": "i64", "time_t": "i64",
+	"unsigned long long": "u64", "unsigned long long int": "u64", "uint64_t": "u64", "Uint64": "u64",
+	"size_t": "u64", "uintptr_t": "u64",
+}
+
+// cReturnKind classifies the return type of a C function for converting
+// the result to a C67 number: "void", "float32", "float64", "pointer",
+// "bool", an integer kind from cIntegerKinds, or "" for a type that is not
+// known, whose result is taken from the whole of rax
+func cReturnKind(cType string, windows bool) string {
+	var words []string
+	for _, word := range strings.Fields(strings.ReplaceAll(cType, "*", " * ")) {
+		switch word {
+		case "const", "volatile", "extern", "static", "inline", "SDL_DECLSPEC", "SDLCALL":
+			continue
+		}
+		words = append(words, word)
+	}
+	t := strings.Join(words, " ")
+	switch {
+	case t == "void":
+		return "void"
+	case t == "float":
+		return "float32"
+	case t == "double":
+		return "float64"
+	case isPointerType(t):
+		return "pointer"
+	case t == "bool" || t == "_Bool" || t == "SDL_bool":
+		return "bool"
+	case t == "long" || t == "long int" || t == "signed long":
+		if windows {
+			return "i32"
+		}
+		return "i64"
+	case t == "unsigned long" || t == "unsigned long int":
+		if windows {
+			return "u32"
+		}
+		return "u64"
+	}
+	return cIntegerKinds[t]
+}
+
 // ExtractSymbolsFromSo extracts exported function symbols from a .so file using Go's debug/elf
 func ExtractSymbolsFromSo(soPath string) ([]string, error) {
 	// Open ELF file
@@ -714,6 +780,33 @@ func ExtractSymbolsFromSo(soPath string) ([]string, error) {
 	return funcSymbols, nil
 }
 
+// ExtractDataSymbolsFromSo returns the data objects a .so file exports,
+// such as stdout or environ, with their sizes in bytes
+func ExtractDataSymbolsFromSo(soPath string) (map[string]uint64, error) {
+	elfFile, err := elf.Open(soPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ELF file: %v", err)
+	}
+	defer elfFile.Close()
+
+	symbols, err := elfFile.DynamicSymbols()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dynamic symbols: %v", err)
+	}
+
+	variables := make(map[string]uint64)
+	for _, sym := range symbols {
+		if elf.ST_TYPE(sym.Info) != elf.STT_OBJECT || sym.Section == elf.SHN_UNDEF {
+			continue
+		}
+		// Older versions of a symbol are hidden behind the default one
+		if _, seen := variables[sym.Name]; !seen || !sym.VersionIndex.IsHidden() {
+			variables[sym.Name] = sym.Size
+		}
+	}
+	return variables, nil
+}
+
 // DiscoverFunctionSignatures attempts to discover function signatures for a library
 // using multiple strategies in order of preference:
 // 1. pkg-config for library information and include paths
@@ -921,7 +1014,10 @@ func extractFunctionDeclarations(preprocessed string) map[string]*CFunctionSigna
 				var paramType string
 				// Check if last part looks like a parameter name (starts with __ or lowercase)
 				lastPart := parts[len(parts)-1]
-				if len(parts) > 1 && (strings.HasPrefix(lastPart, "__") || (len(lastPart) > 0 && lastPart[0] >= 'a' && lastPart[0] <= 'z' && !strings.Contains(lastPart, "*"))) {
+				if name := strings.TrimLeft(lastPart, "*"); len(parts) > 1 && name != lastPart && name != "" {
+					// Pointer parameter written "char *__s" - the stars belong to the type
+					paramType = strings.Join(parts[:len(parts)-1], " ") + " " + lastPart[:len(lastPart)-len(name)]
+				} else if len(parts) > 1 && (strings.HasPrefix(lastPart, "__") || (len(lastPart) > 0 && lastPart[0] >= 'a' && lastPart[0] <= 'z' && !strings.Contains(lastPart, "*"))) {
 					// Has parameter name - take all but last as type
 					paramType = strings.Join(parts[:len(parts)-1], " ")
 				} else {
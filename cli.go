@@ -15,6 +15,8 @@ import (
 // - c67 (default: compile current directory or show help)
 // - c67 build <file> (compile to executable)
 // - c67 run <file> (compile and run immediately)
+// - c67 doc <file> (print the documentation of a module, see doc.go)
+// - c67 inspect <executable> (print its capabilities, see capabilities.go)
 // - c67 <file.c67> (shorthand for build)
 //
 // Also supports shebang execution: #!/usr/bin/c67
@@ -79,6 +81,21 @@ func RunCLI(args []string, platform Platform, verbose, quiet bool, optTimeout fl
 	case "test":
 		return cmdTest(ctx, args[1:])
 
+	case "doc":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: c67 doc <file.c67> [--format text|md|html] [-o output]")
+		}
+		return cmdDoc(ctx, args[1:])
+
+	case "hash":
+		return cmdHash(ctx, args[1:])
+
+	case "inspect":
+		return cmdInspect(ctx, args[1:])
+
+	case "selftest":
+		return cmdSelfTest(ctx, args[1:])
+
 	case "help", "--help", "-h":
 		return cmdHelp(ctx)
 
@@ -119,6 +136,12 @@ func cmdBuild(ctx *CommandContext, args []string) error {
 			outputPath = args[i+1]
 			i++
 		}
+		if args[i] == "-D" && i+1 < len(args) {
+			if err := (defineFlag{}).Set(args[i+1]); err != nil {
+				return err
+			}
+			i++
+		}
 	}
 
 	// If not in args, use context output path (from main -o flag)
@@ -170,6 +193,51 @@ func cmdBuild(ctx *CommandContext, args []string) error {
 	return nil
 }
 
+// cmdDoc prints or writes the documentation of a C67 source file
+func cmdDoc(ctx *CommandContext, args []string) error {
+	inputFile := args[0]
+	format := "text"
+	outputPath := ctx.OutputPath
+	for i := 1; i < len(args); i++ {
+		switch {
+		case (args[i] == "--format" || args[i] == "-f") && i+1 < len(args):
+			format = args[i+1]
+			i++
+		case args[i] == "-o" && i+1 < len(args):
+			outputPath = args[i+1]
+			i++
+		}
+	}
+
+	doc, err := documentFile(inputFile)
+	if err != nil {
+		return err
+	}
+	var text string
+	switch format {
+	case "text", "txt":
+		text = doc.Text()
+	case "md", "markdown":
+		text = doc.Markdown()
+	case "html":
+		text = doc.HTML()
+	default:
+		return fmt.Errorf("unknown doc format: %s (use text, md or html)", format)
+	}
+
+	if outputPath == "" {
+		fmt.Print(text)
+		return nil
+	}
+	if err := os.WriteFile(outputPath, []byte(text), 0644); err != nil {
+		return err
+	}
+	if !ctx.Quiet {
+		fmt.Printf("Wrote: %s\n", outputPath)
+	}
+	return nil
+}
+
 // cmdRun compiles a C67 source file to /dev/shm and executes it
 func cmdRun(ctx *CommandContext, args []string) error {
 	if len(args) == 0 {
@@ -541,6 +609,10 @@ COMMANDS:
     build <file.c67>      Compile a C67 source file to an executable
     run <file.c67>        Compile and run a C67 program immediately
     test [directory]      Run all test_*.c67 files (default: current directory)
+    doc <file.c67>        Print the documentation of a module (--format text|md|html)
+    hash <file.c67>...    Print a hash of each program that ignores formatting and comments
+    inspect <exe>...      Print what executables built by c67 use (threads, FFI, network, files)
+    selftest              Compile and run built-in programs for each target (--arch amd64,arm64)
     help                  Show this help message
     version               Show version information
 
@@ -556,7 +628,30 @@ FLAGS (can be used with any command):
     --os <os>              Target OS: linux, darwin, freebsd (default: linux)
     --target <platform>    Target platform: amd64-linux, arm64-macos, etc.
     --opt-timeout <secs>   Optimization timeout in seconds (default: 2.0)
+    --default-loop-max <n> Iteration limit of loops without max (0: max is required)
+    --default-recursion-max <n>  Depth limit of recursive calls without max (0: unlimited)
+    -D NAME[=value]        Define NAME for defined("NAME") and cfg("NAME")
+    --color <when>         Color diagnostics: auto, always, never (NO_COLOR turns auto off)
+    --log-level <level>    Least level logged: debug, info, warn, error, off (default: info)
+    --stack-size <bytes>   Largest stack frame a function may need (default: 1048576)
+    --zbb                  Use Zbb bit manipulation instructions on riscv64
+    --lazy-ffi             Resolve C calls with dlopen/dlsym at their first call (Linux x86-64)
+    -L <dir>               Search <dir> for imported C libraries first (can be repeated)
+    --rpath <dir>          Write <dir> into DT_RPATH, e.g. '$ORIGIN/lib' (can be repeated)
+    --runpath <dir>        Write <dir> into DT_RUNPATH (can be repeated)
+    --gc                   Allocate outside arena blocks from a collected heap (Linux x86-64)
+    --fomit-frame-pointer  No stack frame for lambdas that only do arithmetic (x86-64)
+    --sandbox              Reject system access and install a seccomp filter (Linux x86-64)
+    --entry <name>         Start at this function instead of main (x86-64)
+    --no-main              Freestanding static executable without libc (Linux x86-64)
+    --emit-manifest        Write <output>.manifest.json listing inputs and hashes
+    --alloc <how>          arena, or malloc for Valgrind and ASan (Linux x86-64)
+    --trace-counts         Report calls, cycles and allocations at exit (Linux x86-64)
+    --keep-temps           Write each compilation stage to <output>.temps
+    --emit-header          Write a C header declaring the exported functions
+    --emit-python          Write a Python ctypes module wrapping the exported functions
     -u, --update-deps      Update dependency repositories from Git
+    --offline              Only use Git imports and dependencies from the cache
     -s, --single           Compile single file only (don't load siblings)
 
 EXAMPLES:
@@ -575,6 +670,9 @@ EXAMPLES:
     c67 test
     c67 test ./tests
 
+    # Document a module from its ## comments
+    c67 doc mathlib.c67 --format md -o mathlib.md
+
     # Shebang execution (add #!/usr/bin/c67 to first line of .c67 file)
     chmod +x script.c67
     ./script.c67 arg1 arg2
@@ -6,9 +6,9 @@ import (
 	"fmt"
 	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime/debug"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -65,22 +65,33 @@ type C67Compiler struct {
 	variables            map[string]int                // variable name -> stack offset
 	mutableVars          map[string]bool               // variable name -> is mutable
 	lambdaVars           map[string]bool               // variable name -> is lambda/function
+	arityOverloads       map[string]*arityOverload     // function name -> functions split by argument count
 	parentVariables      map[string]bool               // Track parent-scope vars in parallel loops (use r11 instead of rbp)
+	threadArenaOffset    int                           // rbp offset of the thread arena in a parallel loop body (0 = none)
+	threadArenaMarks     int                           // Frame slots for arena blocks nested in the parallel loop body
+	threadArenaDepth     int                           // Arena blocks currently open in the parallel loop body
+	varArenas            map[string]int                // variable name -> arena level it was defined at
 	varTypes             map[string]string             // variable name -> "map" or "list" (legacy)
 	varTypeInfo          map[string]*C67Type           // variable name -> type annotation (new type system)
+	intVarTypes          map[string]string             // variable name -> integer type for +% and +| (see intarith.go)
 	functionSignatures   map[string]*FunctionSignature // function name -> signature (params, variadic)
 	sourceCode           string                        // Store source for recompilation
 	usedFunctions        map[string]bool               // Track which functions are called
 	unknownFunctions     map[string]bool               // Track functions called but not defined
 	callOrder            []string                      // Track order of function calls
+	usedDataSymbols      []string                      // Data symbols from libraries read through the GOT, such as stdout
 	cImports             map[string]string             // Track C imports: alias -> library name
 	cLibHandles          map[string]string             // Track library handles: library -> handle var name
 	cConstants           map[string]*CHeaderConstants  // Track C constants: alias -> constants
+	cVariables           map[string]map[string]uint64  // Track C data symbols: alias -> name -> size in bytes
+	cFunctionSymbols     map[string][]string           // Track C function symbols: alias -> names the .so exports
 	cFunctionLibs        map[string]string             // Track which library each C function belongs to: function -> library
 	stringCounter        int                           // Counter for unique string labels
 	stackOffset          int                           // Current stack offset for variables (logical)
 	maxStackOffset       int                           // Maximum stack offset reached (for frame allocation)
 	runtimeStack         int                           // Actual runtime stack usage (updated during compilation)
+	runtimeStackPeak     int                           // Deepest runtimeStack of the function being compiled
+	stackOffsetPeak      int                           // Deepest stackOffset of the function being compiled
 	loopBaseOffsets      map[int]int                   // Loop label -> stackOffset before loop body (for state calculation)
 	labelCounter         int                           // Counter for unique labels (if/else, loops, etc)
 	lambdaCounter        int                           // Counter for unique lambda function names
@@ -96,6 +107,38 @@ type C67Compiler struct {
 	cContext             bool                          // When true, compile expressions for C FFI (affects strings, pointers, ints)
 	currentArena         int                           // Current arena index (starts at 1 for global arena = meta-arena[0])
 	usesArenas           bool                          // Track if program uses any arena blocks
+	usesSharedMap        bool                          // Track if program creates any sharedmap() (for runtime helper generation)
+	usesHashMap          bool                          // Track if program uses hash-indexed maps (for runtime helper generation)
+	usesPersistent       bool                          // Track if program uses ilist/imap persistent collections
+	usesFieldnames       bool                          // Track if program calls fieldnames()
+	usesVecMath          bool                          // Track if program calls vector builtins (vadd, dot, mat4mul, ...)
+	usesSliceAssign      bool                          // Track if program writes through a slice (xs[2:5] <- ys)
+	usesCopy             bool                          // Track if program calls copy() or deepcopy()
+	usesFinalizers       bool                          // Track if program calls onfree()
+	coldPaths            []coldPath                    // Error exits emitted after the program code
+	usesIntern           bool                          // Track if program calls intern() or hash() on a runtime string
+	usesLogging          bool                          // Track if program calls logdebug/loginfo/logwarn/logerror
+	usesArgs             bool                          // Track if program calls args() or flags()
+	usesToml             bool                          // Track if program calls parsetoml()
+	usesRun              bool                          // Track if program calls run()
+	usesBigload          bool                          // Track if program calls bigload()
+	usesReduce           map[string]bool               // sum/min/max/mean reductions the program calls on lists
+	usesSorted           bool                          // Track if program calls bsearch() or sortedinsert()
+	usesGrid             bool                          // Track if program uses array2d() grids
+	usesGameloop         bool                          // Track if program calls gameloop()
+	usesDates            bool                          // Track if program calls now(), datefmt() or dateparse()
+	usesSecureRand       bool                          // Track if program calls securerand() or uuid4()
+	usesRuntimeInit      bool                          // Track if the program entry calls _c67_runtime_init
+	runtimeHelpersStart  int                           // Offset in .text where the runtime helpers start (see runtimeinit.go)
+	usesCrashReport      bool                          // Track if the program entry installs the crash handler (see crashreport.go)
+	sdlEasyTypes         map[string]string             // generated sdl.easy function -> C67 type of its result, if not a number
+	usesLazyFFI          bool                          // Track if any C call is resolved at first use (--lazy-ffi)
+	lazyFFILibs          map[string]string             // --lazy-ffi: C library -> label of its shared object name
+	lazyFFISlots         int                           // --lazy-ffi: number of call site cache slots so far
+	usesErrno            bool                          // Track if program calls cerrno() or cerrstr()
+	sharedMapVars        map[string]bool               // variable name -> bound to sharedmap() (updates use the locked insert path)
+	hashMapVars          map[string]bool               // variable name -> mutable map literal with a hash index (see mapindex.go)
+	persistentVars       map[string]bool               // variable name -> bound to ilist/imap/with/without (see persistent.go)
 	arenaStack           []ArenaScope                  // Stack of active arena scopes
 	globalArenaInit      bool                          // Track if global arena has been initialized
 	importedFunctions    []string                      // Track imported C functions (malloc, free, etc.)
@@ -120,6 +163,7 @@ type C67Compiler struct {
 	movedVars         map[string]bool    // Track variables that have been moved (use-after-move detection)
 	inUnsafeBlock     bool               // True when compiling inside an unsafe block (skip safety checks)
 	functionNamespace map[string]string  // function name -> namespace (for imported C67 functions)
+	namespaceAliases  map[string]string  // alias -> namespace, for files imported under two aliases
 	scopeDepth        int                // Track scope depth for proper move tracking
 	scopedMoved       []map[string]bool  // Stack of moved variables per scope
 	errors            *ErrorCollector    // Railway-oriented error collector
@@ -189,8 +233,11 @@ func NewC67Compiler(platform Platform, verbose bool) (*C67Compiler, error) {
 		variables:           make(map[string]int),
 		mutableVars:         make(map[string]bool),
 		lambdaVars:          make(map[string]bool),
+		arityOverloads:      make(map[string]*arityOverload),
 		varTypes:            make(map[string]string),
+		varArenas:           make(map[string]int),
 		varTypeInfo:         make(map[string]*C67Type),
+		intVarTypes:         make(map[string]string),
 		functionSignatures:  make(map[string]*FunctionSignature),
 		usedFunctions:       make(map[string]bool),
 		unknownFunctions:    make(map[string]bool),
@@ -198,10 +245,17 @@ func NewC67Compiler(platform Platform, verbose bool) (*C67Compiler, error) {
 		cImports:            make(map[string]string),
 		cLibHandles:         make(map[string]string),
 		cConstants:          make(map[string]*CHeaderConstants),
+		cVariables:          make(map[string]map[string]uint64),
+		cFunctionSymbols:    make(map[string][]string),
+		sdlEasyTypes:        make(map[string]string),
+		lazyFFILibs:         make(map[string]string),
 		cFunctionLibs:       make(map[string]string),
 		lambdaOffsets:       make(map[string]int),
 		loopBaseOffsets:     make(map[int]int),
 		cacheEnabledLambdas: make(map[string]bool),
+		sharedMapVars:       make(map[string]bool),
+		hashMapVars:         make(map[string]bool),
+		persistentVars:      make(map[string]bool),
 		hotFunctions:        make(map[string]bool),
 		hotFunctionTable:    make(map[string]int),
 		debug:               debugEnabled,
@@ -215,6 +269,7 @@ func NewC67Compiler(platform Platform, verbose bool) (*C67Compiler, error) {
 		scopedMoved:         []map[string]bool{make(map[string]bool)},
 		errors:              NewErrorCollector(10),
 		functionNamespace:   make(map[string]string),
+		namespaceAliases:    make(map[string]string),
 		globalVars:          make(map[string]int),
 		globalVarsMutable:   make(map[string]bool),
 		dataSection:         []byte{},
@@ -327,21 +382,16 @@ func (fc *C67Compiler) processCImports(program *Program) {
 					libSoName += ".so"
 				}
 
-				// Use ldconfig to find the full path
-				ldconfigCmd := exec.Command("ldconfig", "-p")
-				if ldOutput, ldErr := ldconfigCmd.Output(); ldErr == nil {
-					lines := strings.Split(string(ldOutput), "\n")
-					for _, line := range lines {
-						if strings.Contains(line, libSoName) && strings.Contains(line, "=>") {
-							parts := strings.Split(line, "=>")
-							if len(parts) == 2 {
-								cImport.SoPath = strings.TrimSpace(parts[1])
-								if VerboseMode {
-									fmt.Fprintf(os.Stderr, "Resolved %s to %s\n", cImport.Library, cImport.SoPath)
-								}
-								break
-							}
-						}
+				// Look in the -L directories first, then use ldconfig to find the full path
+				if path := findLibraryInSearchPaths(cImport.Library); path != "" {
+					cImport.SoPath = path
+					if VerboseMode {
+						fmt.Fprintf(os.Stderr, "Resolved %s to %s\n", cImport.Library, cImport.SoPath)
+					}
+				} else if paths := ldconfigLibraryPaths(libSoName); len(paths) > 0 {
+					cImport.SoPath = paths[0]
+					if VerboseMode {
+						fmt.Fprintf(os.Stderr, "Resolved %s to %s\n", cImport.Library, cImport.SoPath)
 					}
 				}
 			}
@@ -351,7 +401,13 @@ func (fc *C67Compiler) processCImports(program *Program) {
 				if VerboseMode {
 					fmt.Fprintf(os.Stderr, "Extracting symbols from %s...\n", cImport.SoPath)
 				}
+				if variables, err := ExtractDataSymbolsFromSo(cImport.SoPath); err == nil {
+					fc.cVariables[cImport.Alias] = variables
+				}
 				symbols, err := ExtractSymbolsFromSo(cImport.SoPath)
+				if err == nil && len(symbols) > 0 {
+					fc.cFunctionSymbols[cImport.Alias] = symbols
+				}
 				if err != nil {
 					// Non-fatal: symbol extraction is optional
 					if VerboseMode {
@@ -477,6 +533,9 @@ func (fc *C67Compiler) processCImports(program *Program) {
 			}
 		}
 	}
+
+	// Generate the sdl.easy wrappers from the signatures found above
+	fc.addSDLEasyWrappers(program)
 }
 
 func (fc *C67Compiler) Compile(program *Program, outputPath string) error {
@@ -491,6 +550,9 @@ func (fc *C67Compiler) Compile(program *Program, outputPath string) error {
 	if program.FunctionNamespaces != nil {
 		fc.functionNamespace = program.FunctionNamespaces
 	}
+	if program.NamespaceAliases != nil {
+		fc.namespaceAliases = program.NamespaceAliases
+	}
 
 	if fc.debug {
 		if VerboseMode {
@@ -498,6 +560,19 @@ func (fc *C67Compiler) Compile(program *Program, outputPath string) error {
 		}
 	}
 
+	// --sandbox rejects what reaches the system directly (see sandbox.go)
+	if SandboxFlag {
+		if err := fc.checkSandbox(program); err != nil {
+			return err
+		}
+	}
+	fc.eb.capabilities = programCapabilities(program)
+
+	// --entry and --no-main need the function to start at (see entry.go)
+	if err := fc.checkEntry(program); err != nil {
+		return err
+	}
+
 	// Pre-pass: Collect C imports to set up library handles and extract constants
 	// This MUST happen before architecture-specific compilation
 	fc.processCImports(program)
@@ -517,10 +592,17 @@ func (fc *C67Compiler) Compile(program *Program, outputPath string) error {
 		return fc.compileRiscv64(program, outputPath)
 	}
 
+	// Split lambdas with clauses for several argument counts into one function per count
+	statements, err := fc.splitArityOverloads(program.Statements)
+	if err != nil {
+		return err
+	}
+	program.Statements = statements
+
 	// Add format strings for printf
 	fc.eb.Define("fmt_str", "%s\x00")
 	fc.eb.Define("fmt_int", "%ld\n\x00")
-	fc.eb.Define("fmt_float", "%.0f\n\x00") // Print float without decimal places
+	fc.eb.Define("fmt_float", "%.17g\n\x00") // Print float with enough digits to round-trip
 	fc.eb.Define("_str_debug_default_arena", "DEBUG: Initializing default arena\n\x00")
 	fc.eb.Define("_str_debug_arena_value", "DEBUG: Arena pointer value: %p\n\x00")
 	fc.eb.Define("_loop_max_exceeded_msg", "Error: loop exceeded maximum iterations\n\x00")
@@ -528,14 +610,24 @@ func (fc *C67Compiler) Compile(program *Program, outputPath string) error {
 	fc.eb.Define("_null_ptr_msg", "ERROR: Null pointer dereference detected\n\x00")
 	fc.eb.Define("_bounds_negative_msg", "ERROR: Array index out of bounds (index < 0)\n\x00")
 	fc.eb.Define("_bounds_too_large_msg", "ERROR: Array index out of bounds (index >= length)\n\x00")
+	fc.eb.Define("_slice_assign_length_msg", sliceAssignLengthMsg+"\x00")
+	fc.eb.Define("_slice_assign_bounds_msg", sliceAssignBoundsMsg+"\x00")
 	fc.eb.Define("_malloc_failed_msg", "ERROR: Memory allocation failed (out of memory)\n\x00")
 
 	// Define arena metadata in .data section
 	// Only store POINTERS here, actual arena buffers are malloc'd
 	// Meta-arena: array of pointers to arena structs
-	fc.eb.DefineWritable("_c67_arena_meta", "\x00\x00\x00\x00\x00\x00\x00\x00")     // Pointer to arena array
-	fc.eb.DefineWritable("_c67_arena_meta_cap", "\x00\x00\x00\x00\x00\x00\x00\x00") // Capacity (number of slots)
-	fc.eb.DefineWritable("_c67_arena_meta_len", "\x00\x00\x00\x00\x00\x00\x00\x00") // Length (number of active arenas)
+	fc.eb.DefineWritable("_c67_arena_meta", "\x00\x00\x00\x00\x00\x00\x00\x00")      // Pointer to arena array
+	fc.eb.DefineWritable("_c67_arena_meta_cap", "\x00\x00\x00\x00\x00\x00\x00\x00")  // Capacity (number of slots)
+	fc.eb.DefineWritable("_c67_arena_meta_len", "\x00\x00\x00\x00\x00\x00\x00\x00")  // Length (number of active arenas)
+	fc.eb.DefineWritable("_c67_arena_meta_lock", "\x00\x00\x00\x00\x00\x00\x00\x00") // Spinlock held while the meta-arena grows
+
+	// Number formatting and buffered stdout need their buffers laid out in the first pass
+	fc.eb.DefineWritable("_dtoa_buffer", string(make([]byte, dtoaBufferSize)))
+	fc.eb.DefineWritable("_dtoa_bignums", string(make([]byte, dtoaBigSize)))
+	if fc.eb.target.OS() == OSLinux {
+		fc.eb.DefineWritable("_c67_stdout", string(make([]byte, stdoutDataOffset+stdoutBufferSize)))
+	}
 	fc.eb.Define("_arena_null_error", "ERROR: Arena alloc returned NULL\n\x00")
 	fc.eb.Define("_str_arena_ptr_fmt", "arena_alloc: arena_ptr=%p\n\x00")
 	fc.eb.Define("_str_alloc_loading_arena", "alloc: loading arena pointer\n\x00")
@@ -545,59 +637,27 @@ func (fc *C67Compiler) Compile(program *Program, outputPath string) error {
 	fc.eb.Define("_str_capacity_value", "current capacity=%ld\n\x00")
 	fc.eb.Define("_count_mismatch_error", "ERROR: Count write/read mismatch!\n\x00")
 
+	// Save argc and argv for args() and flags() before any register is touched
+	fc.emitSaveProgramArgs()
+
+	// With --gc, reserve the collected heap before anything is allocated
+	fc.emitGCInit()
+	fc.emitMallocAllocInit()
+	fc.emitTraceCountsInit()
+
+	// With --sandbox, install the seccomp filter before the first statement
+	fc.emitSandboxInit()
+
+	// Report crashes with the function and the registers
+	fc.emitCrashReportInit()
+
 	// Initialize registers at entry (where _start jumps to)
 	fc.out.XorRegWithReg("rax", "rax")
 	fc.out.XorRegWithReg("rdi", "rdi")
 	fc.out.XorRegWithReg("rsi", "rsi")
 
-	// ===== CPU FEATURE DETECTION =====
 	// Detect FMA, AVX2, POPCNT, and AVX-512 support at runtime
-	// This enables dynamic optimization for available CPU features
-
-	fc.eb.DefineWritable("cpu_has_fma", "\x00")    // FMA3 support (Haswell 2013+)
-	fc.eb.DefineWritable("cpu_has_avx2", "\x00")   // AVX2 support (Haswell 2013+)
-	fc.eb.DefineWritable("cpu_has_popcnt", "\x00") // POPCNT support (Nehalem 2008+)
-	fc.eb.DefineWritable("cpu_has_avx512", "\x00") // AVX-512F support (Skylake-X 2017+)
-
-	// Check CPUID leaf 1 for FMA and POPCNT
-	fc.out.MovImmToReg("rax", "1")     // CPUID leaf 1
-	fc.out.XorRegWithReg("rcx", "rcx") // subleaf 0
-	fc.out.Emit([]byte{0x0f, 0xa2})    // cpuid
-
-	// Test ECX bit 12 (FMA)
-	fc.out.Emit([]byte{0x0f, 0xba, 0xe1, 0x0c}) // bt ecx, 12
-	fc.out.Emit([]byte{0x0f, 0x92, 0xc0})       // setc al
-	fc.out.LeaSymbolToReg("rbx", "cpu_has_fma")
-	fc.out.MovByteRegToMem("rax", "rbx", 0)
-
-	// Test ECX bit 23 (POPCNT)
-	fc.out.Emit([]byte{0x0f, 0xba, 0xe1, 0x17}) // bt ecx, 23
-	fc.out.Emit([]byte{0x0f, 0x92, 0xc0})       // setc al
-	fc.out.LeaSymbolToReg("rbx", "cpu_has_popcnt")
-	fc.out.MovByteRegToMem("rax", "rbx", 0)
-
-	// Check CPUID leaf 7 for AVX2 and AVX-512
-	fc.out.MovImmToReg("rax", "7")     // CPUID leaf 7
-	fc.out.XorRegWithReg("rcx", "rcx") // subleaf 0
-	fc.out.Emit([]byte{0x0f, 0xa2})    // cpuid
-
-	// Test EBX bit 5 (AVX2)
-	fc.out.Emit([]byte{0x0f, 0xba, 0xe3, 0x05}) // bt ebx, 5
-	fc.out.Emit([]byte{0x0f, 0x92, 0xc0})       // setc al
-	fc.out.LeaSymbolToReg("rbx", "cpu_has_avx2")
-	fc.out.MovByteRegToMem("rax", "rbx", 0)
-
-	// Test EBX bit 16 (AVX512F - foundation)
-	fc.out.Emit([]byte{0x0f, 0xba, 0xe3, 0x10}) // bt ebx, 16
-	fc.out.Emit([]byte{0x0f, 0x92, 0xc0})       // setc al
-	fc.out.LeaSymbolToReg("rbx", "cpu_has_avx512")
-	fc.out.MovByteRegToMem("rax", "rbx", 0)
-
-	// Clear registers used for CPUID
-	fc.out.XorRegWithReg("rax", "rax")
-	fc.out.XorRegWithReg("rbx", "rbx")
-	fc.out.XorRegWithReg("rcx", "rcx")
-	// ===== END CPU FEATURE DETECTION =====
+	fc.emitRuntimeInit()
 
 	// Two-pass compilation: First pass collects all variable declarations
 	// so that function/constant order doesn't matter
@@ -634,8 +694,11 @@ func (fc *C67Compiler) Compile(program *Program, outputPath string) error {
 	// Subtract 8 more bytes to reach 32 bytes (16-byte aligned)
 	fc.out.SubImmFromReg("rsp", 8)
 
+	// Return address, rbp, rbx and alignment
+	topLevelFrame := 32
 	if fc.maxStackOffset > 0 {
 		alignedSize := int64((fc.maxStackOffset + 15) & ^15)
+		topLevelFrame += int(alignedSize)
 		if VerboseMode {
 			fmt.Fprintf(os.Stderr, "Allocating %d bytes of stack space (maxStackOffset=%d)\n", alignedSize, fc.maxStackOffset)
 		}
@@ -654,6 +717,7 @@ func (fc *C67Compiler) Compile(program *Program, outputPath string) error {
 			fmt.Fprintf(os.Stderr, "DEBUG:   Statement %d: %T\n", i, stmt)
 		}
 	}
+	fc.startStackFrame()
 	for i, stmt := range program.Statements {
 		if VerboseMode {
 			fmt.Fprintf(os.Stderr, "DEBUG: About to compile statement %d: %T\n", i, stmt)
@@ -663,6 +727,7 @@ func (fc *C67Compiler) Compile(program *Program, outputPath string) error {
 			fmt.Fprintf(os.Stderr, "DEBUG: Finished compiling statement %d\n", i)
 		}
 	}
+	fc.checkStackFrame("", topLevelFrame)
 
 	fc.popDeferScope()
 
@@ -673,6 +738,9 @@ func (fc *C67Compiler) Compile(program *Program, outputPath string) error {
 
 	// Generate lambda functions here (before exit, but jumped over)
 	fc.generateLambdaFunctions()
+	// Pattern lambda bodies are placed after exit in the final pass; compiling
+	// them here registers the memo caches and constants they use
+	fc.generatePatternLambdaFunctions()
 
 	// Patch the jump to skip over lambdas
 	skipLambdasTarget := fc.eb.text.Len()
@@ -680,15 +748,17 @@ func (fc *C67Compiler) Compile(program *Program, outputPath string) error {
 
 	// Evaluate main (if it exists) to get the exit code BEFORE cleaning up arenas
 	// main can be a direct value (main = 42) or a function (main = { 42 })
-	_, exists := fc.variables["main"]
+	// (or the function named with --entry, see entry.go)
+	entry := entryName()
+	_, exists := fc.variables[entry]
 	if exists {
 		// main exists - check if it's a lambda/function or a direct value
-		if fc.lambdaVars["main"] {
+		if fc.lambdaVars[entry] {
 			// main is a lambda/function - call it with no arguments
-			fc.compileExpression(&CallExpr{Function: "main", Args: []Expression{}})
+			fc.compileExpression(&CallExpr{Function: entry, Args: []Expression{}})
 		} else {
 			// main is a direct value - just load it
-			fc.compileExpression(&IdentExpr{Name: "main"})
+			fc.compileExpression(&IdentExpr{Name: entry})
 		}
 		// Result is in xmm0 (float64)
 	} else {
@@ -704,6 +774,7 @@ func (fc *C67Compiler) Compile(program *Program, outputPath string) error {
 	}
 
 	// Save exit code on stack before cleanup (rdi will be clobbered by munmap syscalls)
+	fc.emitTraceReport()
 	fc.out.PushReg("rdi")
 
 	// Cleanup all arenas in meta-arena at program exit
@@ -733,11 +804,14 @@ func (fc *C67Compiler) Compile(program *Program, outputPath string) error {
 	if needsLibcExit {
 		// Use libc's exit() for proper cleanup (flushes buffers)
 		// Exit code is already in rdi (first argument)
+		fc.emitFlushStdout()
 		fc.trackFunctionCall("exit")
 		fc.eb.GenerateCallInstruction("exit")
 	} else {
 		// Use direct syscall exit on Linux (works with syscall-based printf)
-		fc.out.MovImmToReg("rax", "60") // syscall number for exit
+		fc.emitFlushStdout()
+		fc.out.MovImmToReg("rax", "231") // syscall number for exit_group
+		// exit_group also stops @@ worker threads still leaving their barrier
 		// exit code is already in rdi (first syscall argument)
 		fc.eb.Emit("syscall") // invoke syscall directly
 	}
@@ -766,8 +840,12 @@ func (fc *C67Compiler) Compile(program *Program, outputPath string) error {
 		}
 		return fc.writePE(program, outputPath)
 	} else if fc.eb.target.IsMachO() {
-		// MachO is handled in ARM64 codegen path above
-		return fmt.Errorf("MachO should be handled by ARM64 code generator")
+		// arm64 Mach-O is handled in the ARM64 codegen path above
+		return fmt.Errorf("Mach-O executables can only be written for arm64 so far")
+	}
+
+	if NoMainFlag {
+		return fc.writeFreestandingELF(program, outputPath)
 	}
 
 	// Default: Write ELF using existing infrastructure
@@ -784,6 +862,9 @@ func (fc *C67Compiler) updateStackOffset(delta int) {
 	if fc.stackOffset > fc.maxStackOffset {
 		fc.maxStackOffset = fc.stackOffset
 	}
+	if fc.stackOffset > fc.stackOffsetPeak {
+		fc.stackOffsetPeak = fc.stackOffset
+	}
 }
 
 // Confidence that this function is working: 95%
@@ -792,6 +873,7 @@ func (fc *C67Compiler) collectSymbols(stmt Statement) error {
 	case *AssignStmt:
 		// Check if variable already exists
 		_, exists := fc.variables[s.Name]
+		fc.trackPersistent(s)
 
 		if VerboseMode {
 			fmt.Fprintf(os.Stderr, "DEBUG collectSymbols AssignStmt: name=%s, exists=%v, IsUpdate=%v, Mutable=%v, mutableVars[%s]=%v, stackOffset=%d\n",
@@ -814,6 +896,8 @@ func (fc *C67Compiler) collectSymbols(stmt Statement) error {
 			if !isMutable {
 				return fmt.Errorf("cannot update immutable variable '%s' (use <- only for mutable variables)", s.Name)
 			}
+			fc.untrackHashMap(s)
+			fc.trackSharedMap(s)
 		} else if s.Mutable {
 			if exists {
 				return fmt.Errorf("variable '%s' already defined (use <- to update) [currently at offset %d]", s.Name, fc.variables[s.Name])
@@ -856,26 +940,42 @@ func (fc *C67Compiler) collectSymbols(stmt Statement) error {
 				}
 			}
 
-			// Track type if we can determine it from the expression
+			// Track type if we can determine it from the expression or annotation
 			exprType := fc.getExprType(s.Value)
+			if s.TypeAnnotation != nil && s.TypeAnnotation.ExprType() != "" {
+				exprType = s.TypeAnnotation.ExprType()
+			}
 			if exprType != "number" && exprType != "unknown" {
 				fc.varTypes[s.Name] = exprType
 				if VerboseMode {
 					fmt.Fprintf(os.Stderr, "DEBUG: Setting varTypes[%s] = %s (mutable)\n", s.Name, exprType)
 				}
 			}
+			if intType := fc.intTypeOf(s.Value); intType != "" {
+				fc.intVarTypes[s.Name] = intType
+			}
 
 			// Track if this is a lambda/function
 			switch s.Value.(type) {
 			case *LambdaExpr, *PatternLambdaExpr, *MultiLambdaExpr:
 				fc.lambdaVars[s.Name] = true
 			}
+
+			// Track shared maps so that name[key] <- value is safe across @@ threads
+			fc.trackSharedMap(s)
+
+			// Track map literals that can grow, so m[key] uses the hash index
+			if _, ok := s.Value.(*MapExpr); ok {
+				fc.hashMapVars[s.Name] = true
+			}
 		} else {
 			// = - Define immutable variable (can shadow existing immutable, but not mutable)
 			if exists && fc.mutableVars[s.Name] {
 				// Allow updating existing mutable variable with =
 				// Don't create new variable, reuse existing offset
 				s.IsReuseMutable = true
+				fc.untrackHashMap(s)
+				fc.trackSharedMap(s)
 			} else {
 				// Create new immutable variable
 
@@ -916,8 +1016,11 @@ func (fc *C67Compiler) collectSymbols(stmt Statement) error {
 					}
 				}
 
-				// Track type if we can determine it from the expression
+				// Track type if we can determine it from the expression or annotation
 				exprType := fc.getExprType(s.Value)
+				if s.TypeAnnotation != nil && s.TypeAnnotation.ExprType() != "" {
+					exprType = s.TypeAnnotation.ExprType()
+				}
 				if fc.debug {
 					fmt.Fprintf(os.Stderr, "DEBUG TYPE TRACKING: var=%s, exprType=%s, Value type=%T\n", s.Name, exprType, s.Value)
 				}
@@ -927,12 +1030,18 @@ func (fc *C67Compiler) collectSymbols(stmt Statement) error {
 						fmt.Fprintf(os.Stderr, "DEBUG: Setting varTypes[%s] = %s (immutable)\n", s.Name, exprType)
 					}
 				}
+				if intType := fc.intTypeOf(s.Value); intType != "" {
+					fc.intVarTypes[s.Name] = intType
+				}
 
 				// Track if this is a lambda/function
 				switch s.Value.(type) {
 				case *LambdaExpr, *PatternLambdaExpr, *MultiLambdaExpr:
 					fc.lambdaVars[s.Name] = true
 				}
+
+				// A new immutable binding shadows any shared map of the same name
+				delete(fc.sharedMapVars, s.Name)
 			}
 		}
 	case *MultipleAssignStmt:
@@ -940,6 +1049,7 @@ func (fc *C67Compiler) collectSymbols(stmt Statement) error {
 		// Each variable needs stack space
 		for _, name := range s.Names {
 			_, exists := fc.variables[name]
+			delete(fc.sharedMapVars, name)
 
 			if s.IsUpdate {
 				// Update operation (<-) requires existing mutable variables
@@ -1000,6 +1110,9 @@ func (fc *C67Compiler) collectSymbols(stmt Statement) error {
 				// Allocate maximum space needed (with stack counter)
 				fc.updateStackOffset(40)
 			}
+		} else if s.KeyIterator != "" {
+			// List/map loop state plus the key variable
+			fc.updateStackOffset(80)
 		} else {
 			fc.updateStackOffset(64)
 		}
@@ -1220,6 +1333,9 @@ func (fc *C67Compiler) collectLoopsFromExpression(expr Expression) {
 	case *PostfixExpr:
 		fc.collectLoopsFromExpression(e.Operand)
 
+	case *TryExpr:
+		fc.collectLoopsFromExpression(e.Expr)
+
 	case *CastExpr:
 		fc.collectLoopsFromExpression(e.Expr)
 
@@ -1311,6 +1427,23 @@ func (fc *C67Compiler) isExpressionPure(expr Expression, pureFunctions map[strin
 	}
 }
 
+// compileAssignValue compiles the value of the assignment s into xmm0 and
+// copies it out of the arena blocks opened since the variable was defined.
+// A map literal for a hash-indexed variable is built in the arena of the
+// variable instead.
+func (fc *C67Compiler) compileAssignValue(s *AssignStmt) {
+	isUpdate := s.IsUpdate || s.IsReuseMutable
+	if fc.isHashMapAssign(s) {
+		fc.compileEscapingStore(s.Name, nil, isUpdate)
+		fc.compileHashMapLiteral(s.Name, s.Value.(*MapExpr))
+		return
+	}
+	fc.currentAssignName = s.Name
+	fc.compileExpression(s.Value)
+	fc.currentAssignName = ""
+	fc.compileEscapingStore(s.Name, s.Value, isUpdate)
+}
+
 // Confidence that this function is working: 100%
 func (fc *C67Compiler) compileStatement(stmt Statement) {
 	switch s := stmt.(type) {
@@ -1324,9 +1457,7 @@ func (fc *C67Compiler) compileStatement(stmt Statement) {
 		// Check if it's a global variable
 		if _, isGlobal := fc.globalVars[s.Name]; isGlobal {
 			// Compile the value
-			fc.currentAssignName = s.Name
-			fc.compileExpression(s.Value)
-			fc.currentAssignName = ""
+			fc.compileAssignValue(s)
 			// Store to .data section
 			// lea rax, [rel _global_varname]
 			// movsd [rax], xmm0
@@ -1344,12 +1475,10 @@ func (fc *C67Compiler) compileStatement(stmt Statement) {
 			// so we don't need a special check here
 			if !s.IsUpdate && !s.IsReuseMutable {
 				fc.out.SubImmFromReg("rsp", 16)
-				fc.runtimeStack += 16
+				fc.growRuntimeStack(16)
 			}
 
-			fc.currentAssignName = s.Name
-			fc.compileExpression(s.Value)
-			fc.currentAssignName = ""
+			fc.compileAssignValue(s)
 			// Use r11 for parent variables in parallel loops, rbp for local variables
 			baseReg := "rbp"
 			if fc.parentVariables != nil && fc.parentVariables[s.Name] {
@@ -1368,11 +1497,11 @@ func (fc *C67Compiler) compileStatement(stmt Statement) {
 		if !s.IsUpdate && !s.Mutable {
 			// Immutable new variables - allocate runtime space
 			fc.out.SubImmFromReg("rsp", int64(len(s.Names)*16))
-			fc.runtimeStack += len(s.Names) * 16
+			fc.growRuntimeStack(len(s.Names) * 16)
 		} else if !s.IsUpdate && s.Mutable {
 			// Mutable new variables - allocate runtime space
 			fc.out.SubImmFromReg("rsp", int64(len(s.Names)*16))
-			fc.runtimeStack += len(s.Names) * 16
+			fc.growRuntimeStack(len(s.Names) * 16)
 		}
 		// Updates reuse existing stack space, no allocation needed
 
@@ -1426,6 +1555,9 @@ func (fc *C67Compiler) compileStatement(stmt Statement) {
 		// Clean up list pointer from stack
 		fc.out.AddImmToReg("rsp", 8)
 
+	case *SliceUpdateStmt:
+		fc.compileSliceUpdate(s)
+
 	case *MapUpdateStmt:
 		// List/map element update: arr[idx] <- value
 		// For lists (linked lists): Creates new list with updated element
@@ -1461,6 +1593,10 @@ func (fc *C67Compiler) compileStatement(stmt Statement) {
 			}
 		}
 
+		if fc.persistentVars[s.MapName] {
+			compilerError("cannot update persistent collection '%s' in place, use with(%s, key, value)", s.MapName, s.MapName)
+		}
+
 		// Check mutability (check both local and global)
 		isMutable := fc.mutableVars[s.MapName] || fc.globalVarsMutable[s.MapName]
 		if !isMutable {
@@ -1486,6 +1622,7 @@ func (fc *C67Compiler) compileStatement(stmt Statement) {
 
 			// Compile value expression -> xmm0
 			fc.compileExpression(s.Value)
+			fc.compileEscapingStore(s.MapName, s.Value, true)
 			// Save value to stack
 			fc.out.SubImmFromReg("rsp", 8)
 			fc.out.MovXmmToMem("xmm0", "rsp", 0)
@@ -1530,6 +1667,37 @@ func (fc *C67Compiler) compileStatement(stmt Statement) {
 
 			// Clean up stack (index + value)
 			fc.out.AddImmToReg("rsp", 16)
+		} else if fc.sharedMapVars[s.MapName] {
+			// SHARED MAP UPDATE: insert or overwrite by key, safe across @@ threads
+			// Calls _c67_sharedmap_set(rdi=map_ptr, xmm0=key, xmm1=value)
+
+			// Compile key expression -> xmm0 and save to stack
+			fc.compileExpression(s.Index)
+			fc.out.SubImmFromReg("rsp", 16)
+			fc.out.MovXmmToMem("xmm0", "rsp", 0)
+
+			// Compile value expression -> xmm0
+			fc.compileExpression(s.Value)
+			fc.out.MovXmmToXmm("xmm1", "xmm0") // xmm1 = value
+
+			// Load map pointer from variable
+			if isGlobal {
+				fc.out.LeaSymbolToReg("rax", "_global_"+s.MapName)
+				fc.out.MovMemToXmm("xmm2", "rax", 0)
+			} else {
+				fc.out.MovMemToXmm("xmm2", baseReg, -offset)
+			}
+			fc.out.MovqXmmToReg("rdi", "xmm2") // rdi = map pointer
+
+			// Restore key into xmm0 and clean up stack
+			fc.out.MovMemToXmm("xmm0", "rsp", 0)
+			fc.out.AddImmToReg("rsp", 16)
+
+			fc.trackFunctionCall("_c67_sharedmap_set")
+			fc.eb.GenerateCallInstruction("_c67_sharedmap_set")
+		} else if fc.hashMapVars[s.MapName] {
+			// HASH MAP UPDATE: insert or overwrite by key through the index
+			fc.compileHashMapSet(s, isGlobal, baseReg, offset)
 		} else {
 			// MAP UPDATE: In-place modification
 			// Memory layout: [length (float64)] [key1] [val1] [key2] [val2] ...
@@ -1542,6 +1710,7 @@ func (fc *C67Compiler) compileStatement(stmt Statement) {
 
 			// Compile value expression -> xmm0
 			fc.compileExpression(s.Value)
+			fc.compileEscapingStore(s.MapName, s.Value, true)
 			// Save value to stack
 			fc.out.SubImmFromReg("rsp", 8)
 			fc.out.MovXmmToMem("xmm0", "rsp", 0)
@@ -1728,6 +1897,12 @@ func (fc *C67Compiler) compileArenaStmt(stmt *ArenaStmt) {
 	// Mark that this program uses arenas
 	fc.usesArenas = true
 
+	// In a parallel loop body, the block borrows the thread arena instead
+	if fc.threadArenaOffset != 0 {
+		fc.compileThreadArenaBlock(stmt.Body, false)
+		return
+	}
+
 	// Save previous arena context and increment to next arena
 	previousArena := fc.currentArena
 	fc.currentArena++
@@ -1775,6 +1950,22 @@ func (fc *C67Compiler) compileArenaExpr(expr *ArenaExpr) {
 		}
 	}
 
+	// At module level, the variables of the block are globals that were not
+	// known when the .data section was filled in
+	if fc.currentLambda == nil {
+		for _, stmt := range expr.Body {
+			if assign, ok := stmt.(*AssignStmt); ok && fc.variables[assign.Name] == -1 {
+				fc.eb.DefineWritable("_global_"+assign.Name, "\x00\x00\x00\x00\x00\x00\x00\x00")
+			}
+		}
+	}
+
+	// In a parallel loop body, the block borrows the thread arena instead
+	if fc.threadArenaOffset != 0 {
+		fc.compileThreadArenaBlock(expr.Body, true)
+		return
+	}
+
 	// Save previous arena context and increment to next arena
 	previousArena := fc.currentArena
 	fc.currentArena++
@@ -1810,6 +2001,9 @@ func (fc *C67Compiler) compileArenaExpr(expr *ArenaExpr) {
 	// Restore previous arena context
 	fc.currentArena = previousArena
 
+	// The result outlives the block, so it moves to the parent arena
+	fc.copyOutOfArena(fc.currentArena, arenaResult(expr.Body), "the value of the arena expression")
+
 	// Reset arena
 	fc.out.LeaSymbolToReg("rbx", "_c67_arena_meta")
 	fc.out.MovMemToReg("rbx", "rbx", 0)
@@ -1820,6 +2014,9 @@ func (fc *C67Compiler) compileArenaExpr(expr *ArenaExpr) {
 }
 
 func (fc *C67Compiler) compileSpawnStmt(stmt *SpawnStmt) {
+	// Flush buffered output so the child does not print it a second time
+	fc.emitFlushStdout()
+
 	// Call fork() syscall (57 on x86-64 Linux)
 	// Returns: child gets 0 in rax, parent gets child PID in rax
 	fc.out.MovImmToReg("rax", "57") // fork syscall number
@@ -1859,6 +2056,7 @@ func (fc *C67Compiler) compileSpawnStmt(stmt *SpawnStmt) {
 	fc.out.MovImmToReg("rdi", "0") // NULL = 0
 	fc.trackFunctionCall("fflush")
 	fc.eb.GenerateCallInstruction("fflush")
+	fc.emitFlushStdout()
 
 	// Exit child process with status 0
 	fc.out.MovImmToReg("rax", "60") // exit syscall number
@@ -1891,6 +2089,9 @@ func (fc *C67Compiler) compileLoopStatement(stmt *LoopStmt) {
 	// Sequential loop
 	// Check if iterating over a range expression (0..<10, 0..=10)
 	if rangeExpr, isRange := stmt.Iterable.(*RangeExpr); isRange {
+		if stmt.KeyIterator != "" {
+			compilerError("@ %s, %s in ... needs a list or map, not a range", stmt.KeyIterator, stmt.Iterator)
+		}
 		// Range loop (lazy iteration)
 		fc.compileRangeLoop(stmt, rangeExpr)
 	} else {
@@ -1936,6 +2137,7 @@ func (fc *C67Compiler) compileWhileStatement(stmt *WhileStmt) {
 	}
 
 	// Mark loop start - record position for back jump
+	fc.alignLoopHeader()
 	loopStartPos := fc.eb.text.Len()
 
 	// Push loop info for break/continue handling
@@ -1989,6 +2191,8 @@ func (fc *C67Compiler) compileWhileStatement(stmt *WhileStmt) {
 	fc.activeLoops[len(fc.activeLoops)-1].EndPatches = append(
 		fc.activeLoops[len(fc.activeLoops)-1].EndPatches, maxCheckJumpPos)
 
+	fc.emitSafepoint()
+
 	// Jump back to loop start
 	currentPos := fc.eb.text.Len()
 	backOffset := int32(loopStartPos - (currentPos + 5)) // 5 bytes for JMP instruction
@@ -2100,7 +2304,7 @@ func (fc *C67Compiler) compileRangeLoop(stmt *LoopStmt, rangeExpr *RangeExpr) {
 	}
 
 	fc.out.SubImmFromReg("rsp", stackSize)
-	fc.runtimeStack += int(stackSize) // Track runtime allocation
+	fc.growRuntimeStack(int(stackSize)) // Track runtime allocation
 
 	// Initialize max iterations (after stack allocation)
 	if stmt.NeedsMaxCheck {
@@ -2123,9 +2327,14 @@ func (fc *C67Compiler) compileRangeLoop(stmt *LoopStmt, rangeExpr *RangeExpr) {
 	// Evaluate range end and store on stack (limit)
 	fc.compileExpression(rangeExpr.End)
 	fc.out.Cvttsd2si("rax", "xmm0") // rax = loop limit
-	// For inclusive ranges (..=), increment end by 1
+	// For inclusive ranges, move the limit one past end so the exit test is strict
+	step := rangeExpr.StepValue()
 	if rangeExpr.Inclusive {
-		fc.out.IncReg("rax")
+		if step < 0 {
+			fc.out.SubImmFromReg("rax", 1)
+		} else {
+			fc.out.IncReg("rax")
+		}
 	}
 	// Store limit on stack at rbp-limitOffset
 	fc.out.MovRegToMem("rax", "rbp", -limitOffset)
@@ -2140,6 +2349,7 @@ func (fc *C67Compiler) compileRangeLoop(stmt *LoopStmt, rangeExpr *RangeExpr) {
 	fc.mutableVars[stmt.Iterator] = true
 
 	// Loop start label - this is where we jump back to
+	fc.alignLoopHeader()
 	loopStartPos := fc.eb.text.Len()
 
 	// Reset iteration counter to 0 at loop start (critical for nested loops)
@@ -2161,107 +2371,108 @@ func (fc *C67Compiler) compileRangeLoop(stmt *LoopStmt, rangeExpr *RangeExpr) {
 	}
 	fc.activeLoops = append(fc.activeLoops, loopInfo)
 
-	// Runtime max iteration checking (only if needed)
-	if stmt.NeedsMaxCheck {
-		// Check max iterations (if not infinite)
-		if stmt.MaxIterations != math.MaxInt64 {
-			// Load iteration count
-			fc.out.MovMemToReg("rax", "rbp", -iterationCountOffset)
-			// Load max iterations
-			fc.out.MovMemToReg("rcx", "rbp", -maxIterOffset)
-			// Compare: if iteration_count >= max_iterations, exceeded limit
-			fc.out.CmpRegToReg("rax", "rcx")
-
-			// Jump past error handling if not exceeded
-			notExceededJumpPos := fc.eb.text.Len()
-			fc.out.JumpConditional(JumpLess, 0) // Placeholder, will patch
-
-			// Exceeded max iterations - print error and exit
-			// printf("Error: Loop exceeded max iterations\n")
-			fc.out.LeaSymbolToReg("rdi", "_loop_max_exceeded_msg")
-			fc.trackFunctionCall("printf")
-			fc.eb.GenerateCallInstruction("printf")
-
-			// exit(1)
-			fc.out.MovImmToReg("rdi", "1")
-			fc.trackFunctionCall("exit")
-			fc.eb.GenerateCallInstruction("exit")
+	// unroll N compiles the body N times before jumping back, each copy
+	// with its own checks and step (see unroll.go)
+	copies := max(stmt.Unroll, 1)
+	for copyIndex := 0; copyIndex < copies; copyIndex++ {
+		// Runtime max iteration checking (only if needed)
+		if stmt.NeedsMaxCheck {
+			// Check max iterations (if not infinite)
+			if stmt.MaxIterations != math.MaxInt64 {
+				// Load iteration count
+				fc.out.MovMemToReg("rax", "rbp", -iterationCountOffset)
+				// Load max iterations
+				fc.out.MovMemToReg("rcx", "rbp", -maxIterOffset)
+				// Compare: if iteration_count >= max_iterations, exceeded limit
+				fc.out.CmpRegToReg("rax", "rcx")
+
+				// Exceeded max iterations - print error and exit, out of line
+				fc.emitColdAbort(JumpGreaterOrEqual, "_loop_max_exceeded_msg", "Error: loop exceeded maximum iterations\n")
+			}
 
-			// Patch the jump to skip error handling
-			notExceededPos := fc.eb.text.Len()
-			notExceededOffset := int32(notExceededPos - (notExceededJumpPos + 6))
-			fc.patchJumpImmediate(notExceededJumpPos+2, notExceededOffset)
+			// Increment iteration counter
+			fc.out.MovMemToReg("rax", "rbp", -iterationCountOffset)
+			fc.out.IncReg("rax")
+			fc.out.MovRegToMem("rax", "rbp", -iterationCountOffset)
 		}
 
-		// Increment iteration counter
-		fc.out.MovMemToReg("rax", "rbp", -iterationCountOffset)
-		fc.out.IncReg("rax")
-		fc.out.MovRegToMem("rax", "rbp", -iterationCountOffset)
-	}
+		// Compare counter with limit
+		fc.out.MovMemToReg("rax", "rbp", -limitOffset) // Load limit
 
-	// Compare counter with limit
-	fc.out.MovMemToReg("rax", "rbp", -limitOffset) // Load limit
+		if useRegister {
+			fc.out.CmpRegToReg(counterReg, "rax")
+		} else {
+			fc.out.MovMemToReg("rcx", "rbp", -counterOffset) // Load counter from stack
+			fc.out.CmpRegToReg("rcx", "rax")
+		}
 
-	if useRegister {
-		fc.out.CmpRegToReg(counterReg, "rax")
-	} else {
-		fc.out.MovMemToReg("rcx", "rbp", -counterOffset) // Load counter from stack
-		fc.out.CmpRegToReg("rcx", "rax")
-	}
+		// Jump to loop end if counter >= limit (counter <= limit when counting down)
+		loopEndJumpPos := fc.eb.text.Len()
+		if step < 0 {
+			fc.out.JumpConditional(JumpLessOrEqual, 0) // Placeholder
+		} else {
+			fc.out.JumpConditional(JumpGreaterOrEqual, 0) // Placeholder
+		}
 
-	// Jump to loop end if counter >= limit
-	loopEndJumpPos := fc.eb.text.Len()
-	fc.out.JumpConditional(JumpGreaterOrEqual, 0) // Placeholder
+		// Add this to the loop's end patches
+		fc.activeLoops[len(fc.activeLoops)-1].EndPatches = append(
+			fc.activeLoops[len(fc.activeLoops)-1].EndPatches,
+			loopEndJumpPos+2, // +2 to skip to the offset field
+		)
 
-	// Add this to the loop's end patches
-	fc.activeLoops[len(fc.activeLoops)-1].EndPatches = append(
-		fc.activeLoops[len(fc.activeLoops)-1].EndPatches,
-		loopEndJumpPos+2, // +2 to skip to the offset field
-	)
+		// Store current counter value as iterator (convert to float64)
+		if useRegister {
+			fc.out.Cvtsi2sd("xmm0", counterReg)
+		} else {
+			fc.out.MovMemToReg("rcx", "rbp", -counterOffset)
+			fc.out.Cvtsi2sd("xmm0", "rcx")
+		}
+		fc.out.MovXmmToMem("xmm0", "rbp", -iterOffset)
 
-	// Store current counter value as iterator (convert to float64)
-	if useRegister {
-		fc.out.Cvtsi2sd("xmm0", counterReg)
-	} else {
-		fc.out.MovMemToReg("rcx", "rbp", -counterOffset)
-		fc.out.Cvtsi2sd("xmm0", "rcx")
-	}
-	fc.out.MovXmmToMem("xmm0", "rbp", -iterOffset)
+		// Save runtime stack before loop body (to clean up loop-local variables)
+		runtimeStackBeforeBody := fc.runtimeStack
 
-	// Save runtime stack before loop body (to clean up loop-local variables)
-	runtimeStackBeforeBody := fc.runtimeStack
+		// Compile loop body
+		for _, s := range stmt.Body {
+			fc.compileStatement(s)
+		}
 
-	// Compile loop body
-	for _, s := range stmt.Body {
-		fc.compileStatement(s)
-	}
+		// Mark continue position (increment step)
+		continuePos := fc.eb.text.Len()
+		fc.activeLoops[len(fc.activeLoops)-1].ContinuePos = continuePos
 
-	// Mark continue position (increment step)
-	continuePos := fc.eb.text.Len()
-	fc.activeLoops[len(fc.activeLoops)-1].ContinuePos = continuePos
+		// Patch all continue jumps to point here
+		for _, patchPos := range fc.activeLoops[len(fc.activeLoops)-1].ContinuePatches {
+			backOffset := int32(continuePos - (patchPos + 4))
+			fc.patchJumpImmediate(patchPos, backOffset)
+		}
+		fc.activeLoops[len(fc.activeLoops)-1].ContinuePatches = nil
 
-	// Patch all continue jumps to point here
-	for _, patchPos := range fc.activeLoops[len(fc.activeLoops)-1].ContinuePatches {
-		backOffset := int32(continuePos - (patchPos + 4))
-		fc.patchJumpImmediate(patchPos, backOffset)
-	}
+		// Clean up loop-local variables allocated during loop body
+		// Calculate how much stack was actually allocated during loop body
+		bodyStackUsage := fc.runtimeStack - runtimeStackBeforeBody
+		if bodyStackUsage > 0 {
+			fc.out.AddImmToReg("rsp", int64(bodyStackUsage))
+			fc.runtimeStack = runtimeStackBeforeBody
+		}
 
-	// Clean up loop-local variables allocated during loop body
-	// Calculate how much stack was actually allocated during loop body
-	bodyStackUsage := fc.runtimeStack - runtimeStackBeforeBody
-	if bodyStackUsage > 0 {
-		fc.out.AddImmToReg("rsp", int64(bodyStackUsage))
-		fc.runtimeStack = runtimeStackBeforeBody
+		// Advance loop counter by the step
+		stepCounter := counterReg
+		if !useRegister {
+			stepCounter = "rax"
+			fc.out.MovMemToReg("rax", "rbp", -counterOffset)
+		}
+		if step == 1 {
+			fc.out.IncReg(stepCounter) // Single instruction!
+		} else {
+			fc.out.AddImmToReg(stepCounter, step)
+		}
+		if !useRegister {
+			fc.out.MovRegToMem("rax", "rbp", -counterOffset)
+		}
 	}
 
-	// Increment loop counter
-	if useRegister {
-		fc.out.IncReg(counterReg) // Single instruction!
-	} else {
-		fc.out.MovMemToReg("rax", "rbp", -counterOffset)
-		fc.out.IncReg("rax")
-		fc.out.MovRegToMem("rax", "rbp", -counterOffset)
-	}
+	fc.emitSafepoint()
 
 	// Jump back to loop start
 	loopBackJumpPos := fc.eb.text.Len()
@@ -2311,6 +2522,8 @@ func collectLoopLocalVars(body []Statement) map[string]bool {
 			case *LoopStmt:
 				// Recursively scan nested loop bodies
 				scanStatements(s.Body)
+			case *ArenaStmt:
+				scanStatements(s.Body)
 				// Add more cases as needed for other statement types with nested statements
 			}
 		}
@@ -2423,11 +2636,17 @@ func (fc *C67Compiler) compileParallelRangeLoop(stmt *LoopStmt, rangeExpr *Range
 
 	start := int(startLit.Value)
 	end := int(endLit.Value)
+	step := rangeExpr.StepValue()
 	if rangeExpr.Inclusive {
 		end++ // Convert inclusive to exclusive for calculations
 	}
 
+	// With a step other than 1, threads split the iteration numbers
+	// 0..<totalItems and compute the iterator as start + n*step
 	totalItems := end - start
+	if step != 1 {
+		totalItems = int(rangeExpr.TripCount(int64(startLit.Value), int64(endLit.Value)))
+	}
 	if totalItems <= 0 {
 		// Empty range: skip parallel loop entirely (no error)
 		if VerboseMode {
@@ -2456,11 +2675,14 @@ func (fc *C67Compiler) compileParallelRangeLoop(stmt *LoopStmt, rangeExpr *Range
 		fmt.Fprintf(os.Stderr, "      Emitting parallel execution assembly code\n")
 	}
 
+	// No collections while the threads run
+	fc.emitGCPause()
+
 	// Step 1: Allocate space on stack for barrier
 	// Barrier layout: [count: int64][total: int64] = 16 bytes total
 	// Using int64 for simplicity (assembly has better support for 64-bit operations)
 	fc.out.SubImmFromReg("rsp", 16)
-	fc.runtimeStack += 16
+	fc.growRuntimeStack(16)
 
 	// Step 2: Initialize barrier
 	// V6: actualThreads worker threads + 1 parent thread = actualThreads+1 total
@@ -2478,10 +2700,19 @@ func (fc *C67Compiler) compileParallelRangeLoop(stmt *LoopStmt, rangeExpr *Range
 	// For V1, spawn 2 threads
 	// Each thread will execute its portion of the loop
 
+	// Each parallel loop gets its own thread entry function
+	fc.labelCounter++
+	threadEntryLabel := fmt.Sprintf("_parallel_thread_entry_%d", fc.labelCounter)
+
 	// Calculate work ranges for each thread
 	threadRanges := make([][2]int, actualThreads)
 	for i := 0; i < actualThreads; i++ {
 		threadStart, threadEnd := GetThreadWorkRange(i, totalItems, actualThreads)
+		if step != 1 {
+			threadRanges[i][0] = threadStart
+			threadRanges[i][1] = threadEnd
+			continue
+		}
 		threadRanges[i][0] = start + threadStart
 		threadRanges[i][1] = start + threadEnd
 	}
@@ -2505,7 +2736,8 @@ func (fc *C67Compiler) compileParallelRangeLoop(stmt *LoopStmt, rangeExpr *Range
 
 	// Allocate pthread_t array on stack to store thread IDs
 	// Each pthread_t is 8 bytes, allocate space for all threads
-	pthreadArraySize := int64(actualThreads * 8)
+	// Round up to 16 bytes so pthread_create is called with an aligned stack
+	pthreadArraySize := int64((actualThreads*8 + 15) &^ 15)
 	fc.out.SubImmFromReg("rsp", pthreadArraySize)
 	fc.out.MovRegToReg("r12", "rsp") // r12 = pthread_t array base
 
@@ -2549,11 +2781,11 @@ func (fc *C67Compiler) compileParallelRangeLoop(stmt *LoopStmt, rangeExpr *Range
 
 		// Calculate pthread_t pointer: r12 + (threadIdx * 8)
 		pthreadOffset := int64(threadIdx * 8)
-		fc.out.MovRegToReg("rdi", "r12")                       // rdi = pthread array base (arg 1)
-		fc.out.AddImmToReg("rdi", pthreadOffset)               // rdi = &thread_id
-		fc.out.MovImmToReg("rsi", "0")                         // attr = NULL (arg 2)
-		fc.out.LeaSymbolToReg("rdx", "_parallel_thread_entry") // start_routine (arg 3)
-		fc.out.MovRegToReg("rcx", "r13")                       // arg = thread args (arg 4)
+		fc.out.MovRegToReg("rdi", "r12")               // rdi = pthread array base (arg 1)
+		fc.out.AddImmToReg("rdi", pthreadOffset)       // rdi = &thread_id
+		fc.out.MovImmToReg("rsi", "0")                 // attr = NULL (arg 2)
+		fc.out.LeaSymbolToReg("rdx", threadEntryLabel) // start_routine (arg 3)
+		fc.out.MovRegToReg("rcx", "r13")               // arg = thread args (arg 4)
 		fc.trackFunctionCall("pthread_create")
 		fc.eb.GenerateCallInstruction("pthread_create")
 
@@ -2564,6 +2796,7 @@ func (fc *C67Compiler) compileParallelRangeLoop(stmt *LoopStmt, rangeExpr *Range
 
 		// pthread_create failed - print error and exit
 		fc.out.MovImmToReg("rdi", "1")
+		fc.emitFlushStdout()
 		fc.trackFunctionCall("exit")
 		fc.eb.GenerateCallInstruction("exit")
 
@@ -2599,13 +2832,14 @@ func (fc *C67Compiler) compileParallelRangeLoop(stmt *LoopStmt, rangeExpr *Range
 
 	// Clean up pthread_t array from stack
 	fc.out.AddImmToReg("rsp", pthreadArraySize)
+	fc.emitGCResume()
 
 	// Jump over thread entry function
 	parentJumpPos := fc.eb.text.Len()
 	fc.out.JumpUnconditional(0) // Will patch to skip thread function
 
-	// Thread entry function: void* _parallel_thread_entry(void* arg)
-	fc.eb.MarkLabel("_parallel_thread_entry")
+	// Thread entry function: void* _parallel_thread_entry_N(void* arg)
+	fc.eb.MarkLabel(threadEntryLabel)
 
 	// Function prologue
 	fc.out.PushReg("rbp")
@@ -2629,10 +2863,16 @@ func (fc *C67Compiler) compileParallelRangeLoop(stmt *LoopStmt, rangeExpr *Range
 	//   [rbp-40]: barrier_ptr
 	//   [rbp-48]: parent_rbp
 	//   [rbp-56]: iterator value (float64)
+	//   [rbp-threadArenaSlot]: thread arena, below the body's variables
+	//   then one saved arena offset per nested arena block
 	// CRITICAL: pthread entry gives us 16-byte aligned rsp. After push rbp + push rbx,
 	// rsp is aligned. We need rsp MISALIGNED by 8 before call instructions (so that
-	// after call pushes return address, it becomes aligned). Therefore, sub by 56 not 64.
-	fc.out.SubImmFromReg("rsp", 56)
+	// after call pushes return address, it becomes aligned). Therefore the frame
+	// below rbp is a multiple of 16, and 8 of it is the saved rbx.
+	threadArenaSlot := fc.threadArenaSlot()
+	threadArenaMarks := arenaNesting(stmt.Body)
+	threadFrameSize := int64((threadArenaSlot+8*threadArenaMarks+15)&^15) - 8
+	fc.out.SubImmFromReg("rsp", threadFrameSize)
 
 	// Load parameters from argument structure and store to stack slots (rbp-relative)
 	// Note: Using rbx since we saved rdi to rbx above
@@ -2652,9 +2892,19 @@ func (fc *C67Compiler) compileParallelRangeLoop(stmt *LoopStmt, rangeExpr *Range
 	fc.out.MovMemToReg("rax", "rbp", -16) // rax = start
 	fc.out.MovRegToMem("rax", "rbp", -32) // [rbp-32] = counter (initialized to start)
 
+	// Create the thread arena that the body allocates from
+	fc.out.MovImmToReg("rdi", fmt.Sprintf("%d", threadArenaSize))
+	fc.out.CallSymbol("c67_arena_create")
+	fc.out.MovRegToMem("rax", "rbp", -threadArenaSlot)
+
 	// Loop start
+	fc.alignLoopHeader()
 	loopStartPos := fc.eb.text.Len()
 
+	// Drop the stack that the previous iteration's variables took
+	fc.out.MovRegToReg("rsp", "rbp")
+	fc.out.SubImmFromReg("rsp", threadFrameSize+8)
+
 	// Load counter and end from stack and compare (rbp-relative)
 	fc.out.MovMemToReg("rax", "rbp", -32) // rax = counter
 	fc.out.MovMemToReg("rcx", "rbp", -24) // rcx = end
@@ -2669,7 +2919,11 @@ func (fc *C67Compiler) compileParallelRangeLoop(stmt *LoopStmt, rangeExpr *Range
 	// This makes the iterator accessible as a proper float64 variable
 	// Note: Using rbp-56 to avoid conflict with loop variables at rbp-16 through rbp-48
 	iteratorOffset := 56
-	fc.out.MovMemToReg("rax", "rbp", -32)              // rax = counter
+	fc.out.MovMemToReg("rax", "rbp", -32) // rax = counter
+	if step != 1 {
+		fc.out.ImulImmToReg("rax", step)        // rax = counter * step
+		fc.out.AddImmToReg("rax", int64(start)) // rax = start + counter * step
+	}
 	fc.out.Cvtsi2sd("xmm0", "rax")                     // xmm0 = (float64)counter
 	fc.out.MovXmmToMem("xmm0", "rbp", -iteratorOffset) // Store at rbp-56
 
@@ -2698,9 +2952,12 @@ func (fc *C67Compiler) compileParallelRangeLoop(stmt *LoopStmt, rangeExpr *Range
 
 	// Compile actual loop body
 	// Parent variables will use r11, local variables use rbp
+	savedThreadArena := [3]int{fc.threadArenaOffset, fc.threadArenaMarks, fc.threadArenaDepth}
+	fc.threadArenaOffset, fc.threadArenaMarks, fc.threadArenaDepth = threadArenaSlot, threadArenaMarks, 0
 	for _, bodyStmt := range stmt.Body {
 		fc.compileStatement(bodyStmt)
 	}
+	fc.threadArenaOffset, fc.threadArenaMarks, fc.threadArenaDepth = savedThreadArena[0], savedThreadArena[1], savedThreadArena[2]
 
 	// Restore original context
 	fc.variables[stmt.Iterator] = savedIteratorOffset
@@ -2723,6 +2980,17 @@ func (fc *C67Compiler) compileParallelRangeLoop(stmt *LoopStmt, rangeExpr *Range
 	loopExitOffset := int32(loopEndPos - (loopEndJumpPos + ConditionalJumpSize))
 	fc.patchJumpImmediate(loopEndJumpPos+2, loopExitOffset)
 
+	// Destroy the thread arena, unless values allocated in it were stored
+	// where they outlive the thread
+	if !fc.parallelBodyEscapes(stmt.Body, stmt.Iterator) {
+		fc.out.MovMemToReg("rdi", "rbp", -threadArenaSlot)
+		fc.out.CallSymbol("c67_arena_destroy")
+	} else if fc.gcEnabled() {
+		// The collector scans the arena from now on
+		fc.out.MovMemToReg("rdi", "rbp", -threadArenaSlot)
+		fc.out.CallSymbol("_c67_gc_keep_arena")
+	}
+
 	// V4: Barrier synchronization after loop completes
 	// Atomically decrement barrier counter and synchronize
 
@@ -2794,8 +3062,8 @@ func (fc *C67Compiler) compileParallelRangeLoop(stmt *LoopStmt, rangeExpr *Range
 	wakeExitOffset := int32(threadExitPos - (wakeExitJumpPos + UnconditionalJumpSize))
 	fc.patchJumpImmediate(wakeExitJumpPos+1, wakeExitOffset)
 
-	// Restore stack pointer (matches the sub rsp, 56 in prologue)
-	fc.out.AddImmToReg("rsp", 56)
+	// Restore stack pointer (matches the sub rsp in the prologue)
+	fc.out.AddImmToReg("rsp", threadFrameSize)
 
 	// Note: Argument structure cleanup - currently relies on process termination
 	// (Memory leak acceptable for short-lived thread wrapper functions)
@@ -2834,9 +3102,14 @@ func (fc *C67Compiler) compileListLoop(stmt *LoopStmt) {
 	lengthOffset := baseOffset + 32
 	indexOffset := baseOffset + 48
 	iterOffset := baseOffset + 64
+	keyOffset := baseOffset + 80
+	if stmt.KeyIterator != "" {
+		stackSize = 80
+	}
+	iterableIsMap := fc.getExprType(stmt.Iterable) == "map"
 
 	fc.out.SubImmFromReg("rsp", stackSize)
-	fc.runtimeStack += int(stackSize)
+	fc.growRuntimeStack(int(stackSize))
 
 	fc.out.MovXmmToMem("xmm0", "rbp", -listPtrOffset)
 
@@ -2857,7 +3130,12 @@ func (fc *C67Compiler) compileListLoop(stmt *LoopStmt) {
 
 	fc.variables[stmt.Iterator] = iterOffset
 	fc.mutableVars[stmt.Iterator] = true
+	if stmt.KeyIterator != "" {
+		fc.variables[stmt.KeyIterator] = keyOffset
+		fc.mutableVars[stmt.KeyIterator] = true
+	}
 
+	fc.alignLoopHeader()
 	loopStartPos := fc.eb.text.Len()
 
 	// Register this loop on the active loop stack
@@ -2919,6 +3197,18 @@ func (fc *C67Compiler) compileListLoop(stmt *LoopStmt) {
 	// Store in iterator variable
 	fc.out.MovXmmToMem("xmm0", "rbp", -iterOffset)
 
+	// @ k, v in m: the key is stored just before the value
+	// @ i, x in list: the key is the index
+	if stmt.KeyIterator != "" {
+		if iterableIsMap {
+			fc.out.MovMemToXmm("xmm0", "rbx", -8)
+		} else {
+			fc.out.MovMemToReg("rax", "rbp", -indexOffset)
+			fc.out.Cvtsi2sd("xmm0", "rax")
+		}
+		fc.out.MovXmmToMem("xmm0", "rbp", -keyOffset)
+	}
+
 	// Compile loop body
 	for _, s := range stmt.Body {
 		fc.compileStatement(s)
@@ -2939,6 +3229,8 @@ func (fc *C67Compiler) compileListLoop(stmt *LoopStmt) {
 	fc.out.IncReg("rax")
 	fc.out.MovRegToMem("rax", "rbp", -indexOffset)
 
+	fc.emitSafepoint()
+
 	// Jump back to loop start
 	loopBackJumpPos := fc.eb.text.Len()
 	backOffset := int32(loopStartPos - (loopBackJumpPos + UnconditionalJumpSize)) // 5 bytes for unconditional jump
@@ -2951,6 +3243,10 @@ func (fc *C67Compiler) compileListLoop(stmt *LoopStmt) {
 
 	delete(fc.variables, stmt.Iterator)
 	delete(fc.mutableVars, stmt.Iterator)
+	if stmt.KeyIterator != "" {
+		delete(fc.variables, stmt.KeyIterator)
+		delete(fc.mutableVars, stmt.KeyIterator)
+	}
 
 	// Patch all end jumps (conditional jump + any @0 breaks)
 	for _, patchPos := range fc.activeLoops[len(fc.activeLoops)-1].EndPatches {
@@ -2974,6 +3270,9 @@ func (fc *C67Compiler) compileJumpStatement(stmt *JumpStmt) {
 		if stmt.Value != nil {
 			fc.compileExpression(stmt.Value)
 			// xmm0 now contains return value
+			if fc.arenaLevel() > 1 {
+				fc.copyOutOfArena(1, stmt.Value, "the returned value")
+			}
 		}
 		fc.out.MovRegToReg("rsp", "rbp")
 
@@ -3043,11 +3342,42 @@ func (fc *C67Compiler) compileJumpStatement(stmt *JumpStmt) {
 	}
 }
 
+// forwardJump emits a conditional jump with a zero offset; patch it with landForwardJump
+func (fc *C67Compiler) forwardJump(cond JumpCondition) int {
+	pos := fc.eb.text.Len()
+	fc.out.JumpConditional(cond, 0)
+	return pos
+}
+
+// forwardJumpAlways emits an unconditional jump with a zero offset; patch it with landForwardJump
+func (fc *C67Compiler) forwardJumpAlways() int {
+	pos := fc.eb.text.Len()
+	fc.out.JumpUnconditional(0)
+	return pos
+}
+
+// landForwardJump points a jump from forwardJump or forwardJumpAlways at the current position
+func (fc *C67Compiler) landForwardJump(pos int) {
+	if fc.eb.text.Bytes()[pos] == 0x0f {
+		fc.patchJumpImmediate(pos+2, int32(fc.eb.text.Len()-(pos+ConditionalJumpSize)))
+	} else {
+		fc.patchJumpImmediate(pos+1, int32(fc.eb.text.Len()-(pos+UnconditionalJumpSize)))
+	}
+}
+
+// patchJumpImmediate writes the offset of the jump whose displacement
+// starts at pos. A short jump has a single byte for it (see jumprange.go).
 func (fc *C67Compiler) patchJumpImmediate(pos int, offset int32) {
 	// Get the current bytes from buffer
 	// This is safe because we're patching backwards into already-written code
 	bytes := fc.eb.text.Bytes()
 
+	if pos > 0 && isShortJumpOpcode(bytes[pos-1]) {
+		checkReach("the short jump", pos-1, int64(offset), 8, 1)
+		bytes[pos] = byte(offset)
+		return
+	}
+
 	if fc.debug {
 		if VerboseMode {
 			fmt.Fprintf(os.Stderr, "DEBUG PATCH: Before patching at pos %d: %02x %02x %02x %02x\n", pos, bytes[pos], bytes[pos+1], bytes[pos+2], bytes[pos+3])
@@ -3144,7 +3474,7 @@ func (fc *C67Compiler) getExprType(expr Expression) string {
 		return "number"
 	case *RangeExpr:
 		return "list" // Range expressions compile to lists
-	case *ListExpr:
+	case *ListExpr, *VectorExpr:
 		return "list"
 	case *MapExpr:
 		return "map"
@@ -3163,6 +3493,16 @@ func (fc *C67Compiler) getExprType(expr Expression) string {
 		if e.Operator == "::" {
 			return "list"
 		}
+		// Arithmetic on a map record calls its methods, which give records (see operators.go)
+		switch e.Operator {
+		case "+", "-", "*", "/", "%", "mod":
+			if fc.getExprType(e.Left) == "map" || fc.getExprType(e.Right) == "map" {
+				return "map"
+			}
+			if fc.isDecimalOp(e) {
+				return "decimal"
+			}
+		}
 		// Binary expressions between strings return strings if operator is "+"
 		if e.Operator == "+" {
 			leftType := fc.getExprType(e.Left)
@@ -3173,6 +3513,12 @@ func (fc *C67Compiler) getExprType(expr Expression) string {
 		}
 		return "number"
 	case *CallExpr:
+		if t, ok := fc.sdlEasyTypes[e.Function]; ok {
+			return t
+		}
+		if t, ok := fc.sdlEasyTypes[sdlEasyName(e.Function)]; ok {
+			return t
+		}
 		// Check if this is a C FFI call first (namespace.function)
 		if strings.Contains(e.Function, ".") {
 			parts := strings.Split(e.Function, ".")
@@ -3186,7 +3532,7 @@ func (fc *C67Compiler) getExprType(expr Expression) string {
 						returnType := strings.TrimSpace(funcSig.ReturnType)
 
 						// Map C return types to C67 types
-						if returnType == "char*" || returnType == "const char*" {
+						if isCStringType(returnType) {
 							return "cstring"
 						} else if isPointerType(returnType) {
 							return "cpointer"
@@ -3210,10 +3556,31 @@ func (fc *C67Compiler) getExprType(expr Expression) string {
 			}
 		}
 
+		if e.Function == "decimal" {
+			return "decimal"
+		}
+
+		// A copy has the type of the original
+		if (e.Function == "copy" || e.Function == "deepcopy") && len(e.Args) == 1 {
+			return fc.getExprType(e.Args[0])
+		}
+
+		// cfg("NAME") is a string when the value does not read as a number
+		if isDefineCall(e) {
+			if folded, ok := foldDefineCall(e); ok {
+				return fc.getExprType(folded)
+			}
+		}
+
+		// onfree() returns its handle
+		if e.Function == "onfree" && len(e.Args) == 2 {
+			return fc.getExprType(e.Args[0])
+		}
+
 		// Function calls - check return type for C67 built-ins
 		stringFuncs := map[string]bool{
-			"str": true, "read_file": true,
-			"upper": true, "lower": true, "trim": true,
+			"str": true, "read_file": true, "readfile": true, "run": true,
+			"upper": true, "lower": true, "trim": true, "cerrstr": true, "datefmt": true, "uuid4": true,
 			"_error_code_extract": true,
 		}
 		if stringFuncs[e.Function] {
@@ -3221,9 +3588,11 @@ func (fc *C67Compiler) getExprType(expr Expression) string {
 		}
 		// Functions that return lists
 		listFuncs := map[string]bool{
-			"append": true, "pop": true, "tail": true,
+			"append": true, "pop": true, "tail": true, "_list_alloc": true, "args": true, "bigload": true,
+			"sortedinsert": true, "array2d": true, "gridfill": true, "gridcopy": true,
+			"fieldnames": true, "securerand": true,
 		}
-		if listFuncs[e.Function] {
+		if listFuncs[e.Function] || vectorListFuncs[e.Function] {
 			return "list"
 		}
 		// Functions that return maps
@@ -3231,15 +3600,42 @@ func (fc *C67Compiler) getExprType(expr Expression) string {
 			"safe_divide_result": true,
 			"safe_sqrt_result":   true,
 			"safe_ln_result":     true,
+			"sharedmap":          true,
+			"flags":              true,
+			"parsetoml":          true,
 		}
 		if mapFuncs[e.Function] {
 			return "map"
 		}
+		// Persistent collections have a header of their own (see persistent.go)
+		if _, isUser := fc.variables[e.Function]; persistentBuiltins[e.Function] && !isUser {
+			return "persistent"
+		}
 		// Other functions return numbers by default
 		return "number"
+	case *PipeExpr:
+		// Piping into run() gives the command's output
+		if isRunCall(e.Right) {
+			return "string"
+		}
+		return "unknown"
 	case *SliceExpr:
 		// Slicing preserves the type of the list
 		return fc.getExprType(e.List)
+	case *UnaryExpr:
+		if e.Operator == "-" && fc.getExprType(e.Operand) == "decimal" {
+			return "decimal"
+		}
+		return "unknown"
+	case *FMAExpr:
+		// a * b + c on decimals, which the optimizer fused
+		for _, operand := range []Expression{e.A, e.B, e.C} {
+			if fc.getExprType(operand) == "decimal" {
+				return "decimal"
+			}
+		}
+		// Otherwise a number, like the * and + it was fused from
+		return "number"
 	case *FStringExpr:
 		// F-strings are always strings
 		return "string"
@@ -3265,6 +3661,43 @@ func (fc *C67Compiler) getExprType(expr Expression) string {
 		// Indexing returns the element type
 		// For lists/maps, elements are numbers (float64)
 		return "number"
+	case *TryExpr:
+		// f(x)! yields the call's result when it is not an error
+		return fc.getExprType(e.Expr)
+	case *BlockExpr:
+		// A comprehension yields its accumulator, which is created by the
+		// first assignment to the name in its last statement
+		if !e.Comprehension || len(e.Statements) == 0 {
+			return "unknown"
+		}
+		result, ok := e.Statements[len(e.Statements)-1].(*ExpressionStmt)
+		if !ok {
+			return "unknown"
+		}
+		ident, ok := result.Expr.(*IdentExpr)
+		if !ok {
+			return "unknown"
+		}
+		for _, stmt := range e.Statements {
+			if assign, ok := stmt.(*AssignStmt); ok && assign.Name == ident.Name && !assign.IsUpdate {
+				return fc.getExprType(assign.Value)
+			}
+		}
+		return "unknown"
+	case *ArenaExpr:
+		// An arena expression yields its last value, often a variable of the block
+		result := arenaResult(e.Body)
+		if ident, ok := result.(*IdentExpr); ok {
+			for _, stmt := range e.Body {
+				if assign, ok := stmt.(*AssignStmt); ok && assign.Name == ident.Name && !assign.IsUpdate {
+					return fc.getExprType(assign.Value)
+				}
+			}
+		}
+		if result == nil {
+			return "unknown"
+		}
+		return fc.getExprType(result)
 	default:
 		return "unknown"
 	}
@@ -3423,18 +3856,7 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 		}
 
 		// Compile first part
-		// Check if it needs str() conversion using type checking
-		firstPart := e.Parts[0]
-		if fc.getExprType(firstPart) == "string" {
-			// Already a string - compile directly
-			fc.compileExpression(firstPart)
-		} else {
-			// Not a string - use 'as string' for conversion
-			fc.compileExpression(&CastExpr{
-				Expr: firstPart,
-				Type: "string",
-			})
-		}
+		fc.compileFStringPart(e, 0)
 
 		// Concatenate remaining parts
 		for i := 1; i < len(e.Parts); i++ {
@@ -3443,17 +3865,7 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 			fc.out.MovXmmToMem("xmm0", "rsp", 0)
 
 			// Evaluate right string (next part)
-			part := e.Parts[i]
-			if fc.getExprType(part) == "string" {
-				// Already a string - compile directly
-				fc.compileExpression(part)
-			} else {
-				// Not a string - use 'as string' for conversion
-				fc.compileExpression(&CastExpr{
-					Expr: part,
-					Type: "string",
-				})
-			}
+			fc.compileFStringPart(e, i)
 
 			// Save right pointer to stack
 			fc.out.SubImmFromReg("rsp", 16)
@@ -3495,6 +3907,9 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 			// Load variable from stack into xmm0
 			offset, exists := fc.variables[e.Name]
 			if !exists {
+				if overload, ok := fc.arityOverloads[e.Name]; ok {
+					compilerError("'%s' takes %s argument(s) and can only be called directly", e.Name, overload.arities())
+				}
 				if VerboseMode {
 					fmt.Fprintf(os.Stderr, "DEBUG: Undefined variable '%s', available vars: %v\n", e.Name, fc.variables)
 					fmt.Fprintf(os.Stderr, "DEBUG: Current lambda: %v\n", fc.currentLambda)
@@ -3539,6 +3954,19 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 		}
 		// Value is already in xmm0 from compileExpression call
 
+	case *TryExpr:
+		// f(x)! - return an error result from the enclosing function, otherwise keep the value
+		if fc.currentLambda == nil {
+			compilerError("%s can only propagate errors from inside a function", e.String())
+		}
+		fc.compileExpression(e.Expr)
+		notError := fc.emitJumpIfNotError()
+		// xmm0 already holds the error value to return
+		fc.compileJumpStatement(&JumpStmt{IsBreak: true, Label: 0})
+		for _, pos := range notError {
+			fc.landForwardJump(pos)
+		}
+
 	case *NamespacedIdentExpr:
 		// Handle namespaced identifiers like sdl.SDL_INIT_VIDEO or data.field
 		// Check if this is a C constant
@@ -3550,9 +3978,14 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 				if VerboseMode {
 					fmt.Fprintf(os.Stderr, "Resolved C constant %s.%s = %d\n", e.Namespace, e.Name, value)
 				}
+			} else if size, found := fc.cVariables[e.Namespace][e.Name]; found {
+				fc.compileCVariable(fc.cImports[e.Namespace], e.Name, size)
 			} else {
-				compilerError("undefined constant '%s.%s'", e.Namespace, e.Name)
+				compilerError("undefined constant or variable '%s.%s'", e.Namespace, e.Name)
 			}
+		} else if size, found := fc.libcVariableSize(e); found {
+			// c.stdout and the like, without an import
+			fc.compileCVariable("", e.Name, size)
 		} else {
 			// Not a C import - treat as field access (obj.field)
 			// Convert to IndexExpr and compile it
@@ -3732,45 +4165,17 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 
 	case *FMAExpr:
 		// Fused Multiply-Add: result = a * b + c (or a * b - c for FMSUB)
-		// Detected by optimizer from patterns like (a * b) + c
-		// Compile to single FMA instruction on x86-64 (FMA3/AVX-512), ARM64 (NEON/SVE), RISC-V (RVV)
-		savedTailPosition := fc.inTailPosition
-		fc.inTailPosition = false
-		defer func() { fc.inTailPosition = savedTailPosition }()
-
-		// Allocate registers for operands
-		regA := fc.regTracker.AllocXMM("fma_a")
-		regB := fc.regTracker.AllocXMM("fma_b")
-		regC := fc.regTracker.AllocXMM("fma_c")
-		if regA == "" || regB == "" || regC == "" {
-			// Fallback to scalar registers if SIMD not available
-			regA, regB, regC = "xmm1", "xmm2", "xmm3"
-		}
-
-		// Compile operands
-		fc.compileExpression(e.A)
-		fc.out.MovXmmToXmm(regA, "xmm0")
-
-		fc.compileExpression(e.B)
-		fc.out.MovXmmToXmm(regB, "xmm0")
-
-		fc.compileExpression(e.C)
-		fc.out.MovXmmToXmm(regC, "xmm0")
-
-		// Emit FMA instruction: result = a * b + c or a * b - c
-		// The FMA instruction does: dst = src1 * src2 +/- src3
-		// So we want: xmm0 = regA * regB +/- regC
-		if e.IsSub {
-			// FMSUB: xmm0 = regA * regB - regC
-			fc.out.VFmsubPDVectorToVector("xmm0", regA, regB, regC)
-		} else {
-			// FMADD: xmm0 = regA * regB + regC
-			fc.out.VFmaddPDVectorToVector("xmm0", regA, regB, regC)
+		// Produced by the parser for a *+ b + c and by the optimizer for (a * b) + c
+		if fc.getExprType(e) == "decimal" {
+			// Decimals round the product, so a * b + c stays two operations
+			op := "+"
+			if e.IsSub {
+				op = "-"
+			}
+			fc.compileExpression(&BinaryExpr{Left: &BinaryExpr{Left: e.A, Operator: "*", Right: e.B}, Operator: op, Right: e.C})
+			return
 		}
-
-		fc.regTracker.FreeXMM(regA)
-		fc.regTracker.FreeXMM(regB)
-		fc.regTracker.FreeXMM(regC)
+		fc.compileFMA(e.A, e.B, e.C, e.IsSub)
 		return
 
 	case *BinaryExpr:
@@ -3790,14 +4195,14 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 			// Compile left expression into xmm0
 			fc.compileExpression(e.Left)
 
-			// Check if xmm0 is NaN by comparing with itself
-			fc.out.Ucomisd("xmm0", "xmm0") // Compare xmm0 with itself
-			// If NaN, parity flag is set (PF=1)
-			// Jump to execute_default if parity (i.e., if value is NaN)
-			executeDefaultPos1 := fc.eb.text.Len()
-			fc.out.JumpConditional(JumpParity, 0) // jp (jump if parity/NaN)
+			// Error values take the default; ordinary results (including plain NaN) go on to the null check
+			notError := fc.emitJumpIfNotError()
+			executeDefaultPos1 := fc.forwardJumpAlways()
+			for _, pos := range notError {
+				fc.landForwardJump(pos)
+			}
 
-			// Not NaN, now check if xmm0 == 0.0 (null pointer)
+			// Not an error, now check if xmm0 == 0.0 (null pointer)
 			zeroReg := fc.regTracker.AllocXMM("or_bang_zero")
 			if zeroReg == "" {
 				zeroReg = "xmm2" // Fallback
@@ -3806,35 +4211,24 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 			fc.out.Ucomisd("xmm0", zeroReg)   // Compare xmm0 with 0.0
 			fc.regTracker.FreeXMM(zeroReg)
 
+			// A plain NaN compares unordered (ZF=1, PF=1) and is kept as a value
+			nanValuePos := fc.forwardJump(JumpParity)
+
 			// Jump to execute_default if equal (i.e., if value is 0/null)
-			executeDefaultPos2 := fc.eb.text.Len()
-			fc.out.JumpConditional(JumpEqual, 0) // je (jump if equal to 0)
+			executeDefaultPos2 := fc.forwardJump(JumpEqual)
 
-			// Value is valid (not NaN and not 0), skip to end without evaluating right side
-			skipDefaultPos := fc.eb.text.Len()
-			fc.out.JumpUnconditional(0) // jmp (unconditional jump to end)
+			// Value is valid (not an error and not 0), skip to end without evaluating right side
+			fc.landForwardJump(nanValuePos)
+			skipDefaultPos := fc.forwardJumpAlways()
 
 			// execute_default label: evaluate right expression (could be block or value)
-			executeDefaultLabel := fc.eb.text.Len()
+			fc.landForwardJump(executeDefaultPos1)
+			fc.landForwardJump(executeDefaultPos2)
 			fc.compileExpression(e.Right) // Result goes to xmm0
 
-			// End label
-			endLabel := fc.eb.text.Len()
-
-			// Patch the jumps
-			// Patch NaN check jump to execute_default
-			offset1 := int32(executeDefaultLabel - (executeDefaultPos1 + 6))
-			fc.patchJumpImmediate(executeDefaultPos1+2, offset1)
+			fc.landForwardJump(skipDefaultPos)
 
-			// Patch zero check jump to execute_default
-			offset2 := int32(executeDefaultLabel - (executeDefaultPos2 + 6))
-			fc.patchJumpImmediate(executeDefaultPos2+2, offset2)
-
-			// Patch skip jump to end
-			offset3 := int32(endLabel - (skipDefaultPos + 5))
-			fc.patchJumpImmediate(skipDefaultPos+1, offset3)
-
-			// xmm0 now contains either original value (if not NaN/null) or result of right side
+			// xmm0 now contains either original value (if not an error/null) or result of right side
 			return
 		}
 
@@ -4045,7 +4439,8 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 				fc.out.SubImmFromReg("rsp", StackSlotSize)
 
 				// Call the helper function
-				// Note: Don't track internal function calls (see comment at _c67_string_eq call)
+				// Note: Don't track internal function calls - they use CallSymbol (0x00000000 placeholder)
+				// not GenerateCallInstruction (0x12345678 placeholder), so they shouldn't be in callOrder
 				fc.out.CallSymbol("_c67_list_concat")
 
 				fc.out.AddImmToReg("rsp", StackSlotSize)
@@ -4131,8 +4526,7 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 				fc.out.MovRegToReg("rdx", "rcx")
 				fc.out.ShlImmReg("rdx", 4)
 
-				fc.trackFunctionCall("memcpy")
-				fc.eb.GenerateCallInstruction("memcpy")
+				fc.emitEntryCopy()
 
 				fc.out.PopReg("rcx")
 				fc.out.PopReg("rsi")
@@ -4169,10 +4563,6 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 				return
 			}
 
-			if leftType == "map" && rightType == "map" {
-				// Map union is a future enhancement
-				compilerError("map union not yet implemented")
-			}
 		}
 
 		// String comparison operators
@@ -4200,10 +4590,8 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 				// Align stack for call
 				fc.out.SubImmFromReg("rsp", StackSlotSize)
 
-				// Call the helper function
-				// Note: Don't track internal function calls - they use CallSymbol (0x00000000 placeholder)
-				// not GenerateCallInstruction (0x12345678 placeholder), so they shouldn't be in callOrder
-				fc.out.CallSymbol("_c67_string_eq")
+				// Call the variant selected at startup (see cpudispatch.go)
+				fc.callDispatched("_c67_dispatch_string_eq")
 
 				// Restore stack alignment
 				fc.out.AddImmToReg("rsp", StackSlotSize)
@@ -4230,6 +4618,12 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 			}
 		}
 
+		// Exact arithmetic on decimals (see decimal.go)
+		if fc.isDecimalOp(e) {
+			fc.compileDecimalBinary(e)
+			return
+		}
+
 		// Default: numeric binary operation
 		// We must save the left operand to the STACK, not to a register,
 		// because compileExpression(e.Right) may call functions that clobber registers
@@ -4248,6 +4642,13 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 		fc.out.MovRegToReg("xmm1", "xmm0")
 		fc.out.MovMemToXmm("xmm0", "rsp", 0)
 		fc.out.AddImmToReg("rsp", 16)
+
+		// A map operand may bring its own operator (see operators.go)
+		methodCalled := -1
+		if receiver := fc.operatorReceiver(e); receiver >= 0 {
+			methodCalled = fc.emitOperatorMethodCall(e.Operator, receiver)
+		}
+
 		// Perform scalar floating-point operation
 		switch e.Operator {
 		case "+":
@@ -4257,14 +4658,10 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 		case "*":
 			fc.out.MulsdXmm("xmm0", "xmm1") // mulsd xmm0, xmm1
 		case "*+":
-			// FMA: a *+ b = a * a + b (square and add, using fused multiply-add)
-			// Use VFMADD213SD xmm0, xmm0, xmm1 => xmm0 = xmm0 * xmm0 + xmm1
-			// Encoding: C4 E2 F9 A9 C1 (VFMADD213SD xmm0, xmm0, xmm1)
-			fc.out.Write(0xC4) // VEX 3-byte prefix
-			fc.out.Write(0xE2) // VEX byte 1: R=1, X=1, B=1, m=00010 (0F38)
-			fc.out.Write(0xF9) // VEX byte 2: W=1, vvvv=0000 (xmm0), L=0, pp=01 (66)
-			fc.out.Write(0xA9) // Opcode: VFMADD213SD
-			fc.out.Write(0xC1) // ModR/M: 11 000 001 (xmm0, xmm0, xmm1)
+			// A fused multiply without an addend (a *+ b + c becomes an FMAExpr in the parser)
+			fc.out.MulsdXmm("xmm0", "xmm1") // mulsd xmm0, xmm1
+		case "+%", "-%", "*%", "+|", "-|", "*|":
+			fc.compileIntArith(e)
 		case "/":
 			// Check for division by zero (xmm1 == 0.0)
 			zeroReg := fc.regTracker.AllocXMM("div_zero_check")
@@ -4328,11 +4725,10 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 			fc.eb.Define(errorLabel, errorMsg)
 
 			// syscall: write(2, msg, len)
-			fc.out.MovImmToReg("rax", "1")
 			fc.out.MovImmToReg("rdi", "2")
 			fc.out.LeaSymbolToReg("rsi", errorLabel)
 			fc.out.MovImmToReg("rdx", fmt.Sprintf("%d", len(errorMsg)))
-			fc.eb.Emit("syscall")
+			fc.emitBufferedWrite()
 
 			// syscall: exit(1)
 			fc.out.MovImmToReg("rax", "60")
@@ -4535,6 +4931,9 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 			fc.out.MovMemToXmm("xmm0", "rsp", 0)
 			fc.out.AddImmToReg("rsp", 8)
 		}
+		if methodCalled >= 0 {
+			fc.landForwardJump(methodCalled)
+		}
 
 	case *CallExpr:
 		fc.compileCall(e)
@@ -4546,6 +4945,7 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 		// Compile range expression by expanding it to a list
 		// 0..<10 becomes [0, 1, 2, ..., 9]
 		// 0..=10 becomes [0, 1, 2, ..., 10]
+		// 10..>0 by -5 becomes [10, 5]
 
 		// Evaluate start and end expressions (must be compile-time constants for now)
 		startNum, startOk := e.Start.(*NumberExpr)
@@ -4558,18 +4958,11 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 		start := int64(startNum.Value)
 		end := int64(endNum.Value)
 
-		// Build list of elements
+		// Build list of elements, stepping by the 'by' clause if any
 		var elements []Expression
-		if e.Inclusive {
-			// ..= includes end value
-			for i := start; i <= end; i++ {
-				elements = append(elements, &NumberExpr{Value: float64(i)})
-			}
-		} else {
-			// ..< excludes end value
-			for i := start; i < end; i++ {
-				elements = append(elements, &NumberExpr{Value: float64(i)})
-			}
+		step := e.StepValue()
+		for n := int64(0); n < e.TripCount(start, end); n++ {
+			elements = append(elements, &NumberExpr{Value: float64(start + n*step)})
 		}
 
 		// Compile as a list
@@ -4784,6 +5177,15 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 		fc.out.MovMemToXmm("xmm0", "rsp", 0)
 		fc.out.AddImmToReg("rsp", StackSlotSize)
 	case *IndexExpr:
+		if ident, ok := e.List.(*IdentExpr); ok && fc.hashMapVars[ident.Name] {
+			fc.compileHashMapGet(ident.Name, e.Index)
+			break
+		}
+		if ident, ok := e.List.(*IdentExpr); ok && fc.persistentVars[ident.Name] {
+			fc.compilePersistentGet(ident.Name, e.Index)
+			break
+		}
+
 		// Determine if we're indexing a map/string or list
 		// Strings are map[uint64]float64, so use map indexing
 		containerType := fc.getExprType(e.List)
@@ -4825,8 +5227,9 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 			// =============================
 			// Three-tier approach for optimal performance:
 			// 1. AVX-512: Process 8 keys/iteration (8× throughput)
-			// 2. SSE2:    Process 2 keys/iteration (2× throughput)
-			// 3. Scalar:  Process 1 key/iteration (baseline)
+			// 2. AVX2:    Process 4 keys/iteration (4× throughput)
+			// 3. SSE2:    Process 2 keys/iteration (2× throughput)
+			// 4. Scalar:  Process 1 key/iteration (baseline)
 			//
 			// Map format: [count (float64)][key1][value1][key2][value2]...
 			// Keys are interleaved with values at 16-byte strides
@@ -4888,22 +5291,20 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 			// Gather 8 keys using VGATHERQPD
 			// vgatherqpd zmm0{k1}, [rbx + zmm4*1]
 			// First, set mask k1 to all 1s (we want all 8 values)
-			fc.out.Emit([]byte{0xc5, 0xf8, 0x92, 0xc9}) // kmovb k1, ecx (set to 0xFF)
-			// Actually, let's use kxnorb k1, k1, k1 to set all bits to 1
-			fc.out.Emit([]byte{0xc5, 0xfc, 0x46, 0xc9}) // kxnorb k1, k0, k1 -> k1 = 0xFF
+			fc.out.Emit([]byte{0xc5, 0xf4, 0x46, 0xc9}) // kxnorw k1, k1, k1 -> k1 = 0xFFFF
 
 			// vgatherqpd zmm0{k1}, [rbx + zmm4*1]
-			// EVEX.512.66.0F38.W1 92 /r
-			// This is complex - we need rbx as base, zmm4 as index, scale=1
-			fc.out.Emit([]byte{0x62, 0xf2, 0xfd, 0x49, 0x92, 0x04, 0xe3}) // [rbx + zmm4*1]
+			// EVEX.512.66.0F38.W1 93 /vsib - rbx as base, zmm4 as index, scale=1
+			// (the gather clears k1 as elements complete)
+			fc.out.Emit([]byte{0x62, 0xf2, 0xfd, 0x49, 0x93, 0x04, 0x23}) // [rbx + zmm4*1]
 
-			// Compare all 8 keys with search key
-			// vcmppd k2{k1}, zmm0, zmm3, 0 (EQ_OQ)
-			fc.out.Emit([]byte{0x62, 0xf1, 0xfd, 0x49, 0xc2, 0xd3, 0x00}) // EVEX.512.66.0F.W1 C2 /r ib
+			// Compare all 8 keys with search key (unmasked, k1 is zero after the gather)
+			// vcmppd k2, zmm0, zmm3, 0 (EQ_OQ)
+			fc.out.Emit([]byte{0x62, 0xf1, 0xfd, 0x48, 0xc2, 0xd3, 0x00}) // EVEX.512.66.0F.W1 C2 /r ib
 
 			// Extract mask to GPR
-			// kmovb eax, k2
-			fc.out.Emit([]byte{0xc5, 0xf9, 0x90, 0xc2}) // kmovb eax, k2
+			// kmovw eax, k2
+			fc.out.Emit([]byte{0xc5, 0xf8, 0x93, 0xc2}) // kmovw eax, k2
 
 			// Test if any key matched
 			fc.out.Emit([]byte{0x85, 0xc0}) // test eax, eax
@@ -4918,8 +5319,9 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 			fc.out.CmpRegToImm("rcx", 8)
 			fc.out.JumpConditional(JumpGreaterOrEqual, int32(avx512LoopStart-(fc.eb.text.Len()+6)))
 
-			// Clean up indices from stack and fall through to SSE2
+			// Clean up indices from stack and fall through to AVX2/SSE2
 			fc.out.AddImmToReg("rsp", 64)
+			fc.out.VZeroUpper()
 			avx512ToSse2Jump := fc.eb.text.Len()
 			fc.out.JumpUnconditional(0)
 			avx512ToSse2End := fc.eb.text.Len()
@@ -4944,16 +5346,83 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 
 			// Clean up and jump to end
 			fc.out.AddImmToReg("rsp", 64)
+			fc.out.VZeroUpper()
 			avx512DoneJump := fc.eb.text.Len()
 			fc.out.JumpUnconditional(0)
 			avx512DoneEnd := fc.eb.text.Len()
 
-			// ============ SSE2 PATH (2 keys/iteration) ============
+			// ============ AVX2 PATH (4 keys/iteration) ============
+			// Consumer CPUs since Haswell (2013) have AVX2 but usually not AVX-512
 			avx512SkipPos := fc.eb.text.Len()
 			fc.patchJumpImmediate(avx512NotSupportedJump+2, int32(avx512SkipPos-avx512NotSupportedEnd))
 			fc.patchJumpImmediate(avx512SkipJump+2, int32(avx512SkipPos-avx512SkipEnd))
 			fc.patchJumpImmediate(avx512ToSse2Jump+1, int32(avx512SkipPos-avx512ToSse2End))
 
+			// Check cpu_has_avx2 flag
+			fc.out.LeaSymbolToReg("r15", "cpu_has_avx2")
+			fc.out.Emit([]byte{0x41, 0x80, 0x3f, 0x00}) // cmp byte [r15], 0
+			avx2NotSupportedJump := fc.eb.text.Len()
+			fc.out.JumpConditional(JumpEqual, 0) // Jump to SSE2 if not supported
+			avx2NotSupportedEnd := fc.eb.text.Len()
+
+			// Check if we can process 4 at a time (count >= 4)
+			fc.out.CmpRegToImm("rcx", 4)
+			avx2SkipJump := fc.eb.text.Len()
+			fc.out.JumpConditional(JumpLess, 0)
+			avx2SkipEnd := fc.eb.text.Len()
+
+			// Broadcast search key to all 4 lanes of ymm3
+			fc.out.Emit([]byte{0xc4, 0xe2, 0x7d, 0x19, 0xda}) // vbroadcastsd ymm3, xmm2
+
+			// AVX2 loop: two unaligned loads cover 4 key-value pairs,
+			// unpack + permute gathers the keys in order [key0 | key1 | key2 | key3]
+			avx2LoopStart := fc.eb.text.Len()
+			fc.out.Emit([]byte{0xc5, 0xfd, 0x10, 0x03})             // vmovupd ymm0, [rbx]    = k0 v0 k1 v1
+			fc.out.Emit([]byte{0xc5, 0xfd, 0x10, 0x4b, 0x20})       // vmovupd ymm1, [rbx+32] = k2 v2 k3 v3
+			fc.out.Emit([]byte{0xc5, 0xfd, 0x14, 0xc1})             // vunpcklpd ymm0, ymm0, ymm1 = k0 k2 k1 k3
+			fc.out.Emit([]byte{0xc4, 0xe3, 0xfd, 0x01, 0xc0, 0xd8}) // vpermpd ymm0, ymm0, 0xd8 = k0 k1 k2 k3
+
+			// Compare all 4 keys with search key and extract the lane mask
+			fc.out.Emit([]byte{0xc5, 0xfd, 0xc2, 0xc3, 0x00}) // vcmpeqpd ymm0, ymm0, ymm3
+			fc.out.Emit([]byte{0xc5, 0xfd, 0x50, 0xc0})       // vmovmskpd eax, ymm0
+
+			// Test if any key matched
+			fc.out.Emit([]byte{0x85, 0xc0}) // test eax, eax
+			avx2FoundJump := fc.eb.text.Len()
+			fc.out.JumpConditional(JumpNotEqual, 0)
+			avx2FoundEnd := fc.eb.text.Len()
+
+			// No match - advance by 64 bytes (4 key-value pairs)
+			fc.out.AddImmToReg("rbx", 64)
+			fc.out.SubImmFromReg("rcx", 4)
+			// Continue if count >= 4
+			fc.out.CmpRegToImm("rcx", 4)
+			fc.out.JumpConditional(JumpGreaterOrEqual, int32(avx2LoopStart-(fc.eb.text.Len()+6)))
+
+			// Fall through to SSE2 for the remaining 0-3 keys
+			fc.out.VZeroUpper()
+			avx2ToSse2Jump := fc.eb.text.Len()
+			fc.out.JumpUnconditional(0)
+			avx2ToSse2End := fc.eb.text.Len()
+
+			// AVX2 match found - lane index (0-3) of the first matching key
+			avx2FoundPos := fc.eb.text.Len()
+			fc.patchJumpImmediate(avx2FoundJump+2, int32(avx2FoundPos-avx2FoundEnd))
+			fc.out.VZeroUpper()
+			fc.out.Emit([]byte{0x0f, 0xbc, 0xd0})                   // bsf edx, eax
+			fc.out.Emit([]byte{0xc1, 0xe2, 0x04})                   // shl edx, 4
+			fc.out.Emit([]byte{0x83, 0xc2, 0x08})                   // add edx, 8
+			fc.out.Emit([]byte{0xf2, 0x48, 0x0f, 0x10, 0x04, 0x13}) // movsd xmm0, [rbx+rdx]
+			avx2DoneJump := fc.eb.text.Len()
+			fc.out.JumpUnconditional(0)
+			avx2DoneEnd := fc.eb.text.Len()
+
+			// ============ SSE2 PATH (2 keys/iteration) ============
+			avx2SkipPos := fc.eb.text.Len()
+			fc.patchJumpImmediate(avx2NotSupportedJump+2, int32(avx2SkipPos-avx2NotSupportedEnd))
+			fc.patchJumpImmediate(avx2SkipJump+2, int32(avx2SkipPos-avx2SkipEnd))
+			fc.patchJumpImmediate(avx2ToSse2Jump+1, int32(avx2SkipPos-avx2ToSse2End))
+
 			// Broadcast search key to both lanes of xmm3 for SSE2 comparison
 			// unpcklpd xmm3, xmm2, xmm2 duplicates xmm2 into both 64-bit lanes
 			fc.out.MovXmmToXmm("xmm3", "xmm2")
@@ -5079,6 +5548,7 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 			allDonePos := fc.eb.text.Len()
 			fc.patchJumpImmediate(allDoneJump+1, int32(allDonePos-allDoneEnd))
 			fc.patchJumpImmediate(avx512DoneJump+1, int32(allDonePos-avx512DoneEnd))
+			fc.patchJumpImmediate(avx2DoneJump+1, int32(allDonePos-avx2DoneEnd))
 			fc.patchJumpImmediate(notFoundDoneJump+1, int32(allDonePos-notFoundDoneEnd))
 
 		} else {
@@ -5222,14 +5692,15 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 			fc.eb.DefineWritable(closureLabel, strings.Repeat("\x00", 16))
 
 			// At runtime, initialize the closure object with function pointer
-			fc.out.LeaSymbolToReg("r12", closureLabel) // r12 = closure object address
+			// rcx, not r12, which may hold the counter of an enclosing loop
+			fc.out.LeaSymbolToReg("rcx", closureLabel) // rcx = closure object address
 			fc.out.LeaSymbolToReg("rax", funcName)     // rax = function pointer
-			fc.out.MovRegToMem("rax", "r12", 0)        // Store func ptr at offset 0
+			fc.out.MovRegToMem("rax", "rcx", 0)        // Store func ptr at offset 0
 			// Offset 8 is already 0 (NULL environment) from the zeroed data
 
 			// Return closure object pointer as float64 in xmm0
 			fc.out.SubImmFromReg("rsp", 16)
-			fc.out.MovRegToMem("r12", "rsp", 0)
+			fc.out.MovRegToMem("rcx", "rsp", 0)
 			fc.out.MovMemToXmm("xmm0", "rsp", 0)
 			fc.out.AddImmToReg("rsp", 16)
 		}
@@ -5304,6 +5775,16 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 			}
 		}
 
+		// A comprehension at module level is compiled after the .data section
+		// was filled in, so its hidden variables get their storage here
+		if e.Comprehension && fc.currentLambda == nil {
+			for _, stmt := range e.Statements {
+				if assign, ok := stmt.(*AssignStmt); ok && fc.variables[assign.Name] == -1 {
+					fc.eb.DefineWritable("_global_"+assign.Name, "\x00\x00\x00\x00\x00\x00\x00\x00")
+				}
+			}
+		}
+
 		// Empty block returns true (1.0)
 		if len(e.Statements) == 0 {
 			fc.compileExpression(&NumberExpr{Value: 1.0})
@@ -5366,21 +5847,8 @@ func (fc *C67Compiler) compileExpression(expr Expression) {
 		fc.compileSliceExpr(e)
 
 	case *VectorExpr:
-		// Allocate stack space for vector components (8 bytes per float64)
-		stackSize := int64(e.Size * 8)
-		fc.out.SubImmFromReg("rsp", stackSize)
-
-		// Compile and store each component
-		for i, comp := range e.Components {
-			fc.compileExpression(comp)
-			// Result is in xmm0, store it to stack at offset i*8
-			offset := i * 8
-			fc.out.MovXmmToMem("xmm0", "rsp", offset)
-		}
-
-		// Load stack address into rax and convert to float64 for return
-		fc.out.MovRegToReg("rax", "rsp")
-		fc.out.Cvtsi2sd("xmm0", "rax")
+		// Vectors are plain lists, so indexing, # and println work on them
+		fc.compileExpression(&ListExpr{Elements: e.Components})
 
 	case *LoopExpr:
 		// Loop expressions return a value (possibly through reduction)
@@ -5714,105 +6182,138 @@ func (fc *C67Compiler) compileCastExpr(expr *CastExpr) {
 			return
 		}
 
-		// Convert number to string using _c67_itoa (pure machine code, no libc)
-		// Allocate buffer for number string (32 bytes enough for any number)
-		fc.out.SubImmFromReg("rsp", 32)
-		fc.out.MovRegToReg("r15", "rsp") // r15 = buffer pointer
+		fc.compileNumberToString(-1)
 
-		// Convert float to int64 (truncate)
-		fc.out.Cvttsd2si("rdi", "xmm0")
+	case "list":
+		// Convert C array to C67 list
+		// TODO: implement when needed (requires length parameter)
+		compilerError("'as list' conversion not yet implemented")
 
-		// Call _c67_itoa(rdi=number) -> (rsi=buffer, rdx=length)
-		// Save r15 before call (it contains buffer pointer)
-		fc.out.PushReg("r15")
-		fc.trackFunctionCall("_c67_itoa")
-		fc.eb.GenerateCallInstruction("_c67_itoa")
-		fc.out.PopReg("r15")
+	default:
+		compilerError("unknown cast type '%s'", expr.Type)
+	}
+}
 
-		// _c67_itoa returns: rsi=buffer start, rdx=length
-		// Copy result to our stack buffer
-		fc.out.MovRegToReg("rdi", "r15") // dest
-		fc.out.MovRegToReg("rcx", "rdx") // count
-		// memcpy loop
-		fc.out.CmpRegToImm("rcx", 0)
-		fc.out.Write(0x74) // JE (jump if zero)
-		fc.out.Write(0x00) // Placeholder
-		endJump := fc.eb.text.Len() - 1
-
-		copyStart := fc.eb.text.Len()
-		fc.out.MovMemToReg("al", "rsi", 0)
-		fc.out.MovByteRegToMem("al", "rdi", 0)
-		fc.out.AddImmToReg("rsi", 1)
-		fc.out.AddImmToReg("rdi", 1)
-		fc.out.SubImmFromReg("rcx", 1)
-		fc.out.Write(0x75) // JNZ (jump back if not zero)
-		copyOffset := int8(copyStart - (fc.eb.text.Len() + 1))
-		fc.out.Write(byte(copyOffset))
+// compileFStringPart compiles part i of an f-string to a string in xmm0
+func (fc *C67Compiler) compileFStringPart(e *FStringExpr, i int) {
+	part := e.Parts[i]
+	if fc.getExprType(part) == "string" {
+		// Already a string - compile directly
+		fc.compileExpression(part)
+		return
+	}
+	if precision := e.PrecisionOf(i); precision >= 0 {
+		// {x:.N} - fixed number of digits after the decimal point
+		fc.compileExpression(part)
+		if precision < decimalPlaces && fc.getExprType(part) == "decimal" {
+			fc.emitDecimalRound(precision)
+		}
+		fc.compileNumberToString(precision)
+		return
+	}
+	// Not a string - use 'as string' for conversion
+	fc.compileExpression(&CastExpr{
+		Expr: part,
+		Type: "string",
+	})
+}
 
-		endPos := fc.eb.text.Len()
-		fc.eb.text.Bytes()[endJump] = byte(endPos - (endJump + 1))
+// compileNumberToString converts the number in xmm0 to a C67 string in xmm0.
+// A negative precision gives the shortest round-trip text, otherwise exactly
+// precision digits follow the decimal point. Clobbers r13-r15.
+func (fc *C67Compiler) compileNumberToString(precision int) {
+	// Allocate buffer for number string (as large as _dtoa_buffer)
+	fc.out.SubImmFromReg("rsp", dtoaBufferSize)
+	fc.out.MovRegToReg("r15", "rsp") // r15 = buffer pointer
 
-		fc.out.MovRegToReg("r13", "rdx") // r13 = length
+	// Call _c67_dtoa(xmm0) or _c67_dtoa_fixed(xmm0, rdi=precision) -> (rsi=buffer, rdx=length)
+	// Save r15 before call (it contains buffer pointer)
+	fc.out.PushReg("r15")
+	if precision >= 0 {
+		fc.out.MovImmToReg("rdi", strconv.Itoa(precision))
+		fc.trackFunctionCall("_c67_dtoa_fixed")
+		fc.eb.GenerateCallInstruction("_c67_dtoa_fixed")
+	} else {
+		fc.trackFunctionCall("_c67_dtoa")
+		fc.eb.GenerateCallInstruction("_c67_dtoa")
+	}
+	fc.out.PopReg("r15")
 
-		// Convert C string to C67 string: allocate 8 + len*16 bytes
-		fc.out.MovRegToReg("rax", "r13")
-		fc.out.ShlRegByImm("rax", 4) // len * 16
-		fc.out.AddImmToReg("rax", 8) // + 8 for count
-		fc.out.MovRegToReg("rdi", "rax")
-		fc.callArenaAlloc()
-		fc.out.MovRegToReg("r14", "rax") // r14 = C67 string
+	// The helper returns: rsi=buffer start, rdx=length
+	// Copy result to our stack buffer
+	fc.out.MovRegToReg("rdi", "r15") // dest
+	fc.out.MovRegToReg("rcx", "rdx") // count
+	// memcpy loop
+	fc.out.CmpRegToImm("rcx", 0)
+	fc.out.Write(0x74) // JE (jump if zero)
+	fc.out.Write(0x00) // Placeholder
+	endJump := fc.eb.text.Len() - 1
 
-		// Store count
-		fc.out.Cvtsi2sd("xmm0", "r13")
-		fc.out.MovXmmToMem("xmm0", "r14", 0)
+	copyStart := fc.eb.text.Len()
+	fc.out.MovMemToReg("al", "rsi", 0)
+	fc.out.MovByteRegToMem("al", "rdi", 0)
+	fc.out.AddImmToReg("rsi", 1)
+	fc.out.AddImmToReg("rdi", 1)
+	fc.out.SubImmFromReg("rcx", 1)
+	fc.out.Write(0x75) // JNZ (jump back if not zero)
+	copyOffset := int8(copyStart - (fc.eb.text.Len() + 1))
+	fc.out.Write(byte(copyOffset))
 
-		// Copy characters to C67 string
-		fc.out.XorRegWithReg("rcx", "rcx") // index
-		copyLoopStart := fc.eb.text.Len()
-		fc.out.CmpRegToReg("rcx", "r13")
-		copyLoopEnd := fc.eb.text.Len()
-		fc.out.JumpConditional(JumpGreaterOrEqual, 0)
+	endPos := fc.eb.text.Len()
+	fc.eb.text.Bytes()[endJump] = byte(endPos - (endJump + 1))
 
-		// Load character: movzx rax, byte [r15 + rcx]
-		fc.out.MovRegToReg("rax", "r15")
-		fc.out.AddRegToReg("rax", "rcx")
-		fc.out.Emit([]byte{0x48, 0x0f, 0xb6, 0x00}) // movzx rax, byte [rax]
+	fc.out.MovRegToReg("r13", "rdx") // r13 = length
 
-		// Store to C67 string at offset 8 + rcx*16
-		fc.out.MovRegToReg("rdx", "rcx")
-		fc.out.ShlRegByImm("rdx", 4)     // rcx * 16
-		fc.out.AddImmToReg("rdx", 8)     // + 8
-		fc.out.AddRegToReg("rdx", "r14") // rdx = string_ptr + offset
+	// Convert C string to C67 string: allocate 8 + len*16 bytes
+	fc.out.MovRegToReg("rax", "r13")
+	fc.out.ShlRegByImm("rax", 4) // len * 16
+	fc.out.AddImmToReg("rax", 8) // + 8 for count
+	fc.out.MovRegToReg("rdi", "rax")
+	fc.callArenaAlloc()
+	fc.out.MovRegToReg("r14", "rax") // r14 = C67 string
 
-		// Store index as float64
-		fc.out.Cvtsi2sd("xmm0", "rcx")
-		fc.out.MovXmmToMem("xmm0", "rdx", 0)
+	// Store count
+	fc.out.Cvtsi2sd("xmm0", "r13")
+	fc.out.MovXmmToMem("xmm0", "r14", 0)
 
-		// Store char as float64
-		fc.out.Cvtsi2sd("xmm0", "rax")
-		fc.out.MovXmmToMem("xmm0", "rdx", 8)
+	// Copy characters to C67 string
+	fc.out.XorRegWithReg("rcx", "rcx") // index
+	copyLoopStart := fc.eb.text.Len()
+	fc.out.CmpRegToReg("rcx", "r13")
+	copyLoopEnd := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpGreaterOrEqual, 0)
 
-		// Increment and loop
-		fc.out.AddImmToReg("rcx", 1)
-		backOffset := int32(copyLoopStart - (fc.eb.text.Len() + UnconditionalJumpSize))
-		fc.out.JumpUnconditional(backOffset)
+	// Load character: movzx rax, byte [r15 + rcx]
+	fc.out.MovRegToReg("rax", "r15")
+	fc.out.AddRegToReg("rax", "rcx")
+	fc.out.Emit([]byte{0x48, 0x0f, 0xb6, 0x00}) // movzx rax, byte [rax]
 
-		// Loop end
-		loopEndTarget := fc.eb.text.Len()
-		fc.patchJumpImmediate(copyLoopEnd+2, int32(loopEndTarget-(copyLoopEnd+ConditionalJumpSize)))
+	// Store to C67 string at offset 8 + rcx*16
+	fc.out.MovRegToReg("rdx", "rcx")
+	fc.out.ShlRegByImm("rdx", 4)     // rcx * 16
+	fc.out.AddImmToReg("rdx", 8)     // + 8
+	fc.out.AddRegToReg("rdx", "r14") // rdx = string_ptr + offset
 
-		// Clean up buffer and return C67 string pointer in xmm0
-		fc.out.AddImmToReg("rsp", 32)
-		fc.out.MovqRegToXmm("xmm0", "r14")
+	// Store index as float64
+	fc.out.Cvtsi2sd("xmm0", "rcx")
+	fc.out.MovXmmToMem("xmm0", "rdx", 0)
 
-	case "list":
-		// Convert C array to C67 list
-		// TODO: implement when needed (requires length parameter)
-		compilerError("'as list' conversion not yet implemented")
+	// Store char as float64
+	fc.out.Cvtsi2sd("xmm0", "rax")
+	fc.out.MovXmmToMem("xmm0", "rdx", 8)
 
-	default:
-		compilerError("unknown cast type '%s'", expr.Type)
-	}
+	// Increment and loop
+	fc.out.AddImmToReg("rcx", 1)
+	backOffset := int32(copyLoopStart - (fc.eb.text.Len() + UnconditionalJumpSize))
+	fc.out.JumpUnconditional(backOffset)
+
+	// Loop end
+	loopEndTarget := fc.eb.text.Len()
+	fc.patchJumpImmediate(copyLoopEnd+2, int32(loopEndTarget-(copyLoopEnd+ConditionalJumpSize)))
+
+	// Clean up buffer and return C67 string pointer in xmm0
+	fc.out.AddImmToReg("rsp", dtoaBufferSize)
+	fc.out.MovqRegToXmm("xmm0", "r14")
 }
 
 func (fc *C67Compiler) compileSliceExpr(expr *SliceExpr) {
@@ -6309,6 +6810,7 @@ func (fc *C67Compiler) emitNullPointerCheck(reg string) {
 
 	// exit(1)
 	fc.out.MovImmToReg("rdi", "1")
+	fc.emitFlushStdout()
 	fc.trackFunctionCall("exit")
 	fc.eb.GenerateCallInstruction("exit")
 
@@ -6344,6 +6846,7 @@ func (fc *C67Compiler) emitBoundsCheck(indexReg, lengthReg string) {
 	fc.trackFunctionCall("printf")
 	fc.eb.GenerateCallInstruction("printf")
 	fc.out.MovImmToReg("rdi", "1")
+	fc.emitFlushStdout()
 	fc.trackFunctionCall("exit")
 	fc.eb.GenerateCallInstruction("exit")
 
@@ -6354,6 +6857,7 @@ func (fc *C67Compiler) emitBoundsCheck(indexReg, lengthReg string) {
 	fc.trackFunctionCall("printf")
 	fc.eb.GenerateCallInstruction("printf")
 	fc.out.MovImmToReg("rdi", "1")
+	fc.emitFlushStdout()
 	fc.trackFunctionCall("exit")
 	fc.eb.GenerateCallInstruction("exit")
 
@@ -6595,7 +7099,14 @@ func (fc *C67Compiler) generateLambdaFunctions() {
 		fc.lambdaOffsets[lambda.Name] = offsetBefore
 
 		// Mark the start of the lambda function with a label (again, to update offset)
-		fc.eb.MarkLabel(lambda.Name)
+		fc.eb.MarkFunction(lambda.Name)
+		fc.emitTraceThunk(lambda.Name)
+
+		// Arithmetic on the parameters needs no frame (see leaf.go)
+		if fc.canOmitFrame(&lambda) {
+			fc.generateLeafLambda(&lambda)
+			continue
+		}
 
 		// Function prologue with proper calling convention
 		fc.out.PushReg("rbp")
@@ -6648,12 +7159,28 @@ func (fc *C67Compiler) generateLambdaFunctions() {
 		for k, v := range oldMutableVars {
 			fc.mutableVars[k] = v
 		}
+		oldHashMapVars := fc.hashMapVars
+		fc.hashMapVars = make(map[string]bool)
+		for k, v := range oldHashMapVars {
+			fc.hashMapVars[k] = v
+		}
+		oldPersistentVars := fc.persistentVars
+		fc.persistentVars = make(map[string]bool)
+		for k, v := range oldPersistentVars {
+			fc.persistentVars[k] = v
+		}
+		oldSharedMapVars := fc.sharedMapVars
+		fc.sharedMapVars = make(map[string]bool)
+		for k, v := range oldSharedMapVars {
+			fc.sharedMapVars[k] = v
+		}
 		fc.stackOffset = 0
 		fc.runtimeStack = 0 // Not used in new convention
+		fc.startStackFrame()
 
 		// Store parameters from xmm registers at fixed offsets
 		// Parameters come in xmm0, xmm1, xmm2, ...
-		xmmRegs := []string{"xmm0", "xmm1", "xmm2", "xmm3", "xmm4", "xmm5"}
+		xmmRegs := lambdaArgRegs(ArchX86_64)
 		baseParamOffset := 24 // First param at rbp-24
 
 		// CRITICAL: If variadic, save ALL xmm registers immediately
@@ -6668,7 +7195,7 @@ func (fc *C67Compiler) generateLambdaFunctions() {
 
 		for i, paramName := range lambda.Params {
 			if i >= len(xmmRegs) {
-				compilerError("lambda has too many parameters (max 6)")
+				compilerError("lambda has too many parameters (max %d)", maxLambdaArgs)
 			}
 
 			// Calculate fixed offset for this parameter
@@ -6676,6 +7203,9 @@ func (fc *C67Compiler) generateLambdaFunctions() {
 			fc.stackOffset = paramOffset // Track for collectSymbols compatibility
 			fc.variables[paramName] = paramOffset
 			fc.mutableVars[paramName] = false
+			delete(fc.hashMapVars, paramName)
+			delete(fc.persistentVars, paramName)
+			delete(fc.sharedMapVars, paramName)
 
 			// Mark parameter type as "number" by default (all values are float64 in C67)
 			// This prevents x + y from being interpreted as list append when x and y are parameters
@@ -6714,9 +7244,7 @@ func (fc *C67Compiler) generateLambdaFunctions() {
 			// Skip if no variadic args (r14 == 0)
 			skipLabel := fc.nextLabel()
 			fc.out.CmpRegToImm("r14", 0)
-			fc.out.Write(0x74) // JE (jump if equal)
-			fc.out.Write(0x00) // Will be patched
-			skipPos := fc.eb.text.Len() - 1
+			skipJump := fc.forwardJump(JumpEqual)
 
 			// Calculate list size: 8 (count) + r14 * 16 (key+value pairs)
 			// size = 8 + r14 * 16 = 8 + r14 << 4
@@ -6752,23 +7280,15 @@ func (fc *C67Compiler) generateLambdaFunctions() {
 			// Copy variadic arguments from saved xmm locations to list
 			// The xmm registers were saved at offset savedXmmOffset earlier
 			// Arguments are at xmmRegs[paramCount] through xmmRegs[paramCount + r14 - 1]
-			maxVariadic := 6 - paramCount
-			if maxVariadic > 6 {
-				maxVariadic = 6
-			}
+			maxVariadic := len(xmmRegs) - paramCount
 
 			for i := 0; i < maxVariadic; i++ {
 				xmmIdx := paramCount + i
-				if xmmIdx >= 6 {
-					break
-				}
 
 				// Check if this arg exists (i < r14)
 				checkLabel := fc.nextLabel()
 				fc.out.CmpRegToImm("r14", int64(i+1))
-				fc.out.Write(0x7C) // JL (jump if r14 < i+1, meaning this arg doesn't exist)
-				fc.out.Write(0x00) // Will be patched
-				checkPos := fc.eb.text.Len() - 1
+				checkJump := fc.forwardJump(JumpLess) // r14 < i+1: this arg doesn't exist
 
 				// This arg exists - load from saved location and store in list
 				keyOffset := 8 + i*16
@@ -6785,9 +7305,8 @@ func (fc *C67Compiler) generateLambdaFunctions() {
 				fc.out.MovXmmToMem("xmm15", "rax", valOffset)
 
 				// Patch the check jump to here (skip storing this arg)
-				checkTarget := fc.eb.text.Len()
-				fc.patchJumpImmediate(checkPos, int32(checkTarget-(checkPos+1)))
-				fc.defineLabel(checkLabel, checkTarget)
+				fc.defineLabel(checkLabel, fc.eb.text.Len())
+				fc.landForwardJump(checkJump)
 			}
 
 			// Store list pointer in variadic parameter location
@@ -6796,14 +7315,11 @@ func (fc *C67Compiler) generateLambdaFunctions() {
 
 			// Jump over empty list creation
 			hasArgsLabel := fc.nextLabel()
-			fc.out.Write(0xEB) // JMP short
-			fc.out.Write(0x00) // Will be patched
-			hasArgsPos := fc.eb.text.Len() - 1
+			hasArgsJump := fc.forwardJumpAlways()
 
 			// Empty list path (when r14==0)
-			skipTarget := fc.eb.text.Len()
-			fc.patchJumpImmediate(skipPos, int32(skipTarget-(skipPos+1)))
-			fc.defineLabel(skipLabel, skipTarget)
+			fc.defineLabel(skipLabel, fc.eb.text.Len())
+			fc.landForwardJump(skipJump)
 
 			// Create static empty list
 			emptyListLabel := fmt.Sprintf("variadic_empty_%d", fc.stringCounter)
@@ -6815,9 +7331,8 @@ func (fc *C67Compiler) generateLambdaFunctions() {
 			fc.out.MovXmmToMem("xmm15", "rbp", -variadicOffset)
 
 			// Patch has-args jump
-			hasArgsTarget := fc.eb.text.Len()
-			fc.patchJumpImmediate(hasArgsPos, int32(hasArgsTarget-(hasArgsPos+1)))
-			fc.defineLabel(hasArgsLabel, hasArgsTarget)
+			fc.defineLabel(hasArgsLabel, fc.eb.text.Len())
+			fc.landForwardJump(hasArgsJump)
 		}
 
 		// Add captured variables to the lambda's scope
@@ -6848,6 +7363,7 @@ func (fc *C67Compiler) generateLambdaFunctions() {
 		// Set current lambda context for "me" self-reference and tail recursion
 		fc.currentLambda = &lambda
 		fc.lambdaBodyStart = fc.eb.text.Len()
+		fc.emitSafepoint()
 
 		// Note: Don't call collectLoopsFromExpression here - symbols will be collected
 		// when we compile the BlockExpr. Calling it here causes duplicate symbol collection.
@@ -6857,6 +7373,7 @@ func (fc *C67Compiler) generateLambdaFunctions() {
 
 		// Compile lambda body (result in xmm0)
 		fc.compileExpression(lambda.Body)
+		fc.checkStackFrame(lambda.Name, 16+frameSize)
 
 		fc.popDeferScope()
 
@@ -6879,6 +7396,9 @@ func (fc *C67Compiler) generateLambdaFunctions() {
 		// Restore previous state
 		fc.variables = oldVariables
 		fc.mutableVars = oldMutableVars
+		fc.hashMapVars = oldHashMapVars
+		fc.persistentVars = oldPersistentVars
+		fc.sharedMapVars = oldSharedMapVars
 		fc.stackOffset = oldStackOffset
 		fc.runtimeStack = oldRuntimeStack
 	}
@@ -6894,7 +7414,8 @@ func (fc *C67Compiler) generatePatternLambdaFunctions() {
 		}
 		// Record offset
 		fc.lambdaOffsets[patternLambda.Name] = fc.eb.text.Len()
-		fc.eb.MarkLabel(patternLambda.Name)
+		fc.eb.MarkFunction(patternLambda.Name)
+		fc.emitTraceThunk(patternLambda.Name)
 
 		// Function prologue
 		fc.out.PushReg("rbp")
@@ -6904,10 +7425,16 @@ func (fc *C67Compiler) generatePatternLambdaFunctions() {
 		oldVariables := fc.variables
 		oldMutableVars := fc.mutableVars
 		oldStackOffset := fc.stackOffset
+		oldRuntimeStack := fc.runtimeStack
 
 		fc.variables = make(map[string]int)
 		fc.mutableVars = make(map[string]bool)
-		fc.stackOffset = 0
+		fc.runtimeStack = 0
+
+		// Save rbx at [rbp-8] like other lambdas, clause bodies may clobber it
+		fc.out.SubImmFromReg("rsp", 16)
+		fc.out.MovRegToMem("rbx", "rbp", -8)
+		fc.stackOffset = 16
 
 		if VerboseMode {
 			fmt.Fprintf(os.Stderr, "DEBUG generatePatternLambdaFunctions: reset variables map for '%s', fc.variables=%v\n", patternLambda.Name, fc.variables)
@@ -6917,7 +7444,7 @@ func (fc *C67Compiler) generatePatternLambdaFunctions() {
 		numParams := len(patternLambda.Clauses[0].Patterns)
 
 		// Store parameters from xmm0, xmm1, ... to stack
-		xmmRegs := []string{"xmm0", "xmm1", "xmm2", "xmm3", "xmm4", "xmm5"}
+		xmmRegs := lambdaArgRegs(ArchX86_64)
 		paramOffsets := make([]int, numParams)
 		for i := 0; i < numParams; i++ {
 			fc.stackOffset += 16
@@ -6925,6 +7452,8 @@ func (fc *C67Compiler) generatePatternLambdaFunctions() {
 			fc.out.SubImmFromReg("rsp", 16)
 			fc.out.MovXmmToMem(xmmRegs[i], "rbp", -paramOffsets[i])
 		}
+		paramsEnd := fc.stackOffset
+		fc.startStackFrame()
 
 		// Generate pattern matching code
 		// For each clause, check if patterns match, execute body if so
@@ -6938,20 +7467,40 @@ func (fc *C67Compiler) generatePatternLambdaFunctions() {
 		failLabel := fmt.Sprintf("pattern_fail_%d", fc.labelCounter)
 
 		// Track all jumps that need patching across all clauses
-		type jumpPatch struct {
-			jumpPos int
-			target  string
+		var allJumps []patternJump
+
+		// Leading clauses with literal first patterns are dispatched on the value
+		dispatch := planPatternDispatch(patternLambda.Clauses)
+		afterDispatch := failLabel
+		if dispatch != nil {
+			if dispatch.count < len(clauseLabels) {
+				afterDispatch = clauseLabels[dispatch.count]
+			}
+			allJumps = append(allJumps, fc.emitPatternDispatch(dispatch, paramOffsets[0], clauseLabels, afterDispatch)...)
 		}
-		var allJumps []jumpPatch
 
 		for clauseIdx, clause := range patternLambda.Clauses {
 			fc.eb.MarkLabel(clauseLabels[clauseIdx])
 
+			// Earlier clauses may have bound variables before failing to match,
+			// so every clause starts its bindings right after the parameters
+			fc.stackOffset = paramsEnd
+			fc.out.MovRegToReg("rsp", "rbp")
+			fc.out.SubImmFromReg("rsp", int64(paramsEnd))
+
 			// Determine target for failed pattern matches in this clause
 			nextTarget := failLabel
 			if clauseIdx < len(patternLambda.Clauses)-1 {
 				nextTarget = clauseLabels[clauseIdx+1]
 			}
+			dispatched := dispatch != nil && clauseIdx < dispatch.count
+			if dispatched {
+				// Only clauses with the same literal can match after this one
+				nextTarget = afterDispatch
+				if next := dispatch.nextClause[clauseIdx]; next >= 0 {
+					nextTarget = clauseLabels[next]
+				}
+			}
 
 			// Check each pattern in this clause
 			for paramIdx, pattern := range clause.Patterns {
@@ -6959,14 +7508,21 @@ func (fc *C67Compiler) generatePatternLambdaFunctions() {
 
 				switch p := pattern.(type) {
 				case *LiteralPattern:
+					if dispatched && paramIdx == 0 {
+						// The dispatch already compared the first parameter
+						break
+					}
 					// Compare parameter against literal value
 					fc.compileExpression(p.Value) // Result in xmm0
 					fc.out.MovMemToXmm("xmm1", "rbp", -paramOffset)
 					fc.out.Ucomisd("xmm0", "xmm1")
-					// If not equal, jump to next clause
+					// If not equal (or NaN), jump to next clause
 					jumpOffset := fc.eb.text.Len()
 					fc.out.JumpConditional(JumpNotEqual, 0)
-					allJumps = append(allJumps, jumpPatch{jumpOffset, nextTarget})
+					allJumps = append(allJumps, patternJump{pos: jumpOffset, target: nextTarget})
+					jumpOffset = fc.eb.text.Len()
+					fc.out.JumpConditional(JumpParity, 0)
+					allJumps = append(allJumps, patternJump{pos: jumpOffset, target: nextTarget})
 
 				case *VarPattern:
 					// Bind parameter to variable name
@@ -6987,6 +7543,7 @@ func (fc *C67Compiler) generatePatternLambdaFunctions() {
 			fc.compileExpression(clause.Body)
 
 			// After executing body, return (don't fall through to next clause)
+			fc.out.MovMemToReg("rbx", "rbp", -8)
 			fc.out.MovRegToReg("rsp", "rbp")
 
 			fc.out.PopReg("rbp")
@@ -6997,25 +7554,13 @@ func (fc *C67Compiler) generatePatternLambdaFunctions() {
 		fc.eb.MarkLabel(failLabel)
 
 		// Now patch all jumps after all labels have been marked
-		for _, jump := range allJumps {
-			targetOffset := fc.eb.LabelOffset(jump.target)
-			if targetOffset < 0 {
-				compilerError("pattern lambda jump target not found: %s", jump.target)
-			}
-			offset := int32(targetOffset - (jump.jumpPos + 6)) // 6 = size of conditional jump instruction
-			if VerboseMode {
-				fmt.Fprintf(os.Stderr, "DEBUG: Patching jump at %d to target %s (offset %d -> %d, relative %d)\n",
-					jump.jumpPos, jump.target, jump.jumpPos, targetOffset, offset)
-			}
-			fc.eb.text.Bytes()[jump.jumpPos+2] = byte(offset)
-			fc.eb.text.Bytes()[jump.jumpPos+3] = byte(offset >> 8)
-			fc.eb.text.Bytes()[jump.jumpPos+4] = byte(offset >> 16)
-			fc.eb.text.Bytes()[jump.jumpPos+5] = byte(offset >> 24)
-		}
+		fc.checkStackFrame(patternLambda.Name, 16+paramsEnd)
+		fc.patchPatternJumps(allJumps)
 		// No pattern matched - return 0
 		fc.out.XorpdXmm("xmm0", "xmm0")
 
 		// Function epilogue
+		fc.out.MovMemToReg("rbx", "rbp", -8)
 		fc.out.MovRegToReg("rsp", "rbp")
 
 		fc.out.PopReg("rbp")
@@ -7025,6 +7570,7 @@ func (fc *C67Compiler) generatePatternLambdaFunctions() {
 		fc.variables = oldVariables
 		fc.mutableVars = oldMutableVars
 		fc.stackOffset = oldStackOffset
+		fc.runtimeStack = oldRuntimeStack
 	}
 }
 
@@ -7107,7 +7653,7 @@ func (fc *C67Compiler) patchHotFunctionTable() {
 }
 
 func (fc *C67Compiler) generateCacheLookup() {
-	fc.eb.MarkLabel("c67_cache_lookup")
+	fc.eb.MarkFunction("c67_cache_lookup")
 
 	fc.out.PushReg("rbp")
 	fc.out.MovRegToReg("rbp", "rsp")
@@ -7174,7 +7720,7 @@ func (fc *C67Compiler) generateCacheLookup() {
 }
 
 func (fc *C67Compiler) generateCacheInsert() {
-	fc.eb.MarkLabel("c67_cache_insert")
+	fc.eb.MarkFunction("c67_cache_insert")
 
 	fc.out.PushReg("rbp")
 	fc.out.MovRegToReg("rbp", "rsp")
@@ -7208,29 +7754,143 @@ func (fc *C67Compiler) generateCacheInsert() {
 	fc.out.MovRegToReg("rax", "r13")
 	fc.out.AndRegWithImm("rax", 31)
 
-	fc.out.Emit([]byte{0x48, 0xc1, 0xe0, 0x04})
-	fc.out.AddRegToReg("rax", "rdi")
-	fc.out.MovRegToMem("r13", "rax", 0)
-	fc.out.MovRegToMem("r14", "rax", 8)
-	fc.out.PopReg("r15")
-	fc.out.PopReg("r14")
-	fc.out.PopReg("r13")
-	fc.out.PopReg("r12")
-	fc.out.PopReg("rbx")
-	fc.out.PopReg("rbp")
-	fc.out.Ret()
-}
+	fc.out.Emit([]byte{0x48, 0xc1, 0xe0, 0x04})
+	fc.out.AddRegToReg("rax", "rdi")
+	fc.out.MovRegToMem("r13", "rax", 0)
+	fc.out.MovRegToMem("r14", "rax", 8)
+	fc.out.PopReg("r15")
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+func (fc *C67Compiler) generateRuntimeHelpers() {
+	// Arena runtime functions are generated inline below (c67_arena_create, alloc, etc)
+	// Don't call fc.eb.EmitArenaRuntimeCode() as it's the old stub from main.go
+	// Arena symbols are predeclared earlier in writeELF() to ensure they're available during code generation
+
+	// Error exits of the program code go first, out of its way
+	fc.emitColdPaths()
+	fc.runtimeHelpersStart = fc.eb.text.Len()
+
+	// Generate syscall-based printf runtime on Linux
+	fc.GeneratePrintfSyscallRuntime()
+
+	fc.generateCacheLookup()
+	fc.generateCacheInsert()
+
+	if fc.usesSharedMap {
+		fc.generateSharedMapHelpers()
+	}
+
+	if fc.usesHashMap {
+		fc.generateHashMapHelpers()
+	}
+
+	if fc.usesPersistent {
+		fc.generatePersistentHelpers()
+	}
+
+	if fc.usesFieldnames {
+		fc.generateFieldnamesHelper()
+	}
+
+	if fc.usesRuntimeInit {
+		fc.generateRuntimeInit()
+	}
+
+	fc.generateCPUDispatchHelpers()
+
+	if fc.usesVecMath {
+		fc.generateVecMathHelpers()
+	}
+
+	if fc.usesSliceAssign {
+		fc.generateSliceAssignHelper()
+	}
+
+	if fc.usesCopy {
+		fc.generateCopyHelpers()
+	}
+
+	if fc.usesFinalizers {
+		fc.generateFinalizerHelpers()
+	}
+
+	if fc.gcEnabled() {
+		fc.generateGCHelpers()
+	}
+
+	if fc.mallocAllocEnabled() {
+		fc.generateMallocAllocHelpers()
+	}
+
+	if fc.traceCountsEnabled() {
+		fc.generateTraceReport()
+	}
+
+	if fc.usesIntern {
+		fc.generateInternHelper()
+	}
+
+	if fc.usesLogging {
+		fc.generateLogHelpers()
+	}
+
+	if fc.usesArgs {
+		fc.generateArgsHelpers()
+	}
+
+	if fc.usesToml {
+		fc.generateTomlHelpers()
+	}
+
+	if fc.usesRun {
+		fc.generateRunHelper()
+	}
+
+	if fc.usesBigload {
+		fc.generateBigloadHelper()
+	}
+
+	if len(fc.usesReduce) > 0 {
+		fc.generateReduceHelpers()
+	}
+
+	if fc.usesSorted {
+		fc.generateSortedHelpers()
+	}
+
+	if fc.usesGrid {
+		fc.generateGridHelpers()
+	}
+
+	if fc.usesGameloop {
+		fc.generateGameloopHelpers()
+	}
+
+	if fc.usesDates {
+		fc.generateDateHelpers()
+	}
 
-func (fc *C67Compiler) generateRuntimeHelpers() {
-	// Arena runtime functions are generated inline below (c67_arena_create, alloc, etc)
-	// Don't call fc.eb.EmitArenaRuntimeCode() as it's the old stub from main.go
-	// Arena symbols are predeclared earlier in writeELF() to ensure they're available during code generation
+	if fc.usesSecureRand {
+		fc.generateSecureRandHelpers()
+	}
 
-	// Generate syscall-based printf runtime on Linux
-	fc.GeneratePrintfSyscallRuntime()
+	if fc.usesCrashReport {
+		fc.generateCrashReportHelpers()
+	}
 
-	fc.generateCacheLookup()
-	fc.generateCacheInsert()
+	if fc.usesLazyFFI {
+		fc.generateFFIResolve()
+	}
+
+	if fc.usesErrno {
+		fc.generateErrnoHelpers()
+	}
 
 	for lambdaName := range fc.cacheEnabledLambdas {
 		cacheName := lambdaName + "_cache"
@@ -7241,7 +7901,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// Arguments: rdi = left_ptr, rsi = right_ptr
 	// Returns: rax = pointer to new concatenated string
 
-	fc.eb.MarkLabel("_c67_string_concat")
+	fc.eb.MarkFunction("_c67_string_concat")
 
 	// Function prologue
 	fc.out.PushReg("rbp")
@@ -7358,7 +8018,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// Converts a C67 string (map format) to a null-terminated C string
 	// Argument: xmm0 = C67 string pointer (as float64)
 	// Returns: rax = C string pointer
-	fc.eb.MarkLabel("c67_string_to_cstr")
+	fc.eb.MarkFunction("c67_string_to_cstr")
 
 	// Function prologue
 	fc.out.PushReg("rbp")
@@ -7372,9 +8032,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	fc.out.PushReg("r15") // r15 = output byte position
 
 	// Stack alignment FIX: call(8) + 6 pushes(48) = 56 bytes (MISALIGNED!)
-	// Sub 16 keeps stack aligned for malloc call
-	// Stack alignment FIX: call(8) + 6 pushes(48) = 56 bytes (MISALIGNED!)
-	// Sub 16 keeps stack aligned for malloc call
+	// Sub 16 keeps stack aligned for the arena allocation
 
 	// Convert float64 pointer to integer pointer in r12
 	fc.out.SubImmFromReg("rsp", StackSlotSize)
@@ -7385,23 +8043,17 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	fc.out.MovMemToXmm("xmm0", "r12", 0)
 	fc.out.Emit([]byte{0xf2, 0x4c, 0x0f, 0x2c, 0xf0}) // cvttsd2si r14, xmm0 (r14 = codepoint count)
 
-	// DO NOT USE MALLOC! See MEMORY.md - C string conversion should use arena allocation
-	// TODO: Replace with arena allocation (or stack for small strings)
-	// Allocate memory: count * 4 + 1 for UTF-8 (max 4 bytes per codepoint + null)
+	// Allocate memory in the arena: count * 4 + 1 for UTF-8 (max 4 bytes per codepoint + null)
 	// Calculate size in temporary register
 	fc.out.MovRegToReg("rax", "r14")
 	fc.out.Emit([]byte{0x48, 0xc1, 0xe0, 0x02}) // shl rax, 2 (multiply by 4)
 	fc.out.Emit([]byte{0x48, 0x83, 0xc0, 0x01}) // add rax, 1
 
-	// Platform-specific calling convention for malloc
+	// callArenaAlloc takes the size in rdi on every platform
+	fc.out.MovRegToReg("rdi", "rax")
 	if fc.eb.target.OS() == OSWindows {
-		// Windows x64: first arg in rcx
-		fc.out.MovRegToReg("rcx", "rax")
 		// Allocate shadow space (32 bytes) for Windows calling convention
 		fc.out.SubImmFromReg("rsp", 32)
-	} else {
-		// SysV (Linux/Unix): first arg in rdi
-		fc.out.MovRegToReg("rdi", "rax")
 	}
 
 	// Allocate from arena
@@ -7555,7 +8207,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// Converts a null-terminated C string to a C67 string (map format)
 	// Argument: rdi = C string pointer
 	// Returns: xmm0 = C67 string pointer (as float64)
-	fc.eb.MarkLabel("cstr_to_c67_string")
+	fc.eb.MarkFunction("cstr_to_c67_string")
 
 	// Function prologue
 	fc.out.PushReg("rbp")
@@ -7661,7 +8313,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// String format (map): [count (float64)][key0 (float64)][val0 (float64)]...
 	// Note: Currently only step == 1 is fully supported
 
-	fc.eb.MarkLabel("c67_slice_string")
+	fc.eb.MarkFunction("c67_slice_string")
 
 	// Function prologue
 	fc.out.PushReg("rbp")
@@ -7848,7 +8500,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// Returns: rax = pointer to new concatenated list
 	// List format: [length (8 bytes)][elem0 (8 bytes)][elem1 (8 bytes)]...
 
-	fc.eb.MarkLabel("_c67_list_concat")
+	fc.eb.MarkFunction("_c67_list_concat")
 
 	// Function prologue
 	fc.out.PushReg("rbp")
@@ -7901,37 +8553,17 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 
 	// Copy left list elements
 	// memcpy(r10 + 8, r12 + 8, r14 * 8)
-	fc.out.Emit([]byte{0x4d, 0x89, 0xf1})             // mov r9, r14 (counter)
 	fc.out.Emit([]byte{0x49, 0x8d, 0x74, 0x24, 0x08}) // lea rsi, [r12 + 8]
 	fc.out.Emit([]byte{0x49, 0x8d, 0x7a, 0x08})       // lea rdi, [r10 + 8]
-
-	// Loop to copy left elements
-	fc.eb.MarkLabel("_list_concat_copy_left_loop")
-	fc.out.Emit([]byte{0x4d, 0x85, 0xc9}) // test r9, r9
-	fc.out.Emit([]byte{0x74, 0x17})       // jz +23 bytes (skip loop body)
-
-	fc.out.MovMemToXmm("xmm0", "rsi", 0)        // load element (4 bytes)
-	fc.out.MovXmmToMem("xmm0", "rdi", 0)        // store element (4 bytes)
-	fc.out.Emit([]byte{0x48, 0x83, 0xc6, 0x08}) // add rsi, 8 (4 bytes)
-	fc.out.Emit([]byte{0x48, 0x83, 0xc7, 0x08}) // add rdi, 8 (4 bytes)
-	fc.out.Emit([]byte{0x49, 0xff, 0xc9})       // dec r9 (3 bytes)
-	fc.out.Emit([]byte{0xeb, 0xe4})             // jmp back -28 bytes (2 bytes)
+	fc.out.MovRegToReg("rdx", "r14")
+	fc.callDispatched("_c67_dispatch_copy_qwords")
 
 	// Copy right list elements
 	// memcpy(r10 + 8 + r14*8, r13 + 8, r15 * 8)
 	fc.out.Emit([]byte{0x49, 0x8d, 0x75, 0x08}) // lea rsi, [r13 + 8]
 	// rdi already points to correct position
-
-	fc.eb.MarkLabel("_list_concat_copy_right_loop")
-	fc.out.Emit([]byte{0x4d, 0x85, 0xff}) // test r15, r15
-	fc.out.Emit([]byte{0x74, 0x17})       // jz +23 bytes (skip loop body)
-
-	fc.out.MovMemToXmm("xmm0", "rsi", 0)        // load element (4 bytes)
-	fc.out.MovXmmToMem("xmm0", "rdi", 0)        // store element (4 bytes)
-	fc.out.Emit([]byte{0x48, 0x83, 0xc6, 0x08}) // add rsi, 8 (4 bytes)
-	fc.out.Emit([]byte{0x48, 0x83, 0xc7, 0x08}) // add rdi, 8 (4 bytes)
-	fc.out.Emit([]byte{0x49, 0xff, 0xcf})       // dec r15 (3 bytes)
-	fc.out.Emit([]byte{0xeb, 0xe4})             // jmp back -28 bytes (2 bytes)
+	fc.out.MovRegToReg("rdx", "r15")
+	fc.callDispatched("_c67_dispatch_copy_qwords")
 
 	// Return result pointer in rax
 	fc.out.MovRegToReg("rax", "r10")
@@ -7955,7 +8587,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// Returns: rax = pointer to new repeated list (heap-allocated)
 	// Simple implementation: just call list_concat repeatedly
 
-	fc.eb.MarkLabel("_c67_list_repeat")
+	fc.eb.MarkFunction("_c67_list_repeat")
 
 	// Function prologue
 	fc.out.PushReg("rbp")
@@ -8036,7 +8668,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// Returns: xmm0 = 1.0 if equal, 0.0 if not
 	// String format: [count (8 bytes)][key0 (8)][val0 (8)][key1 (8)][val1 (8)]...
 
-	fc.eb.MarkLabel("_c67_string_eq")
+	fc.eb.MarkFunction("_c67_string_eq")
 
 	fc.out.PushReg("rbp")
 	fc.out.MovRegToReg("rbp", "rsp")
@@ -8168,7 +8800,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// Converts a C67 string to uppercase
 	// Argument: rdi = C67 string pointer (as integer)
 	// Returns: xmm0 = uppercase C67 string pointer (as float64)
-	fc.eb.MarkLabel("upper_string")
+	fc.eb.MarkFunction("upper_string")
 
 	fc.out.PushReg("rbp")
 	fc.out.MovRegToReg("rbp", "rsp")
@@ -8262,7 +8894,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// Converts a C67 string to lowercase
 	// Argument: rdi = C67 string pointer (as integer)
 	// Returns: xmm0 = lowercase C67 string pointer (as float64)
-	fc.eb.MarkLabel("lower_string")
+	fc.eb.MarkFunction("lower_string")
 
 	fc.out.PushReg("rbp")
 	fc.out.MovRegToReg("rbp", "rsp")
@@ -8356,7 +8988,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// Removes leading and trailing whitespace
 	// Argument: rdi = C67 string pointer (as integer)
 	// Returns: xmm0 = trimmed C67 string pointer (as float64)
-	fc.eb.MarkLabel("trim_string")
+	fc.eb.MarkFunction("trim_string")
 
 	fc.out.PushReg("rbp")
 	fc.out.MovRegToReg("rbp", "rsp")
@@ -8568,7 +9200,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// Argument: rdi = capacity (int64)
 	// Returns: rax = arena pointer
 	// Arena structure: [buffer_ptr (8)][capacity (8)][offset (8)][alignment (8)] = 32 bytes header
-	fc.eb.MarkLabel("c67_arena_create")
+	fc.eb.MarkFunction("c67_arena_create")
 
 	fc.out.PushReg("rbp")
 	fc.out.MovRegToReg("rbp", "rsp")
@@ -8636,6 +9268,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	fc.trackFunctionCall("printf")
 	fc.eb.GenerateCallInstruction("printf")
 	fc.out.MovImmToReg("rdi", "1")
+	fc.emitFlushStdout()
 	fc.trackFunctionCall("exit")
 	fc.eb.GenerateCallInstruction("exit")
 
@@ -8644,7 +9277,18 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// If arena is full, reallocs buffer to 2x size
 	// Arguments: rdi = arena_ptr, rsi = size (int64)
 	// Returns: rax = allocated memory pointer
-	fc.eb.MarkLabel("c67_arena_alloc")
+	fc.eb.MarkFunction("c67_arena_alloc")
+	fc.emitTraceArenaAlloc()
+
+	// With --gc, the global arena is the collected heap
+	if fc.gcEnabled() {
+		fc.emitGCAllocDispatch()
+	}
+
+	// With --alloc=malloc, every block comes from malloc
+	if fc.mallocAllocEnabled() {
+		fc.emitMallocAllocDispatch()
+	}
 
 	fc.out.PushReg("rbp")
 	fc.out.MovRegToReg("rbp", "rsp")
@@ -8690,13 +9334,13 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	fc.out.MovMemToReg("r8", "rbx", 0)   // r8 = buffer_ptr
 	fc.out.MovMemToReg("r9", "rbx", 8)   // r9 = capacity
 	fc.out.MovMemToReg("r10", "rbx", 16) // r10 = current offset
-	fc.out.MovMemToReg("r11", "rbx", 24) // r11 = alignment
+	fc.out.MovMemToReg("r14", "rbx", 24) // r14 = alignment (r11 is the parent frame in parallel loops)
 
 	// Align offset: aligned_offset = (offset + alignment - 1) & ~(alignment - 1)
 	fc.out.MovRegToReg("rax", "r10")      // rax = offset
-	fc.out.AddRegToReg("rax", "r11")      // rax = offset + alignment
+	fc.out.AddRegToReg("rax", "r14")      // rax = offset + alignment
 	fc.out.SubImmFromReg("rax", 1)        // rax = offset + alignment - 1
-	fc.out.MovRegToReg("rcx", "r11")      // rcx = alignment
+	fc.out.MovRegToReg("rcx", "r14")      // rcx = alignment
 	fc.out.SubImmFromReg("rcx", 1)        // rcx = alignment - 1
 	fc.out.Emit([]byte{0x48, 0xf7, 0xd1}) // not rcx
 	fc.out.Emit([]byte{0x48, 0x21, 0xc8}) // and rax, rcx (aligned_offset in rax)
@@ -8770,7 +9414,9 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 		fc.out.MovRegToReg("rdx", "r9")     // rdx = new_capacity
 		fc.out.MovImmToReg("r10", "1")      // r10 = MREMAP_MAYMOVE
 		fc.out.MovImmToReg("rax", "25")     // rax = syscall number for mremap
+		fc.out.PushReg("r11")               // syscall clobbers r11
 		fc.out.Syscall()
+		fc.out.PopReg("r11")
 
 		// Check if mremap failed (returns MAP_FAILED = -1 or negative on error)
 		fc.out.CmpRegToImm("rax", -1)
@@ -8822,8 +9468,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	fc.out.MovImmToReg("rdi", "2") // stderr
 	fc.out.LeaSymbolToReg("rsi", errorLabel)
 	fc.out.MovImmToReg("rdx", fmt.Sprintf("%d", len(errorMsg)))
-	fc.out.MovImmToReg("rax", "1") // write syscall
-	fc.out.Syscall()
+	fc.emitBufferedWrite()
 
 	fc.out.MovImmToReg("rdi", "1")  // exit code 1
 	fc.out.MovImmToReg("rax", "60") // exit syscall
@@ -8847,13 +9492,15 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// Generate c67_arena_destroy(arena_ptr)
 	// Frees all memory associated with the arena
 	// Argument: rdi = arena_ptr
-	fc.eb.MarkLabel("c67_arena_destroy")
+	fc.eb.MarkFunction("c67_arena_destroy")
 
 	fc.out.PushReg("rbp")
 	fc.out.MovRegToReg("rbp", "rsp")
 	fc.out.PushReg("rbx")
 
 	fc.out.MovRegToReg("rbx", "rdi") // rbx = arena_ptr
+	fc.emitMallocFreeAll()
+	fc.emitTraceArenaDestroy()
 
 	// Munmap buffer: munmap(ptr, size) via syscall 11
 	fc.out.MovMemToReg("rdi", "rbx", 0) // rdi = buffer_ptr
@@ -8874,11 +9521,17 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// Generate c67_arena_reset(arena_ptr)
 	// Resets the arena offset to 0, effectively freeing all allocations
 	// Argument: rdi = arena_ptr
-	fc.eb.MarkLabel("c67_arena_reset")
+	fc.eb.MarkFunction("c67_arena_reset")
 
 	fc.out.PushReg("rbp")
 	fc.out.MovRegToReg("rbp", "rsp")
 
+	// Run the onfree() cleanups of the arena while its memory is intact
+	if fc.usesFinalizers {
+		fc.emitRunFinalizers()
+	}
+	fc.emitMallocFreeAll()
+
 	// Reset offset to 0
 	fc.out.MovImmToMem(0, "rdi", 16) // [arena_ptr+16] = 0
 
@@ -8889,7 +9542,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// LINKED LIST implementation - creates a cons cell: [head|tail]
 	// Arguments: rdi = element (as float64 bits), rsi = tail pointer (as float64 bits, 0.0 = nil)
 	// Returns: rax = pointer to new cons cell (16 bytes)
-	fc.eb.MarkLabel("_c67_list_cons")
+	fc.eb.MarkFunction("_c67_list_cons")
 
 	// Function prologue
 	fc.out.PushReg("rbp")
@@ -8948,7 +9601,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// LINKED LIST implementation - returns head of cons cell
 	// Argument: rdi = list pointer (as float64 bits, 0.0 = nil)
 	// Returns: xmm0 = first element (or NaN if empty)
-	fc.eb.MarkLabel("_c67_list_head")
+	fc.eb.MarkFunction("_c67_list_head")
 
 	// Function prologue
 	fc.out.PushReg("rbp")
@@ -8956,7 +9609,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 
 	// Check if list pointer is NULL (0)
 	fc.out.TestRegReg("rdi", "rdi")
-	fc.out.Emit([]byte{0x75, 0x12}) // jnz +18 (skip NaN generation)
+	skipNaN := fc.forwardJump(JumpNotEqual)
 
 	// Return NaN for empty list (NULL pointer)
 	fc.out.Emit([]byte{0x48, 0xb8})                                     // mov rax, immediate
@@ -8967,6 +9620,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	fc.out.AddImmToReg("rsp", 8)
 	fc.out.PopReg("rbp")
 	fc.out.Ret()
+	fc.landForwardJump(skipNaN)
 
 	// Load head (first element) at [cell+0]
 	fc.out.MovMemToXmm("xmm0", "rdi", 0)
@@ -8979,7 +9633,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// LINKED LIST implementation - returns tail of cons cell (O(1) operation)
 	// Argument: rdi = list pointer (as float64 bits, 0.0 = nil)
 	// Returns: xmm0 = tail pointer (as float64, 0.0 = nil)
-	fc.eb.MarkLabel("_c67_list_tail")
+	fc.eb.MarkFunction("_c67_list_tail")
 
 	// Function prologue
 	fc.out.PushReg("rbp")
@@ -8987,7 +9641,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 
 	// Check if list pointer is NULL (0)
 	fc.out.TestRegReg("rdi", "rdi")
-	fc.out.Emit([]byte{0x75, 0x0e}) // jnz +14 (skip returning 0.0)
+	skipZero := fc.forwardJump(JumpNotEqual)
 
 	// Return 0.0 for empty list (NULL pointer)
 	fc.out.XorRegWithReg("rax", "rax")
@@ -8997,6 +9651,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	fc.out.AddImmToReg("rsp", 8)
 	fc.out.PopReg("rbp")
 	fc.out.Ret()
+	fc.landForwardJump(skipZero)
 
 	// Load tail pointer at [cell+8]
 	fc.out.MovMemToXmm("xmm0", "rdi", 8)
@@ -9009,7 +9664,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// LINKED LIST implementation - walks list and counts nodes (O(n))
 	// Argument: rdi = list pointer (as float64 bits, 0.0 = nil)
 	// Returns: rax = length as int64
-	fc.eb.MarkLabel("_c67_list_length")
+	fc.eb.MarkFunction("_c67_list_length")
 
 	// Function prologue
 	fc.out.PushReg("rbp")
@@ -9047,7 +9702,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// LINKED LIST implementation - walks to index-th node (O(n))
 	// Arguments: rdi = list pointer (as float64 bits), rsi = index (int64)
 	// Returns: xmm0 = element at index (or NaN if out of bounds)
-	fc.eb.MarkLabel("_c67_list_index")
+	fc.eb.MarkFunction("_c67_list_index")
 
 	// Function prologue
 	fc.out.PushReg("rbp")
@@ -9110,7 +9765,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// Arguments: rdi = list_ptr, rsi = index (integer), xmm0 = new value (float64), rdx = arena_ptr
 	// Returns: rax = new list pointer
 	// Uses specified arena for allocation
-	fc.eb.MarkLabel("_c67_list_update")
+	fc.eb.MarkFunction("_c67_list_update")
 
 	// Function prologue
 	fc.out.PushReg("rbp")
@@ -9206,7 +9861,7 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 
 	// Generate _c67_string_println(string_ptr) - prints string followed by newline
 	// Argument: rdi/rcx (platform-dependent) = string pointer (map with [count][0][char0][1][char1]...)
-	fc.eb.MarkLabel("_c67_string_println")
+	fc.eb.MarkFunction("_c67_string_println")
 
 	// For Windows, we use a simpler approach: call printf for each character
 	// For Unix, we use write syscall for efficiency
@@ -9324,11 +9979,10 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 		fc.out.MovRegToMem("rdi", "r13", 0)
 
 		// write(1, buffer, 1)
-		fc.out.MovImmToReg("rax", "1")   // syscall: write
 		fc.out.MovImmToReg("rdi", "1")   // fd: stdout
 		fc.out.MovRegToReg("rsi", "r13") // buffer
 		fc.out.MovImmToReg("rdx", "1")   // length: 1
-		fc.out.Syscall()
+		fc.emitBufferedWrite()
 
 		// Increment and loop
 		fc.out.IncReg("r14")
@@ -9342,11 +9996,10 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 		// Print newline
 		fc.out.MovImmToReg("rax", "10") // '\n'
 		fc.out.MovRegToMem("rax", "r13", 0)
-		fc.out.MovImmToReg("rax", "1")   // syscall: write
 		fc.out.MovImmToReg("rdi", "1")   // fd: stdout
 		fc.out.MovRegToReg("rsi", "r13") // buffer
 		fc.out.MovImmToReg("rdx", "1")   // length: 1
-		fc.out.Syscall()
+		fc.emitBufferedWrite()
 
 		// Restore
 		fc.out.AddImmToReg("rsp", 8)
@@ -9361,8 +10014,12 @@ func (fc *C67Compiler) generateRuntimeHelpers() {
 	// Generate _c67_itoa for number to string conversion
 	fc.generateItoa()
 
-	// Generate syscall-based print helpers for Linux
+	// Generate _c67_dtoa and _c67_dtoa_fixed for float to string conversion
+	fc.generateDtoa()
+
+	// Generate the buffered stdout writer and syscall-based print helpers for Linux
 	if fc.eb.target.OS() == OSLinux {
+		fc.generateStdoutBuffer()
 		fc.generatePrintSyscall()
 		fc.generatePrintlnSyscall()
 	}
@@ -9478,14 +10135,13 @@ func (fc *C67Compiler) cleanupAllArenas() {
 	skipCleanupJump := fc.eb.text.Len()
 	fc.out.JumpConditional(JumpEqual, 0) // je skip_cleanup
 
-	// Load meta-arena length
-	fc.out.LeaSymbolToReg("rax", "_c67_arena_meta_len")
-	fc.out.MovMemToReg("rcx", "rax", 0) // rcx = number of arenas
-
 	// Loop through all arenas and free them
 	fc.out.XorRegWithReg("r8", "r8") // r8 = index = 0
 
 	cleanupLoopStart := fc.eb.text.Len()
+	// Load meta-arena length, again in every round since syscall clobbers rcx
+	fc.out.LeaSymbolToReg("rax", "_c67_arena_meta_len")
+	fc.out.MovMemToReg("rcx", "rax", 0) // rcx = number of arenas
 	fc.out.CmpRegToReg("r8", "rcx")
 	skipCleanupEnd := fc.eb.text.Len()
 	fc.out.JumpConditional(JumpGreaterOrEqual, 0) // jge cleanup_done
@@ -9496,6 +10152,12 @@ func (fc *C67Compiler) cleanupAllArenas() {
 	fc.out.AddRegToReg("rax", "rbx")
 
 	fc.out.MovMemToReg("r9", "rax", 0) // r9 = arena struct pointer
+	fc.out.TestRegReg("r9", "r9")
+	unusedSlot := fc.forwardJump(JumpEqual)
+	if fc.mallocAllocEnabled() {
+		fc.out.MovRegToReg("rdi", "r9")
+		fc.emitMallocFreeAll()
+	}
 
 	// Munmap buffer: munmap(ptr, size)
 	fc.out.MovMemToReg("rdi", "r9", 0) // rdi = buffer_ptr (arena[0])
@@ -9530,6 +10192,7 @@ func (fc *C67Compiler) cleanupAllArenas() {
 	}
 
 	// Increment index
+	fc.landForwardJump(unusedSlot)
 	fc.out.AddImmToReg("r8", 1)
 	backOffset := int32(cleanupLoopStart - (fc.eb.text.Len() + UnconditionalJumpSize))
 	fc.out.JumpUnconditional(backOffset)
@@ -9569,7 +10232,7 @@ func (fc *C67Compiler) generateItoa() {
 	// Define global buffer for itoa (32 bytes)
 	fc.eb.DefineWritable("_itoa_buffer", string(make([]byte, 32)))
 
-	fc.eb.MarkLabel("_c67_itoa")
+	fc.eb.MarkFunction("_c67_itoa")
 
 	// Prologue
 	fc.out.PushReg("rbp")
@@ -9684,8 +10347,13 @@ func (fc *C67Compiler) generateItoa() {
 // generateArenaEnsureCapacity generates the _c67_arena_ensure_capacity function
 // This function ensures the meta-arena has enough capacity for the requested depth
 // Argument: rdi = required_depth
+//
+// Arena blocks in parallel loop bodies call this from several threads. The
+// arenas below _c67_arena_meta_len are ready, and len is only raised after
+// their slots are written, so the common case needs no lock. Growing takes
+// _c67_arena_meta_lock, and checks len again once it has it.
 func (fc *C67Compiler) generateArenaEnsureCapacity() {
-	fc.eb.MarkLabel("_c67_arena_ensure_capacity")
+	fc.eb.MarkFunction("_c67_arena_ensure_capacity")
 
 	// Function prologue
 	fc.out.PushReg("rbp")
@@ -9695,10 +10363,28 @@ func (fc *C67Compiler) generateArenaEnsureCapacity() {
 	fc.out.PushReg("r13")
 	fc.out.PushReg("r14")
 	fc.out.PushReg("r15")
+	fc.out.PushReg("r11") // parent frame in parallel loop bodies; syscalls clobber it
 
 	// r12 = required_depth
 	fc.out.MovRegToReg("r12", "rdi")
 
+	// Fast path: return without locking if the arenas already exist
+	fc.out.LeaSymbolToReg("rbx", "_c67_arena_meta_len")
+	fc.out.MovMemToReg("r14", "rbx", 0)
+	fc.out.CmpRegToReg("r12", "r14")
+	fastReturnJump := fc.forwardJump(JumpLessOrEqual)
+
+	// Take the meta-arena lock: spin on lock cmpxchg until it goes from 0 to 1
+	fc.out.LeaSymbolToReg("rbx", "_c67_arena_meta_lock")
+	lockRetry := fc.eb.text.Len()
+	fc.out.XorRegWithReg("rax", "rax")
+	fc.out.MovImmToReg("rcx", "1")
+	fc.out.Emit([]byte{0xf0, 0x48, 0x0f, 0xb1, 0x0b}) // lock cmpxchg [rbx], rcx
+	lockTaken := fc.forwardJump(JumpEqual)
+	fc.out.Emit([]byte{0xf3, 0x90})                                           // pause
+	fc.out.Emit([]byte{0xeb, byte(int8(lockRetry - (fc.eb.text.Len() + 2)))}) // jmp lock_retry
+	fc.landForwardJump(lockTaken)
+
 	// Load current capacity
 	fc.out.LeaSymbolToReg("rbx", "_c67_arena_meta_cap")
 	fc.out.MovMemToReg("r13", "rbx", 0) // r13 = current capacity
@@ -9779,6 +10465,13 @@ func (fc *C67Compiler) generateArenaEnsureCapacity() {
 	fc.patchJumpImmediate(lenOnlyGrowthJump+1, int32(returnLabel-(lenOnlyGrowthJump+5)))
 	fc.patchJumpImmediate(firstGrowCheckJump+2, int32(returnLabel-(firstGrowCheckJump+6)))
 
+	// Release the lock; the stores made while holding it are visible first
+	fc.out.LeaSymbolToReg("rbx", "_c67_arena_meta_lock")
+	fc.out.XorRegWithReg("rax", "rax")
+	fc.out.MovRegToMem("rax", "rbx", 0)
+
+	fc.landForwardJump(fastReturnJump)
+	fc.out.PopReg("r11")
 	fc.out.PopReg("r15")
 	fc.out.PopReg("r14")
 	fc.out.PopReg("r13")
@@ -9793,6 +10486,7 @@ func (fc *C67Compiler) generateArenaEnsureCapacity() {
 	fc.patchJumpImmediate(fc.firstMetaArenaMallocErrorJump+2, int32(errorLabel-(fc.firstMetaArenaMallocErrorJump+6)))
 
 	fc.out.MovImmToReg("rdi", "1")
+	fc.emitFlushStdout()
 	fc.trackFunctionCall("exit")
 	fc.eb.GenerateCallInstruction("exit")
 }
@@ -9844,27 +10538,15 @@ func (fc *C67Compiler) compileStoredFunctionCall(call *CallExpr) {
 	}
 
 	// Compile arguments and put them in xmm registers
-	xmmRegs := []string{"xmm0", "xmm1", "xmm2", "xmm3", "xmm4", "xmm5"}
-	if len(call.Args) > len(xmmRegs) {
-		compilerError("too many arguments to stored function (max 6)")
+	if len(call.Args) > maxLambdaArgs {
+		compilerError("too many arguments to stored function (max %d)", maxLambdaArgs)
 	}
 
 	// Save function pointer and environment to stack (will be clobbered during arg evaluation)
 	fc.out.SubImmFromReg("rsp", 16)
 	fc.out.MovRegToMem("r11", "rsp", 0)
 	fc.out.MovRegToMem("r15", "rsp", 8)
-	// Evaluate all arguments and save to stack
-	for _, arg := range call.Args {
-		fc.compileExpression(arg) // Result in xmm0
-		fc.out.SubImmFromReg("rsp", 16)
-		fc.out.MovXmmToMem("xmm0", "rsp", 0)
-	}
-
-	// Load arguments from stack into xmm registers (in reverse order)
-	for i := len(call.Args) - 1; i >= 0; i-- {
-		fc.out.MovMemToXmm(xmmRegs[i], "rsp", 0)
-		fc.out.AddImmToReg("rsp", 16)
-	}
+	fc.compileLambdaArgs(call.Args)
 
 	// Load function pointer from stack to r11
 	fc.out.MovMemToReg("r11", "rsp", 0)
@@ -9887,6 +10569,66 @@ func (fc *C67Compiler) compileStoredFunctionCall(call *CallExpr) {
 	// Result is in xmm0
 }
 
+// compileLambdaArgs evaluates the arguments of a lambda call into the
+// registers of lambdaArgRegs. Numbers and immutable variables cannot call
+// anything, so they are loaded last, straight into their register. The
+// other arguments are evaluated in order; all but the last of them wait on
+// the stack, since a later one may call a function that clobbers registers.
+func (fc *C67Compiler) compileLambdaArgs(args []Expression) {
+	regs := lambdaArgRegs(ArchX86_64)
+	var computed []int
+	for i, arg := range args {
+		if !fc.isDirectLambdaArg(arg) {
+			computed = append(computed, i)
+		}
+	}
+	for k, i := range computed {
+		fc.compileExpression(args[i]) // Result in xmm0
+		if k < len(computed)-1 {
+			fc.out.SubImmFromReg("rsp", 16)
+			fc.out.MovXmmToMem("xmm0", "rsp", 0)
+		} else if i != 0 {
+			fc.out.MovRegToReg(regs[i], "xmm0")
+		}
+	}
+	for k := len(computed) - 2; k >= 0; k-- {
+		fc.out.MovMemToXmm(regs[computed[k]], "rsp", 0)
+		fc.out.AddImmToReg("rsp", 16)
+	}
+
+	for i, arg := range args {
+		switch a := arg.(type) {
+		case *NumberExpr:
+			fc.out.MovImmToReg("rax", strconv.FormatUint(math.Float64bits(a.Value), 10))
+			fc.out.MovqRegToXmm(regs[i], "rax")
+		case *IdentExpr:
+			if fc.isDirectLambdaArg(a) {
+				baseReg := "rbp"
+				if fc.parentVariables != nil && fc.parentVariables[a.Name] {
+					baseReg = "r11"
+				}
+				fc.out.MovMemToXmm(regs[i], baseReg, -fc.variables[a.Name])
+			}
+		}
+	}
+}
+
+// isDirectLambdaArg reports whether compileLambdaArgs loads arg straight
+// into its register: a number, or an immutable local variable
+func (fc *C67Compiler) isDirectLambdaArg(arg Expression) bool {
+	switch a := arg.(type) {
+	case *NumberExpr:
+		return true
+	case *IdentExpr:
+		if _, isGlobal := fc.globalVars[a.Name]; isGlobal || fc.mutableVars[a.Name] || fc.movedVars[a.Name] {
+			return false
+		}
+		_, exists := fc.variables[a.Name]
+		return exists
+	}
+	return false
+}
+
 func (fc *C67Compiler) compileLambdaDirectCall(call *CallExpr) {
 	// Check if this is a pure function eligible for memoization
 	var targetLambda *LambdaFunc
@@ -9899,7 +10641,7 @@ func (fc *C67Compiler) compileLambdaDirectCall(call *CallExpr) {
 
 	// Direct call to a lambda by name (for recursion)
 	// Compile arguments and put them in xmm registers
-	xmmRegs := []string{"xmm0", "xmm1", "xmm2", "xmm3", "xmm4", "xmm5"}
+	xmmRegs := lambdaArgRegs(ArchX86_64)
 
 	// Check if function is variadic
 	var isVariadic bool
@@ -9915,7 +10657,7 @@ func (fc *C67Compiler) compileLambdaDirectCall(call *CallExpr) {
 	} else {
 
 		if len(call.Args) > len(xmmRegs) {
-			compilerError("too many arguments to lambda function (max 6)")
+			compilerError("too many arguments to lambda function (max %d)", maxLambdaArgs)
 		}
 	}
 
@@ -9925,18 +10667,7 @@ func (fc *C67Compiler) compileLambdaDirectCall(call *CallExpr) {
 		return
 	}
 
-	// Evaluate all arguments and save to stack
-	for _, arg := range call.Args {
-		fc.compileExpression(arg) // Result in xmm0
-		fc.out.SubImmFromReg("rsp", 16)
-		fc.out.MovXmmToMem("xmm0", "rsp", 0)
-	}
-
-	// Load arguments from stack into xmm registers (in reverse order)
-	for i := len(call.Args) - 1; i >= 0; i-- {
-		fc.out.MovMemToXmm(xmmRegs[i], "rsp", 0)
-		fc.out.AddImmToReg("rsp", 16)
-	}
+	fc.compileLambdaArgs(call.Args)
 
 	// If variadic, pass count of variadic arguments in r14
 	if isVariadic {
@@ -10033,7 +10764,11 @@ func (fc *C67Compiler) compileMemoizedCall(call *CallExpr, lambda *LambdaFunc) {
 	// Load argument and call function
 	fc.out.MovMemToXmm("xmm0", "rsp", 0)
 	fc.trackFunctionCall(lambda.Name)
+	tracked := fc.emitRecursionDepthEnter(call)
 	fc.out.CallSymbol(lambda.Name)
+	if tracked {
+		fc.emitRecursionDepthLeave(call)
+	}
 	// xmm0 = result
 
 	// Save result
@@ -10179,32 +10914,29 @@ func (fc *C67Compiler) isFMAPattern(expr Expression) (bool, Expression, Expressi
 	return false, nil, nil, nil
 }
 
-// compileFMA compiles a fused multiply-add: result = a * b + c
-// Uses VFMADD132SD if FMA is available, falls back to mul+add otherwise
-func (fc *C67Compiler) compileFMA(a, b, c Expression) {
+// compileFMA compiles a fused multiply-add: result = a * b + c (or a * b - c)
+// Uses VFMADD132SD/VFMSUB132SD if FMA is available, falls back to mul+add otherwise
+func (fc *C67Compiler) compileFMA(a, b, c Expression, isSub bool) {
 	savedTailPosition := fc.inTailPosition
 	fc.inTailPosition = false
 
-	// Compile c into xmm0 (accumulator)
-	fc.compileExpression(c)
-	fc.out.SubImmFromReg("rsp", 16)
-	fc.out.MovXmmToMem("xmm0", "rsp", 0)
-
-	// Compile a into xmm0
+	// Compile a and b in source order, spilling both to the stack
+	// (function calls in later operands may clobber every XMM register)
 	fc.compileExpression(a)
 	fc.out.SubImmFromReg("rsp", 16)
-	fc.out.MovXmmToMem("xmm0", "rsp", 8)
+	fc.out.MovXmmToMem("xmm0", "rsp", 0)
 
-	// Compile b into xmm1
 	fc.compileExpression(b)
-	fc.out.MovRegToReg("xmm1", "xmm0")
+	fc.out.MovXmmToMem("xmm0", "rsp", 8)
 
-	// Restore a into xmm0
-	fc.out.MovMemToXmm("xmm0", "rsp", 8)
+	// Compile c into xmm2 (addend)
+	fc.compileExpression(c)
+	fc.out.MovRegToReg("xmm2", "xmm0")
 
-	// Restore c into xmm2
-	fc.out.MovMemToXmm("xmm2", "rsp", 16)
-	fc.out.AddImmToReg("rsp", 32)
+	// Restore a into xmm0 and b into xmm1
+	fc.out.MovMemToXmm("xmm0", "rsp", 0)
+	fc.out.MovMemToXmm("xmm1", "rsp", 8)
+	fc.out.AddImmToReg("rsp", 16)
 
 	fc.inTailPosition = savedTailPosition
 
@@ -10220,18 +10952,25 @@ func (fc *C67Compiler) compileFMA(a, b, c Expression) {
 	jzPos := fc.eb.text.Len()
 	fc.out.Emit([]byte{0x0f, 0x84, 0x00, 0x00, 0x00, 0x00}) // jz fallback (6 bytes)
 
-	// FMA path: xmm0 = xmm0 * xmm1 + xmm2
-	// VFMADD132SD xmm0, xmm2, xmm1 => xmm0 = xmm0 * xmm1 + xmm2
-	fc.out.Emit([]byte{0xc4, 0xe2, 0xe9, 0x99, 0xc1}) // vfmadd132sd xmm0, xmm2, xmm1
+	// FMA path: xmm0 = xmm0 * xmm1 +/- xmm2
+	if isSub {
+		fc.out.Emit([]byte{0xc4, 0xe2, 0xe9, 0x9b, 0xc1}) // vfmsub132sd xmm0, xmm2, xmm1
+	} else {
+		fc.out.Emit([]byte{0xc4, 0xe2, 0xe9, 0x99, 0xc1}) // vfmadd132sd xmm0, xmm2, xmm1
+	}
 
 	// Jump over fallback
 	jmpOverPos := fc.eb.text.Len()
 	fc.out.Emit([]byte{0xeb, 0x00}) // jmp end (2 bytes)
 
-	// Fallback path: mul + add
+	// Fallback path: mul + add (or sub)
 	fallbackPos := fc.eb.text.Len()
 	fc.out.MulsdXmm("xmm0", "xmm1") // xmm0 = xmm0 * xmm1
-	fc.out.AddsdXmm("xmm0", "xmm2") // xmm0 = xmm0 + xmm2
+	if isSub {
+		fc.out.SubsdXmm("xmm0", "xmm2") // xmm0 = xmm0 - xmm2
+	} else {
+		fc.out.AddsdXmm("xmm0", "xmm2") // xmm0 = xmm0 + xmm2
+	}
 
 	// End position
 	endPos := fc.eb.text.Len()
@@ -10253,7 +10992,7 @@ func (fc *C67Compiler) compileBinaryOpSafe(left, right Expression, operator stri
 		if leftCall, ok := left.(*DirectCallExpr); ok {
 			if leftIdent, ok := leftCall.Callee.(*IdentExpr); ok && leftIdent.Name == "*" && len(leftCall.Args) == 2 {
 				// Pattern: (a * b) + c
-				fc.compileFMA(leftCall.Args[0], leftCall.Args[1], right)
+				fc.compileFMA(leftCall.Args[0], leftCall.Args[1], right, false)
 				return
 			}
 		}
@@ -10261,7 +11000,7 @@ func (fc *C67Compiler) compileBinaryOpSafe(left, right Expression, operator stri
 		if rightCall, ok := right.(*DirectCallExpr); ok {
 			if rightIdent, ok := rightCall.Callee.(*IdentExpr); ok && rightIdent.Name == "*" && len(rightCall.Args) == 2 {
 				// Pattern: c + (a * b)
-				fc.compileFMA(rightCall.Args[0], rightCall.Args[1], left)
+				fc.compileFMA(rightCall.Args[0], rightCall.Args[1], left, false)
 				return
 			}
 		}
@@ -10409,27 +11148,14 @@ func (fc *C67Compiler) compileDirectCall(call *DirectCallExpr) {
 	fc.out.AddImmToReg("rsp", StackSlotSize)
 
 	// Compile arguments and put them in xmm registers
-	xmmRegs := []string{"xmm0", "xmm1", "xmm2", "xmm3", "xmm4", "xmm5"}
-	if len(call.Args) > len(xmmRegs) {
-		compilerError("too many arguments to direct call (max 6)")
+	if len(call.Args) > maxLambdaArgs {
+		compilerError("too many arguments to direct call (max %d)", maxLambdaArgs)
 	}
 
 	// Save function pointer to stack (rax might get clobbered)
 	fc.out.SubImmFromReg("rsp", 16)
 	fc.out.MovRegToMem("rax", "rsp", 0)
-
-	// Evaluate all arguments and save to stack
-	for _, arg := range call.Args {
-		fc.compileExpression(arg) // Result in xmm0
-		fc.out.SubImmFromReg("rsp", 16)
-		fc.out.MovXmmToMem("xmm0", "rsp", 0)
-	}
-
-	// Load arguments from stack into xmm registers (in reverse order)
-	for i := len(call.Args) - 1; i >= 0; i-- {
-		fc.out.MovMemToXmm(xmmRegs[i], "rsp", 0)
-		fc.out.AddImmToReg("rsp", 16)
-	}
+	fc.compileLambdaArgs(call.Args)
 
 	// Load closure pointer from stack
 	fc.out.MovMemToReg("r11", "rsp", 0)
@@ -11204,6 +11930,43 @@ func (fc *C67Compiler) compileTailRecursiveCall(call *CallExpr) {
 	fc.out.JumpUnconditional(jumpOffset)
 }
 
+// emitRecursionDepthEnter increments the depth counter of a recursive call
+// and aborts when it goes past the call's max, or past
+// --default-recursion-max when the call has no max. Returns false, emitting
+// nothing, when the depth is unlimited. Clobbers rax and rcx.
+func (fc *C67Compiler) emitRecursionDepthEnter(call *CallExpr) bool {
+	maxDepth := int64(math.MaxInt64)
+	if call.NeedsRecursionCheck {
+		maxDepth = call.MaxRecursionDepth
+	} else if DefaultRecursionMax > 0 {
+		maxDepth = DefaultRecursionMax
+	}
+	if maxDepth == math.MaxInt64 {
+		return false
+	}
+
+	// Uses a global variable to track recursion depth: functionName_recursion_depth
+	depthVarName := call.Function + "_recursion_depth"
+	fc.eb.DefineWritable(depthVarName, "\x00\x00\x00\x00\x00\x00\x00\x00")
+	fc.out.LeaSymbolToReg("rcx", depthVarName)
+	fc.out.MovMemToReg("rax", "rcx", 0)
+	fc.out.IncReg("rax")
+	fc.out.MovRegToMem("rax", "rcx", 0)
+	fc.out.MovImmToReg("rcx", strconv.FormatInt(maxDepth, 10))
+	fc.out.CmpRegToReg("rax", "rcx")
+	fc.emitColdAbort(JumpGreater, "_recursion_max_exceeded_msg", "Error: recursion exceeded maximum depth\n")
+	return true
+}
+
+// emitRecursionDepthLeave decrements the depth counter after a tracked
+// recursive call returns. Preserves xmm0. Clobbers rax and rcx.
+func (fc *C67Compiler) emitRecursionDepthLeave(call *CallExpr) {
+	fc.out.LeaSymbolToReg("rcx", call.Function+"_recursion_depth")
+	fc.out.MovMemToReg("rax", "rcx", 0)
+	fc.out.DecReg("rax")
+	fc.out.MovRegToMem("rax", "rcx", 0)
+}
+
 func (fc *C67Compiler) compileRecursiveCall(call *CallExpr) {
 	if fc.inTailPosition {
 		fc.tailCallsOptimized++
@@ -11219,56 +11982,6 @@ func (fc *C67Compiler) compileRecursiveCall(call *CallExpr) {
 		return
 	}
 
-	// Compile a recursive call with optional depth tracking
-	// Only track depth if max is not infinite (for zero runtime overhead with max inf)
-	// TODO: Depth tracking currently disabled - requires writable .bss/.data section support
-	//       Current implementation tries to write to read-only .rodata which fails
-	//       Use "max inf" for unlimited recursion (works perfectly)
-	needsDepthTracking := false // call.MaxRecursionDepth != math.MaxInt64
-	var depthVarName string
-
-	if needsDepthTracking {
-		// Uses a global variable to track recursion depth: functionName_recursion_depth
-		depthVarName = call.Function + "_recursion_depth"
-
-		// Ensure the depth counter variable exists in data section (initialized to 0)
-		if fc.eb.consts[depthVarName] == nil {
-			// Define an 8-byte zero-initialized variable
-			fc.eb.Define(depthVarName, "\x00\x00\x00\x00\x00\x00\x00\x00")
-		}
-
-		// Load current recursion depth
-		fc.out.MovMemToReg("rax", depthVarName, 0)
-
-		// Increment depth
-		fc.out.IncReg("rax")
-
-		// Check against max
-		fc.out.CmpRegToImm("rax", call.MaxRecursionDepth)
-
-		// Jump past error if not exceeded
-		notExceededJump := fc.eb.text.Len()
-		fc.out.JumpConditional(JumpLessOrEqual, 0)
-
-		// Exceeded max recursion - print error and exit
-		fc.out.LeaSymbolToReg("rdi", "_recursion_max_exceeded_msg")
-		fc.trackFunctionCall("printf")
-		fc.eb.GenerateCallInstruction("printf")
-
-		// exit(1)
-		fc.out.MovImmToReg("rdi", "1")
-		fc.trackFunctionCall("exit")
-		fc.eb.GenerateCallInstruction("exit")
-
-		// Patch the jump
-		notExceededPos := fc.eb.text.Len()
-		notExceededOffset := int32(notExceededPos - (notExceededJump + 6))
-		fc.patchJumpImmediate(notExceededJump+2, notExceededOffset)
-
-		// Store incremented depth
-		fc.out.MovRegToMem("rax", depthVarName, 0)
-	}
-
 	// Compile arguments in order and save ALL to stack
 	for _, arg := range call.Args {
 		fc.compileExpression(arg)
@@ -11288,19 +12001,46 @@ func (fc *C67Compiler) compileRecursiveCall(call *CallExpr) {
 
 	// Make the recursive call
 	// Use direct call to lambda symbol (not PLT stub like GenerateCallInstruction)
+	tracked := fc.emitRecursionDepthEnter(call)
 	fc.out.CallSymbol(call.Function)
-
-	// Decrement recursion depth after return (only if tracking)
-	if needsDepthTracking {
-		fc.out.MovMemToReg("rax", depthVarName, 0)
-		fc.out.SubImmFromReg("rax", 1) // Decrement
-		fc.out.MovRegToMem("rax", depthVarName, 0)
+	if tracked {
+		fc.emitRecursionDepthLeave(call)
 	}
 
 	// Result is in xmm0
 }
 
 // Confidence that this function is working: 85%
+// checkCFunctionExists stops with suggestions when funcName is neither
+// exported by the library imported as alias nor declared in its headers.
+// Without a symbol table for the library, as for a linker script, or with
+// --lazy-ffi, which resolves calls when they are made, any name is accepted.
+func (fc *C67Compiler) checkCFunctionExists(alias, funcName string) {
+	symbols := fc.cFunctionSymbols[alias]
+	if len(symbols) == 0 || LazyFFIFlag || slices.Contains(symbols, funcName) {
+		return
+	}
+	known := slices.Clone(symbols)
+	if constants, ok := fc.cConstants[alias]; ok {
+		if _, declared := constants.Functions[funcName]; declared {
+			return
+		}
+		if _, isMacro := constants.Macros[funcName]; isMacro {
+			return
+		}
+		for name := range constants.Functions {
+			known = append(known, name)
+		}
+	}
+	if suggestions := findSimilarNames(funcName, known, 3); len(suggestions) > 0 {
+		for i, name := range suggestions {
+			suggestions[i] = alias + "." + name
+		}
+		compilerError("unknown C function %s.%s. Did you mean: %s?", alias, funcName, strings.Join(suggestions, ", "))
+	}
+	compilerError("unknown C function %s.%s: the library imported as %s does not export it", alias, funcName, alias)
+}
+
 func (fc *C67Compiler) compileCFunctionCall(libName string, funcName string, args []Expression) {
 	// Generate C FFI call
 	// Strategy for v1.1.0:
@@ -11314,11 +12054,16 @@ func (fc *C67Compiler) compileCFunctionCall(libName string, funcName string, arg
 		fmt.Fprintf(os.Stderr, "Generating C FFI call: %s.%s with %d args\n", libName, funcName, len(args))
 	}
 
-	// Track library dependency for ELF generation
-	fc.cLibHandles[libName] = "linked" // Mark as needing dynamic linking
+	// With --lazy-ffi the call goes through a cache slot filled at first use
+	lazySlot := fc.lazyFFISlot(libName, funcName)
 
-	// Track function usage for PLT generation and call order patching
-	fc.trackFunctionCall(funcName)
+	if lazySlot == "" {
+		// Track library dependency for ELF generation
+		fc.cLibHandles[libName] = "linked" // Mark as needing dynamic linking
+
+		// Track function usage for PLT generation and call order patching
+		fc.trackFunctionCall(funcName)
+	}
 
 	// Track which library this function belongs to (for Windows DLL imports)
 	if libName != "" {
@@ -11459,10 +12204,12 @@ func (fc *C67Compiler) compileCFunctionCall(libName string, funcName string, arg
 				} else if info.isFloatParam {
 					info.castType = "double"
 				} else if paramType != "" {
-					if isPointerType(paramType) {
+					if isCStringType(paramType) && exprType != "cstring" && exprType != "cpointer" {
+						// char* parameter: a C67 string is copied to a NUL-terminated
+						// C string, a number is passed on as a pointer
+						info.castType = "autocstr"
+					} else if isPointerType(paramType) {
 						info.castType = "pointer"
-					} else if strings.Contains(paramType, "char") && strings.Contains(paramType, "*") {
-						info.castType = "cstr"
 					} else {
 						info.castType = "int"
 					}
@@ -11581,6 +12328,13 @@ func (fc *C67Compiler) compileCFunctionCall(libName string, funcName string, arg
 						fc.out.Cvttsd2si("rax", "xmm0")
 					}
 
+				case "autocstr":
+					if isNullPointer {
+						// Already set rax to 0 above
+					} else {
+						fc.emitAutoCString()
+					}
+
 				case "int", "i32", "int32":
 					if isNullPointer {
 						// Already set rax to 0 above
@@ -11702,8 +12456,12 @@ func (fc *C67Compiler) compileCFunctionCall(libName string, funcName string, arg
 		// Allocate shadow space for Windows x64 calling convention
 		shadowSpace := fc.allocateShadowSpace()
 
-		// Generate PLT call
-		fc.eb.GenerateCallInstruction(funcName)
+		// Generate PLT call, or call through the lazy FFI cache slot
+		if lazySlot != "" {
+			fc.callDispatched(lazySlot)
+		} else {
+			fc.eb.GenerateCallInstruction(funcName)
+		}
 
 		// Deallocate shadow space
 		fc.deallocateShadowSpace(shadowSpace)
@@ -11722,41 +12480,17 @@ func (fc *C67Compiler) compileCFunctionCall(libName string, funcName string, arg
 			fmt.Fprintf(os.Stderr, "C function %s return type: %q (funcSig=%v)\n", funcName, returnType, funcSig != nil)
 		}
 
-		if returnType == "float" || returnType == "double" {
-			// Result is already in xmm0 as double - no conversion needed
-		} else if returnType == "void" {
-			// Void return - set xmm0 to 0
-			fc.out.XorpdXmm("xmm0", "xmm0")
-		} else if isPointerType(returnType) || returnType == "" {
-			// Pointer type - keep raw integer value but convert to float64 for C67
-			// (C67 internally represents everything as float64)
-			// On Windows and Linux, pointers are 64-bit and returned in RAX correctly
-			// NOTE: When signature is unknown (returnType == ""), assume pointer/64-bit return
-			// This is safer than assuming 32-bit, and works for SDL functions
-			fc.out.Cvtsi2sd("xmm0", "rax")
-		} else {
-			// Integer result in rax - convert to float64 for C67
-			// On Windows: bool returns are 1 byte (AL), int returns are 4 bytes (EAX)
-			// Zero-extend to 64-bit RAX to avoid garbage in upper bits
-			if fc.eb.target.OS() == OSWindows {
-				// Check if this is a bool return (1 byte in AL)
-				if returnType == "bool" || returnType == "_Bool" {
-					// movzx eax, al (zero-extend 8-bit AL to 32-bit EAX, then to RAX)
-					fc.out.MovzxRegReg("eax", "al")
-				} else {
-					// For int/int32/etc returns: use EAX directly (upper 32 bits of RAX auto-zeroed)
-					// mov eax, eax (zero-extends EAX to 64-bit RAX)
-					fc.out.MovRegToReg("eax", "eax")
-				}
-			}
-			fc.out.Cvtsi2sd("xmm0", "rax")
-		}
+		fc.convertCReturnValue(returnType)
 	} else {
 		// No arguments - just call the function
 		// Allocate shadow space for Windows x64 calling convention
 		shadowSpace := fc.allocateShadowSpace()
 
-		fc.eb.GenerateCallInstruction(funcName)
+		if lazySlot != "" {
+			fc.callDispatched(lazySlot)
+		} else {
+			fc.eb.GenerateCallInstruction(funcName)
+		}
 
 		// Deallocate shadow space
 		fc.deallocateShadowSpace(shadowSpace)
@@ -11767,44 +12501,114 @@ func (fc *C67Compiler) compileCFunctionCall(libName string, funcName string, arg
 			returnType = funcSig.ReturnType
 		}
 
-		if returnType == "float" || returnType == "double" {
-			// Result is already in xmm0 as double - no conversion needed
-		} else if returnType == "void" {
-			// Void return - set xmm0 to 0
-			fc.out.XorpdXmm("xmm0", "xmm0")
-		} else if isPointerType(returnType) || returnType == "" {
-			// Pointer type - keep raw integer value but convert to float64 for C67
-			// (C67 internally represents everything as float64)
-			// On Windows and Linux, pointers are 64-bit and returned in RAX correctly
-			// NOTE: When signature is unknown (returnType == ""), assume pointer/64-bit return
-			// This is safer than assuming 32-bit, and works for SDL functions
-			fc.out.Cvtsi2sd("xmm0", "rax")
-		} else {
-			// Integer result in rax - convert to float64 for C67
-			// On Windows: bool returns are 1 byte (AL), int returns are 4 bytes (EAX)
-			// Zero-extend to 64-bit RAX to avoid garbage in upper bits
-			if fc.eb.target.OS() == OSWindows {
-				// Check if this is a bool return (1 byte in AL)
-				if returnType == "bool" || returnType == "_Bool" {
-					// movzx eax, al (zero-extend 8-bit AL to 32-bit EAX, then to RAX)
-					fc.out.MovzxRegReg("eax", "al")
-				} else {
-					// For int/int32/etc returns: use EAX directly (upper 32 bits of RAX auto-zeroed)
-					// mov eax, eax (zero-extends EAX to 64-bit RAX)
-					fc.out.MovRegToReg("eax", "eax")
-				}
-			}
-			fc.out.Cvtsi2sd("xmm0", "rax")
-		}
+		fc.convertCReturnValue(returnType)
+	}
+}
+
+// convertCReturnValue turns the result of a C function with the given
+// return type into a C67 number in xmm0. Only the bits of rax that belong
+// to the type are used: 32-bit ints are sign-extended, unsigned types
+// zero-extended, bool becomes 0 or 1 and pointers keep all 64 bits. A
+// return type that is not known takes the whole of rax, or eax on Windows.
+func (fc *C67Compiler) convertCReturnValue(returnType string) {
+	kind := cReturnKind(returnType, fc.eb.target.OS() == OSWindows)
+	if kind == "" && returnType != "" && fc.eb.target.OS() == OSWindows {
+		kind = "u32"
+	}
+	switch kind {
+	case "float64":
+		// Result is already in xmm0 as double - no conversion needed
+		return
+	case "float32":
+		fc.out.Emit([]byte{0xf3, 0x0f, 0x5a, 0xc0}) // cvtss2sd xmm0, xmm0
+		return
+	case "void":
+		fc.out.XorpdXmm("xmm0", "xmm0")
+		return
+	case "bool":
+		fc.out.Emit([]byte{0x84, 0xc0})       // test al, al
+		fc.out.Emit([]byte{0x0f, 0x95, 0xc0}) // setne al
+		fc.out.Emit([]byte{0x0f, 0xb6, 0xc0}) // movzx eax, al
+	case "i8", "u8", "i16", "u16", "i32", "u32":
+		fc.extendRax(kind)
+	case "u64":
+		// cvtsi2sd is signed: halve values with the top bit set, keeping
+		// the low bit for rounding, and double the result
+		fc.out.TestRegReg("rax", "rax")
+		signed := fc.forwardJump(JumpGreaterOrEqual)
+		fc.out.MovRegToReg("rcx", "rax")
+		fc.out.ShrRegByImm("rcx", 1)
+		fc.out.AndRegWithImm("rax", 1)
+		fc.out.OrRegWithReg("rcx", "rax")
+		fc.out.Cvtsi2sd("xmm0", "rcx")
+		fc.out.AddsdXmm("xmm0", "xmm0")
+		done := fc.forwardJumpAlways()
+		fc.landForwardJump(signed)
+		fc.out.Cvtsi2sd("xmm0", "rax")
+		fc.landForwardJump(done)
+		return
+	}
+	// Pointers, 64-bit ints and unknown types use all of rax
+	fc.out.Cvtsi2sd("xmm0", "rax")
+}
+
+// extendRax sign- or zero-extends the low bits of rax that an integer of the
+// given kind ("i8" to "u32") has to all of rax
+func (fc *C67Compiler) extendRax(kind string) {
+	switch kind {
+	case "i8":
+		fc.out.Emit([]byte{0x48, 0x0f, 0xbe, 0xc0}) // movsx rax, al
+	case "u8":
+		fc.out.Emit([]byte{0x0f, 0xb6, 0xc0}) // movzx eax, al
+	case "i16":
+		fc.out.Emit([]byte{0x48, 0x0f, 0xbf, 0xc0}) // movsx rax, ax
+	case "u16":
+		fc.out.Emit([]byte{0x0f, 0xb7, 0xc0}) // movzx eax, ax
+	case "i32":
+		fc.out.Emit([]byte{0x48, 0x63, 0xc0}) // movsxd rax, eax
+	case "u32":
+		fc.out.Emit([]byte{0x89, 0xc0}) // mov eax, eax
 	}
 }
 
+// emitAutoCString converts the argument in xmm0 for a char* parameter into
+// rax. The type of the argument is not always known at compile time, so it
+// is checked at runtime: a number (a C pointer such as the result of
+// c.malloc) has exponent bits and is passed on as it is, 0 is NULL, and
+// anything else is a C67 string, copied to a NUL-terminated C string in the
+// current arena.
+func (fc *C67Compiler) emitAutoCString() {
+	fc.out.MovqXmmToReg("rax", "xmm0")
+	fc.out.MovRegToReg("rcx", "rax")
+	fc.out.ShrRegByImm("rcx", 52)
+	number := fc.forwardJump(JumpNotEqual)
+	fc.out.TestRegReg("rax", "rax")
+	null := fc.forwardJump(JumpEqual)
+	fc.out.CallSymbol("c67_string_to_cstr")
+	done := fc.forwardJumpAlways()
+	fc.landForwardJump(number)
+	fc.out.Cvttsd2si("rax", "xmm0")
+	fc.landForwardJump(null)
+	fc.landForwardJump(done)
+}
+
 func (fc *C67Compiler) compileCall(call *CallExpr) {
 	if VerboseMode {
 		fmt.Fprintf(os.Stderr, "DEBUG compileCall: function='%s'\n", call.Function)
 		fmt.Fprintf(os.Stderr, "DEBUG compileCall: variables=%v\n", fc.variables)
 	}
 
+	// Bind calls to functions defined for several argument counts
+	if overload, ok := fc.arityOverloads[call.Function]; ok {
+		variant, found := overload.resolve(len(call.Args))
+		if !found {
+			compilerError("'%s' has no clause taking %d argument(s) (it takes %s)", call.Function, len(call.Args), overload.arities())
+		}
+		resolved := *call
+		resolved.Function = variant
+		call = &resolved
+	}
+
 	// Check if this is a recursive call (function name matches current lambda)
 	isRecursive := fc.currentLambda != nil && call.Function == fc.currentLambda.Name
 
@@ -11823,6 +12627,13 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 		return
 	}
 
+	// alias.easy.name(...) calls the generated sdl.easy wrapper
+	if name := sdlEasyName(call.Function); name != "" {
+		if _, ok := fc.cImports[strings.Split(call.Function, ".")[0]]; ok {
+			call.Function = name
+		}
+	}
+
 	// Check if this is a namespaced function call (namespace.function)
 	if strings.Contains(call.Function, ".") {
 		parts := strings.Split(call.Function, ".")
@@ -11832,10 +12643,17 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 
 			// Check if namespace is a registered C import
 			if libName, ok := fc.cImports[namespace]; ok {
+				fc.checkCFunctionExists(namespace, funcName)
 				fc.compileCFunctionCall(libName, funcName, call.Args)
 				return
 			}
 
+			// A file imported under two aliases has its functions in the
+			// namespace of the first
+			if aliased, ok := fc.namespaceAliases[namespace]; ok {
+				namespace = aliased
+			}
+
 			// Check if this is a C67 namespaced function call
 			// Look up the function in the namespace map
 			if actualNamespace, exists := fc.functionNamespace[funcName]; exists && actualNamespace == namespace {
@@ -11988,15 +12806,21 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 		// Evaluate the argument to get the value in xmm0
 		fc.compileExpression(call.Args[0])
 
-		// Check if xmm0 is NaN (error) or normal value (success)
-		fc.out.Ucomisd("xmm0", "xmm0") // Compare with itself - sets PF=1 if NaN
+		// Success values (including plain NaN) have no error
+		notErrorPos := fc.emitJumpIfNotError()
 
-		// Jump if not NaN (parity flag not set)
-		notNaNPos := fc.eb.text.Len()
-		fc.out.JumpConditional(JumpNotParity, 0) // jnp - jump if not parity (not NaN)
+		// err("message") values carry the message string itself
+		fc.out.MovRegToReg("rcx", "rax")
+		fc.out.ShrRegByImm("rcx", 48)
+		fc.out.CmpRegToImm("rcx", errorMessageTag>>48)
+		codePos := fc.forwardJump(JumpNotEqual)
+		fc.out.MovImmToReg("rcx", strconv.FormatUint(errorPointerMask, 10))
+		fc.out.AndRegWithReg("rax", "rcx")
+		fc.out.MovqRegToXmm("xmm0", "rax")
+		messageDonePos := fc.forwardJumpAlways()
+		fc.landForwardJump(codePos)
 
-		// NaN path: extract error code from mantissa (low 32 bits)
-		fc.out.MovqXmmToReg("rax", "xmm0")          // Move float bits to GPR
+		// Error code path: extract error code from mantissa (low 32 bits)
 		fc.out.Emit([]byte{0x48, 0x25})             // and rax, immediate32
 		fc.out.Emit([]byte{0xff, 0xff, 0xff, 0xff}) // mask = 0xFFFFFFFF
 
@@ -12057,9 +12881,10 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 		donePos := fc.eb.text.Len()
 		fc.out.JumpUnconditional(0)
 
-		// Not NaN path: return empty string
-		notNaNTarget := fc.eb.text.Len()
-		fc.patchJumpImmediate(notNaNPos+2, int32(notNaNTarget-(notNaNPos+6)))
+		// Not an error: return empty string
+		for _, pos := range notErrorPos {
+			fc.landForwardJump(pos)
+		}
 
 		// Create empty string
 		labelName := fmt.Sprintf("empty_str_%d", fc.stringCounter)
@@ -12076,6 +12901,7 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 		// Done
 		doneTarget := fc.eb.text.Len()
 		fc.patchJumpImmediate(donePos+1, int32(doneTarget-(donePos+5)))
+		fc.landForwardJump(messageDonePos)
 		return
 
 	case "head":
@@ -12279,6 +13105,9 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 
 		arg := call.Args[0]
 		argType := fc.getExprType(arg)
+		if argType == "persistent" {
+			compilerError("%s() cannot print the persistent collection %s, print its elements instead", call.Function, arg)
+		}
 
 		if strExpr, ok := arg.(*StringExpr); ok {
 			// String literal
@@ -12287,12 +13116,7 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 			fc.eb.Define(labelName, strExpr.Value)
 
 			if fc.eb.target.OS() == OSLinux {
-				// Use write syscall
-				fc.out.MovImmToReg("rax", "1") // sys_write
-				fc.out.MovImmToReg("rdi", "1") // stdout
-				fc.out.LeaSymbolToReg("rsi", labelName)
-				fc.out.MovImmToReg("rdx", fmt.Sprintf("%d", len(strExpr.Value)))
-				fc.out.Syscall()
+				fc.emitConstantWrite(labelName, strExpr.Value)
 			} else {
 				// Windows - use printf
 				fc.eb.Define(labelName+"_z", strExpr.Value+"\x00")
@@ -12358,21 +13182,16 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 			// xmm0 contains float64 value
 
 			if fc.eb.target.OS() == OSLinux {
-				// Convert to int64 and use _c67_itoa + write syscall
-				fc.out.Cvttsd2si("rdi", "xmm0")
-
-				// Allocate stack buffer
+				// Shortest round-trip text via _c67_dtoa + write syscall
 				fc.out.SubImmFromReg("rsp", 32)
-				fc.out.MovRegToReg("r15", "rsp")
 
-				// Call _c67_itoa
-				fc.trackFunctionCall("_c67_itoa")
-				fc.eb.GenerateCallInstruction("_c67_itoa")
+				// Call _c67_dtoa
+				fc.trackFunctionCall("_c67_dtoa")
+				fc.eb.GenerateCallInstruction("_c67_dtoa")
 
 				// Write to stdout
-				fc.out.MovImmToReg("rax", "1")
 				fc.out.MovImmToReg("rdi", "1")
-				fc.out.Syscall()
+				fc.emitBufferedWrite()
 
 				// Clean up
 				fc.out.AddImmToReg("rsp", 32)
@@ -12405,11 +13224,10 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 
 			if fc.eb.target.OS() == OSLinux {
 				// Use write syscall
-				fc.out.MovImmToReg("rax", "1") // sys_write
 				fc.out.MovImmToReg("rdi", "1") // stdout
 				fc.out.LeaSymbolToReg("rsi", newlineLabel)
 				fc.out.MovImmToReg("rdx", "1") // 1 byte
-				fc.out.Syscall()
+				fc.emitBufferedWrite()
 			} else {
 				// Windows - use printf
 				fc.eb.Define(newlineLabel+"_z", "\n\x00") // null-terminated
@@ -12424,6 +13242,9 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 
 		arg := call.Args[0]
 		argType := fc.getExprType(arg)
+		if argType == "persistent" {
+			compilerError("%s() cannot print the persistent collection %s, print its elements instead", call.Function, arg)
+		}
 
 		if strExpr, ok := arg.(*StringExpr); ok {
 			// String literal
@@ -12431,14 +13252,9 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 			fc.stringCounter++
 			strWithNewline := strExpr.Value + "\n"
 			fc.eb.Define(labelName, strWithNewline)
-
-			if fc.eb.target.OS() == OSLinux {
-				// Use write syscall
-				fc.out.MovImmToReg("rax", "1") // sys_write
-				fc.out.MovImmToReg("rdi", "1") // stdout
-				fc.out.LeaSymbolToReg("rsi", labelName)
-				fc.out.MovImmToReg("rdx", fmt.Sprintf("%d", len(strWithNewline)))
-				fc.out.Syscall()
+
+			if fc.eb.target.OS() == OSLinux {
+				fc.emitConstantWrite(labelName, strWithNewline)
 			} else {
 				// Windows - use printf
 				fc.eb.Define(labelName+"_z", strWithNewline+"\x00") // null-terminated
@@ -12504,6 +13320,12 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 			fc.compileExpression(arg)
 			// xmm0 now contains the map pointer as float64
 
+			// A map record may print itself (see operators.go)
+			strPrinted := -1
+			if argType == "map" && fc.eb.target.OS() == OSLinux {
+				strPrinted = fc.emitStrMethodPrintln()
+			}
+
 			// Convert map pointer from xmm0 to rax (integer pointer)
 			fc.out.SubImmFromReg("rsp", StackSlotSize)
 			fc.out.MovXmmToMem("xmm0", "rsp", 0)
@@ -12528,6 +13350,7 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 			fmtLabel := fmt.Sprintf("println_fmt_%d", fc.stringCounter)
 			fc.stringCounter++
 			fc.eb.Define(fmtLabel, "%g\n\x00")
+			fc.eb.Define(fmtLabel+"_nl", "\n")
 
 			// Get current position for loop start
 			loopStartPos := fc.eb.text.Len()
@@ -12545,17 +13368,43 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 			// Load map pointer from stack
 			fc.out.MovMemToReg("rax", "rsp", 0) // rax = map pointer
 
-			// Calculate element address: map_base + 8 + (index * 8)
-			// The map structure is: [length (8 bytes)] [element0] [element1] ...
+			// Calculate value address: map_base + 16 + (index * 16)
+			// The map structure is: [length (8 bytes)] [key0][value0] [key1][value1] ...
 			fc.out.MovRegToReg("rbx", "rax") // rbx = map base
-			fc.out.AddImmToReg("rbx", 8)     // rbx = map base + 8 (skip length)
+			fc.out.AddImmToReg("rbx", 16)    // rbx = map base + 16 (skip length and key)
 			fc.out.MovRegToReg("rsi", "rcx") // rsi = index
-			fc.out.ShlImmReg("rsi", 3)       // rsi = index * 8
-			fc.out.AddRegToReg("rbx", "rsi") // rbx = element address
+			fc.out.ShlImmReg("rsi", 4)       // rsi = index * 16
+			fc.out.AddRegToReg("rbx", "rsi") // rbx = value address
 
 			// Load the element value into xmm0
 			fc.out.MovMemToXmm("xmm0", "rbx", 0)
 
+			if fc.eb.target.OS() == OSLinux {
+				// Shortest round-trip text via _c67_dtoa, then the element's newline
+				fc.trackFunctionCall("_c67_dtoa")
+				fc.eb.GenerateCallInstruction("_c67_dtoa")
+				fc.out.MovImmToReg("rdi", "1") // stdout
+				fc.emitBufferedWrite()
+				fc.out.MovImmToReg("rdi", "1") // stdout
+				fc.out.LeaSymbolToReg("rsi", fmtLabel+"_nl")
+				fc.out.MovImmToReg("rdx", "1")
+				fc.emitBufferedWrite()
+
+				// Increment index on stack
+				fc.out.MovMemToReg("rcx", "rsp", 16)
+				fc.out.AddImmToReg("rcx", 1)
+				fc.out.MovRegToMem("rcx", "rsp", 16)
+				fc.out.JumpUnconditional(int32(loopStartPos - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+				loopEndPos := fc.eb.text.Len()
+				fc.patchJumpImmediate(loopEndJumpPos+2, int32(loopEndPos-(loopEndJumpPos+6)))
+				fc.out.AddImmToReg("rsp", 24)
+				if strPrinted >= 0 {
+					fc.landForwardJump(strPrinted)
+				}
+				return
+			}
+
 			// Print using printf (printf clobbers rax, rcx, rdx, rsi, rdi, r8-r11)
 			shadowSpace := fc.allocateShadowSpace()
 			fc.out.LeaSymbolToReg(fc.getIntArgReg(0), fmtLabel)
@@ -12599,34 +13448,28 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 			// xmm0 contains float64 value
 
 			if fc.eb.target.OS() == OSLinux {
-				// Convert to int64 and use _c67_itoa + write syscall
-				fc.out.Cvttsd2si("rdi", "xmm0") // Convert float to int64
-
-				// Allocate stack buffer for number string
+				// Shortest round-trip text via _c67_dtoa + write syscall
 				fc.out.SubImmFromReg("rsp", 32)
-				fc.out.MovRegToReg("r15", "rsp") // Save buffer pointer
 
-				// Call _c67_itoa(rdi=number)
-				fc.trackFunctionCall("_c67_itoa")
-				fc.eb.GenerateCallInstruction("_c67_itoa")
+				// Call _c67_dtoa(xmm0=number)
+				fc.trackFunctionCall("_c67_dtoa")
+				fc.eb.GenerateCallInstruction("_c67_dtoa")
 				// Returns: rsi=string start, rdx=length
 
 				// Write to stdout: write(1, rsi, rdx)
-				fc.out.MovImmToReg("rax", "1") // sys_write
 				fc.out.MovImmToReg("rdi", "1") // stdout
 				// rsi already has buffer pointer
 				// rdx already has length
-				fc.out.Syscall()
+				fc.emitBufferedWrite()
 
 				// Write newline
 				newlineLabel := fmt.Sprintf("println_newline_%d", fc.stringCounter)
 				fc.stringCounter++
 				fc.eb.Define(newlineLabel, "\n")
-				fc.out.MovImmToReg("rax", "1") // sys_write
 				fc.out.MovImmToReg("rdi", "1") // stdout
 				fc.out.LeaSymbolToReg("rsi", newlineLabel)
 				fc.out.MovImmToReg("rdx", "1")
-				fc.out.Syscall()
+				fc.emitBufferedWrite()
 
 				// Clean up stack
 				fc.out.AddImmToReg("rsp", 32)
@@ -12953,11 +13796,10 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 				fc.eb.Define(labelName, processedStr)
 
 				// Use write syscall: write(2, str, len)
-				fc.out.MovImmToReg("rax", "1")                                  // sys_write
 				fc.out.MovImmToReg("rdi", "2")                                  // fd = stderr
 				fc.out.LeaSymbolToReg("rsi", labelName)                         // buffer
 				fc.out.MovImmToReg("rdx", fmt.Sprintf("%d", len(processedStr))) // length
-				fc.out.Syscall()
+				fc.emitBufferedWrite()
 			}
 		} else if isNewline {
 			// eprintln - print to stderr with newline
@@ -12967,11 +13809,10 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 				fc.stringCounter++
 				fc.eb.Define(newlineLabel, "\n")
 
-				fc.out.MovImmToReg("rax", "1") // sys_write
 				fc.out.MovImmToReg("rdi", "2") // stderr
 				fc.out.LeaSymbolToReg("rsi", newlineLabel)
 				fc.out.MovImmToReg("rdx", "1") // 1 byte
-				fc.out.Syscall()
+				fc.emitBufferedWrite()
 			} else {
 				arg := call.Args[0]
 				if strExpr, ok := arg.(*StringExpr); ok {
@@ -12980,11 +13821,10 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 					processedStr := processEscapeSequences(strExpr.Value) + "\n"
 					fc.eb.Define(labelName, processedStr)
 
-					fc.out.MovImmToReg("rax", "1") // sys_write
 					fc.out.MovImmToReg("rdi", "2") // stderr
 					fc.out.LeaSymbolToReg("rsi", labelName)
 					fc.out.MovImmToReg("rdx", fmt.Sprintf("%d", len(processedStr)))
-					fc.out.Syscall()
+					fc.emitBufferedWrite()
 				} else {
 					// For numbers, convert to string using pure syscall approach
 					fc.compileExpression(arg)
@@ -13003,20 +13843,18 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 					// Returns: rsi=string start, rdx=length
 
 					// Write to stderr: write(2, rsi, rdx)
-					fc.out.MovImmToReg("rax", "1") // sys_write
 					fc.out.MovImmToReg("rdi", "2") // stderr
 					// rsi, rdx already set
-					fc.out.Syscall()
+					fc.emitBufferedWrite()
 
 					// Write newline
 					newlineLabel := fmt.Sprintf("eprintln_newline_%d", fc.stringCounter)
 					fc.stringCounter++
 					fc.eb.Define(newlineLabel, "\n")
-					fc.out.MovImmToReg("rax", "1")
 					fc.out.MovImmToReg("rdi", "2")
 					fc.out.LeaSymbolToReg("rsi", newlineLabel)
 					fc.out.MovImmToReg("rdx", "1")
-					fc.out.Syscall()
+					fc.emitBufferedWrite()
 
 					fc.out.AddImmToReg("rsp", 32)
 				}
@@ -13036,11 +13874,10 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 				processedStr := processEscapeSequences(strExpr.Value)
 				fc.eb.Define(labelName, processedStr)
 
-				fc.out.MovImmToReg("rax", "1") // sys_write
 				fc.out.MovImmToReg("rdi", "2") // stderr
 				fc.out.LeaSymbolToReg("rsi", labelName)
 				fc.out.MovImmToReg("rdx", fmt.Sprintf("%d", len(processedStr)))
-				fc.out.Syscall()
+				fc.emitBufferedWrite()
 			} else {
 				// For numbers, convert to string using pure syscall approach
 				fc.compileExpression(arg)
@@ -13059,10 +13896,9 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 				// Returns: rsi=string start, rdx=length
 
 				// Write to stderr: write(2, rsi, rdx)
-				fc.out.MovImmToReg("rax", "1") // sys_write
 				fc.out.MovImmToReg("rdi", "2") // stderr
 				// rsi, rdx already set
-				fc.out.Syscall()
+				fc.emitBufferedWrite()
 
 				fc.out.AddImmToReg("rsp", 32)
 			}
@@ -13241,11 +14077,10 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 					// Simple case: just a format string with no placeholders
 					// Write directly to stderr using write syscall (don't include null terminator)
 					strLen := len(fmtStr)
-					fc.out.MovImmToReg("rax", "1") // sys_write
 					fc.out.MovImmToReg("rdi", "2") // stderr (fd 2)
 					fc.out.LeaSymbolToReg("rsi", labelName)
 					fc.out.MovImmToReg("rdx", fmt.Sprintf("%d", strLen))
-					fc.out.Syscall()
+					fc.emitBufferedWrite()
 				} else {
 					// Complex case with arguments: use fprintf (less common for exitf)
 					// FIXME: This path needs proper stderr handling
@@ -13302,10 +14137,12 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 			// Exit with appropriate code
 			if fc.platform.OS == OSWindows {
 				fc.out.MovImmToReg("rcx", fmt.Sprintf("%d", exitCode))
+				fc.emitFlushStdout()
 				fc.trackFunctionCall("exit")
 				fc.eb.GenerateCallInstruction("exit")
 			} else {
 				fc.out.MovImmToReg("rdi", fmt.Sprintf("%d", exitCode))
+				fc.emitFlushStdout()
 				fc.trackFunctionCall("exit")
 				fc.eb.GenerateCallInstruction("exit")
 			}
@@ -13317,11 +14154,10 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 				fc.stringCounter++
 				fc.eb.Define(newlineLabel, "\n")
 
-				fc.out.MovImmToReg("rax", "1")
 				fc.out.MovImmToReg("rdi", "2")
 				fc.out.LeaSymbolToReg("rsi", newlineLabel)
 				fc.out.MovImmToReg("rdx", "1")
-				fc.out.Syscall()
+				fc.emitBufferedWrite()
 			} else {
 				arg := call.Args[0]
 				if strExpr, ok := arg.(*StringExpr); ok {
@@ -13330,11 +14166,10 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 					processedStr := processEscapeSequences(strExpr.Value) + "\n"
 					fc.eb.Define(labelName, processedStr)
 
-					fc.out.MovImmToReg("rax", "1")
 					fc.out.MovImmToReg("rdi", "2")
 					fc.out.LeaSymbolToReg("rsi", labelName)
 					fc.out.MovImmToReg("rdx", fmt.Sprintf("%d", len(processedStr)))
-					fc.out.Syscall()
+					fc.emitBufferedWrite()
 				} else {
 					fc.compileExpression(arg)
 
@@ -13352,9 +14187,8 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 
 					fc.out.MovRegToReg("rdx", "rax")
 					fc.out.MovRegToReg("rsi", "rsp")
-					fc.out.MovImmToReg("rax", "1")
 					fc.out.MovImmToReg("rdi", "2")
-					fc.out.Syscall()
+					fc.emitBufferedWrite()
 
 					fc.out.AddImmToReg("rsp", 32)
 				}
@@ -13368,11 +14202,10 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 					processedStr := processEscapeSequences(strExpr.Value)
 					fc.eb.Define(labelName, processedStr)
 
-					fc.out.MovImmToReg("rax", "1")
 					fc.out.MovImmToReg("rdi", "2")
 					fc.out.LeaSymbolToReg("rsi", labelName)
 					fc.out.MovImmToReg("rdx", fmt.Sprintf("%d", len(processedStr)))
-					fc.out.Syscall()
+					fc.emitBufferedWrite()
 				} else {
 					fc.compileExpression(arg)
 
@@ -13390,9 +14223,8 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 
 					fc.out.MovRegToReg("rdx", "rax")
 					fc.out.MovRegToReg("rsi", "rsp")
-					fc.out.MovImmToReg("rax", "1")
 					fc.out.MovImmToReg("rdi", "2")
-					fc.out.Syscall()
+					fc.emitBufferedWrite()
 
 					fc.out.AddImmToReg("rsp", 32)
 				}
@@ -13401,6 +14233,7 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 
 		// Exit with code 1
 		fc.out.MovImmToReg("rdi", "1")
+		fc.emitFlushStdout()
 		fc.trackFunctionCall("exit")
 		fc.eb.GenerateCallInstruction("exit")
 		fc.hasExplicitExit = true
@@ -13418,6 +14251,8 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 		// Restore stack pointer to frame pointer (rsp % 16 == 8 for proper call alignment)
 		// Don't pop rbp since exit() never returns
 		fc.out.MovRegToReg("rsp", "rbp")
+		fc.emitTraceReport()
+		fc.emitFlushStdout()
 		fc.trackFunctionCall("exit")
 		fc.eb.GenerateCallInstruction("exit")
 
@@ -13485,8 +14320,7 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 		fc.out.MovRegToReg("rdx", "rcx")
 		fc.out.ShlImmReg("rdx", 4) // size = old_count * 16
 
-		fc.trackFunctionCall("memcpy")
-		fc.eb.GenerateCallInstruction("memcpy")
+		fc.emitEntryCopy()
 
 		// Restore saved values
 		fc.out.PopReg("rcx") // old count
@@ -13497,7 +14331,7 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 		// skipCopyJump points to 0x0F, offset starts at skipCopyJump+2
 		currentPos := fc.eb.text.Len()
 		skipOffset := currentPos - skipCopyPatch
-		fc.eb.text.Bytes()[skipCopyJump+2] = byte(skipOffset)
+		fc.patchJumpImmediate(skipCopyJump+2, int32(skipOffset))
 		fc.eb.text.Bytes()[skipCopyJump+3] = byte(skipOffset >> 8)
 		fc.eb.text.Bytes()[skipCopyJump+4] = byte(skipOffset >> 16)
 		fc.eb.text.Bytes()[skipCopyJump+5] = byte(skipOffset >> 24)
@@ -13599,8 +14433,7 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 		fc.out.MovRegToReg("rdx", "r13")
 		fc.out.ShlImmReg("rdx", 4) // size = (count-1) * 16
 
-		fc.trackFunctionCall("memcpy")
-		fc.eb.GenerateCallInstruction("memcpy")
+		fc.emitEntryCopy()
 
 		// Restore callee-saved registers
 		fc.out.PopReg("r15")
@@ -13612,7 +14445,7 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 		// Patch skip copy jump
 		currentPos := fc.eb.text.Len()
 		skipOffset := currentPos - skipCopyPatch
-		fc.eb.text.Bytes()[skipCopy+2] = byte(skipOffset)
+		fc.patchJumpImmediate(skipCopy+2, int32(skipOffset))
 		fc.eb.text.Bytes()[skipCopy+3] = byte(skipOffset >> 8)
 		fc.eb.text.Bytes()[skipCopy+4] = byte(skipOffset >> 16)
 		fc.eb.text.Bytes()[skipCopy+5] = byte(skipOffset >> 24)
@@ -13820,6 +14653,12 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 		// sqrtsd xmm0, xmm0 - sqrt of xmm0, result in xmm0
 		fc.out.Sqrtsd("xmm0", "xmm0")
 
+	case "fma":
+		if len(call.Args) != 3 {
+			compilerError("fma() requires exactly 3 arguments: fma(a, b, c) = a * b + c")
+		}
+		fc.compileFMA(call.Args[0], call.Args[1], call.Args[2], false)
+
 	case "sin":
 		if len(call.Args) != 1 {
 			compilerError("sin() requires exactly 1 argument")
@@ -13976,6 +14815,18 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 		fc.out.MovMemToXmm("xmm0", "rsp", 0)
 		fc.out.AddImmToReg("rsp", 8)
 
+	case "err":
+		// err("message") - Creates an error value that carries its message
+		// The string pointer is NaN-boxed: 0x7FFC_pppp_pppp_pppp
+		if len(call.Args) != 1 {
+			compilerError("err() requires exactly 1 argument (error message string)")
+		}
+		fc.compileExpression(call.Args[0])
+		fc.out.MovqXmmToReg("rax", "xmm0")
+		fc.out.MovImmToReg("rcx", strconv.FormatUint(errorMessageTag, 10))
+		fc.out.OrRegWithReg("rax", "rcx")
+		fc.out.MovqRegToXmm("xmm0", "rax")
+
 	case "abs":
 		if len(call.Args) != 1 {
 			compilerError("abs() requires exactly 1 argument")
@@ -14527,10 +15378,7 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 		fc.out.DivsdXmm("xmm0", "xmm1")          // xmm0 = a/b
 
 		// Allocate 8 bytes in arena for result
-		offset := (fc.currentArena - 1) * 8
-		fc.out.LeaSymbolToReg("rdi", "_c67_arena_meta")
-		fc.out.MovMemToReg("rdi", "rdi", 0)
-		fc.out.MovMemToReg("rdi", "rdi", offset)
+		fc.loadArenaPointer("rdi")
 		fc.out.MovImmToReg("rsi", "8")
 
 		// Save result before calling alloc
@@ -14623,10 +15471,7 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 		fc.out.MovXmmToMem("xmm0", "rsp", 0)
 
 		// Allocate Result map (64 bytes)
-		offset := (fc.currentArena - 1) * 8
-		fc.out.LeaSymbolToReg("rdi", "_c67_arena_meta")
-		fc.out.MovMemToReg("rdi", "rdi", 0)
-		fc.out.MovMemToReg("rdi", "rdi", offset)
+		fc.loadArenaPointer("rdi")
 		fc.out.MovImmToReg("rsi", "64")
 		fc.out.CallSymbol("c67_arena_alloc")
 
@@ -14682,10 +15527,7 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 		// Result still on stack, don't pop yet
 
 		// Allocate Result map (64 bytes)
-		offset := (fc.currentArena - 1) * 8
-		fc.out.LeaSymbolToReg("rdi", "_c67_arena_meta")
-		fc.out.MovMemToReg("rdi", "rdi", 0)
-		fc.out.MovMemToReg("rdi", "rdi", offset)
+		fc.loadArenaPointer("rdi")
 		fc.out.MovImmToReg("rsi", "64")
 		fc.out.CallSymbol("c67_arena_alloc")
 
@@ -14824,180 +15666,11 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 		fc.out.MovMemToXmm("xmm0", "rsp", 0)
 		fc.out.AddImmToReg("rsp", 16)
 
-	// ===== BIT MANIPULATION BUILTINS =====
-	// High-performance CPU instructions for bit operations
-	// with graceful fallback for older CPUs
-
-	case "popcount":
-		// popcount(x) - Count number of set bits (population count)
-		// Returns float64 representing the count
-		// Uses POPCNT instruction if available (3 cycles), falls back to loop (~25 cycles)
-		if len(call.Args) != 1 {
-			compilerError("popcount() requires exactly 1 argument")
-		}
-
-		// Compile argument and convert to integer
-		fc.compileExpression(call.Args[0])
-		fc.out.Cvttsd2si("rax", "xmm0") // Convert float64 to int64
-
-		// Check if POPCNT is available
-		fc.out.LeaSymbolToReg("rcx", "cpu_has_popcnt")
-		fc.out.Emit([]byte{0x0f, 0xb6, 0x09}) // movzx ecx, byte [rcx]
-		fc.out.Emit([]byte{0x85, 0xc9})       // test ecx, ecx
-
-		// Jump to fallback if no POPCNT
-		jzPos := fc.eb.text.Len()
-		fc.out.Emit([]byte{0x0f, 0x84, 0x00, 0x00, 0x00, 0x00}) // jz fallback
-
-		// POPCNT path: popcnt rax, rax
-		fc.out.Emit([]byte{0xf3, 0x48, 0x0f, 0xb8, 0xc0}) // popcnt rax, rax
-
-		// Jump over fallback
-		jmpOverPos := fc.eb.text.Len()
-		fc.out.Emit([]byte{0xeb, 0x00}) // jmp end
-
-		// Fallback path: loop implementation
-		fallbackPos := fc.eb.text.Len()
-		// rcx = count (result), rdx = temp
-		fc.out.XorRegWithReg("rcx", "rcx") // count = 0
-		fc.out.MovRegToReg("rdx", "rax")   // rdx = x (preserve rax for comparison)
-
-		// Loop: while (rdx != 0) { count += rdx & 1; rdx >>= 1; }
-		loopStart := fc.eb.text.Len()
-		fc.out.Emit([]byte{0x48, 0x85, 0xd2}) // test rdx, rdx
-		loopEndJump := fc.eb.text.Len()
-		fc.out.Emit([]byte{0x74, 0x00}) // jz loop_end (2 bytes)
-
-		fc.out.MovRegToReg("rax", "rdx")            // rax = rdx
-		fc.out.Emit([]byte{0x48, 0x83, 0xe0, 0x01}) // and rax, 1
-		fc.out.AddRegToReg("rcx", "rax")            // count += (rdx & 1)
-		fc.out.ShrRegByImm("rdx", 1)                // rdx >>= 1
-
-		// Jump back to loop start
-		backOffset := loopStart - (fc.eb.text.Len() + 2)
-		fc.out.Emit([]byte{0xeb, byte(backOffset)}) // jmp loop_start
-
-		// Loop end
-		loopEndPos := fc.eb.text.Len()
-		fc.eb.text.Bytes()[loopEndJump+1] = byte(loopEndPos - (loopEndJump + 2))
-
-		fc.out.MovRegToReg("rax", "rcx") // Move result to rax
-
-		// End position
-		endPos := fc.eb.text.Len()
-
-		// Patch jumps
-		fc.patchJumpImmediate(jzPos+2, int32(fallbackPos-(jzPos+6)))
-		fc.eb.text.Bytes()[jmpOverPos+1] = byte(endPos - (jmpOverPos + 2))
-
-		// Convert result to float64
-		fc.out.Cvtsi2sd("xmm0", "rax")
-
-	case "clz":
-		// clz(x) - Count leading zeros
-		// Returns float64 representing the count (0-64)
-		// Uses LZCNT instruction if available, falls back to BSR + adjustment
-		if len(call.Args) != 1 {
-			compilerError("clz() requires exactly 1 argument")
-		}
-
-		fc.compileExpression(call.Args[0])
-		fc.out.Cvttsd2si("rax", "xmm0") // Convert to int64
-
-		// Check if POPCNT is available (LZCNT came with same CPU generation)
-		fc.out.LeaSymbolToReg("rcx", "cpu_has_popcnt")
-		fc.out.Emit([]byte{0x0f, 0xb6, 0x09}) // movzx ecx, byte [rcx]
-		fc.out.Emit([]byte{0x85, 0xc9})       // test ecx, ecx
-
-		jzPos := fc.eb.text.Len()
-		fc.out.Emit([]byte{0x0f, 0x84, 0x00, 0x00, 0x00, 0x00}) // jz fallback
-
-		// LZCNT path: lzcnt rax, rax
-		fc.out.Emit([]byte{0xf3, 0x48, 0x0f, 0xbd, 0xc0}) // lzcnt rax, rax
+	case "popcount", "clz", "ctz", "bswap":
+		fc.compileBitCall(call)
 
-		jmpOverPos := fc.eb.text.Len()
-		fc.out.Emit([]byte{0xeb, 0x00}) // jmp end
-
-		// Fallback path: use BSR (bit scan reverse)
-		fallbackPos := fc.eb.text.Len()
-		fc.out.Emit([]byte{0x48, 0x85, 0xc0}) // test rax, rax
-
-		zeroJump := fc.eb.text.Len()
-		fc.out.Emit([]byte{0x74, 0x00}) // jz is_zero (2 bytes)
-
-		// BSR: finds position of highest set bit
-		fc.out.Emit([]byte{0x48, 0x0f, 0xbd, 0xc8}) // bsr rcx, rax
-		fc.out.MovImmToReg("rax", "63")
-		fc.out.SubRegFromReg("rax", "rcx") // clz = 63 - bsr_result
-
-		jmpEndPos := fc.eb.text.Len()
-		fc.out.Emit([]byte{0xeb, 0x00}) // jmp end
-
-		// Zero case: return 64
-		zeroPos := fc.eb.text.Len()
-		fc.eb.text.Bytes()[zeroJump+1] = byte(zeroPos - (zeroJump + 2))
-		fc.out.MovImmToReg("rax", "64")
-
-		// End position
-		endPos := fc.eb.text.Len()
-		fc.patchJumpImmediate(jzPos+2, int32(fallbackPos-(jzPos+6)))
-		fc.eb.text.Bytes()[jmpOverPos+1] = byte(endPos - (jmpOverPos + 2))
-		fc.eb.text.Bytes()[jmpEndPos+1] = byte(endPos - (jmpEndPos + 2))
-
-		fc.out.Cvtsi2sd("xmm0", "rax")
-
-	case "ctz":
-		// ctz(x) - Count trailing zeros
-		// Returns float64 representing the count (0-64)
-		// Uses TZCNT instruction if available, falls back to BSF
-		if len(call.Args) != 1 {
-			compilerError("ctz() requires exactly 1 argument")
-		}
-
-		fc.compileExpression(call.Args[0])
-		fc.out.Cvttsd2si("rax", "xmm0") // Convert to int64
-
-		// Check if POPCNT is available (TZCNT came with same CPU generation)
-		fc.out.LeaSymbolToReg("rcx", "cpu_has_popcnt")
-		fc.out.Emit([]byte{0x0f, 0xb6, 0x09}) // movzx ecx, byte [rcx]
-		fc.out.Emit([]byte{0x85, 0xc9})       // test ecx, ecx
-
-		jzPos := fc.eb.text.Len()
-		fc.out.Emit([]byte{0x0f, 0x84, 0x00, 0x00, 0x00, 0x00}) // jz fallback
-
-		// TZCNT path: tzcnt rax, rax
-		fc.out.Emit([]byte{0xf3, 0x48, 0x0f, 0xbc, 0xc0}) // tzcnt rax, rax
-
-		jmpOverPos := fc.eb.text.Len()
-		fc.out.Emit([]byte{0xeb, 0x00}) // jmp end
-
-		// Fallback path: use BSF (bit scan forward)
-		fallbackPos := fc.eb.text.Len()
-		fc.out.Emit([]byte{0x48, 0x85, 0xc0}) // test rax, rax
-
-		zeroJump := fc.eb.text.Len()
-		fc.out.Emit([]byte{0x74, 0x00}) // jz is_zero (2 bytes)
-
-		// BSF: finds position of lowest set bit (already gives us trailing zeros!)
-		fc.out.Emit([]byte{0x48, 0x0f, 0xbc, 0xc0}) // bsf rax, rax
-
-		jmpEndPos := fc.eb.text.Len()
-		fc.out.Emit([]byte{0xeb, 0x00}) // jmp end
-
-		// Zero case: return 64
-		zeroPos := fc.eb.text.Len()
-		fc.eb.text.Bytes()[zeroJump+1] = byte(zeroPos - (zeroJump + 2))
-		fc.out.MovImmToReg("rax", "64")
-
-		// End position
-		endPos := fc.eb.text.Len()
-		fc.patchJumpImmediate(jzPos+2, int32(fallbackPos-(jzPos+6)))
-		fc.eb.text.Bytes()[jmpOverPos+1] = byte(endPos - (jmpOverPos + 2))
-		fc.eb.text.Bytes()[jmpEndPos+1] = byte(endPos - (jmpEndPos + 2))
-
-		fc.out.Cvtsi2sd("xmm0", "rax")
-
-	// ===== END BIT MANIPULATION BUILTINS =====
+	case "decimal":
+		fc.compileDecimalCall(call)
 
 	case "str":
 		// Convert number to string
@@ -15602,13 +16275,8 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 		// Save size to stack
 		fc.out.PushReg("rdi")
 
-		// Load arena pointer from meta-arena: _c67_arena_meta[currentArena-1]
-		arenaIndex := fc.currentArena - 1 // Convert to 0-based index
-		offset := arenaIndex * 8
-		fc.out.LeaSymbolToReg("rdi", "_c67_arena_meta")
-		fc.out.MovMemToReg("rdi", "rdi", 0) // Load the meta-arena pointer
-
-		fc.out.MovMemToReg("rdi", "rdi", offset) // Load the arena pointer from slot
+		// Load the current arena pointer (the thread arena in a parallel loop body)
+		fc.loadArenaPointer("rdi")
 
 		// Restore size to rsi
 		fc.out.PopReg("rsi") // size in rsi
@@ -15840,12 +16508,13 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 		endPos := fc.eb.text.Len()
 		fc.patchJumpImmediate(endJumpPos+1, int32(endPos-(endJumpPos+5)))
 
-	case "read_file":
+	case "read_file", "readfile":
 		// read_file(path) - Read entire file, return as C67 string
 		// Uses Linux syscalls (open/lseek/read/close) instead of libc for simplicity
 		if len(call.Args) != 1 {
 			compilerError("read_file() requires 1 argument (path)")
 		}
+		fc.trackFunctionCall("strlen") // cstr_to_c67_string
 
 		// Evaluate path argument (C67 string)
 		fc.compileExpression(call.Args[0])
@@ -16080,131 +16749,104 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 		fc.out.MovImmToReg("rax", "8")
 		fc.out.Cvtsi2sd("xmm0", "rax")
 
-	case "vadd":
-		// vadd(v1, v2) - Vector addition using SIMD
-		if len(call.Args) != 2 {
-			compilerError("vadd() requires exactly 2 arguments")
-		}
-
-		// Compile first vector argument -> pointer in xmm0
-		fc.compileExpression(call.Args[0])
-		// Push pointer to stack (save for later)
-		fc.out.SubImmFromReg("rsp", StackSlotSize)
-		fc.out.MovXmmToMem("xmm0", "rsp", 0)
+	case "vadd", "vsub", "vmul", "vdiv", "vscale", "dot", "cross", "normalize", "mat4mul":
+		// Vector and matrix builtins backed by SSE2 runtime helpers (see vecmath.go)
+		fc.compileVectorCall(call)
 
-		// Compile second vector argument -> pointer in xmm0
-		fc.compileExpression(call.Args[1])
-		// Convert second vector pointer to rbx
-		fc.out.SubImmFromReg("rsp", StackSlotSize)
-		fc.out.MovXmmToMem("xmm0", "rsp", 0)
-		fc.out.MovMemToReg("rbx", "rsp", 0)
-		fc.out.AddImmToReg("rsp", StackSlotSize)
+	case "copy", "deepcopy":
+		// Shallow and deep copies of lists and maps (see copy.go)
+		fc.compileCopyCall(call)
 
-		// Get first vector pointer from stack to rax
-		fc.out.MovMemToReg("rax", "rsp", 0)
-		fc.out.AddImmToReg("rsp", StackSlotSize)
+	case "delete":
+		// Remove a key from a hash-indexed map variable (see mapindex.go)
+		fc.compileDeleteCall(call)
 
-		// For now, just return the first vector pointer to test if the logic works
-		fc.out.Cvtsi2sd("xmm0", "rax")
+	case "ilist", "imap", "with", "without":
+		// Persistent collections that share all but the changed path (see persistent.go)
+		fc.compilePersistentCall(call)
 
-	case "vsub":
-		// vsub(v1, v2) - Vector subtraction using SIMD
-		if len(call.Args) != 2 {
-			compilerError("vsub() requires exactly 2 arguments")
-		}
+	case "onfree":
+		// Cleanup for a C resource when its arena is reset (see finalizer.go)
+		fc.compileOnfreeCall(call)
 
-		// Compile first vector argument -> pointer in xmm0
-		fc.compileExpression(call.Args[0])
-		fc.out.SubImmFromReg("rsp", StackSlotSize)
-		fc.out.MovXmmToMem("xmm0", "rsp", 0)
-		fc.out.MovMemToReg("r12", "rsp", 0)
-		fc.out.AddImmToReg("rsp", StackSlotSize)
+	case "intern", "hash":
+		// Symbol (map key) for a runtime string (see intern.go)
+		fc.compileInternCall(call)
 
-		// Compile second vector argument -> pointer in xmm0
-		fc.compileExpression(call.Args[1])
-		fc.out.SubImmFromReg("rsp", StackSlotSize)
-		fc.out.MovXmmToMem("xmm0", "rsp", 0)
-		fc.out.MovMemToReg("r13", "rsp", 0)
-		fc.out.AddImmToReg("rsp", StackSlotSize)
+	case "fieldnames", "getfield", "setfield":
+		// Field names and dynamic field access on records (see fields.go)
+		fc.compileFieldCall(call)
 
-		// Allocate stack space for result
-		fc.out.SubImmFromReg("rsp", 16)
+	case "typeof":
+		// Kind of a value as a symbol (see typeof.go)
+		fc.compileTypeofCall(call)
 
-		// Load and subtract vectors using SIMD
-		fc.out.MovupdMemToXmm("xmm0", "r12", 0)
-		fc.out.MovupdMemToXmm("xmm1", "r13", 0)
-		fc.out.SubpdXmm("xmm0", "xmm1")
+	case "defined", "cfg":
+		// Compile-time definitions from -D (see defines.go)
+		fc.compileDefineCall(call)
 
-		// Store result and return pointer
-		fc.out.MovupdXmmToMem("xmm0", "rsp", 0)
-		fc.out.MovRegToReg("rax", "rsp")
-		fc.out.Cvtsi2sd("xmm0", "rax")
+	case "logdebug", "loginfo", "logwarn", "logerror":
+		// Leveled, timestamped lines on stderr (see logging.go)
+		fc.compileLogCall(call)
 
-	case "vmul":
-		// vmul(v1, v2) - Vector element-wise multiplication using SIMD
-		if len(call.Args) != 2 {
-			compilerError("vmul() requires exactly 2 arguments")
-		}
+	case "args", "flags":
+		// Command-line arguments as a list, or parsed into a map (see args.go)
+		fc.compileArgsCall(call)
 
-		// Compile first vector argument
-		fc.compileExpression(call.Args[0])
-		fc.out.SubImmFromReg("rsp", StackSlotSize)
-		fc.out.MovXmmToMem("xmm0", "rsp", 0)
-		fc.out.MovMemToReg("r12", "rsp", 0)
-		fc.out.AddImmToReg("rsp", StackSlotSize)
+	case "parsetoml":
+		// TOML or INI text to nested maps (see toml.go)
+		fc.compileParseTomlCall(call)
 
-		// Compile second vector argument
-		fc.compileExpression(call.Args[1])
-		fc.out.SubImmFromReg("rsp", StackSlotSize)
-		fc.out.MovXmmToMem("xmm0", "rsp", 0)
-		fc.out.MovMemToReg("r13", "rsp", 0)
-		fc.out.AddImmToReg("rsp", StackSlotSize)
+	case "run":
+		// Output of a shell command (see subprocess.go)
+		if len(call.Args) != 1 {
+			compilerError("run() requires exactly 1 argument (command)")
+		}
+		fc.compileRun(call.Args, nil)
 
-		// Allocate stack space for result
-		fc.out.SubImmFromReg("rsp", 16)
+	case "bigload":
+		// File of float64s or numeric lines as a list (see bigload.go)
+		fc.compileBigloadCall(call)
 
-		// Load and multiply vectors using SIMD
-		fc.out.MovupdMemToXmm("xmm0", "r12", 0)
-		fc.out.MovupdMemToXmm("xmm1", "r13", 0)
-		fc.out.MulpdXmm("xmm0", "xmm1")
+	case "sum", "min", "max", "mean":
+		// List reductions and min/max of two numbers (see reduce.go)
+		fc.compileReduceCall(call)
 
-		// Store result and return pointer
-		fc.out.MovupdXmmToMem("xmm0", "rsp", 0)
-		fc.out.MovRegToReg("rax", "rsp")
-		fc.out.Cvtsi2sd("xmm0", "rax")
+	case "bsearch", "sortedinsert":
+		// Binary search and insertion into sorted lists (see sorted.go)
+		fc.compileSortedCall(call)
 
-	case "vdiv":
-		// vdiv(v1, v2) - Vector element-wise division using SIMD
-		if len(call.Args) != 2 {
-			compilerError("vdiv() requires exactly 2 arguments")
-		}
+	case "array2d", "gridfill", "gridcopy", "_grid_get", "_grid_set":
+		// 2D grids and grid[x, y] indexing (see grid.go)
+		fc.compileGridCall(call)
 
-		// Compile first vector argument
-		fc.compileExpression(call.Args[0])
-		fc.out.SubImmFromReg("rsp", StackSlotSize)
-		fc.out.MovXmmToMem("xmm0", "rsp", 0)
-		fc.out.MovMemToReg("r12", "rsp", 0)
-		fc.out.AddImmToReg("rsp", StackSlotSize)
+	case "gameloop":
+		// Fixed-timestep update/render loop (see gameloop.go)
+		fc.compileGameloopCall(call)
 
-		// Compile second vector argument
-		fc.compileExpression(call.Args[1])
-		fc.out.SubImmFromReg("rsp", StackSlotSize)
-		fc.out.MovXmmToMem("xmm0", "rsp", 0)
-		fc.out.MovMemToReg("r13", "rsp", 0)
-		fc.out.AddImmToReg("rsp", StackSlotSize)
+	case "now", "datefmt", "dateparse", "adddays", "datediff":
+		// Times as seconds since 1970 in UTC (see datetime.go)
+		fc.compileDateCall(call)
 
-		// Allocate stack space for result
-		fc.out.SubImmFromReg("rsp", 16)
+	case "securerand", "uuid4":
+		// Random bytes from the operating system (see securerand.go)
+		fc.compileSecureRandCall(call)
 
-		// Load and divide vectors using SIMD
-		fc.out.MovupdMemToXmm("xmm0", "r12", 0)
-		fc.out.MovupdMemToXmm("xmm1", "r13", 0)
-		fc.out.DivpdXmm("xmm0", "xmm1")
+	case "cerrno", "cerrstr":
+		// errno after C calls (see errno.go)
+		fc.compileErrnoCall(call)
 
-		// Store result and return pointer
-		fc.out.MovupdXmmToMem("xmm0", "rsp", 0)
-		fc.out.MovRegToReg("rax", "rsp")
-		fc.out.Cvtsi2sd("xmm0", "rax")
+	case "loopmax", "recursionmax":
+		// The --default-loop-max and --default-recursion-max the program was
+		// built with, 0 when not set
+		if len(call.Args) != 0 {
+			compilerError("%s() takes no arguments", call.Function)
+		}
+		limit := DefaultLoopMax
+		if call.Function == "recursionmax" {
+			limit = DefaultRecursionMax
+		}
+		fc.compileExpression(&NumberExpr{Value: float64(limit)})
 
 	case "atomic_add":
 		// atomic_add(ptr, value) - Atomically add value to *ptr and return old value
@@ -16303,6 +16945,74 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 		// Return the stored value
 		fc.out.Cvtsi2sd("xmm0", "rax")
 
+	case "sharedmap":
+		// sharedmap(capacity) - Create a fixed-capacity map that @@ threads can update concurrently
+		// Uses the normal map layout, so m[key] and #m work unchanged (see sharedmap.go)
+		if len(call.Args) != 1 {
+			compilerError("sharedmap() requires exactly 1 argument (capacity)")
+		}
+		fc.usesSharedMap = true
+		fc.eb.Define("_sharedmap_error_msg", sharedMapErrorMsg)
+
+		// Compile capacity argument and keep it on the stack across the allocation
+		fc.compileExpression(call.Args[0])
+		fc.out.Cvttsd2si("rax", "xmm0") // rax = capacity
+		fc.out.SubImmFromReg("rsp", 16)
+		fc.out.MovRegToMem("rax", "rsp", 0)
+
+		// Allocate header (16) + count (8) + capacity * 16 bytes
+		fc.out.MovRegToReg("rdi", "rax")
+		fc.out.ShlImmReg("rdi", 4)
+		fc.out.AddImmToReg("rdi", sharedMapHeaderSize+8)
+		fc.callArenaAlloc()
+
+		// Header: [lock = 0][capacity], then count = 0.0
+		fc.out.MovMemToReg("rcx", "rsp", 0)
+		fc.out.AddImmToReg("rsp", 16)
+		fc.out.MovImmToMem(0, "rax", 0)
+		fc.out.MovRegToMem("rcx", "rax", 8)
+		fc.out.MovImmToMem(0, "rax", sharedMapHeaderSize)
+
+		// Return pointer to the count field, like any other map
+		fc.out.AddImmToReg("rax", sharedMapHeaderSize)
+		fc.out.MovqRegToXmm("xmm0", "rax")
+
+	case "_list_alloc":
+		// _list_alloc(n) - Create a list of n zeros, used by parallel list comprehensions
+		// so that threads can write their elements by index
+		if len(call.Args) != 1 {
+			compilerError("_list_alloc requires exactly 1 argument")
+		}
+		fc.compileExpression(call.Args[0])
+		fc.out.Cvttsd2si("rax", "xmm0") // rax = count
+		fc.out.SubImmFromReg("rsp", 16)
+		fc.out.MovRegToMem("rax", "rsp", 0)
+
+		// Allocate count (8) + n * 16 bytes
+		fc.out.MovRegToReg("rdi", "rax")
+		fc.out.ShlImmReg("rdi", 4)
+		fc.out.AddImmToReg("rdi", 8)
+		fc.callArenaAlloc()
+
+		fc.out.MovMemToReg("rcx", "rsp", 0)
+		fc.out.AddImmToReg("rsp", 16)
+		fc.out.Cvtsi2sd("xmm0", "rcx")
+		fc.out.MovXmmToMem("xmm0", "rax", 0)
+
+		// Fill in keys 0..n-1 with 0.0 values
+		fc.out.XorRegWithReg("rdx", "rdx") // rdx = index
+		fc.out.LeaMemToReg("rsi", "rax", 8)
+		loopStart := fc.eb.text.Len()
+		fc.out.CmpRegToReg("rdx", "rcx")
+		done := fc.forwardJump(JumpGreaterOrEqual)
+		fc.out.MovRegToMem("rdx", "rsi", 0)
+		fc.out.MovImmToMem(0, "rsi", 8)
+		fc.out.IncReg("rdx")
+		fc.out.AddImmToReg("rsi", 16)
+		fc.out.JumpUnconditional(int32(loopStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+		fc.landForwardJump(done)
+		fc.out.MovqRegToXmm("xmm0", "rax")
+
 	case "malloc":
 		// REMOVED: malloc() is not a builtin function.
 		// Use arena {} blocks with allocate() for automatic memory management,
@@ -16455,11 +17165,7 @@ func (fc *C67Compiler) compileCall(call *CallExpr) {
 
 		// xmm0 already has the value
 		// Load current arena pointer into rdx (4th argument)
-		arenaIndex := fc.currentArena - 1
-		arenaOffset := arenaIndex * 8
-		fc.out.LeaSymbolToReg("rdx", "_c67_arena_meta")
-		fc.out.MovMemToReg("rdx", "rdx", 0)           // rdx = meta-arena pointer
-		fc.out.MovMemToReg("rdx", "rdx", arenaOffset) // rdx = arena pointer from slot
+		fc.loadArenaPointer("rdx")
 
 		// Call the runtime function
 		fc.trackFunctionCall("_c67_list_update")
@@ -16535,6 +17241,12 @@ func (fc *C67Compiler) compilePipeExpr(expr *PipeExpr) {
 	// - If list: map function over elements (use ParallelExpr)
 	// - If scalar: call function with single value
 
+	// Piping into run() feeds the command's stdin
+	if isRunCall(expr.Right) {
+		fc.compileRunPipe(expr)
+		return
+	}
+
 	leftType := fc.getExprType(expr.Left)
 
 	if leftType == "list" {
@@ -16635,7 +17347,7 @@ func (fc *C67Compiler) compileSendExpr(expr *SendExpr) {
 	// Allocate stack space for: message map (8), socket fd (8), sockaddr_in (16), message buffer (256)
 	stackSpace := int64(288)
 	fc.out.SubImmFromReg("rsp", stackSpace)
-	fc.runtimeStack += int(stackSpace)
+	fc.growRuntimeStack(int(stackSpace))
 
 	// Step 1: Evaluate and save message
 	fc.compileExpression(expr.Message)
@@ -16756,7 +17468,7 @@ func (fc *C67Compiler) compileReceiveExpr(expr *ReceiveExpr) {
 	// Allocate stack space for: socket fd (8), sockaddr_in (16), sender addr (16), buffer (256), result map (8)
 	stackSpace := int64(304)
 	fc.out.SubImmFromReg("rsp", stackSpace)
-	fc.runtimeStack += int(stackSpace)
+	fc.growRuntimeStack(int(stackSpace))
 
 	// Step 1: Create UDP socket (syscall 41: socket)
 	fc.out.MovImmToReg("rax", "41") // socket syscall
@@ -17014,6 +17726,8 @@ func (fc *C67Compiler) compileReceiveLoopStmt(stmt *ReceiveLoopStmt) {
 		fc.compileStatement(bodyStmt)
 	}
 
+	fc.emitSafepoint()
+
 	// Step 6: Jump back to loop start
 	fc.out.JumpUnconditional(0) // Will be patched
 	endOfBody := fc.eb.text.Len()
@@ -17039,6 +17753,30 @@ func (fc *C67Compiler) compileReceiveLoopStmt(stmt *ReceiveLoopStmt) {
 // Confidence that this function is working: 95%
 // createErrorResult creates an error Result with the given error code in xmm0
 // The error code should be a 3-4 character string like "out", "arg", "dv0", etc.
+// Error values are quiet NaNs with a non-zero payload and the sign bit clear, so
+// NaNs produced by arithmetic (0x7FF8_0000_0000_0000, or 0xFFF8_... on x86) stay ordinary values
+const (
+	errorNaNBase     = 0x7FF8000000000000 // Quiet NaN; error(code) puts a 4-char code in the low 32 bits
+	errorMessageTag  = 0x7FFC000000000000 // Quiet NaN; err(message) puts a string pointer in the low 48 bits
+	errorPayloadMask = 0x0007FFFFFFFFFFFF
+	errorPointerMask = 0x0000FFFFFFFFFFFF
+)
+
+// emitJumpIfNotError tests the value in xmm0 and returns the positions of the
+// jumps taken when it is not an error value; land them with landForwardJump.
+// Falls through with the value's bits in rax when it is an error. Clobbers rax and rcx.
+func (fc *C67Compiler) emitJumpIfNotError() []int {
+	fc.out.MovqXmmToReg("rax", "xmm0")
+	fc.out.MovRegToReg("rcx", "rax")
+	fc.out.ShrRegByImm("rcx", 51)
+	fc.out.CmpRegToImm("rcx", errorNaNBase>>51) // positive quiet NaN
+	notNaN := fc.forwardJump(JumpNotEqual)
+	fc.out.MovImmToReg("rcx", strconv.FormatUint(errorPayloadMask, 10))
+	fc.out.TestRegReg("rax", "rcx")
+	noPayload := fc.forwardJump(JumpEqual)
+	return []int{notNaN, noPayload}
+}
+
 func (fc *C67Compiler) createErrorResult(errorCode string) {
 	// Pad error code to 4 bytes with null terminator if needed
 	code := errorCode
@@ -17049,10 +17787,11 @@ func (fc *C67Compiler) createErrorResult(errorCode string) {
 		code = code[:4]
 	}
 
-	// Convert error code string to 32-bit integer (little-endian byte order)
+	// Convert error code string to a 32-bit integer, first character in the
+	// high byte as error("code") and the .error accessor expect
 	var codeInt uint32
 	for i := 0; i < 4; i++ {
-		codeInt |= uint32(code[i]) << (uint(i) * 8)
+		codeInt |= uint32(code[i]) << (24 - uint(i)*8)
 	}
 
 	// Create error NaN: 0x7FF8_0000_0000_0000 | error_code
@@ -17079,6 +17818,30 @@ func (fc *C67Compiler) trackFunctionCall(funcName string) {
 	fc.callOrder = append(fc.callOrder, funcName)
 }
 
+// trackDataSymbol records a data symbol from a shared library, such as
+// stdout or environ, which gets a GOT slot filled by the dynamic linker
+func (fc *C67Compiler) trackDataSymbol(name string) {
+	for _, used := range fc.usedDataSymbols {
+		if used == name {
+			return
+		}
+	}
+	fc.usedDataSymbols = append(fc.usedDataSymbols, name)
+}
+
+// dataSymbolSlotLabel is the label of the GOT slot of a data symbol
+func dataSymbolSlotLabel(name string) string {
+	return "_c67_got_" + name
+}
+
+// emitDataSymbolAddress loads the address of the data symbol name from
+// its GOT slot into dst
+func (fc *C67Compiler) emitDataSymbolAddress(dst, name string) {
+	fc.trackDataSymbol(name)
+	fc.out.LeaSymbolToReg(dst, dataSymbolSlotLabel(name))
+	fc.out.MovMemToReg(dst, dst, 0)
+}
+
 // callMallocAligned calls malloc with proper stack alignment.
 // This helper ensures the stack is 16-byte aligned before calling malloc,
 // which is required by the x86-64 System V ABI.
@@ -17135,6 +17898,7 @@ func (fc *C67Compiler) callMallocAligned(sizeReg string, pushCount int) {
 	fc.trackFunctionCall("printf")
 	fc.eb.GenerateCallInstruction("printf")
 	fc.out.MovImmToReg("rdi", "1")
+	fc.emitFlushStdout()
 	fc.trackFunctionCall("exit")
 	fc.eb.GenerateCallInstruction("exit")
 
@@ -17322,6 +18086,8 @@ func collectFunctionCallsWithParams(expr Expression, calls map[string]bool, para
 		collectFunctionCallsWithParams(e.Container, calls, params)
 	case *MoveExpr:
 		collectFunctionCallsWithParams(e.Expr, calls, params)
+	case *TryExpr:
+		collectFunctionCallsWithParams(e.Expr, calls, params)
 	}
 }
 
@@ -17345,6 +18111,11 @@ func collectFunctionCallsFromStmtWithParams(stmt Statement, calls map[string]boo
 		for _, bodyStmt := range s.Body {
 			collectFunctionCallsFromStmtWithParams(bodyStmt, calls, params)
 		}
+	case *WhileStmt:
+		collectFunctionCallsWithParams(s.Condition, calls, params)
+		for _, bodyStmt := range s.Body {
+			collectFunctionCallsFromStmtWithParams(bodyStmt, calls, params)
+		}
 	}
 }
 
@@ -17392,46 +18163,98 @@ func collectDefinedFromExpr(expr Expression, defined map[string]bool) {
 	}
 }
 
+// builtinFunctions are the functions that are always available (implemented in compiler)
+var builtinFunctions = map[string]bool{
+	"printf": true, "exit": true, "syscall": true,
+	"getpid": true, "me": true,
+	"print": true, "println": true, // print/println are builtin optimizations, not dependencies
+	"eprint": true, "eprintln": true, "eprintf": true, // stderr printing with Result return
+	"exitln": true, "exitf": true, // stderr printing with exit(1)
+	"readln": true, "read_file": true, "readfile": true,
+	// Math functions (hardware instructions)
+	"sqrt": true, "sin": true, "cos": true, "tan": true,
+	"asin": true, "acos": true, "atan": true, "atan2": true,
+	"exp": true, "log": true, "pow": true,
+	"floor": true, "ceil": true, "round": true, "fma": true,
+	"abs": true, "approx": true,
+	// Bit manipulation functions (CPU instructions with fallback)
+	"popcount": true, "clz": true, "ctz": true, "bswap": true,
+	// Fixed-point decimals
+	"decimal": true,
+	// Channel primitives
+	"chan": true, "close": true,
+	// Concurrency
+	"sharedmap": true,
+	// Vector math
+	"vadd": true, "vsub": true, "vmul": true, "vdiv": true, "vscale": true,
+	"dot": true, "cross": true, "normalize": true, "mat4mul": true,
+	// List methods
+	"append": true, "head": true, "tail": true, "pop": true,
+	"copy": true, "deepcopy": true,
+	// Map methods
+	"delete": true,
+	// Persistent collections
+	"ilist": true, "imap": true, "with": true, "without": true,
+	// Resource cleanup
+	"onfree": true,
+	// Symbols
+	"intern": true, "hash": true, "typeof": true,
+	// Conditional compilation
+	"defined": true, "cfg": true,
+	// Records
+	"fieldnames": true, "getfield": true, "setfield": true,
+	// Runtime limits
+	"loopmax": true, "recursionmax": true,
+	// Logging
+	"logdebug": true, "loginfo": true, "logwarn": true, "logerror": true,
+	// Command line
+	"args": true, "flags": true,
+	// Configuration
+	"parsetoml": true,
+	// External commands
+	"run": true,
+	// Large datasets
+	"bigload": true,
+	// Reductions
+	"sum": true, "min": true, "max": true, "mean": true,
+	// Sorted lists
+	"bsearch": true, "sortedinsert": true,
+	// 2D grids
+	"array2d": true, "gridfill": true, "gridcopy": true,
+	// Game loop
+	"gameloop": true,
+	// Dates and times
+	"now": true, "datefmt": true, "dateparse": true, "adddays": true, "datediff": true,
+	// Secure random bytes
+	"securerand": true, "uuid4": true,
+	// errno after C calls
+	"cerrno": true, "cerrstr": true,
+	// Error handling
+	"error": true, "err": true, "is_nan": true,
+	// Internal functions (start with _)
+	"_error_code_extract": true,
+	"_list_alloc":         true,
+	"_grid_get":           true,
+	"_grid_set":           true,
+	// Debug
+	"printa": true,
+	// Memory allocation
+	"alloc": true, "free": true,
+	// Dynamic library loading
+	"dlopen": true, "dlsym": true, "dlclose": true,
+	// Memory operations
+	"read_i8": true, "read_u8": true, "read_i16": true, "read_u16": true,
+	"read_i32": true, "read_u32": true, "read_i64": true, "read_u64": true, "read_f64": true,
+	"write_i8": true, "write_u8": true, "write_i16": true, "write_u16": true,
+	"write_i32": true, "write_u32": true, "write_i64": true, "write_u64": true, "write_f32": true, "write_f64": true,
+	// Dynamic calling
+	"call": true, "arena_create": true, "arena_alloc": true, "arena_reset": true, "arena_destroy": true,
+}
+
 // Confidence that this function is working: 95%
 // getUnknownFunctions determines which functions are called but not defined
 func getUnknownFunctions(program *Program) []string {
-	// Builtin functions that are always available (implemented in compiler)
-	builtins := map[string]bool{
-		"printf": true, "exit": true, "syscall": true,
-		"getpid": true, "me": true,
-		"print": true, "println": true, // print/println are builtin optimizations, not dependencies
-		"eprint": true, "eprintln": true, "eprintf": true, // stderr printing with Result return
-		"exitln": true, "exitf": true, // stderr printing with exit(1)
-		// Math functions (hardware instructions)
-		"sqrt": true, "sin": true, "cos": true, "tan": true,
-		"asin": true, "acos": true, "atan": true, "atan2": true,
-		"exp": true, "log": true, "pow": true,
-		"floor": true, "ceil": true, "round": true,
-		"abs": true, "approx": true,
-		// Bit manipulation functions (CPU instructions with fallback)
-		"popcount": true, "clz": true, "ctz": true,
-		// Channel primitives
-		"chan": true, "close": true,
-		// List methods
-		"append": true, "head": true, "tail": true, "pop": true,
-		// Error handling
-		"error": true, "is_nan": true,
-		// Internal functions (start with _)
-		"_error_code_extract": true,
-		// Debug
-		"printa": true,
-		// Memory allocation
-		"alloc": true, "free": true,
-		// Dynamic library loading
-		"dlopen": true, "dlsym": true, "dlclose": true,
-		// Memory operations
-		"read_i8": true, "read_u8": true, "read_i16": true, "read_u16": true,
-		"read_i32": true, "read_u32": true, "read_i64": true, "read_u64": true, "read_f64": true,
-		"write_i8": true, "write_u8": true, "write_i16": true, "write_u16": true,
-		"write_i32": true, "write_u32": true, "write_i64": true, "write_u64": true, "write_f32": true, "write_f64": true,
-		// Dynamic calling
-		"call": true, "arena_create": true, "arena_alloc": true, "arena_reset": true, "arena_destroy": true,
-	}
+	builtins := builtinFunctions
 
 	// Collect C import namespaces (e.g., "enet", "libc")
 	cImports := make(map[string]bool)
@@ -17513,6 +18336,26 @@ func getUnknownFunctions(program *Program) []string {
 	return unknown
 }
 
+// describeUnknownFunctions returns the unknown function names, each with up
+// to three defined or builtin functions that are spelled alike
+func describeUnknownFunctions(program *Program, unknown []string) []string {
+	var known []string
+	for name := range collectDefinedFunctions(program) {
+		known = append(known, name)
+	}
+	for name := range builtinFunctions {
+		known = append(known, name)
+	}
+	described := make([]string, len(unknown))
+	for i, name := range unknown {
+		described[i] = name
+		if suggestions := findSimilarNames(name, known, 3); len(suggestions) > 0 {
+			described[i] = fmt.Sprintf("%s (did you mean: %s?)", name, strings.Join(suggestions, ", "))
+		}
+	}
+	return described
+}
+
 // filterPrivateFunctions removes all function definitions with names starting with _
 // Private functions (starting with _) are not exported when importing modules
 func filterPrivateFunctions(program *Program) {
@@ -17533,6 +18376,15 @@ func filterPrivateFunctions(program *Program) {
 }
 
 func processImports(program *Program, platform Platform, sourceFilePath string) error {
+	absSourcePath, _ := filepath.Abs(sourceFilePath)
+	return processImportsFrom(program, platform, sourceFilePath, []string{absSourcePath}, map[string]string{})
+}
+
+// processImportsFrom processes the imports of program, and of the files it
+// imports, in turn. chain is the file of program and the files that
+// imported it, and loaded the files that were imported already, with the
+// namespace their functions are in ("" if none).
+func processImportsFrom(program *Program, platform Platform, sourceFilePath string, chain []string, loaded map[string]string) error {
 	// Find all import statements (both Git and C imports)
 	var imports []*ImportStmt
 	var cImports []*CImportStmt
@@ -17615,6 +18467,11 @@ func processImports(program *Program, platform Platform, sourceFilePath string)
 
 		// Parse and merge each .c67 file with namespace handling
 		for _, c67File := range c67Files {
+			absFile, _ := filepath.Abs(c67File)
+			if err := checkImportCycle(chain, absFile); err != nil {
+				return err
+			}
+
 			depContent, err := os.ReadFile(c67File)
 			if err != nil {
 				if VerboseMode {
@@ -17623,6 +18480,7 @@ func processImports(program *Program, platform Platform, sourceFilePath string)
 				continue
 			}
 
+			buildManifest.addSource(c67File, depContent)
 			depParser := NewParserWithFilename(string(depContent), c67File)
 			depProgram := depParser.ParseProgram()
 
@@ -17649,22 +18507,44 @@ func processImports(program *Program, platform Platform, sourceFilePath string)
 				}
 			}
 
+			namespace := ""
+			if usePrefix {
+				namespace = imp.Alias
+			}
+			if loadedNamespace, ok := loaded[absFile]; ok {
+				// Imported by another file too, so its functions are defined
+				// already. Under another alias, that alias names their namespace,
+				// or gives them one if they were imported without.
+				if namespace != "" && namespace != loadedNamespace {
+					if loadedNamespace == "" {
+						addNamespaceToFunctions(depProgram, namespace)
+						mergeFunctionNamespaces(program, depProgram)
+					} else {
+						if program.NamespaceAliases == nil {
+							program.NamespaceAliases = make(map[string]string)
+						}
+						program.NamespaceAliases[namespace] = loadedNamespace
+					}
+				}
+				continue
+			}
+			loaded[absFile] = namespace
+
 			if usePrefix {
 				addNamespaceToFunctions(depProgram, imp.Alias)
 			}
 
+			// The imports of the imported file, relative to it, after its
+			// own functions have their namespace
+			if err := processImportsFrom(depProgram, platform, absFile, append(chain[:len(chain):len(chain)], absFile), loaded); err != nil {
+				return err
+			}
+
 			// Prepend dependency program to main program
 			program.Statements = append(depProgram.Statements, program.Statements...)
 
 			// Merge namespace mappings
-			if depProgram.FunctionNamespaces != nil {
-				if program.FunctionNamespaces == nil {
-					program.FunctionNamespaces = make(map[string]string)
-				}
-				for funcName, ns := range depProgram.FunctionNamespaces {
-					program.FunctionNamespaces[funcName] = ns
-				}
-			}
+			mergeFunctionNamespaces(program, depProgram)
 
 			if VerboseMode {
 				fmt.Fprintf(os.Stderr, "Loaded %s from %s\n", c67File, imp.URL)
@@ -17858,6 +18738,27 @@ func transformDotNotationExpr(expr Expression, instanceName string) Expression {
 	return expr
 }
 
+// mergeFunctionNamespaces adds the namespaces of the functions of
+// depProgram, and its namespace aliases, to those of program
+func mergeFunctionNamespaces(program, depProgram *Program) {
+	if depProgram.FunctionNamespaces != nil {
+		if program.FunctionNamespaces == nil {
+			program.FunctionNamespaces = make(map[string]string)
+		}
+		for funcName, ns := range depProgram.FunctionNamespaces {
+			program.FunctionNamespaces[funcName] = ns
+		}
+	}
+	if depProgram.NamespaceAliases != nil {
+		if program.NamespaceAliases == nil {
+			program.NamespaceAliases = make(map[string]string)
+		}
+		for alias, ns := range depProgram.NamespaceAliases {
+			program.NamespaceAliases[alias] = ns
+		}
+	}
+}
+
 func addNamespaceToFunctions(program *Program, namespace string) {
 	// Store namespace metadata in Program for later use during compilation
 	// We can't rename functions with dots because the parser doesn't support it
@@ -17888,6 +18789,19 @@ func CompileC67WithOptions(inputPath string, outputPath string, platform Platfor
 		VerboseMode = oldVerbose
 	}()
 
+	// The outermost call writes the manifest, once the executable is built
+	if EmitManifestFlag && buildManifest == nil {
+		buildManifest = &BuildManifest{Compiler: versionString}
+		defer func() { buildManifest = nil }()
+		defer func() {
+			if err == nil {
+				err = buildManifest.write(outputPath)
+			}
+		}()
+	}
+
+	buildManifest.addTarget(platform)
+
 	// Default to WPO if not explicitly set
 	if wpoTimeout == 0 {
 		wpoTimeout = 2.0
@@ -17921,8 +18835,12 @@ func CompileC67WithOptions(inputPath string, outputPath string, platform Platfor
 	if readErr != nil {
 		return fmt.Errorf("failed to read %s: %v", inputPath, readErr)
 	}
+	buildManifest.addSource(inputPath, content)
 
-	// Parse main file
+	// Parse main file, with a fresh set of hashed names to check and of
+	// used files to include
+	keyNames = map[uint64]string{}
+	usedFiles = newUseState()
 	parser := NewParserWithFilename(string(content), inputPath)
 	program := parser.ParseProgram()
 
@@ -18006,6 +18924,7 @@ func CompileC67WithOptions(inputPath string, outputPath string, platform Platfor
 						continue
 					}
 
+					buildManifest.addSource(siblingPath, siblingContent)
 					siblingParser := NewParserWithFilename(string(siblingContent), siblingPath)
 					siblingProgram := siblingParser.ParseProgram()
 
@@ -18039,6 +18958,7 @@ func CompileC67WithOptions(inputPath string, outputPath string, platform Platfor
 				if err != nil {
 					return fmt.Errorf("failed to fetch dependency %s: %v", repoURL, err)
 				}
+				buildManifest.addGitImport(repoURL, "", repoPath)
 
 				// Find all .c67 files in the repository
 				c67Files, err := FindC67Files(repoPath)
@@ -18056,6 +18976,7 @@ func CompileC67WithOptions(inputPath string, outputPath string, platform Platfor
 						continue
 					}
 
+					buildManifest.addSource(c67File, depContent)
 					depParser := NewParserWithFilename(string(depContent), c67File)
 					depProgram := depParser.ParseProgram()
 
@@ -18114,10 +19035,11 @@ func CompileC67WithOptions(inputPath string, outputPath string, platform Platfor
 		sort.Strings(finalUnknownFuncs)
 
 		// Report all undefined functions
+		names := describeUnknownFunctions(program, finalUnknownFuncs)
 		if len(finalUnknownFuncs) == 1 {
-			return fmt.Errorf("undefined function: %s\nNote: Function must be defined before use or imported from a dependency", finalUnknownFuncs[0])
+			return fmt.Errorf("undefined function: %s\nNote: Function must be defined before use or imported from a dependency", names[0])
 		}
-		return fmt.Errorf("undefined functions: %s\nNote: Functions must be defined before use or imported from dependencies", strings.Join(finalUnknownFuncs, ", "))
+		return fmt.Errorf("undefined functions: %s\nNote: Functions must be defined before use or imported from dependencies", strings.Join(names, ", "))
 	}
 
 	// Compile
@@ -18129,11 +19051,35 @@ func CompileC67WithOptions(inputPath string, outputPath string, platform Platfor
 	compiler.wpoTimeout = wpoTimeout
 	compiler.errors.SetSourceCode(combinedSource)
 
+	if KeepTempsFlag {
+		if err := keepTemps(outputPath, inputPath, content, program); err != nil {
+			return fmt.Errorf("--keep-temps: %v", err)
+		}
+	}
+
 	err = compiler.Compile(program, outputPath)
 	if err != nil {
 		return fmt.Errorf("compilation failed: %v", err)
 	}
 
+	if KeepTempsFlag {
+		if err := keepTempsSections(outputPath, compiler.eb); err != nil {
+			return fmt.Errorf("--keep-temps: %v", err)
+		}
+	}
+
+	if EmitHeaderFlag {
+		if err := writeHeader(inputPath, outputPath); err != nil {
+			return fmt.Errorf("--emit-header: %v", err)
+		}
+	}
+
+	if EmitPythonFlag {
+		if err := writePythonModule(inputPath, outputPath); err != nil {
+			return fmt.Errorf("--emit-python: %v", err)
+		}
+	}
+
 	// Output optimization summary (unless in quiet mode)
 	if !QuietMode {
 		totalCalls := compiler.tailCallsOptimized + compiler.nonTailCalls
@@ -18179,6 +19125,12 @@ func (fc *C67Compiler) compileRiscv64(program *Program, outputPath string) error
 		return err
 	}
 
+	// Hide load latency on in-order cores
+	scheduleRiscv64(fc.eb.text.Bytes())
+
+	// Use the 16-bit encodings of the C extension where they fit
+	compressRiscv64(fc.eb)
+
 	// Write ELF file
 	return fc.writeELFRiscv64(outputPath)
 }
@@ -91,10 +91,12 @@ func (fc *C67Compiler) writeELFARM64(outputPath string) error {
 
 	// Add C library dependencies from imports
 	for libName := range fc.cLibHandles {
-		if libName != "linked" && libName != "c" {
+		if libName != "linked" && libName != "c" && libName != "" {
 			ds.AddNeeded(libName)
 		}
 	}
+	ds.SetRPath(RPathFlag)
+	ds.SetRunPath(RunPathFlag)
 
 	// Add symbols to dynamic sections (STB_GLOBAL = 1, STT_FUNC = 2)
 	for _, funcName := range pltFunctions {
@@ -105,6 +107,7 @@ func (fc *C67Compiler) writeELFARM64(outputPath string) error {
 	for _, lambda := range fc.lambdaFuncs {
 		ds.AddSymbol(lambda.Name, 1, 2) // STB_GLOBAL, STT_FUNC
 	}
+	fc.addSymbolVersions(ds, pltFunctions)
 
 	// Prepare rodata section (strings, constants) before writing ELF
 	// This is crucial - WriteCompleteDynamicELF expects rodata to be in eb.rodata buffer
@@ -217,6 +220,7 @@ func (fc *C67Compiler) writeELFARM64(outputPath string) error {
 
 	// Update ELF with patched text
 	fc.eb.patchTextInELF()
+	fc.eb.appendSymbolTable(textAddr-baseAddr, textAddr)
 
 	// Write the final executable to file
 	elfBytes := fc.eb.Bytes()
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"slices"
 	"sort"
 	"strings"
 )
@@ -73,7 +74,7 @@ func (fc *C67Compiler) writeELF(program *Program, outputPath string) error {
 			break
 		}
 	}
-	if needsLibc {
+	if needsLibc || len(fc.usedDataSymbols) > 0 {
 		ds.AddNeeded("libc.so.6")
 	}
 
@@ -104,61 +105,26 @@ func (fc *C67Compiler) writeELF(program *Program, outputPath string) error {
 	// Add C library dependencies from imports
 	for libName := range fc.cLibHandles {
 		if libName != "linked" {
-			if libName == "c" {
+			// c.function(...) calls are tracked without a library name
+			if libName == "c" || libName == "" {
 				continue
 			}
-			libSoName := libName
-			if strings.Contains(libSoName, ".so") {
+			if strings.Contains(libName, ".so") {
 				if VerboseMode {
-					fmt.Fprintf(os.Stderr, "Adding custom C library dependency: %s\n", libSoName)
+					fmt.Fprintf(os.Stderr, "Adding custom C library dependency: %s\n", libName)
 				}
-				ds.AddNeeded(libSoName)
+				ds.AddNeeded(libName)
 				continue
 			}
-			if !strings.Contains(libSoName, ".so") {
-				cmd := exec.Command("pkg-config", "--libs-only-l", libName)
-				if output, err := cmd.Output(); err == nil {
-					libs := strings.TrimSpace(string(output))
-					if strings.HasPrefix(libs, "-l") {
-						libSoName = "lib" + strings.TrimPrefix(libs, "-l") + ".so"
-					} else {
-						if !strings.HasPrefix(libSoName, "lib") {
-							libSoName = "lib" + libSoName
-						}
-						libSoName += ".so"
-					}
-				} else {
-					if !strings.HasPrefix(libSoName, "lib") {
-						libSoName = "lib" + libSoName
-					}
-					ldconfigCmd := exec.Command("ldconfig", "-p")
-					if ldOutput, ldErr := ldconfigCmd.Output(); ldErr == nil {
-						lines := strings.Split(string(ldOutput), "\n")
-						for _, line := range lines {
-							if strings.Contains(line, libSoName) && strings.Contains(line, "=>") {
-								parts := strings.Split(line, "=>")
-								if len(parts) == 2 {
-									actualPath := strings.TrimSpace(parts[1])
-									pathParts := strings.Split(actualPath, "/")
-									if len(pathParts) > 0 {
-										libSoName = pathParts[len(pathParts)-1]
-									}
-									break
-								}
-							}
-						}
-					}
-					if !strings.Contains(libSoName, ".so") {
-						libSoName += ".so"
-					}
-				}
-			}
+			libSoName := cLibrarySoName(libName)
 			if VerboseMode {
 				fmt.Fprintf(os.Stderr, "Adding C library dependency: %s\n", libSoName)
 			}
 			ds.AddNeeded(libSoName)
 		}
 	}
+	ds.SetRPath(RPathFlag)
+	ds.SetRunPath(RunPathFlag)
 
 	// Add PLT symbols
 	for _, funcName := range pltFunctions {
@@ -169,24 +135,18 @@ func (fc *C67Compiler) writeELF(program *Program, outputPath string) error {
 	for _, lambda := range fc.lambdaFuncs {
 		ds.AddSymbol(lambda.Name, STB_GLOBAL, STT_FUNC)
 	}
+	// Add data symbols, after all function symbols
+	for _, name := range fc.usedDataSymbols {
+		ds.AddDataSymbol(name)
+	}
+	fc.addSymbolVersions(ds, append(slices.Clone(pltFunctions), fc.usedDataSymbols...))
 
 	// Note: Library dependencies will be determined dynamically based on actual usage
 
-	// Add cache pointer storage to rodata (8 bytes of zeros for each cache)
-	if len(fc.memoCaches) > 0 {
-		for cacheName := range fc.memoCaches {
-			fc.eb.Define(cacheName, "\x00\x00\x00\x00\x00\x00\x00\x00")
-		}
-	}
-
-	// Check if hot functions are used with WPO disabled
-	if len(fc.hotFunctions) > 0 && fc.wpoTimeout == 0 {
-		return fmt.Errorf("hot functions require whole-program optimization (do not use --opt-timeout=0)")
+	if err := fc.defineProgramTables(); err != nil {
+		return err
 	}
 
-	fc.buildHotFunctionTable()
-	fc.generateHotFunctionTable()
-
 	rodataSymbols := fc.eb.RodataSection()
 
 	// Create sorted list of symbol names for deterministic ordering
@@ -271,6 +231,9 @@ func (fc *C67Compiler) writeELF(program *Program, outputPath string) error {
 	if err != nil {
 		return err
 	}
+	for _, name := range fc.usedDataSymbols {
+		fc.eb.DefineAddr(dataSymbolSlotLabel(name), ds.DataSymbolSlot(name, gotBase, len(pltFunctions)))
+	}
 
 	// Update rodata addresses using same sorted order
 	currentAddr = rodataBaseAddr
@@ -308,132 +271,12 @@ func (fc *C67Compiler) writeELF(program *Program, outputPath string) error {
 		currentAddr = dataBaseAddr
 	}
 
-	// Regenerate code with correct addresses
-	fc.eb.text.Reset()
-	// DON'T reset rodata - it already has correct addresses from first pass
-	// Resetting rodata causes all symbols to move, breaking PC-relative addressing
-	fc.eb.pcRelocations = []PCRelocation{} // Reset PC relocations for recompilation
-	fc.eb.callPatches = []CallPatch{}      // Reset call patches for recompilation
-	fc.eb.labels = make(map[string]int)    // Reset labels for recompilation
-	fc.callOrder = []string{}              // Clear call order for recompilation
-	fc.stringCounter = 0                   // Reset string counter for recompilation
-	fc.labelCounter = 0                    // Reset label counter for recompilation
-	fc.lambdaCounter = 0                   // Reset lambda counter for recompilation
-	// DON'T clear lambdaFuncs - we need them for second pass lambda generation
-	fc.lambdaOffsets = make(map[string]int) // Reset lambda offsets
-	fc.variables = make(map[string]int)     // Reset variables map
-	fc.mutableVars = make(map[string]bool)  // Reset mutability tracking
-	fc.stackOffset = 0                      // Reset stack offset
-	// Set up stack frame
-	fc.out.PushReg("rbp")
-	fc.out.MovRegToReg("rbp", "rsp")
-	fc.out.SubImmFromReg("rsp", StackSlotSize) // Align stack to 16 bytes
-	fc.out.XorRegWithReg("rax", "rax")
-	fc.out.XorRegWithReg("rdi", "rdi")
-	fc.out.XorRegWithReg("rsi", "rsi")
-
-	// DON'T re-define rodata symbols - they already exist from first pass
-	// Re-defining them would change their addresses and break PC-relative references
-
-	// ===== AVX-512 CPU DETECTION (regenerated) =====
-	fc.out.MovImmToReg("rax", "7")              // CPUID leaf 7
-	fc.out.XorRegWithReg("rcx", "rcx")          // subleaf 0
-	fc.out.Emit([]byte{0x0f, 0xa2})             // cpuid
-	fc.out.Emit([]byte{0xf6, 0xc3, 0x01})       // test bl, 1
-	fc.out.Emit([]byte{0x0f, 0xba, 0xe3, 0x10}) // bt ebx, 16
-	fc.out.Emit([]byte{0x0f, 0x92, 0xc0})       // setc al
-	fc.out.LeaSymbolToReg("rbx", "cpu_has_avx512")
-	fc.out.MovByteRegToMem("rax", "rbx", 0) // Write only AL, not full RAX
-	fc.out.XorRegWithReg("rax", "rax")
-	fc.out.XorRegWithReg("rbx", "rbx")
-	fc.out.XorRegWithReg("rcx", "rcx")
-	// ===== END AVX-512 DETECTION =====
-
-	// Recompile with correct addresses
-	// NOTE: Use the original program parameter (which includes imports),
-	// not a reparsed version from source which would lose imported statements
-
-	// Reset compiler state for second pass
-	fc.variables = make(map[string]int)
-	fc.mutableVars = make(map[string]bool)
-	fc.varTypes = make(map[string]string)
-	fc.stackOffset = 0
-	fc.lambdaFuncs = nil // Clear lambda list so collectSymbols can repopulate it
-	fc.lambdaCounter = 0
-	fc.labelCounter = 0                                       // Reset label counter for consistent loop labels
-	fc.movedVars = make(map[string]bool)                      // Reset moved variables tracking
-	fc.scopedMoved = []map[string]bool{make(map[string]bool)} // Reset scoped tracking
-
-	// Collect symbols again (two-pass compilation for second regeneration)
-	for _, stmt := range program.Statements {
-		if err := fc.collectSymbols(stmt); err != nil {
-			return err
-		}
-	}
-
-	// Reset labelCounter after collectSymbols so compilation uses same labels
-	fc.labelCounter = 0
-
-	// DON'T rebuild hot function table - it already exists in rodata from first pass
-	// Rebuilding it would change its address and break PC-relative references
-
-	fc.pushDeferScope()
-
-	// Initialize arena system (malloc'd arenas at runtime)
-	fc.initializeMetaArenaAndGlobalArena()
-
-	// Generate code with symbols collected
-	for _, stmt := range program.Statements {
-		fc.compileStatement(stmt)
-	}
-
-	fc.popDeferScope()
-
-	// Jump over lambda functions to reach the main evaluation code
-	skipLambdasJump := fc.eb.text.Len()
-	fc.out.JumpUnconditional(0) // Will be patched
-	skipLambdasEnd := fc.eb.text.Len()
-
-	// Generate lambda functions here (before exit, but jumped over)
-	fc.generateLambdaFunctions()
-
-	// Patch the jump to skip over lambdas
-	skipLambdasTarget := fc.eb.text.Len()
-	fc.patchJumpImmediate(skipLambdasJump+1, int32(skipLambdasTarget-skipLambdasEnd))
-
-	// Evaluate main (if it exists) to get the exit code
-	_, exists := fc.variables["main"]
-	if exists {
-		// main exists - check if it's a lambda/function or a direct value
-		if fc.lambdaVars["main"] {
-			// main is a lambda/function - call it with no arguments
-			fc.compileExpression(&CallExpr{Function: "main", Args: []Expression{}})
-		} else {
-			// main is a direct value - just load it
-			fc.compileExpression(&IdentExpr{Name: "main"})
-		}
-		// Result is in xmm0 (float64)
-		// Convert float64 result in xmm0 to int32 in rdi (for exit code)
-		fc.out.Emit([]byte{0xf2, 0x48, 0x0f, 0x2c, 0xf8})
-	} else {
-		// No main - use exit code 0
-		fc.out.XorRegWithReg("rdi", "rdi")
-	}
-
-	// Always add implicit exit at the end of the program
-	// Use syscall exit on Linux (no libc dependency for syscall-based printf)
-	if VerboseMode {
-		fmt.Fprintf(os.Stderr, "DEBUG: Using syscall exit (no libc)\n")
+	// Regenerate code with correct addresses, probing the CPU only if the
+	// code uses what it finds
+	if err := fc.regenerateTextWithRuntimeInit(program, "c67.main"); err != nil {
+		return err
 	}
-	fc.out.MovImmToReg("rax", "60") // syscall number for exit
-	// exit code is already in rdi (first syscall argument)
-	fc.eb.Emit("syscall") // invoke syscall directly
-
-	// Generate pattern lambda functions
-	fc.generatePatternLambdaFunctions()
-
-	// Generate runtime helper functions AFTER lambda generation
-	fc.generateRuntimeHelpers()
+	fc.emitCrashSymbols()
 
 	// Collect rodata symbols again (lambda/runtime functions may have created new ones)
 	rodataSymbols = fc.eb.RodataSection()
@@ -545,6 +388,7 @@ func (fc *C67Compiler) writeELF(program *Program, outputPath string) error {
 	fc.eb.patchTextInELF()
 	fc.eb.patchRodataInELF()
 	// Note: data section is already written during WriteCompleteDynamicELF, no patching needed
+	fc.eb.appendSymbolTable(textAddr-baseAddr, textAddr)
 
 	// Output the executable file
 	elfBytes := fc.eb.Bytes()
@@ -581,5 +425,204 @@ func (fc *C67Compiler) writeELF(program *Program, outputPath string) error {
 	return nil
 }
 
+// defineProgramTables defines the data the first pass found a need for:
+// the memo caches, the hot function table and the field names
+func (fc *C67Compiler) defineProgramTables() error {
+	// Add cache pointer storage to rodata (8 bytes of zeros for each cache)
+	if len(fc.memoCaches) > 0 {
+		for cacheName := range fc.memoCaches {
+			fc.eb.Define(cacheName, "\x00\x00\x00\x00\x00\x00\x00\x00")
+		}
+	}
+
+	// Check if hot functions are used with WPO disabled
+	if len(fc.hotFunctions) > 0 && fc.wpoTimeout == 0 {
+		return fmt.Errorf("hot functions require whole-program optimization (do not use --opt-timeout=0)")
+	}
+
+	fc.buildHotFunctionTable()
+	fc.generateHotFunctionTable()
+
+	// All names have been hashed by now, so the table can be laid out
+	if fc.usesFieldnames {
+		fc.eb.Define("_c67_field_names", string(fieldNameTable()))
+	}
+	return nil
+}
+
+// regenerateText generates the code again, now that the data has its
+// addresses, starting with the function entryLabel at the ELF entry point
+func (fc *C67Compiler) regenerateText(program *Program, entryLabel string) error {
+	fc.eb.text.Reset()
+	// DON'T reset rodata - it already has correct addresses from first pass
+	// Resetting rodata causes all symbols to move, breaking PC-relative addressing
+	fc.eb.pcRelocations = []PCRelocation{} // Reset PC relocations for recompilation
+	fc.eb.callPatches = []CallPatch{}      // Reset call patches for recompilation
+	fc.eb.labels = make(map[string]int)    // Reset labels for recompilation
+	fc.callOrder = []string{}              // Clear call order for recompilation
+	fc.stringCounter = 0                   // Reset string counter for recompilation
+	fc.labelCounter = 0                    // Reset label counter for recompilation
+	fc.lambdaCounter = 0                   // Reset lambda counter for recompilation
+	// DON'T clear lambdaFuncs - we need them for second pass lambda generation
+	fc.lambdaOffsets = make(map[string]int) // Reset lambda offsets
+	fc.variables = make(map[string]int)     // Reset variables map
+	fc.mutableVars = make(map[string]bool)  // Reset mutability tracking
+	fc.stackOffset = 0                      // Reset stack offset
+	fc.coldPaths = nil                      // Error exits of the first pass were never emitted
+	fc.eb.MarkFunction(entryLabel)
+	fc.emitSaveProgramArgs()
+	fc.emitGCInit()
+	fc.emitMallocAllocInit()
+	fc.emitTraceCountsInit()
+	fc.emitSandboxInit()
+	fc.emitCrashReportInit()
+	// Set up stack frame
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.SubImmFromReg("rsp", StackSlotSize) // Align stack to 16 bytes
+	fc.out.XorRegWithReg("rax", "rax")
+	fc.out.XorRegWithReg("rdi", "rdi")
+	fc.out.XorRegWithReg("rsi", "rsi")
+
+	// DON'T re-define rodata symbols - they already exist from first pass
+	// Re-defining them would change their addresses and break PC-relative references
+
+	// Regenerate CPU feature detection (FMA, AVX2, POPCNT, AVX-512)
+	if fc.usesRuntimeInit {
+		fc.emitRuntimeInit()
+	}
+
+	// Recompile with correct addresses
+	// NOTE: Use the original program parameter (which includes imports),
+	// not a reparsed version from source which would lose imported statements
+
+	// Reset compiler state for second pass
+	fc.variables = make(map[string]int)
+	fc.mutableVars = make(map[string]bool)
+	fc.varTypes = make(map[string]string)
+	fc.stackOffset = 0
+	fc.lambdaFuncs = nil // Clear lambda list so collectSymbols can repopulate it
+	fc.patternLambdaFuncs = nil
+	fc.lambdaCounter = 0
+	fc.labelCounter = 0                                       // Reset label counter for consistent loop labels
+	fc.movedVars = make(map[string]bool)                      // Reset moved variables tracking
+	fc.scopedMoved = []map[string]bool{make(map[string]bool)} // Reset scoped tracking
+
+	// Collect symbols again (two-pass compilation for second regeneration)
+	for _, stmt := range program.Statements {
+		if err := fc.collectSymbols(stmt); err != nil {
+			return err
+		}
+	}
+
+	// Reset labelCounter after collectSymbols so compilation uses same labels
+	fc.labelCounter = 0
+
+	// DON'T rebuild hot function table - it already exists in rodata from first pass
+	// Rebuilding it would change its address and break PC-relative references
+
+	fc.pushDeferScope()
+
+	// Initialize arena system (malloc'd arenas at runtime)
+	fc.initializeMetaArenaAndGlobalArena()
+
+	// Generate code with symbols collected
+	for _, stmt := range program.Statements {
+		fc.compileStatement(stmt)
+	}
+
+	fc.popDeferScope()
+
+	// Jump over lambda functions to reach the main evaluation code
+	skipLambdasJump := fc.eb.text.Len()
+	fc.out.JumpUnconditional(0) // Will be patched
+	skipLambdasEnd := fc.eb.text.Len()
+
+	// Generate lambda functions here (before exit, but jumped over)
+	fc.generateLambdaFunctions()
+
+	// Patch the jump to skip over lambdas
+	skipLambdasTarget := fc.eb.text.Len()
+	fc.patchJumpImmediate(skipLambdasJump+1, int32(skipLambdasTarget-skipLambdasEnd))
+	fc.eb.MarkFunction("c67.exit") // evaluates main and exits
+
+	// Evaluate main (if it exists) to get the exit code
+	// (or the function named with --entry, see entry.go)
+	entry := entryName()
+	_, exists := fc.variables[entry]
+	if exists {
+		// main exists - check if it's a lambda/function or a direct value
+		if fc.lambdaVars[entry] {
+			// main is a lambda/function - call it with no arguments
+			fc.compileExpression(&CallExpr{Function: entry, Args: []Expression{}})
+		} else {
+			// main is a direct value - just load it
+			fc.compileExpression(&IdentExpr{Name: entry})
+		}
+		// Result is in xmm0 (float64)
+		// Convert float64 result in xmm0 to int32 in rdi (for exit code)
+		fc.out.Emit([]byte{0xf2, 0x48, 0x0f, 0x2c, 0xf8})
+	} else {
+		// No main - use exit code 0
+		fc.out.XorRegWithReg("rdi", "rdi")
+	}
+
+	fc.emitTraceReport()
+
+	// With --alloc=malloc, free what is left, so that only real leaks are
+	// reported
+	if fc.mallocAllocEnabled() {
+		fc.out.PushReg("rdi")
+		fc.cleanupAllArenas()
+		fc.out.PopReg("rdi")
+	}
+
+	// Always add implicit exit at the end of the program
+	// Use syscall exit on Linux (no libc dependency for syscall-based printf)
+	if VerboseMode {
+		fmt.Fprintf(os.Stderr, "DEBUG: Using syscall exit (no libc)\n")
+	}
+	fc.emitFlushStdout()
+	fc.out.MovImmToReg("rax", "231") // syscall number for exit_group
+	// exit_group also stops @@ worker threads still leaving their barrier
+	// exit code is already in rdi (first syscall argument)
+	fc.eb.Emit("syscall") // invoke syscall directly
+
+	// Generate pattern lambda functions
+	fc.generatePatternLambdaFunctions()
+
+	// Generate runtime helper functions AFTER lambda generation
+	fc.generateRuntimeHelpers()
+	return nil
+}
+
+// cLibrarySoName returns the shared object name for an imported C library,
+// as it goes into DT_NEEDED: the soname of the library in a -L directory,
+// or of the library ldconfig has for the name pkg-config gives, or for
+// lib<name>.so. libm becomes libm.so.6, so the executable does not need the
+// unversioned development symlink and keeps working across minor versions.
+func cLibrarySoName(libName string) string {
+	if strings.Contains(libName, ".so") {
+		return libName
+	}
+	if path := findLibraryInSearchPaths(libName); path != "" {
+		return sharedObjectName(path)
+	}
+	libSoName := libName
+	if output, err := exec.Command("pkg-config", "--libs-only-l", libName).Output(); err == nil {
+		if libs := strings.Fields(string(output)); len(libs) > 0 && strings.HasPrefix(libs[0], "-l") {
+			libSoName = strings.TrimPrefix(libs[0], "-l")
+		}
+	}
+	if !strings.HasPrefix(libSoName, "lib") {
+		libSoName = "lib" + libSoName
+	}
+	libSoName += ".so"
+	if paths := ldconfigLibraryPaths(libSoName); len(paths) > 0 {
+		return sharedObjectName(paths[0])
+	}
+	return libSoName
+}
+
 // Confidence that this function is working: 50%
 // writePE generates a Windows PE (Portable Executable) file for x86_64
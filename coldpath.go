@@ -0,0 +1,84 @@
+// Completion: 100% - Out-of-line error paths and aligned loop headers
+package main
+
+// coldpath.go - Keeping rarely taken code out of hot loops
+//
+// A runtime check such as the max of a loop or the depth of a recursive call
+// used to emit its error message and exit right after the check, where every
+// iteration had to jump over them and they took up instruction cache:
+//
+//	cmp rax, rcx
+//	jl ok
+//	<print the error and exit>
+//	ok:
+//
+// emitColdAbort emits only the conditional jump. The code it jumps to is
+// emitted by emitColdPaths after the program and its lambdas, together with
+// the runtime helpers, and the checks with the same message share it.
+//
+// Loop headers, the targets of the back-edge jumps, start at a multiple of
+// loopHeaderAlignment so that the decoder fetches them in one go.
+
+// loopHeaderAlignment is the alignment of the first instruction of a loop
+const loopHeaderAlignment = 16
+
+// coldPath is an error exit that is emitted out of line
+type coldPath struct {
+	msgLabel string
+	msg      string
+	jumps    []int // forward jumps from the checks that end here
+}
+
+// emitColdAbort emits a conditional jump, taken when the check failed, to
+// out-of-line code that prints msg (defined at msgLabel) and exits like
+// emitRuntimeAbort
+func (fc *C67Compiler) emitColdAbort(cond JumpCondition, msgLabel, msg string) {
+	jump := fc.forwardJump(cond)
+	for i := range fc.coldPaths {
+		if fc.coldPaths[i].msgLabel == msgLabel {
+			fc.coldPaths[i].jumps = append(fc.coldPaths[i].jumps, jump)
+			return
+		}
+	}
+	fc.coldPaths = append(fc.coldPaths, coldPath{msgLabel: msgLabel, msg: msg, jumps: []int{jump}})
+}
+
+// emitColdPaths emits the code of the error exits of emitColdAbort, and lands
+// the jumps to them. Called once the program code is done.
+func (fc *C67Compiler) emitColdPaths() {
+	if len(fc.coldPaths) > 0 {
+		fc.eb.MarkFunction("c67.cold")
+	}
+	for _, path := range fc.coldPaths {
+		for _, jump := range path.jumps {
+			fc.landForwardJump(jump)
+		}
+		fc.emitRuntimeAbort(path.msgLabel, path.msg)
+	}
+	fc.coldPaths = nil
+}
+
+// alignLoopHeader pads the code with NOPs up to the next multiple of
+// loopHeaderAlignment. The padding runs once, before the loop is entered.
+func (fc *C67Compiler) alignLoopHeader() {
+	padding := (loopHeaderAlignment - fc.eb.text.Len()%loopHeaderAlignment) % loopHeaderAlignment
+	for padding > 0 {
+		n := min(padding, len(x86Nops)-1)
+		fc.out.Emit(x86Nops[n])
+		padding -= n
+	}
+}
+
+// x86Nops are the recommended single-instruction NOPs, by length
+var x86Nops = [][]byte{
+	nil,
+	{0x90},
+	{0x66, 0x90},
+	{0x0f, 0x1f, 0x00},
+	{0x0f, 0x1f, 0x40, 0x00},
+	{0x0f, 0x1f, 0x44, 0x00, 0x00},
+	{0x66, 0x0f, 0x1f, 0x44, 0x00, 0x00},
+	{0x0f, 0x1f, 0x80, 0x00, 0x00, 0x00, 0x00},
+	{0x0f, 0x1f, 0x84, 0x00, 0x00, 0x00, 0x00, 0x00},
+	{0x66, 0x0f, 0x1f, 0x84, 0x00, 0x00, 0x00, 0x00, 0x00},
+}
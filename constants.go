@@ -0,0 +1,78 @@
+// Completion: 100% - Built-in numeric constants
+package main
+
+import (
+	"math"
+	"reflect"
+)
+
+// constants.go - pi, e, tau, epsilon, maxnum and minnum
+//
+// The built-in constants are exact float64 values, so no file has to define
+// its own approximation of pi:
+//
+//	area = r -> pi * r * r
+//
+// They are not keywords. A name that the file binds anywhere, as a variable,
+// a function, a parameter or a loop variable, means what the file binds, so
+// e stays free for a parameter. Otherwise the parser replaces the name with
+// the number, after macro expansion and before constant folding, so 2 * pi
+// folds to tau.
+
+// builtinConstants are the values of the built-in constants
+var builtinConstants = map[string]float64{
+	"pi":      math.Pi,
+	"e":       math.E,
+	"tau":     2 * math.Pi,
+	"epsilon": 0x1p-52,          // the gap between 1 and the next number
+	"maxnum":  math.MaxFloat64,  // the largest finite number
+	"minnum":  -math.MaxFloat64, // the lowest finite number
+}
+
+// resolveBuiltinConstants replaces the built-in constants that program uses
+// but does not bind with their values
+func resolveBuiltinConstants(program *Program) {
+	bound := make(map[string]bool)
+	visitAST(reflect.ValueOf(program.Statements), func(node any) {
+		for _, name := range boundNames(node) {
+			bound[name] = true
+		}
+	})
+	replaceBuiltinConstants(reflect.ValueOf(program.Statements), bound)
+}
+
+// replaceBuiltinConstants replaces the unbound built-in constants below v,
+// in place
+func replaceBuiltinConstants(v reflect.Value, bound map[string]bool) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return
+		}
+		// The field name in .name is not a variable
+		if b, ok := v.Interface().(*BinaryExpr); ok && b.Operator == "." {
+			replaceBuiltinConstants(reflect.ValueOf(&b.Left).Elem(), bound)
+			return
+		}
+		replaceBuiltinConstants(v.Elem(), bound)
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		if ident, ok := v.Interface().(*IdentExpr); ok && v.Type() == expressionType && v.CanSet() {
+			if value, ok := builtinConstants[ident.Name]; ok && !bound[ident.Name] {
+				v.Set(reflect.ValueOf(&NumberExpr{Value: value}))
+			}
+			return
+		}
+		replaceBuiltinConstants(v.Elem(), bound)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			replaceBuiltinConstants(v.Field(i), bound)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			replaceBuiltinConstants(v.Index(i), bound)
+		}
+	}
+}
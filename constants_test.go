@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestBuiltinConstants tests pi, e, tau, epsilon, maxnum and minnum
+func TestBuiltinConstants(t *testing.T) {
+	source := `area = r -> pi * r * r
+println(area(2))
+println(e)
+println((tau == 2 * pi) + (1 + epsilon > 1) + (1 + epsilon / 2 == 1))
+println((maxnum * 2 == inf) + (minnum == -maxnum) + (minnum < -maxnum / 2))
+`
+	result := compileAndRun(t, source)
+	if !strings.Contains(result, "12.566370614359172\n2.718281828459045\n3\n3\n") {
+		t.Errorf("Expected the constants, got: %s", result)
+	}
+}
+
+// TestBuiltinConstantsShadowed tests that a file can bind the names itself
+func TestBuiltinConstantsShadowed(t *testing.T) {
+	source := `pi = 3
+f = e -> e + 1
+m := {tau: 7}
+println(pi)
+println(f(1))
+println(m.tau)
+`
+	result := compileAndRun(t, source)
+	if !strings.Contains(result, "3\n2\n7\n") {
+		t.Errorf("Expected the file's own bindings, got: %s", result)
+	}
+}
@@ -0,0 +1,137 @@
+// Completion: 100% - Constants propagated into lambda bodies
+package main
+
+import "reflect"
+
+// constprop.go - Constant propagation across lambda boundaries
+//
+//	SCALE = 4
+//	scale = x -> x * SCALE      # x -> x * 4
+//
+// Constants that are defined with = before a lambda are replaced by their
+// values in its body, as they are in the statements after them, unless a
+// parameter of the lambda, or a name it defines, has the same name. This
+// runs before the inliner picks its candidates, so a body that is small
+// once the constants are in is inlined as such.
+//
+// A lambda that is defined with = in a block, and that is only ever called
+// there, always with the same number for a parameter, also gets that
+// number in place of the parameter:
+//
+//	main = {
+//	    area = (w, h) -> w * h
+//	    println(area(3, 2))
+//	    println(area(5, 2))     # h is 2 in the body of area
+//	}
+//
+// Module-level functions are not specialized this way, since other files,
+// and C through --emit-header, can call them with other arguments. Neither
+// are lambdas whose name is used other than to call them, since they can
+// then be called from anywhere.
+
+// lambdaConstMap returns the constants of constMap that are visible in the
+// body of lambda, which are all but the ones its parameters shadow
+func lambdaConstMap(lambda *LambdaExpr, constMap map[string]*NumberExpr) map[string]*NumberExpr {
+	bodyConstMap := make(map[string]*NumberExpr, len(constMap))
+	for k, v := range constMap {
+		bodyConstMap[k] = v
+	}
+	for _, param := range lambda.Params {
+		delete(bodyConstMap, param)
+	}
+	delete(bodyConstMap, lambda.VariadicParam)
+	return bodyConstMap
+}
+
+// forgetAssignedNames removes the names that stmt assigns anywhere in it
+// from constMap, for statements that propagateConstants does not look into
+func forgetAssignedNames(stmt Statement, constMap map[string]*NumberExpr) {
+	visitAST(reflect.ValueOf(stmt), func(node any) {
+		switch n := node.(type) {
+		case *AssignStmt:
+			delete(constMap, n.Name)
+		case *MultipleAssignStmt:
+			for _, name := range n.Names {
+				delete(constMap, name)
+			}
+		}
+	})
+}
+
+// specializeLocalLambdas replaces the parameters of the lambdas defined in
+// statements, a block, with the number they are always called with
+func specializeLocalLambdas(statements []Statement) {
+	lambdas := make(map[string]*LambdaExpr)
+	definitions := make(map[string]int)
+	visitAST(reflect.ValueOf(statements), func(node any) {
+		if assign, ok := node.(*AssignStmt); ok {
+			definitions[assign.Name]++
+		}
+	})
+	for _, stmt := range statements {
+		assign, ok := stmt.(*AssignStmt)
+		if !ok || assign.Mutable || assign.IsUpdate || assign.IsReuseMutable || definitions[assign.Name] != 1 {
+			continue
+		}
+		if lambda, ok := assign.Value.(*LambdaExpr); ok && lambda.VariadicParam == "" && len(lambda.Params) > 0 {
+			lambdas[assign.Name] = lambda
+		}
+	}
+	if len(lambdas) == 0 {
+		return
+	}
+
+	// args[name][i] is the number that every call passes for parameter i,
+	// or nil if the calls pass anything else
+	args := make(map[string][]*NumberExpr)
+	called := make(map[string]bool)
+	visitAST(reflect.ValueOf(statements), func(node any) {
+		switch n := node.(type) {
+		case *IdentExpr:
+			delete(lambdas, n.Name) // used as a value, so called from who knows where
+		case *CallExpr:
+			lambda, ok := lambdas[n.Function]
+			if !ok {
+				return
+			}
+			if len(n.Args) != len(lambda.Params) {
+				delete(lambdas, n.Function)
+				return
+			}
+			if !called[n.Function] {
+				called[n.Function] = true
+				args[n.Function] = make([]*NumberExpr, len(n.Args))
+				for i, arg := range n.Args {
+					args[n.Function][i], _ = arg.(*NumberExpr)
+				}
+				return
+			}
+			for i, arg := range n.Args {
+				if num, ok := arg.(*NumberExpr); !ok || args[n.Function][i] == nil || num.Value != args[n.Function][i].Value {
+					args[n.Function][i] = nil
+				}
+			}
+		}
+	})
+
+	for name, lambda := range lambdas {
+		if !called[name] {
+			continue
+		}
+		paramConstMap := make(map[string]*NumberExpr)
+		for i, param := range lambda.Params {
+			if num := args[name][i]; num != nil {
+				paramConstMap[param] = &NumberExpr{Value: num.Value}
+			}
+		}
+		// A parameter that the body assigns to is not the same number all through it
+		visitAST(reflect.ValueOf(lambda.Body), func(node any) {
+			if assign, ok := node.(*AssignStmt); ok {
+				delete(paramConstMap, assign.Name)
+			}
+		})
+		if len(paramConstMap) > 0 {
+			lambda.Body = strengthReduceExpr(foldConstantExpr(propagateConstantsExpr(lambda.Body, paramConstMap)))
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const constPropSource = `scale = 4
+times = x -> x * scale
+bump = scale -> scale + 1
+main = {
+    offset = 100
+    add = (x, n) -> x + n + offset
+    twice = x -> x * 2
+    f = twice
+    println(times(10))
+    println(bump(10))
+    println(add(1, 5))
+    println(add(2, 5))
+    println(twice(3))
+    println(f(4))
+}
+`
+
+// lambdaBodies returns the bodies of the lambdas assigned to names in
+// program, in any block, as strings
+func lambdaBodies(program *Program) map[string]string {
+	bodies := make(map[string]string)
+	visitAST(reflect.ValueOf(program.Statements), func(node any) {
+		if assign, ok := node.(*AssignStmt); ok {
+			if lambda, ok := assign.Value.(*LambdaExpr); ok {
+				bodies[assign.Name] = lambda.Body.String()
+			}
+		}
+	})
+	return bodies
+}
+
+// TestConstantPropagationIntoLambdas tests that constants and constant
+// arguments are propagated into lambda bodies, but not past parameters
+// that shadow them or into lambdas that are used as values
+func TestConstantPropagationIntoLambdas(t *testing.T) {
+	bodies := lambdaBodies(NewParser(constPropSource).ParseProgram())
+	want := map[string]string{
+		"times": "(x * 4)",
+		"bump":  "(scale + 1)",
+		"add":   "((x + 5) + 100)",
+		"twice": "(x * 2)",
+	}
+	for name, body := range want {
+		if bodies[name] != body {
+			t.Errorf("Expected the body of %s to be %s, got %s", name, body, bodies[name])
+		}
+	}
+
+	result := compileAndRun(t, constPropSource)
+	if !strings.Contains(result, "40\n11\n106\n107\n6\n8\n") {
+		t.Errorf("Expected output to contain '40\\n11\\n106\\n107\\n6\\n8\\n', got: %s", result)
+	}
+}
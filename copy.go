@@ -0,0 +1,195 @@
+// Completion: 100% - copy() and deepcopy() for lists and maps
+package main
+
+// copy.go - Copying collections
+//
+// Assigning a list or map shares it: after ys = xs both names see the same
+// entries, and xs[0] <- 1 is visible through ys. copy(xs) returns a new
+// collection with the same count, keys and values. deepcopy(xs) copies the
+// values that are themselves collections as well, all the way down.
+//
+// Values are plain float64 bits, so a value counts as a collection when its
+// bits look like a user-space pointer: at least 64 KiB and below 2^47.
+// Doubles with those bit patterns are subnormals smaller than 1e-303, which
+// programs do not hold as numbers. Anything else (numbers, NaN-encoded
+// errors) is returned or kept as it is.
+//
+// The copies are allocated from the arena that is current at the call site.
+// Copying a sharedmap gives an ordinary map with the same entries.
+
+// compileCopyCall compiles copy(x) and deepcopy(x)
+func (fc *C67Compiler) compileCopyCall(call *CallExpr) {
+	if len(call.Args) != 1 {
+		compilerError("%s() requires exactly 1 argument", call.Function)
+	}
+	fc.compileExpression(call.Args[0])
+	fc.out.MovqXmmToReg("rdi", "xmm0")
+
+	// rsi = struct of the current arena, as in callArenaAlloc
+	fc.loadArenaPointer("rsi")
+
+	helper := "_c67_copy"
+	if call.Function == "deepcopy" {
+		helper = "_c67_deepcopy"
+	}
+	fc.usesCopy = true
+	fc.out.SubImmFromReg("rsp", StackSlotSize)
+	fc.out.CallSymbol(helper)
+	fc.out.AddImmToReg("rsp", StackSlotSize)
+	fc.out.MovqRegToXmm("xmm0", "rax")
+}
+
+// emitJumpIfNotCollection jumps when the 64-bit value in reg does not look
+// like a collection pointer. Returns the forward jumps to land. Clobbers rcx.
+func (fc *C67Compiler) emitJumpIfNotCollection(reg string) []int {
+	var jumps []int
+	fc.out.MovRegToReg("rcx", reg)
+	fc.out.ShrRegByImm("rcx", 47)
+	jumps = append(jumps, fc.forwardJump(JumpNotEqual))
+	fc.out.CmpRegToImm(reg, 0x10000)
+	jumps = append(jumps, fc.forwardJump(JumpBelow))
+	return jumps
+}
+
+// inlineCopyBytes is the size up to which emitEntryCopy copies at the call
+// site instead of calling memcpy: eight list entries
+const inlineCopyBytes = 8 * 16
+
+// emitEntryCopy copies rdx bytes, a multiple of 16, from rsi to rdi, like a
+// call to memcpy. Up to inlineCopyBytes are copied by a loop of 16-byte
+// moves at the call site; only longer copies call memcpy. Clobbers what
+// memcpy does.
+func (fc *C67Compiler) emitEntryCopy() {
+	fc.out.CmpRegToImm("rdx", inlineCopyBytes)
+	large := fc.forwardJump(JumpAbove)
+	loop := fc.eb.text.Len()
+	fc.out.TestRegReg("rdx", "rdx")
+	done := fc.forwardJump(JumpEqual)
+	fc.out.MovupdMemToXmm("xmm0", "rsi", 0)
+	fc.out.MovupdXmmToMem("xmm0", "rdi", 0)
+	fc.out.AddImmToReg("rsi", 16)
+	fc.out.AddImmToReg("rdi", 16)
+	fc.out.SubImmFromReg("rdx", 16)
+	fc.out.JumpUnconditional(int32(loop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(large)
+	fc.trackFunctionCall("memcpy")
+	fc.eb.GenerateCallInstruction("memcpy")
+	fc.landForwardJump(done)
+}
+
+// generateCopyHelpers emits the runtime helpers behind copy() and deepcopy()
+func (fc *C67Compiler) generateCopyHelpers() {
+	fc.generateShallowCopy()
+	fc.generateDeepCopy()
+}
+
+// generateShallowCopy emits _c67_copy(rdi=value, rsi=arena) -> rax
+func (fc *C67Compiler) generateShallowCopy() {
+	fc.eb.MarkFunction("_c67_copy")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+
+	fc.out.MovRegToReg("rax", "rdi")
+	notCollection := fc.emitJumpIfNotCollection("rdi")
+
+	fc.out.MovRegToReg("rbx", "rdi") // rbx = source
+	fc.out.MovMemToXmm("xmm0", "rbx", 0)
+	fc.out.Cvttsd2si("r12", "xmm0") // r12 = count
+
+	// Allocate count (8) + count * 16 bytes
+	fc.out.MovRegToReg("rdi", "rsi")
+	fc.out.MovRegToReg("rsi", "r12")
+	fc.out.ShlImmReg("rsi", 4)
+	fc.out.AddImmToReg("rsi", 8)
+	fc.trackFunctionCall("c67_arena_alloc")
+	fc.out.CallSymbol("c67_arena_alloc")
+
+	// Copy count, keys and values: 1 + 2*count words
+	fc.out.MovRegToReg("r13", "r12")
+	fc.out.ShlImmReg("r13", 1)
+	fc.out.AddImmToReg("r13", 1)
+	fc.out.XorRegWithReg("r14", "r14")
+	loopStart := fc.eb.text.Len()
+	fc.out.CmpRegToReg("r14", "r13")
+	done := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovRegToReg("rcx", "r14")
+	fc.out.ShlImmReg("rcx", 3)
+	fc.out.MovRegToReg("rdx", "rbx")
+	fc.out.AddRegToReg("rdx", "rcx")
+	fc.out.MovMemToReg("rdx", "rdx", 0)
+	fc.out.AddRegToReg("rcx", "rax")
+	fc.out.MovRegToMem("rdx", "rcx", 0)
+	fc.out.IncReg("r14")
+	fc.out.JumpUnconditional(int32(loopStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(done)
+
+	for _, pos := range notCollection {
+		fc.landForwardJump(pos)
+	}
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generateDeepCopy emits _c67_deepcopy(rdi=value, rsi=arena) -> rax, which
+// copies the collection and then replaces every collection value in the copy
+// with a deep copy of it
+func (fc *C67Compiler) generateDeepCopy() {
+	fc.eb.MarkFunction("_c67_deepcopy")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+
+	fc.out.MovRegToReg("r12", "rsi") // r12 = arena
+	fc.out.CallSymbol("_c67_copy")
+	fc.out.MovRegToReg("rbx", "rax") // rbx = copy
+	notCollection := fc.emitJumpIfNotCollection("rbx")
+
+	fc.out.MovMemToXmm("xmm0", "rbx", 0)
+	fc.out.Cvttsd2si("r13", "xmm0")    // r13 = count
+	fc.out.XorRegWithReg("r14", "r14") // r14 = index
+	loopStart := fc.eb.text.Len()
+	fc.out.CmpRegToReg("r14", "r13")
+	done := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovRegToReg("rax", "r14")
+	fc.out.ShlImmReg("rax", 4)
+	fc.out.AddRegToReg("rax", "rbx")
+	fc.out.MovMemToReg("rdi", "rax", 16) // value i
+	skip := fc.emitJumpIfNotCollection("rdi")
+	fc.out.MovRegToReg("rsi", "r12")
+	fc.out.CallSymbol("_c67_deepcopy")
+	fc.out.MovRegToReg("rcx", "r14")
+	fc.out.ShlImmReg("rcx", 4)
+	fc.out.AddRegToReg("rcx", "rbx")
+	fc.out.MovRegToMem("rax", "rcx", 16)
+	for _, pos := range skip {
+		fc.landForwardJump(pos)
+	}
+	fc.out.IncReg("r14")
+	fc.out.JumpUnconditional(int32(loopStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(done)
+
+	for _, pos := range notCollection {
+		fc.landForwardJump(pos)
+	}
+	fc.out.MovRegToReg("rax", "rbx")
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
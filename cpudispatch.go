@@ -0,0 +1,223 @@
+// Completion: 100% - Module complete
+package main
+
+// cpudispatch.go - Runtime CPU dispatch for hot x86-64 runtime helpers
+//
+// Some runtime helpers are generated in two variants: a baseline version that
+// only needs SSE2 and an optimized version for newer CPUs. Callers do not call
+// either variant directly. Instead they call through a slot in a writable
+// dispatch table:
+//
+//	call qword [rip + _c67_dispatch_string_eq]
+//
+// emitCPUDispatchInit fills every slot once, in _c67_runtime_init right after
+// CPUID detection and before any @@ threads exist, so the table needs no
+// locking.
+//
+// Map lookups are inlined at each m[key] site and select their AVX-512, AVX2
+// or SSE2 tier by testing the cpu_has_* flags directly. ARM64 needs no table:
+// NEON is part of the ARMv8 baseline.
+
+// cpuDispatchEntry describes one dispatched runtime helper
+type cpuDispatchEntry struct {
+	slot      string // writable 8-byte symbol holding the selected function pointer
+	baseline  string // label of the SSE2 variant
+	optimized string // label of the optimized variant
+	feature   string // cpu_has_* flag that selects the optimized variant
+}
+
+var cpuDispatchTable = []cpuDispatchEntry{
+	{"_c67_dispatch_string_eq", "_c67_string_eq", "_c67_string_eq_avx2", "cpu_has_avx2"},
+	{"_c67_dispatch_copy_qwords", "_c67_copy_qwords", "_c67_copy_qwords_avx2", "cpu_has_avx2"},
+}
+
+// emitCPUDispatchInit stores the best variant of each helper in its slot.
+// Must run after the cpu_has_* flags have been set. Clobbers rax and rdx.
+func (fc *C67Compiler) emitCPUDispatchInit() {
+	for _, entry := range cpuDispatchTable {
+		fc.out.LeaSymbolToReg("rax", entry.baseline)
+		fc.out.LeaSymbolToReg("rdx", entry.feature)
+		fc.out.Emit([]byte{0x80, 0x3a, 0x00}) // cmp byte [rdx], 0
+		skipJump := fc.eb.text.Len()
+		fc.out.JumpConditional(JumpEqual, 0)
+		skipEnd := fc.eb.text.Len()
+		fc.out.LeaSymbolToReg("rax", entry.optimized)
+		fc.patchJumpImmediate(skipJump+2, int32(fc.eb.text.Len()-skipEnd))
+
+		fc.out.LeaSymbolToReg("rdx", entry.slot)
+		fc.out.MovRegToMem("rax", "rdx", 0)
+	}
+}
+
+// callDispatched emits call qword [rip + slot]. The relocation to the slot
+// is what tells the compiler that the program needs _c67_runtime_init.
+func (fc *C67Compiler) callDispatched(slot string) {
+	fc.out.Emit([]byte{0xff, 0x15}) // call qword [rip + disp32]
+	fc.eb.pcRelocations = append(fc.eb.pcRelocations, PCRelocation{
+		offset:     uint64(fc.eb.text.Len()),
+		symbolName: slot,
+	})
+	fc.out.Emit([]byte{0x00, 0x00, 0x00, 0x00})
+}
+
+// generateCPUDispatchHelpers generates the variants that are only reached
+// through the dispatch table. _c67_string_eq is the baseline string compare
+// and is generated together with the other string helpers.
+func (fc *C67Compiler) generateCPUDispatchHelpers() {
+	fc.generateCopyQwords()
+	fc.generateStringEqAVX2()
+}
+
+// generateCopyQwords generates _c67_copy_qwords and _c67_copy_qwords_avx2
+// Arguments: rdi = destination, rsi = source, rdx = number of 8-byte words
+// Returns: rdi and rsi advanced past the copied words
+// Clobbers only rdx and xmm0/ymm0, so callers can keep state in r8-r11.
+func (fc *C67Compiler) generateCopyQwords() {
+	// AVX2: 4 words per iteration, then fall into the SSE2 tail
+	fc.eb.MarkFunction("_c67_copy_qwords_avx2")
+	avxLoop := fc.eb.text.Len()
+	fc.out.CmpRegToImm("rdx", 4)
+	avxDoneJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpLess, 0)
+	avxDoneEnd := fc.eb.text.Len()
+	fc.out.Emit([]byte{0xc5, 0xfe, 0x6f, 0x06}) // vmovdqu ymm0, [rsi]
+	fc.out.Emit([]byte{0xc5, 0xfe, 0x7f, 0x07}) // vmovdqu [rdi], ymm0
+	fc.out.AddImmToReg("rsi", 32)
+	fc.out.AddImmToReg("rdi", 32)
+	fc.out.SubImmFromReg("rdx", 4)
+	fc.out.JumpUnconditional(int32(avxLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.patchJumpImmediate(avxDoneJump+2, int32(fc.eb.text.Len()-avxDoneEnd))
+	fc.out.Emit([]byte{0xc5, 0xf8, 0x77}) // vzeroupper
+
+	// SSE2: 2 words per iteration
+	fc.eb.MarkFunction("_c67_copy_qwords")
+	sseLoop := fc.eb.text.Len()
+	fc.out.CmpRegToImm("rdx", 2)
+	sseDoneJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpLess, 0)
+	sseDoneEnd := fc.eb.text.Len()
+	fc.out.Emit([]byte{0x0f, 0x10, 0x06}) // movups xmm0, [rsi]
+	fc.out.Emit([]byte{0x0f, 0x11, 0x07}) // movups [rdi], xmm0
+	fc.out.AddImmToReg("rsi", 16)
+	fc.out.AddImmToReg("rdi", 16)
+	fc.out.SubImmFromReg("rdx", 2)
+	fc.out.JumpUnconditional(int32(sseLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.patchJumpImmediate(sseDoneJump+2, int32(fc.eb.text.Len()-sseDoneEnd))
+
+	// Odd word left over
+	fc.out.TestRegReg("rdx", "rdx")
+	retJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpEqual, 0)
+	retEnd := fc.eb.text.Len()
+	fc.out.MovMemToXmm("xmm0", "rsi", 0)
+	fc.out.MovXmmToMem("xmm0", "rdi", 0)
+	fc.out.AddImmToReg("rsi", 8)
+	fc.out.AddImmToReg("rdi", 8)
+	fc.patchJumpImmediate(retJump+2, int32(fc.eb.text.Len()-retEnd))
+	fc.out.Ret()
+}
+
+// generateStringEqAVX2 generates _c67_string_eq_avx2(left_ptr, right_ptr)
+// Same contract as _c67_string_eq: returns xmm0 = 1.0 if equal, 0.0 if not.
+// Compares 4 characters per iteration by checking the value lanes of two
+// 32-byte blocks; keys are positional and need no comparison.
+func (fc *C67Compiler) generateStringEqAVX2() {
+	fc.eb.MarkFunction("_c67_string_eq_avx2")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+
+	// Null pointers are empty strings
+	fc.out.MovRegToReg("rax", "rdi")
+	fc.out.OrRegToReg("rax", "rsi")
+	fc.out.TestRegReg("rax", "rax")
+	eqNullJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpEqual, 0)
+	fc.out.TestRegReg("rdi", "rdi")
+	neqJump1 := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpEqual, 0)
+	fc.out.TestRegReg("rsi", "rsi")
+	neqJump2 := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpEqual, 0)
+
+	// Counts must match
+	fc.out.MovMemToXmm("xmm0", "rdi", 0)
+	fc.out.MovMemToXmm("xmm1", "rsi", 0)
+	fc.out.Cvttsd2si("rbx", "xmm0")
+	fc.out.Cvttsd2si("r12", "xmm1")
+	fc.out.CmpRegToReg("rbx", "r12")
+	neqJump3 := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpNotEqual, 0)
+
+	// r8, r9 = first entry of each string, rbx = characters left
+	fc.out.MovRegToReg("r8", "rdi")
+	fc.out.AddImmToReg("r8", 8)
+	fc.out.MovRegToReg("r9", "rsi")
+	fc.out.AddImmToReg("r9", 8)
+
+	// 4 characters per iteration: lanes 1 and 3 of each block hold values
+	blockLoop := fc.eb.text.Len()
+	fc.out.CmpRegToImm("rbx", 4)
+	tailJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpLess, 0)
+	tailEnd := fc.eb.text.Len()
+	fc.out.Emit([]byte{0xc4, 0xc1, 0x7e, 0x6f, 0x00})       // vmovdqu ymm0, [r8]
+	fc.out.Emit([]byte{0xc4, 0xc2, 0x7d, 0x29, 0x01})       // vpcmpeqq ymm0, ymm0, [r9]
+	fc.out.Emit([]byte{0xc4, 0xc1, 0x7e, 0x6f, 0x48, 0x20}) // vmovdqu ymm1, [r8+32]
+	fc.out.Emit([]byte{0xc4, 0xc2, 0x75, 0x29, 0x49, 0x20}) // vpcmpeqq ymm1, ymm1, [r9+32]
+	fc.out.Emit([]byte{0xc5, 0xfd, 0x50, 0xc0})             // vmovmskpd eax, ymm0
+	fc.out.Emit([]byte{0xc5, 0x7d, 0x50, 0xe1})             // vmovmskpd r12d, ymm1
+	fc.out.Emit([]byte{0x41, 0xc1, 0xe4, 0x04})             // shl r12d, 4
+	fc.out.Emit([]byte{0x44, 0x09, 0xe0})                   // or eax, r12d
+	fc.out.Emit([]byte{0x25, 0xaa, 0x00, 0x00, 0x00})       // and eax, 0xaa
+	fc.out.Emit([]byte{0x3d, 0xaa, 0x00, 0x00, 0x00})       // cmp eax, 0xaa
+	neqJump4 := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpNotEqual, 0)
+	fc.out.AddImmToReg("r8", 64)
+	fc.out.AddImmToReg("r9", 64)
+	fc.out.SubImmFromReg("rbx", 4)
+	fc.out.JumpUnconditional(int32(blockLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	// Remaining 0-3 characters
+	tailLoop := fc.eb.text.Len()
+	fc.patchJumpImmediate(tailJump+2, int32(tailLoop-tailEnd))
+	fc.out.TestRegReg("rbx", "rbx")
+	eqJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpEqual, 0)
+	fc.out.MovMemToXmm("xmm2", "r8", 8)
+	fc.out.MovMemToXmm("xmm3", "r9", 8)
+	fc.out.Ucomisd("xmm2", "xmm3")
+	neqJump5 := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpNotEqual, 0)
+	fc.out.AddImmToReg("r8", 16)
+	fc.out.AddImmToReg("r9", 16)
+	fc.out.SubImmFromReg("rbx", 1)
+	fc.out.JumpUnconditional(int32(tailLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	// Equal - return 1.0
+	eqPos := fc.eb.text.Len()
+	fc.patchJumpImmediate(eqNullJump+2, int32(eqPos-(eqNullJump+ConditionalJumpSize)))
+	fc.patchJumpImmediate(eqJump+2, int32(eqPos-(eqJump+ConditionalJumpSize)))
+	fc.out.MovImmToReg("rax", "1")
+	fc.out.Cvtsi2sd("xmm0", "rax")
+	doneJump := fc.eb.text.Len()
+	fc.out.JumpUnconditional(0)
+
+	// Not equal - return 0.0
+	neqPos := fc.eb.text.Len()
+	for _, jump := range []int{neqJump1, neqJump2, neqJump3, neqJump4, neqJump5} {
+		fc.patchJumpImmediate(jump+2, int32(neqPos-(jump+ConditionalJumpSize)))
+	}
+	fc.out.XorRegWithReg("rax", "rax")
+	fc.out.Cvtsi2sd("xmm0", "rax")
+
+	donePos := fc.eb.text.Len()
+	fc.patchJumpImmediate(doneJump+1, int32(donePos-(doneJump+UnconditionalJumpSize)))
+	fc.out.Emit([]byte{0xc5, 0xf8, 0x77}) // vzeroupper
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
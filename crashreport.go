@@ -0,0 +1,335 @@
+// Completion: 100% - Crash reports for SIGSEGV, SIGBUS and SIGILL (Linux x86-64)
+package main
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+// crashreport.go - What a crashed program says before it dies
+//
+// At startup, programs for Linux x86-64 install a handler for SIGSEGV,
+// SIGBUS and SIGILL, on a stack of its own so that a stack overflow can
+// be reported too. Instead of a bare "Segmentation fault", stderr gets:
+//
+//	Error: segmentation fault (SIGSEGV) at address 0x8
+//	  in crash+0x1f
+//	  rax 0x0000000000000000  rbx 0x0000000000000000  rcx ...
+//	  ...
+//	  rip 0x00000000004031a3
+//
+// The function is the nearest one before the faulting instruction, from a
+// table of the functions in .text by name, which goes at the end of .text
+// since the addresses are only known there. A fault in a C library is
+// outside of it. The handler then returns with the default action back in
+// place, so the instruction faults again and the process dies of the
+// signal, with a core dump if those are on, as it would have without it.
+// No ptrace or debugger is involved: the kernel passes the registers to
+// the handler.
+
+// crashSignals are the signals with a crash report
+var crashSignals = []int{11, 7, 4} // SIGSEGV, SIGBUS, SIGILL
+
+// crashAltStackSize is the size of the stack the handler runs on
+const crashAltStackSize = 64 * 1024
+
+// crashSigactionFlags are SA_SIGINFO | SA_ONSTACK | SA_RESETHAND | SA_RESTORER
+const crashSigactionFlags = 0x4 | 0x08000000 | 0x80000000 | 0x04000000
+
+// crashText holds every piece of text in a report, which the handler
+// copies from by offset
+const crashText = "Error: segmentation fault (SIGSEGV)bus error (SIGBUS)illegal instruction (SIGILL) at address \n  in code outside the program\n" +
+	"rax rbx rcx rdx rsi rdi rbp rsp r8  r9  r10 r11 r12 r13 r14 r15 rip "
+
+// crashRegisters are the registers in a report, in the order of the report,
+// by their index in the gregs of the ucontext the kernel passes
+var crashRegisters = []struct {
+	name  string
+	index int
+}{
+	{"rax ", 13}, {"rbx ", 11}, {"rcx ", 14}, {"rdx ", 12},
+	{"rsi ", 9}, {"rdi ", 8}, {"rbp ", 10}, {"rsp ", 15},
+	{"r8  ", 0}, {"r9  ", 1}, {"r10 ", 2}, {"r11 ", 3},
+	{"r12 ", 4}, {"r13 ", 5}, {"r14 ", 6}, {"r15 ", 7},
+	{"rip ", 16},
+}
+
+// Offsets in siginfo_t and ucontext_t
+const (
+	siginfoAddr   = 16
+	ucontextGregs = 40
+	gregRIP       = 16
+)
+
+// crashReportEnabled reports whether the program gets a crash handler
+func (fc *C67Compiler) crashReportEnabled() bool {
+	return fc.eb.target.OS() == OSLinux && fc.eb.target.Arch() == ArchX86_64
+}
+
+// emitCrashReportInit installs the crash handler at program entry
+func (fc *C67Compiler) emitCrashReportInit() {
+	if !fc.crashReportEnabled() {
+		return
+	}
+	fc.usesCrashReport = true
+	fc.eb.Define("_c67_crash_text", crashText)
+	fc.eb.Define("_c67_hex_digits", "0123456789abcdef")
+	fc.out.CallSymbol("_c67_crash_init")
+}
+
+// generateCrashReportHelpers emits _c67_crash_init and the handler
+func (fc *C67Compiler) generateCrashReportHelpers() {
+	fc.generateCrashInit()
+	fc.generateCrashHex()
+	fc.generateCrashHandler()
+
+	// The handler returns here, and rt_sigreturn puts back the registers
+	fc.eb.MarkFunction("_c67_crash_restorer")
+	fc.out.MovImmToReg("rax", "15") // rt_sigreturn
+	fc.out.Syscall()
+}
+
+// generateCrashInit emits _c67_crash_init(), which maps the signal stack
+// and sets the handler for crashSignals. Uses system calls only.
+func (fc *C67Compiler) generateCrashInit() {
+	fc.eb.MarkFunction("_c67_crash_init")
+
+	// mmap(NULL, size, PROT_READ|PROT_WRITE, MAP_PRIVATE|MAP_ANONYMOUS, -1, 0)
+	fc.out.XorRegWithReg("rdi", "rdi")
+	fc.out.MovImmToReg("rsi", strconv.Itoa(crashAltStackSize))
+	fc.out.MovImmToReg("rdx", "3")
+	fc.out.MovImmToReg("r10", "34")
+	fc.out.MovImmToReg("r8", "-1")
+	fc.out.XorRegWithReg("r9", "r9")
+	fc.out.MovImmToReg("rax", "9")
+	fc.out.Syscall()
+	fc.out.CmpRegToImm("rax", -4096)
+	noStack := fc.forwardJump(JumpAboveOrEqual)
+
+	// sigaltstack(&stack_t{sp, flags, size}, NULL)
+	fc.out.SubImmFromReg("rsp", 32)
+	fc.out.MovRegToMem("rax", "rsp", 0)
+	fc.out.MovImmToMem(0, "rsp", 8)
+	fc.out.MovImmToMem(crashAltStackSize, "rsp", 16)
+	fc.out.MovRegToReg("rdi", "rsp")
+	fc.out.XorRegWithReg("rsi", "rsi")
+	fc.out.MovImmToReg("rax", "131")
+	fc.out.Syscall()
+	fc.out.AddImmToReg("rsp", 32)
+	fc.landForwardJump(noStack)
+
+	// rt_sigaction(signal, &sigaction{handler, flags, restorer, mask}, NULL, 8)
+	fc.out.SubImmFromReg("rsp", 32)
+	fc.out.LeaSymbolToReg("rax", "_c67_crash_handler")
+	fc.out.MovRegToMem("rax", "rsp", 0)
+	fc.out.MovImmToReg("rax", strconv.Itoa(crashSigactionFlags))
+	fc.out.MovRegToMem("rax", "rsp", 8)
+	fc.out.LeaSymbolToReg("rax", "_c67_crash_restorer")
+	fc.out.MovRegToMem("rax", "rsp", 16)
+	fc.out.MovImmToMem(0, "rsp", 24)
+	for _, signal := range crashSignals {
+		fc.out.MovImmToReg("rdi", strconv.Itoa(signal))
+		fc.out.MovRegToReg("rsi", "rsp")
+		fc.out.XorRegWithReg("rdx", "rdx")
+		fc.out.MovImmToReg("r10", "8")
+		fc.out.MovImmToReg("rax", "13")
+		fc.out.Syscall()
+	}
+	fc.out.AddImmToReg("rsp", 32)
+	fc.out.Ret()
+}
+
+// generateCrashHex emits _c67_crash_hex(rax=value, rdi=cursor, rsi=digits),
+// which writes rax as 0x and at least rsi hex digits at rdi and advances
+// rdi past them. Expects r11 = _c67_hex_digits. Clobbers rax, rcx, rdx, r8.
+func (fc *C67Compiler) generateCrashHex() {
+	fc.eb.MarkFunction("_c67_crash_hex")
+
+	fc.out.MovImmToReg("rcx", strconv.Itoa('0'))
+	fc.out.MovU8RegToMem("rcx", "rdi", 0)
+	fc.out.MovImmToReg("rcx", strconv.Itoa('x'))
+	fc.out.MovU8RegToMem("rcx", "rdi", 1)
+	fc.out.AddImmToReg("rdi", 2)
+	fc.out.MovImmToReg("rcx", "16")  // digits left
+	fc.out.XorRegWithReg("r8", "r8") // 1 once a digit is written
+
+	loopStart := fc.eb.text.Len()
+	fc.out.MovRegToReg("rdx", "rax")
+	fc.out.ShrRegByImm("rdx", 60)
+	fc.out.ShlImmReg("rax", 4)
+	fc.out.TestRegReg("rdx", "rdx")
+	nonZero := fc.forwardJump(JumpNotEqual)
+	fc.out.TestRegReg("r8", "r8")
+	started := fc.forwardJump(JumpNotEqual)
+	fc.out.CmpRegToReg("rcx", "rsi")
+	leading := fc.forwardJump(JumpGreater)
+	fc.landForwardJump(nonZero)
+	fc.landForwardJump(started)
+	fc.out.MovImmToReg("r8", "1")
+	fc.out.AddRegToReg("rdx", "r11")
+	fc.out.MovU8MemToReg("rdx", "rdx", 0)
+	fc.out.MovU8RegToMem("rdx", "rdi", 0)
+	fc.out.IncReg("rdi")
+	fc.landForwardJump(leading)
+	fc.out.DecReg("rcx")
+	fc.out.JumpConditional(JumpNotEqual, int32(loopStart-(fc.eb.text.Len()+ConditionalJumpSize)))
+	fc.out.Ret()
+}
+
+// emitCrashCopy copies the piece s of crashText to the report at rdi
+func (fc *C67Compiler) emitCrashCopy(s string) {
+	offset := strings.Index(crashText, s)
+	if offset < 0 {
+		compilerError("crash report text %q is missing from crashText", s)
+	}
+	fc.out.LeaSymbolToReg("rsi", "_c67_crash_text")
+	if offset > 0 {
+		fc.out.AddImmToReg("rsi", int64(offset))
+	}
+	fc.out.MovImmToReg("rcx", strconv.Itoa(len(s)))
+	fc.out.Emit([]byte{0xf3, 0xa4}) // rep movsb
+}
+
+// generateCrashHandler emits _c67_crash_handler(rdi=signal, rsi=siginfo,
+// rdx=ucontext), which writes the report to stderr
+func (fc *C67Compiler) generateCrashHandler() {
+	fc.eb.MarkFunction("_c67_crash_handler")
+
+	// The registers go back from the ucontext, so all of them can be used
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.SubImmFromReg("rsp", 1024)
+	fc.out.AndRegWithImm("rsp", -16)
+	fc.out.MovRegToReg("r12", "rdi")
+	fc.out.MovRegToReg("r13", "rsi")
+	fc.out.MovRegToReg("r14", "rdx")
+	fc.out.LeaSymbolToReg("r11", "_c67_hex_digits")
+	fc.out.MovRegToReg("rdi", "rsp")
+
+	fc.emitCrashCopy("Error: ")
+	signalNames := map[int]string{7: "bus error (SIGBUS)", 4: "illegal instruction (SIGILL)"}
+	fc.out.CmpRegToImm("r12", 7)
+	bus := fc.forwardJump(JumpEqual)
+	fc.out.CmpRegToImm("r12", 4)
+	ill := fc.forwardJump(JumpEqual)
+	fc.emitCrashCopy("segmentation fault (SIGSEGV)")
+	named := []int{fc.forwardJumpAlways()}
+	for _, jump := range []struct{ at, signal int }{{bus, 7}, {ill, 4}} {
+		fc.landForwardJump(jump.at)
+		fc.emitCrashCopy(signalNames[jump.signal])
+		named = append(named, fc.forwardJumpAlways())
+	}
+	for _, jump := range named {
+		fc.landForwardJump(jump)
+	}
+	fc.emitCrashCopy(" at address ")
+	fc.out.MovMemToReg("rax", "r13", siginfoAddr)
+	fc.out.MovImmToReg("rsi", "1")
+	fc.out.CallSymbol("_c67_crash_hex")
+	fc.emitCrashCopy("\n")
+
+	// Find the last function that starts at or before rip
+	fc.out.MovMemToReg("r15", "r14", ucontextGregs+8*gregRIP)
+	fc.out.LeaSymbolToReg("r9", "_c67_crash_symbols")
+	fc.out.XorRegWithReg("rbx", "rbx")
+	fc.out.CmpRegToReg("r15", "r9")
+	outside := fc.forwardJump(JumpAboveOrEqual)
+	fc.out.MovMemToReg("rcx", "r9", 0)
+	fc.out.LeaMemToReg("r10", "r9", 8)
+	loopStart := fc.eb.text.Len()
+	fc.out.TestRegReg("rcx", "rcx")
+	end := fc.forwardJump(JumpEqual)
+	fc.out.MovRegToReg("rax", "r9")
+	fc.out.MovMemToReg("rdx", "r10", 0)
+	fc.out.SubRegFromReg("rax", "rdx")
+	fc.out.CmpRegToReg("rax", "r15")
+	past := fc.forwardJump(JumpAbove)
+	fc.out.MovRegToReg("rbx", "r10")
+	fc.out.AddImmToReg("r10", 24)
+	fc.out.DecReg("rcx")
+	fc.out.JumpUnconditional(int32(loopStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(end)
+	fc.landForwardJump(past)
+	fc.landForwardJump(outside)
+	fc.out.TestRegReg("rbx", "rbx")
+	unknown := fc.forwardJump(JumpEqual)
+
+	fc.emitCrashCopy("  in ")
+	fc.out.MovRegToReg("rsi", "r9")
+	fc.out.MovMemToReg("rax", "rbx", 8)
+	fc.out.AddRegToReg("rsi", "rax")
+	fc.out.MovMemToReg("rcx", "rbx", 16)
+	fc.out.Emit([]byte{0xf3, 0xa4}) // rep movsb
+	fc.out.MovImmToReg("rax", strconv.Itoa('+'))
+	fc.out.MovU8RegToMem("rax", "rdi", 0)
+	fc.out.IncReg("rdi")
+	fc.out.MovRegToReg("rdx", "r9")
+	fc.out.MovMemToReg("rax", "rbx", 0)
+	fc.out.SubRegFromReg("rdx", "rax")
+	fc.out.MovRegToReg("rax", "r15")
+	fc.out.SubRegFromReg("rax", "rdx")
+	fc.out.MovImmToReg("rsi", "1")
+	fc.out.CallSymbol("_c67_crash_hex")
+	fc.emitCrashCopy("\n")
+	known := fc.forwardJumpAlways()
+	fc.landForwardJump(unknown)
+	fc.emitCrashCopy("  in code outside the program\n")
+	fc.landForwardJump(known)
+
+	for i, reg := range crashRegisters {
+		if i%4 == 0 {
+			fc.emitCrashCopy("  ")
+		}
+		fc.emitCrashCopy(reg.name)
+		fc.out.MovMemToReg("rax", "r14", ucontextGregs+8*reg.index)
+		fc.out.MovImmToReg("rsi", "16")
+		fc.out.CallSymbol("_c67_crash_hex")
+		if i%4 == 3 || i == len(crashRegisters)-1 {
+			fc.emitCrashCopy("\n")
+		} else {
+			fc.emitCrashCopy("  ")
+		}
+	}
+
+	// write(2, report, length)
+	fc.out.MovRegToReg("rdx", "rdi")
+	fc.out.SubRegFromReg("rdx", "rsp")
+	fc.out.MovRegToReg("rsi", "rsp")
+	fc.out.MovImmToReg("rdi", "2")
+	fc.out.MovImmToReg("rax", "1")
+	fc.out.Syscall()
+
+	fc.out.MovRegToReg("rsp", "rbp")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// emitCrashSymbols appends the table of functions for the crash handler
+// to .text, once the text is complete:
+//
+//	[n] n * [distance back to the function][name offset][name length] names
+//
+// The distances and offsets are from the table, sorted by function.
+func (fc *C67Compiler) emitCrashSymbols() {
+	if !fc.usesCrashReport {
+		return
+	}
+	for fc.eb.text.Len()%8 != 0 {
+		fc.eb.text.WriteByte(0xcc) // int3
+	}
+	table := fc.eb.text.Len()
+	fc.eb.MarkLabel("_c67_crash_symbols")
+
+	funcs := fc.eb.textFunctions()
+	entries := binary.LittleEndian.AppendUint64(nil, uint64(len(funcs)))
+	var names []byte
+	for _, f := range funcs {
+		entries = binary.LittleEndian.AppendUint64(entries, uint64(table-f.offset))
+		entries = binary.LittleEndian.AppendUint64(entries, uint64(8+24*len(funcs)+len(names)))
+		entries = binary.LittleEndian.AppendUint64(entries, uint64(len(f.name)))
+		names = append(names, f.name...)
+	}
+	fc.eb.text.Write(entries)
+	fc.eb.text.Write(names)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestCrashReport tests that a crash prints the signal, the address, the
+// function and the registers, and still kills the program
+func TestCrashReport(t *testing.T) {
+	for _, tt := range []struct {
+		source string
+		want   []string
+	}{
+		{"x := read_i64(8, 0)\nprintln(x)\n", []string{"Error: segmentation fault (SIGSEGV) at address 0x8\n", "  in c67.main+0x"}},
+		{"f = n -> f(n + 1) + 1\nprintln(f(0))\n", []string{"Error: segmentation fault (SIGSEGV) at address 0x", "  in f+0x"}},
+	} {
+		_, stderr, exitCode := runCommandSeparate(exec.Command(compileTestCode(t, tt.source)))
+		for _, want := range append(tt.want, "  rax 0x", "  rip 0x") {
+			if !strings.Contains(stderr, want) {
+				t.Errorf("Expected %q in the report of %q, got %q", want, tt.source, stderr)
+			}
+		}
+		if exitCode != -1 {
+			t.Errorf("Expected %q to be killed by the signal, got exit code %d", tt.source, exitCode)
+		}
+	}
+}
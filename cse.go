@@ -0,0 +1,278 @@
+// Completion: 100% - Common subexpression elimination within statements
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// cse.go - Common subexpression elimination
+//
+//	v := xs[i*stride+j] + ys[i*stride+j]
+//
+// evaluates i*stride+j twice. eliminateCommonSubexpressions assigns an
+// arithmetic expression that a statement repeats to a temporary just before
+// the statement, and makes every occurrence read the temporary instead:
+//
+//	_cse0 = i*stride+j
+//	v := xs[_cse0] + ys[_cse0]
+//
+// The pass handles one statement at a time, in every statement list of the
+// program: the top level, loop bodies, lambda bodies, blocks and arenas.
+// A candidate is built from + - * / (and the FMAExpr made by constant
+// folding) over numbers and variables, and multiplies or divides at least
+// once, so that reading the temporary is the cheaper choice.
+//
+// Only statements that cannot change a variable while they are evaluated
+// are rewritten: no calls, blocks or lambdas inside the expression. A call
+// is allowed as the outermost expression, since all of its arguments are
+// evaluated before it runs.
+
+// cseCounter numbers the temporaries, which must not clash between the
+// files of a program
+var cseCounter atomic.Int64
+
+// cseOperators are the binary operators that cannot have side effects
+var cseOperators = map[string]bool{
+	"+": true, "-": true, "*": true, "/": true, "%": true, "**": true,
+	"<": true, "<=": true, ">": true, ">=": true, "==": true, "!=": true,
+	"and": true, "or": true,
+}
+
+// eliminateCommonSubexpressions runs the pass over a list of statements,
+// and the statement lists nested in them
+func eliminateCommonSubexpressions(stmts []Statement) []Statement {
+	out := make([]Statement, 0, len(stmts))
+	for _, stmt := range stmts {
+		cseNested(stmt)
+		out = append(out, cseStatement(stmt)...)
+	}
+	return out
+}
+
+// cseNested runs the pass over the statement lists inside a statement
+func cseNested(stmt Statement) {
+	switch s := stmt.(type) {
+	case *AssignStmt:
+		cseNestedExpr(s.Value)
+	case *MultipleAssignStmt:
+		cseNestedExpr(s.Value)
+	case *ExpressionStmt:
+		cseNestedExpr(s.Expr)
+	case *MapUpdateStmt:
+		cseNestedExpr(s.Value)
+	case *JumpStmt:
+		cseNestedExpr(s.Value)
+	case *LoopStmt:
+		s.Body = eliminateCommonSubexpressions(s.Body)
+	case *WhileStmt:
+		s.Body = eliminateCommonSubexpressions(s.Body)
+	case *ReceiveLoopStmt:
+		s.Body = eliminateCommonSubexpressions(s.Body)
+	case *ArenaStmt:
+		s.Body = eliminateCommonSubexpressions(s.Body)
+	}
+}
+
+// cseNestedExpr runs the pass over the statement lists inside an expression
+func cseNestedExpr(expr Expression) {
+	switch e := expr.(type) {
+	case *LambdaExpr:
+		cseNestedExpr(e.Body)
+	case *BlockExpr:
+		e.Statements = eliminateCommonSubexpressions(e.Statements)
+	case *ArenaExpr:
+		e.Body = eliminateCommonSubexpressions(e.Body)
+	case *LoopExpr:
+		e.Body = eliminateCommonSubexpressions(e.Body)
+	case *MatchExpr:
+		cseNestedExpr(e.Condition)
+		for _, clause := range e.Clauses {
+			cseNestedExpr(clause.Guard)
+			cseNestedExpr(clause.Result)
+		}
+		cseNestedExpr(e.DefaultExpr)
+	case *CallExpr:
+		for _, arg := range e.Args {
+			cseNestedExpr(arg)
+		}
+	case *BinaryExpr:
+		cseNestedExpr(e.Left)
+		cseNestedExpr(e.Right)
+	}
+}
+
+// cseStatement returns the statement, preceded by the temporaries for the
+// expressions it repeats
+func cseStatement(stmt Statement) []Statement {
+	var slots []*Expression
+	switch s := stmt.(type) {
+	case *AssignStmt:
+		slots = []*Expression{&s.Value}
+	case *ExpressionStmt:
+		slots = []*Expression{&s.Expr}
+	case *MapUpdateStmt:
+		slots = []*Expression{&s.Index, &s.Value}
+	case *JumpStmt:
+		if s.Value != nil {
+			slots = []*Expression{&s.Value}
+		}
+	}
+	for _, slot := range slots {
+		if call, ok := (*slot).(*CallExpr); ok {
+			for _, arg := range call.Args {
+				if !isCSESafe(arg) {
+					return []Statement{stmt}
+				}
+			}
+		} else if !isCSESafe(*slot) {
+			return []Statement{stmt}
+		}
+	}
+
+	var temps []Statement
+	for {
+		counts := make(map[string]int)
+		for _, slot := range slots {
+			countCSECandidates(*slot, counts)
+		}
+		best := ""
+		for key, n := range counts {
+			if n > 1 && (len(key) > len(best) || len(key) == len(best) && key < best) {
+				best = key
+			}
+		}
+		if best == "" {
+			break
+		}
+		name := fmt.Sprintf("_cse%d", cseCounter.Add(1)-1)
+		var value Expression
+		for _, slot := range slots {
+			*slot = replaceCSECandidate(*slot, best, name, &value)
+		}
+		temps = append(temps, &AssignStmt{Name: name, Value: value})
+	}
+	return append(temps, stmt)
+}
+
+// isCSESafe reports whether evaluating an expression cannot change any
+// variable
+func isCSESafe(expr Expression) bool {
+	switch e := expr.(type) {
+	case *NumberExpr, *StringExpr, *IdentExpr:
+		return true
+	case *BinaryExpr:
+		return cseOperators[e.Operator] && isCSESafe(e.Left) && isCSESafe(e.Right)
+	case *FMAExpr:
+		return isCSESafe(e.A) && isCSESafe(e.B) && isCSESafe(e.C)
+	case *UnaryExpr:
+		return (e.Operator == "-" || e.Operator == "not") && isCSESafe(e.Operand)
+	case *IndexExpr:
+		return isCSESafe(e.List) && isCSESafe(e.Index)
+	case *ListExpr:
+		for _, elem := range e.Elements {
+			if !isCSESafe(elem) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// isCSECandidate reports whether an expression is arithmetic worth keeping
+// in a temporary
+func isCSECandidate(expr Expression) bool {
+	arithmetic, costly := cseArithmetic(expr)
+	return arithmetic && costly
+}
+
+// cseArithmetic reports whether an expression is made of + - * / over
+// numbers and variables, and whether it multiplies or divides
+func cseArithmetic(expr Expression) (arithmetic, costly bool) {
+	switch e := expr.(type) {
+	case *NumberExpr, *IdentExpr:
+		return true, false
+	case *BinaryExpr:
+		switch e.Operator {
+		case "+", "-", "*", "/":
+		default:
+			return false, false
+		}
+		leftOK, leftCostly := cseArithmetic(e.Left)
+		rightOK, rightCostly := cseArithmetic(e.Right)
+		return leftOK && rightOK, leftCostly || rightCostly || e.Operator == "*" || e.Operator == "/"
+	case *FMAExpr:
+		aOK, _ := cseArithmetic(e.A)
+		bOK, _ := cseArithmetic(e.B)
+		cOK, _ := cseArithmetic(e.C)
+		return aOK && bOK && cOK, true
+	default:
+		return false, false
+	}
+}
+
+// countCSECandidates counts the candidates in a safe expression by their
+// text
+func countCSECandidates(expr Expression, counts map[string]int) {
+	if isCSECandidate(expr) {
+		counts[expr.String()]++
+	}
+	switch e := expr.(type) {
+	case *CallExpr:
+		for _, arg := range e.Args {
+			countCSECandidates(arg, counts)
+		}
+	case *BinaryExpr:
+		countCSECandidates(e.Left, counts)
+		countCSECandidates(e.Right, counts)
+	case *FMAExpr:
+		countCSECandidates(e.A, counts)
+		countCSECandidates(e.B, counts)
+		countCSECandidates(e.C, counts)
+	case *UnaryExpr:
+		countCSECandidates(e.Operand, counts)
+	case *IndexExpr:
+		countCSECandidates(e.List, counts)
+		countCSECandidates(e.Index, counts)
+	case *ListExpr:
+		for _, elem := range e.Elements {
+			countCSECandidates(elem, counts)
+		}
+	}
+}
+
+// replaceCSECandidate replaces the occurrences of the candidate with the text
+// key by the temporary, and stores the first one in value
+func replaceCSECandidate(expr Expression, key, name string, value *Expression) Expression {
+	if isCSECandidate(expr) && expr.String() == key {
+		if *value == nil {
+			*value = expr
+		}
+		return &IdentExpr{Name: name}
+	}
+	switch e := expr.(type) {
+	case *CallExpr:
+		for i, arg := range e.Args {
+			e.Args[i] = replaceCSECandidate(arg, key, name, value)
+		}
+	case *BinaryExpr:
+		e.Left = replaceCSECandidate(e.Left, key, name, value)
+		e.Right = replaceCSECandidate(e.Right, key, name, value)
+	case *FMAExpr:
+		e.A = replaceCSECandidate(e.A, key, name, value)
+		e.B = replaceCSECandidate(e.B, key, name, value)
+		e.C = replaceCSECandidate(e.C, key, name, value)
+	case *UnaryExpr:
+		e.Operand = replaceCSECandidate(e.Operand, key, name, value)
+	case *IndexExpr:
+		e.List = replaceCSECandidate(e.List, key, name, value)
+		e.Index = replaceCSECandidate(e.Index, key, name, value)
+	case *ListExpr:
+		for i, elem := range e.Elements {
+			e.Elements[i] = replaceCSECandidate(elem, key, name, value)
+		}
+	}
+	return expr
+}
@@ -0,0 +1,297 @@
+// Completion: 100% - Dates and times (x86_64 Unix)
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// datetime.go - now, datefmt, dateparse, adddays and datediff
+//
+// A time is a number of seconds since 1970-01-01T00:00:00Z, so times can
+// be stored, compared and subtracted like any other number:
+//
+//	t = now()                                  // 1767366245.123, with the fraction
+//	datefmt(t, "YYYY-MM-DD HH:mm:ss")          // "2026-01-02 15:04:05"
+//	dateparse("2026-01-02")                    // 1767312000
+//	dateparse("02/01/2026", "DD/MM/YYYY")      // 1767312000
+//	adddays(t, 7)                              // a week later
+//	datediff(dateparse("2026-03-01"), t)       // seconds between them
+//
+// Dates are in UTC, like the timestamps of the log built-ins, so they do
+// not move with the time zone or daylight saving time of the machine and
+// adddays(t, n) is always n * 86400 seconds later.
+//
+// Layouts are string literals made of YYYY, MM, DD, HH, mm and ss, for the
+// year, month, day, hour, minute and second, and any other characters,
+// which stand for themselves. They are translated to strftime and strptime
+// formats at compile time. Without a layout, dateparse accepts YYYY-MM-DD,
+// optionally followed by HH:mm:ss after a T or a space and then a Z. A
+// string that does not match all the way gives error("prs").
+
+// dateLayoutFields are the fields of a date layout, by strftime conversion
+var dateLayoutFields = []struct{ field, conversion string }{
+	{"YYYY", "%Y"}, {"MM", "%m"}, {"DD", "%d"},
+	{"HH", "%H"}, {"mm", "%M"}, {"ss", "%S"},
+}
+
+// dateParseLayouts are the formats dateparse tries without a layout
+var dateParseLayouts = []string{
+	"%Y-%m-%dT%H:%M:%SZ", "%Y-%m-%dT%H:%M:%S",
+	"%Y-%m-%d %H:%M:%SZ", "%Y-%m-%d %H:%M:%S",
+	"%Y-%m-%d",
+}
+
+// dateBufferSize is the largest formatted date, including the terminator
+const dateBufferSize = 256
+
+// dateFormat translates a date layout to a strftime/strptime format
+func dateFormat(layout string) string {
+	var format strings.Builder
+next:
+	for i := 0; i < len(layout); {
+		for _, f := range dateLayoutFields {
+			if strings.HasPrefix(layout[i:], f.field) {
+				format.WriteString(f.conversion)
+				i += len(f.field)
+				continue next
+			}
+		}
+		if layout[i] == '%' {
+			format.WriteByte('%')
+		}
+		format.WriteByte(layout[i])
+		i++
+	}
+	return format.String()
+}
+
+// compileDateCall compiles now, datefmt, dateparse, adddays and datediff
+func (fc *C67Compiler) compileDateCall(call *CallExpr) {
+	switch call.Function {
+	case "adddays":
+		if len(call.Args) != 2 {
+			compilerError("adddays() requires exactly 2 arguments (time, days)")
+		}
+		days := &BinaryExpr{Left: call.Args[1], Operator: "*", Right: &NumberExpr{Value: 86400}}
+		fc.compileExpression(&BinaryExpr{Left: call.Args[0], Operator: "+", Right: days})
+		return
+	case "datediff":
+		if len(call.Args) != 2 {
+			compilerError("datediff() requires exactly 2 arguments (later, earlier)")
+		}
+		fc.compileExpression(&BinaryExpr{Left: call.Args[0], Operator: "-", Right: call.Args[1]})
+		return
+	}
+
+	if fc.eb.target.Arch() != ArchX86_64 || fc.eb.target.OS() == OSWindows {
+		compilerError("%s() is only supported on x86_64 Unix targets", call.Function)
+	}
+
+	switch call.Function {
+	case "now":
+		if len(call.Args) != 0 {
+			compilerError("now() takes no arguments")
+		}
+		fc.trackFunctionCall("clock_gettime")
+		fc.usesDates = true
+		fc.out.CallSymbol("_c67_now")
+
+	case "datefmt":
+		if len(call.Args) != 2 {
+			compilerError("datefmt() requires exactly 2 arguments (time, layout)")
+		}
+		format := fc.dateFormatLabel(call, call.Args[1], "")
+		for _, name := range []string{"gmtime_r", "strftime", "strlen"} {
+			fc.trackFunctionCall(name)
+		}
+		fc.usesDates = true
+
+		// Whole seconds, rounded down so times before 1970 keep their date
+		fc.compileExpression(call.Args[0])
+		fc.out.Cvttsd2si("rdi", "xmm0")
+		fc.out.Cvtsi2sd("xmm1", "rdi")
+		fc.out.Ucomisd("xmm1", "xmm0")
+		whole := fc.forwardJump(JumpBelowOrEqual)
+		fc.out.DecReg("rdi")
+		fc.landForwardJump(whole)
+
+		fc.out.LeaSymbolToReg("rsi", format)
+		fc.out.CallSymbol("_c67_datefmt")
+
+	case "dateparse":
+		if len(call.Args) != 1 && len(call.Args) != 2 {
+			compilerError("dateparse() requires 1 or 2 arguments (string, layout)")
+		}
+		format := "_c67_date_layouts"
+		if len(call.Args) == 2 {
+			format = fc.dateFormatLabel(call, call.Args[1], "\x00")
+		} else {
+			fc.eb.Define(format, strings.Join(dateParseLayouts, "\x00")+"\x00\x00")
+		}
+		for _, name := range []string{"strptime", "timegm", "strlen"} {
+			fc.trackFunctionCall(name)
+		}
+		fc.usesDates = true
+
+		fc.compileExpression(call.Args[0])
+		fc.out.CallSymbol("c67_string_to_cstr")
+		fc.out.MovRegToReg("rdi", "rax")
+		fc.out.LeaSymbolToReg("rsi", format)
+		fc.out.CallSymbol("_c67_dateparse")
+	}
+}
+
+// dateFormatLabel defines the format for the layout argument of call, with
+// extra after its terminator, and returns its label
+func (fc *C67Compiler) dateFormatLabel(call *CallExpr, layout Expression, extra string) string {
+	s, ok := layout.(*StringExpr)
+	if !ok {
+		compilerError("%s() needs a string literal layout such as \"YYYY-MM-DD\"", call.Function)
+	}
+	label := fmt.Sprintf("date_format_%d", fc.stringCounter)
+	fc.stringCounter++
+	fc.eb.Define(label, dateFormat(s.Value)+"\x00"+extra)
+	return label
+}
+
+// generateDateHelpers emits the runtime helpers behind the date built-ins
+func (fc *C67Compiler) generateDateHelpers() {
+	fc.generateDateNow()
+	fc.generateDateFmt()
+	fc.generateDateParse()
+}
+
+// generateDateNow emits _c67_now() -> xmm0, the real-time clock in seconds
+func (fc *C67Compiler) generateDateNow() {
+	fc.eb.MarkFunction("_c67_now")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.SubImmFromReg("rsp", 16)
+	fc.out.AndRegWithImm("rsp", -16)   // [rsp] = struct timespec
+	fc.out.XorRegWithReg("rdi", "rdi") // CLOCK_REALTIME
+	fc.out.MovRegToReg("rsi", "rsp")
+	fc.trackFunctionCall("clock_gettime")
+	fc.eb.GenerateCallInstruction("clock_gettime")
+	fc.out.MovMemToReg("rax", "rsp", 8)
+	fc.out.Cvtsi2sd("xmm0", "rax")
+	fc.gameloopLoadConst("xmm1", 1e-9)
+	fc.out.MulsdXmm("xmm0", "xmm1")
+	fc.out.MovMemToReg("rax", "rsp", 0)
+	fc.out.Cvtsi2sd("xmm1", "rax")
+	fc.out.AddsdXmm("xmm0", "xmm1")
+	fc.out.MovRegToReg("rsp", "rbp")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generateDateFmt emits _c67_datefmt(rdi=seconds, rsi=strftime format) -> xmm0,
+// the formatted string, or error("arg") for a year gmtime_r cannot represent
+func (fc *C67Compiler) generateDateFmt() {
+	fc.eb.MarkFunction("_c67_datefmt")
+
+	// [rsp] = time_t, [rsp+16] = struct tm, [rsp+80] = the formatted date
+	const (
+		tm     = 16
+		buffer = 80
+	)
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("r12")
+	fc.out.SubImmFromReg("rsp", buffer+dateBufferSize)
+	fc.out.AndRegWithImm("rsp", -16)
+
+	fc.out.MovRegToMem("rdi", "rsp", 0)
+	fc.out.MovRegToReg("r12", "rsi")
+	fc.out.MovRegToReg("rdi", "rsp")
+	fc.out.LeaMemToReg("rsi", "rsp", tm)
+	fc.trackFunctionCall("gmtime_r")
+	fc.eb.GenerateCallInstruction("gmtime_r")
+	fc.out.TestRegReg("rax", "rax")
+	failed := fc.forwardJump(JumpEqual)
+
+	// strftime returns 0 and leaves the buffer undefined when it is too small
+	fc.out.LeaMemToReg("rdi", "rsp", buffer)
+	fc.out.MovImmToReg("rsi", fmt.Sprintf("%d", dateBufferSize))
+	fc.out.MovRegToReg("rdx", "r12")
+	fc.out.LeaMemToReg("rcx", "rsp", tm)
+	fc.trackFunctionCall("strftime")
+	fc.eb.GenerateCallInstruction("strftime")
+	fc.out.LeaMemToReg("rdi", "rsp", buffer)
+	fc.out.AddRegToReg("rax", "rdi")
+	fc.out.Emit([]byte{0xc6, 0x00, 0x00}) // mov byte [rax], 0
+	fc.out.CallSymbol("cstr_to_c67_string")
+	done := fc.forwardJumpAlways()
+
+	fc.landForwardJump(failed)
+	fc.createErrorResult("arg")
+
+	fc.landForwardJump(done)
+	fc.out.LeaMemToReg("rsp", "rbp", -8)
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generateDateParse emits _c67_dateparse(rdi=C string, rsi=strptime formats) -> xmm0.
+// The formats follow each other, each with a terminator, and end with an
+// empty one. The first that matches the whole string gives the time, and
+// none gives error("prs").
+func (fc *C67Compiler) generateDateParse() {
+	fc.eb.MarkFunction("_c67_dateparse")
+
+	// [rsp] = struct tm
+	const tmSize = 64
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.SubImmFromReg("rsp", tmSize)
+	fc.out.AndRegWithImm("rsp", -16)
+	fc.out.MovRegToReg("r12", "rdi") // r12 = string
+	fc.out.MovRegToReg("r13", "rsi") // r13 = format
+
+	loop := fc.eb.text.Len()
+	fc.out.Emit([]byte{0x41, 0x80, 0x7d, 0x00, 0x00}) // cmp byte [r13], 0
+	failed := fc.forwardJump(JumpEqual)
+
+	// strptime only sets the fields in the format
+	for offset := 0; offset < tmSize; offset += 8 {
+		fc.out.MovImmToMem(0, "rsp", offset)
+	}
+	fc.out.MovRegToReg("rdi", "r12")
+	fc.out.MovRegToReg("rsi", "r13")
+	fc.out.MovRegToReg("rdx", "rsp")
+	fc.trackFunctionCall("strptime")
+	fc.eb.GenerateCallInstruction("strptime")
+	fc.out.TestRegReg("rax", "rax")
+	noMatch := fc.forwardJump(JumpEqual)
+	fc.out.Emit([]byte{0x80, 0x38, 0x00}) // cmp byte [rax], 0
+	trailing := fc.forwardJump(JumpNotEqual)
+	fc.out.MovRegToReg("rdi", "rsp")
+	fc.trackFunctionCall("timegm")
+	fc.eb.GenerateCallInstruction("timegm")
+	fc.out.Cvtsi2sd("xmm0", "rax")
+	done := fc.forwardJumpAlways()
+
+	// Try the next format
+	fc.landForwardJump(noMatch)
+	fc.landForwardJump(trailing)
+	fc.out.MovRegToReg("rdi", "r13")
+	fc.trackFunctionCall("strlen")
+	fc.eb.GenerateCallInstruction("strlen")
+	fc.out.AddRegToReg("r13", "rax")
+	fc.out.IncReg("r13")
+	fc.out.JumpUnconditional(int32(loop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(failed)
+	fc.createErrorResult("prs")
+
+	fc.landForwardJump(done)
+	fc.out.LeaMemToReg("rsp", "rbp", -16)
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
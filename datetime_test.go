@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+// TestDates tests formatting, parsing and arithmetic on times
+func TestDates(t *testing.T) {
+	source := `t = dateparse("2026-01-02T15:04:05Z")
+println(t)
+println(datefmt(t, "YYYY-MM-DD HH:mm:ss"))
+println(datefmt(adddays(t, 30), "DD/MM/YYYY"))
+println(dateparse("02/01/2026", "DD/MM/YYYY"))
+println(dateparse("2026-01-02 15:04:05") == t)
+println(dateparse("2026-01-02 x").error)
+println(datediff(dateparse("2026-03-01"), dateparse("2026-02-01")))
+println(datefmt(-1.5, "YYYY-MM-DD HH:mm:ss 100%"))
+println(now() > t)
+`
+	want := "1767366245\n2026-01-02 15:04:05\n01/02/2026\n1767312000\n1\nprs\n2419200\n1969-12-31 23:59:58 100%\n1\n"
+	if result := compileAndRun(t, source); result != want {
+		t.Errorf("Expected %q, got %q", want, result)
+	}
+}
+
+// TestDateFormat tests the translation of layouts to strftime formats
+func TestDateFormat(t *testing.T) {
+	for layout, want := range map[string]string{
+		"YYYY-MM-DD":      "%Y-%m-%d",
+		"HH:mm:ss":        "%H:%M:%S",
+		"DD.MM.YYYY 100%": "%d.%m.%Y 100%%",
+		"YYYYY":           "%YY",
+	} {
+		if got := dateFormat(layout); got != want {
+			t.Errorf("dateFormat(%q): expected %q, got %q", layout, want, got)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Completion: 100% - Fixed-point decimals (x86_64)
+package main
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// decimal.go - Decimal numbers for money
+//
+// decimal(x) is x as a decimal with four places, from a string or a number:
+//
+//	decimal("0.1") + decimal("0.2")   // 0.3, where 0.1 + 0.2 is 0.30000000000000004
+//	price = decimal("19.99")
+//	price * 3 / 7                     // 8.5671
+//	f"{price / 3:.2}"                 // 6.66
+//
+// A decimal is a number like any other, the one nearest to its value, so
+// it can be stored, passed and printed as usual and prints as written.
+// When either operand of +, -, *, / or % is known at compile time to be a
+// decimal, the operator instead works on the exact count of ten-thousandths
+// of both, as an int64 with a 128-bit product or dividend in between, so
+// the sum above does not drift. The result of * and / is rounded to four
+// places half away from zero, and a number operand is first rounded to four
+// places. {d:.N} with fewer places rounds the same way.
+//
+// Decimals are exact up to about 900 billion, where their count of
+// ten-thousandths stops fitting in the 53 bits of a float64. Decimals that
+// come back from a function are numbers again; decimal(f(x)) makes them
+// decimals without changing them.
+
+const (
+	decimalPlaces = 4     // digits after the point
+	decimalScale  = 10000 // 10^decimalPlaces
+)
+
+// decimalOps are the operators that work exactly on decimals
+var decimalOps = map[string]bool{"+": true, "-": true, "*": true, "/": true, "%": true, "mod": true}
+
+// isDecimalOp reports whether e is an operator on a decimal
+func (fc *C67Compiler) isDecimalOp(e *BinaryExpr) bool {
+	return decimalOps[e.Operator] && (fc.getExprType(e.Left) == "decimal" || fc.getExprType(e.Right) == "decimal")
+}
+
+// decimalLiteral returns the decimal that the text s stands for, as the
+// nearest float64
+func decimalLiteral(s string) (float64, bool) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return 0, false
+	}
+	units := new(big.Rat).Mul(r, big.NewRat(decimalScale, 1))
+	if !units.IsInt() || units.Num().BitLen() > 53 {
+		return 0, false
+	}
+	return float64(units.Num().Int64()) / decimalScale, true
+}
+
+// compileDecimalCall compiles decimal(x)
+func (fc *C67Compiler) compileDecimalCall(call *CallExpr) {
+	if len(call.Args) != 1 {
+		compilerError("decimal() requires exactly 1 argument")
+	}
+	if s, ok := call.Args[0].(*StringExpr); ok {
+		value, ok := decimalLiteral(s.Value)
+		if !ok {
+			compilerError("decimal(%q) is not a number with at most %d decimal places below 900 billion", s.Value, decimalPlaces)
+		}
+		fc.compileExpression(&NumberExpr{Value: value})
+		return
+	}
+	if fc.getExprType(call.Args[0]) == "string" {
+		fc.compileExpression(&CallExpr{Function: "num", Args: call.Args})
+	} else {
+		fc.compileExpression(call.Args[0])
+	}
+	fc.emitDecimalUnits("rax", "xmm0")
+	fc.emitDecimalFromUnits()
+}
+
+// emitDecimalUnits sets reg to the ten-thousandths of the number in xmm,
+// rounded to the nearest. Clobbers xmm and xmm2.
+func (fc *C67Compiler) emitDecimalUnits(reg, xmm string) {
+	fc.out.MovImmToReg(reg, strconv.FormatUint(math.Float64bits(decimalScale), 10))
+	fc.out.MovqRegToXmm("xmm2", reg)
+	fc.out.MulsdXmm(xmm, "xmm2")
+	if reg == "rax" {
+		fc.out.Emit([]byte{0xf2, 0x48, 0x0f, 0x2d, 0xc0}) // cvtsd2si rax, xmm0 (round to nearest)
+	} else {
+		fc.out.Emit([]byte{0xf2, 0x48, 0x0f, 0x2d, 0xc9}) // cvtsd2si rcx, xmm1 (round to nearest)
+	}
+}
+
+// emitDecimalFromUnits sets xmm0 to the decimal with the ten-thousandths in rax
+func (fc *C67Compiler) emitDecimalFromUnits() {
+	fc.out.Cvtsi2sd("xmm0", "rax")
+	fc.out.MovImmToReg("rax", strconv.FormatUint(math.Float64bits(decimalScale), 10))
+	fc.out.MovqRegToXmm("xmm1", "rax")
+	fc.out.DivsdXmm("xmm0", "xmm1")
+}
+
+// compileDecimalBinary compiles an operator on decimals
+func (fc *C67Compiler) compileDecimalBinary(e *BinaryExpr) {
+	fc.compileExpression(e.Left)
+	fc.out.SubImmFromReg("rsp", 16)
+	fc.out.MovXmmToMem("xmm0", "rsp", 0)
+	fc.compileExpression(e.Right)
+	fc.out.MovRegToReg("xmm1", "xmm0")
+	fc.out.MovMemToXmm("xmm0", "rsp", 0)
+	fc.out.AddImmToReg("rsp", 16)
+
+	fc.emitDecimalUnits("rcx", "xmm1")
+	fc.emitDecimalUnits("rax", "xmm0")
+
+	var divZero int
+	switch e.Operator {
+	case "+":
+		fc.out.AddRegToReg("rax", "rcx")
+	case "-":
+		fc.out.SubRegFromReg("rax", "rcx")
+	case "*":
+		fc.out.Emit([]byte{0x48, 0xf7, 0xe9}) // imul rcx (rdx:rax = rax * rcx)
+		fc.out.MovImmToReg("rsi", strconv.Itoa(decimalScale))
+		fc.out.Emit([]byte{0x48, 0xf7, 0xfe}) // idiv rsi
+		fc.emitDecimalRoundQuotient()
+	case "/":
+		fc.out.TestRegReg("rcx", "rcx")
+		divZero = fc.forwardJump(JumpEqual)
+		fc.out.MovRegToReg("rsi", "rcx")
+		fc.out.MovImmToReg("rcx", strconv.Itoa(decimalScale))
+		fc.out.Emit([]byte{0x48, 0xf7, 0xe9}) // imul rcx (rdx:rax = rax * 10^4)
+		fc.out.Emit([]byte{0x48, 0xf7, 0xfe}) // idiv rsi
+		fc.emitDecimalRoundQuotient()
+	default: // % and mod
+		// The remainder has the sign of the divisor, as for numbers
+		fc.out.TestRegReg("rcx", "rcx")
+		divZero = fc.forwardJump(JumpEqual)
+		fc.out.MovRegToReg("rsi", "rcx")
+		fc.out.Emit([]byte{0x48, 0x99})       // cqo
+		fc.out.Emit([]byte{0x48, 0xf7, 0xfe}) // idiv rsi
+		fc.out.MovRegToReg("rax", "rdx")
+		fc.out.TestRegReg("rax", "rax")
+		exact := fc.forwardJump(JumpEqual)
+		fc.out.XorRegWithReg("rdx", "rsi")
+		sameSign := fc.forwardJump(JumpGreaterOrEqual)
+		fc.out.AddRegToReg("rax", "rsi")
+		fc.landForwardJump(sameSign)
+		fc.landForwardJump(exact)
+	}
+	fc.emitDecimalFromUnits()
+
+	if e.Operator == "+" || e.Operator == "-" || e.Operator == "*" {
+		return
+	}
+	done := fc.forwardJumpAlways()
+	// Dividing by zero gives the same error as for numbers
+	fc.landForwardJump(divZero)
+	fc.out.MovImmToReg("rax", "0x7ff8000064763000") // NaN with "dv0\0"
+	fc.out.MovqRegToXmm("xmm0", "rax")
+	fc.landForwardJump(done)
+}
+
+// emitDecimalRoundQuotient rounds the quotient in rax of an idiv by rsi
+// half away from zero, with the remainder in rdx
+func (fc *C67Compiler) emitDecimalRoundQuotient() {
+	// rdx = 2 * remainder, made relative to a positive divisor
+	fc.out.AddRegToReg("rdx", "rdx")
+	fc.out.TestRegReg("rsi", "rsi")
+	positive := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.NegReg("rdx")
+	fc.out.NegReg("rsi")
+	fc.landForwardJump(positive)
+
+	fc.out.CmpRegToReg("rdx", "rsi")
+	notUp := fc.forwardJump(JumpLess)
+	fc.out.IncReg("rax")
+	done := fc.forwardJumpAlways()
+	fc.landForwardJump(notUp)
+	fc.out.NegReg("rsi")
+	fc.out.CmpRegToReg("rdx", "rsi")
+	notDown := fc.forwardJump(JumpGreater)
+	fc.out.DecReg("rax")
+	fc.landForwardJump(notDown)
+	fc.landForwardJump(done)
+}
+
+// emitDecimalRound rounds the decimal in xmm0 to places digits after the
+// point, half away from zero
+func (fc *C67Compiler) emitDecimalRound(places int) {
+	fc.emitDecimalUnits("rax", "xmm0")
+	fc.out.MovImmToReg("rsi", strconv.FormatInt(int64(math.Pow10(decimalPlaces-places)), 10))
+	fc.out.Emit([]byte{0x48, 0x99})       // cqo
+	fc.out.Emit([]byte{0x48, 0xf7, 0xfe}) // idiv rsi
+	fc.out.PushReg("rsi")
+	fc.emitDecimalRoundQuotient()
+	fc.out.PopReg("rsi")
+	fc.out.ImulRegWithReg("rax", "rsi")
+	fc.emitDecimalFromUnits()
+}
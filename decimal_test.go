@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+// TestDecimal tests that arithmetic on decimals is exact to four places
+func TestDecimal(t *testing.T) {
+	source := `price = decimal("19.99")
+total := decimal(0)
+@ i in 0..<10 { total <- total + decimal("0.1") }
+println(total)
+println(decimal("0.1") + decimal("0.2"))
+println(price * 3 / 7)
+println(decimal("-2") / 3)
+println(decimal("-7.5") % 2)
+println(decimal("0.0001") * decimal("0.5"))
+println(price * 2 + price)
+a = decimal("2.675")
+println(f"{a:.2} {-a:.2}")
+s = "1.23456"
+println(decimal(s))
+println(decimal("1") / 0 or! -1)
+`
+	want := "1\n0.3\n8.5671\n-0.6667\n0.5\n0.0001\n59.97\n2.68 -2.68\n1.2346\n-1\n"
+	if result := compileAndRun(t, source); result != want {
+		t.Errorf("Expected %q, got %q", want, result)
+	}
+}
+
+// TestDecimalLiteral tests the parsing of decimal("...") at compile time
+func TestDecimalLiteral(t *testing.T) {
+	for s, want := range map[string]float64{"19.99": 19.99, "-0.0001": -0.0001, " 42 ": 42, "1e3": 1000} {
+		if got, ok := decimalLiteral(s); !ok || got != want {
+			t.Errorf("decimalLiteral(%q): expected %v, got %v, %v", s, want, got, ok)
+		}
+	}
+	for _, s := range []string{"0.00001", "abc", "", "1000000000000"} {
+		if _, ok := decimalLiteral(s); ok {
+			t.Errorf("decimalLiteral(%q): expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Completion: 100% - Conditional compilation with -D
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defines.go - Compile-time definitions from -D NAME=value
+//
+// Each -D NAME=value flag defines NAME, and -D NAME defines it as 1. Two
+// calls read the definitions, and both fold to literals:
+//
+//	defined("DEBUG")    1 if DEBUG is defined, 0 if not
+//	cfg("LEVEL")        the value of LEVEL, a number if it reads as one and
+//	                    a string if not, or 0 when LEVEL is not defined
+//
+// so a single source can turn debug output or features on per build:
+//
+//	defined("DEBUG") { 1 -> println("debug build") }
+//
+// The name has to be a string literal. The branch that is not taken is
+// still compiled, so it has to be valid code on every build.
+
+// Defines holds the -D definitions of the build
+var Defines = map[string]string{}
+
+// defineFlag collects repeated -D flags into Defines
+type defineFlag struct{}
+
+func (defineFlag) String() string {
+	var defs []string
+	for name, value := range Defines {
+		defs = append(defs, name+"="+value)
+	}
+	return strings.Join(defs, ",")
+}
+
+func (defineFlag) Set(def string) error {
+	name, value, found := strings.Cut(def, "=")
+	if name == "" {
+		return fmt.Errorf("-D needs a name, as in -D DEBUG or -D LEVEL=2")
+	}
+	if !found {
+		value = "1"
+	}
+	Defines[name] = value
+	return nil
+}
+
+// isDefineCall reports whether call is defined(...) or cfg(...)
+func isDefineCall(call *CallExpr) bool {
+	return call.Function == "defined" || call.Function == "cfg"
+}
+
+// foldDefineCall returns the literal that defined(name) or cfg(name) folds
+// to, or false if the name is not a string literal
+func foldDefineCall(call *CallExpr) (Expression, bool) {
+	if len(call.Args) != 1 {
+		return nil, false
+	}
+	name, ok := call.Args[0].(*StringExpr)
+	if !ok {
+		return nil, false
+	}
+	value, defined := Defines[name.Value]
+	if call.Function == "defined" {
+		if defined {
+			return &NumberExpr{Value: 1}, true
+		}
+		return &NumberExpr{Value: 0}, true
+	}
+	if !defined {
+		return &NumberExpr{Value: 0}, true
+	}
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		return &NumberExpr{Value: n}, true
+	}
+	return &StringExpr{Value: value}, true
+}
+
+// compileDefineCall compiles a defined() or cfg() that the optimizer did not
+// fold, as in the body of a function
+func (fc *C67Compiler) compileDefineCall(call *CallExpr) {
+	folded, ok := foldDefineCall(call)
+	if !ok {
+		compilerError("%s() requires exactly 1 argument, the name as a string literal", call.Function)
+	}
+	fc.compileExpression(folded)
+}
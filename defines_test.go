@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestDefineFlag tests parsing of -D NAME and -D NAME=value
+func TestDefineFlag(t *testing.T) {
+	saved := Defines
+	Defines = map[string]string{}
+	defer func() { Defines = saved }()
+
+	for _, def := range []string{"DEBUG", "LEVEL=2", "NAME=a=b"} {
+		if err := (defineFlag{}).Set(def); err != nil {
+			t.Fatalf("Set(%q): %v", def, err)
+		}
+	}
+	if Defines["DEBUG"] != "1" || Defines["LEVEL"] != "2" || Defines["NAME"] != "a=b" {
+		t.Errorf("Unexpected definitions: %v", Defines)
+	}
+	if err := (defineFlag{}).Set("=3"); err == nil {
+		t.Errorf("Expected an error for a definition without a name")
+	}
+}
+
+// TestDefinedAndCfg tests that defined() and cfg() fold to the -D definitions
+func TestDefinedAndCfg(t *testing.T) {
+	saved := Defines
+	Defines = map[string]string{"DEBUG": "1", "LEVEL": "4", "MODE": "fast"}
+	defer func() { Defines = saved }()
+
+	source := `main = {
+    defined("DEBUG") {
+        1 -> println("debug")
+        ~> println("release")
+    }
+    println(defined("TRACE"))
+    println(cfg("LEVEL") * 2)
+    println(cfg("MODE"))
+    println(cfg("MISSING"))
+}
+`
+	result := compileAndRun(t, source)
+	if !strings.Contains(result, "debug\n0\n8\nfast\n0\n") {
+		t.Errorf("Expected output to contain 'debug\\n0\\n8\\nfast\\n0\\n', got: %s", result)
+	}
+}
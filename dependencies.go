@@ -98,15 +98,8 @@ func GetRepoCachePath(repoURL string) (string, error) {
 		return "", err
 	}
 
-	// Remove protocol prefix if present
-	repoURL = strings.TrimPrefix(repoURL, "https://")
-	repoURL = strings.TrimPrefix(repoURL, "http://")
-	repoURL = strings.TrimPrefix(repoURL, "git://")
-
-	// Remove .git suffix if present
-	repoURL = strings.TrimSuffix(repoURL, ".git")
-
-	return filepath.Join(cachePath, repoURL), nil
+	// The same place for the HTTPS and the SSH URL (see gitauth.go)
+	return filepath.Join(cachePath, gitRepoPath(repoURL)), nil
 }
 
 // EnsureRepoCloned ensures a repository is cloned to the cache
@@ -121,6 +114,9 @@ func EnsureRepoCloned(repoURL string, updateDeps bool) (string, error) {
 	if _, err := os.Stat(filepath.Join(repoPath, ".git")); err == nil {
 		// Repo exists
 		if updateDeps {
+			if err := requireNetwork("updating", repoURL); err != nil {
+				return "", err
+			}
 			if err := GitPull(repoPath); err != nil {
 				return "", fmt.Errorf("failed to update %s: %w", repoURL, err)
 			}
@@ -132,6 +128,9 @@ func EnsureRepoCloned(repoURL string, updateDeps bool) (string, error) {
 	}
 
 	// Repo doesn't exist, clone it
+	if err := requireNetwork("cloning", repoURL); err != nil {
+		return "", err
+	}
 	if err := GitClone(repoURL, repoPath); err != nil {
 		return "", fmt.Errorf("failed to clone %s: %w", repoURL, err)
 	}
@@ -159,6 +158,9 @@ func EnsureRepoClonedWithVersion(repoURL, version string, updateDeps bool) (stri
 
 	if !repoExists {
 		// Repo doesn't exist, clone it with the specific version
+		if err := requireNetwork("cloning", repoURL); err != nil {
+			return "", err
+		}
 		if err := GitCloneWithVersion(repoURL, repoPath, version); err != nil {
 			return "", fmt.Errorf("failed to clone %s: %w", repoURL, err)
 		}
@@ -177,7 +179,10 @@ func EnsureRepoClonedWithVersion(repoURL, version string, updateDeps bool) (stri
 		// Repo exists
 		if updateDeps {
 			// Fetch updates
-			fetchCmd := exec.Command("git", "-C", repoPath, "fetch", "--all", "--tags")
+			if err := requireNetwork("updating", repoURL); err != nil {
+				return "", err
+			}
+			fetchCmd := gitCommand(repoURL, "-C", repoPath, "fetch", "--all", "--tags")
 			fetchCmd.Stdout = os.Stderr
 			fetchCmd.Stderr = os.Stderr
 			if err := fetchCmd.Run(); err != nil {
@@ -238,19 +243,13 @@ func GitCloneWithVersion(repoURL, destPath, version string) error {
 	}
 
 	// Build full clone URL
-	cloneURL := repoURL
-	if !strings.HasPrefix(repoURL, "http://") &&
-		!strings.HasPrefix(repoURL, "https://") &&
-		!strings.HasPrefix(repoURL, "git://") &&
-		!strings.HasPrefix(repoURL, "git@") {
-		cloneURL = "https://" + repoURL
-	}
+	cloneURL := gitCloneURL(repoURL)
 
 	// Handle version-specific cloning
 	if version == "" || version == "latest" {
 		// Use smart detection (latest tag > main > master)
 		// First do a bare clone to discover refs
-		cmd := exec.Command("git", "clone", "--bare", cloneURL, destPath+".tmp")
+		cmd := gitCommand(cloneURL, "clone", "--bare", cloneURL, destPath+".tmp")
 		cmd.Stdout = os.Stderr
 		cmd.Stderr = os.Stderr
 		if err := cmd.Run(); err != nil {
@@ -272,14 +271,14 @@ func GitCloneWithVersion(repoURL, destPath, version string) error {
 			if VerboseMode {
 				fmt.Fprintf(os.Stderr, "Cloning %s at %s...\n", repoURL, checkoutRef)
 			}
-			cloneCmd := exec.Command("git", "clone", "--depth=1", "--branch", checkoutRef, cloneURL, destPath)
+			cloneCmd := gitCommand(cloneURL, "clone", "--depth=1", "--branch", checkoutRef, cloneURL, destPath)
 			cloneCmd.Stdout = os.Stderr
 			cloneCmd.Stderr = os.Stderr
 			if err := cloneCmd.Run(); err != nil {
 				return fmt.Errorf("git clone failed: %w", err)
 			}
 		} else {
-			cloneCmd := exec.Command("git", "clone", "--depth=1", cloneURL, destPath)
+			cloneCmd := gitCommand(cloneURL, "clone", "--depth=1", cloneURL, destPath)
 			cloneCmd.Stdout = os.Stderr
 			cloneCmd.Stderr = os.Stderr
 			if err := cloneCmd.Run(); err != nil {
@@ -291,7 +290,7 @@ func GitCloneWithVersion(repoURL, destPath, version string) error {
 		if VerboseMode {
 			fmt.Fprintf(os.Stderr, "Cloning %s at %s...\n", repoURL, version)
 		}
-		cloneCmd := exec.Command("git", "clone", "--depth=1", "--branch", version, cloneURL, destPath)
+		cloneCmd := gitCommand(cloneURL, "clone", "--depth=1", "--branch", version, cloneURL, destPath)
 		cloneCmd.Stdout = os.Stderr
 		cloneCmd.Stderr = os.Stderr
 
@@ -301,7 +300,7 @@ func GitCloneWithVersion(repoURL, destPath, version string) error {
 			if VerboseMode {
 				fmt.Fprintf(os.Stderr, "Not a branch/tag, trying as commit...\n")
 			}
-			cloneCmd = exec.Command("git", "clone", cloneURL, destPath)
+			cloneCmd = gitCommand(cloneURL, "clone", cloneURL, destPath)
 			cloneCmd.Stdout = os.Stderr
 			cloneCmd.Stderr = os.Stderr
 			if err := cloneCmd.Run(); err != nil {
@@ -330,16 +329,10 @@ func GitClone(repoURL, destPath string) error {
 	}
 
 	// Build full clone URL (add https:// if needed)
-	cloneURL := repoURL
-	if !strings.HasPrefix(repoURL, "http://") &&
-		!strings.HasPrefix(repoURL, "https://") &&
-		!strings.HasPrefix(repoURL, "git://") &&
-		!strings.HasPrefix(repoURL, "git@") {
-		cloneURL = "https://" + repoURL
-	}
+	cloneURL := gitCloneURL(repoURL)
 
 	// First, do a shallow clone (just enough to discover tags and branches)
-	cmd := exec.Command("git", "clone", "--bare", cloneURL, destPath+".tmp")
+	cmd := gitCommand(cloneURL, "clone", "--bare", cloneURL, destPath+".tmp")
 	cmd.Stdout = os.Stderr
 	cmd.Stderr = os.Stderr
 
@@ -364,10 +357,10 @@ func GitClone(repoURL, destPath string) error {
 		if VerboseMode {
 			fmt.Fprintf(os.Stderr, "Cloning %s at %s...\n", repoURL, checkoutRef)
 		}
-		cloneCmd = exec.Command("git", "clone", "--depth=1", "--branch", checkoutRef, cloneURL, destPath)
+		cloneCmd = gitCommand(cloneURL, "clone", "--depth=1", "--branch", checkoutRef, cloneURL, destPath)
 	} else {
 		// No specific ref, let git use default
-		cloneCmd = exec.Command("git", "clone", "--depth=1", cloneURL, destPath)
+		cloneCmd = gitCommand(cloneURL, "clone", "--depth=1", cloneURL, destPath)
 	}
 	cloneCmd.Stdout = os.Stderr
 	cloneCmd.Stderr = os.Stderr
@@ -429,7 +422,7 @@ func branchExists(bareRepoPath, branchName string) bool {
 // Fetches latest tags and updates to latest tag, or latest main/master
 func GitPull(repoPath string) error {
 	// Fetch all updates including tags
-	fetchCmd := exec.Command("git", "-C", repoPath, "fetch", "--all", "--tags")
+	fetchCmd := gitCommand(gitRemoteURL(repoPath), "-C", repoPath, "fetch", "--all", "--tags")
 	fetchCmd.Stdout = os.Stderr
 	fetchCmd.Stderr = os.Stderr
 
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestTokenLength tests that tokens carry their length in the source
+func TestTokenLength(t *testing.T) {
+	l := NewLexer(`name := "hi" + 12.5`)
+	for _, want := range []int{4, 2, 4, 1, 4} {
+		tok := l.NextToken()
+		if tok.Length != want {
+			t.Errorf("Token %q: expected length %d, got %d", tok.Value, want, tok.Length)
+		}
+	}
+}
+
+// TestDiagnosticSpan tests that the underline covers the token and keeps tabs
+func TestDiagnosticSpan(t *testing.T) {
+	err := CompilerError{
+		Level:    LevelError,
+		Message:  "unknown name",
+		Location: SourceLocation{File: "a.c67", Line: 3, Column: 7, Length: 5},
+		Context:  ErrorContext{SourceLine: "\tx := hello + 1"},
+	}
+	got := err.Format(false)
+	if !strings.Contains(got, "  | \t     ^^^^^\n") {
+		t.Errorf("Expected the underline to line up with 'hello', got:\n%s", got)
+	}
+}
+
+// TestUnclosedBracketNote tests the note that points at a bracket left open
+func TestUnclosedBracketNote(t *testing.T) {
+	source := "main = {\n    xs := [1, 2\n    println(xs)\n"
+	p := NewParser(source)
+	func() {
+		defer func() { recover() }()
+		p.ParseProgram()
+	}()
+	report := p.errors.Report(false)
+	if !strings.Contains(report, "note: '[' opened here is never closed\n  --> <input>:2:11\n") {
+		t.Errorf("Expected a note about the unclosed '[', got:\n%s", report)
+	}
+}
+
+// TestColorMode tests --color and NO_COLOR
+func TestColorMode(t *testing.T) {
+	saved := ColorMode
+	defer func() { ColorMode = saved }()
+
+	ColorMode = "always"
+	if !useColor() {
+		t.Errorf("Expected color with --color always")
+	}
+	ColorMode = "auto"
+	t.Setenv("NO_COLOR", "1")
+	if useColor() {
+		t.Errorf("Expected no color in auto mode when NO_COLOR is set")
+	}
+}
@@ -0,0 +1,302 @@
+// Completion: 100% - c67 doc
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// doc.go - Documentation extracted from a module
+//
+// A "## text" comment on the lines right above a module-level definition is
+// its doc comment, which the parser keeps on the AssignStmt or CStructDecl:
+//
+//	## add returns the sum of a and b
+//	add = (a, b) -> a + b
+//
+// `c67 doc file.c67` lists the exported functions with their arities, the
+// constants and cstructs of the file, and the C libraries it imports with
+// the functions it calls from them, as text, markdown or HTML. Every name
+// is exported unless the file has an `export` list that leaves it out. main
+// and mutable variables are not part of the API.
+
+// DocEntry documents one module-level definition
+type DocEntry struct {
+	Kind      string // "function", "constant" or "cstruct"
+	Name      string
+	Doc       string
+	Signature string // add(a, b) for functions, the value for constants
+	Arities   []string
+	Fields    []CStructField
+}
+
+// CImportDoc documents a C library import and what the module calls from it
+type CImportDoc struct {
+	Library   string
+	Alias     string
+	Functions []string
+}
+
+// ModuleDoc is the documentation of a source file
+type ModuleDoc struct {
+	File     string
+	Entries  []DocEntry
+	CImports []CImportDoc
+}
+
+// documentFile parses a source file and extracts its documentation
+func documentFile(filename string) (doc *ModuleDoc, err error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: %v", filename, r)
+		}
+	}()
+	usedFiles = newUseState()
+	parser := NewParserWithFilename(string(content), filename)
+	program := parser.ParseProgram()
+	return extractDocs(program, string(content), filename), nil
+}
+
+// extractDocs collects the documentation of the definitions in program
+func extractDocs(program *Program, source, filename string) *ModuleDoc {
+	exported := make(map[string]bool)
+	for _, name := range program.ExportedFuncs {
+		exported[name] = true
+	}
+	isExported := func(name string) bool {
+		return program.ExportMode == "*" || len(exported) == 0 || exported[name]
+	}
+
+	doc := &ModuleDoc{File: filename}
+	for _, stmt := range program.Statements {
+		switch s := stmt.(type) {
+		case *AssignStmt:
+			if s.Name == "main" || s.Mutable || s.IsUpdate || !isExported(s.Name) {
+				continue
+			}
+			entry := DocEntry{Kind: "constant", Name: s.Name, Doc: s.Doc}
+			if arities, params := lambdaArities(s.Value); arities != nil {
+				entry.Kind = "function"
+				entry.Arities = arities
+				entry.Signature = s.Name + "(" + params + ")"
+			} else {
+				entry.Signature = s.Value.String()
+			}
+			doc.Entries = append(doc.Entries, entry)
+		case *CStructDecl:
+			if !isExported(s.Name) {
+				continue
+			}
+			doc.Entries = append(doc.Entries, DocEntry{
+				Kind:      "cstruct",
+				Name:      s.Name,
+				Doc:       s.Doc,
+				Signature: fmt.Sprintf("cstruct %s (%d bytes)", s.Name, s.Size),
+				Fields:    s.Fields,
+			})
+		case *CImportStmt:
+			doc.CImports = append(doc.CImports, CImportDoc{
+				Library:   s.Library,
+				Alias:     s.Alias,
+				Functions: cFunctionsCalled(source, s.Alias),
+			})
+		}
+	}
+	return doc
+}
+
+// lambdaArities returns the arities a function value can be called with and
+// its parameters, or nil if expr is not a lambda
+func lambdaArities(expr Expression) ([]string, string) {
+	switch e := expr.(type) {
+	case *LambdaExpr:
+		params := append([]string{}, e.Params...)
+		arity := strconv.Itoa(len(e.Params))
+		if e.VariadicParam != "" {
+			params = append(params, e.VariadicParam+"...")
+			arity += "+"
+		}
+		return []string{arity}, strings.Join(params, ", ")
+	case *MultiLambdaExpr:
+		var arities, params []string
+		for _, lambda := range e.Lambdas {
+			a, p := lambdaArities(lambda)
+			arities = append(arities, a...)
+			params = append(params, p)
+		}
+		return arities, strings.Join(params, " | ")
+	case *PatternLambdaExpr:
+		seen := make(map[int]bool)
+		var arities []string
+		for _, clause := range e.Clauses {
+			if !seen[len(clause.Patterns)] {
+				seen[len(clause.Patterns)] = true
+				arities = append(arities, strconv.Itoa(len(clause.Patterns)))
+			}
+		}
+		return arities, "..."
+	}
+	return nil, ""
+}
+
+// cFunctionsCalled returns the sorted names of the functions that source
+// calls as alias.name(...)
+func cFunctionsCalled(source, alias string) []string {
+	re := regexp.MustCompile(`\b` + regexp.QuoteMeta(alias) + `\.([A-Za-z_][A-Za-z0-9_]*)\s*\(`)
+	seen := make(map[string]bool)
+	var names []string
+	for _, m := range re.FindAllStringSubmatch(source, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// docSections groups the entries by kind, in the order they are rendered
+func (d *ModuleDoc) docSections() [][]DocEntry {
+	var functions, constants, cstructs []DocEntry
+	for _, e := range d.Entries {
+		switch e.Kind {
+		case "function":
+			functions = append(functions, e)
+		case "constant":
+			constants = append(constants, e)
+		case "cstruct":
+			cstructs = append(cstructs, e)
+		}
+	}
+	return [][]DocEntry{functions, constants, cstructs}
+}
+
+var docSectionTitles = []string{"Functions", "Constants", "C Structs"}
+
+// Text renders the documentation as plain text
+func (d *ModuleDoc) Text() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "%s\n", d.File)
+	for i, entries := range d.docSections() {
+		if len(entries) == 0 {
+			continue
+		}
+		fmt.Fprintf(&out, "\n%s\n", strings.ToUpper(docSectionTitles[i]))
+		for _, e := range entries {
+			fmt.Fprintf(&out, "\n    %s\n", e.heading())
+			for _, f := range e.Fields {
+				fmt.Fprintf(&out, "        %s as %s  (offset %d)\n", f.Name, f.Type, f.Offset)
+			}
+			if e.Doc != "" {
+				for _, line := range strings.Split(e.Doc, "\n") {
+					fmt.Fprintf(&out, "        %s\n", line)
+				}
+			}
+		}
+	}
+	if len(d.CImports) > 0 {
+		out.WriteString("\nC IMPORTS\n\n")
+		for _, c := range d.CImports {
+			fmt.Fprintf(&out, "    %s as %s: %s\n", c.Library, c.Alias, c.functionList())
+		}
+	}
+	return out.String()
+}
+
+// Markdown renders the documentation as markdown
+func (d *ModuleDoc) Markdown() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "# %s\n", d.File)
+	for i, entries := range d.docSections() {
+		if len(entries) == 0 {
+			continue
+		}
+		fmt.Fprintf(&out, "\n## %s\n", docSectionTitles[i])
+		for _, e := range entries {
+			fmt.Fprintf(&out, "\n### %s\n\n`%s`\n", e.Name, e.heading())
+			if len(e.Fields) > 0 {
+				out.WriteString("\n| Field | Type | Offset |\n|---|---|---|\n")
+				for _, f := range e.Fields {
+					fmt.Fprintf(&out, "| %s | %s | %d |\n", f.Name, f.Type, f.Offset)
+				}
+			}
+			if e.Doc != "" {
+				fmt.Fprintf(&out, "\n%s\n", e.Doc)
+			}
+		}
+	}
+	if len(d.CImports) > 0 {
+		out.WriteString("\n## C Imports\n\n")
+		for _, c := range d.CImports {
+			fmt.Fprintf(&out, "- `%s` as `%s`: %s\n", c.Library, c.Alias, c.functionList())
+		}
+	}
+	return out.String()
+}
+
+// HTML renders the documentation as a standalone HTML page
+func (d *ModuleDoc) HTML() string {
+	var out strings.Builder
+	file := html.EscapeString(d.File)
+	fmt.Fprintf(&out, "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>%s</title></head>\n<body>\n<h1>%s</h1>\n", file, file)
+	for i, entries := range d.docSections() {
+		if len(entries) == 0 {
+			continue
+		}
+		fmt.Fprintf(&out, "<h2>%s</h2>\n", docSectionTitles[i])
+		for _, e := range entries {
+			fmt.Fprintf(&out, "<h3 id=\"%s\">%s</h3>\n<pre>%s</pre>\n", html.EscapeString(e.Name), html.EscapeString(e.Name), html.EscapeString(e.heading()))
+			if len(e.Fields) > 0 {
+				out.WriteString("<table>\n<tr><th>Field</th><th>Type</th><th>Offset</th></tr>\n")
+				for _, f := range e.Fields {
+					fmt.Fprintf(&out, "<tr><td>%s</td><td>%s</td><td>%d</td></tr>\n", html.EscapeString(f.Name), html.EscapeString(f.Type), f.Offset)
+				}
+				out.WriteString("</table>\n")
+			}
+			if e.Doc != "" {
+				fmt.Fprintf(&out, "<p>%s</p>\n", strings.ReplaceAll(html.EscapeString(e.Doc), "\n", "<br>\n"))
+			}
+		}
+	}
+	if len(d.CImports) > 0 {
+		out.WriteString("<h2>C Imports</h2>\n<ul>\n")
+		for _, c := range d.CImports {
+			fmt.Fprintf(&out, "<li><code>%s</code> as <code>%s</code>: %s</li>\n", html.EscapeString(c.Library), html.EscapeString(c.Alias), html.EscapeString(c.functionList()))
+		}
+		out.WriteString("</ul>\n")
+	}
+	out.WriteString("</body>\n</html>\n")
+	return out.String()
+}
+
+// heading is the one-line summary of an entry
+func (e DocEntry) heading() string {
+	switch e.Kind {
+	case "function":
+		noun := "arity"
+		if len(e.Arities) > 1 {
+			noun = "arities"
+		}
+		return fmt.Sprintf("%s  [%s %s]", e.Signature, noun, strings.Join(e.Arities, ", "))
+	case "constant":
+		return e.Name + " = " + e.Signature
+	}
+	return e.Signature
+}
+
+// functionList lists the C functions called, or says that none are
+func (c CImportDoc) functionList() string {
+	if len(c.Functions) == 0 {
+		return "no functions called"
+	}
+	return strings.Join(c.Functions, ", ")
+}
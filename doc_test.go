@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestDocComments tests that ## comments are kept on the definitions below them
+func TestDocComments(t *testing.T) {
+	source := `## The number of lives
+LIVES = 3
+
+## add returns the sum of a and b.
+## It works on any numbers.
+add = (a, b) -> a + b
+
+// An ordinary comment
+sub = (a, b) -> a - b
+
+cstruct Point {
+    x as int32,
+    y as int32
+}
+
+main = {
+    println(add(LIVES, 2))
+}
+`
+	program := NewParser(source).ParseProgram()
+	doc := extractDocs(program, source, "game.c67")
+	got := make(map[string]DocEntry)
+	for _, e := range doc.Entries {
+		got[e.Name] = e
+	}
+	if got["LIVES"].Kind != "constant" || got["LIVES"].Doc != "The number of lives" {
+		t.Errorf("LIVES: got %+v", got["LIVES"])
+	}
+	if got["add"].Doc != "add returns the sum of a and b.\nIt works on any numbers." || got["add"].Arities[0] != "2" {
+		t.Errorf("add: got %+v", got["add"])
+	}
+	if got["sub"].Doc != "" {
+		t.Errorf("sub should have no doc comment, got %q", got["sub"].Doc)
+	}
+	if got["Point"].Kind != "cstruct" || len(got["Point"].Fields) != 2 {
+		t.Errorf("Point: got %+v", got["Point"])
+	}
+	if _, ok := got["main"]; ok {
+		t.Errorf("main should not be documented")
+	}
+}
+
+// TestDocRender tests the markdown rendering, export lists and C import summaries
+func TestDocRender(t *testing.T) {
+	source := `import sdl3 as sdl
+export start
+
+## start opens the window
+start = (width, height, flags...) -> sdl.SDL_Init(0)
+stop = { sdl.SDL_Quit() }
+`
+	program := NewParser(source).ParseProgram()
+	md := extractDocs(program, source, "win.c67").Markdown()
+	for _, want := range []string{"`start(width, height, flags...)  [arity 2+]`", "start opens the window", "- `sdl3` as `sdl`: SDL_Init, SDL_Quit"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Expected markdown to contain %q, got:\n%s", want, md)
+		}
+	}
+	if strings.Contains(md, "### stop") {
+		t.Errorf("stop is not exported, got:\n%s", md)
+	}
+}
@@ -0,0 +1,1055 @@
+// Completion: 100% - Module complete
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// dtoa.go - Number to text conversion for println, print, printf %v and f-strings
+//
+// _c67_dtoa writes the shortest decimal string that reads back as the same
+// float64, in the spirit of Ryu: 0.1 prints as 0.1, 1/3 as 0.3333333333333333
+// and 0.1 + 0.2 as 0.30000000000000004. Whole numbers below 2^63 print as
+// integers, exactly as before.
+//
+// For p = 1, 2, ... 16 the helper rounds x to p significant digits d and
+// checks d * 10^k == x (k = position of the last digit). When d < 2^53 and
+// |k| <= 22 both d and 10^k are exact doubles, so this single multiplication
+// or division is correctly rounded and the check is exact (Clinger's fast
+// path). Most numbers that a program prints, 0.1 or 3.14, end there.
+//
+// Every other number takes the exact path, Steele & White's free-format
+// algorithm as refined by Burger & Dybvig: x and the halfway points to its
+// neighbours become integers R +- M over a scale S, with 10^k folded into S
+// or into R and M, and each digit is the quotient of 10 * R by S. Digits
+// stop as soon as the number read so far lies between the halfway points,
+// which makes the output the shortest that reads back as x, and the last
+// digit is rounded to the closer of the two candidates. The integers are
+// dtoaBigLimbs limbs of 64 bits in _dtoa_bignums, enough for
+// 4.9e-324 * 10^324 and 1.8e308 * 10^dtoaMaxPrecision, so 1 / 7 prints as
+// 0.14285714285714285 and the largest double as 1.7976931348623157e+308.
+//
+// Formatting follows ECMAScript Number::toString:
+//
+//	1e-6 .. 1e21     fixed notation   0.000001, 1.5, 100000000000000000000
+//	otherwise        exponent         1e-7, 1.5e+300
+//	NaN, Inf, -Inf   as written
+//
+// except that -0 keeps its sign, as in Go.
+//
+// _c67_dtoa_fixed prints exactly p digits after the decimal point and is
+// used for f-string precision specifiers such as {x:.2}, for p up to
+// dtoaMaxPrecision. The digits are those of |x| * 10^p rounded half to even:
+// in 64-bit registers while that fits, otherwise with the integers of the
+// exact path, so {1/3:.20} prints 0.33333333333333331483 and {1e300:.2}
+// all 301 digits before the point.
+//
+// Both helpers return rsi = text, rdx = length (the same contract as
+// _c67_itoa) and write into the shared _dtoa_buffer.
+
+const (
+	dtoaMaxDigits    = 17  // significant digits that identify every float64
+	dtoaExactPow10   = 22  // largest k with 10^k exactly representable
+	dtoaMaxPrecision = 100 // most digits after the point in {x:.N}
+	dtoaBufferSize   = 416 // longest output, -1.8e308 with 100 decimals, is 411 bytes
+	dtoaBigLimbs     = 22  // 64-bit limbs of each integer of the exact path
+	dtoaChunk        = 19  // decimal digits per limb when printing an integer
+)
+
+// Offsets of the integers of the exact path in _dtoa_bignums
+const (
+	dtoaBigR      = iota * dtoaBigLimbs * 8 // remainder, x * 2 / gap
+	dtoaBigS                                // scale
+	dtoaBigMPlus                            // distance to the halfway point above
+	dtoaBigMMinus                           // distance to the halfway point below
+	dtoaBigT                                // scratch for sums
+	dtoaBigSize
+)
+
+// generateDtoa generates _c67_dtoa, _c67_dtoa_fixed and their subroutines.
+// _dtoa_buffer and _dtoa_bignums are defined with the other writable data
+// in Compile.
+func (fc *C67Compiler) generateDtoa() {
+	fc.generateDtoaScale("_c67_dtoa_div10k", true)
+	fc.generateDtoaScale("_c67_dtoa_mul10k", false)
+	fc.generateDtoaDigits()
+	fc.generateDtoaBig()
+	fc.generateDtoaShortest()
+	fc.generateDtoaFixed()
+}
+
+// dtoaLoadConst loads a float64 constant into an XMM register through rax
+func (fc *C67Compiler) dtoaLoadConst(xmm string, value float64) {
+	fc.out.MovImmToReg("rax", fmt.Sprintf("%d", math.Float64bits(value)))
+	fc.out.MovqRegToXmm(xmm, "rax")
+}
+
+// generateDtoaScale generates label: xmm0 = xmm0 / 10^rcx (divide) or xmm0 * 10^rcx.
+// A negative rcx swaps the operation, so 10^|rcx| is always built exactly
+// when |rcx| <= 22. Preserves rcx; clobbers rax, xmm1 and xmm2.
+func (fc *C67Compiler) generateDtoaScale(label string, divide bool) {
+	fc.eb.MarkFunction(label)
+	fc.dtoaLoadConst("xmm1", 1.0)
+	fc.dtoaLoadConst("xmm2", 10.0)
+
+	fc.out.MovRegToReg("rax", "rcx")
+	fc.out.TestRegReg("rax", "rax")
+	positive := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.NegReg("rax")
+	fc.landForwardJump(positive)
+
+	powLoop := fc.eb.text.Len()
+	fc.out.TestRegReg("rax", "rax")
+	powDone := fc.forwardJump(JumpEqual)
+	fc.out.MulsdXmm("xmm1", "xmm2")
+	fc.out.DecReg("rax")
+	fc.out.JumpUnconditional(int32(powLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(powDone)
+
+	fc.out.TestRegReg("rcx", "rcx")
+	negative := fc.forwardJump(JumpLess)
+	if divide {
+		fc.out.DivsdXmm("xmm0", "xmm1")
+	} else {
+		fc.out.MulsdXmm("xmm0", "xmm1")
+	}
+	fc.out.Ret()
+	fc.landForwardJump(negative)
+	if divide {
+		fc.out.MulsdXmm("xmm0", "xmm1")
+	} else {
+		fc.out.DivsdXmm("xmm0", "xmm1")
+	}
+	fc.out.Ret()
+}
+
+// generateDtoaDigits generates _c67_dtoa_digits: writes rax (unsigned) in
+// decimal at rbx, zero-padded to at least rsi digits, and advances rbx.
+// Clobbers rax, rcx, rdx, rdi, r9 and r10.
+func (fc *C67Compiler) generateDtoaDigits() {
+	fc.eb.MarkFunction("_c67_dtoa_digits")
+	fc.out.MovRegToReg("r9", "rax")
+	fc.out.XorRegWithReg("rcx", "rcx")
+	fc.out.MovImmToReg("r10", "10")
+
+	countLoop := fc.eb.text.Len()
+	fc.out.Emit([]byte{0x31, 0xd2})       // xor edx, edx
+	fc.out.Emit([]byte{0x49, 0xf7, 0xf2}) // div r10
+	fc.out.IncReg("rcx")
+	fc.out.TestRegReg("rax", "rax")
+	fc.out.JumpConditional(JumpNotEqual, int32(countLoop-(fc.eb.text.Len()+ConditionalJumpSize)))
+
+	fc.out.CmpRegToReg("rcx", "rsi")
+	wideEnough := fc.forwardJump(JumpAboveOrEqual)
+	fc.out.MovRegToReg("rcx", "rsi")
+	fc.landForwardJump(wideEnough)
+
+	fc.out.MovRegToReg("rax", "r9")
+	fc.out.Emit([]byte{0x48, 0x8d, 0x3c, 0x0b}) // lea rdi, [rbx + rcx]
+	fc.out.AddRegToReg("rbx", "rcx")
+
+	writeLoop := fc.eb.text.Len()
+	fc.out.Emit([]byte{0x31, 0xd2})       // xor edx, edx
+	fc.out.Emit([]byte{0x49, 0xf7, 0xf2}) // div r10
+	fc.out.Emit([]byte{0x80, 0xc2, 0x30}) // add dl, '0'
+	fc.out.DecReg("rdi")
+	fc.out.Emit([]byte{0x88, 0x17}) // mov [rdi], dl
+	fc.out.DecReg("rcx")
+	fc.out.JumpConditional(JumpNotEqual, int32(writeLoop-(fc.eb.text.Len()+ConditionalJumpSize)))
+	fc.out.Ret()
+}
+
+// emitDtoaPrologue saves registers and points rbx and r8 at _dtoa_buffer.
+// Locals: [rsp] = 1 if the mantissa is even (exact path), [rsp+8] = |x|.
+func (fc *C67Compiler) emitDtoaPrologue() {
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+	fc.out.SubImmFromReg("rsp", 16)
+	fc.out.LeaSymbolToReg("rbx", "_dtoa_buffer")
+	fc.out.MovRegToReg("r8", "rbx")
+}
+
+// emitDtoaEpilogue returns rsi = r8 (text start), rdx = rbx - r8 (length)
+func (fc *C67Compiler) emitDtoaEpilogue() {
+	fc.out.MovRegToReg("rsi", "r8")
+	fc.out.MovRegToReg("rdx", "rbx")
+	fc.out.SubRegFromReg("rdx", "r8")
+	fc.out.AddImmToReg("rsp", 16)
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.Ret()
+}
+
+// emitDtoaSpecials writes NaN, Inf and (if zeroAsInteger) 0 and -0 directly,
+// and the sign of any other value. Returns the jumps that go to the epilogue.
+// Afterwards xmm0 = |x|.
+func (fc *C67Compiler) emitDtoaSpecials(zeroAsInteger bool) []int {
+	var done []int
+
+	fc.out.MovqXmmToReg("rax", "xmm0")
+	fc.out.MovRegToReg("rcx", "rax")
+	fc.out.ShlRegByImm("rcx", 1) // drop the sign bit
+
+	if zeroAsInteger {
+		nonZero := fc.forwardJump(JumpNotEqual)
+		fc.out.TestRegReg("rax", "rax")
+		positiveZero := fc.forwardJump(JumpGreaterOrEqual)
+		fc.out.Emit([]byte{0xc6, 0x03, '-'}) // mov byte [rbx], '-'
+		fc.out.IncReg("rbx")
+		fc.landForwardJump(positiveZero)
+		fc.out.Emit([]byte{0xc6, 0x03, '0'}) // mov byte [rbx], '0'
+		fc.out.IncReg("rbx")
+		done = append(done, fc.forwardJumpAlways())
+		fc.landForwardJump(nonZero)
+	}
+
+	fc.out.MovImmToReg("rdx", "0xFFE0000000000000") // exponent all ones, shifted
+	fc.out.CmpRegToReg("rcx", "rdx")
+	finite := fc.forwardJump(JumpBelow)
+	isNaN := fc.forwardJump(JumpAbove)
+
+	fc.out.TestRegReg("rax", "rax")
+	positiveInf := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.Emit([]byte{0xc6, 0x03, '-'}) // mov byte [rbx], '-'
+	fc.out.IncReg("rbx")
+	fc.landForwardJump(positiveInf)
+	fc.out.Emit([]byte{0xc7, 0x03, 'I', 'n', 'f', 0}) // mov dword [rbx], "Inf"
+	fc.out.AddImmToReg("rbx", 3)
+	done = append(done, fc.forwardJumpAlways())
+
+	fc.landForwardJump(isNaN)
+	fc.out.Emit([]byte{0xc7, 0x03, 'N', 'a', 'N', 0}) // mov dword [rbx], "NaN"
+	fc.out.AddImmToReg("rbx", 3)
+	done = append(done, fc.forwardJumpAlways())
+
+	fc.landForwardJump(finite)
+	fc.out.TestRegReg("rax", "rax")
+	positive := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.Emit([]byte{0xc6, 0x03, '-'}) // mov byte [rbx], '-'
+	fc.out.IncReg("rbx")
+	fc.out.Emit([]byte{0x48, 0x0f, 0xba, 0xf0, 0x3f}) // btr rax, 63
+	fc.out.MovqRegToXmm("xmm0", "rax")
+	fc.landForwardJump(positive)
+
+	return done
+}
+
+// emitDtoaInsertDot shifts [rdi, rbx) one byte right, stores '.' at rdi and advances rbx
+func (fc *C67Compiler) emitDtoaInsertDot() {
+	fc.out.MovRegToReg("rsi", "rbx")
+	shiftLoop := fc.eb.text.Len()
+	fc.out.CmpRegToReg("rsi", "rdi")
+	shifted := fc.forwardJump(JumpBelowOrEqual)
+	fc.out.Emit([]byte{0x8a, 0x46, 0xff}) // mov al, [rsi-1]
+	fc.out.Emit([]byte{0x88, 0x06})       // mov [rsi], al
+	fc.out.DecReg("rsi")
+	fc.out.JumpUnconditional(int32(shiftLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(shifted)
+	fc.out.Emit([]byte{0xc6, 0x07, '.'}) // mov byte [rdi], '.'
+	fc.out.IncReg("rbx")
+}
+
+// emitDtoaZeros writes rcx (> 0) '0' characters at rbx
+func (fc *C67Compiler) emitDtoaZeros() {
+	zeroLoop := fc.eb.text.Len()
+	fc.out.Emit([]byte{0xc6, 0x03, '0'}) // mov byte [rbx], '0'
+	fc.out.IncReg("rbx")
+	fc.out.DecReg("rcx")
+	fc.out.JumpConditional(JumpNotEqual, int32(zeroLoop-(fc.eb.text.Len()+ConditionalJumpSize)))
+}
+
+// emitDtoaWriteDigits writes r14 at rbx with at least minWidth digits
+func (fc *C67Compiler) emitDtoaWriteDigits(minWidthReg string) {
+	fc.out.MovRegToReg("rax", "r14")
+	if minWidthReg != "rsi" {
+		fc.out.MovRegToReg("rsi", minWidthReg)
+	}
+	fc.out.CallSymbol("_c67_dtoa_digits")
+}
+
+// generateDtoaShortest generates _c67_dtoa(xmm0) -> rsi = text, rdx = length.
+// Clobbers rax, rcx, rdi, r8, r9, r10 and xmm0-xmm2.
+func (fc *C67Compiler) generateDtoaShortest() {
+	fc.eb.MarkFunction("_c67_dtoa")
+	fc.emitDtoaPrologue()
+	done := fc.emitDtoaSpecials(true)
+	fc.out.MovXmmToMem("xmm0", "rsp", 8)
+
+	// Whole numbers below 2^63 print as integers
+	fc.dtoaLoadConst("xmm1", 9223372036854775808.0)
+	fc.out.Ucomisd("xmm0", "xmm1")
+	tooBig := fc.forwardJump(JumpAboveOrEqual)
+	fc.out.Cvttsd2si("rax", "xmm0")
+	fc.out.Cvtsi2sd("xmm1", "rax")
+	fc.out.Ucomisd("xmm0", "xmm1")
+	fraction := fc.forwardJump(JumpNotEqual)
+	fc.out.MovImmToReg("rsi", "1")
+	fc.out.CallSymbol("_c67_dtoa_digits")
+	done = append(done, fc.forwardJumpAlways())
+	fc.landForwardJump(tooBig)
+	fc.landForwardJump(fraction)
+
+	// r12 = floor(log10(x)): estimate from the binary exponent, then correct
+	// by one. The fast path can only check numbers from about 1e-21 to 1e37.
+	var exact []int
+	fc.out.MovqXmmToReg("rax", "xmm0")
+	fc.out.ShrRegByImm("rax", 52)
+	fc.out.SubImmFromReg("rax", 1023)
+	fc.out.ImulImmToReg("rax", 78913)           // log10(2) * 2^18
+	fc.out.Emit([]byte{0x48, 0xc1, 0xf8, 0x12}) // sar rax, 18
+	fc.out.MovRegToReg("r12", "rax")
+	fc.out.CmpRegToImm("r12", 1-dtoaExactPow10)
+	exact = append(exact, fc.forwardJump(JumpLess))
+	fc.out.CmpRegToImm("r12", dtoaExactPow10+dtoaMaxDigits-3)
+	exact = append(exact, fc.forwardJump(JumpGreater))
+	fc.out.MovRegToReg("rcx", "r12")
+	fc.out.IncReg("rcx")
+	fc.out.CallSymbol("_c67_dtoa_div10k")
+	fc.dtoaLoadConst("xmm1", 1.0)
+	fc.out.Ucomisd("xmm0", "xmm1")
+	estimateOk := fc.forwardJump(JumpBelow)
+	fc.out.IncReg("r12")
+	fc.landForwardJump(estimateOk)
+
+	// r13 = p, r14 = x rounded to p significant digits
+	fc.out.MovImmToReg("r13", "1")
+	precisionLoop := fc.eb.text.Len()
+	fc.out.MovRegToReg("rcx", "r12")
+	fc.out.SubRegFromReg("rcx", "r13")
+	fc.out.IncReg("rcx") // k = e10 - p + 1
+	fc.out.MovMemToXmm("xmm0", "rsp", 8)
+	fc.out.CallSymbol("_c67_dtoa_div10k")
+	fc.out.Emit([]byte{0xf2, 0x4c, 0x0f, 0x2d, 0xf0}) // cvtsd2si r14, xmm0 (round to nearest)
+	fc.out.CmpRegToImm("r13", dtoaMaxDigits)
+	exact = append(exact, fc.forwardJump(JumpGreaterOrEqual))
+
+	// Only check d * 10^k == x where the check is exact
+	var next []int
+	fc.out.CmpRegToImm("rcx", dtoaExactPow10)
+	next = append(next, fc.forwardJump(JumpGreater))
+	fc.out.CmpRegToImm("rcx", -dtoaExactPow10)
+	next = append(next, fc.forwardJump(JumpLess))
+	fc.out.MovImmToReg("rax", fmt.Sprintf("%d", int64(1)<<53))
+	fc.out.CmpRegToReg("r14", "rax")
+	next = append(next, fc.forwardJump(JumpAboveOrEqual))
+	fc.out.Cvtsi2sd("xmm0", "r14")
+	fc.out.CallSymbol("_c67_dtoa_mul10k")
+	fc.out.MovMemToXmm("xmm1", "rsp", 8)
+	fc.out.Ucomisd("xmm0", "xmm1")
+	found := fc.forwardJump(JumpEqual)
+	for _, pos := range next {
+		fc.landForwardJump(pos)
+	}
+	fc.out.IncReg("r13")
+	fc.out.JumpUnconditional(int32(precisionLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	for _, pos := range exact {
+		fc.landForwardJump(pos)
+	}
+	fc.emitDtoaExact()
+	fc.landForwardJump(found)
+
+	// Rounding up may carry into a new digit (9.99... -> 10)
+	fc.out.MovImmToReg("rax", "1")
+	fc.out.MovRegToReg("rcx", "r13")
+	powLoop := fc.eb.text.Len()
+	fc.out.ImulImmToReg("rax", 10)
+	fc.out.DecReg("rcx")
+	fc.out.JumpConditional(JumpNotEqual, int32(powLoop-(fc.eb.text.Len()+ConditionalJumpSize)))
+	fc.out.CmpRegToReg("r14", "rax")
+	noCarry := fc.forwardJump(JumpBelow)
+	fc.out.MovRegToReg("rax", "r14")
+	fc.out.MovImmToReg("r10", "10")
+	fc.out.Emit([]byte{0x31, 0xd2})       // xor edx, edx
+	fc.out.Emit([]byte{0x49, 0xf7, 0xf2}) // div r10
+	fc.out.MovRegToReg("r14", "rax")
+	fc.out.IncReg("r12")
+	fc.landForwardJump(noCarry)
+
+	// Drop trailing zeros; r13 = number of digits
+	fc.out.MovImmToReg("r10", "10")
+	stripLoop := fc.eb.text.Len()
+	fc.out.CmpRegToImm("r13", 1)
+	stripped := fc.forwardJump(JumpLessOrEqual)
+	fc.out.MovRegToReg("rax", "r14")
+	fc.out.Emit([]byte{0x31, 0xd2})       // xor edx, edx
+	fc.out.Emit([]byte{0x49, 0xf7, 0xf2}) // div r10
+	fc.out.TestRegReg("rdx", "rdx")
+	lastDigit := fc.forwardJump(JumpNotEqual)
+	fc.out.MovRegToReg("r14", "rax")
+	fc.out.DecReg("r13")
+	fc.out.JumpUnconditional(int32(stripLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(stripped)
+	fc.landForwardJump(lastDigit)
+
+	// Choose the notation from the decimal exponent r12
+	fc.out.CmpRegToImm("r12", 21)
+	exponent1 := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.CmpRegToImm("r12", -6)
+	exponent2 := fc.forwardJump(JumpLess)
+	fc.out.CmpRegToImm("r12", 0)
+	belowOne := fc.forwardJump(JumpLess)
+
+	// 1 <= x < 1e21: digits, then trailing zeros or a decimal point
+	fc.out.MovImmToReg("rsi", "1")
+	fc.emitDtoaWriteDigits("rsi")
+	fc.out.MovRegToReg("rcx", "r12")
+	fc.out.IncReg("rcx")
+	fc.out.SubRegFromReg("rcx", "r13") // integer digits not covered by r14
+	noZeros := fc.forwardJump(JumpLessOrEqual)
+	fc.emitDtoaZeros()
+	done = append(done, fc.forwardJumpAlways())
+	fc.landForwardJump(noZeros)
+	done = append(done, fc.forwardJump(JumpEqual))
+	fc.out.MovRegToReg("rdi", "rbx")
+	fc.out.SubRegFromReg("rdi", "r13")
+	fc.out.AddRegToReg("rdi", "r12")
+	fc.out.IncReg("rdi")
+	fc.emitDtoaInsertDot()
+	done = append(done, fc.forwardJumpAlways())
+
+	// 1e-6 <= x < 1: "0.", leading zeros, digits
+	fc.landForwardJump(belowOne)
+	fc.out.Emit([]byte{0x66, 0xc7, 0x03, '0', '.'}) // mov word [rbx], "0."
+	fc.out.AddImmToReg("rbx", 2)
+	fc.out.MovRegToReg("rcx", "r12")
+	fc.out.IncReg("rcx")
+	fc.out.NegReg("rcx")
+	noLeadingZeros := fc.forwardJump(JumpEqual)
+	fc.emitDtoaZeros()
+	fc.landForwardJump(noLeadingZeros)
+	fc.out.MovImmToReg("rsi", "1")
+	fc.emitDtoaWriteDigits("rsi")
+	done = append(done, fc.forwardJumpAlways())
+
+	// Exponent notation: d.ddde+XX
+	fc.landForwardJump(exponent1)
+	fc.landForwardJump(exponent2)
+	fc.out.MovImmToReg("rsi", "1")
+	fc.emitDtoaWriteDigits("rsi")
+	fc.out.CmpRegToImm("r13", 1)
+	singleDigit := fc.forwardJump(JumpLessOrEqual)
+	fc.out.MovRegToReg("rdi", "rbx")
+	fc.out.SubRegFromReg("rdi", "r13")
+	fc.out.IncReg("rdi")
+	fc.emitDtoaInsertDot()
+	fc.landForwardJump(singleDigit)
+	fc.out.Emit([]byte{0x66, 0xc7, 0x03, 'e', '+'}) // mov word [rbx], "e+"
+	fc.out.AddImmToReg("rbx", 2)
+	fc.out.MovRegToReg("rax", "r12")
+	fc.out.TestRegReg("rax", "rax")
+	positiveExp := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.Emit([]byte{0xc6, 0x43, 0xff, '-'}) // mov byte [rbx-1], '-'
+	fc.out.NegReg("rax")
+	fc.landForwardJump(positiveExp)
+	fc.out.MovImmToReg("rsi", "1")
+	fc.out.CallSymbol("_c67_dtoa_digits")
+
+	for _, pos := range done {
+		fc.landForwardJump(pos)
+	}
+	fc.emitDtoaEpilogue()
+}
+
+// dtoaBig points reg at the integer at offset in _dtoa_bignums
+func (fc *C67Compiler) dtoaBig(reg string, offset int) {
+	fc.out.LeaSymbolToReg(reg, "_dtoa_bignums")
+	if offset != 0 {
+		fc.out.AddImmToReg(reg, int64(offset))
+	}
+}
+
+// dtoaBigCall calls the big integer subroutine label with rdi = dst and, if
+// src >= 0, rsi = src
+func (fc *C67Compiler) dtoaBigCall(label string, dst, src int) {
+	fc.dtoaBig("rdi", dst)
+	if src >= 0 {
+		fc.dtoaBig("rsi", src)
+	}
+	fc.out.CallSymbol(label)
+}
+
+// dtoaBigMulImm multiplies the integer at dst by m
+func (fc *C67Compiler) dtoaBigMulImm(dst int, m int64) {
+	fc.out.MovImmToReg("rax", fmt.Sprintf("%d", m))
+	fc.dtoaBigCall("_c67_dtoa_big_mul", dst, -1)
+}
+
+// generateDtoaBig generates the subroutines for the integers of the exact
+// path, which all have dtoaBigLimbs limbs, least significant first. None of
+// them touch r8, r11 or the callee-saved registers.
+func (fc *C67Compiler) generateDtoaBig() {
+	// _c67_dtoa_big_set(rdi = dst, rax = v, rcx = n): dst = v * 2^n, for
+	// v < 2^64 and n < 64 * (dtoaBigLimbs - 1). Clobbers rax, rcx, rdx, rdi,
+	// r9 and r10.
+	fc.eb.MarkFunction("_c67_dtoa_big_set")
+	fc.out.MovRegToReg("r9", "rax")
+	fc.out.MovRegToReg("r10", "rcx")
+	fc.out.MovRegToReg("rdx", "rdi")
+	fc.out.XorRegWithReg("rax", "rax")
+	fc.out.MovImmToReg("rcx", fmt.Sprintf("%d", dtoaBigLimbs))
+	fc.out.Emit([]byte{0xf3, 0x48, 0xab}) // rep stosq
+	fc.out.MovRegToReg("rdi", "rdx")
+	fc.out.MovRegToReg("rcx", "r10")
+	fc.out.ShrRegByImm("rcx", 6)
+	fc.out.Emit([]byte{0x48, 0x8d, 0x3c, 0xcf}) // lea rdi, [rdi + rcx*8]
+	fc.out.MovRegToReg("rcx", "r10")
+	fc.out.AndRegWithImm("rcx", 63)
+	fc.out.MovRegToReg("rax", "r9")
+	fc.out.ShlRegByReg("rax", "rcx")
+	fc.out.MovRegToMem("rax", "rdi", 0)
+	fc.out.TestRegReg("rcx", "rcx")
+	aligned := fc.forwardJump(JumpEqual)
+	fc.out.NegReg("rcx") // shifts use cl mod 64, so this is 64 - n mod 64
+	fc.out.MovRegToReg("rax", "r9")
+	fc.out.ShrRegByReg("rax", "rcx")
+	fc.out.MovRegToMem("rax", "rdi", 8)
+	fc.landForwardJump(aligned)
+	fc.out.Ret()
+
+	// _c67_dtoa_big_mul(rdi = dst, rax = m): dst *= m. Clobbers rax, rcx,
+	// rdx, r9 and r10.
+	fc.eb.MarkFunction("_c67_dtoa_big_mul")
+	fc.out.MovRegToReg("r9", "rax")
+	fc.out.XorRegWithReg("r10", "r10") // carry
+	fc.out.XorRegWithReg("rcx", "rcx")
+	mulLoop := fc.eb.text.Len()
+	fc.out.Emit([]byte{0x48, 0x8b, 0x04, 0xcf}) // mov rax, [rdi + rcx*8]
+	fc.out.Emit([]byte{0x49, 0xf7, 0xe1})       // mul r9
+	fc.out.AddRegToReg("rax", "r10")
+	fc.out.Emit([]byte{0x48, 0x83, 0xd2, 0x00}) // adc rdx, 0
+	fc.out.Emit([]byte{0x48, 0x89, 0x04, 0xcf}) // mov [rdi + rcx*8], rax
+	fc.out.MovRegToReg("r10", "rdx")
+	fc.out.IncReg("rcx")
+	fc.out.CmpRegToImm("rcx", dtoaBigLimbs)
+	fc.out.JumpConditional(JumpBelow, int32(mulLoop-(fc.eb.text.Len()+ConditionalJumpSize)))
+	fc.out.Ret()
+
+	// _c67_dtoa_big_mul10k(rdi = dst, rsi = k >= 0): dst *= 10^k, 19 powers
+	// at a time. Clobbers rax, rcx, rdx, rsi, r9 and r10.
+	fc.eb.MarkFunction("_c67_dtoa_big_mul10k")
+	chunkLoop := fc.eb.text.Len()
+	fc.out.CmpRegToImm("rsi", 19)
+	lastChunk := fc.forwardJump(JumpLess)
+	fc.out.MovImmToReg("rax", "10000000000000000000")
+	fc.out.CallSymbol("_c67_dtoa_big_mul")
+	fc.out.SubImmFromReg("rsi", 19)
+	fc.out.JumpUnconditional(int32(chunkLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(lastChunk)
+	fc.out.MovImmToReg("rax", "1")
+	powLoop := fc.eb.text.Len()
+	fc.out.TestRegReg("rsi", "rsi")
+	powDone := fc.forwardJump(JumpEqual)
+	fc.out.ImulImmToReg("rax", 10)
+	fc.out.DecReg("rsi")
+	fc.out.JumpUnconditional(int32(powLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(powDone)
+	fc.out.CallSymbol("_c67_dtoa_big_mul")
+	fc.out.Ret()
+
+	// _c67_dtoa_big_cmp(rdi = a, rsi = b): sets the flags of an unsigned
+	// comparison of a with b. Clobbers rax and rcx.
+	fc.eb.MarkFunction("_c67_dtoa_big_cmp")
+	fc.out.MovImmToReg("rcx", fmt.Sprintf("%d", dtoaBigLimbs-1))
+	cmpLoop := fc.eb.text.Len()
+	fc.out.Emit([]byte{0x48, 0x8b, 0x04, 0xcf}) // mov rax, [rdi + rcx*8]
+	fc.out.Emit([]byte{0x48, 0x3b, 0x04, 0xce}) // cmp rax, [rsi + rcx*8]
+	differ := fc.forwardJump(JumpNotEqual)
+	fc.out.DecReg("rcx")
+	fc.out.JumpConditional(JumpGreaterOrEqual, int32(cmpLoop-(fc.eb.text.Len()+ConditionalJumpSize)))
+	fc.out.XorRegWithReg("rax", "rax") // equal: ZF set, CF clear
+	fc.landForwardJump(differ)
+	fc.out.Ret()
+
+	// _c67_dtoa_big_add and _c67_dtoa_big_sub(rdi = a, rsi = b): a += b and
+	// a -= b. inc and dec leave the carry flag alone. Clobbers rax, rcx and rdx.
+	for _, op := range []struct {
+		label  string
+		opcode byte
+	}{
+		{"_c67_dtoa_big_add", 0x11}, // adc
+		{"_c67_dtoa_big_sub", 0x19}, // sbb
+	} {
+		fc.eb.MarkFunction(op.label)
+		fc.out.XorRegWithReg("rdx", "rdx") // clears the carry flag
+		fc.out.MovImmToReg("rcx", fmt.Sprintf("%d", dtoaBigLimbs))
+		carryLoop := fc.eb.text.Len()
+		fc.out.Emit([]byte{0x48, 0x8b, 0x04, 0xd6})      // mov rax, [rsi + rdx*8]
+		fc.out.Emit([]byte{0x48, op.opcode, 0x04, 0xd7}) // adc/sbb [rdi + rdx*8], rax
+		fc.out.IncReg("rdx")
+		fc.out.DecReg("rcx")
+		fc.out.JumpConditional(JumpNotEqual, int32(carryLoop-(fc.eb.text.Len()+ConditionalJumpSize)))
+		fc.out.Ret()
+	}
+
+	// _c67_dtoa_big_copy(rdi = dst, rsi = src). Clobbers rcx, rdi and rsi.
+	fc.eb.MarkFunction("_c67_dtoa_big_copy")
+	fc.out.MovImmToReg("rcx", fmt.Sprintf("%d", dtoaBigLimbs))
+	fc.out.Emit([]byte{0xf3, 0x48, 0xa5}) // rep movsq
+	fc.out.Ret()
+
+	// _c67_dtoa_big_shr(rdi = dst, rcx = n): dst >>= n, for
+	// n < 64 * dtoaBigLimbs. Returns rax != 0 if any of the bits shifted out
+	// was set. Clobbers rcx, rdx, rsi, r9 and r10.
+	fc.eb.MarkFunction("_c67_dtoa_big_shr")
+	fc.out.MovRegToReg("r9", "rcx")
+	fc.out.ShrRegByImm("r9", 6) // whole limbs
+	fc.out.AndRegWithImm("rcx", 63)
+	fc.out.XorRegWithReg("rax", "rax")
+	fc.out.XorRegWithReg("rdx", "rdx")
+	stickyLoop := fc.eb.text.Len()
+	fc.out.CmpRegToReg("rdx", "r9")
+	stickyDone := fc.forwardJump(JumpAboveOrEqual)
+	fc.out.Emit([]byte{0x48, 0x0b, 0x04, 0xd7}) // or rax, [rdi + rdx*8]
+	fc.out.IncReg("rdx")
+	fc.out.JumpUnconditional(int32(stickyLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(stickyDone)
+	fc.out.MovImmToReg("r10", "1")
+	fc.out.ShlRegByReg("r10", "rcx")
+	fc.out.DecReg("r10")
+	fc.out.Emit([]byte{0x4e, 0x23, 0x14, 0xcf}) // and r10, [rdi + r9*8]
+	fc.out.OrRegWithReg("rax", "r10")
+
+	// rdx = source limb, rsi = destination
+	fc.out.MovRegToReg("rsi", "rdi")
+	shiftLoop := fc.eb.text.Len()
+	fc.out.CmpRegToImm("rdx", dtoaBigLimbs)
+	shiftDone := fc.forwardJump(JumpAboveOrEqual)
+	fc.out.Emit([]byte{0x4c, 0x8b, 0x14, 0xd7}) // mov r10, [rdi + rdx*8]
+	fc.out.XorRegWithReg("r9", "r9")
+	fc.out.IncReg("rdx")
+	fc.out.CmpRegToImm("rdx", dtoaBigLimbs)
+	top := fc.forwardJump(JumpAboveOrEqual)
+	fc.out.Emit([]byte{0x4c, 0x8b, 0x0c, 0xd7}) // mov r9, [rdi + rdx*8]
+	fc.landForwardJump(top)
+	fc.out.Emit([]byte{0x4d, 0x0f, 0xad, 0xca}) // shrd r10, r9, cl
+	fc.out.MovRegToMem("r10", "rsi", 0)
+	fc.out.AddImmToReg("rsi", 8)
+	fc.out.JumpUnconditional(int32(shiftLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(shiftDone)
+	fc.out.LeaMemToReg("r10", "rdi", dtoaBigLimbs*8)
+	zeroLoop := fc.eb.text.Len()
+	fc.out.CmpRegToReg("rsi", "r10")
+	zeroed := fc.forwardJump(JumpAboveOrEqual)
+	fc.out.Emit([]byte{0x48, 0xc7, 0x06, 0, 0, 0, 0}) // mov qword [rsi], 0
+	fc.out.AddImmToReg("rsi", 8)
+	fc.out.JumpUnconditional(int32(zeroLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(zeroed)
+	fc.out.Ret()
+
+	// _c67_dtoa_big_div(rdi = dst, rax = d): dst /= d. Returns rdx = the
+	// remainder and rax = 0 if the quotient is 0. Clobbers rcx, r9 and r10.
+	fc.eb.MarkFunction("_c67_dtoa_big_div")
+	fc.out.MovRegToReg("r9", "rax")
+	fc.out.XorRegWithReg("rdx", "rdx")
+	fc.out.XorRegWithReg("r10", "r10")
+	fc.out.MovImmToReg("rcx", fmt.Sprintf("%d", dtoaBigLimbs-1))
+	divLoop := fc.eb.text.Len()
+	fc.out.Emit([]byte{0x48, 0x8b, 0x04, 0xcf}) // mov rax, [rdi + rcx*8]
+	fc.out.Emit([]byte{0x49, 0xf7, 0xf1})       // div r9
+	fc.out.Emit([]byte{0x48, 0x89, 0x04, 0xcf}) // mov [rdi + rcx*8], rax
+	fc.out.OrRegWithReg("r10", "rax")
+	fc.out.DecReg("rcx")
+	fc.out.JumpConditional(JumpGreaterOrEqual, int32(divLoop-(fc.eb.text.Len()+ConditionalJumpSize)))
+	fc.out.MovRegToReg("rax", "r10")
+	fc.out.Ret()
+}
+
+// emitDtoaBigCmpSum compares the integer at a plus the one at b with the
+// one at c, through the scratch integer
+func (fc *C67Compiler) emitDtoaBigCmpSum(a, b, c int) {
+	fc.dtoaBigCall("_c67_dtoa_big_copy", dtoaBigT, a)
+	fc.dtoaBigCall("_c67_dtoa_big_add", dtoaBigT, b)
+	fc.dtoaBigCall("_c67_dtoa_big_cmp", dtoaBigT, c)
+}
+
+// emitDtoaBigBeyond returns jumps, after a comparison, that are taken when
+// the flags say beyond (JumpAbove or JumpBelow), or equal with an even
+// mantissa: a halfway point then rounds to x too, so it counts as inside
+func (fc *C67Compiler) emitDtoaBigBeyond(beyond JumpCondition) []int {
+	taken := []int{fc.forwardJump(beyond)}
+	notEqual := fc.forwardJump(JumpNotEqual)
+	fc.out.MovMemToReg("rax", "rsp", 0)
+	fc.out.TestRegReg("rax", "rax")
+	taken = append(taken, fc.forwardJump(JumpNotEqual))
+	fc.landForwardJump(notEqual)
+	return taken
+}
+
+// emitDtoaExact sets r14 = the shortest digits that read back as [rsp+8],
+// r13 = their count and r12 = the decimal exponent of the first digit.
+// Clobbers rax, rcx, rdx, rsi, rdi, r9 and r10.
+func (fc *C67Compiler) emitDtoaExact() {
+	// r13 = f, r12 = e with |x| = f * 2^e, [rsp] = 1 if f is even
+	fc.out.MovMemToReg("rax", "rsp", 8)
+	fc.emitDtoaUnpack()
+	fc.out.MovRegToReg("r13", "rax")
+	fc.out.MovRegToReg("r12", "rcx")
+	fc.out.AndRegWithImm("rax", 1)
+	fc.out.Emit([]byte{0x48, 0x83, 0xf0, 0x01}) // xor rax, 1
+	fc.out.MovRegToMem("rax", "rsp", 0)
+
+	// rsi = 1 if the gap below x is half the gap above, as it is for the
+	// powers of two except the smallest normal number
+	fc.out.XorRegWithReg("rsi", "rsi")
+	fc.out.MovImmToReg("rax", fmt.Sprintf("%d", uint64(1)<<52))
+	fc.out.CmpRegToReg("r13", "rax")
+	evenGaps := fc.forwardJump(JumpNotEqual)
+	fc.out.CmpRegToImm("r12", -1074)
+	evenGaps2 := fc.forwardJump(JumpEqual)
+	fc.out.MovImmToReg("rsi", "1")
+	fc.landForwardJump(evenGaps)
+	fc.landForwardJump(evenGaps2)
+
+	// r14 = max(e, 0), r12 = max(-e, 0)
+	fc.out.XorRegWithReg("r14", "r14")
+	fc.out.TestRegReg("r12", "r12")
+	negative := fc.forwardJump(JumpLess)
+	fc.out.MovRegToReg("r14", "r12")
+	fc.out.XorRegWithReg("r12", "r12")
+	split := fc.forwardJumpAlways()
+	fc.landForwardJump(negative)
+	fc.out.NegReg("r12")
+	fc.landForwardJump(split)
+
+	// R = f * 2^(r14+1+rsi), S = 2^(r12+1+rsi), M+ = 2^(r14+rsi), M- = 2^r14,
+	// so that x = R / S and the halfway points are (R +- M) / S
+	fc.out.MovRegToReg("rax", "r13")
+	fc.out.LeaMemToReg("rcx", "r14", 1)
+	fc.out.AddRegToReg("rcx", "rsi")
+	fc.dtoaBigCall("_c67_dtoa_big_set", dtoaBigR, -1)
+	fc.out.MovImmToReg("rax", "1")
+	fc.out.LeaMemToReg("rcx", "r12", 1)
+	fc.out.AddRegToReg("rcx", "rsi")
+	fc.dtoaBigCall("_c67_dtoa_big_set", dtoaBigS, -1)
+	fc.out.MovImmToReg("rax", "1")
+	fc.out.MovRegToReg("rcx", "r14")
+	fc.out.AddRegToReg("rcx", "rsi")
+	fc.dtoaBigCall("_c67_dtoa_big_set", dtoaBigMPlus, -1)
+	fc.out.MovImmToReg("rax", "1")
+	fc.out.MovRegToReg("rcx", "r14")
+	fc.dtoaBigCall("_c67_dtoa_big_set", dtoaBigMMinus, -1)
+
+	// r12 = k, the number of digits before the point, from the binary
+	// exponent b of x: floor(b * log10(2)) is never more than k
+	fc.out.Emit([]byte{0x49, 0x0f, 0xbd, 0xc5}) // bsr rax, r13
+	fc.out.AddRegToReg("rax", "r14")
+	fc.out.SubRegFromReg("rax", "r12")
+	fc.out.ImulImmToReg("rax", 78913)           // log10(2) * 2^18
+	fc.out.Emit([]byte{0x48, 0xc1, 0xf8, 0x12}) // sar rax, 18
+	fc.out.MovRegToReg("r12", "rax")
+
+	// Fold 10^k into S, or 10^-k into R and M
+	fc.out.TestRegReg("r12", "r12")
+	scaleDown := fc.forwardJump(JumpLess)
+	fc.out.MovRegToReg("rsi", "r12")
+	fc.dtoaBig("rdi", dtoaBigS)
+	fc.out.CallSymbol("_c67_dtoa_big_mul10k")
+	scaled := fc.forwardJumpAlways()
+	fc.landForwardJump(scaleDown)
+	for _, big := range []int{dtoaBigR, dtoaBigMPlus, dtoaBigMMinus} {
+		fc.out.MovRegToReg("rsi", "r12")
+		fc.out.NegReg("rsi")
+		fc.dtoaBig("rdi", big)
+		fc.out.CallSymbol("_c67_dtoa_big_mul10k")
+	}
+	fc.landForwardJump(scaled)
+
+	// Raise k while the halfway point above reaches 10^k
+	fixup := fc.eb.text.Len()
+	fc.emitDtoaBigCmpSum(dtoaBigR, dtoaBigMPlus, dtoaBigS)
+	raise := fc.emitDtoaBigBeyond(JumpAbove)
+	generate := fc.forwardJumpAlways()
+	for _, pos := range raise {
+		fc.landForwardJump(pos)
+	}
+	fc.dtoaBigMulImm(dtoaBigS, 10)
+	fc.out.IncReg("r12")
+	fc.out.JumpUnconditional(int32(fixup - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(generate)
+
+	fc.out.DecReg("r12")
+	fc.out.XorRegWithReg("r13", "r13")
+	fc.out.XorRegWithReg("r14", "r14")
+
+	// Each digit is 10 * R / S, with R the remainder
+	var done []int
+	digitLoop := fc.eb.text.Len()
+	for _, big := range []int{dtoaBigR, dtoaBigMPlus, dtoaBigMMinus} {
+		fc.dtoaBigMulImm(big, 10)
+	}
+	fc.out.ImulImmToReg("r14", 10)
+	fc.out.IncReg("r13")
+	divLoop := fc.eb.text.Len()
+	fc.dtoaBigCall("_c67_dtoa_big_cmp", dtoaBigR, dtoaBigS)
+	divided := fc.forwardJump(JumpBelow)
+	fc.dtoaBigCall("_c67_dtoa_big_sub", dtoaBigR, dtoaBigS)
+	fc.out.IncReg("r14")
+	fc.out.JumpUnconditional(int32(divLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(divided)
+
+	// Stop when the digits so far are within the gap below (low) or the
+	// digit plus one is within the gap above (high)
+	fc.dtoaBigCall("_c67_dtoa_big_cmp", dtoaBigR, dtoaBigMMinus)
+	low := fc.emitDtoaBigBeyond(JumpBelow)
+	fc.emitDtoaBigCmpSum(dtoaBigR, dtoaBigMPlus, dtoaBigS)
+	high := fc.emitDtoaBigBeyond(JumpAbove)
+	fc.out.JumpUnconditional(int32(digitLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	for _, pos := range high {
+		fc.landForwardJump(pos)
+	}
+	fc.out.IncReg("r14")
+	done = append(done, fc.forwardJumpAlways())
+
+	// Both: round the last digit to the closer of the two, or to the even
+	// one when x is halfway between them
+	for _, pos := range low {
+		fc.landForwardJump(pos)
+	}
+	fc.emitDtoaBigCmpSum(dtoaBigR, dtoaBigMPlus, dtoaBigS)
+	both := fc.emitDtoaBigBeyond(JumpAbove)
+	done = append(done, fc.forwardJumpAlways())
+	for _, pos := range both {
+		fc.landForwardJump(pos)
+	}
+	fc.emitDtoaBigCmpSum(dtoaBigR, dtoaBigR, dtoaBigS)
+	done = append(done, fc.forwardJump(JumpBelow))
+	roundUp := fc.forwardJump(JumpAbove)
+	fc.out.Emit([]byte{0x41, 0xf6, 0xc6, 0x01}) // test r14b, 1
+	done = append(done, fc.forwardJump(JumpEqual))
+	fc.landForwardJump(roundUp)
+	fc.out.IncReg("r14")
+
+	for _, pos := range done {
+		fc.landForwardJump(pos)
+	}
+}
+
+// generateDtoaFixed generates _c67_dtoa_fixed(xmm0, rdi = digits after the
+// decimal point, at most dtoaMaxPrecision) -> rsi = text, rdx = length
+func (fc *C67Compiler) generateDtoaFixed() {
+	fc.eb.MarkFunction("_c67_dtoa_fixed")
+	fc.emitDtoaPrologue()
+	fc.out.MovRegToReg("r13", "rdi")
+	done := fc.emitDtoaSpecials(false)
+
+	// |x| * 10^p fits 64 bits when p <= 19 and the product is below 2^63
+	fc.out.CmpRegToImm("r13", dtoaChunk)
+	big := []int{fc.forwardJump(JumpAbove)}
+	fc.out.MovXmmToMem("xmm0", "rsp", 8)
+	fc.out.MovRegToReg("rcx", "r13")
+	fc.out.CallSymbol("_c67_dtoa_mul10k")
+	fc.dtoaLoadConst("xmm1", 9223372036854775808.0)
+	fc.out.Ucomisd("xmm0", "xmm1")
+	fc.out.MovMemToXmm("xmm0", "rsp", 8)
+	big = append(big, fc.forwardJump(JumpAboveOrEqual))
+
+	fc.emitDtoaFixedRound()
+
+	// At least p+1 digits so there is always a digit before the point
+	fc.out.MovRegToReg("rsi", "r13")
+	fc.out.IncReg("rsi")
+	fc.emitDtoaWriteDigits("rsi")
+	written := fc.forwardJumpAlways()
+
+	for _, pos := range big {
+		fc.landForwardJump(pos)
+	}
+	fc.emitDtoaFixedBig()
+	fc.landForwardJump(written)
+
+	fc.out.TestRegReg("r13", "r13")
+	done = append(done, fc.forwardJump(JumpEqual))
+	fc.out.MovRegToReg("rdi", "rbx")
+	fc.out.SubRegFromReg("rdi", "r13")
+	fc.emitDtoaInsertDot()
+
+	for _, pos := range done {
+		fc.landForwardJump(pos)
+	}
+	fc.emitDtoaEpilogue()
+}
+
+// emitDtoaFixedBig writes the digits of |x| * 10^r13 rounded half to even,
+// at least r13+1 of them, for xmm0 = |x|. The product is built exactly as
+// mantissa * 2^e * 10^p in R and shifted right by -e, then divided by
+// 10^19 repeatedly; the remainders, least significant first, go to T.
+// Clobbers rax, rcx, rdx, rsi, rdi, r9, r10, r12 and r14.
+func (fc *C67Compiler) emitDtoaFixedBig() {
+	// R = mantissa * 2^max(e, 0) * 10^p, r12 = max(-e, 0)
+	fc.out.MovqXmmToReg("rax", "xmm0")
+	fc.emitDtoaUnpack()
+	fc.out.XorRegWithReg("r12", "r12")
+	fc.out.TestRegReg("rcx", "rcx")
+	whole := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovRegToReg("r12", "rcx")
+	fc.out.NegReg("r12")
+	fc.out.XorRegWithReg("rcx", "rcx")
+	fc.landForwardJump(whole)
+	fc.dtoaBigCall("_c67_dtoa_big_set", dtoaBigR, -1)
+	fc.out.MovRegToReg("rsi", "r13")
+	fc.dtoaBigCall("_c67_dtoa_big_mul10k", dtoaBigR, -1)
+
+	// R >>= r12, rounding half to even: shift all but the rounding bit out
+	// first, and remember whether any of those bits were set
+	fc.out.TestRegReg("r12", "r12")
+	rounded := []int{fc.forwardJump(JumpEqual)}
+	fc.out.MovRegToReg("rcx", "r12")
+	fc.out.DecReg("rcx")
+	fc.dtoaBigCall("_c67_dtoa_big_shr", dtoaBigR, -1)
+	fc.out.MovRegToReg("r14", "rax")
+	fc.out.MovImmToReg("rcx", "1")
+	fc.dtoaBigCall("_c67_dtoa_big_shr", dtoaBigR, -1)
+	fc.out.TestRegReg("rax", "rax")
+	rounded = append(rounded, fc.forwardJump(JumpEqual))
+	fc.out.TestRegReg("r14", "r14")
+	roundUp := fc.forwardJump(JumpNotEqual)
+	fc.dtoaBig("rdi", dtoaBigR)
+	fc.out.Emit([]byte{0xf6, 0x07, 0x01}) // test byte [rdi], 1
+	rounded = append(rounded, fc.forwardJump(JumpEqual))
+	fc.landForwardJump(roundUp)
+	fc.out.MovImmToReg("rax", "1")
+	fc.out.XorRegWithReg("rcx", "rcx")
+	fc.dtoaBigCall("_c67_dtoa_big_set", dtoaBigT, -1)
+	fc.dtoaBigCall("_c67_dtoa_big_add", dtoaBigR, dtoaBigT)
+	for _, pos := range rounded {
+		fc.landForwardJump(pos)
+	}
+
+	// T[r12] = chunks of 19 digits, until R is 0 and there are p+1 digits
+	fc.out.XorRegWithReg("r12", "r12")
+	chunkLoop := fc.eb.text.Len()
+	fc.out.MovImmToReg("rax", "10000000000000000000")
+	fc.dtoaBigCall("_c67_dtoa_big_div", dtoaBigR, -1)
+	fc.dtoaBig("rdi", dtoaBigT)
+	fc.out.Emit([]byte{0x4a, 0x89, 0x14, 0xe7}) // mov [rdi + r12*8], rdx
+	fc.out.IncReg("r12")
+	fc.out.TestRegReg("rax", "rax")
+	fc.out.JumpConditional(JumpNotEqual, int32(chunkLoop-(fc.eb.text.Len()+ConditionalJumpSize)))
+	fc.out.MovRegToReg("rax", "r12")
+	fc.out.ImulImmToReg("rax", dtoaChunk)
+	fc.out.CmpRegToReg("rax", "r13")
+	fc.out.JumpConditional(JumpLessOrEqual, int32(chunkLoop-(fc.eb.text.Len()+ConditionalJumpSize)))
+
+	// The top chunk has the digits that the other chunks leave for p+1
+	fc.out.DecReg("r12")
+	fc.out.MovRegToReg("rsi", "r12")
+	fc.out.ImulImmToReg("rsi", -dtoaChunk)
+	fc.out.AddRegToReg("rsi", "r13")
+	fc.out.IncReg("rsi")
+	fc.out.CmpRegToImm("rsi", 1)
+	wideEnough := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovImmToReg("rsi", "1")
+	fc.landForwardJump(wideEnough)
+	writeLoop := fc.eb.text.Len()
+	fc.dtoaBig("rdi", dtoaBigT)
+	fc.out.Emit([]byte{0x4a, 0x8b, 0x04, 0xe7}) // mov rax, [rdi + r12*8]
+	fc.out.CallSymbol("_c67_dtoa_digits")
+	fc.out.MovImmToReg("rsi", fmt.Sprintf("%d", dtoaChunk))
+	fc.out.DecReg("r12")
+	fc.out.JumpConditional(JumpGreaterOrEqual, int32(writeLoop-(fc.eb.text.Len()+ConditionalJumpSize)))
+}
+
+// emitDtoaUnpack splits the bits of a positive finite float64 in rax into
+// rax = mantissa and rcx = binary exponent e, with x = rax * 2^e. Clobbers rdx.
+func (fc *C67Compiler) emitDtoaUnpack() {
+	fc.out.MovRegToReg("rcx", "rax")
+	fc.out.ShrRegByImm("rcx", 52)
+	fc.out.MovImmToReg("rdx", fmt.Sprintf("%d", uint64(1)<<52-1))
+	fc.out.AndRegWithReg("rax", "rdx")
+	fc.out.TestRegReg("rcx", "rcx")
+	subnormal := fc.forwardJump(JumpEqual)
+	fc.out.MovImmToReg("rdx", fmt.Sprintf("%d", uint64(1)<<52))
+	fc.out.OrRegWithReg("rax", "rdx")
+	normal := fc.forwardJumpAlways()
+	fc.landForwardJump(subnormal)
+	fc.out.IncReg("rcx")
+	fc.landForwardJump(normal)
+	fc.out.SubImmFromReg("rcx", 1075)
+}
+
+// emitDtoaFixedRound sets r14 = |x| * 10^r13 rounded half to even, computed
+// exactly as mantissa * 10^p (128-bit) shifted by the binary exponent, so
+// ties such as 0.05 -> 0.1 are decided by the true binary value. Expects
+// xmm0 = |x| with the product known to be below 2^63. Clobbers rax, rcx,
+// rdx, rdi, rsi, r9 and r10.
+func (fc *C67Compiler) emitDtoaFixedRound() {
+	// rax = mantissa, rsi = binary exponent e with |x| = rax * 2^e
+	fc.out.MovqXmmToReg("rax", "xmm0")
+	fc.emitDtoaUnpack()
+	fc.out.MovRegToReg("rsi", "rcx")
+
+	// rdx:rax = mantissa * 10^p
+	fc.out.MovImmToReg("r9", "1")
+	fc.out.MovRegToReg("rcx", "r13")
+	powLoop := fc.eb.text.Len()
+	fc.out.TestRegReg("rcx", "rcx")
+	powDone := fc.forwardJump(JumpEqual)
+	fc.out.ImulImmToReg("r9", 10)
+	fc.out.DecReg("rcx")
+	fc.out.JumpUnconditional(int32(powLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(powDone)
+	fc.out.Emit([]byte{0x49, 0xf7, 0xe1}) // mul r9
+
+	var done []int
+
+	// e >= 0: x is a whole number, shift left
+	fc.out.MovRegToReg("rcx", "rsi")
+	fc.out.TestRegReg("rcx", "rcx")
+	fraction := fc.forwardJump(JumpLess)
+	fc.out.ShlRegByReg("rax", "rcx")
+	fc.out.MovRegToReg("r14", "rax")
+	done = append(done, fc.forwardJumpAlways())
+
+	// e < 0: rax = product >> (t = -e - 1) keeps the rounding bit, r10 = sticky bits
+	fc.landForwardJump(fraction)
+	fc.out.NegReg("rcx")
+	fc.out.DecReg("rcx")
+	fc.out.CmpRegToImm("rcx", 127)
+	vanishes := fc.forwardJump(JumpAbove)
+	fc.out.CmpRegToImm("rcx", 64)
+	lowShift := fc.forwardJump(JumpBelow)
+	fc.out.SubImmFromReg("rcx", 64)
+	fc.out.MovRegToReg("r10", "rax")
+	fc.out.MovImmToReg("rdi", "1")
+	fc.out.ShlRegByReg("rdi", "rcx")
+	fc.out.DecReg("rdi")
+	fc.out.AndRegWithReg("rdi", "rdx")
+	fc.out.OrRegWithReg("r10", "rdi")
+	fc.out.ShrRegByReg("rdx", "rcx")
+	fc.out.MovRegToReg("rax", "rdx")
+	shifted := fc.forwardJumpAlways()
+	fc.landForwardJump(lowShift)
+	fc.out.MovImmToReg("rdi", "1")
+	fc.out.ShlRegByReg("rdi", "rcx")
+	fc.out.DecReg("rdi")
+	fc.out.AndRegWithReg("rdi", "rax")
+	fc.out.MovRegToReg("r10", "rdi")
+	fc.out.Emit([]byte{0x48, 0x0f, 0xad, 0xd0}) // shrd rax, rdx, cl
+	fc.landForwardJump(shifted)
+
+	// Round half to even
+	fc.out.MovRegToReg("r14", "rax")
+	fc.out.ShrRegByImm("r14", 1)
+	fc.out.Emit([]byte{0xa8, 0x01}) // test al, 1
+	done = append(done, fc.forwardJump(JumpEqual))
+	fc.out.TestRegReg("r10", "r10")
+	roundUp := fc.forwardJump(JumpNotEqual)
+	fc.out.Emit([]byte{0x41, 0xf6, 0xc6, 0x01}) // test r14b, 1
+	done = append(done, fc.forwardJump(JumpEqual))
+	fc.landForwardJump(roundUp)
+	fc.out.IncReg("r14")
+	done = append(done, fc.forwardJumpAlways())
+
+	fc.landForwardJump(vanishes)
+	fc.out.XorRegWithReg("r14", "r14")
+
+	for _, pos := range done {
+		fc.landForwardJump(pos)
+	}
+}
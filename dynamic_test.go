@@ -1,9 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"debug/elf"
+	"encoding/binary"
+	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -308,3 +315,232 @@ func TestLDDOutput(t *testing.T) {
 	// Just check that it ran successfully
 	t.Logf("ldd output:\n%s", output)
 }
+
+// TestLibrarySearchPaths tests that -L finds an imported library, which is
+// then linked by its soname, and that --rpath and --runpath end up in the
+// dynamic section
+func TestLibrarySearchPaths(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("needs x86-64 Linux")
+	}
+	libm := "/lib/x86_64-linux-gnu/libm.so.6"
+	if _, err := os.Stat(libm); err != nil {
+		t.Skip("libm.so.6 not found")
+	}
+	tmpDir := t.TempDir()
+	libDir := filepath.Join(tmpDir, "lib")
+	if err := os.Mkdir(libDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(libm, filepath.Join(libDir, "libmymath.so")); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { LibrarySearchPaths, RPathFlag, RunPathFlag = nil, "", "" }()
+	LibrarySearchPaths = []string{libDir}
+	RPathFlag = "/opt/c67"
+	RunPathFlag = "$ORIGIN/lib"
+
+	src := filepath.Join(tmpDir, "test.c67")
+	if err := os.WriteFile(src, []byte("import mymath as mm\nprintln(mm.ilogb(8.0))\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(tmpDir, "test")
+	if err := CompileC67WithOptions(src, exe, Platform{OS: OSLinux, Arch: ArchX86_64}, 0, false); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+	output, err := exec.Command(exe).CombinedOutput()
+	if err != nil || string(output) != "3\n" {
+		t.Errorf("Expected '3\\n', got %q (%v)", output, err)
+	}
+
+	f, err := elf.Open(exe)
+	if err != nil {
+		t.Fatalf("Failed to open ELF: %v", err)
+	}
+	defer f.Close()
+	// Read the dynamic entries from PT_DYNAMIC, as there are no section headers
+	var entries [][2]uint64
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_DYNAMIC {
+			continue
+		}
+		data := make([]byte, prog.Filesz)
+		if _, err := prog.ReadAt(data, 0); err != nil {
+			t.Fatalf("Failed to read PT_DYNAMIC: %v", err)
+		}
+		for i := 0; i+16 <= len(data); i += 16 {
+			entries = append(entries, [2]uint64{binary.LittleEndian.Uint64(data[i:]), binary.LittleEndian.Uint64(data[i+8:])})
+		}
+	}
+	var strtab uint64
+	for _, e := range entries {
+		if elf.DynTag(e[0]) == elf.DT_STRTAB {
+			strtab = e[1]
+		}
+	}
+	dynString := func(offset uint64) string {
+		for _, prog := range f.Progs {
+			addr := strtab + offset
+			if prog.Type == elf.PT_LOAD && addr >= prog.Vaddr && addr < prog.Vaddr+prog.Filesz {
+				buf := make([]byte, 256)
+				n, _ := prog.ReadAt(buf, int64(addr-prog.Vaddr))
+				if end := bytes.IndexByte(buf[:n], 0); end >= 0 {
+					return string(buf[:end])
+				}
+			}
+		}
+		return ""
+	}
+	got := make(map[elf.DynTag][]string)
+	for _, e := range entries {
+		switch tag := elf.DynTag(e[0]); tag {
+		case elf.DT_NEEDED, elf.DT_RPATH, elf.DT_RUNPATH:
+			got[tag] = append(got[tag], dynString(e[1]))
+		}
+	}
+	expected := map[elf.DynTag]string{elf.DT_NEEDED: "libm.so.6", elf.DT_RPATH: "/opt/c67", elf.DT_RUNPATH: "$ORIGIN/lib"}
+	for tag, value := range expected {
+		if !slices.Contains(got[tag], value) {
+			t.Errorf("%v = %q, want %q among them", tag, got[tag], value)
+		}
+	}
+}
+
+func TestSymbolVersionSections(t *testing.T) {
+	ds := NewDynamicSections(ArchX86_64)
+	ds.AddNeeded("libc.so.6")
+	ds.AddNeeded("libm.so.6")
+	ds.AddSymbol("memcpy", STB_GLOBAL, STT_FUNC)
+	ds.AddSymbol("puts", STB_GLOBAL, STT_FUNC)
+	ds.AddSymbol("cos", STB_GLOBAL, STT_FUNC)
+	ds.AddSymbol("mine", STB_GLOBAL, STT_FUNC)
+	ds.SetSymbolVersion("memcpy", "libc.so.6", "GLIBC_2.14")
+	ds.SetSymbolVersion("puts", "libc.so.6", "GLIBC_2.2.5")
+	ds.SetSymbolVersion("cos", "libm.so.6", "GLIBC_2.2.5")
+	size := ds.dynstr.Len()
+	ds.buildVersionSections()
+	if ds.dynstr.Len() != size {
+		t.Errorf("buildVersionSections grew dynstr from %d to %d bytes", size, ds.dynstr.Len())
+	}
+
+	var versym []uint16
+	for i := 0; i < ds.versym.Len(); i += 2 {
+		versym = append(versym, binary.LittleEndian.Uint16(ds.versym.Bytes()[i:]))
+	}
+	if want := []uint16{0, 2, 3, 4, 1}; !slices.Equal(versym, want) {
+		t.Errorf("versym = %v, want %v", versym, want)
+	}
+
+	// Walk the Elf64_Verneed chain
+	data := ds.verneed.Bytes()
+	name := func(offset uint32) string {
+		s := ds.dynstr.Bytes()[offset:]
+		return string(s[:bytes.IndexByte(s, 0)])
+	}
+	var got []string
+	for offset := uint32(0); ; {
+		cnt := binary.LittleEndian.Uint16(data[offset+2:])
+		lib := name(binary.LittleEndian.Uint32(data[offset+4:]))
+		aux := offset + binary.LittleEndian.Uint32(data[offset+8:])
+		for i := uint16(0); i < cnt; i++ {
+			version := name(binary.LittleEndian.Uint32(data[aux+8:]))
+			if hash := binary.LittleEndian.Uint32(data[aux:]); hash != elfHash(version) {
+				t.Errorf("hash of %s = %#x, want %#x", version, hash, elfHash(version))
+			}
+			got = append(got, fmt.Sprintf("%s:%s:%d", lib, version, binary.LittleEndian.Uint16(data[aux+6:])))
+			aux += binary.LittleEndian.Uint32(data[aux+12:])
+		}
+		next := binary.LittleEndian.Uint32(data[offset+12:])
+		if next == 0 {
+			break
+		}
+		offset += next
+	}
+	want := []string{"libc.so.6:GLIBC_2.14:2", "libc.so.6:GLIBC_2.2.5:3", "libm.so.6:GLIBC_2.2.5:4"}
+	if !slices.Equal(got, want) {
+		t.Errorf("verneed = %v, want %v", got, want)
+	}
+	if elfHash("GLIBC_2.2.5") != 0x09691a75 {
+		t.Errorf("elfHash(GLIBC_2.2.5) = %#x, want 0x09691a75", elfHash("GLIBC_2.2.5"))
+	}
+}
+
+func TestSymbolVersionsInExecutable(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("needs x86-64 Linux")
+	}
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "test.c67")
+	code := "import c\np := c.malloc(16)\nq := c.malloc(16)\nc.memcpy(q, p, 16)\nprintln(\"copied\")\n"
+	if err := os.WriteFile(src, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(tmpDir, "test")
+	if err := CompileC67WithOptions(src, exe, Platform{OS: OSLinux, Arch: ArchX86_64}, 0, false); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+	// The dynamic linker reports the version each symbol was bound to
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), "LD_DEBUG=bindings")
+	output, err := cmd.CombinedOutput()
+	if err != nil || !strings.Contains(string(output), "copied\n") {
+		t.Fatalf("Expected 'copied', got %q (%v)", output, err)
+	}
+	if !strings.Contains(string(output), "`memcpy' [GLIBC_2.14]") {
+		t.Errorf("memcpy was not bound to its default version GLIBC_2.14:\n%s", output)
+	}
+}
+
+func TestDataSymbolRelocations(t *testing.T) {
+	ds := NewDynamicSections(ArchX86_64)
+	ds.AddNeeded("libc.so.6")
+	functions := []string{"malloc", "fputs"}
+	for _, fn := range functions {
+		ds.AddSymbol(fn, STB_GLOBAL, STT_FUNC)
+	}
+	stdout := ds.AddDataSymbol("stdout")
+	environ := ds.AddDataSymbol("environ")
+	if again := ds.AddDataSymbol("stdout"); again != stdout {
+		t.Errorf("AddDataSymbol(stdout) twice gave symbols %d and %d", stdout, again)
+	}
+	if info := ds.dynsymSyms[environ].info; info != STB_GLOBAL<<4|STT_OBJECT {
+		t.Errorf("environ symbol info = %#x, want a global object", info)
+	}
+
+	const gotBase = 0x40b100
+	ds.GenerateGOT(functions, 0x40b000, 0x402000)
+	if want := 8 * (3 + len(functions) + 2); ds.got.Len() != want {
+		t.Errorf("GOT size = %d, want %d", ds.got.Len(), want)
+	}
+	if slot := ds.DataSymbolSlot("environ", gotBase, len(functions)); slot != gotBase+48 {
+		t.Errorf("environ slot = %#x, want %#x", slot, gotBase+48)
+	}
+
+	ds.buildDataRelocations(gotBase, len(functions))
+	data := ds.relaDyn.Bytes()
+	if len(data) != 2*24 {
+		t.Fatalf(".rela.dyn is %d bytes, want 48", len(data))
+	}
+	for i, want := range []struct {
+		offset uint64
+		sym    uint32
+	}{{gotBase + 40, stdout}, {gotBase + 48, environ}} {
+		entry := data[i*24:]
+		offset := binary.LittleEndian.Uint64(entry)
+		info := binary.LittleEndian.Uint64(entry[8:])
+		if offset != want.offset || uint32(info>>32) != want.sym || uint32(info) != R_X86_64_GLOB_DAT {
+			t.Errorf("relocation %d = offset %#x, symbol %d, type %d; want %#x, %d, GLOB_DAT",
+				i, offset, info>>32, uint32(info), want.offset, want.sym)
+		}
+	}
+
+	ds.buildDynamicSection(map[string]uint64{"reladyn": 0x401100})
+	tags := make(map[int64]uint64)
+	for buf := ds.dynamic.Bytes(); len(buf) >= 16; buf = buf[16:] {
+		tags[int64(binary.LittleEndian.Uint64(buf))] = binary.LittleEndian.Uint64(buf[8:])
+	}
+	if tags[DT_RELA] != 0x401100 || tags[DT_RELASZ] != 48 || tags[DT_RELAENT] != 24 {
+		t.Errorf("DT_RELA = %#x, DT_RELASZ = %d, DT_RELAENT = %d; want 0x401100, 48, 24",
+			tags[DT_RELA], tags[DT_RELASZ], tags[DT_RELAENT])
+	}
+}
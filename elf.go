@@ -57,7 +57,11 @@ func (eb *ExecutableBuilder) WriteELFHeader() error {
 	w.Write8u(entry)
 	w.Write8(progHeaderOffset)
 	w.Write8u(sectionTableAddr)
-	w.Write4(0)
+	if eb.target.Arch() == ArchRiscv64 {
+		w.Write4(0x1) // EF_RISCV_RVC: the code uses compressed instructions
+	} else {
+		w.Write4(0)
+	}
 	w.Write2(elfHeaderSize)
 	w.Write2(progHeaderSize)
 	const programHeaderTableEntries = 1
@@ -34,6 +34,8 @@ func (eb *ExecutableBuilder) WriteCompleteDynamicELF(ds *DynamicSections, functi
 	// Build all dynamic sections first
 	ds.buildSymbolTable()
 	ds.buildHashTable()
+	ds.buildVersionSections()
+	ds.buildDataRelocations(0, len(functions))
 
 	// Pre-generate PLT and GOT with dummy values to get correct sizes
 	ds.GeneratePLT(functions, 0, 0)
@@ -109,6 +111,21 @@ func (eb *ExecutableBuilder) WriteCompleteDynamicELF(ds *DynamicSections, functi
 	currentOffset += uint64((ds.hash.Len() + 7) & ^7)
 	currentAddr += uint64((ds.hash.Len() + 7) & ^7)
 
+	// .gnu.version, .gnu.version_r and .rela.dyn, before .rela.plt, which
+	// grows later
+	for _, section := range []struct {
+		name string
+		buf  *bytes.Buffer
+	}{{"versym", &ds.versym}, {"verneed", &ds.verneed}, {"reladyn", &ds.relaDyn}} {
+		layout[section.name] = struct {
+			offset uint64
+			addr   uint64
+			size   int
+		}{currentOffset, currentAddr, section.buf.Len()}
+		currentOffset += uint64((section.buf.Len() + 7) & ^7)
+		currentAddr += uint64((section.buf.Len() + 7) & ^7)
+	}
+
 	// .rela.plt
 	layout["rela"] = struct {
 		offset uint64
@@ -263,6 +280,13 @@ func (eb *ExecutableBuilder) WriteCompleteDynamicELF(ds *DynamicSections, functi
 	addrs["dynsym"] = layout["dynsym"].addr
 	addrs["rela"] = layout["rela"].addr
 	addrs["got"] = layout["got"].addr
+	if ds.verneed.Len() > 0 {
+		addrs["versym"] = layout["versym"].addr
+		addrs["verneed"] = layout["verneed"].addr
+	}
+	if ds.relaDyn.Len() > 0 {
+		addrs["reladyn"] = layout["reladyn"].addr
+	}
 
 	if VerboseMode {
 		fmt.Fprintf(os.Stderr, "Hash layout: offset=0x%x, size=%d\n", layout["hash"].offset, layout["hash"].size)
@@ -323,6 +347,7 @@ func (eb *ExecutableBuilder) WriteCompleteDynamicELF(ds *DynamicSections, functi
 		}
 		ds.updateRelocationAddress(oldGotEntryAddr, newGotEntryAddr)
 	}
+	ds.buildDataRelocations(gotAddr, len(functions))
 
 	rodataOffset := gotOffset + uint64((ds.got.Len()+7) & ^7)
 	rodataAddr = gotAddr + uint64((ds.got.Len()+7) & ^7)
@@ -332,6 +357,10 @@ func (eb *ExecutableBuilder) WriteCompleteDynamicELF(ds *DynamicSections, functi
 		size   int
 	}{rodataOffset, rodataAddr, layout["rodata"].size}
 	eb.rodataOffsetInELF = rodataOffset
+	eb.loadedSections = make(map[string]loadedSection)
+	for _, name := range []string{"dynsym", "dynstr", "dynamic"} {
+		eb.loadedSections[name] = loadedSection{layout[name].offset, layout[name].addr, layout[name].size}
+	}
 
 	// Entry point is already set to _start above
 	// (entryPoint := layout["_start"].addr)
@@ -492,6 +521,9 @@ func (eb *ExecutableBuilder) WriteCompleteDynamicELF(ds *DynamicSections, functi
 	writePadded(&ds.dynsym, (ds.dynsym.Len()+7)&^7)
 	writePadded(&ds.dynstr, (ds.dynstr.Len()+7)&^7)
 	writePadded(&ds.hash, (ds.hash.Len()+7)&^7)
+	writePadded(&ds.versym, (ds.versym.Len()+7)&^7)
+	writePadded(&ds.verneed, (ds.verneed.Len()+7)&^7)
+	writePadded(&ds.relaDyn, (ds.relaDyn.Len()+7)&^7)
 	if VerboseMode {
 		fmt.Fprintf(os.Stderr, "Rela buffer contents (%d bytes): %x\n", ds.rela.Len(), ds.rela.Bytes())
 	}
@@ -804,7 +836,9 @@ func (eb *ExecutableBuilder) patchX86PLTCalls(textBytes []byte, ds *DynamicSecti
 
 				if pltOffset >= 0 || isInternal {
 					currentAddr := textAddr + uint64(placeholderPos)
-					relOffset := int32(targetAddr - (currentAddr + 4))
+					distance := int64(targetAddr) - int64(currentAddr+4)
+					checkReach("the call to "+funcName, placeholderPos, distance, 32, 1)
+					relOffset := int32(distance)
 
 					if VerboseMode {
 						fmt.Fprintf(os.Stderr, "DEBUG: Call at %x, target %x, relOffset=%d (0x%x)\n", currentAddr, targetAddr, relOffset, uint32(relOffset))
@@ -892,8 +926,8 @@ func (eb *ExecutableBuilder) patchARM64PLTCalls(textBytes []byte, ds *DynamicSec
 			textBytes[callPos+1] = byte((blInstr >> 8) & 0xFF)
 			textBytes[callPos+2] = byte((blInstr >> 16) & 0xFF)
 			textBytes[callPos+3] = byte((blInstr >> 24) & 0xFF)
-		} else if VerboseMode {
-			fmt.Fprintf(os.Stderr, "Warning: BL offset too large for %s: %d words\n", funcName, wordOffset)
+		} else {
+			checkReach("the call to "+funcName, callPos, offset, 26, 4)
 		}
 	}
 }
@@ -936,6 +970,8 @@ func (eb *ExecutableBuilder) patchRISCVPLTCalls(textBytes []byte, ds *DynamicSec
 						textBytes[i+1] = byte((jalInstr >> 8) & 0xFF)
 						textBytes[i+2] = byte((jalInstr >> 16) & 0xFF)
 						textBytes[i+3] = byte((jalInstr >> 24) & 0xFF)
+					} else {
+						checkReach("the call to "+functions[funcIndex], i, offset, 21, 1)
 					}
 				}
 				funcIndex++
@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"os"
+	"slices"
 )
 
 // ELF section types and flags
@@ -17,6 +18,7 @@ const (
 	SHT_RELA     = 4
 	SHT_HASH     = 5
 	SHT_DYNAMIC  = 6
+	SHT_NOTE     = 7
 	SHT_NOBITS   = 8
 	SHT_REL      = 9
 	SHT_DYNSYM   = 11
@@ -24,6 +26,8 @@ const (
 	SHF_WRITE     = 0x1
 	SHF_ALLOC     = 0x2
 	SHF_EXECINSTR = 0x4
+	SHF_MERGE     = 0x10
+	SHF_STRINGS   = 0x20
 
 	// Dynamic tags
 	DT_NULL     = 0
@@ -38,9 +42,16 @@ const (
 	DT_RELAENT  = 9
 	DT_STRSZ    = 10
 	DT_SYMENT   = 11
+	DT_RPATH    = 15
 	DT_PLTREL   = 20
 	DT_DEBUG    = 21
 	DT_JMPREL   = 23
+	DT_RUNPATH  = 29
+
+	// Symbol versioning (GNU)
+	DT_VERSYM     = 0x6ffffff0
+	DT_VERNEED    = 0x6ffffffe
+	DT_VERNEEDNUM = 0x6fffffff
 
 	// Relocation types (x86_64)
 	R_X86_64_NONE      = 0
@@ -61,6 +72,7 @@ const (
 	STB_LOCAL  = 0
 	STB_GLOBAL = 1
 	STT_NOTYPE = 0
+	STT_OBJECT = 1
 	STT_FUNC   = 2
 )
 
@@ -84,6 +96,11 @@ type DynamicSections struct {
 	rela      bytes.Buffer
 	relaCount int
 
+	// Data symbols from libraries, such as stdout, reached through GOT
+	// slots after the PLT slots and filled by .rela.dyn
+	dataSymbols []string
+	relaDyn     bytes.Buffer
+
 	// PLT and GOT
 	plt        bytes.Buffer
 	got        bytes.Buffer
@@ -92,10 +109,27 @@ type DynamicSections struct {
 	// Needed libraries
 	needed []string
 
+	// Library search paths, directories separated by ':'
+	rpath   string
+	runpath string
+
+	// Symbol versions (.gnu.version and .gnu.version_r)
+	versym        bytes.Buffer
+	verneed       bytes.Buffer
+	verneedLibs   []versionNeed
+	symbolVersion map[uint32]uint16 // dynstr offset of a symbol name -> version index
+
 	// Target architecture
 	arch Arch
 }
 
+// versionNeed is a library and the versions of it that symbols need
+type versionNeed struct {
+	lib      string
+	versions []string
+	indices  []uint16
+}
+
 type Symbol struct {
 	name  uint32 // offset in string table
 	info  byte
@@ -107,10 +141,11 @@ type Symbol struct {
 
 func NewDynamicSections(arch Arch) *DynamicSections {
 	ds := &DynamicSections{
-		dynstrMap:  make(map[string]uint32),
-		pltEntries: []string{},
-		needed:     []string{},
-		arch:       arch,
+		dynstrMap:     make(map[string]uint32),
+		pltEntries:    []string{},
+		needed:        []string{},
+		symbolVersion: make(map[uint32]uint16),
+		arch:          arch,
 	}
 
 	// First byte of string table must be null
@@ -176,6 +211,63 @@ func (ds *DynamicSections) AddDefinedSymbol(name string, binding byte, symtype b
 	return uint32(len(ds.dynsymSyms) - 1)
 }
 
+// AddDataSymbol adds a data object from a shared library, such as stdout
+// or environ, and gives it a GOT slot after those of the PLT functions
+func (ds *DynamicSections) AddDataSymbol(name string) uint32 {
+	if i := slices.Index(ds.dataSymbols, name); i >= 0 {
+		return ds.dataSymbolIndex(i)
+	}
+	ds.dataSymbols = append(ds.dataSymbols, name)
+	return ds.AddSymbol(name, STB_GLOBAL, STT_OBJECT)
+}
+
+// dataSymbolIndex returns the dynsym index of the i-th data symbol
+func (ds *DynamicSections) dataSymbolIndex(i int) uint32 {
+	offset := ds.dynstrMap[ds.dataSymbols[i]]
+	for j, sym := range ds.dynsymSyms {
+		if sym.name == offset && sym.info&0xf == STT_OBJECT {
+			return uint32(j)
+		}
+	}
+	return 0
+}
+
+// DataSymbolSlot returns the address of the GOT slot that holds the address
+// of the data symbol name, given the GOT base and the number of PLT
+// functions, or 0 if there is no such data symbol
+func (ds *DynamicSections) DataSymbolSlot(name string, gotBase uint64, numFunctions int) uint64 {
+	i := slices.Index(ds.dataSymbols, name)
+	if i < 0 {
+		return 0
+	}
+	return gotBase + uint64(24+(numFunctions+i)*8)
+}
+
+// buildDataRelocations writes .rela.dyn, which has the dynamic linker store
+// the address of each data symbol in its GOT slot at startup. These are
+// GLOB_DAT relocations, which cannot go in the lazily bound .rela.plt. No
+// copy relocations are needed, since the code always loads the address
+// from the GOT instead of linking against a fixed one, and a symbol that
+// turns out to be an IFUNC is resolved by the dynamic linker as well.
+func (ds *DynamicSections) buildDataRelocations(gotBase uint64, numFunctions int) {
+	var relType uint32
+	switch ds.arch {
+	case ArchARM64:
+		relType = R_AARCH64_GLOB_DAT
+	case ArchRiscv64:
+		relType = R_RISCV_64
+	default:
+		relType = R_X86_64_GLOB_DAT
+	}
+	ds.relaDyn.Reset()
+	for i, name := range ds.dataSymbols {
+		info := uint64(ds.dataSymbolIndex(i))<<32 | uint64(relType)
+		binary.Write(&ds.relaDyn, binary.LittleEndian, ds.DataSymbolSlot(name, gotBase, numFunctions))
+		binary.Write(&ds.relaDyn, binary.LittleEndian, info)
+		binary.Write(&ds.relaDyn, binary.LittleEndian, uint64(0)) // addend
+	}
+}
+
 // buildSymbolTable writes the symbol table
 func (ds *DynamicSections) buildSymbolTable() {
 	ds.dynsym.Reset()
@@ -226,11 +318,117 @@ func (ds *DynamicSections) UpdateSymbolValue(name string, value uint64) bool {
 
 // AddNeeded adds a required shared library
 func (ds *DynamicSections) AddNeeded(lib string) {
+	if slices.Contains(ds.needed, lib) {
+		return
+	}
 	// Add the string immediately so layout calculations are correct
 	ds.addString(lib)
 	ds.needed = append(ds.needed, lib)
 }
 
+// SetRPath sets the DT_RPATH library search path. The dynamic linker
+// searches it before LD_LIBRARY_PATH, also for the dependencies of the
+// libraries. An empty path adds no entry.
+func (ds *DynamicSections) SetRPath(path string) {
+	if path != "" {
+		ds.addString(path)
+	}
+	ds.rpath = path
+}
+
+// SetRunPath sets the DT_RUNPATH library search path, which is searched
+// after LD_LIBRARY_PATH and only for the executable's own DT_NEEDED
+// entries. An empty path adds no entry.
+func (ds *DynamicSections) SetRunPath(path string) {
+	if path != "" {
+		ds.addString(path)
+	}
+	ds.runpath = path
+}
+
+// SetSymbolVersion records that the symbol name binds to version of the
+// needed library lib, such as memcpy to GLIBC_2.14 in libc.so.6
+func (ds *DynamicSections) SetSymbolVersion(name, lib, version string) {
+	i := slices.IndexFunc(ds.verneedLibs, func(n versionNeed) bool { return n.lib == lib })
+	if i < 0 {
+		ds.addString(lib)
+		ds.verneedLibs = append(ds.verneedLibs, versionNeed{lib: lib})
+		i = len(ds.verneedLibs) - 1
+	}
+	need := &ds.verneedLibs[i]
+	j := slices.Index(need.versions, version)
+	if j < 0 {
+		// Indices 0 and 1 are the local and global versions
+		index := uint16(2)
+		for _, n := range ds.verneedLibs {
+			index += uint16(len(n.versions))
+		}
+		ds.addString(version)
+		need.versions = append(need.versions, version)
+		need.indices = append(need.indices, index)
+		j = len(need.versions) - 1
+	}
+	ds.symbolVersion[ds.addString(name)] = need.indices[j]
+}
+
+// buildVersionSections writes .gnu.version, with the version index of each
+// dynamic symbol, and .gnu.version_r, with the versions needed from each
+// library. Both stay empty if no symbol has a version.
+func (ds *DynamicSections) buildVersionSections() {
+	ds.versym.Reset()
+	ds.verneed.Reset()
+	if len(ds.verneedLibs) == 0 {
+		return
+	}
+
+	for i, sym := range ds.dynsymSyms {
+		index := uint16(1) // VER_NDX_GLOBAL
+		if i == 0 {
+			index = 0 // VER_NDX_LOCAL
+		} else if v, ok := ds.symbolVersion[sym.name]; ok && sym.shndx == 0 {
+			index = v
+		}
+		binary.Write(&ds.versym, binary.LittleEndian, index)
+	}
+
+	// Elf64_Verneed and Elf64_Vernaux are both 16 bytes
+	for i, need := range ds.verneedLibs {
+		next := uint32(16 + 16*len(need.versions))
+		if i == len(ds.verneedLibs)-1 {
+			next = 0
+		}
+		binary.Write(&ds.verneed, binary.LittleEndian, uint16(1)) // vn_version
+		binary.Write(&ds.verneed, binary.LittleEndian, uint16(len(need.versions)))
+		binary.Write(&ds.verneed, binary.LittleEndian, ds.addString(need.lib))
+		binary.Write(&ds.verneed, binary.LittleEndian, uint32(16)) // vn_aux
+		binary.Write(&ds.verneed, binary.LittleEndian, next)
+		for j, version := range need.versions {
+			next := uint32(16)
+			if j == len(need.versions)-1 {
+				next = 0
+			}
+			binary.Write(&ds.verneed, binary.LittleEndian, elfHash(version))
+			binary.Write(&ds.verneed, binary.LittleEndian, uint16(0)) // vna_flags
+			binary.Write(&ds.verneed, binary.LittleEndian, need.indices[j])
+			binary.Write(&ds.verneed, binary.LittleEndian, ds.addString(version))
+			binary.Write(&ds.verneed, binary.LittleEndian, next)
+		}
+	}
+}
+
+// elfHash is the SysV ELF hash function, used for version names
+func elfHash(name string) uint32 {
+	var h uint32
+	for i := 0; i < len(name); i++ {
+		h = h<<4 + uint32(name[i])
+		if g := h & 0xf0000000; g != 0 {
+			h ^= g >> 24
+		}
+		h &^= 0xf0000000
+	}
+	return h
+}
+
 // AddRelocation adds a relocation entry
 func (ds *DynamicSections) AddRelocation(offset uint64, symIndex uint32, relType uint32) {
 	info := (uint64(symIndex) << 32) | uint64(relType)
@@ -284,6 +482,14 @@ func (ds *DynamicSections) buildDynamicSection(addrs map[string]uint64) {
 		writeDynEntry(DT_NEEDED, uint64(nameOffset))
 	}
 
+	// Library search paths
+	if ds.rpath != "" {
+		writeDynEntry(DT_RPATH, uint64(ds.addString(ds.rpath)))
+	}
+	if ds.runpath != "" {
+		writeDynEntry(DT_RUNPATH, uint64(ds.addString(ds.runpath)))
+	}
+
 	// Hash table
 	if hashAddr, ok := addrs["hash"]; ok {
 		writeDynEntry(DT_HASH, hashAddr)
@@ -302,6 +508,11 @@ func (ds *DynamicSections) buildDynamicSection(addrs map[string]uint64) {
 	}
 
 	// Relocations
+	if relaDynAddr, ok := addrs["reladyn"]; ok {
+		writeDynEntry(DT_RELA, relaDynAddr)
+		writeDynEntry(DT_RELASZ, uint64(ds.relaDyn.Len()))
+		writeDynEntry(DT_RELAENT, 24) // sizeof(Elf64_Rela)
+	}
 	if relaAddr, ok := addrs["rela"]; ok {
 		writeDynEntry(DT_JMPREL, relaAddr)
 		writeDynEntry(DT_PLTRELSZ, uint64(ds.relaCount*24)) // sizeof(Elf64_Rela)
@@ -313,6 +524,13 @@ func (ds *DynamicSections) buildDynamicSection(addrs map[string]uint64) {
 		writeDynEntry(DT_PLTGOT, gotAddr)
 	}
 
+	// Symbol versions
+	if versymAddr, ok := addrs["versym"]; ok {
+		writeDynEntry(DT_VERSYM, versymAddr)
+		writeDynEntry(DT_VERNEED, addrs["verneed"])
+		writeDynEntry(DT_VERNEEDNUM, uint64(len(ds.verneedLibs)))
+	}
+
 	// Debug
 	writeDynEntry(DT_DEBUG, 0)
 
@@ -0,0 +1,233 @@
+// Completion: 100% - Function symbols in .symtab
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+)
+
+// elf_symtab.go - Function symbols for objdump, nm and perf
+//
+// The loader only needs the program headers, so the executables used to have
+// no section headers at all, and objdump and nm saw the code as one anonymous
+// blob. appendSymbolTable adds section headers for .text and the dynamic
+// sections, and a .symtab with a FUNC symbol for every function marked with
+// MarkFunction: the top level code (c67.main), the lambdas (lambda_3) and the
+// runtime helpers (_c67_list_cons). A function runs up to the start of the
+// next one.
+//
+// .symtab is not loaded, so it goes at the end of the file, after the last
+// segment, in debug and non-debug builds alike. So do .note.c67, with the
+// capabilities of the program (see capabilities.go), and .comment and the
+// build ID (see buildid.go).
+
+// loadedSection is where WriteCompleteDynamicELF placed a section
+type loadedSection struct {
+	offset uint64
+	addr   uint64
+	size   int
+}
+
+// textFunction is a function in .text
+type textFunction struct {
+	name   string
+	offset int
+	size   int
+}
+
+// elfSectionHeader is an Elf64_Shdr
+type elfSectionHeader struct {
+	name      uint32
+	typ       uint32
+	flags     uint64
+	addr      uint64
+	offset    uint64
+	size      uint64
+	link      uint32
+	info      uint32
+	addralign uint64
+	entsize   uint64
+}
+
+// textFunctions returns the functions marked with MarkFunction that are in
+// the current .text, by offset
+func (eb *ExecutableBuilder) textFunctions() []textFunction {
+	var funcs []textFunction
+	for name := range eb.functions {
+		if offset, ok := eb.labels[name]; ok {
+			funcs = append(funcs, textFunction{name: name, offset: offset})
+		}
+	}
+	sort.Slice(funcs, func(i, j int) bool {
+		if funcs[i].offset != funcs[j].offset {
+			return funcs[i].offset < funcs[j].offset
+		}
+		return funcs[i].name < funcs[j].name
+	})
+
+	end := eb.text.Len()
+	for i := len(funcs) - 1; i >= 0; i-- {
+		funcs[i].size = end - funcs[i].offset
+		if funcs[i].size > 0 {
+			end = funcs[i].offset
+		}
+	}
+	return funcs
+}
+
+// appendSymbolTable appends .symtab, .strtab, .shstrtab and the section
+// headers to the ELF, for the text at textOffset in the file and at textAddr
+// in memory, and points the ELF header at them
+func (eb *ExecutableBuilder) appendSymbolTable(textOffset, textAddr uint64) {
+	var symtab, strtab, shstrtab bytes.Buffer
+	headers := []elfSectionHeader{{}}
+	addHeader := func(name string, sh elfSectionHeader) uint32 {
+		sh.name = uint32(shstrtab.Len())
+		shstrtab.WriteString(name)
+		shstrtab.WriteByte(0)
+		headers = append(headers, sh)
+		return uint32(len(headers) - 1)
+	}
+	appendData := func(data *bytes.Buffer, align int) (offset, size uint64) {
+		for eb.elf.Len()%align != 0 {
+			eb.elf.WriteByte(0)
+		}
+		offset = uint64(eb.elf.Len())
+		eb.elf.Write(data.Bytes())
+		return offset, uint64(data.Len())
+	}
+	shstrtab.WriteByte(0)
+
+	// .data that was added after WriteCompleteDynamicELF, such as the
+	// --lazy-ffi cache slots, lives past the end of the last segment in the
+	// rest of its page, and must keep reading as zeros
+	header := eb.elf.Bytes()
+	phoff := binary.LittleEndian.Uint64(header[0x20:])
+	segmentsEnd := 0
+	for i := range int(binary.LittleEndian.Uint16(header[0x38:])) {
+		ph := header[phoff+uint64(i*progHeaderSize):]
+		if binary.LittleEndian.Uint32(ph) == 1 { // PT_LOAD
+			segmentsEnd = max(segmentsEnd, int(binary.LittleEndian.Uint64(ph[8:])+binary.LittleEndian.Uint64(ph[32:])))
+		}
+	}
+	segmentsEnd = (segmentsEnd + pageSize - 1) &^ (pageSize - 1)
+	for eb.elf.Len() < segmentsEnd {
+		eb.elf.WriteByte(0)
+	}
+
+	textIndex := addHeader(".text", elfSectionHeader{
+		typ:       SHT_PROGBITS,
+		flags:     SHF_ALLOC | SHF_EXECINSTR,
+		addr:      textAddr,
+		offset:    textOffset,
+		size:      uint64(eb.text.Len()),
+		addralign: 16,
+	})
+	if dynstr, ok := eb.loadedSections["dynstr"]; ok {
+		dynsym, dynamic := eb.loadedSections["dynsym"], eb.loadedSections["dynamic"]
+		dynstrIndex := addHeader(".dynstr", elfSectionHeader{
+			typ:       SHT_STRTAB,
+			flags:     SHF_ALLOC,
+			addr:      dynstr.addr,
+			offset:    dynstr.offset,
+			size:      uint64(dynstr.size),
+			addralign: 1,
+		})
+		addHeader(".dynsym", elfSectionHeader{
+			typ:       SHT_DYNSYM,
+			flags:     SHF_ALLOC,
+			addr:      dynsym.addr,
+			offset:    dynsym.offset,
+			size:      uint64(dynsym.size),
+			link:      dynstrIndex,
+			info:      1, // only the undefined symbol is local
+			addralign: 8,
+			entsize:   24,
+		})
+		addHeader(".dynamic", elfSectionHeader{
+			typ:       SHT_DYNAMIC,
+			flags:     SHF_ALLOC | SHF_WRITE,
+			addr:      dynamic.addr,
+			offset:    dynamic.offset,
+			size:      uint64(dynamic.size),
+			link:      dynstrIndex,
+			addralign: 8,
+			entsize:   16,
+		})
+	}
+
+	note := bytes.NewBuffer(capabilityNote(eb.capabilities))
+	noteOffset, noteSize := appendData(note, 4)
+	addHeader(".note.c67", elfSectionHeader{typ: SHT_NOTE, offset: noteOffset, size: noteSize, addralign: 4})
+
+	comment := bytes.NewBufferString(versionString + "\x00")
+	commentOffset, commentSize := appendData(comment, 1)
+	addHeader(".comment", elfSectionHeader{
+		typ:       SHT_PROGBITS,
+		flags:     SHF_MERGE | SHF_STRINGS,
+		offset:    commentOffset,
+		size:      commentSize,
+		addralign: 1,
+		entsize:   1,
+	})
+	buildID, buildIDOffset := buildIDNote()
+	buildIDNoteOffset, buildIDNoteSize := appendData(bytes.NewBuffer(buildID), 4)
+	addHeader(".note.gnu.build-id", elfSectionHeader{typ: SHT_NOTE, offset: buildIDNoteOffset, size: buildIDNoteSize, addralign: 4})
+
+	strtab.WriteByte(0)
+	symbols := []Symbol{{}} // the undefined symbol
+	for _, fn := range eb.textFunctions() {
+		symbols = append(symbols, Symbol{
+			name:  uint32(strtab.Len()),
+			info:  STB_LOCAL<<4 | STT_FUNC,
+			shndx: uint16(textIndex),
+			value: textAddr + uint64(fn.offset),
+			size:  uint64(fn.size),
+		})
+		strtab.WriteString(fn.name)
+		strtab.WriteByte(0)
+	}
+	for _, sym := range symbols {
+		binary.Write(&symtab, binary.LittleEndian, sym.name)
+		binary.Write(&symtab, binary.LittleEndian, sym.info)
+		binary.Write(&symtab, binary.LittleEndian, sym.other)
+		binary.Write(&symtab, binary.LittleEndian, sym.shndx)
+		binary.Write(&symtab, binary.LittleEndian, sym.value)
+		binary.Write(&symtab, binary.LittleEndian, sym.size)
+	}
+
+	symtabOffset, symtabSize := appendData(&symtab, 8)
+	strtabOffset, strtabSize := appendData(&strtab, 1)
+	strtabIndex := uint32(len(headers) + 1)
+	addHeader(".symtab", elfSectionHeader{
+		typ:       SHT_SYMTAB,
+		offset:    symtabOffset,
+		size:      symtabSize,
+		link:      strtabIndex,
+		info:      uint32(len(symbols)), // all symbols are local
+		addralign: 8,
+		entsize:   24,
+	})
+	addHeader(".strtab", elfSectionHeader{typ: SHT_STRTAB, offset: strtabOffset, size: strtabSize, addralign: 1})
+	shstrtabIndex := addHeader(".shstrtab", elfSectionHeader{typ: SHT_STRTAB, addralign: 1})
+	headers[shstrtabIndex].offset, headers[shstrtabIndex].size = appendData(&shstrtab, 1)
+
+	for eb.elf.Len()%8 != 0 {
+		eb.elf.WriteByte(0)
+	}
+	shoff := uint64(eb.elf.Len())
+	for _, sh := range headers {
+		binary.Write(&eb.elf, binary.LittleEndian, sh)
+	}
+
+	// e_shoff, e_shentsize, e_shnum and e_shstrndx
+	header = eb.elf.Bytes()
+	binary.LittleEndian.PutUint64(header[0x28:], shoff)
+	binary.LittleEndian.PutUint16(header[0x3a:], sectionHeaderSize)
+	binary.LittleEndian.PutUint16(header[0x3c:], uint16(len(headers)))
+	binary.LittleEndian.PutUint16(header[0x3e:], uint16(shstrtabIndex))
+
+	// Last, since the ID is the hash of everything else
+	eb.setBuildID(int(buildIDNoteOffset) + buildIDOffset)
+}
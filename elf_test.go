@@ -509,3 +509,56 @@ func TestELFPermissions(t *testing.T) {
 		t.Errorf("File not executable: permissions = %o", info.Mode().Perm())
 	}
 }
+
+// TestELFFunctionSymbols verifies that .symtab has a sized FUNC symbol for
+// the top level code, the lambdas and the runtime helpers
+func TestELFFunctionSymbols(t *testing.T) {
+	for _, arch := range []Arch{ArchX86_64, ArchARM64} {
+		t.Run(arch.String(), func(t *testing.T) {
+			tmpDir := t.TempDir()
+			srcFile := filepath.Join(tmpDir, "symbols.c67")
+			exePath := filepath.Join(tmpDir, "symbols")
+			if err := os.WriteFile(srcFile, []byte("sq = x -> x * x\nprintln(sq(5))\n"), 0644); err != nil {
+				t.Fatalf("Failed to write source file: %v", err)
+			}
+			if err := CompileC67(srcFile, exePath, Platform{Arch: arch, OS: OSLinux}); err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+
+			f, err := elf.Open(exePath)
+			if err != nil {
+				t.Fatalf("Failed to open ELF: %v", err)
+			}
+			defer f.Close()
+
+			text := f.Section(".text")
+			if text == nil {
+				t.Fatalf("No .text section")
+			}
+			symbols, err := f.Symbols()
+			if err != nil {
+				t.Fatalf("Failed to read .symtab: %v", err)
+			}
+			found := make(map[string]bool)
+			end := text.Addr
+			for _, sym := range symbols {
+				if elf.ST_TYPE(sym.Info) != elf.STT_FUNC || sym.Size == 0 {
+					t.Errorf("%s: want a sized FUNC symbol, got type %v, size %d", sym.Name, elf.ST_TYPE(sym.Info), sym.Size)
+				}
+				if sym.Value < end || sym.Value+sym.Size > text.Addr+text.Size {
+					t.Errorf("%s at 0x%x overlaps the previous function or is outside .text", sym.Name, sym.Value)
+				}
+				end = sym.Value + sym.Size
+				found[sym.Name] = true
+			}
+			for _, name := range []string{"c67.main", "_c67_itoa"} {
+				if !found[name] {
+					t.Errorf("No symbol for %s in %v", name, found)
+				}
+			}
+			if !found["sq"] && !found["lambda_1"] {
+				t.Errorf("No symbol for the lambda in %v", found)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+// Completion: 100% - --entry and freestanding --no-main executables (x86-64)
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// entry.go - Custom entry points and freestanding executables
+//
+//	c67 --entry serve app.c67
+//	c67 --no-main --entry kmain kernel.c67
+//
+// A program ends by calling main, if there is one, and exits with what it
+// returns. --entry names the function to call there instead, for code that
+// is built with several entry points. The top-level statements still run
+// before it, as they do before main.
+//
+// --no-main builds a freestanding executable for Linux x86-64: a static ELF
+// with no interpreter, no libc and no PLT, whose entry point is a bare
+// _start. _start sets up the arena, stores the top-level functions, calls
+// the entry function (main, or the one named with --entry) and exits with
+// its result, after writing out what is buffered for stdout. Since nothing
+// else runs, the top level may only define functions and constants, and C
+// functions cannot be called. The executable has its own memcpy, memmove,
+// memset and strlen for the runtime helpers. The other libc functions they
+// use, mostly on error paths such as a failed allocation, are replaced by a
+// stub that names the function and exits with status 127.
+
+// libcStubStatus is the exit status of a libc function called with --no-main
+const libcStubStatus = 127
+
+// entryName returns the name of the function the program starts at
+func entryName() string {
+	if EntryFlag != "" {
+		return EntryFlag
+	}
+	return "main"
+}
+
+// isFunctionDefinition reports whether expr defines a function
+func isFunctionDefinition(expr Expression) bool {
+	switch expr.(type) {
+	case *LambdaExpr, *PatternLambdaExpr, *MultiLambdaExpr:
+		return true
+	}
+	return false
+}
+
+// checkEntry returns an error when --entry or --no-main can not build program
+func (fc *C67Compiler) checkEntry(program *Program) error {
+	if EntryFlag == "" && !NoMainFlag {
+		return nil
+	}
+	if fc.eb.target.Arch() != ArchX86_64 {
+		return fmt.Errorf("--entry and --no-main are only supported on x86-64")
+	}
+	if NoMainFlag && fc.eb.target.OS() != OSLinux {
+		return fmt.Errorf("--no-main is only supported on Linux x86-64")
+	}
+
+	entry := entryName()
+	found := false
+	for _, stmt := range program.Statements {
+		if assign, ok := stmt.(*AssignStmt); ok && assign.Name == entry && isFunctionDefinition(assign.Value) {
+			found = true
+		}
+	}
+	if !found {
+		if EntryFlag == "" {
+			return fmt.Errorf("--no-main: there is no function named main; name the entry function with --entry")
+		}
+		return fmt.Errorf("--entry: there is no function named %s", entry)
+	}
+	if !NoMainFlag {
+		return nil
+	}
+
+	for _, stmt := range program.Statements {
+		switch s := stmt.(type) {
+		case *UseStmt, *ImportStmt, *ExportStmt, *AliasStmt:
+			continue
+		case *AssignStmt:
+			switch s.Value.(type) {
+			case *NumberExpr, *StringExpr:
+				if !s.IsUpdate {
+					continue
+				}
+			default:
+				if !s.IsUpdate && isFunctionDefinition(s.Value) {
+					continue
+				}
+			}
+		case *CImportStmt:
+			return fmt.Errorf("--no-main does not link C libraries (%s)", s.Library)
+		}
+		return fmt.Errorf("--no-main: the top level may only define functions and constants, since only %s runs: %s", entry, stmt.String())
+	}
+
+	var cCall string
+	visitAST(reflect.ValueOf(program.Statements), func(node any) {
+		if call, ok := node.(*CallExpr); ok && call.IsCFFI && cCall == "" {
+			cCall = call.Function
+		}
+	})
+	if cCall != "" {
+		return fmt.Errorf("--no-main does not link libc (c.%s)", cCall)
+	}
+	return nil
+}
+
+// writeFreestandingELF writes the static executable of --no-main
+func (fc *C67Compiler) writeFreestandingELF(program *Program, outputPath string) error {
+	if len(fc.usedDataSymbols) > 0 {
+		return fmt.Errorf("--no-main does not link libc (%s)", fc.usedDataSymbols[0])
+	}
+
+	if err := fc.defineProgramTables(); err != nil {
+		return err
+	}
+
+	// The first pass only decided what goes in; nothing has an address yet,
+	// so one more pass generates the code for the layout below
+	if err := fc.regenerateTextWithRuntimeInit(program, "_start"); err != nil {
+		return err
+	}
+	if err := fc.generateLibcStubs(); err != nil {
+		return err
+	}
+	fc.emitCrashSymbols()
+
+	// .text starts on the second page, after the headers. .rodata and .data
+	// follow it on the next page, writable, as in the dynamic executables.
+	textOffset := uint64(pageSize)
+	textAddr := uint64(baseAddr) + textOffset
+	rodataOffset := (textOffset + uint64(fc.eb.text.Len()) + pageSize - 1) &^ (pageSize - 1)
+	rodataAddr := uint64(baseAddr) + rodataOffset
+
+	fc.eb.rodata.Reset()
+	rodataSymbols := fc.eb.RodataSection()
+	rodataNames := make([]string, 0, len(rodataSymbols))
+	for name := range rodataSymbols {
+		rodataNames = append(rodataNames, name)
+	}
+	sort.Strings(rodataNames)
+	for _, name := range rodataNames {
+		if strings.HasPrefix(name, "str_") {
+			for fc.eb.rodata.Len()%8 != 0 {
+				fc.eb.rodata.WriteByte(0)
+			}
+		}
+		fc.eb.DefineAddr(name, rodataAddr+uint64(fc.eb.rodata.Len()))
+		fc.eb.WriteRodata([]byte(rodataSymbols[name]))
+	}
+	for fc.eb.rodata.Len()%8 != 0 {
+		fc.eb.rodata.WriteByte(0)
+	}
+
+	fc.eb.data.Reset()
+	dataAddr := rodataAddr + uint64(fc.eb.rodata.Len())
+	dataSymbols := fc.eb.DataSection()
+	dataNames := make([]string, 0, len(dataSymbols))
+	for name := range dataSymbols {
+		dataNames = append(dataNames, name)
+	}
+	sort.Strings(dataNames)
+	for _, name := range dataNames {
+		fc.eb.DefineAddr(name, dataAddr+uint64(fc.eb.data.Len()))
+		fc.eb.WriteData([]byte(dataSymbols[name]))
+	}
+
+	fc.eb.PatchPCRelocations(textAddr, rodataAddr, fc.eb.rodata.Len())
+	fc.eb.PatchCallSites(textAddr)
+	fc.patchHotFunctionTable()
+
+	fc.eb.elf.Reset()
+	fc.eb.writeStaticELFHeaders(textAddr+uint64(fc.eb.labels["_start"]), textOffset, rodataOffset)
+	for uint64(fc.eb.elf.Len()) < textOffset {
+		fc.eb.elf.WriteByte(0)
+	}
+	fc.eb.elf.Write(fc.eb.text.Bytes())
+	for uint64(fc.eb.elf.Len()) < rodataOffset {
+		fc.eb.elf.WriteByte(0)
+	}
+	fc.eb.elf.Write(fc.eb.rodata.Bytes())
+	fc.eb.elf.Write(fc.eb.data.Bytes())
+	fc.eb.appendSymbolTable(textOffset, textAddr)
+
+	return os.WriteFile(outputPath, fc.eb.elf.Bytes(), 0o755)
+}
+
+// freestandingLibc are the libc functions the helpers use for memory and
+// strings, which --no-main executables get their own copy of
+var freestandingLibc = map[string]func(fc *C67Compiler){
+	"memcpy":  (*C67Compiler).generateFreestandingMemcpy,
+	"memmove": (*C67Compiler).generateFreestandingMemmove,
+	"memset":  (*C67Compiler).generateFreestandingMemset,
+	"strlen":  (*C67Compiler).generateFreestandingStrlen,
+}
+
+// generateFreestandingMemcpy emits memcpy(rdi=dest, rsi=src, rdx=n) -> rax=dest
+func (fc *C67Compiler) generateFreestandingMemcpy() {
+	fc.out.MovRegToReg("rax", "rdi")
+	fc.out.MovRegToReg("rcx", "rdx")
+	fc.out.Emit([]byte{0xf3, 0xa4}) // rep movsb
+	fc.out.Ret()
+}
+
+// generateFreestandingMemmove emits memmove(rdi=dest, rsi=src, rdx=n) ->
+// rax=dest, which copies backwards when dest is after src
+func (fc *C67Compiler) generateFreestandingMemmove() {
+	fc.out.MovRegToReg("rax", "rdi")
+	fc.out.MovRegToReg("rcx", "rdx")
+	fc.out.CmpRegToReg("rdi", "rsi")
+	forward := fc.forwardJump(JumpBelowOrEqual)
+	fc.out.TestRegReg("rcx", "rcx")
+	empty := fc.forwardJump(JumpEqual)
+	fc.out.LeaMemToReg("rsi", "rsi", -1)
+	fc.out.AddRegToReg("rsi", "rcx")
+	fc.out.LeaMemToReg("rdi", "rdi", -1)
+	fc.out.AddRegToReg("rdi", "rcx")
+	fc.out.Emit([]byte{0xfd})       // std
+	fc.out.Emit([]byte{0xf3, 0xa4}) // rep movsb
+	fc.out.Emit([]byte{0xfc})       // cld
+	fc.landForwardJump(empty)
+	fc.out.Ret()
+	fc.landForwardJump(forward)
+	fc.out.Emit([]byte{0xf3, 0xa4}) // rep movsb
+	fc.out.Ret()
+}
+
+// generateFreestandingMemset emits memset(rdi=dest, rsi=byte, rdx=n) -> rax=dest
+func (fc *C67Compiler) generateFreestandingMemset() {
+	fc.out.MovRegToReg("r8", "rdi")
+	fc.out.MovRegToReg("rax", "rsi")
+	fc.out.MovRegToReg("rcx", "rdx")
+	fc.out.Emit([]byte{0xf3, 0xaa}) // rep stosb
+	fc.out.MovRegToReg("rax", "r8")
+	fc.out.Ret()
+}
+
+// generateFreestandingStrlen emits strlen(rdi=s) -> rax
+func (fc *C67Compiler) generateFreestandingStrlen() {
+	fc.out.MovRegToReg("rax", "rdi")
+	loopStart := fc.eb.text.Len()
+	fc.out.MovU8MemToReg("rcx", "rax", 0)
+	fc.out.TestRegReg("rcx", "rcx")
+	done := fc.forwardJump(JumpEqual)
+	fc.out.IncReg("rax")
+	fc.out.JumpUnconditional(int32(loopStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(done)
+	fc.out.SubRegFromReg("rax", "rdi")
+	fc.out.Ret()
+}
+
+// generateLibcStubs emits the libc functions the code calls, from
+// freestandingLibc or as a stub that reports it and exits, and returns an error for any other call that
+// has nothing to go to
+func (fc *C67Compiler) generateLibcStubs() error {
+	var missing []string
+	seen := make(map[string]bool)
+	for _, patch := range fc.eb.callPatches {
+		name, isLibc := strings.CutSuffix(patch.targetName, "$stub")
+		if seen[patch.targetName] || fc.eb.LabelOffset(patch.targetName) >= 0 || fc.eb.LabelOffset(name) >= 0 {
+			continue
+		}
+		seen[patch.targetName] = true
+		if !isLibc {
+			missing = append(missing, name)
+			continue
+		}
+		if generate, ok := freestandingLibc[name]; ok {
+			fc.eb.MarkFunction(patch.targetName)
+			generate(fc)
+			continue
+		}
+
+		msg := "Error: " + name + " is not available with --no-main\n"
+		msgLabel := "_c67_no_libc_" + name
+		fc.eb.Define(msgLabel, msg)
+		fc.eb.MarkFunction(patch.targetName)
+		fc.out.MovImmToReg("rdi", "2")
+		fc.out.LeaSymbolToReg("rsi", msgLabel)
+		fc.out.MovImmToReg("rdx", strconv.Itoa(len(msg)))
+		fc.out.MovImmToReg("rax", "1") // write
+		fc.out.Syscall()
+		fc.out.MovImmToReg("rdi", strconv.Itoa(libcStubStatus))
+		fc.out.MovImmToReg("rax", "231") // exit_group
+		fc.out.Syscall()
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("--no-main: %s is not available", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// writeStaticELFHeaders writes the ELF header and program headers of a
+// static executable: the headers and .text read and execute, .rodata and
+// .data at rodataOffset read and write, and a stack that does not execute
+func (eb *ExecutableBuilder) writeStaticELFHeaders(entry, textOffset, rodataOffset uint64) {
+	const numProgHeaders = 3
+	w := eb.ELFWriter()
+
+	w.Write(0x7f)
+	w.Write(0x45) // E
+	w.Write(0x4c) // L
+	w.Write(0x46) // F
+	w.Write(2)    // 64-bit
+	w.Write(1)    // little endian
+	w.Write(1)    // ELF version
+	w.Write(0)    // System V
+	w.WriteN(0, 8)
+	w.Write2(2) // EXEC
+	w.Write2(byte(GetELFMachineType(eb.target.Arch())))
+	w.Write4(1)
+
+	w.Write8u(entry)
+	w.Write8u(elfHeaderSize)
+	w.Write8u(0) // section headers are added by appendSymbolTable
+	w.Write4(0)
+	w.Write2(byte(elfHeaderSize))
+	w.Write2(byte(progHeaderSize))
+	w.Write2(byte(numProgHeaders))
+	w.Write2(0)
+	w.Write2(0)
+	w.Write2(0)
+
+	// PT_LOAD (headers and text)
+	textEnd := textOffset + uint64(eb.text.Len())
+	w.Write4(1) // PT_LOAD
+	w.Write4(5) // PF_R | PF_X
+	w.Write8u(0)
+	w.Write8u(baseAddr)
+	w.Write8u(baseAddr)
+	w.Write8u(textEnd)
+	w.Write8u(textEnd)
+	w.Write8u(pageSize)
+
+	// PT_LOAD (rodata and data)
+	rwSize := uint64(eb.rodata.Len() + eb.data.Len())
+	w.Write4(1) // PT_LOAD
+	w.Write4(6) // PF_R | PF_W
+	w.Write8u(rodataOffset)
+	w.Write8u(baseAddr + rodataOffset)
+	w.Write8u(baseAddr + rodataOffset)
+	w.Write8u(rwSize)
+	w.Write8u(rwSize)
+	w.Write8u(pageSize)
+
+	w.WriteUnsigned(0x6474e551) // PT_GNU_STACK
+	w.Write4(6)                 // PF_R | PF_W
+	w.WriteN(0, 40)
+	w.Write8u(16)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"debug/elf"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestEntryFlag tests that --entry calls the named function instead of main
+func TestEntryFlag(t *testing.T) {
+	defer func() { EntryFlag = "" }()
+	EntryFlag = "start"
+	source := `println("top")
+main = () -> 1
+start = () -> {
+	println("start")
+	7
+}
+`
+	stdout, _, exitCode := runCommandSeparate(exec.Command(compileTestCode(t, source)))
+	if stdout != "top\nstart\n" || exitCode != 7 {
+		t.Errorf("Expected \"top\\nstart\\n\" and exit code 7, got %q and %d", stdout, exitCode)
+	}
+}
+
+// TestNoMain tests that --no-main builds a static executable without an
+// interpreter that runs the entry function
+func TestNoMain(t *testing.T) {
+	defer func() { EntryFlag = ""; NoMainFlag = false }()
+	EntryFlag = "kmain"
+	NoMainFlag = true
+	source := `fib = n -> {
+	| n < 2 => n
+	~> fib(n - 1) + fib(n - 2)
+}
+greeting = "hello, "
+kmain = () -> {
+	println(greeting + "world")
+	fib(10)
+}
+`
+	binary := compileTestCode(t, source)
+	f, err := elf.Open(binary)
+	if err != nil {
+		t.Fatalf("Failed to open ELF: %v", err)
+	}
+	defer f.Close()
+	if f.Type != elf.ET_EXEC {
+		t.Errorf("Expected ET_EXEC, got %v", f.Type)
+	}
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_INTERP || prog.Type == elf.PT_DYNAMIC {
+			t.Errorf("Expected no %v program header", prog.Type)
+		}
+	}
+
+	stdout, _, exitCode := runCommandSeparate(exec.Command(binary))
+	if stdout != "hello, world\n" || exitCode != 55 {
+		t.Errorf("Expected \"hello, world\\n\" and exit code 55, got %q and %d", stdout, exitCode)
+	}
+}
+
+// TestNoMainRejects tests what --entry and --no-main do not build
+func TestNoMainRejects(t *testing.T) {
+	defer func() { EntryFlag = ""; NoMainFlag = false }()
+	for _, tt := range []struct {
+		entry  string
+		noMain bool
+		source string
+		want   string
+	}{
+		{"start", false, "main = () -> 0\n", "no function named start"},
+		{"", true, "f = () -> 0\n", "no function named main"},
+		{"", true, "println(1)\nmain = () -> 0\n", "the top level may only define"},
+		{"", true, "main = () -> c.getpid()\n", "does not link libc"},
+	} {
+		EntryFlag, NoMainFlag = tt.entry, tt.noMain
+		if _, err := compileTestCodeAllowError(t, tt.source); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Expected an error with %q for %q, got %v", tt.want, tt.source, err)
+		}
+	}
+}
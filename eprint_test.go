@@ -4,6 +4,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
 	"testing"
@@ -138,6 +139,55 @@ func TestEprintFormatted(t *testing.T) {
 	}
 }
 
+// TestLogging tests the log built-ins in text and JSON form and the level switch
+func TestLogging(t *testing.T) {
+	code := `host := "db\"1"
+loginfo("listening", "port", 8080, "host", host)
+logdebug("cache miss", "key", 7)
+logwarn("slow query", "ms", 12.5)
+println("done")
+`
+	binary := compileTestCode(t, code)
+	timestamp := regexp.MustCompile(`\d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ`)
+
+	tests := []struct {
+		name       string
+		env        []string
+		wantStderr string
+	}{
+		{
+			name:       "text",
+			wantStderr: "T INFO listening port=8080 host=db\"1\nT WARN slow query ms=12.5\n",
+		},
+		{
+			name: "json_debug",
+			env:  []string{"C67_LOG_FORMAT=json", "C67_LOG_LEVEL=debug"},
+			wantStderr: `{"time":"T","level":"info","msg":"listening","port":8080,"host":"db\"1"}` + "\n" +
+				`{"time":"T","level":"debug","msg":"cache miss","key":7}` + "\n" +
+				`{"time":"T","level":"warn","msg":"slow query","ms":12.5}` + "\n",
+		},
+		{
+			name:       "off",
+			env:        []string{"C67_LOG_LEVEL=off"},
+			wantStderr: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exec.Command(binary)
+			cmd.Env = append(os.Environ(), tt.env...)
+			stdout, stderr, _ := runCommandSeparate(cmd)
+			if stdout != "done\n" {
+				t.Errorf("stdout = %q, want %q", stdout, "done\n")
+			}
+			if got := timestamp.ReplaceAllString(stderr, "T"); got != tt.wantStderr {
+				t.Errorf("stderr = %q, want %q", got, tt.wantStderr)
+			}
+		})
+	}
+}
+
 // compileTestCode compiles C67 code and returns the path to the executable
 func compileTestCode(t *testing.T, code string) string {
 	t.Helper()
@@ -188,3 +238,53 @@ func runCommandSeparate(cmd *exec.Cmd) (stdout, stderr string, exitCode int) {
 
 	return
 }
+
+// TestArgsAndFlags tests args() and the flags() command-line parser
+func TestArgsAndFlags(t *testing.T) {
+	code := `a := args()
+println(#a)
+first: str = a[1]
+println(first)
+opts := flags()
+println(opts.verbose)
+println(opts.quiet)
+name: str = opts.name
+println(name)
+key := :level
+level: str = opts[key]
+println(level)
+input: str = opts[0]
+println(input)
+rest: str = opts[1]
+println(rest)
+`
+	binary := compileTestCode(t, code)
+	cmd := exec.Command(binary, "in.txt", "--verbose", "--name=bob", "--level=1", "--level=3", "--", "--quiet")
+	stdout, _, exitCode := runCommandSeparate(cmd)
+	if exitCode != 0 {
+		t.Fatalf("exit code = %d", exitCode)
+	}
+	want := "8\nin.txt\n1\n0\nbob\n3\nin.txt\n--quiet\n"
+	if stdout != want {
+		t.Errorf("stdout = %q, want %q", stdout, want)
+	}
+}
+
+// TestRun tests run() and piping strings through external commands
+func TestRun(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(input, []byte("pear\napple\npear\nfig\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	code := `print(run("echo hello"))
+print(readfile("` + input + `") | run("sort") | run("uniq"))
+print(run("printf 'b\\na\\n'") | run("sort") | run("head -n 1"))
+count := "x\ny\n" | run("cat") | run("wc -l")
+print(count)
+`
+	result := compileAndRun(t, code)
+	want := "hello\napple\nfig\npear\na\n2\n"
+	if result != want {
+		t.Errorf("output = %q, want %q", result, want)
+	}
+}
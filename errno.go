@@ -0,0 +1,79 @@
+// Completion: 100% - cerrno() and cerrstr() after C calls
+package main
+
+// errno.go - errno access for C FFI code
+//
+//	f = libc.fopen(path, "r")
+//	why = cerrstr()
+//	f == 0 {
+//	    println(f"cannot open {path}: {why}")
+//	}
+//
+// cerrno() is the current errno of the calling thread, read through
+// __errno_location() on Linux and __error() on macOS. cerrstr() is the
+// strerror() text for it, and cerrstr(code) the text for a given code.
+// errno is only meaningful right after a C call that reports failure, and
+// later C calls, including those made by the runtime, may change it, so
+// read it before doing anything else.
+
+// errnoLocation returns the libc function that gives the address of errno
+func (fc *C67Compiler) errnoLocation() string {
+	if fc.eb.target.OS() == OSDarwin {
+		return "__error"
+	}
+	return "__errno_location"
+}
+
+// compileErrnoCall compiles cerrno() and cerrstr()
+func (fc *C67Compiler) compileErrnoCall(call *CallExpr) {
+	if call.Function == "cerrno" && len(call.Args) != 0 {
+		compilerError("cerrno() takes no arguments")
+	}
+	if call.Function == "cerrstr" && len(call.Args) > 1 {
+		compilerError("cerrstr() takes at most 1 argument (errno)")
+	}
+	if fc.eb.target.OS() != OSLinux && fc.eb.target.OS() != OSDarwin {
+		compilerError("%s() is only supported on Linux and macOS", call.Function)
+	}
+	for _, name := range []string{fc.errnoLocation(), "strerror", "strlen"} {
+		fc.trackFunctionCall(name)
+	}
+	fc.usesErrno = true
+
+	if call.Function == "cerrstr" && len(call.Args) == 1 {
+		fc.compileExpression(call.Args[0])
+	} else {
+		fc.out.CallSymbol("_c67_cerrno")
+	}
+	if call.Function == "cerrstr" {
+		fc.out.Cvttsd2si("rdi", "xmm0")
+		fc.out.CallSymbol("_c67_cerrstr")
+	}
+}
+
+// generateErrnoHelpers emits _c67_cerrno() -> xmm0 and
+// _c67_cerrstr(rdi=code) -> xmm0
+func (fc *C67Compiler) generateErrnoHelpers() {
+	fc.eb.MarkFunction("_c67_cerrno")
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.AndRegWithImm("rsp", -16)
+	fc.eb.GenerateCallInstruction(fc.errnoLocation())
+	fc.out.Emit([]byte{0x48, 0x63, 0x00}) // movsxd rax, dword [rax]
+	fc.out.Cvtsi2sd("xmm0", "rax")
+	fc.out.MovRegToReg("rsp", "rbp")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+
+	// strerror() may reuse its buffer, so the text is copied right away
+	fc.eb.MarkFunction("_c67_cerrstr")
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.AndRegWithImm("rsp", -16)
+	fc.eb.GenerateCallInstruction("strerror")
+	fc.out.MovRegToReg("rdi", "rax")
+	fc.out.CallSymbol("cstr_to_c67_string")
+	fc.out.MovRegToReg("rsp", "rbp")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
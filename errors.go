@@ -3,7 +3,9 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"strings"
+	"unicode/utf8"
 )
 
 // ErrorLevel indicates the severity of an error
@@ -75,6 +77,14 @@ type ErrorContext struct {
 	HelpText   string // Explanatory help text
 }
 
+// ErrorNote points at a related place in the source, such as the '{' that
+// an unexpected end of file leaves unclosed
+type ErrorNote struct {
+	Message    string
+	Location   SourceLocation
+	SourceLine string
+}
+
 // CompilerError represents a single compilation error
 type CompilerError struct {
 	Level    ErrorLevel
@@ -82,6 +92,7 @@ type CompilerError struct {
 	Message  string
 	Location SourceLocation
 	Context  ErrorContext
+	Notes    []ErrorNote
 }
 
 // Error implements the error interface
@@ -117,35 +128,29 @@ func (e CompilerError) Format(useColor bool) string {
 	sb.WriteString("\n")
 
 	// Source context
-	if e.Context.SourceLine != "" {
-		lineNum := fmt.Sprintf("%d", e.Location.Line)
-		padding := strings.Repeat(" ", len(lineNum)+1)
-
-		sb.WriteString(padding)
-		sb.WriteString("|\n")
-		sb.WriteString(lineNum)
-		sb.WriteString(" | ")
-		sb.WriteString(e.Context.SourceLine)
-		sb.WriteString("\n")
-		sb.WriteString(padding)
-		sb.WriteString("| ")
+	writeSnippet(&sb, e.Location, e.Context.SourceLine, '^', "\033[1;31m", useColor)
 
-		// Underline the error position
-		if e.Location.Column > 0 {
-			sb.WriteString(strings.Repeat(" ", e.Location.Column-1))
-			if useColor {
-				sb.WriteString("\033[1;31m") // Bold red
-			}
-			if e.Location.Length > 0 {
-				sb.WriteString(strings.Repeat("^", e.Location.Length))
-			} else {
-				sb.WriteString("^")
-			}
-			if useColor {
-				sb.WriteString("\033[0m")
-			}
-			sb.WriteString("\n")
+	// Related places
+	for _, note := range e.Notes {
+		if useColor {
+			sb.WriteString("\033[1;36m") // Bold cyan
+		}
+		sb.WriteString("note: ")
+		if useColor {
+			sb.WriteString("\033[0m")
 		}
+		sb.WriteString(note.Message)
+		sb.WriteString("\n")
+		if useColor {
+			sb.WriteString("\033[1;34m")
+		}
+		sb.WriteString("  --> ")
+		sb.WriteString(note.Location.String())
+		if useColor {
+			sb.WriteString("\033[0m")
+		}
+		sb.WriteString("\n")
+		writeSnippet(&sb, note.Location, note.SourceLine, '-', "\033[1;36m", useColor)
 	}
 
 	// Suggestion
@@ -177,6 +182,71 @@ func (e CompilerError) Format(useColor bool) string {
 	return sb.String()
 }
 
+// writeSnippet writes a source line with the span at loc underlined by
+// marker. The underline keeps the tabs of the line so that it lines up, and
+// is cut off at the end of the line for tokens that span lines.
+func writeSnippet(sb *strings.Builder, loc SourceLocation, line string, marker byte, color string, useColor bool) {
+	if line == "" {
+		return
+	}
+	lineNum := fmt.Sprintf("%d", loc.Line)
+	padding := strings.Repeat(" ", len(lineNum)+1)
+
+	sb.WriteString(padding)
+	sb.WriteString("|\n")
+	sb.WriteString(lineNum)
+	sb.WriteString(" | ")
+	sb.WriteString(line)
+	sb.WriteString("\n")
+	sb.WriteString(padding)
+	sb.WriteString("| ")
+
+	if loc.Column <= 0 {
+		sb.WriteString("\n")
+		return
+	}
+	start := min(loc.Column-1, len(line))
+	for _, r := range line[:start] {
+		if r == '\t' {
+			sb.WriteByte('\t')
+		} else {
+			sb.WriteByte(' ')
+		}
+	}
+	width := 1
+	if loc.Length > 0 {
+		width = max(utf8.RuneCountInString(line[start:min(start+loc.Length, len(line))]), 1)
+	}
+	if useColor {
+		sb.WriteString(color)
+	}
+	sb.WriteString(strings.Repeat(string(marker), width))
+	if useColor {
+		sb.WriteString("\033[0m")
+	}
+	sb.WriteString("\n")
+}
+
+// ColorMode is "always", "never" or "auto" from --color. In auto mode,
+// diagnostics are colored when stderr is a terminal, unless NO_COLOR is set
+// or TERM is dumb.
+var ColorMode = "auto"
+
+// useColor reports whether diagnostics should be colored
+func useColor() bool {
+	switch ColorMode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if _, set := os.LookupEnv("NO_COLOR"); set || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := os.Stderr.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
 // ErrorCollector accumulates errors during compilation
 type ErrorCollector struct {
 	errors     []CompilerError
@@ -208,6 +278,11 @@ func (ec *ErrorCollector) AddError(err CompilerError) {
 	if err.Context.SourceLine == "" && ec.sourceCode != "" {
 		err.Context.SourceLine = ec.getSourceLine(err.Location.Line)
 	}
+	for i, note := range err.Notes {
+		if note.SourceLine == "" && ec.sourceCode != "" {
+			err.Notes[i].SourceLine = ec.getSourceLine(note.Location.Line)
+		}
+	}
 
 	if err.Level == LevelFatal || err.Level == LevelError {
 		ec.errors = append(ec.errors, err)
@@ -0,0 +1,84 @@
+// Completion: 100% - Values leaving arena blocks
+package main
+
+// escape.go - Keeping arena-allocated values alive past their arena block
+//
+//	results := []
+//	total := arena {
+//	    row := [1, 2, 3]
+//	    results <- row
+//	    row
+//	}
+//
+// An arena block frees everything it allocated when it ends, so a list, map
+// or string that leaves the block would point into memory that the next
+// block reuses. The compiler knows the ways out: the value of an arena
+// expression, a value assigned to a variable or stored into a list or map
+// that was defined outside the block, and a value returned with ret from
+// inside the block. Unless the value is known to be a number, it is
+// deep-copied, as by deepcopy(), into the arena of its destination before
+// the block resets: the parent arena for the value of the block, the arena
+// the variable was defined in, and the global arena for ret.
+//
+// In a parallel loop body, an arena block rolls back the thread arena, which
+// has no older part to copy into. A value that would leave such a block,
+// say into a variable of the parent, which another thread then reads, is a
+// compile error. Assigning it outside the arena block is fine, since the
+// thread arena is kept then, as described in threadarena.go.
+
+// arenaLevel returns the number of arenas open where code is compiled: the
+// current arena, plus the arena blocks open in a parallel loop body
+func (fc *C67Compiler) arenaLevel() int {
+	return fc.currentArena + fc.threadArenaDepth
+}
+
+// compileEscapingStore is called with the value for the variable name in
+// xmm0. A new variable is remembered at the arena level of its definition;
+// for an update, the value is copied out of the arena blocks opened since.
+func (fc *C67Compiler) compileEscapingStore(name string, value Expression, isUpdate bool) {
+	if !isUpdate {
+		fc.varArenas[name] = fc.arenaLevel()
+		return
+	}
+	if level, known := fc.varArenas[name]; known && level < fc.arenaLevel() {
+		fc.copyOutOfArena(level, value, "a value stored into '"+name+"'")
+	}
+}
+
+// copyOutOfArena replaces the value in xmm0 with a deep copy in the arena at
+// level, unless it is a number
+func (fc *C67Compiler) copyOutOfArena(level int, value Expression, what string) {
+	if value == nil || fc.getExprType(value) == "number" {
+		return
+	}
+	if fc.threadArenaOffset != 0 {
+		if fc.threadArenaDepth > 0 {
+			compilerError("%s escapes an arena block in a parallel loop body; move it out of the arena block", what)
+		}
+		return // the thread arena is kept
+	}
+	fc.out.MovqXmmToReg("rdi", "xmm0")
+	fc.out.LeaSymbolToReg("rsi", "_c67_arena_meta")
+	fc.out.MovMemToReg("rsi", "rsi", 0)
+	fc.out.MovMemToReg("rsi", "rsi", (level-1)*8) // rsi = arena struct of the destination
+	fc.usesCopy = true
+	fc.out.SubImmFromReg("rsp", StackSlotSize)
+	fc.out.CallSymbol("_c67_deepcopy")
+	fc.out.AddImmToReg("rsp", StackSlotSize)
+	fc.out.MovqRegToXmm("xmm0", "rax")
+}
+
+// arenaResult returns the expression that gives the value of an arena
+// expression with the given body, or nil if there is none
+func arenaResult(body []Statement) Expression {
+	if len(body) == 0 {
+		return nil
+	}
+	switch s := body[len(body)-1].(type) {
+	case *AssignStmt:
+		return s.Value
+	case *ExpressionStmt:
+		return s.Expr
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+// Completion: 100% - fieldnames, getfield and setfield
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// fields.go - Reflection on maps used as records
+//
+// The field names of {name: "Ada", age: 36} are gone at runtime: the keys
+// are the hashes of the names (hashStringKey). fieldnames(m) gets them back
+// from a table of every name the compiler hashed, sorted by key bits so the
+// runtime can binary search it:
+//
+//	[n] [key0][offset0] [key1][offset1] ... the names as C67 strings
+//
+// An offset is from the start of the table, so the table needs no
+// relocations. fieldnames(m) returns the names in the order of the entries
+// of m, and leaves out keys that are not in the table: numbers, and names
+// that only a runtime intern(s) has seen.
+//
+// getfield(m, name) is m[hash(name)], and the statement setfield(m, name, v)
+// is m[hash(name)] <- v, which the parser turns into a map update, so m has
+// to be a mutable variable as for any update.
+
+// fieldNameTable returns the table of the names that have been hashed
+func fieldNameTable() []byte {
+	keys := make([]uint64, 0, len(keyNames))
+	for key := range keyNames {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return math.Float64bits(float64(keys[i])) < math.Float64bits(float64(keys[j]))
+	})
+
+	table := binary.LittleEndian.AppendUint64(nil, uint64(len(keys)))
+	var names []byte
+	for _, key := range keys {
+		offset := 8 + 16*len(keys) + len(names)
+		table = binary.LittleEndian.AppendUint64(table, math.Float64bits(float64(key)))
+		table = binary.LittleEndian.AppendUint64(table, uint64(offset))
+		runes := []rune(keyNames[key])
+		names = binary.LittleEndian.AppendUint64(names, math.Float64bits(float64(len(runes))))
+		for i, r := range runes {
+			names = binary.LittleEndian.AppendUint64(names, math.Float64bits(float64(i)))
+			names = binary.LittleEndian.AppendUint64(names, math.Float64bits(float64(r)))
+		}
+	}
+	return append(table, names...)
+}
+
+// compileFieldCall compiles fieldnames(m) and getfield(m, name). A
+// setfield(m, name, v) that gets here is not a statement of its own.
+func (fc *C67Compiler) compileFieldCall(call *CallExpr) {
+	switch call.Function {
+	case "fieldnames":
+		if len(call.Args) != 1 {
+			compilerError("fieldnames() requires exactly 1 argument")
+		}
+		if fc.getExprType(call.Args[0]) == "persistent" {
+			compilerError("fieldnames() needs a map, not a persistent collection")
+		}
+		fc.usesFieldnames = true
+		fc.compileExpression(call.Args[0])
+		fc.out.MovqXmmToReg("rdi", "xmm0")
+		fc.loadArenaPointer("rsi")
+		fc.trackFunctionCall("_c67_fieldnames")
+		fc.eb.GenerateCallInstruction("_c67_fieldnames")
+		fc.out.MovqRegToXmm("xmm0", "rax")
+
+	case "getfield":
+		if len(call.Args) != 2 {
+			compilerError("getfield() requires exactly 2 arguments (map, name)")
+		}
+		fc.compileExpression(&IndexExpr{
+			List:  call.Args[0],
+			Index: &CallExpr{Function: "hash", Args: call.Args[1:]},
+		})
+
+	case "setfield":
+		compilerError("setfield(m, name, value) is a statement; it needs a map variable and gives no value")
+	}
+}
+
+// generateFieldnamesHelper emits _c67_fieldnames(rdi=map, rsi=arena) -> rax,
+// a list of the names of the keys of the map, and the name table, which an
+// ELF has laid out before the helpers are generated (see writeELF)
+func (fc *C67Compiler) generateFieldnamesHelper() {
+	fc.eb.Define("_c67_field_names", string(fieldNameTable()))
+
+	fc.eb.MarkFunction("_c67_fieldnames")
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+
+	// r12 = map, rbx = its count, r14 = the list, room for every key
+	fc.out.MovRegToReg("r12", "rdi")
+	fc.out.MovMemToReg("rax", "r12", 0)
+	fc.out.MovqRegToXmm("xmm0", "rax")
+	fc.out.Cvttsd2si("rbx", "xmm0")
+	fc.out.MovRegToReg("rdi", "rsi")
+	fc.out.MovRegToReg("rsi", "rbx")
+	fc.out.ShlRegByImm("rsi", 4)
+	fc.out.AddImmToReg("rsi", 8)
+	fc.trackFunctionCall("c67_arena_alloc")
+	fc.out.CallSymbol("c67_arena_alloc")
+	fc.out.MovRegToReg("r14", "rax")
+
+	// r13 = entry number, rdi = names found, r8 = table, rsi = its size
+	fc.out.XorRegWithReg("r13", "r13")
+	fc.out.XorRegWithReg("rdi", "rdi")
+	fc.out.LeaSymbolToReg("r8", "_c67_field_names")
+	fc.out.MovMemToReg("rsi", "r8", 0)
+
+	loop := fc.eb.text.Len()
+	fc.out.CmpRegToReg("r13", "rbx")
+	done := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovRegToReg("rax", "r13")
+	fc.out.ShlRegByImm("rax", 4)
+	fc.out.AddRegToReg("rax", "r12")
+	fc.out.MovMemToReg("rdx", "rax", 8)
+
+	// Binary search for the key in rdx between r9 and r10
+	fc.out.XorRegWithReg("r9", "r9")
+	fc.out.MovRegToReg("r10", "rsi")
+	search := fc.eb.text.Len()
+	fc.out.CmpRegToReg("r9", "r10")
+	missing := fc.forwardJump(JumpAboveOrEqual)
+	fc.out.MovRegToReg("rcx", "r9")
+	fc.out.AddRegToReg("rcx", "r10")
+	fc.out.ShrRegByImm("rcx", 1)
+	fc.out.MovRegToReg("rax", "rcx")
+	fc.out.ShlRegByImm("rax", 4)
+	fc.out.AddRegToReg("rax", "r8")
+	fc.out.MovMemToReg("rax", "rax", 8)
+	fc.out.CmpRegToReg("rax", "rdx")
+	found := fc.forwardJump(JumpEqual)
+	below := fc.forwardJump(JumpBelow)
+	fc.out.MovRegToReg("r10", "rcx")
+	fc.out.JumpUnconditional(int32(search - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(below)
+	fc.out.MovRegToReg("r9", "rcx")
+	fc.out.IncReg("r9")
+	fc.out.JumpUnconditional(int32(search - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	// Append the name at the offset next to the key
+	fc.landForwardJump(found)
+	fc.out.ShlRegByImm("rcx", 4)
+	fc.out.AddRegToReg("rcx", "r8")
+	fc.out.MovMemToReg("rcx", "rcx", 16)
+	fc.out.AddRegToReg("rcx", "r8")
+	fc.out.MovRegToReg("rax", "rdi")
+	fc.out.ShlRegByImm("rax", 4)
+	fc.out.AddRegToReg("rax", "r14")
+	fc.out.MovRegToMem("rdi", "rax", 8)
+	fc.out.MovRegToMem("rcx", "rax", 16)
+	fc.out.IncReg("rdi")
+	fc.landForwardJump(missing)
+	fc.out.IncReg("r13")
+	fc.out.JumpUnconditional(int32(loop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(done)
+	fc.out.Cvtsi2sd("xmm0", "rdi")
+	fc.out.MovqXmmToReg("rax", "xmm0")
+	fc.out.MovRegToMem("rax", "r14", 0)
+	fc.out.MovRegToReg("rax", "r14")
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestFieldnames tests that fieldnames() gives the names of the keys in order, without the numeric ones
+func TestFieldnames(t *testing.T) {
+	source := `person := {name: "Ada", 7: 1, age: 36}
+names = fieldnames(person)
+first: str = names[0]
+second: str = names[1]
+println(#names)
+println(first)
+println(second)
+println(#fieldnames({}))
+`
+	result := compileAndRun(t, source)
+	if !strings.Contains(result, "2\nname\nage\n0\n") {
+		t.Errorf("Expected output to contain '2\\nname\\nage\\n0\\n', got: %s", result)
+	}
+}
+
+// TestGetfieldSetfield tests dynamic field access with runtime names
+func TestGetfieldSetfield(t *testing.T) {
+	source := `m := {x: 1, y: 2}
+field = "x"
+setfield(m, field, 10)
+setfield(m, "z", 5)
+println(getfield(m, field) + getfield(m, "y") + m.z)
+names = fieldnames(m)
+last: str = names[2]
+println(last)
+`
+	result := compileAndRun(t, source)
+	if !strings.Contains(result, "17\nz\n") {
+		t.Errorf("Expected output to contain '17\\nz\\n', got: %s", result)
+	}
+}
+
+// TestSetfieldAsValue tests that setfield() cannot be used as a value
+func TestSetfieldAsValue(t *testing.T) {
+	code := `main = {
+    m := {x: 1}
+    v = setfield(m, "x", 2)
+}
+`
+	_, err := compileTestCodeAllowError(t, code)
+	if err == nil || !strings.Contains(err.Error(), "setfield(m, name, value) is a statement") {
+		t.Errorf("Expected an error that setfield() is a statement, got: %v", err)
+	}
+}
+
+// TestKeyCollision tests that two names with the same map key stop the
+// compilation with both names
+func TestKeyCollision(t *testing.T) {
+	saved := keyNames
+	defer func() { keyNames = saved }()
+	keyNames = map[uint64]string{}
+
+	key := hashStringKey("width")
+	hashStringKey("width")
+	defer func() {
+		err, _ := recover().(error)
+		if err == nil || !strings.Contains(err.Error(), `"height" and "width"`) {
+			t.Errorf("Expected an error that names both fields, got %v", err)
+		}
+	}()
+	recordKeyName(key, "height")
+}
@@ -0,0 +1,242 @@
+// Completion: 100% - onfree() cleanup for C resources
+package main
+
+import "fmt"
+
+// finalizer.go - Running cleanup code when a C resource is no longer used
+//
+//	arena {
+//	    tex := onfree(sdl.SDL_CreateTexture(renderer, 0, 0, 64, 64), t -> {
+//	        sdl.SDL_DestroyTexture(t)
+//	    })
+//	    draw(tex)
+//	}
+//
+// onfree(handle, cleanup) returns handle and remembers to call cleanup with
+// it once the arena that is current at the call is reset: at the end of an
+// arena block, or by arena_reset(). The cleanups of an arena run newest
+// first, before the memory of the arena is reused, so they may still read
+// values allocated in it.
+//
+// Handles registered in the global arena are never reset, unless the
+// program is compiled with --gc. Then the collector runs the cleanup of
+// every handle that no root and no live object holds anymore, after the
+// collection, as gc.go describes. The comparison is by value, so a cleanup
+// that captures its handle instead of taking it as the argument keeps it
+// alive.
+//
+// Every onfree() adds a record to the list in _c67_finalizers, allocated
+// from the same arena:
+//
+//	[handle][cleanup closure][arena struct][next][seen by the collector]
+//
+// onfree() is not available in the body of a @@ loop, whose arena blocks
+// roll back the thread arena without a reset.
+
+// finalizerSize is the size of a record in the _c67_finalizers list
+const finalizerSize = 40
+
+// compileOnfreeCall compiles onfree(handle, cleanup)
+func (fc *C67Compiler) compileOnfreeCall(call *CallExpr) {
+	if len(call.Args) != 2 {
+		compilerError("onfree() requires exactly 2 arguments (handle, cleanup)")
+	}
+	if fc.threadArenaOffset != 0 {
+		compilerError("onfree() is not supported in a parallel loop body")
+	}
+	fc.usesFinalizers = true
+	fc.eb.DefineWritable("_c67_finalizers", "\x00\x00\x00\x00\x00\x00\x00\x00")
+
+	fc.out.SubImmFromReg("rsp", 16)
+	fc.compileExpression(call.Args[0])
+	fc.out.MovXmmToMem("xmm0", "rsp", 0)
+	fc.compileExpression(call.Args[1])
+	fc.out.MovXmmToMem("xmm0", "rsp", 8)
+
+	fc.out.MovImmToReg("rdi", fmt.Sprint(finalizerSize))
+	fc.callArenaAlloc()
+	fc.out.MovMemToReg("rcx", "rsp", 0)
+	fc.out.MovRegToMem("rcx", "rax", 0)
+	fc.out.MovMemToReg("rcx", "rsp", 8)
+	fc.out.MovRegToMem("rcx", "rax", 8)
+	fc.loadArenaPointer("rcx")
+	fc.out.MovRegToMem("rcx", "rax", 16)
+	fc.out.LeaSymbolToReg("rdx", "_c67_finalizers")
+	fc.out.MovMemToReg("rcx", "rdx", 0)
+	fc.out.MovRegToMem("rcx", "rax", 24)
+	fc.out.MovImmToMem(0, "rax", 32)
+	fc.out.MovRegToMem("rax", "rdx", 0)
+
+	fc.out.MovMemToXmm("xmm0", "rsp", 0) // onfree() gives the handle back
+	fc.out.AddImmToReg("rsp", 16)
+}
+
+// emitRunFinalizers runs the cleanups registered in the arena in rdi, from
+// c67_arena_reset. Preserves rdi.
+func (fc *C67Compiler) emitRunFinalizers() {
+	fc.out.PushReg("rdi")
+	fc.out.CallSymbol("_c67_take_finalizers")
+	fc.out.MovRegToReg("rdi", "rax")
+	fc.out.CallSymbol("_c67_run_finalizers")
+	fc.out.PopReg("rdi")
+}
+
+// generateFinalizerHelpers emits the runtime helpers behind onfree()
+func (fc *C67Compiler) generateFinalizerHelpers() {
+	fc.generateTakeFinalizers()
+	fc.generateRunFinalizers()
+}
+
+// generateTakeFinalizers emits _c67_take_finalizers(rdi=arena) -> rax, which
+// unlinks the records of the arena that the collector has not seen, and
+// returns them as a list in the same order. The seen flag of the records it
+// keeps is cleared. Clobbers rcx, rdx and r8-r9.
+func (fc *C67Compiler) generateTakeFinalizers() {
+	fc.eb.MarkFunction("_c67_take_finalizers")
+	fc.out.PushReg("rax")
+	fc.out.MovImmToMem(0, "rsp", 0) // head of the taken list
+	fc.out.MovRegToReg("r9", "rsp") // r9 = link to append to
+	fc.out.LeaSymbolToReg("rdx", "_c67_finalizers")
+
+	walk := fc.eb.text.Len()
+	fc.out.MovMemToReg("rcx", "rdx", 0)
+	fc.out.TestRegReg("rcx", "rcx")
+	done := fc.forwardJump(JumpEqual)
+	fc.out.MovMemToReg("r8", "rcx", 16)
+	fc.out.CmpRegToReg("r8", "rdi")
+	other := fc.forwardJump(JumpNotEqual)
+	fc.out.MovMemToReg("r8", "rcx", 32)
+	fc.out.TestRegReg("r8", "r8")
+	seen := fc.forwardJump(JumpNotEqual)
+	fc.out.MovMemToReg("r8", "rcx", 24)
+	fc.out.MovRegToMem("r8", "rdx", 0) // unlink
+	fc.out.MovImmToMem(0, "rcx", 24)
+	fc.out.MovRegToMem("rcx", "r9", 0) // append
+	fc.out.LeaMemToReg("r9", "rcx", 24)
+	fc.out.JumpUnconditional(int32(walk - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(other)
+	fc.landForwardJump(seen)
+	fc.out.MovImmToMem(0, "rcx", 32)
+	fc.out.LeaMemToReg("rdx", "rcx", 24)
+	fc.out.JumpUnconditional(int32(walk - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(done)
+	fc.out.PopReg("rax")
+	fc.out.Ret()
+}
+
+// generateRunFinalizers emits _c67_run_finalizers(rdi=list), which calls the
+// cleanup of every record in a list from _c67_take_finalizers with its
+// handle. The cleanups are C67 code, so everything the caller may still
+// use is saved: rbx, r12-r15 and xmm0.
+func (fc *C67Compiler) generateRunFinalizers() {
+	fc.eb.MarkFunction("_c67_run_finalizers")
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	saved := []string{"rbx", "r12", "r13", "r14", "r15"}
+	for _, reg := range saved {
+		fc.out.PushReg(reg)
+	}
+	fc.out.SubImmFromReg("rsp", 32)
+	fc.out.AndRegWithImm("rsp", -16) // called from any safepoint
+	fc.out.MovupdXmmToMem("xmm0", "rsp", 0)
+	fc.out.MovRegToMem("rdi", "rsp", 16) // the record to run next
+
+	next := fc.eb.text.Len()
+	fc.out.MovMemToReg("rax", "rsp", 16)
+	fc.out.TestRegReg("rax", "rax")
+	done := fc.forwardJump(JumpEqual)
+	fc.out.MovMemToReg("rcx", "rax", 24)
+	fc.out.MovRegToMem("rcx", "rsp", 16)
+	fc.out.MovMemToXmm("xmm0", "rax", 0) // the handle
+	fc.out.MovMemToReg("rax", "rax", 8)  // the closure
+	fc.out.MovMemToReg("r11", "rax", 0)  // function pointer
+	fc.out.MovMemToReg("r15", "rax", 8)  // environment
+	fc.out.CallRegister("r11")
+	fc.out.JumpUnconditional(int32(next - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(done)
+	fc.out.MovupdMemToXmm("xmm0", "rsp", 0)
+	fc.out.LeaMemToReg("rsp", "rbp", -8*len(saved))
+	for i := len(saved) - 1; i >= 0; i-- {
+		fc.out.PopReg(saved[i])
+	}
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// emitGCMarkFinalizers marks the onfree() records of the global arena, which
+// are objects in the collected heap, without scanning them
+func (fc *C67Compiler) emitGCMarkFinalizers() {
+	fc.out.LeaSymbolToReg("rdx", "_c67_arena_meta")
+	fc.out.MovMemToReg("rdx", "rdx", 0)
+	fc.out.MovMemToReg("rdx", "rdx", 0) // rdx = global arena
+	fc.out.LeaSymbolToReg("rcx", "_c67_finalizers")
+	fc.out.MovMemToReg("rcx", "rcx", 0)
+	walk := fc.eb.text.Len()
+	fc.out.TestRegReg("rcx", "rcx")
+	done := fc.forwardJump(JumpEqual)
+	fc.out.MovMemToReg("rax", "rcx", 16)
+	fc.out.CmpRegToReg("rax", "rdx")
+	other := fc.forwardJump(JumpNotEqual)
+	fc.out.MovMemToReg("rax", "rcx", -8)
+	fc.out.OrRegWithImm("rax", 1) // the mark bit of the header
+	fc.out.MovRegToMem("rax", "rcx", -8)
+	fc.landForwardJump(other)
+	fc.out.MovMemToReg("rcx", "rcx", 24)
+	fc.out.JumpUnconditional(int32(walk - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(done)
+}
+
+// emitGCRunFinalizers runs the cleanups of the unreachable handles in the
+// global arena at the end of a collection. Allocations in the cleanups do
+// not start another collection until they are done.
+func (fc *C67Compiler) emitGCRunFinalizers() {
+	fc.emitGCPause()
+	fc.out.LeaSymbolToReg("rdi", "_c67_arena_meta")
+	fc.out.MovMemToReg("rdi", "rdi", 0)
+	fc.out.MovMemToReg("rdi", "rdi", 0)
+	fc.out.CallSymbol("_c67_take_finalizers")
+	fc.out.MovRegToReg("rdi", "rax")
+	fc.out.CallSymbol("_c67_run_finalizers")
+	fc.emitGCResume()
+}
+
+// generateGCScanHandles emits _c67_gc_scan_handles(rdi=start, rsi=end),
+// which _c67_gc_scan_range calls first. Every onfree() record whose handle
+// equals a word in [start, end) is flagged as seen. Preserves rdi and rsi,
+// clobbers rax, rcx, rdx and r8-r9.
+func (fc *C67Compiler) generateGCScanHandles() {
+	fc.eb.MarkFunction("_c67_gc_scan_handles")
+	fc.out.LeaSymbolToReg("r9", "_c67_finalizers")
+	fc.out.MovMemToReg("r9", "r9", 0)
+	fc.out.TestRegReg("r9", "r9")
+	none := fc.forwardJump(JumpEqual)
+	fc.out.LeaMemToReg("rcx", "rdi", 7)
+	fc.out.AndRegWithImm("rcx", -8)
+
+	word := fc.eb.text.Len()
+	fc.out.LeaMemToReg("rax", "rcx", 8)
+	fc.out.CmpRegToReg("rax", "rsi")
+	done := fc.forwardJump(JumpAbove)
+	fc.out.MovMemToReg("rdx", "rcx", 0)
+	fc.out.MovRegToReg("r8", "r9")
+	record := fc.eb.text.Len()
+	fc.out.TestRegReg("r8", "r8")
+	nextWord := fc.forwardJump(JumpEqual)
+	fc.out.MovMemToReg("rax", "r8", 0)
+	fc.out.CmpRegToReg("rax", "rdx")
+	other := fc.forwardJump(JumpNotEqual)
+	fc.out.MovImmToMem(1, "r8", 32)
+	fc.landForwardJump(other)
+	fc.out.MovMemToReg("r8", "r8", 24)
+	fc.out.JumpUnconditional(int32(record - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(nextWord)
+	fc.out.AddImmToReg("rcx", 8)
+	fc.out.JumpUnconditional(int32(word - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(done)
+	fc.landForwardJump(none)
+	fc.out.Ret()
+}
@@ -3,6 +3,8 @@ package main
 
 import (
 	"math"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
@@ -308,6 +310,91 @@ func TestFMAInMatch(t *testing.T) {
 	}
 }
 
+// Test the explicit *+ operator and fma() builtin
+func TestExplicitFMA(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected float64
+	}{
+		{"fused_add", `main = { a := 2.0
+b := 3.0
+println(a *+ b + 4.0) }`, 10.0},
+		{"fused_sub", `main = { a := 5.0
+b := 3.0
+println(a *+ b - 2.0) }`, 13.0},
+		{"addend_left", `main = { a := 2.0
+b := 3.0
+println(2.0 + a *+ b) }`, 8.0},
+		{"no_addend", `main = { a := 2.0
+println(a *+ 7.0) }`, 14.0},
+		{"builtin", `main = { x := 1.5
+println(fma(x, 4.0, 1.0)) }`, 7.0},
+		{"nested_calls", `sq = x -> x * x
+main = { println(fma(sq(2), sq(3), sq(1))) }`, 37.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := compileAndRunFloat(t, tt.code)
+			if math.Abs(result-tt.expected) > 0.0001 {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+// TestRiscvFMA tests that *+ and fma() compile to one FMADD.D or FMSUB.D on
+// RISC-V, also as a println argument
+func TestRiscvFMA(t *testing.T) {
+	const (
+		fmaddD = 0x43 // major opcodes, with fmt = D in bits 25-26
+		fmsubD = 0x47
+	)
+	tests := []struct {
+		name   string
+		code   string
+		opcode uint32
+	}{
+		{"fused_add", "a := 2\nb := 3\nc := 4\nx := a *+ b + c\n", fmaddD},
+		{"fused_sub", "a := 2\nb := 3\nc := 4\nx := a *+ b - c\n", fmsubD},
+		{"builtin", "a := 2\nb := 3\nc := 4\nx := fma(a, b, c)\n", fmaddD},
+		{"println", "a := 2\nb := 3\nprintln(a *+ b + 1)\n", fmaddD},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eb, _ := New("riscv64")
+			program := NewParser(tt.code).ParseProgram()
+			if err := NewRiscvCodeGen(eb).CompileProgram(program); err != nil {
+				t.Fatalf("Compilation failed: %v", err)
+			}
+			fused := 0
+			for _, word := range riscvWords(eb.text.Bytes()) {
+				if word&0x7f == fmaddD || word&0x7f == fmsubD {
+					if word&0x7f != tt.opcode || (word>>25)&3 != 1 {
+						t.Errorf("Unexpected fused instruction %08x", word)
+					}
+					fused++
+				}
+			}
+			if fused != 1 {
+				t.Errorf("Expected one fused multiply-add, got %d", fused)
+			}
+		})
+	}
+
+	// The optimizer folds x * 2 + 1 into an FMAExpr, which println must take
+	platform := Platform{Arch: ArchRiscv64, OS: OSLinux}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "fma.c67")
+	if err := os.WriteFile(src, []byte("x := 7\nprintln(x * 2 + 1)\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CompileC67WithOptions(src, filepath.Join(dir, "fma"), platform, 0, false); err != nil {
+		t.Errorf("Compilation of println(x * 2 + 1) failed: %v", err)
+	}
+}
+
 // Benchmark FMA vs separate operations
 func BenchmarkFMA(b *testing.B) {
 	code := `
@@ -0,0 +1,214 @@
+// Completion: 100% - gameloop() fixed-timestep loop
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// gameloop.go - Fixed-timestep game loop
+//
+//	steps = gameloop(update, render, fps)
+//
+// update(dt) advances the game by a fixed dt = 1/fps seconds and render(alpha)
+// draws it, where alpha in [0, 1) is how far the clock has moved towards
+// the next update, for interpolating between the last two states. Each frame
+// measures the real time since the previous frame on the monotonic clock,
+// adds it to an accumulator and calls update once per whole dt in it, then
+// calls render once and sleeps until the next update is due. Frames longer
+// than gameloopMaxFrame seconds count as that long, so a stall (a debugger,
+// a dragged window) does not make the game catch up with hundreds of
+// updates. The loop ends when update returns 0 and gives back the number
+// of updates that ran; an fps that is not positive gives error("arg").
+
+// gameloopMaxFrame is the longest frame time the accumulator takes in
+const gameloopMaxFrame = 0.25
+
+// compileGameloopCall compiles gameloop(update, render, fps)
+func (fc *C67Compiler) compileGameloopCall(call *CallExpr) {
+	if len(call.Args) != 3 {
+		compilerError("gameloop() requires exactly 3 arguments (update, render, fps)")
+	}
+	if fc.eb.target.OS() != OSLinux && fc.eb.target.OS() != OSDarwin {
+		compilerError("gameloop() is only supported on Linux and macOS")
+	}
+	for _, name := range []string{"clock_gettime", "nanosleep"} {
+		fc.trackFunctionCall(name)
+	}
+	fc.usesGameloop = true
+
+	fc.out.SubImmFromReg("rsp", 16)
+	fc.compileExpression(call.Args[0])
+	fc.out.MovXmmToMem("xmm0", "rsp", 0)
+	fc.compileExpression(call.Args[1])
+	fc.out.MovXmmToMem("xmm0", "rsp", 8)
+	fc.compileExpression(call.Args[2])
+	fc.out.MovMemToReg("rdi", "rsp", 0)
+	fc.out.MovMemToReg("rsi", "rsp", 8)
+	fc.out.CallSymbol("_c67_gameloop")
+	fc.out.AddImmToReg("rsp", 16)
+}
+
+// generateGameloopHelpers emits _c67_gameloop and the clock it reads
+func (fc *C67Compiler) generateGameloopHelpers() {
+	fc.generateGameloopNow()
+	fc.generateGameloop()
+}
+
+// gameloopLoadConst loads a float64 constant into an XMM register through rax
+func (fc *C67Compiler) gameloopLoadConst(xmm string, value float64) {
+	fc.out.MovImmToReg("rax", fmt.Sprintf("%d", math.Float64bits(value)))
+	fc.out.MovqRegToXmm(xmm, "rax")
+}
+
+// generateGameloopNow emits _c67_gameloop_now() -> xmm0, the monotonic
+// clock in seconds
+func (fc *C67Compiler) generateGameloopNow() {
+	fc.eb.MarkFunction("_c67_gameloop_now")
+
+	clockMonotonic := "1"
+	if fc.eb.target.OS() == OSDarwin {
+		clockMonotonic = "6"
+	}
+	fc.out.SubImmFromReg("rsp", 24)
+	fc.out.MovImmToReg("rdi", clockMonotonic)
+	fc.out.MovRegToReg("rsi", "rsp")
+	fc.trackFunctionCall("clock_gettime")
+	fc.eb.GenerateCallInstruction("clock_gettime")
+	fc.out.MovMemToReg("rax", "rsp", 8)
+	fc.out.Cvtsi2sd("xmm0", "rax")
+	fc.gameloopLoadConst("xmm1", 1e-9)
+	fc.out.MulsdXmm("xmm0", "xmm1")
+	fc.out.MovMemToReg("rax", "rsp", 0)
+	fc.out.Cvtsi2sd("xmm1", "rax")
+	fc.out.AddsdXmm("xmm0", "xmm1")
+	fc.out.AddImmToReg("rsp", 24)
+	fc.out.Ret()
+}
+
+// generateGameloop emits _c67_gameloop(rdi=update, rsi=render, xmm0=fps) -> xmm0
+func (fc *C67Compiler) generateGameloop() {
+	fc.eb.MarkFunction("_c67_gameloop")
+
+	// Lambdas may use any register, so the loop state lives in the frame
+	const (
+		update = -48
+		render = -56
+		dt     = -64
+		acc    = -72
+		prev   = -80
+		steps  = -88
+	)
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+	fc.out.PushReg("r15")
+	fc.out.SubImmFromReg("rsp", 72)
+	fc.out.AndRegWithImm("rsp", -16) // [rsp] = timespec for nanosleep
+
+	fc.out.MovRegToMem("rdi", "rbp", update)
+	fc.out.MovRegToMem("rsi", "rbp", render)
+	fc.out.XorpdXmm("xmm1", "xmm1")
+	fc.out.Ucomisd("xmm0", "xmm1")
+	badFps := fc.forwardJump(JumpBelowOrEqual) // also taken for NaN
+	fc.gameloopLoadConst("xmm1", 1)
+	fc.out.DivsdXmm("xmm1", "xmm0")
+	fc.out.MovXmmToMem("xmm1", "rbp", dt)
+	fc.out.XorRegWithReg("rax", "rax")
+	fc.out.MovRegToMem("rax", "rbp", acc)
+	fc.out.MovRegToMem("rax", "rbp", steps)
+	fc.out.CallSymbol("_c67_gameloop_now")
+	fc.out.MovXmmToMem("xmm0", "rbp", prev)
+
+	// Add the time since the last frame, at most gameloopMaxFrame
+	frame := fc.eb.text.Len()
+	fc.out.CallSymbol("_c67_gameloop_now")
+	fc.out.MovMemToXmm("xmm2", "rbp", prev)
+	fc.out.MovXmmToMem("xmm0", "rbp", prev)
+	fc.out.MovXmmToXmm("xmm1", "xmm0")
+	fc.out.SubsdXmm("xmm1", "xmm2")
+	fc.gameloopLoadConst("xmm2", gameloopMaxFrame)
+	fc.out.MinsdXmm("xmm1", "xmm2")
+	fc.out.MovMemToXmm("xmm2", "rbp", acc)
+	fc.out.AddsdXmm("xmm1", "xmm2")
+	fc.out.MovXmmToMem("xmm1", "rbp", acc)
+
+	// One update per whole dt in the accumulator
+	tick := fc.eb.text.Len()
+	fc.out.MovMemToXmm("xmm0", "rbp", acc)
+	fc.out.MovMemToXmm("xmm1", "rbp", dt)
+	fc.out.Ucomisd("xmm0", "xmm1")
+	toRender := fc.forwardJump(JumpBelow)
+	fc.out.SubsdXmm("xmm0", "xmm1")
+	fc.out.MovXmmToMem("xmm0", "rbp", acc)
+	fc.out.MovXmmToXmm("xmm0", "xmm1")
+	fc.gameloopCallLambda(update)
+	fc.out.MovMemToXmm("xmm1", "rbp", steps)
+	fc.gameloopLoadConst("xmm2", 1)
+	fc.out.AddsdXmm("xmm1", "xmm2")
+	fc.out.MovXmmToMem("xmm1", "rbp", steps)
+	fc.out.XorpdXmm("xmm1", "xmm1")
+	fc.out.Ucomisd("xmm0", "xmm1")
+	notZero := fc.forwardJump(JumpParity)
+	done := fc.forwardJump(JumpEqual)
+	fc.landForwardJump(notZero)
+	fc.out.JumpUnconditional(int32(tick - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	// Render with alpha = acc / dt
+	fc.landForwardJump(toRender)
+	fc.out.DivsdXmm("xmm0", "xmm1")
+	fc.gameloopCallLambda(render)
+
+	// Sleep for whatever is left of the current dt
+	fc.out.CallSymbol("_c67_gameloop_now")
+	fc.out.MovMemToXmm("xmm1", "rbp", prev)
+	fc.out.SubsdXmm("xmm0", "xmm1")
+	fc.out.MovMemToXmm("xmm1", "rbp", acc)
+	fc.out.AddsdXmm("xmm0", "xmm1")
+	fc.out.MovMemToXmm("xmm1", "rbp", dt)
+	fc.out.SubsdXmm("xmm1", "xmm0") // xmm1 = seconds until the next update
+	fc.out.XorpdXmm("xmm0", "xmm0")
+	fc.out.Ucomisd("xmm1", "xmm0")
+	noSleep := fc.forwardJump(JumpBelowOrEqual)
+	fc.out.Cvttsd2si("rax", "xmm1")
+	fc.out.MovRegToMem("rax", "rsp", 0)
+	fc.out.Cvtsi2sd("xmm0", "rax")
+	fc.out.SubsdXmm("xmm1", "xmm0")
+	fc.gameloopLoadConst("xmm0", 1e9)
+	fc.out.MulsdXmm("xmm1", "xmm0")
+	fc.out.Cvttsd2si("rax", "xmm1")
+	fc.out.MovRegToMem("rax", "rsp", 8)
+	fc.out.MovRegToReg("rdi", "rsp")
+	fc.out.XorRegWithReg("rsi", "rsi")
+	fc.trackFunctionCall("nanosleep")
+	fc.eb.GenerateCallInstruction("nanosleep")
+	fc.landForwardJump(noSleep)
+	fc.out.JumpUnconditional(int32(frame - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(badFps)
+	fc.createErrorResult("arg")
+	returnPos := fc.forwardJumpAlways()
+	fc.landForwardJump(done)
+	fc.out.MovMemToXmm("xmm0", "rbp", steps)
+	fc.landForwardJump(returnPos)
+	fc.out.LeaMemToReg("rsp", "rbp", -40)
+	fc.out.PopReg("r15")
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// gameloopCallLambda calls the closure stored at [rbp+slot] with xmm0 as
+// its argument, like a call through a lambda variable
+func (fc *C67Compiler) gameloopCallLambda(slot int) {
+	fc.out.MovMemToReg("rax", "rbp", slot)
+	fc.out.MovMemToReg("r11", "rax", 0)
+	fc.out.MovMemToReg("r15", "rax", 8)
+	fc.out.CallRegister("r11")
+}
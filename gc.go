@@ -0,0 +1,604 @@
+// Completion: 100% - Conservative mark-sweep heap for --gc
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// gc.go - A garbage-collected heap as an alternative to arena discipline
+//
+//	c67 --gc server.c67
+//
+// With --gc, what would be allocated from the global arena (lists, maps,
+// strings, alloc() outside arena blocks) comes from a heap that is collected
+// instead of growing until the program exits. arena { } blocks keep working
+// as before, and are a cheap way to drop short-lived data early.
+//
+// The collector is a conservative, non-moving mark-sweep collector. Every
+// object has an 8-byte header with its size and a mark bit, and a bitmap
+// with one bit per heap word records where objects start. Any aligned word
+// that points at the start of an object, or at most 16 bytes into it, keeps
+// the object alive. The roots are the stack of the main thread, the
+// registers, the .data section, the used part of every arena, and the arenas
+// that @@ worker threads keep. Marking uses an explicit stack, so deep
+// structures do not recurse. Sweeping coalesces neighbouring free objects
+// into a free list and gives a free tail back to the bump pointer. An
+// allocation takes the first of the first few free blocks that fits, or
+// else bumps the pointer.
+//
+// Collections only happen at safepoints: loop back-edges and the entry of
+// every function. After allocating as many bytes as survived the previous
+// collection (at least gcMinThreshold), the allocator sets a flag, and the
+// next safepoint collects. While @@ threads run, the main thread waits in the
+// barrier, and collections are postponed until the loop is done. Pointers
+// kept only in memory from C (malloc'd buffers, C globals) are not seen.
+//
+// Handles given to onfree() in the global arena are checked after marking:
+// the cleanup of a handle that no scanned word equals runs at the end of the
+// collection, with collections paused (see finalizer.go).
+
+const (
+	gcHeapSize     = 4 << 30 // reserved address space for objects
+	gcBitmapSize   = gcHeapSize / 64
+	gcMarkStack    = gcHeapSize / 2 // room for a pointer per smallest object
+	gcMinThreshold = 8 << 20        // bytes allocated before the first collection
+	gcFreeSearch   = 64             // free blocks looked at before bump allocating
+)
+
+// gcOutOfMemoryMsg is printed when the heap is used up
+const gcOutOfMemoryMsg = "Error: out of memory in the --gc heap\n"
+
+// gcEnabled reports whether the program uses the collected heap
+func (fc *C67Compiler) gcEnabled() bool {
+	return GCFlag && fc.eb.target.OS() == OSLinux && fc.eb.target.Arch() == ArchX86_64
+}
+
+// gcState are the writable words the collector keeps its state in
+var gcState = []string{
+	"_c67_gc_heap",      // start of the heap
+	"_c67_gc_top",       // bump pointer
+	"_c67_gc_end",       // end of the heap
+	"_c67_gc_bitmap",    // object start bits
+	"_c67_gc_markstack", // mark stack
+	"_c67_gc_free",      // first-fit free list
+	"_c67_gc_allocated", // bytes allocated since the last collection
+	"_c67_gc_threshold", // bytes to allocate before the next collection
+	"_c67_gc_pending",   // 1 when the next safepoint should collect
+	"_c67_gc_paused",    // > 0 while @@ threads run
+	"_c67_gc_lock",      // allocation spinlock
+	"_c67_gc_stack_top", // rsp at program entry
+	"_c67_gc_arenas",    // list of arenas kept by @@ threads
+}
+
+// emitGCInit reserves the heap at program entry, while rsp still points at
+// argc, and records the top of the stack
+func (fc *C67Compiler) emitGCInit() {
+	if GCFlag && !fc.gcEnabled() {
+		compilerError("--gc is only supported on Linux x86-64")
+	}
+	if !fc.gcEnabled() {
+		return
+	}
+	for _, name := range gcState {
+		fc.eb.DefineWritable(name, "\x00\x00\x00\x00\x00\x00\x00\x00")
+	}
+	fc.eb.Define("_c67_gc_oom_msg", gcOutOfMemoryMsg)
+
+	fc.out.LeaSymbolToReg("rax", "_c67_gc_stack_top")
+	fc.out.MovRegToMem("rsp", "rax", 0)
+
+	// mmap(NULL, size, PROT_READ|PROT_WRITE, MAP_PRIVATE|MAP_ANONYMOUS|MAP_NORESERVE, -1, 0)
+	fc.out.XorRegWithReg("rdi", "rdi")
+	fc.out.MovImmToReg("rsi", fmt.Sprint(gcHeapSize+gcBitmapSize+gcMarkStack))
+	fc.out.MovImmToReg("rdx", "3")
+	fc.out.MovImmToReg("r10", "16418")
+	fc.out.MovImmToReg("r8", "-1")
+	fc.out.XorRegWithReg("r9", "r9")
+	fc.out.MovImmToReg("rax", "9")
+	fc.out.Syscall()
+	fc.out.CmpRegToImm("rax", -4096)
+	mapped := fc.forwardJump(JumpBelow)
+	fc.emitGCOutOfMemory()
+	fc.landForwardJump(mapped)
+
+	fc.out.LeaSymbolToReg("rcx", "_c67_gc_heap")
+	fc.out.MovRegToMem("rax", "rcx", 0)
+	fc.out.LeaSymbolToReg("rcx", "_c67_gc_top")
+	fc.out.MovRegToMem("rax", "rcx", 0)
+	fc.out.MovImmToReg("rdx", fmt.Sprint(gcHeapSize))
+	fc.out.AddRegToReg("rax", "rdx")
+	fc.out.LeaSymbolToReg("rcx", "_c67_gc_end")
+	fc.out.MovRegToMem("rax", "rcx", 0)
+	fc.out.LeaSymbolToReg("rcx", "_c67_gc_bitmap")
+	fc.out.MovRegToMem("rax", "rcx", 0)
+	fc.out.AddImmToReg("rax", gcBitmapSize)
+	fc.out.LeaSymbolToReg("rcx", "_c67_gc_markstack")
+	fc.out.MovRegToMem("rax", "rcx", 0)
+	fc.out.LeaSymbolToReg("rcx", "_c67_gc_threshold")
+	fc.out.MovImmToMem(gcMinThreshold, "rcx", 0)
+}
+
+// emitGCOutOfMemory prints an error to stderr and exits with status 1
+func (fc *C67Compiler) emitGCOutOfMemory() {
+	fc.out.MovImmToReg("rdi", "2")
+	fc.out.LeaSymbolToReg("rsi", "_c67_gc_oom_msg")
+	fc.out.MovImmToReg("rdx", fmt.Sprint(len(gcOutOfMemoryMsg)))
+	fc.out.MovImmToReg("rax", "1") // write
+	fc.out.Syscall()
+	fc.out.MovImmToReg("rdi", "1")
+	fc.out.MovImmToReg("rax", "231") // exit_group
+	fc.out.Syscall()
+}
+
+// emitSafepoint collects garbage here if an allocation asked for it.
+// Nothing is emitted without --gc or in the body of a @@ loop, and no
+// register or flag the surrounding code relies on is changed.
+func (fc *C67Compiler) emitSafepoint() {
+	if !fc.gcEnabled() || fc.threadArenaOffset != 0 {
+		return
+	}
+	fc.out.PushReg("rax")
+	fc.out.LeaSymbolToReg("rax", "_c67_gc_pending")
+	fc.out.Emit([]byte{0x48, 0x83, 0x38, 0x00}) // cmp qword [rax], 0
+	fc.out.PopReg("rax")
+	idle := fc.forwardJump(JumpEqual)
+	fc.out.CallSymbol("_c67_gc_collect")
+	fc.landForwardJump(idle)
+}
+
+// emitGCPause and emitGCResume bracket a @@ loop in the main thread
+func (fc *C67Compiler) emitGCPause() {
+	if fc.gcEnabled() {
+		fc.out.LeaSymbolToReg("rax", "_c67_gc_paused")
+		fc.out.Emit([]byte{0x48, 0xff, 0x00}) // inc qword [rax]
+	}
+}
+
+func (fc *C67Compiler) emitGCResume() {
+	if fc.gcEnabled() {
+		fc.out.LeaSymbolToReg("rax", "_c67_gc_paused")
+		fc.out.Emit([]byte{0x48, 0xff, 0x08}) // dec qword [rax]
+	}
+}
+
+// emitGCAllocDispatch sends c67_arena_alloc calls for the global arena to
+// _c67_gc_alloc. It is emitted at the entry of c67_arena_alloc.
+func (fc *C67Compiler) emitGCAllocDispatch() {
+	fc.out.LeaSymbolToReg("rax", "_c67_arena_meta")
+	fc.out.MovMemToReg("rax", "rax", 0)
+	fc.out.MovMemToReg("rax", "rax", 0) // rax = global arena
+	fc.out.CmpRegToReg("rdi", "rax")
+	other := fc.forwardJump(JumpNotEqual)
+	fc.out.CallSymbol("_c67_gc_alloc")
+	fc.out.Ret()
+	fc.landForwardJump(other)
+}
+
+// generateGCHelpers emits the allocator and the collector
+func (fc *C67Compiler) generateGCHelpers() {
+	fc.generateGCAlloc()
+	fc.generateGCKeepArena()
+	fc.generateGCScanRange()
+	fc.generateGCScanArena()
+	fc.generateGCCollect()
+	if fc.usesFinalizers {
+		fc.generateGCScanHandles()
+	}
+}
+
+// generateGCAlloc emits _c67_gc_alloc(rsi=size) -> rax. It may be called by
+// several threads at once. Clobbers rcx, rdx, rsi, rdi and r8-r10, like
+// c67_arena_alloc.
+func (fc *C67Compiler) generateGCAlloc() {
+	fc.eb.MarkFunction("_c67_gc_alloc")
+
+	// size = max(8, size rounded up to 8)
+	fc.out.AddImmToReg("rsi", 7)
+	fc.out.AndRegWithImm("rsi", -8)
+	nonEmpty := fc.forwardJump(JumpNotEqual)
+	fc.out.MovImmToReg("rsi", "8")
+	fc.landForwardJump(nonEmpty)
+
+	fc.out.LeaSymbolToReg("r8", "_c67_gc_lock")
+	spin := fc.eb.text.Len()
+	fc.out.XorRegWithReg("rax", "rax")
+	fc.out.MovImmToReg("rcx", "1")
+	fc.out.Emit([]byte{0xf0, 0x49, 0x0f, 0xb1, 0x08}) // lock cmpxchg [r8], rcx
+	locked := fc.forwardJump(JumpEqual)
+	fc.out.Emit([]byte{0xf3, 0x90}) // pause
+	fc.out.JumpUnconditional(int32(spin - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(locked)
+
+	// Ask for a collection once enough has been allocated
+	fc.out.LeaSymbolToReg("r9", "_c67_gc_allocated")
+	fc.out.MovMemToReg("rax", "r9", 0)
+	fc.out.AddRegToReg("rax", "rsi")
+	fc.out.AddImmToReg("rax", 8)
+	fc.out.MovRegToMem("rax", "r9", 0)
+	fc.out.LeaSymbolToReg("r9", "_c67_gc_threshold")
+	fc.out.MovMemToReg("r9", "r9", 0)
+	fc.out.CmpRegToReg("rax", "r9")
+	belowThreshold := fc.forwardJump(JumpBelow)
+	fc.out.LeaSymbolToReg("r9", "_c67_gc_pending")
+	fc.out.MovImmToMem(1, "r9", 0)
+	fc.landForwardJump(belowThreshold)
+
+	// First fit among the first gcFreeSearch blocks, so a list of small
+	// holes does not make every allocation walk all of it: rdi is the
+	// address of the link to the block in rax
+	fc.out.LeaSymbolToReg("rdi", "_c67_gc_free")
+	fc.out.MovImmToReg("r10", fmt.Sprint(gcFreeSearch))
+	search := fc.eb.text.Len()
+	fc.out.MovMemToReg("rax", "rdi", 0)
+	fc.out.TestRegReg("rax", "rax")
+	exhausted := fc.forwardJump(JumpEqual)
+	fc.out.DecReg("r10")
+	tooFar := fc.forwardJump(JumpEqual)
+	fc.out.MovMemToReg("rcx", "rax", 0) // rcx = size of the free block
+	fc.out.CmpRegToReg("rcx", "rsi")
+	fits := fc.forwardJump(JumpAboveOrEqual)
+	fc.out.LeaMemToReg("rdi", "rax", 8)
+	fc.out.JumpUnconditional(int32(search - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(fits)
+	fc.out.MovMemToReg("rdx", "rax", 8)
+	fc.out.MovRegToMem("rdx", "rdi", 0) // unlink
+	// Split off the rest when it holds a header and at least 24 bytes
+	fc.out.MovRegToReg("rdx", "rcx")
+	fc.out.SubRegFromReg("rdx", "rsi")
+	fc.out.CmpRegToImm("rdx", 32)
+	whole := fc.forwardJump(JumpBelow)
+	fc.out.MovRegToMem("rsi", "rax", 0)
+	fc.out.LeaMemToReg("r9", "rax", 8)
+	fc.out.AddRegToReg("r9", "rsi") // r9 = header of the rest
+	fc.out.SubImmFromReg("rdx", 8)
+	fc.out.MovRegToMem("rdx", "r9", 0)
+	fc.out.LeaSymbolToReg("r10", "_c67_gc_free")
+	fc.out.MovMemToReg("rcx", "r10", 0)
+	fc.out.MovRegToMem("rcx", "r9", 8)
+	fc.out.MovRegToMem("r9", "r10", 0)
+	fc.landForwardJump(whole)
+	// Reused memory is cleared, as fresh memory from the kernel is
+	fc.out.MovRegToReg("r9", "rax")
+	fc.out.LeaMemToReg("rdi", "rax", 8)
+	fc.out.MovMemToReg("rcx", "rax", 0)
+	fc.out.ShrRegByImm("rcx", 3)
+	fc.out.XorRegWithReg("rax", "rax")
+	fc.out.Emit([]byte{0xf3, 0x48, 0xab}) // rep stosq
+	fc.out.MovRegToReg("rax", "r9")
+	reused := fc.forwardJumpAlways()
+
+	// Bump allocation
+	fc.landForwardJump(exhausted)
+	fc.landForwardJump(tooFar)
+	fc.out.LeaSymbolToReg("r9", "_c67_gc_top")
+	fc.out.MovMemToReg("rax", "r9", 0)
+	fc.out.LeaMemToReg("rdx", "rax", 8)
+	fc.out.AddRegToReg("rdx", "rsi")
+	fc.out.LeaSymbolToReg("r10", "_c67_gc_end")
+	fc.out.MovMemToReg("r10", "r10", 0)
+	fc.out.CmpRegToReg("rdx", "r10")
+	room := fc.forwardJump(JumpBelowOrEqual)
+	fc.emitGCOutOfMemory()
+	fc.landForwardJump(room)
+	fc.out.MovRegToMem("rdx", "r9", 0)
+	fc.out.MovRegToMem("rsi", "rax", 0) // header = size
+
+	// Mark the start of the object in the bitmap
+	fc.landForwardJump(reused)
+	fc.out.LeaMemToReg("rdx", "rax", 8)
+	fc.out.LeaSymbolToReg("r9", "_c67_gc_heap")
+	fc.out.MovMemToReg("r9", "r9", 0)
+	fc.out.SubRegFromReg("rdx", "r9")
+	fc.out.ShrRegByImm("rdx", 3)
+	fc.out.LeaSymbolToReg("r10", "_c67_gc_bitmap")
+	fc.out.MovMemToReg("r10", "r10", 0)
+	fc.out.Emit([]byte{0x49, 0x0f, 0xab, 0x12}) // bts [r10], rdx
+
+	fc.out.LeaSymbolToReg("r8", "_c67_gc_lock")
+	fc.out.MovImmToMem(0, "r8", 0)
+	fc.out.AddImmToReg("rax", 8)
+	fc.out.Ret()
+}
+
+// generateGCKeepArena emits _c67_gc_keep_arena(rdi=arena), which a @@
+// worker thread calls for an arena that outlives it, so the collector
+// scans it. Preserves r11.
+func (fc *C67Compiler) generateGCKeepArena() {
+	fc.eb.MarkFunction("_c67_gc_keep_arena")
+	fc.out.PushReg("rdi")
+	fc.out.MovImmToReg("rsi", "16")
+	fc.out.CallSymbol("_c67_gc_alloc")
+	fc.out.PopReg("rdi")
+	fc.out.MovRegToMem("rdi", "rax", 0) // node = [arena][next]
+	fc.out.LeaSymbolToReg("rdx", "_c67_gc_arenas")
+	fc.out.MovRegToReg("rcx", "rax")
+	fc.out.MovMemToReg("rax", "rdx", 0)
+	retry := fc.eb.text.Len()
+	fc.out.MovRegToMem("rax", "rcx", 8)
+	fc.out.Emit([]byte{0xf0, 0x48, 0x0f, 0xb1, 0x0a}) // lock cmpxchg [rdx], rcx
+	fc.out.JumpConditional(JumpNotEqual, int32(retry-(fc.eb.text.Len()+ConditionalJumpSize)))
+	fc.out.Ret()
+}
+
+// generateGCScanRange emits _c67_gc_scan_range(rdi=start, rsi=end), which
+// marks the objects that the words in [start, end) point at and pushes them
+// on the mark stack in r12. Clobbers rax, rcx, rdx, rdi and r8-r11.
+func (fc *C67Compiler) generateGCScanRange() {
+	fc.eb.MarkFunction("_c67_gc_scan_range")
+	if fc.usesFinalizers {
+		fc.out.CallSymbol("_c67_gc_scan_handles")
+	}
+	fc.out.LeaSymbolToReg("r8", "_c67_gc_heap")
+	fc.out.MovMemToReg("r8", "r8", 0)
+	fc.out.LeaSymbolToReg("r9", "_c67_gc_top")
+	fc.out.MovMemToReg("r9", "r9", 0)
+	fc.out.LeaSymbolToReg("r10", "_c67_gc_bitmap")
+	fc.out.MovMemToReg("r10", "r10", 0)
+	fc.out.AddImmToReg("rdi", 7)
+	fc.out.AndRegWithImm("rdi", -8)
+
+	next := fc.eb.text.Len()
+	fc.out.LeaMemToReg("rax", "rdi", 8)
+	fc.out.CmpRegToReg("rax", "rsi")
+	done := fc.forwardJump(JumpAbove)
+	fc.out.MovMemToReg("rax", "rdi", 0)
+	fc.out.AddImmToReg("rdi", 8)
+	skip := func(cond JumpCondition) {
+		fc.out.JumpConditional(cond, int32(next-(fc.eb.text.Len()+ConditionalJumpSize)))
+	}
+
+	// A candidate is an aligned word inside the heap
+	fc.out.Emit([]byte{0xa8, 0x07}) // test al, 7
+	skip(JumpNotEqual)
+	fc.out.CmpRegToReg("rax", "r9")
+	skip(JumpAboveOrEqual)
+	fc.out.MovRegToReg("rdx", "rax")
+	fc.out.SubRegFromReg("rdx", "r8")
+	skip(JumpBelow)
+	fc.out.ShrRegByImm("rdx", 3)
+	skip(JumpEqual)
+
+	// Find the object that starts at most two words before it
+	fc.out.MovImmToReg("rcx", "3")
+	find := fc.eb.text.Len()
+	fc.out.Emit([]byte{0x49, 0x0f, 0xa3, 0x12}) // bt [r10], rdx
+	found := fc.forwardJump(JumpBelow)          // jc
+	fc.out.DecReg("rdx")
+	skip(JumpEqual)
+	fc.out.DecReg("rcx")
+	fc.out.JumpConditional(JumpNotEqual, int32(find-(fc.eb.text.Len()+ConditionalJumpSize)))
+	fc.out.JumpUnconditional(int32(next - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(found)
+	fc.out.ShlImmReg("rdx", 3)
+	fc.out.AddRegToReg("rdx", "r8")      // rdx = object
+	fc.out.MovMemToReg("r11", "rdx", -8) // r11 = header
+	fc.out.MovRegToReg("rcx", "r11")
+	fc.out.AndRegWithImm("rcx", -8)
+	fc.out.AddRegToReg("rcx", "rdx")
+	fc.out.CmpRegToReg("rax", "rcx")
+	skip(JumpAboveOrEqual)                      // past the end of the object
+	fc.out.Emit([]byte{0x41, 0xf6, 0xc3, 0x01}) // test r11b, 1
+	skip(JumpNotEqual)                          // already marked
+	fc.out.OrRegWithImm("r11", 1)
+	fc.out.MovRegToMem("r11", "rdx", -8)
+	fc.out.MovRegToMem("rdx", "r12", 0)
+	fc.out.AddImmToReg("r12", 8)
+	fc.out.JumpUnconditional(int32(next - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(done)
+	fc.out.Ret()
+}
+
+// generateGCScanArena emits _c67_gc_scan_arena(rdi=arena), which scans the
+// used part of an arena
+func (fc *C67Compiler) generateGCScanArena() {
+	fc.eb.MarkFunction("_c67_gc_scan_arena")
+	fc.out.TestRegReg("rdi", "rdi")
+	none := fc.forwardJump(JumpEqual)
+	fc.out.MovMemToReg("rsi", "rdi", 16)
+	fc.out.MovMemToReg("rdi", "rdi", 0)
+	fc.out.AddRegToReg("rsi", "rdi")
+	fc.out.CallSymbol("_c67_gc_scan_range")
+	fc.landForwardJump(none)
+	fc.out.Ret()
+}
+
+// generateGCCollect emits _c67_gc_collect(), called from safepoints. It
+// preserves every register.
+func (fc *C67Compiler) generateGCCollect() {
+	fc.eb.MarkFunction("_c67_gc_collect")
+	fc.out.PushReg("rax")
+	fc.out.LeaSymbolToReg("rax", "_c67_gc_paused")
+	fc.out.Emit([]byte{0x48, 0x83, 0x38, 0x00}) // cmp qword [rax], 0
+	fc.out.PopReg("rax")
+	run := fc.forwardJump(JumpEqual)
+	fc.out.Ret()
+	fc.landForwardJump(run)
+
+	saved := []string{"rax", "rbx", "rcx", "rdx", "rsi", "rdi", "r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15"}
+	for _, reg := range saved {
+		fc.out.PushReg(reg)
+	}
+	// xmm registers may hold the only copy of a value
+	fc.out.SubImmFromReg("rsp", 256)
+	for i := 0; i < 16; i++ {
+		fc.out.MovupdXmmToMem(fmt.Sprintf("xmm%d", i), "rsp", i*16)
+	}
+
+	fc.out.LeaSymbolToReg("rax", "_c67_gc_pending")
+	fc.out.MovImmToMem(0, "rax", 0)
+	fc.out.LeaSymbolToReg("rax", "_c67_gc_allocated")
+	fc.out.MovImmToMem(0, "rax", 0)
+	fc.out.LeaSymbolToReg("r12", "_c67_gc_markstack")
+	fc.out.MovMemToReg("r12", "r12", 0)
+
+	// Roots: the stack, with the registers saved above
+	fc.out.MovRegToReg("rdi", "rsp")
+	fc.out.LeaSymbolToReg("rsi", "_c67_gc_stack_top")
+	fc.out.MovMemToReg("rsi", "rsi", 0)
+	fc.out.CallSymbol("_c67_gc_scan_range")
+
+	// Roots: the .data section
+	dataSymbols := fc.eb.DataSection()
+	names := make([]string, 0, len(dataSymbols))
+	for name, value := range dataSymbols {
+		// The onfree() records are not roots, or their handles would
+		// always look reachable
+		if len(value) >= 8 && !strings.HasPrefix(name, "_c67_gc_") && name != "_c67_finalizers" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fc.out.LeaSymbolToReg("rdi", name)
+		fc.out.LeaMemToReg("rsi", "rdi", len(dataSymbols[name]))
+		fc.out.CallSymbol("_c67_gc_scan_range")
+	}
+
+	// Roots: every arena, and the arenas kept by @@ threads
+	fc.out.LeaSymbolToReg("rbx", "_c67_arena_meta")
+	fc.out.MovMemToReg("rbx", "rbx", 0)
+	fc.out.LeaSymbolToReg("r13", "_c67_arena_meta_len")
+	fc.out.MovMemToReg("r13", "r13", 0)
+	arenas := fc.eb.text.Len()
+	fc.out.TestRegReg("r13", "r13")
+	arenasDone := fc.forwardJump(JumpEqual)
+	fc.out.MovMemToReg("rdi", "rbx", 0)
+	fc.out.CallSymbol("_c67_gc_scan_arena")
+	fc.out.AddImmToReg("rbx", 8)
+	fc.out.DecReg("r13")
+	fc.out.JumpUnconditional(int32(arenas - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(arenasDone)
+
+	// The nodes of the list are heap objects too, reachable only from here
+	fc.out.LeaSymbolToReg("rdi", "_c67_gc_arenas")
+	fc.out.LeaMemToReg("rsi", "rdi", 8)
+	fc.out.CallSymbol("_c67_gc_scan_range")
+	fc.out.LeaSymbolToReg("rbx", "_c67_gc_arenas")
+	fc.out.MovMemToReg("rbx", "rbx", 0)
+	kept := fc.eb.text.Len()
+	fc.out.TestRegReg("rbx", "rbx")
+	keptDone := fc.forwardJump(JumpEqual)
+	fc.out.LeaMemToReg("rdi", "rbx", 8)
+	fc.out.LeaMemToReg("rsi", "rbx", 16)
+	fc.out.CallSymbol("_c67_gc_scan_range")
+	fc.out.MovMemToReg("rdi", "rbx", 0)
+	fc.out.CallSymbol("_c67_gc_scan_arena")
+	fc.out.MovMemToReg("rbx", "rbx", 8)
+	fc.out.JumpUnconditional(int32(kept - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(keptDone)
+
+	// The onfree() records of the global arena stay alive, but are not
+	// scanned
+	if fc.usesFinalizers {
+		fc.emitGCMarkFinalizers()
+	}
+
+	// Mark: scan the objects on the mark stack until it is empty
+	fc.out.LeaSymbolToReg("r13", "_c67_gc_markstack")
+	fc.out.MovMemToReg("r13", "r13", 0)
+	mark := fc.eb.text.Len()
+	fc.out.CmpRegToReg("r12", "r13")
+	marked := fc.forwardJump(JumpEqual)
+	fc.out.SubImmFromReg("r12", 8)
+	fc.out.MovMemToReg("rdi", "r12", 0)
+	fc.out.MovMemToReg("rsi", "rdi", -8)
+	fc.out.AndRegWithImm("rsi", -8)
+	fc.out.AddRegToReg("rsi", "rdi")
+	fc.out.CallSymbol("_c67_gc_scan_range")
+	fc.out.JumpUnconditional(int32(mark - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(marked)
+
+	// Sweep: walk the heap, unmark the live objects, free the others and
+	// merge neighbouring free blocks. r14 is the free block being grown and
+	// r15 counts the live bytes.
+	fc.out.LeaSymbolToReg("r8", "_c67_gc_heap")
+	fc.out.MovMemToReg("r8", "r8", 0)
+	fc.out.LeaSymbolToReg("r10", "_c67_gc_bitmap")
+	fc.out.MovMemToReg("r10", "r10", 0)
+	fc.out.LeaSymbolToReg("r9", "_c67_gc_free")
+	fc.out.MovImmToMem(0, "r9", 0)
+	fc.out.LeaSymbolToReg("r13", "_c67_gc_top")
+	fc.out.MovMemToReg("r13", "r13", 0)
+	fc.out.MovRegToReg("rbx", "r8")
+	fc.out.XorRegWithReg("r14", "r14")
+	fc.out.XorRegWithReg("r15", "r15")
+
+	walk := fc.eb.text.Len()
+	fc.out.CmpRegToReg("rbx", "r13")
+	walked := fc.forwardJump(JumpAboveOrEqual)
+	fc.out.MovMemToReg("rcx", "rbx", 0) // rcx = header
+	fc.out.MovRegToReg("rdx", "rcx")
+	fc.out.AndRegWithImm("rdx", -8) // rdx = size
+	fc.out.LeaMemToReg("rax", "rbx", 8)
+	fc.out.SubRegFromReg("rax", "r8")
+	fc.out.ShrRegByImm("rax", 3)                  // rax = start bit
+	fc.out.Emit([]byte{0x49, 0x0f, 0xa3, 0x02})   // bt [r10], rax
+	freeBlock := fc.forwardJump(JumpAboveOrEqual) // jnc
+	fc.out.Emit([]byte{0xf6, 0xc1, 0x01})         // test cl, 1
+	dead := fc.forwardJump(JumpEqual)
+	fc.out.MovRegToMem("rdx", "rbx", 0) // live: clear the mark
+	fc.out.AddRegToReg("r15", "rdx")
+	fc.out.XorRegWithReg("r14", "r14")
+	live := fc.forwardJumpAlways()
+
+	fc.landForwardJump(dead)
+	fc.out.Emit([]byte{0x49, 0x0f, 0xb3, 0x02}) // btr [r10], rax
+	fc.landForwardJump(freeBlock)
+	fc.out.TestRegReg("r14", "r14")
+	newRun := fc.forwardJump(JumpEqual)
+	fc.out.MovMemToReg("rax", "r14", 0)
+	fc.out.AddRegToReg("rax", "rdx")
+	fc.out.AddImmToReg("rax", 8)
+	fc.out.MovRegToMem("rax", "r14", 0)
+	merged := fc.forwardJumpAlways()
+	fc.landForwardJump(newRun)
+	fc.out.MovRegToReg("r14", "rbx")
+	fc.out.MovRegToMem("rdx", "rbx", 0)
+	fc.out.MovMemToReg("rax", "r9", 0)
+	fc.out.MovRegToMem("rax", "rbx", 8)
+	fc.out.MovRegToMem("rbx", "r9", 0)
+
+	fc.landForwardJump(live)
+	fc.landForwardJump(merged)
+	fc.out.AddRegToReg("rbx", "rdx")
+	fc.out.AddImmToReg("rbx", 8)
+	fc.out.JumpUnconditional(int32(walk - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(walked)
+
+	// A free block at the end goes back to the bump pointer; it is the
+	// last one pushed, so it heads the free list
+	fc.out.TestRegReg("r14", "r14")
+	noTail := fc.forwardJump(JumpEqual)
+	fc.out.MovMemToReg("rax", "r14", 8)
+	fc.out.MovRegToMem("rax", "r9", 0)
+	fc.out.LeaSymbolToReg("rax", "_c67_gc_top")
+	fc.out.MovRegToMem("r14", "rax", 0)
+	fc.landForwardJump(noTail)
+
+	// Next collection after allocating max(live, gcMinThreshold) bytes
+	fc.out.MovImmToReg("rax", fmt.Sprint(gcMinThreshold))
+	fc.out.CmpRegToReg("r15", "rax")
+	small := fc.forwardJump(JumpBelow)
+	fc.out.MovRegToReg("rax", "r15")
+	fc.landForwardJump(small)
+	fc.out.LeaSymbolToReg("rcx", "_c67_gc_threshold")
+	fc.out.MovRegToMem("rax", "rcx", 0)
+
+	if fc.usesFinalizers {
+		fc.emitGCRunFinalizers()
+		for i := 0; i < 16; i++ {
+			fc.out.MovupdMemToXmm(fmt.Sprintf("xmm%d", i), "rsp", i*16)
+		}
+	}
+
+	fc.out.AddImmToReg("rsp", 256)
+	for i := len(saved) - 1; i >= 0; i-- {
+		fc.out.PopReg(saved[i])
+	}
+	fc.out.Ret()
+}
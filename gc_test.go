@@ -0,0 +1,64 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+// TestGCCollectsGarbage tests --gc, which collects the lists, maps and strings
+// that are no longer reachable
+func TestGCCollectsGarbage(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("--gc is only supported on Linux x86-64")
+	}
+	defer func() { GCFlag = false }()
+	GCFlag = true
+
+	code := `words := [f"{i}" : @@ i in 0..<8]
+kept := [[0, 0]]
+total := 0
+@ k in 0..<500000 {
+    xs := [k, k + 1, k + 2]
+    s := f"n{k}"
+    total <- total + xs[2] - xs[0] + #s
+    k == 250000 {
+        kept <- [[k], xs]
+    }
+}
+row: list = kept[1]
+println(total)
+println(row[2])
+w: str = words[5]
+println(w)
+`
+	output := compileAndRun(t, code)
+	if output != "4388890\n250002\n5\n" {
+		t.Errorf("Expected '4388890\\n250002\\n5\\n', got %q", output)
+	}
+}
+
+// TestGCRunsFinalizers tests that --gc runs the onfree() cleanups of
+// handles that are no longer held
+func TestGCRunsFinalizers(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("--gc is only supported on Linux x86-64")
+	}
+	defer func() { GCFlag = false }()
+	GCFlag = true
+
+	code := `kept := onfree(0.25, h -> println("kept was freed"))
+@ k in 0..<400000 {
+    h := onfree(k + 0.5, h -> {
+        h == 1000.5 {
+            println("freed")
+        }
+    })
+    xs := [k]
+}
+println(kept)
+`
+	output := compileAndRun(t, code)
+	if output != "freed\n0.25\n" {
+		t.Errorf("Expected 'freed\\n0.25\\n', got %q", output)
+	}
+}
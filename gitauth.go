@@ -0,0 +1,184 @@
+// Completion: 100% - Private Git imports over SSH and with ~/.netrc
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// gitauth.go - Credentials for Git imports of private repositories
+//
+//	import "git@git.example.com:team/pkg.git" as pkg      # SSH, with ssh-agent
+//	import "ssh://git@git.example.com/team/pkg" as pkg    # the same
+//	import "git.example.com/team/pkg" as pkg              # HTTPS, with ~/.netrc
+//
+// SSH imports are cloned over SSH, so the keys of the running ssh-agent
+// (SSH_AUTH_SOCK) or of ~/.ssh are used, and are cached where the HTTPS
+// import of the same repository would be. HTTPS imports use the login and
+// password of the host in $NETRC or ~/.netrc (_netrc on Windows):
+//
+//	machine git.example.com login ci password ghp_xxxxxxxx
+//
+// which are handed to git by a credential helper, through the environment
+// of the git command, so they are not on its command line. The helper only
+// answers when git asks for https and the host of the entry, so they are
+// neither sent in the clear nor to a host that git is redirected to, and
+// http:// imports never get them. The default entry of the file is only
+// used with C67_NETRC_DEFAULT=1, since it would hand its password to any
+// host. Without a matching entry, git asks its own credential helpers, or
+// GIT_ASKPASS, as it does for any clone.
+
+// privateImportHint is added to errors from fetching a Git import
+const privateImportHint = "if the repository is private, import it by its SSH URL (git@host:user/repo) with ssh-agent running, or add the host to ~/.netrc"
+
+// withPrivateImportHint adds privateImportHint to an error from fetching a
+// Git import, unless --offline kept it from being fetched
+func withPrivateImportHint(err error) error {
+	if errors.Is(err, errOffline) {
+		return err
+	}
+	return fmt.Errorf("%w (%s)", err, privateImportHint)
+}
+
+// gitCredentialHelper answers git's requests for credentials for https and
+// the host C67_GIT_HOST with the login and password from the environment
+const gitCredentialHelper = `!f() { test "$1" = get || return 0; ` +
+	`while IFS= read -r line && test -n "$line"; do case "$line" in protocol=*) protocol=${line#protocol=};; host=*) host=${line#host=};; esac; done; ` +
+	`test "$protocol" = https && test "$host" = "$C67_GIT_HOST" && echo "username=$C67_GIT_USERNAME" && echo "password=$C67_GIT_PASSWORD"; }; f`
+
+// isSSHGitURL reports whether repoURL is cloned over SSH
+func isSSHGitURL(repoURL string) bool {
+	return strings.HasPrefix(repoURL, "ssh://") || strings.HasPrefix(repoURL, "git@")
+}
+
+// gitRepoPath returns host/path of a repository URL in any of the forms
+// git accepts: https://host/path, ssh://user@host:port/path, user@host:path
+// or host/path, without a .git suffix
+func gitRepoPath(repoURL string) string {
+	if u, err := url.Parse(repoURL); err == nil && u.Scheme != "" && u.Host != "" {
+		repoURL = u.Hostname() + u.Path
+	} else if at := strings.Index(repoURL, "@"); at >= 0 && strings.Contains(repoURL[at:], ":") {
+		repoURL = strings.Replace(repoURL[at+1:], ":", "/", 1)
+	}
+	return strings.TrimSuffix(repoURL, ".git")
+}
+
+// gitCloneURL returns the URL to clone repoURL from: SSH and other URLs
+// with a scheme as they are, and https:// for host/path
+func gitCloneURL(repoURL string) string {
+	if isSSHGitURL(repoURL) || strings.Contains(repoURL, "://") {
+		return repoURL
+	}
+	return "https://" + repoURL
+}
+
+// gitCommand returns a git command that talks to the remote repoURL, with
+// the credentials of its host from ~/.netrc if it is fetched over HTTPS
+func gitCommand(repoURL string, args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	u, err := url.Parse(gitCloneURL(repoURL))
+	if err != nil || u.Scheme != "https" {
+		return cmd
+	}
+	login, password, ok := netrcCredentials(u.Hostname())
+	if !ok {
+		return cmd
+	}
+	// An empty helper first, so that only this one answers
+	cmd.Args = append([]string{"git", "-c", "credential.helper=", "-c", "credential.helper=" + gitCredentialHelper}, args...)
+	cmd.Env = append(os.Environ(), "C67_GIT_HOST="+u.Host, "C67_GIT_USERNAME="+login, "C67_GIT_PASSWORD="+password)
+	return cmd
+}
+
+// gitRemoteURL returns the URL that the checkout at repoPath fetches from
+func gitRemoteURL(repoPath string) string {
+	output, err := exec.Command("git", "-C", repoPath, "remote", "get-url", "origin").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// netrcPath returns $NETRC, or the .netrc file in the home directory
+func netrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// netrcCredentials returns the login and password for host from the netrc
+// file, or those of its default entry if C67_NETRC_DEFAULT is 1
+func netrcCredentials(host string) (login, password string, ok bool) {
+	path := netrcPath()
+	if path == "" {
+		return "", "", false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", false
+	}
+	return parseNetrc(string(data), host, os.Getenv("C67_NETRC_DEFAULT") == "1")
+}
+
+// parseNetrc returns the login and password of the machine host in the
+// netrc file data, or, with useDefault, those of its default entry if it
+// has no such machine
+func parseNetrc(data, host string, useDefault bool) (login, password string, ok bool) {
+	var defaultLogin, defaultPassword string
+	hasDefault := false
+	fields := strings.Fields(data)
+	for i := 0; i < len(fields); i++ {
+		var matches, isDefault bool
+		switch fields[i] {
+		case "machine":
+			if i+1 >= len(fields) {
+				return defaultLogin, defaultPassword, hasDefault
+			}
+			i++
+			matches = fields[i] == host
+		case "default":
+			isDefault = true
+		case "macdef":
+			// A macro runs to the next empty line, which Fields can not see,
+			// so nothing after it is read
+			return defaultLogin, defaultPassword, hasDefault
+		default:
+			continue
+		}
+		var entryLogin, entryPassword string
+		for i+2 < len(fields) {
+			key := fields[i+1]
+			if key == "machine" || key == "default" || key == "macdef" {
+				break
+			}
+			switch key {
+			case "login":
+				entryLogin = fields[i+2]
+			case "password":
+				entryPassword = fields[i+2]
+			}
+			i += 2
+		}
+		if matches {
+			return entryLogin, entryPassword, true
+		}
+		if isDefault && useDefault && !hasDefault {
+			defaultLogin, defaultPassword, hasDefault = entryLogin, entryPassword, true
+		}
+	}
+	return defaultLogin, defaultPassword, hasDefault
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseNetrc(t *testing.T) {
+	netrc := `machine git.example.com login ci password secret
+default login anonymous password guest
+machine other.example.com
+  login me
+  password hunter2
+`
+	tests := []struct {
+		host, login, password string
+		ok                    bool
+	}{
+		{"git.example.com", "ci", "secret", true},
+		{"other.example.com", "me", "hunter2", true},
+		{"unknown.example.com", "anonymous", "guest", true},
+	}
+	for _, tt := range tests {
+		login, password, ok := parseNetrc(netrc, tt.host, true)
+		if login != tt.login || password != tt.password || ok != tt.ok {
+			t.Errorf("parseNetrc(%q) = %q, %q, %v, want %q, %q, %v", tt.host, login, password, ok, tt.login, tt.password, tt.ok)
+		}
+	}
+	if _, _, ok := parseNetrc("machine git.example.com login ci password secret\n", "github.com", true); ok {
+		t.Errorf("parseNetrc found credentials for a host that is not in the file")
+	}
+	if login, _, ok := parseNetrc(netrc, "unknown.example.com", false); ok {
+		t.Errorf("parseNetrc used the default entry (%q) without being asked to", login)
+	}
+	if login, _, _ := parseNetrc(netrc, "other.example.com", false); login != "me" {
+		t.Errorf("parseNetrc(other.example.com) without the default entry = %q, want me", login)
+	}
+}
+
+func TestGitRepoPath(t *testing.T) {
+	for _, repoURL := range []string{
+		"github.com/user/repo",
+		"https://github.com/user/repo.git",
+		"ssh://git@github.com:22/user/repo",
+		"git@github.com:user/repo.git",
+	} {
+		if got := gitRepoPath(repoURL); got != "github.com/user/repo" {
+			t.Errorf("gitRepoPath(%q) = %q, want github.com/user/repo", repoURL, got)
+		}
+	}
+	if got := gitCloneURL("git@github.com:user/repo.git"); got != "git@github.com:user/repo.git" {
+		t.Errorf("gitCloneURL turned an SSH URL into %q", got)
+	}
+}
+
+func TestGitCommandNetrc(t *testing.T) {
+	netrc := filepath.Join(t.TempDir(), "netrc")
+	if err := os.WriteFile(netrc, []byte("machine git.example.com login ci password secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NETRC", netrc)
+
+	cmd := gitCommand("git.example.com/team/pkg", "ls-remote", "https://git.example.com/team/pkg")
+	if !slices.Contains(cmd.Env, "C67_GIT_HOST=git.example.com") || !slices.Contains(cmd.Env, "C67_GIT_USERNAME=ci") || !slices.Contains(cmd.Env, "C67_GIT_PASSWORD=secret") {
+		t.Errorf("credentials are not in the environment of git")
+	}
+	if slices.Contains(cmd.Args, "secret") {
+		t.Errorf("the password is on the command line: %v", cmd.Args)
+	}
+	if cmd.Args[len(cmd.Args)-2] != "ls-remote" {
+		t.Errorf("git arguments = %v", cmd.Args)
+	}
+
+	for _, repoURL := range []string{"github.com/user/repo", "git@git.example.com:team/pkg.git", "http://git.example.com/team/pkg"} {
+		if cmd := gitCommand(repoURL, "fetch"); cmd.Env != nil || len(cmd.Args) != 2 {
+			t.Errorf("gitCommand(%q) = %v with env %v, want plain git", repoURL, cmd.Args, cmd.Env)
+		}
+	}
+}
+
+// TestGitCredentialHelper tests that the credential helper only answers
+// for https and the host the credentials are for
+func TestGitCredentialHelper(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	tests := []struct {
+		request, want string
+	}{
+		{"protocol=https\nhost=git.example.com\n\n", "username=ci\npassword=secret\n"},
+		{"protocol=http\nhost=git.example.com\n\n", ""},
+		{"protocol=https\nhost=evil.example.com\n\n", ""},
+		{"protocol=https\nhost=git.example.com:8443\n\n", ""},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command("sh", "-c", strings.TrimPrefix(gitCredentialHelper, "!")+" get")
+		cmd.Env = append(os.Environ(), "C67_GIT_HOST=git.example.com", "C67_GIT_USERNAME=ci", "C67_GIT_PASSWORD=secret")
+		cmd.Stdin = strings.NewReader(tt.request)
+		output, _ := cmd.Output()
+		if string(output) != tt.want {
+			t.Errorf("helper answered %q with %q, want %q", tt.request, output, tt.want)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+// Completion: 100% - array2d() grids with grid[x, y] indexing
+package main
+
+// grid.go - 2D arrays
+//
+//	grid := array2d(w, h, init)  w*h cells set to init, stored row by row
+//	grid[x, y]                   cell x of row y
+//	grid[x, y] <- v              overwrite that cell
+//	gridfill(grid, v)            set every cell to v
+//	gridcopy(grid)               independent copy of the grid
+//
+// A grid is an ordinary list of its w*h cells, so #grid, grid[i] and
+// @ cell in grid work on it as usual. The width and height follow the
+// last cell as one extra, uncounted entry:
+//
+//	[count][key0][val0]...[key(n-1)][val(n-1)][w][h]
+//
+// grid[x, y] reads them to compute the flat index y*w + x and aborts the
+// program when x is outside 0..<w or y is outside 0..<h. List helpers that
+// build a new list (append, slices, comprehensions) do not copy the size
+// entry, so only array2d() and gridcopy() results can be indexed by x, y.
+// The parser lowers grid[x, y] to _grid_get(grid, x, y) and
+// grid[x, y] <- v to _grid_set(grid, x, y, v).
+
+// compileGridCall compiles array2d(), gridfill(), gridcopy(), _grid_get()
+// and _grid_set()
+func (fc *C67Compiler) compileGridCall(call *CallExpr) {
+	argCounts := map[string]int{"array2d": 3, "gridfill": 2, "gridcopy": 1, "_grid_get": 3, "_grid_set": 4}
+	if len(call.Args) != argCounts[call.Function] {
+		switch call.Function {
+		case "array2d":
+			compilerError("array2d() requires exactly 3 arguments (width, height, initial value)")
+		case "gridfill":
+			compilerError("gridfill() requires exactly 2 arguments (grid, value)")
+		default:
+			compilerError("%s() requires exactly %d argument(s)", call.Function, argCounts[call.Function])
+		}
+	}
+	for _, name := range []string{"memcpy", "printf", "exit"} {
+		fc.trackFunctionCall(name)
+	}
+	fc.usesGrid = true
+
+	// Writing cells needs the same mutability as xs[i] <- v
+	if call.Function == "gridfill" || call.Function == "_grid_set" {
+		if ident, ok := call.Args[0].(*IdentExpr); ok {
+			if !fc.mutableVars[ident.Name] && !fc.globalVarsMutable[ident.Name] {
+				compilerError("cannot modify immutable list '%s'", ident.Name)
+			}
+		}
+	}
+
+	// Evaluate every argument but the last onto the stack, the last into xmm0
+	slots := len(call.Args) - 1
+	if slots > 0 {
+		fc.out.SubImmFromReg("rsp", int64((slots*8+15)&^15))
+	}
+	for i, arg := range call.Args {
+		fc.compileExpression(arg)
+		if i < slots {
+			fc.out.MovXmmToMem("xmm0", "rsp", i*8)
+		}
+	}
+
+	switch call.Function {
+	case "array2d":
+		fc.out.MovMemToXmm("xmm1", "rsp", 0)
+		fc.out.Cvttsd2si("rdi", "xmm1")
+		fc.out.MovMemToXmm("xmm1", "rsp", 8)
+		fc.out.Cvttsd2si("rsi", "xmm1")
+		fc.out.CallSymbol("_c67_array2d")
+		fc.out.MovqRegToXmm("xmm0", "rax")
+	case "gridfill":
+		fc.out.MovMemToReg("rdi", "rsp", 0)
+		fc.out.CallSymbol("_c67_gridfill")
+		fc.out.MovqRegToXmm("xmm0", "rax")
+	case "gridcopy":
+		fc.out.MovqXmmToReg("rdi", "xmm0")
+		fc.out.SubImmFromReg("rsp", StackSlotSize)
+		fc.out.CallSymbol("_c67_gridcopy")
+		fc.out.AddImmToReg("rsp", StackSlotSize)
+		fc.out.MovqRegToXmm("xmm0", "rax")
+	case "_grid_get":
+		fc.out.MovXmmToXmm("xmm1", "xmm0")
+		fc.out.MovMemToXmm("xmm0", "rsp", 8)
+		fc.out.MovMemToReg("rdi", "rsp", 0)
+		fc.out.CallSymbol("_c67_grid_cell")
+		fc.out.MovMemToXmm("xmm0", "rax", 0)
+	case "_grid_set":
+		fc.out.MovXmmToMem("xmm0", "rsp", 24)
+		fc.out.MovMemToXmm("xmm0", "rsp", 8)
+		fc.out.MovMemToXmm("xmm1", "rsp", 16)
+		fc.out.MovMemToReg("rdi", "rsp", 0)
+		fc.out.CallSymbol("_c67_grid_cell")
+		fc.out.MovMemToXmm("xmm0", "rsp", 24)
+		fc.out.MovXmmToMem("xmm0", "rax", 0)
+	}
+
+	if slots > 0 {
+		fc.out.AddImmToReg("rsp", int64((slots*8+15)&^15))
+	}
+}
+
+// generateGridHelpers emits the runtime helpers behind grids
+func (fc *C67Compiler) generateGridHelpers() {
+	fc.generateArray2d()
+	fc.generateGridCell()
+	fc.generateGridFill()
+	fc.generateGridCopy()
+}
+
+// generateArray2d emits _c67_array2d(rdi=w, rsi=h, xmm0=init) -> rax.
+// A negative width or height counts as 0.
+func (fc *C67Compiler) generateArray2d() {
+	fc.eb.MarkFunction("_c67_array2d")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+	fc.out.SubImmFromReg("rsp", 16)
+	fc.out.AndRegWithImm("rsp", -16)
+	fc.out.MovXmmToMem("xmm0", "rsp", 0) // [rsp] = init
+
+	for _, reg := range []string{"rdi", "rsi"} {
+		fc.out.CmpRegToImm(reg, 0)
+		positive := fc.forwardJump(JumpGreaterOrEqual)
+		fc.out.XorRegWithReg(reg, reg)
+		fc.landForwardJump(positive)
+	}
+	fc.out.MovRegToReg("r12", "rdi") // r12 = w
+	fc.out.MovRegToReg("r13", "rsi") // r13 = h
+	fc.out.MovRegToReg("rbx", "rdi")
+	fc.out.ImulRegWithReg("rbx", "rsi") // rbx = cells
+
+	// count, the cells and the size entry: 8 + (cells + 1) * 16 bytes
+	fc.out.MovRegToReg("rdi", "rbx")
+	fc.out.IncReg("rdi")
+	fc.out.ShlImmReg("rdi", 4)
+	fc.out.AddImmToReg("rdi", 8)
+	fc.callArenaAlloc()
+	fc.out.MovRegToReg("r14", "rax") // r14 = grid
+	fc.out.Cvtsi2sd("xmm0", "rbx")
+	fc.out.MovXmmToMem("xmm0", "r14", 0)
+
+	fc.out.MovMemToXmm("xmm0", "rsp", 0)
+	fc.out.XorRegWithReg("rcx", "rcx")
+	fc.out.LeaMemToReg("rdx", "r14", 8)
+	loop := fc.eb.text.Len()
+	fc.out.CmpRegToReg("rcx", "rbx")
+	done := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovRegToMem("rcx", "rdx", 0)
+	fc.out.MovXmmToMem("xmm0", "rdx", 8)
+	fc.out.IncReg("rcx")
+	fc.out.AddImmToReg("rdx", 16)
+	fc.out.JumpUnconditional(int32(loop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(done)
+	fc.out.MovRegToMem("r12", "rdx", 0)
+	fc.out.MovRegToMem("r13", "rdx", 8)
+
+	fc.out.MovRegToReg("rax", "r14")
+	fc.out.LeaMemToReg("rsp", "rbp", -32)
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generateGridCell emits _c67_grid_cell(rdi=grid, xmm0=x, xmm1=y) -> rax,
+// the address of the cell, after checking x and y against the grid size
+func (fc *C67Compiler) generateGridCell() {
+	fc.eb.MarkFunction("_c67_grid_cell")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.AndRegWithImm("rsp", -16)
+	fc.out.Cvttsd2si("r8", "xmm0") // r8 = x
+	fc.out.Cvttsd2si("r9", "xmm1") // r9 = y
+	fc.out.MovMemToXmm("xmm2", "rdi", 0)
+	fc.out.Cvttsd2si("rcx", "xmm2")
+	fc.out.ShlImmReg("rcx", 4)
+	fc.out.AddRegToReg("rcx", "rdi")
+	fc.out.MovMemToReg("r10", "rcx", 8)  // r10 = w
+	fc.out.MovMemToReg("r11", "rcx", 16) // r11 = h
+	fc.emitBoundsCheck("r8", "r10")
+	fc.emitBoundsCheck("r9", "r11")
+
+	// rdi + 16 + (y*w + x) * 16
+	fc.out.MovRegToReg("rax", "r9")
+	fc.out.ImulRegWithReg("rax", "r10")
+	fc.out.AddRegToReg("rax", "r8")
+	fc.out.ShlImmReg("rax", 4)
+	fc.out.AddRegToReg("rax", "rdi")
+	fc.out.AddImmToReg("rax", 16)
+	fc.out.MovRegToReg("rsp", "rbp")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generateGridFill emits _c67_gridfill(rdi=grid, xmm0=v) -> rax=grid.
+// Leaf function; works on any list.
+func (fc *C67Compiler) generateGridFill() {
+	fc.eb.MarkFunction("_c67_gridfill")
+
+	fc.out.MovMemToXmm("xmm1", "rdi", 0)
+	fc.out.Cvttsd2si("rcx", "xmm1")
+	fc.out.LeaMemToReg("rdx", "rdi", 16)
+	loop := fc.eb.text.Len()
+	fc.out.TestRegReg("rcx", "rcx")
+	done := fc.forwardJump(JumpLessOrEqual)
+	fc.out.MovXmmToMem("xmm0", "rdx", 0)
+	fc.out.AddImmToReg("rdx", 16)
+	fc.out.DecReg("rcx")
+	fc.out.JumpUnconditional(int32(loop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(done)
+	fc.out.MovRegToReg("rax", "rdi")
+	fc.out.Ret()
+}
+
+// generateGridCopy emits _c67_gridcopy(rdi=grid) -> rax, a new grid with
+// the same cells and size
+func (fc *C67Compiler) generateGridCopy() {
+	fc.eb.MarkFunction("_c67_gridcopy")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.AndRegWithImm("rsp", -16)
+
+	// 8 + (count + 1) * 16 bytes, size entry included
+	fc.out.MovRegToReg("rbx", "rdi") // rbx = source
+	fc.out.MovMemToXmm("xmm0", "rbx", 0)
+	fc.out.Cvttsd2si("r12", "xmm0")
+	fc.out.IncReg("r12")
+	fc.out.ShlImmReg("r12", 4)
+	fc.out.AddImmToReg("r12", 8) // r12 = bytes
+	fc.out.MovRegToReg("rdi", "r12")
+	fc.callArenaAlloc()
+
+	fc.out.MovRegToReg("rdi", "rax")
+	fc.out.MovRegToReg("rsi", "rbx")
+	fc.out.MovRegToReg("rdx", "r12")
+	fc.trackFunctionCall("memcpy")
+	fc.eb.GenerateCallInstruction("memcpy")
+
+	fc.out.LeaMemToReg("rsp", "rbp", -16)
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
@@ -4,7 +4,11 @@ package main
 // grow.go - Helper functions for dynamic arena growth
 
 // generateMetaArenaGrowth generates code to grow the meta-arena capacity
-// This doubles the meta-arena pointer array and initializes new arena structures
+// This doubles the meta-arena pointer array and initializes new arena structures.
+// The slots are copied to a newly mapped array instead of being realloc'd,
+// since other threads may still read arena pointers through the old one,
+// which is kept. On Linux it is mapped with a syscall, since this helper is
+// generated after the PLT is laid out.
 // Input registers:
 //
 //	r12 = required_depth
@@ -27,20 +31,44 @@ func (fc *C67Compiler) generateMetaArenaGrowth() {
 	fc.patchJumpImmediate(skipMaxJump+2, int32(skipMaxLabel-(skipMaxJump+6)))
 
 	// r13 = old_capacity, r14 = new_capacity
-	// Realloc meta-arena: realloc(old_ptr, new_capacity * 8)
+	// Allocate the new meta-arena (new_capacity * 8 bytes)
+	var allocFailedJump int
+	if fc.eb.target.OS() == OSLinux {
+		// mmap(NULL, size, PROT_READ|PROT_WRITE, MAP_PRIVATE|MAP_ANONYMOUS, -1, 0)
+		fc.out.XorRegWithReg("rdi", "rdi")
+		fc.out.MovRegToReg("rsi", "r14")
+		fc.out.ShlImmReg("rsi", 3) // rsi = new_capacity * 8
+		fc.out.MovImmToReg("rdx", "3")
+		fc.out.MovImmToReg("r10", "34")
+		fc.out.MovImmToReg("r8", "-1")
+		fc.out.XorRegWithReg("r9", "r9")
+		fc.out.MovImmToReg("rax", "9") // sys_mmap
+		fc.out.Syscall()
+
+		// Check if mmap failed (-4095..-1)
+		fc.out.CmpRegToImm("rax", -4096)
+		allocFailedJump = fc.eb.text.Len()
+		fc.out.JumpConditional(JumpAboveOrEqual, 0) // jae to error
+	} else {
+		fc.out.MovRegToReg("rdi", "r14")
+		fc.out.ShlImmReg("rdi", 3) // rdi = new_capacity * 8
+		fc.trackFunctionCall("malloc")
+		fc.eb.GenerateCallInstruction("malloc")
+
+		// Check if malloc failed
+		fc.out.TestRegReg("rax", "rax")
+		allocFailedJump = fc.eb.text.Len()
+		fc.out.JumpConditional(JumpEqual, 0) // je to error
+	}
+
+	// Copy the old slots with rep movsq
+	fc.out.MovRegToReg("rdi", "rax")
 	fc.out.LeaSymbolToReg("rbx", "_c67_arena_meta")
-	fc.out.MovMemToReg("rdi", "rbx", 0) // rdi = old meta-arena pointer
-	fc.out.MovRegToReg("rsi", "r14")
-	fc.out.ShlImmReg("rsi", 3) // rsi = new_capacity * 8
-	fc.trackFunctionCall("realloc")
-	fc.eb.GenerateCallInstruction("realloc")
-
-	// Check if realloc failed
-	fc.out.TestRegReg("rax", "rax")
-	reallocFailedJump := fc.eb.text.Len()
-	fc.out.JumpConditional(JumpEqual, 0) // je to error
+	fc.out.MovMemToReg("rsi", "rbx", 0)   // rsi = old meta-arena pointer
+	fc.out.MovRegToReg("rcx", "r13")      // rcx = old_capacity
+	fc.out.Emit([]byte{0xf3, 0x48, 0xa5}) // rep movsq
 
-	// Update meta-arena pointer
+	// Publish the new meta-arena pointer
 	fc.out.LeaSymbolToReg("rbx", "_c67_arena_meta")
 	fc.out.MovRegToMem("rax", "rbx", 0)
 	fc.out.MovRegToReg("r15", "rax") // r15 = new meta-arena pointer
@@ -50,7 +78,7 @@ func (fc *C67Compiler) generateMetaArenaGrowth() {
 	fc.out.MovMemToReg("r13", "rbx", 0) // r13 = current len
 
 	// Return the error jump position so caller can patch it
-	fc.metaArenaGrowthErrorJump = reallocFailedJump
+	fc.metaArenaGrowthErrorJump = allocFailedJump
 }
 
 // generateArenaInitLoop generates code to initialize new arena structures
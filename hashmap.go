@@ -3,223 +3,246 @@ package main
 
 import (
 	"fmt"
-	"hash/fnv"
+	"math/bits"
 )
 
 // C67HashMap represents a hash map from uint64 to float64
 // This is the fundamental datastructure in C67
 // All values (ints, strings, etc) are stored in such a hash map
+//
+// It is the model of the map runtime in mapindex.go: the entries are kept in
+// insertion order, as in the universal [count][key0][val0]... layout, and an
+// open-addressing index finds them. The index is split into groups of 16
+// control bytes, one per slot, that are compared with a single SSE2 pcmpeqb.
+// A control byte is mapCtrlEmpty, mapCtrlDeleted (a tombstone) or the top 7
+// bits of the hash of the key in the slot. Each slot holds an entry number.
+//
+// Up to mapSmallLimit entries there is no index at all: a linear scan of a
+// few entries is as fast as hashing, and most maps stay that small. The
+// index is built when the map grows past the limit.
 type C67HashMap struct {
-	buckets []C67HashBucket
-	size    int
-	count   int
+	entries    []C67HashEntry
+	ctrl       []byte
+	slots      []int
+	tombstones int
 }
 
-type C67HashBucket struct {
-	key      uint64
-	value    float64
-	occupied bool
-	next     *C67HashBucket
+// C67HashEntry is a key and its value
+type C67HashEntry struct {
+	key   uint64
+	value float64
 }
 
+const (
+	mapGroupSize      = 16                 // control bytes compared at once
+	mapCtrlEmpty      = 0x80               // never used slot, ends a probe
+	mapCtrlDeleted    = 0xFE               // tombstone, probes continue past it
+	mapMaxLoadPercent = 85                 // used and deleted slots before a rehash
+	mapHashMultiplier = 0x9E3779B97F4A7C15 // 2^64 / golden ratio
+	mapSmallLimit     = 8                  // entries that are scanned without an index
+)
+
 // NewC67HashMap creates a new hash map with the given initial size
 func NewC67HashMap(initialSize int) *C67HashMap {
-	if initialSize < 16 {
-		initialSize = 16
+	m := &C67HashMap{}
+	if initialSize > mapSmallLimit {
+		m.rehash(mapIndexSize(initialSize))
 	}
-	return &C67HashMap{
-		buckets: make([]C67HashBucket, initialSize),
-		size:    initialSize,
-		count:   0,
+	return m
+}
+
+// mapIndexSize returns the smallest index size, a power of two and at least
+// one group, with room for count keys
+func mapIndexSize(count int) int {
+	size := mapGroupSize
+	for count*100 > size*mapMaxLoadPercent {
+		size *= 2
 	}
+	return size
+}
+
+// mapHash hashes the bits of a key. The low bits of float64 integers are
+// all zero, so the product is folded to mix its high bits into the low ones
+// that pick the group. The top 7 bits are left alone for the control byte.
+func mapHash(key uint64) uint64 {
+	h := key * mapHashMultiplier
+	return h ^ h>>32
 }
 
 // hash computes the hash of a uint64 key
 func (m *C67HashMap) hash(key uint64) uint64 {
-	h := fnv.New64a()
-	bytes := make([]byte, 8)
-	for i := 0; i < 8; i++ {
-		bytes[i] = byte(key >> (i * 8))
-	}
-	h.Write(bytes)
-	return h.Sum64()
+	return mapHash(key)
 }
 
-// Get retrieves a value from the hash map
-func (m *C67HashMap) Get(key uint64) (float64, bool) {
-	idx := m.hash(key) % uint64(m.size)
-	bucket := &m.buckets[idx]
+// group returns the first slot of the group where probing for h starts
+func (m *C67HashMap) group(h uint64) int {
+	return int(h>>3) & (len(m.ctrl) - 1) &^ (mapGroupSize - 1)
+}
+
+// find returns the entry number of key, or -1
+func (m *C67HashMap) find(key uint64) int {
+	if m.ctrl == nil {
+		for i, e := range m.entries {
+			if e.key == key {
+				return i
+			}
+		}
+		return -1
+	}
+	if slot := m.probe(key); slot >= 0 {
+		return m.slots[slot]
+	}
+	return -1
+}
 
-	// Check the first bucket
-	if bucket.occupied && bucket.key == key {
-		return bucket.value, true
+// probe returns the slot that holds key in the index, or -1
+func (m *C67HashMap) probe(key uint64) int {
+	h := m.hash(key)
+	h2 := byte(h >> 57)
+	for g := m.group(h); ; g = (g + mapGroupSize) & (len(m.ctrl) - 1) {
+		matches, empty := 0, false
+		for i, c := range m.ctrl[g : g+mapGroupSize] {
+			if c == h2 {
+				matches |= 1 << i
+			}
+			empty = empty || c == mapCtrlEmpty
+		}
+		for ; matches != 0; matches &= matches - 1 {
+			slot := g + bits.TrailingZeros(uint(matches))
+			if m.entries[m.slots[slot]].key == key {
+				return slot
+			}
+		}
+		if empty {
+			return -1
+		}
 	}
+}
 
-	// Check the chain
-	current := bucket.next
-	for current != nil {
-		if current.key == key {
-			return current.value, true
+// place puts entry number entry in the first free or deleted slot for h
+func (m *C67HashMap) place(h uint64, entry int) {
+	for g := m.group(h); ; g = (g + mapGroupSize) & (len(m.ctrl) - 1) {
+		for slot := g; slot < g+mapGroupSize; slot++ {
+			if m.ctrl[slot]&0x80 != 0 {
+				if m.ctrl[slot] == mapCtrlDeleted {
+					m.tombstones--
+				}
+				m.ctrl[slot] = byte(h >> 57)
+				m.slots[slot] = entry
+				return
+			}
 		}
-		current = current.next
 	}
+}
 
+// rehash builds a new index with size slots, which drops the tombstones
+func (m *C67HashMap) rehash(size int) {
+	m.ctrl = make([]byte, size)
+	m.slots = make([]int, size)
+	for i := range m.ctrl {
+		m.ctrl[i] = mapCtrlEmpty
+	}
+	m.tombstones = 0
+	for i, e := range m.entries {
+		m.place(m.hash(e.key), i)
+	}
+}
+
+// Get retrieves a value from the hash map
+func (m *C67HashMap) Get(key uint64) (float64, bool) {
+	if entry := m.find(key); entry >= 0 {
+		return m.entries[entry].value, true
+	}
 	return 0.0, false
 }
 
 // Set stores a value in the hash map
 func (m *C67HashMap) Set(key uint64, value float64) {
-	idx := m.hash(key) % uint64(m.size)
-	bucket := &m.buckets[idx]
-
-	// If bucket is empty, use it
-	if !bucket.occupied {
-		bucket.key = key
-		bucket.value = value
-		bucket.occupied = true
-		m.count++
-		return
-	}
-
-	// If this key already exists in the first bucket, update it
-	if bucket.key == key {
-		bucket.value = value
+	if entry := m.find(key); entry >= 0 {
+		m.entries[entry].value = value
 		return
 	}
 
-	// Check the chain for existing key
-	current := bucket.next
-	prev := bucket
-	for current != nil {
-		if current.key == key {
-			current.value = value
-			return
+	count := len(m.entries) + 1
+	if m.ctrl == nil {
+		m.entries = append(m.entries, C67HashEntry{key: key, value: value})
+		if count > mapSmallLimit {
+			m.rehash(mapIndexSize(count))
 		}
-		prev = current
-		current = current.next
-	}
-
-	// Add new entry to chain
-	newBucket := &C67HashBucket{
-		key:      key,
-		value:    value,
-		occupied: true,
-		next:     nil,
+		return
 	}
-	prev.next = newBucket
-	m.count++
 
-	// Check load factor and resize if needed
-	if float64(m.count)/float64(m.size) > 0.75 {
-		m.resize()
+	// Keep an empty slot in every probe sequence: past the load factor,
+	// grow if the live keys need it, or else only clear the tombstones
+	if (count+m.tombstones)*100 > len(m.ctrl)*mapMaxLoadPercent {
+		m.resize(count)
 	}
+	m.entries = append(m.entries, C67HashEntry{key: key, value: value})
+	m.place(m.hash(key), len(m.entries)-1)
 }
 
-// resize doubles the size of the hash map and rehashes all entries
-func (m *C67HashMap) resize() {
-	oldBuckets := m.buckets
-	m.size *= 2
-	m.buckets = make([]C67HashBucket, m.size)
-	m.count = 0
-
-	// Rehash all entries
-	for i := range oldBuckets {
-		bucket := &oldBuckets[i]
-		if bucket.occupied {
-			m.Set(bucket.key, bucket.value)
-		}
-
-		current := bucket.next
-		for current != nil {
-			m.Set(current.key, current.value)
-			current = current.next
-		}
+// resize rehashes the index for count keys, doubling it when they would fill
+// more than half of the load factor
+func (m *C67HashMap) resize(count int) {
+	size := len(m.ctrl)
+	if count*200 > size*mapMaxLoadPercent {
+		size *= 2
 	}
+	m.rehash(size)
 }
 
 // Delete removes a key from the hash map
+// The slot becomes a tombstone, and the last entry is moved into the gap so
+// that the entries stay packed
 func (m *C67HashMap) Delete(key uint64) bool {
-	idx := m.hash(key) % uint64(m.size)
-	bucket := &m.buckets[idx]
-
-	// Check first bucket
-	if bucket.occupied && bucket.key == key {
-		if bucket.next != nil {
-			// Move next bucket to first position
-			*bucket = *bucket.next
-		} else {
-			// Clear the bucket
-			bucket.key = 0
-			bucket.value = 0.0
-			bucket.occupied = false
-			bucket.next = nil
+	if m.ctrl == nil {
+		entry := m.find(key)
+		if entry < 0 {
+			return false
 		}
-		m.count--
+		m.entries[entry] = m.entries[len(m.entries)-1]
+		m.entries = m.entries[:len(m.entries)-1]
 		return true
 	}
-
-	// Check chain
-	prev := bucket
-	current := bucket.next
-	for current != nil {
-		if current.key == key {
-			prev.next = current.next
-			m.count--
-			return true
-		}
-		prev = current
-		current = current.next
+	slot := m.probe(key)
+	if slot < 0 {
+		return false
 	}
-
-	return false
+	entry, last := m.slots[slot], len(m.entries)-1
+	m.ctrl[slot] = mapCtrlDeleted
+	m.tombstones++
+	if entry != last {
+		m.entries[entry] = m.entries[last]
+		m.slots[m.probe(m.entries[entry].key)] = entry
+	}
+	m.entries = m.entries[:last]
+	return true
 }
 
-// Keys returns all keys in the hash map
+// Keys returns all keys in the hash map, in insertion order
 func (m *C67HashMap) Keys() []uint64 {
-	keys := make([]uint64, 0, m.count)
-
-	for i := range m.buckets {
-		bucket := &m.buckets[i]
-		if bucket.occupied {
-			keys = append(keys, bucket.key)
-		}
-
-		current := bucket.next
-		for current != nil {
-			keys = append(keys, current.key)
-			current = current.next
-		}
+	keys := make([]uint64, 0, len(m.entries))
+	for _, e := range m.entries {
+		keys = append(keys, e.key)
 	}
-
 	return keys
 }
 
-// Values returns all values in the hash map
+// Values returns all values in the hash map, in insertion order
 func (m *C67HashMap) Values() []float64 {
-	values := make([]float64, 0, m.count)
-
-	for i := range m.buckets {
-		bucket := &m.buckets[i]
-		if bucket.occupied {
-			values = append(values, bucket.value)
-		}
-
-		current := bucket.next
-		for current != nil {
-			values = append(values, current.value)
-			current = current.next
-		}
+	values := make([]float64, 0, len(m.entries))
+	for _, e := range m.entries {
+		values = append(values, e.value)
 	}
-
 	return values
 }
 
 // Count returns the number of entries in the hash map
 func (m *C67HashMap) Count() int {
-	return m.count
+	return len(m.entries)
 }
 
 // String returns a string representation of the hash map
 func (m *C67HashMap) String() string {
-	return fmt.Sprintf("C67HashMap{count: %d, size: %d}", m.count, m.size)
+	return fmt.Sprintf("C67HashMap{count: %d, size: %d}", len(m.entries), len(m.ctrl))
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"math"
 	"testing"
 )
 
@@ -182,3 +183,94 @@ func TestC67HashMapEmpty(t *testing.T) {
 		t.Errorf("Expected 0 values for empty map, got %d", len(values))
 	}
 }
+
+func TestC67HashMapTombstones(t *testing.T) {
+	m := NewC67HashMap(16)
+	size := len(m.ctrl)
+
+	// Keep the count low while deleting: the tombstones must not fill the
+	// index, and the index must not grow for them
+	for i := uint64(0); i < 1000; i++ {
+		m.Set(i, float64(i))
+		if i >= 4 && !m.Delete(i-4) {
+			t.Fatalf("Expected key %d to be deleted", i-4)
+		}
+	}
+	if m.Count() != 4 {
+		t.Errorf("Expected count 4, got %d", m.Count())
+	}
+	if len(m.ctrl) != size {
+		t.Errorf("Expected the index to stay at %d slots, got %d", size, len(m.ctrl))
+	}
+	for i := uint64(996); i < 1000; i++ {
+		if val, ok := m.Get(i); !ok || val != float64(i) {
+			t.Errorf("Expected %v, got %v for key %d", float64(i), val, i)
+		}
+	}
+	if _, ok := m.Get(995); ok {
+		t.Error("Expected key 995 to be deleted")
+	}
+}
+
+func TestC67HashMapFloatKeys(t *testing.T) {
+	m := NewC67HashMap(16)
+	want := make(map[uint64]float64)
+
+	// float64 integers have all-zero low bits, which must still spread
+	for i := 0; i < 5000; i++ {
+		key := math.Float64bits(float64(i * 7 % 3001))
+		if i%3 == 2 {
+			_, exists := want[key]
+			if m.Delete(key) != exists {
+				t.Fatalf("Delete(%d) disagrees with a Go map", i*7%3001)
+			}
+			delete(want, key)
+			continue
+		}
+		m.Set(key, float64(i))
+		want[key] = float64(i)
+	}
+
+	if m.Count() != len(want) {
+		t.Errorf("Expected count %d, got %d", len(want), m.Count())
+	}
+	for key, v := range want {
+		if val, ok := m.Get(key); !ok || val != v {
+			t.Errorf("Expected %v, got %v for key %v", v, val, math.Float64frombits(key))
+		}
+	}
+	if m.Count()*100 > len(m.ctrl)*mapMaxLoadPercent {
+		t.Errorf("Load factor exceeded: %d keys in %d slots", m.Count(), len(m.ctrl))
+	}
+}
+
+func TestC67HashMapSmall(t *testing.T) {
+	m := NewC67HashMap(0)
+
+	// Small maps are scanned and get an index only past mapSmallLimit keys
+	for i := uint64(1); i <= mapSmallLimit; i++ {
+		m.Set(i, float64(i))
+	}
+	m.Set(3, 30)
+	if !m.Delete(1) || m.Delete(1) {
+		t.Error("Expected key 1 to be deleted exactly once")
+	}
+	if m.ctrl != nil {
+		t.Errorf("Expected no index for %d keys", m.Count())
+	}
+	m.Set(100, 100)
+	m.Set(101, 101)
+	if m.ctrl == nil {
+		t.Errorf("Expected an index for %d keys", m.Count())
+	}
+
+	want := map[uint64]float64{2: 2, 3: 30, 4: 4, 5: 5, 6: 6, 7: 7, 8: 8, 100: 100, 101: 101}
+	if m.Count() != len(want) {
+		t.Errorf("Expected count %d, got %d", len(want), m.Count())
+	}
+	for key, v := range want {
+		if val, ok := m.Get(key); !ok || val != v {
+			t.Errorf("Expected %v, got %v for key %d", v, val, key)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Completion: 100% - C header of the exported functions with --emit-header
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// header.go - Prototypes for calling C67 functions from C, from --emit-header
+//
+//	c67 --emit-header mathlib.c67 -o mathlib   # also writes mathlib.h
+//
+// A C67 value is a double, and lambdas take their arguments in xmm0-xmm7
+// and return in xmm0, as the C calling convention does for doubles, so each
+// exported function is declared as taking and returning doubles:
+//
+//	## add returns the sum of a and b
+//	add = (a, b) -> a + b
+//
+// becomes
+//
+//	/* add returns the sum of a and b */
+//	double add(double, double);
+//
+// The functions are the ones c67 doc lists for the input file (see
+// doc.go). Strings, lists and maps are passed as the double that holds
+// their address. Functions that can be called with more than one arity, or
+// with a variable number of arguments, have no C prototype and are listed
+// in a comment instead.
+
+// headerPath returns where the header of the executable at outputPath goes
+func headerPath(outputPath string) string {
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".h"
+}
+
+// headerGuard returns the include guard macro of the header of inputPath
+func headerGuard(inputPath string) string {
+	var guard strings.Builder
+	for _, r := range strings.ToUpper(filepath.Base(headerPath(inputPath))) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			guard.WriteRune(r)
+		} else {
+			guard.WriteByte('_')
+		}
+	}
+	return "C67_" + guard.String()
+}
+
+// fixedArityFunctions returns the functions documented in doc that take
+// one number of arguments, which C can call, and the signatures of the
+// other functions
+func fixedArityFunctions(doc *ModuleDoc) (functions []DocEntry, others []string) {
+	for _, entry := range doc.Entries {
+		if entry.Kind != "function" {
+			continue
+		}
+		if len(entry.Arities) != 1 || strings.HasSuffix(entry.Arities[0], "+") { // "2+" is variadic
+			others = append(others, entry.Signature)
+			continue
+		}
+		functions = append(functions, entry)
+	}
+	return functions, others
+}
+
+// generateHeader returns the C header declaring the functions documented
+// in doc, for the input file inputPath
+func generateHeader(doc *ModuleDoc, inputPath string) string {
+	guard := headerGuard(inputPath)
+	var b strings.Builder
+	fmt.Fprintf(&b, "/* Generated by c67 from %s. Do not edit. */\n", filepath.Base(inputPath))
+	fmt.Fprintf(&b, "#ifndef %s\n#define %s\n\n", guard, guard)
+	b.WriteString("#ifdef __cplusplus\nextern \"C\" {\n#endif\n")
+
+	functions, undeclared := fixedArityFunctions(doc)
+	for _, entry := range functions {
+		arity, _ := strconv.Atoi(entry.Arities[0])
+		b.WriteString("\n")
+		if entry.Doc != "" {
+			fmt.Fprintf(&b, "/* %s */\n", strings.ReplaceAll(entry.Doc, "*/", "* /"))
+		}
+		params := "void"
+		if arity > 0 {
+			params = strings.Repeat("double, ", arity-1) + "double"
+		}
+		fmt.Fprintf(&b, "double %s(%s);\n", entry.Name, params)
+	}
+	if len(undeclared) > 0 {
+		b.WriteString("\n/* Not declared, since they take more than one number of arguments:\n")
+		for _, signature := range undeclared {
+			fmt.Fprintf(&b, " *   %s\n", strings.ReplaceAll(signature, "*/", "* /"))
+		}
+		b.WriteString(" */\n")
+	}
+
+	b.WriteString("\n#ifdef __cplusplus\n}\n#endif\n\n")
+	fmt.Fprintf(&b, "#endif /* %s */\n", guard)
+	return b.String()
+}
+
+// writeHeader writes the header of the executable at outputPath. The
+// functions are read from inputPath before it is optimized, which could
+// inline them or leave out the ones that main does not call.
+func writeHeader(inputPath, outputPath string) error {
+	doc, err := documentFile(inputPath)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(headerPath(outputPath), []byte(generateHeader(doc, inputPath)), 0o644)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestEmitHeader tests that --emit-header declares the exported functions,
+// and lists the ones with more than one arity in a comment
+func TestEmitHeader(t *testing.T) {
+	defer func() { EmitHeaderFlag = false }()
+	EmitHeaderFlag = true
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "mathlib.c67")
+	source := `export add tick pick sum
+## add returns the sum of a and b
+add = (a, b) -> a + b
+tick = { println("tick") }
+pick = (a) -> a, (a, b) -> b
+sum = (first, rest...) -> first
+hidden = x -> x
+println(add(1, 2))
+`
+	if err := os.WriteFile(input, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CompileC67(input, filepath.Join(dir, "mathlib"), Platform{Arch: ArchX86_64, OS: OSLinux}); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "mathlib.h"))
+	if err != nil {
+		t.Fatalf("Expected a header: %v", err)
+	}
+	header := string(data)
+	for _, want := range []string{
+		"#ifndef C67_MATHLIB_H\n",
+		"/* add returns the sum of a and b */\ndouble add(double, double);\n",
+		"double tick(void);\n",
+		" *   pick(...)\n",
+		" *   sum(first, rest...)\n",
+	} {
+		if !strings.Contains(header, want) {
+			t.Errorf("Expected %q in the header, got:\n%s", want, header)
+		}
+	}
+	if strings.Contains(header, "hidden") {
+		t.Errorf("Expected hidden to be left out, since it is not exported:\n%s", header)
+	}
+
+	if cc, err := exec.LookPath("cc"); err == nil {
+		use := filepath.Join(dir, "use.c")
+		if err := os.WriteFile(use, []byte("#include \"mathlib.h\"\nint main(void) { return add(1, 2) != 3; }\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if output, err := exec.Command(cc, "-fsyntax-only", "-Wall", "-Werror", use).CombinedOutput(); err != nil {
+			t.Errorf("The header does not compile: %v\n%s", err, output)
+		}
+	}
+}
@@ -207,32 +207,37 @@ func isLikelyDirectory(source string) bool {
 
 // resolveGitRepo clones or updates a git repository and returns paths to .c67 files
 func resolveGitRepo(spec *ImportSpec) ([]string, error) {
-	// Normalize the URL
-	repoURL := spec.Source
-
-	// Handle SSH format: git@github.com:user/repo.git -> github.com/user/repo
-	if strings.HasPrefix(repoURL, "git@") {
-		repoURL = strings.TrimPrefix(repoURL, "git@")
-		repoURL = strings.Replace(repoURL, ":", "/", 1)
-		repoURL = strings.TrimSuffix(repoURL, ".git")
-	}
-
-	// Add https:// if no protocol
-	if !strings.HasPrefix(repoURL, "http://") && !strings.HasPrefix(repoURL, "https://") {
-		repoURL = "https://" + repoURL
+	// SSH URLs stay SSH URLs, for the keys of ssh-agent, and host/path is
+	// fetched over HTTPS (see gitauth.go)
+	repoURL := gitCloneURL(spec.Source)
+
+	// A range like ^1.2 is the highest tag in it (see semver.go)
+	version := spec.Version
+	if isVersionRange(version) {
+		cachePath, err := GetRepoCachePath(repoURL)
+		if err != nil {
+			return nil, err
+		}
+		if version, err = resolveVersionRange(repoURL, cachePath, version); err != nil {
+			return nil, withPrivateImportHint(err)
+		}
+		if VerboseMode {
+			fmt.Fprintf(os.Stderr, "Resolved %s@%s to %s\n", repoURL, spec.Version, version)
+		}
 	}
 
 	// Clone or update the repository
 	var repoPath string
 	var err error
-	if spec.Version != "" {
-		repoPath, err = EnsureRepoClonedWithVersion(repoURL, spec.Version, false)
+	if version != "" {
+		repoPath, err = EnsureRepoClonedWithVersion(repoURL, version, false)
 	} else {
 		repoPath, err = EnsureRepoCloned(repoURL, false)
 	}
 	if err != nil {
-		return nil, err
+		return nil, withPrivateImportHint(err)
 	}
+	buildManifest.addGitImport(repoURL, version, repoPath)
 
 	// Find all top-level .c67 files
 	return findC67Files(repoPath, false)
@@ -325,3 +330,23 @@ func findC67Files(dirPath string, topLevelOnly bool) ([]string, error) {
 
 	return files, nil
 }
+
+// checkImportCycle returns an error with the whole chain if file, which is
+// imported by the last file of chain, is already in it
+func checkImportCycle(chain []string, file string) error {
+	for i, imported := range chain {
+		if imported != file {
+			continue
+		}
+		dir := filepath.Dir(chain[0])
+		names := make([]string, 0, len(chain)-i+1)
+		for _, path := range append(chain[i:], file) {
+			if rel, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+				path = rel
+			}
+			names = append(names, path)
+		}
+		return fmt.Errorf("import cycle: %s", strings.Join(names, " -> "))
+	}
+	return nil
+}
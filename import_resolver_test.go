@@ -1,6 +1,12 @@
 package main
 
-import "testing"
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
 
 func TestImportVersionParsing(t *testing.T) {
 	tests := []struct {
@@ -28,3 +34,78 @@ func TestImportVersionParsing(t *testing.T) {
 		}
 	}
 }
+
+// writeImportTree writes the files, by path, under a new directory and
+// returns the path of main.c67
+func writeImportTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, source := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "main.c67")
+}
+
+// TestNestedImports tests that the imports of an imported file are
+// resolved relative to it
+func TestNestedImports(t *testing.T) {
+	main := writeImportTree(t, map[string]string{
+		"a/a.c67":  "import \"../b\" as b\nfa = x -> b.fb(x) + 1\n",
+		"b/b.c67":  "fb = x -> x * 2\n",
+		"main.c67": "import \"./a\" as a\nimport \"./b\" as b\nprintln(a.fa(1) + b.fb(1))\n",
+	})
+	exe := strings.TrimSuffix(main, ".c67")
+	if err := CompileC67(main, exe, Platform{Arch: ArchX86_64, OS: OSLinux}); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+	output, err := exec.Command(exe).Output()
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if string(output) != "5\n" {
+		t.Errorf("Expected 5, got %q", output)
+	}
+}
+
+// TestImportCycle tests that an import cycle is reported with the files
+// that form it
+func TestImportCycle(t *testing.T) {
+	main := writeImportTree(t, map[string]string{
+		"a/a.c67":  "import \"../b\" as b\nfa = x -> x + 1\n",
+		"b/b.c67":  "import \"../a\" as a\nfb = x -> x * 2\n",
+		"main.c67": "import \"./a\" as a\nprintln(a.fa(1))\n",
+	})
+	err := CompileC67(main, strings.TrimSuffix(main, ".c67"), Platform{Arch: ArchX86_64, OS: OSLinux})
+	want := "import cycle: a/a.c67 -> b/b.c67 -> a/a.c67"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Expected %q, got %v", want, err)
+	}
+}
+
+// TestDiamondImport tests that a file imported by two files under
+// different aliases can be called through both
+func TestDiamondImport(t *testing.T) {
+	main := writeImportTree(t, map[string]string{
+		"a/a.c67":  "import \"../d\" as d\nfa = x -> d.fd(x) + 1\n",
+		"b/b.c67":  "import \"../d\" as dd\nfb = x -> dd.fd(x) + 10\n",
+		"d/d.c67":  "fd = x -> x * 2\n",
+		"main.c67": "import \"./a\" as a\nimport \"./b\" as b\nprintln(a.fa(1) + b.fb(1))\n",
+	})
+	exe := strings.TrimSuffix(main, ".c67")
+	if err := CompileC67(main, exe, Platform{Arch: ArchX86_64, OS: OSLinux}); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+	output, err := exec.Command(exe).Output()
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if string(output) != "15\n" {
+		t.Errorf("Expected 15, got %q", output)
+	}
+}
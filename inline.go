@@ -0,0 +1,86 @@
+// Completion: 100% - inline and noinline on function definitions
+package main
+
+import "fmt"
+
+// inline.go - Controlling which functions are inlined
+//
+// The optimizer inlines module-level functions whose body is one simple
+// expression (see collectInlineCandidates). A definition can overrule it:
+//
+//	noinline hash = (x, y) -> x * 31 + y          # always called
+//	inline clamp = x -> x { | x < 0 -> 0 ~> x }    # inlined, also as a match
+//
+// noinline keeps the function a function, for profilers, perf and
+// --trace-counts, or to keep the code small. inline also inlines a body
+// that is a match, or that has nested calls or long lists, which are not
+// inlined by default. Bodies that are blocks, or that have an expression
+// the inliner can not substitute the arguments into, are never inlined, and
+// inline on such a function is an error. Both go before module-level
+// definitions with =, and are only keywords there.
+
+// parseInlineHint parses inline or noinline and the definition after it
+func (p *Parser) parseInlineHint() Statement {
+	hint := p.current.Value
+	p.nextToken() // skip 'inline' or 'noinline'
+	if p.functionDepth > 0 {
+		p.error(hint + " can only be used on module-level functions")
+	}
+	stmt := p.parseStatement()
+	assign, ok := stmt.(*AssignStmt)
+	if !ok || assign.Mutable || assign.IsUpdate {
+		p.error(fmt.Sprintf("%s must be followed by a function definition with =", hint))
+		return stmt
+	}
+	lambda, ok := assign.Value.(*LambdaExpr)
+	if !ok {
+		p.error(fmt.Sprintf("%s %s: %s is not a function", hint, assign.Name, assign.Name))
+		return stmt
+	}
+	if hint == "inline" {
+		if blocker := inlineBlocker(lambda.Body); blocker != nil {
+			p.error(fmt.Sprintf("inline %s: %s can not be inlined", assign.Name, blocker))
+		}
+	}
+	assign.Inline = hint
+	return assign
+}
+
+// inlineBlocker returns the part of a function body that keeps it from
+// being inlined with inline, or nil if it can be: blocks, and the
+// expressions substituteParams does not substitute arguments into
+func inlineBlocker(expr Expression) Expression {
+	var children []Expression
+	switch e := expr.(type) {
+	case *NumberExpr, *StringExpr, *IdentExpr:
+		return nil
+	case *BinaryExpr:
+		children = []Expression{e.Left, e.Right}
+	case *CallExpr:
+		children = e.Args
+	case *ListExpr:
+		children = e.Elements
+	case *MapExpr:
+		children = append(append(children, e.Keys...), e.Values...)
+	case *IndexExpr:
+		children = []Expression{e.List, e.Index}
+	case *FMAExpr:
+		children = []Expression{e.A, e.B, e.C}
+	case *MatchExpr:
+		children = []Expression{e.Condition, e.DefaultExpr}
+		for _, clause := range e.Clauses {
+			children = append(children, clause.Guard, clause.Result)
+		}
+	default:
+		return expr
+	}
+	for _, child := range children {
+		if child == nil {
+			continue
+		}
+		if blocker := inlineBlocker(child); blocker != nil {
+			return blocker
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const inlineHintsSource = `noinline twice = x -> x * 2
+inline clamp = x -> x { | x < 0 -> 0 ~> x }
+sign = x -> x { | x < 0 -> -1 ~> 1 }
+main = {
+    println(twice(21))
+    println(clamp(-5))
+    println(clamp(7))
+    println(sign(-3))
+}
+`
+
+// TestInlineHints tests that noinline functions stay calls and that inline
+// inlines a match, which is not inlined by default
+func TestInlineHints(t *testing.T) {
+	program := NewParser(inlineHintsSource).ParseProgram()
+	calls := make(map[string]int)
+	visitAST(reflect.ValueOf(program.Statements), func(node any) {
+		if call, ok := node.(*CallExpr); ok {
+			calls[call.Function]++
+		}
+	})
+	if calls["twice"] != 1 || calls["clamp"] != 0 || calls["sign"] != 1 {
+		t.Errorf("Expected twice and sign to be called and clamp to be inlined, got calls %v", calls)
+	}
+
+	result := compileAndRun(t, inlineHintsSource)
+	if !strings.Contains(result, "42\n0\n7\n-1\n") {
+		t.Errorf("Expected output to contain '42\\n0\\n7\\n-1\\n', got: %s", result)
+	}
+}
+
+// TestInlineHintErrors tests inline and noinline where they can not be used
+func TestInlineHintErrors(t *testing.T) {
+	tests := []struct {
+		source, want string
+	}{
+		{"inline f = x -> {\n    y := x + 1\n    y\n}\nmain = { println(f(1)) }\n", "inline f: { y := (x + 1); y } can not be inlined"},
+		{"noinline n = 3\nmain = { println(n) }\n", "noinline n: n is not a function"},
+		{"inline f := x -> x\nmain = { println(f(1)) }\n", "inline must be followed by a function definition with ="},
+		{"main = {\n    inline g = x -> x\n    println(g(1))\n}\n", "inline can only be used on module-level functions"},
+	}
+	for _, tt := range tests {
+		parser := NewParser(tt.source)
+		parser.quiet = true
+		func() {
+			defer func() { recover() }()
+			parser.ParseProgram()
+		}()
+		if report := parser.errors.Report(false); !strings.Contains(report, tt.want) {
+			t.Errorf("Expected %q, got:\n%s", tt.want, report)
+		}
+	}
+
+	// Elsewhere they are names
+	result := compileAndRun(t, "inline = 5\nnoinline := 2\nmain = { println(inline + noinline) }\n")
+	if !strings.Contains(result, "7\n") {
+		t.Errorf("Expected output to contain '7\\n', got: %s", result)
+	}
+}
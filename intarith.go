@@ -0,0 +1,165 @@
+// Completion: 100% - Wrapping and saturating integer arithmetic (x86_64)
+package main
+
+import "strconv"
+
+// intarith.go - The wrapping (+% -% *%) and saturating (+| -| *|) operators
+//
+// The plain operators work on float64, so 250 + 10 is 260 whatever the
+// width a byte for a C struct or a file format is declared with. The
+// integer operators work in the width of their operands instead, which is
+// given by a cast to one of the integer types of `as`, directly or through
+// the variable that holds it:
+//
+//	b = 250 as uint8
+//	b +% 10              // 4, wrapped around modulo 256
+//	b +| 10              // 255, clamped to the largest uint8
+//	0 -| b               // 0, clamped to the smallest uint8
+//	(100 as int8) *| 2   // 127
+//
+// Both operands are truncated to integers and wrapped to the width first,
+// so b +% 300 adds 44. The result has the width too, so chains like
+// b +% 1 +% 1 stay in it. An operand without a width takes that of the
+// other one, and two different widths are a compile error; without any the
+// width is int64. A uint64 operand has to be below 2^63, which is more than
+// the 53 bits a number holds exactly.
+
+// intArithOps are the integer operators, by the arithmetic they do
+var intArithOps = map[string]string{
+	"+%": "+", "-%": "-", "*%": "*",
+	"+|": "+", "-|": "-", "*|": "*",
+}
+
+// intCastKinds are the integer types of `as`, by their kind for extendRax
+var intCastKinds = map[string]string{
+	"int8": "i8", "int16": "i16", "int32": "i32", "int64": "i64",
+	"uint8": "u8", "uint16": "u16", "uint32": "u32", "uint64": "u64",
+}
+
+// intTypeOf returns the integer type that expr is declared with, or ""
+func (fc *C67Compiler) intTypeOf(expr Expression) string {
+	switch e := expr.(type) {
+	case *CastExpr:
+		if _, ok := intCastKinds[e.Type]; ok {
+			return e.Type
+		}
+	case *IdentExpr:
+		return fc.intVarTypes[e.Name]
+	case *BinaryExpr:
+		if _, ok := intArithOps[e.Operator]; ok {
+			return fc.intArithType(e)
+		}
+	}
+	return ""
+}
+
+// intArithType returns the integer type that an integer operator works in
+func (fc *C67Compiler) intArithType(e *BinaryExpr) string {
+	left, right := fc.intTypeOf(e.Left), fc.intTypeOf(e.Right)
+	switch {
+	case left != "" && right != "" && left != right:
+		compilerError("the operands of %s are %s and %s; cast one of them to the other", e.Operator, left, right)
+	case left != "":
+		return left
+	case right != "":
+		return right
+	}
+	return "int64"
+}
+
+// compileIntArith compiles an integer operator on the left operand in xmm0
+// and the right one in xmm1
+func (fc *C67Compiler) compileIntArith(e *BinaryExpr) {
+	typ := fc.intArithType(e)
+	kind := intCastKinds[typ]
+	bits, _ := strconv.Atoi(kind[1:])
+	signed := kind[0] == 'i'
+	op := intArithOps[e.Operator]
+	saturate := e.Operator[1] == '|'
+
+	// rcx = right, rax = left, both wrapped to the width
+	fc.out.Cvttsd2si("rax", "xmm1")
+	fc.extendRax(kind)
+	fc.out.MovRegToReg("rcx", "rax")
+	fc.out.Cvttsd2si("rax", "xmm0")
+	fc.extendRax(kind)
+
+	switch {
+	case !saturate:
+		fc.emitIntOp(op)
+	case bits < 64:
+		// The exact result fits in 64 bits, so it only has to be clamped
+		fc.emitIntOp(op)
+		lo, hi := int64(0), int64(1)<<bits-1
+		if signed {
+			lo, hi = -(int64(1) << (bits - 1)), int64(1)<<(bits-1)-1
+		}
+		switch {
+		case signed:
+			fc.clampRax(hi, JumpLessOrEqual)
+			fc.clampRax(lo, JumpGreaterOrEqual)
+		case op == "-":
+			fc.clampRax(lo, JumpGreaterOrEqual)
+		default:
+			// A u32 product may not fit in an int64, but it fits in a uint64
+			fc.clampRax(hi, JumpBelowOrEqual)
+		}
+	case signed:
+		// On overflow the sign of the exact result is the opposite of the
+		// wrapped one for + and -, and the sign of left ^ right for *
+		if op == "*" {
+			fc.out.MovRegToReg("rdx", "rax")
+			fc.out.XorRegWithReg("rdx", "rcx")
+			fc.out.ImulRegWithReg("rax", "rcx")
+			inRange := fc.forwardJump(JumpNoOverflow)
+			fc.out.Emit([]byte{0x48, 0xc1, 0xfa, 0x3f}) // sar rdx, 63
+			fc.out.MovImmToReg("rax", strconv.FormatInt(1<<63-1, 10))
+			fc.out.XorRegWithReg("rax", "rdx")
+			fc.landForwardJump(inRange)
+			break
+		}
+		fc.emitIntOp(op)
+		inRange := fc.forwardJump(JumpNoOverflow)
+		fc.out.Emit([]byte{0x48, 0xc1, 0xf8, 0x3f}) // sar rax, 63
+		fc.out.MovImmToReg("rcx", strconv.FormatInt(-1<<63, 10))
+		fc.out.XorRegWithReg("rax", "rcx")
+		fc.landForwardJump(inRange)
+	default:
+		// The carry is set when a uint64 result does not fit
+		limit := "-1"
+		switch op {
+		case "+":
+			fc.out.AddRegToReg("rax", "rcx")
+		case "-":
+			fc.out.SubRegFromReg("rax", "rcx")
+			limit = "0"
+		case "*":
+			fc.out.Emit([]byte{0x48, 0xf7, 0xe1}) // mul rcx (rdx:rax = rax * rcx)
+		}
+		inRange := fc.forwardJump(JumpAboveOrEqual)
+		fc.out.MovImmToReg("rax", limit)
+		fc.landForwardJump(inRange)
+	}
+	fc.convertCReturnValue(typ + "_t") // int8_t and so on
+}
+
+// emitIntOp emits rax = rax op rcx, wrapping around at 64 bits
+func (fc *C67Compiler) emitIntOp(op string) {
+	switch op {
+	case "+":
+		fc.out.AddRegToReg("rax", "rcx")
+	case "-":
+		fc.out.SubRegFromReg("rax", "rcx")
+	case "*":
+		fc.out.ImulRegWithReg("rax", "rcx")
+	}
+}
+
+// clampRax sets rax to limit unless comparing rax with it meets cond
+func (fc *C67Compiler) clampRax(limit int64, cond JumpCondition) {
+	fc.out.MovImmToReg("rcx", strconv.FormatInt(limit, 10))
+	fc.out.CmpRegToReg("rax", "rcx")
+	inRange := fc.forwardJump(cond)
+	fc.out.MovRegToReg("rax", "rcx")
+	fc.landForwardJump(inRange)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestIntegerArithmetic tests that +% wraps and +| clamps in the width of
+// the operands
+func TestIntegerArithmetic(t *testing.T) {
+	source := `b = 250 as uint8
+println(b +% 10)
+println(b +| 10)
+println(0 -| b)
+println(b +% 300)
+println((100 as int8) *% 2)
+println((-100 as int8) -| 100)
+c := 65535 as uint16
+c <- c +% 1
+println(c)
+println((70000 as uint32) *| 70000)
+println((2000000000 as int32) +% 2000000000)
+println((3000000000 as int64) *| -4000000000)
+println((5 as uint64) -| 7)
+println(7 +% 8)
+`
+	want := "4\n255\n0\n38\n-56\n-128\n0\n4294967295\n-294967296\n-9223372036854776000\n0\n15\n"
+	if result := compileAndRun(t, source); result != want {
+		t.Errorf("Expected %q, got %q", want, result)
+	}
+}
+
+// TestIntegerArithmeticWidths tests that operands of different widths are
+// rejected
+func TestIntegerArithmeticWidths(t *testing.T) {
+	_, err := compileTestCodeAllowError(t, "a = 1 as uint8\nb = 2 as int16\nprintln(a +% b)\n")
+	if err == nil || !strings.Contains(err.Error(), "uint8 and int16") {
+		t.Errorf("Expected an error for uint8 +%% int16, got %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+// Completion: 100% - Symbols (:name) and intern(s)
+package main
+
+import "strconv"
+
+// intern.go - Interned symbols and hash(s)
+//
+// A symbol literal :name is the key that the identifier name already stands
+// for in {name: value} and obj.name: hashStringKey(name), an integer in
+// 2^52..2^53-1. Comparing two symbols is a single number compare, and a
+// symbol indexes a map exactly as the field name does, so m[s] with
+// s = :name reads the same entry as m.name.
+//
+// intern(s) and hash(s) turn a runtime string into that key by running the
+// same 64-bit FNV-1a hash over the UTF-8 bytes of the string, so
+// intern("name") == :name and m[hash(prefix + "name")] reads m.name. Both
+// are folded at compile time for string literals.
+
+// symbolValue returns the number a symbol for name evaluates to
+func symbolValue(name string) float64 {
+	return float64(hashStringKey(name))
+}
+
+// compileInternCall compiles intern(s) and hash(s)
+func (fc *C67Compiler) compileInternCall(call *CallExpr) {
+	if len(call.Args) != 1 {
+		compilerError("%s() requires exactly 1 argument", call.Function)
+	}
+	if str, ok := call.Args[0].(*StringExpr); ok {
+		fc.compileExpression(&NumberExpr{Value: symbolValue(str.Value)})
+		return
+	}
+	fc.compileExpression(call.Args[0])
+	fc.out.MovqXmmToReg("rdi", "xmm0")
+	fc.usesIntern = true
+	fc.out.SubImmFromReg("rsp", StackSlotSize)
+	fc.out.CallSymbol("_c67_intern")
+	fc.out.AddImmToReg("rsp", StackSlotSize)
+}
+
+// emitInternMixByte feeds one UTF-8 byte of the code point in r9 into the
+// hash in rax: the code point shifted right by shift, masked to its low six
+// bits for continuation bytes, with prefix or'ed in. Clobbers r10.
+func (fc *C67Compiler) emitInternMixByte(shift int64, continuation bool, prefix int32) {
+	fc.out.MovRegToReg("r10", "r9")
+	if shift > 0 {
+		fc.out.ShrRegByImm("r10", shift)
+	}
+	if continuation {
+		fc.out.AndRegWithImm("r10", 0x3F)
+	}
+	if prefix != 0 {
+		fc.out.OrRegWithImm("r10", prefix)
+	}
+	fc.out.XorRegWithReg("rax", "r10")
+	fc.out.ImulRegWithReg("rax", "r11")
+}
+
+// generateInternHelper emits _c67_intern(rdi=string) -> xmm0, the key for
+// the string, matching hashStringKey at compile time
+func (fc *C67Compiler) generateInternHelper() {
+	fc.eb.MarkFunction("_c67_intern")
+
+	fc.out.MovImmToReg("rax", "14695981039346656037") // FNV-1a 64-bit offset basis
+	fc.out.MovImmToReg("r11", "1099511628211")        // FNV-1a 64-bit prime
+	fc.out.TestRegReg("rdi", "rdi")
+	empty := fc.forwardJump(JumpEqual)
+
+	fc.out.MovMemToXmm("xmm0", "rdi", 0)
+	fc.out.Cvttsd2si("rcx", "xmm0")  // rcx = count
+	fc.out.XorRegWithReg("r8", "r8") // r8 = index
+	loopStart := fc.eb.text.Len()
+	fc.out.CmpRegToReg("r8", "rcx")
+	done := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovRegToReg("rdx", "r8")
+	fc.out.ShlImmReg("rdx", 4)
+	fc.out.AddRegToReg("rdx", "rdi")
+	fc.out.MovMemToXmm("xmm0", "rdx", 16)
+	fc.out.Cvttsd2si("r9", "xmm0") // r9 = code point
+
+	// Encode the code point as UTF-8, one to four bytes
+	var next []int
+	fc.out.CmpRegToImm("r9", 0x80)
+	twoBytes := fc.forwardJump(JumpGreaterOrEqual)
+	fc.emitInternMixByte(0, false, 0)
+	next = append(next, fc.forwardJumpAlways())
+
+	fc.landForwardJump(twoBytes)
+	fc.out.CmpRegToImm("r9", 0x800)
+	threeBytes := fc.forwardJump(JumpGreaterOrEqual)
+	fc.emitInternMixByte(6, false, 0xC0)
+	fc.emitInternMixByte(0, true, 0x80)
+	next = append(next, fc.forwardJumpAlways())
+
+	fc.landForwardJump(threeBytes)
+	fc.out.CmpRegToImm("r9", 0x10000)
+	fourBytes := fc.forwardJump(JumpGreaterOrEqual)
+	fc.emitInternMixByte(12, false, 0xE0)
+	fc.emitInternMixByte(6, true, 0x80)
+	fc.emitInternMixByte(0, true, 0x80)
+	next = append(next, fc.forwardJumpAlways())
+
+	fc.landForwardJump(fourBytes)
+	fc.emitInternMixByte(18, false, 0xF0)
+	fc.emitInternMixByte(12, true, 0x80)
+	fc.emitInternMixByte(6, true, 0x80)
+	fc.emitInternMixByte(0, true, 0x80)
+
+	for _, pos := range next {
+		fc.landForwardJump(pos)
+	}
+	fc.out.IncReg("r8")
+	fc.out.JumpUnconditional(int32(loopStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(done)
+	fc.landForwardJump(empty)
+
+	fc.emitSymbolKeyFold()
+	fc.out.Cvtsi2sd("xmm0", "rax")
+	fc.out.Ret()
+}
+
+// emitSymbolKeyFold turns the 64-bit hash in rax into a key the same way
+// hashStringKey does: 52 bits, with bit 52 set. Clobbers rcx.
+func (fc *C67Compiler) emitSymbolKeyFold() {
+	fc.out.MovImmToReg("rcx", strconv.FormatUint(symbolKeyMask, 10))
+	fc.out.AndRegWithReg("rax", "rcx")
+	fc.out.MovImmToReg("rcx", strconv.FormatUint(symbolKeyBit, 10))
+	fc.out.OrRegWithReg("rax", "rcx")
+}
@@ -30,6 +30,8 @@ const (
 	JumpBelowOrEqual                        // JBE/JNA - below or equal (unsigned)
 	JumpParity                              // JP - parity/NaN
 	JumpNotParity                           // JNP - not parity/not NaN
+	JumpOverflow                            // JO - signed overflow
+	JumpNoOverflow                          // JNO - no signed overflow
 )
 
 // JumpConditional generates a conditional jump instruction
@@ -101,6 +103,12 @@ func (o *Out) jmpX86Conditional(condition JumpCondition, offset int32) {
 	case JumpNotParity:
 		opcode = 0x8B
 		name = "jnp"
+	case JumpOverflow:
+		opcode = 0x80
+		name = "jo"
+	case JumpNoOverflow:
+		opcode = 0x81
+		name = "jno"
 	default:
 		return
 	}
@@ -0,0 +1,44 @@
+// Completion: 100% - Jump range checks and branch relaxation
+package main
+
+// jumprange.go - Jumps that reach their targets
+//
+// Every jump encodes its target as a signed offset of a fixed width, and
+// each form reaches only so far:
+//
+//	x86-64   Jcc/JMP rel8 ±128 B, Jcc/JMP/CALL rel32 ±2 GB
+//	ARM64    B.cond, CBZ/CBNZ ±1 MB, B/BL ±128 MB
+//	RISC-V   BEQ/BNE/BLT/... ±4 KB, JAL ±1 MB, AUIPC+JALR ±2 GB
+//
+// A jump whose target is known when it is emitted is relaxed to a longer
+// form when the short one does not reach: a conditional branch becomes the
+// inverse branch over an unconditional one (B.!cond +8; B target on ARM64,
+// B!cond +8; JAL target on RISC-V), and a RISC-V JAL becomes AUIPC+JALR.
+//
+// A forward jump is emitted before its target is known and patched once it
+// is, when the code in between has been written and the jump can no longer
+// grow. The patching checks that the offset fits the form that was emitted
+// and stops with an error if it does not, instead of writing an offset that
+// is cut to the width of the field and jumps somewhere else. So do the calls
+// and PC-relative addresses that are patched when the executable is laid
+// out (PatchCallSites, PatchPCRelocations and the PLT calls). Forward jumps
+// on x86-64 use rel32, which always reaches, except in a few hand-written
+// helpers that use rel8 over a handful of instructions.
+
+// checkReach stops compilation when the instruction described by what, at
+// text position pos, cannot encode distance in units of scale bytes in a
+// signed field of bits bits
+func checkReach(what string, pos int, distance int64, bits uint, scale int64) {
+	if distance%scale == 0 && distance/scale >= -(1<<(bits-1)) && distance/scale < 1<<(bits-1) {
+		return
+	}
+	reach := (int64(1) << (bits - 1)) * scale
+	compilerError("internal error: %s at text offset %#x is %d bytes away, out of its range of ±%d bytes",
+		what, pos, distance, reach)
+}
+
+// isShortJumpOpcode reports whether an x86-64 opcode is a jump with a rel8
+// offset: Jcc rel8 (0x70-0x7f) or JMP rel8 (0xeb)
+func isShortJumpOpcode(op byte) bool {
+	return op >= 0x70 && op <= 0x7f || op == 0xeb
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestVariadicSkipJumps tests that the jumps over the list that a variadic
+// lambda builds reach, which are longer than a short jump
+func TestVariadicSkipJumps(t *testing.T) {
+	source := `sum = (first, rest...) -> {
+    total := first
+    @ item in rest {
+        total <- total + item
+    }
+    total
+}
+main = {
+    println(sum(1, 2, 3))
+    println(sum(1))
+}
+`
+	if output := compileAndRun(t, source); output != "6\n1\n" {
+		t.Errorf("Expected 6 and 1, got %q", output)
+	}
+}
+
+// TestARM64BranchRelaxation tests that conditional branches beyond ±1MB
+// become the inverse branch over a B
+func TestARM64BranchRelaxation(t *testing.T) {
+	eb, _ := New("aarch64")
+	out := NewARM64CodeGen(eb, nil).out
+
+	out.BranchCond("eq", 16)
+	out.BranchCond("eq", 4<<20)
+	out.CompareAndBranchZero64("x1", -(2 << 20))
+	back := int32(-(2 << 20) - 4)
+	got := riscvWords(eb.text.Bytes())
+	want := []uint32{
+		0x54000080,                             // b.eq +16
+		0x54000041,                             // b.ne +8
+		0x14000000 | ((4<<20)-4)>>2&0x3ffffff,  // b target
+		0xb5000041,                             // cbnz x1, +8
+		0x14000000 | uint32(back>>2)&0x3ffffff, // b target
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d instructions, got %08x", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Instruction %d: expected %08x, got %08x", i, want[i], got[i])
+		}
+	}
+}
+
+// TestRiscvBranchRelaxation tests that branches beyond ±4KB become the
+// inverse branch over a JAL, and a JAL beyond ±1MB AUIPC+JALR
+func TestRiscvBranchRelaxation(t *testing.T) {
+	eb, _ := New("riscv64")
+	out := NewRiscvCodeGen(eb).out
+
+	out.BranchEqual("a0", "a1", 8192)
+	out.JumpAndLink("ra", 3<<20)
+	got := riscvWords(eb.text.Bytes())
+	want := []uint32{
+		out.encodeBType(0x63, 0x1, 10, 11, 8), // bne a0, a1, +8
+		out.encodeJType(0x6f, 0, 8188),        // j target
+		out.encodeUType(0x17, 1, 3<<20),       // auipc ra, 0x300
+		out.encodeIType(0x67, 0x0, 1, 1, 0),   // jalr ra, 0(ra)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d instructions, got %08x", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Instruction %d: expected %08x, got %08x", i, want[i], got[i])
+		}
+	}
+}
+
+// TestJumpOutOfRange tests that a patch that does not fit its jump stops
+// compilation instead of cutting the offset
+func TestJumpOutOfRange(t *testing.T) {
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(r.(error).Error(), "out of its range of ±1048576 bytes") {
+			t.Errorf("Expected an out of range error, got %v", r)
+		}
+	}()
+	eb, _ := New("aarch64")
+	acg := NewARM64CodeGen(eb, nil)
+	acg.out.BranchCond("ne", 0)
+	acg.patchJumpOffset(0, 2<<20)
+}
+
+// TestDisplacementOutOfRange tests that a PC-relative address or call that
+// does not reach its symbol stops compilation, naming the symbol
+func TestDisplacementOutOfRange(t *testing.T) {
+	for _, arch := range []string{"x86_64", "aarch64", "riscv64"} {
+		t.Run(arch, func(t *testing.T) {
+			defer func() {
+				r := recover()
+				if r == nil || !strings.Contains(r.(error).Error(), "far_away") {
+					t.Errorf("Expected an out of range error for far_away, got %v", r)
+				}
+			}()
+			eb, _ := New(arch)
+			eb.text.Write(make([]byte, 16))
+			eb.consts["far_away"] = &Const{addr: 0x400000 + 8<<30}
+			eb.pcRelocations = append(eb.pcRelocations, PCRelocation{offset: 4, symbolName: "far_away"})
+			eb.PatchPCRelocations(0x400000, 0, 0)
+		})
+	}
+}
@@ -0,0 +1,199 @@
+// Completion: 100% - Compiler stages written to disk with --keep-temps
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// keeptemps.go - What the compiler made of a program, from --keep-temps
+//
+//	c67 --keep-temps app.c67 -o app   # also writes app.temps/
+//
+// Each stage of the compilation goes to a file of its own in
+// <output>.temps, with the same names every time, so that two builds can
+// be compared with diff:
+//
+//	1-tokens.txt         the tokens of the input file: line:column type value
+//	2-ast.json           the AST of the input file, before optimization
+//	3-optimized-ast.json the AST that is compiled, with siblings, imports and
+//	                     dependencies merged in and optimized
+//	4-text.bin           the machine code, as written to the executable
+//	5-rodata.bin         the read-only data
+//	6-data.bin           the writable data
+//	7-relocations.txt    the PC-relative references and calls that were
+//	                     patched in the machine code, by offset
+//
+// Nodes in the JSON files have their Go type as "node", and fields with
+// zero values are left out. Token types are the numbers of the TOKEN_
+// constants in lexer.go.
+
+// keepTempsDir returns where the stages of the executable at outputPath go
+func keepTempsDir(outputPath string) string {
+	return outputPath + ".temps"
+}
+
+// keepTemps writes the stages before code generation
+func keepTemps(outputPath, inputPath string, source []byte, program *Program) error {
+	dir := keepTempsDir(outputPath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	var tokens bytes.Buffer
+	lexer := NewLexer(string(source))
+	for token := lexer.NextToken(); token.Type != TOKEN_EOF; token = lexer.NextToken() {
+		fmt.Fprintf(&tokens, "%d:%d %d %q\n", token.Line, token.Column, token.Type, token.Value)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "1-tokens.txt"), tokens.Bytes(), 0o644); err != nil {
+		return err
+	}
+
+	usedFiles = newUseState() // so the used files are included again
+	parser := NewParserWithFilename(string(source), inputPath)
+	parser.unoptimized = true
+	if err := writeASTJSON(filepath.Join(dir, "2-ast.json"), parser.ParseProgram()); err != nil {
+		return err
+	}
+	return writeASTJSON(filepath.Join(dir, "3-optimized-ast.json"), program)
+}
+
+// keepTempsSections writes the sections and relocations after code generation
+func keepTempsSections(outputPath string, eb *ExecutableBuilder) error {
+	dir := keepTempsDir(outputPath)
+	for name, data := range map[string][]byte{
+		"4-text.bin":   eb.text.Bytes(),
+		"5-rodata.bin": eb.rodata.Bytes(),
+		"6-data.bin":   eb.data.Bytes(),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			return err
+		}
+	}
+
+	var lines []string
+	for _, reloc := range eb.pcRelocations {
+		lines = append(lines, fmt.Sprintf("%08x pcrel %s", reloc.offset, reloc.symbolName))
+	}
+	for _, patch := range eb.callPatches {
+		lines = append(lines, fmt.Sprintf("%08x call  %s", patch.position, patch.targetName))
+	}
+	sort.Strings(lines)
+	text := strings.Join(lines, "\n")
+	if text != "" {
+		text += "\n"
+	}
+	return os.WriteFile(filepath.Join(dir, "7-relocations.txt"), []byte(text), 0o644)
+}
+
+// writeASTJSON writes program as indented JSON to path
+func writeASTJSON(path string, program *Program) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false) // keep < and > of operators readable
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(astJSON(reflect.ValueOf(program), map[uintptr]bool{})); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+// marshalJSON is json.Marshal without escaping < and >
+func marshalJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// jsonObject is a JSON object that keeps its fields in order
+type jsonObject []jsonField
+
+type jsonField struct {
+	name  string
+	value any
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := marshalJSON(field.name)
+		value, err := marshalJSON(field.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// astJSON turns an AST value into something encoding/json can write.
+// Pointers that are already being written, further up, become "(cycle)".
+func astJSON(v reflect.Value, active map[uintptr]bool) any {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return astJSON(v.Elem(), active)
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		if active[v.Pointer()] {
+			return "(cycle)"
+		}
+		active[v.Pointer()] = true
+		defer delete(active, v.Pointer())
+		return astJSON(v.Elem(), active)
+	case reflect.Struct:
+		object := jsonObject{{"node", v.Type().Name()}}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() || v.Field(i).IsZero() {
+				continue
+			}
+			object = append(object, jsonField{field.Name, astJSON(v.Field(i), active)})
+		}
+		return object
+	case reflect.Slice, reflect.Array:
+		list := make([]any, v.Len())
+		for i := range list {
+			list[i] = astJSON(v.Index(i), active)
+		}
+		return list
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		object := jsonObject{}
+		for _, key := range keys {
+			object = append(object, jsonField{fmt.Sprint(key), astJSON(v.MapIndex(key), active)})
+		}
+		return object
+	case reflect.Float32, reflect.Float64:
+		if f := v.Float(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Sprint(f) // not a JSON number
+		}
+		return v.Float()
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return nil
+	}
+	return v.Interface()
+}
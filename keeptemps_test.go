@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestKeepTemps tests that --keep-temps writes every stage, and that the
+// machine code is what went into the executable
+func TestKeepTemps(t *testing.T) {
+	defer func() { KeepTempsFlag = false }()
+	KeepTempsFlag = true
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "app.c67")
+	if err := os.WriteFile(src, []byte("square = x -> x * x\nprintln(square(2 + 3))\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "app")
+	if err := CompileC67(src, exe, Platform{Arch: ArchX86_64, OS: OSLinux}); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	temps := keepTempsDir(exe)
+	read := func(name string) []byte {
+		data, err := os.ReadFile(filepath.Join(temps, name))
+		if err != nil {
+			t.Fatalf("Expected %s: %v", name, err)
+		}
+		return data
+	}
+	if tokens := string(read("1-tokens.txt")); !strings.HasPrefix(tokens, "1:1 1 \"square\"\n") {
+		t.Errorf("Expected the tokens to start with square, got %q", tokens)
+	}
+	for _, name := range []string{"2-ast.json", "3-optimized-ast.json"} {
+		var ast struct {
+			Node       string            `json:"node"`
+			Statements []json.RawMessage `json:"Statements"`
+		}
+		if err := json.Unmarshal(read(name), &ast); err != nil || ast.Node != "Program" || len(ast.Statements) != 2 {
+			t.Errorf("Expected a Program with 2 statements in %s, got %+v (%v)", name, ast, err)
+		}
+	}
+	// 2 + 3 is folded by the optimizer
+	if !bytes.Contains(read("2-ast.json"), []byte(`"Operator": "+"`)) || bytes.Contains(read("3-optimized-ast.json"), []byte(`"Operator": "+"`)) {
+		t.Errorf("Expected 2 + 3 before optimization only")
+	}
+	executable, _ := os.ReadFile(exe)
+	if text := read("4-text.bin"); len(text) == 0 || !bytes.Contains(executable, text) {
+		t.Errorf("Expected the machine code of the executable in 4-text.bin")
+	}
+	if relocations := string(read("7-relocations.txt")); !strings.Contains(relocations, " pcrel square\n") {
+		t.Errorf("Expected the address of square in the relocations, got %q", relocations)
+	}
+	read("5-rodata.bin")
+	read("6-data.bin")
+}
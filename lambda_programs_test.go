@@ -4,6 +4,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -47,6 +48,87 @@ println(factorial(5, 1))
 `,
 			expected: "120\n",
 		},
+		{
+			name: "pattern_lambda_jump_table",
+			source: `op = (0) -> 100, (1) -> 101, (2) -> 102, (3) -> 103, (5) -> 105, (n) -> n * 2
+println(op(0))
+println(op(3))
+println(op(5))
+println(op(4))
+println(op(2.5))
+println(op(-1))
+`,
+			expected: "100\n103\n105\n8\n5\n-2\n",
+		},
+		{
+			name: "pattern_lambda_binary_search",
+			source: `sparse = (1) -> 10, (100) -> 20, (7) -> 30, (0.5) -> 40, (1000000) -> 50, (42) -> 60, (n) -> n
+println(sparse(1))
+println(sparse(100))
+println(sparse(0.5))
+println(sparse(1000000))
+println(sparse(42))
+println(sparse(43))
+`,
+			expected: "10\n20\n40\n50\n60\n43\n",
+		},
+		{
+			name: "pattern_lambda_repeated_literals",
+			source: `pair = (1, 0) -> 1, (1, n) -> 2, (2, 5) -> 3, (3, z) -> 4, (4, x) -> x, (a, b) -> a * b
+println(pair(1, 0))
+println(pair(1, 9))
+println(pair(2, 5))
+println(pair(2, 6))
+println(pair(4, 7))
+println(pair(9, 9))
+`,
+			expected: "1\n2\n3\n12\n7\n81\n",
+		},
+		{
+			name: "pattern_lambda_nan_matches_no_literal",
+			source: `f = (2) -> 1, (3) -> 2, (0) -> 3, (1) -> 4, (x) -> 99
+g = (1) -> 10, (x) -> 99
+println(f(sqrt(-1)))
+println(g(sqrt(-1)))
+`,
+			expected: "99\n99\n",
+		},
+		{
+			name: "multi_lambda_arity_dispatch",
+			source: `k = 1
+area = (r) -> 3 * r * r, (w, h) -> w * h
+g = x -> x + k, (x, y) -> x * y, (x, y, z) -> x + y + z
+println(area(2))
+println(area(3, 4))
+println(g(1))
+println(g(2, 3))
+println(g(1, 2, 3))
+`,
+			expected: "12\n12\n2\n6\n6\n",
+		},
+		{
+			name: "multi_lambda_patterns",
+			source: `fact = (0) -> 1, (n) -> n * fact(n - 1), (n, acc) -> acc + 10
+h = x -> x * 2, (0, y) -> y, (a, b) -> h(a) + h(b)
+println(fact(5))
+println(fact(5, 9))
+println(h(0, 7))
+println(h(2, 3))
+`,
+			expected: "120\n19\n7\n10\n",
+		},
+		{
+			name: "lambda_eight_register_args",
+			source: `sum8 = (a, b, c, d, e, f, g, h) -> a + 2 * b + 3 * c + 4 * d + 5 * e + 6 * f + 7 * g + 8 * h
+sq = x -> x * x
+k = 3
+println(sum8(1, 2, 3, 4, 5, 6, 7, 8))
+println(sum8(sq(2), k, sq(k), 1.5, sq(1), k, 0, sq(3)))
+two = (a, b) -> a * 10 - b
+println(two(sq(k), two(k, 1)))
+`,
+			expected: "204\n138\n61\n",
+		},
 	}
 
 	for _, tt := range tests {
@@ -56,6 +138,33 @@ println(factorial(5, 1))
 	}
 }
 
+// TestMultiLambdaClauseErrors tests that clauses which can never be selected are rejected
+func TestMultiLambdaClauseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		errMsg string
+	}{
+		{"duplicate_arity", "f = x -> x, y -> y + 1\nprintln(f(1))\n", "duplicate clause"},
+		{"shadowed_literal", "f = (n) -> 1, (0) -> 2\nprintln(f(1))\n", "unreachable clause"},
+		{"shadowed_pair", "f = (0, b) -> 1, (a, 5) -> 2, (0, 5) -> 3\nprintln(f(1, 2))\n", "unreachable clause"},
+		{"missing_arity", "f = x -> x, (x, y) -> y\nprintln(f(1, 2, 3))\n", "no clause taking 3"},
+		{"used_as_value", "f = x -> x, (x, y) -> y\ng = f\nprintln(g(1))\n", "can only be called directly"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileTestCodeAllowError(t, tt.source)
+			if err == nil {
+				t.Fatalf("expected an error containing %q", tt.errMsg)
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected an error containing %q, got: %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
 // TestExistingLambdaPrograms runs existing lambda test programs
 func TestExistingLambdaPrograms(t *testing.T) {
 	tests := []string{
@@ -111,3 +220,23 @@ func TestExistingLambdaPrograms(t *testing.T) {
 		})
 	}
 }
+
+func TestGameloop(t *testing.T) {
+	source := `ticks := 0
+frames := 0
+update = dt -> {
+    ticks <- ticks + 1
+    ticks < 5
+}
+render = alpha -> {
+    frames <- frames + 1
+}
+main = {
+    println(gameloop(update, render, 1000))
+    println(ticks)
+    println(frames > 0)
+    println(gameloop(update, render, 0).error)
+}
+`
+	testInlineC67(t, "gameloop", source, "5\n5\n1\narg\n")
+}
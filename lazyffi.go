@@ -0,0 +1,125 @@
+// Completion: 100% - --lazy-ffi inline caches for namespaced C calls
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// lazyffi.go - Lazy FFI with per-callsite inline caches
+//
+// Normally an imported C library is a DT_NEEDED dependency and every
+// alias.Function(...) call goes through the PLT. With --lazy-ffi on Linux
+// x86-64, the library is not linked. Each call site instead gets its own
+// writable cache slot, filled by dlopen and dlsym the first time the call
+// runs:
+//
+//	mov rax, [rip + slot]
+//	test rax, rax
+//	jnz cached
+//	call _c67_ffi_resolve        ; slot, library name, symbol name
+//	cached:
+//	...marshal the arguments...
+//	call qword [rip + slot]
+//
+// After the first call a call site costs one load and a predictable branch,
+// and calls straight into the library without the PLT stub and GOT load.
+// A library or symbol that cannot be found stops the program with the
+// dlerror() message. c.function(...) calls and calls from runtime helpers
+// are linked as usual.
+
+// lazyFFIErrorPrefix starts the message printed when a symbol cannot be resolved
+const lazyFFIErrorPrefix = "ERROR: --lazy-ffi: "
+
+// lazyFFISlot returns the cache slot for a call to funcName in the imported
+// library libName, and emits the check that fills it, or returns "" if the
+// call is linked normally
+func (fc *C67Compiler) lazyFFISlot(libName, funcName string) string {
+	if !LazyFFIFlag || libName == "" || fc.eb.target.OS() != OSLinux {
+		return ""
+	}
+	for _, name := range []string{"dlopen", "dlsym", "dlerror", "strlen"} {
+		fc.trackFunctionCall(name)
+	}
+	fc.usesLazyFFI = true
+	fc.eb.Define("_c67_ffi_error_prefix", lazyFFIErrorPrefix+"\x00")
+	fc.eb.Define("_c67_ffi_error_newline", "\n\x00")
+
+	libLabel, ok := fc.lazyFFILibs[libName]
+	if !ok {
+		libLabel = fmt.Sprintf("_c67_ffi_lib_%d", len(fc.lazyFFILibs))
+		fc.eb.Define(libLabel, cLibrarySoName(libName)+"\x00")
+		fc.lazyFFILibs[libName] = libLabel
+	}
+	symLabel := "_c67_ffi_sym_" + funcName
+	fc.eb.Define(symLabel, funcName+"\x00")
+	slot := fmt.Sprintf("_c67_ffi_cache_%d", fc.lazyFFISlots)
+	fc.lazyFFISlots++
+	fc.eb.DefineWritable(slot, "\x00\x00\x00\x00\x00\x00\x00\x00")
+
+	fc.out.LeaSymbolToReg("rax", slot)
+	fc.out.MovMemToReg("rax", "rax", 0)
+	fc.out.TestRegReg("rax", "rax")
+	cached := fc.forwardJump(JumpNotEqual)
+	fc.out.LeaSymbolToReg("rdi", slot)
+	fc.out.LeaSymbolToReg("rsi", libLabel)
+	fc.out.LeaSymbolToReg("rdx", symLabel)
+	fc.out.CallSymbol("_c67_ffi_resolve")
+	fc.landForwardJump(cached)
+	return slot
+}
+
+// generateFFIResolve emits _c67_ffi_resolve(rdi=slot, rsi=library,
+// rdx=symbol), which stores the address of the symbol in the slot.
+// dlopen hands out the same handle for a library that is already loaded,
+// so call sites of one library share it.
+func (fc *C67Compiler) generateFFIResolve() {
+	fc.eb.MarkFunction("_c67_ffi_resolve")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.AndRegWithImm("rsp", -16)
+	fc.out.MovRegToReg("rbx", "rdi") // rbx = slot
+	fc.out.MovRegToReg("r12", "rdx") // r12 = symbol
+
+	fc.out.MovRegToReg("rdi", "rsi")
+	fc.out.MovImmToReg("rsi", "1") // RTLD_LAZY
+	fc.eb.GenerateCallInstruction("dlopen")
+	fc.out.TestRegReg("rax", "rax")
+	noLib := fc.forwardJump(JumpEqual)
+	fc.out.MovRegToReg("rdi", "rax")
+	fc.out.MovRegToReg("rsi", "r12")
+	fc.eb.GenerateCallInstruction("dlsym")
+	fc.out.TestRegReg("rax", "rax")
+	noSym := fc.forwardJump(JumpEqual)
+	fc.out.MovRegToMem("rax", "rbx", 0)
+	fc.out.LeaMemToReg("rsp", "rbp", -16)
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+
+	fc.landForwardJump(noLib)
+	fc.landForwardJump(noSym)
+	fc.eb.GenerateCallInstruction("dlerror")
+	fc.out.MovRegToReg("rbx", "rax") // rbx = message
+	fc.out.MovImmToReg("rdi", "2")   // stderr
+	fc.out.LeaSymbolToReg("rsi", "_c67_ffi_error_prefix")
+	fc.out.MovImmToReg("rdx", strconv.Itoa(len(lazyFFIErrorPrefix)))
+	fc.emitBufferedWrite()
+	fc.out.MovRegToReg("rdi", "rbx")
+	fc.eb.GenerateCallInstruction("strlen")
+	fc.out.MovRegToReg("rdx", "rax")
+	fc.out.MovRegToReg("rsi", "rbx")
+	fc.out.MovImmToReg("rdi", "2")
+	fc.emitBufferedWrite()
+	fc.out.MovImmToReg("rdi", "2")
+	fc.out.LeaSymbolToReg("rsi", "_c67_ffi_error_newline")
+	fc.out.MovImmToReg("rdx", "1")
+	fc.emitBufferedWrite()
+	fc.out.MovImmToReg("rdi", "1")
+	fc.out.MovImmToReg("rax", "231") // exit_group
+	fc.out.Syscall()
+}
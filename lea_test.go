@@ -174,16 +174,9 @@ func TestMovVsLea(t *testing.T) {
 	out1.MovInstruction("rax", "sym1")
 	mov := eb1.text.Bytes()
 
-	// Should contain MOV opcode (0xC7)
-	found := false
-	for _, b := range mov {
-		if b == 0xC7 {
-			found = true
-			break
-		}
-	}
-	if !found {
-		t.Error("Non-PIE should use MOV instruction (0xC7)")
+	// Should be MOV r32, imm32 (0xB8+r): the address fits in 32 bits
+	if len(mov) != 5 || mov[0] != 0xB8 {
+		t.Errorf("Non-PIE should use MOV instruction (0xB8), got % x", mov)
 	}
 
 	// PIE: should use LEA
@@ -198,7 +191,7 @@ func TestMovVsLea(t *testing.T) {
 	lea := eb2.text.Bytes()
 
 	// Should contain LEA opcode (0x8D)
-	found = false
+	found := false
 	for _, b := range lea {
 		if b == 0x8D {
 			found = true
@@ -0,0 +1,204 @@
+// Completion: 100% - Frameless code for leaf lambdas
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// leaf.go - Omitting the frame of leaf lambdas (--fomit-frame-pointer)
+//
+// Every lambda sets up a frame: it pushes rbp, reserves the stack for its
+// parameters and temporaries and saves rbx, even when all it does is
+//
+//	scale = (x, k) -> x * k + 1
+//
+// A leaf lambda, one whose body is arithmetic on its parameters and numbers,
+// calls nothing and needs no stack. With --fomit-frame-pointer its body is
+// compiled straight into xmm registers: the parameters stay in xmm0-xmm5
+// where the caller passed them, intermediate values use xmm6-xmm14, and the
+// function is the arithmetic followed by ret.
+//
+// Lambdas keep their frame in debug builds (DEBUG is set), so that a
+// debugger can walk the stack through rbp.
+
+// leafTempRegs are the registers for intermediate values of a leaf lambda;
+// xmm15 is the scratch register for constants
+var leafTempRegs = []string{"xmm6", "xmm7", "xmm8", "xmm9", "xmm10", "xmm11", "xmm12", "xmm13", "xmm14"}
+
+// canOmitFrame reports whether lambda can be compiled without a frame
+func (fc *C67Compiler) canOmitFrame(lambda *LambdaFunc) bool {
+	if !OmitFramePointerFlag || fc.debug || fc.eb.target.Arch() != ArchX86_64 {
+		return false
+	}
+	if lambda.VariadicParam != "" || len(lambda.CapturedVars) > 0 || len(lambda.Params) > 6 {
+		return false
+	}
+	params := make(map[string]int, len(lambda.Params))
+	for i, name := range lambda.Params {
+		params[name] = i
+	}
+	return leafRegsNeeded(lambda.Body, params) <= len(leafTempRegs)
+}
+
+// leafRegsNeeded returns how many temporary registers it takes to evaluate
+// expr, or more than there are if expr is not plain arithmetic
+func leafRegsNeeded(expr Expression, params map[string]int) int {
+	const unsupported = math.MaxInt32
+	switch e := expr.(type) {
+	case *NumberExpr:
+		return 1
+	case *IdentExpr:
+		if _, ok := params[e.Name]; ok {
+			return 0 // read in place
+		}
+	case *UnaryExpr:
+		if e.Operator == "-" {
+			return max(leafRegsNeeded(e.Operand, params), 1)
+		}
+	case *BinaryExpr:
+		switch e.Operator {
+		case "+", "-", "*", "/":
+			left := max(leafRegsNeeded(e.Left, params), 1)
+			right := leafRegsNeeded(e.Right, params)
+			if left >= unsupported || right >= unsupported {
+				return unsupported
+			}
+			return max(left, right+1)
+		}
+	case *FMAExpr:
+		if !e.IsNegMul {
+			a := max(leafRegsNeeded(e.A, params), 1)
+			b := leafRegsNeeded(e.B, params)
+			c := leafRegsNeeded(e.C, params)
+			if a >= unsupported || b >= unsupported || c >= unsupported {
+				return unsupported
+			}
+			return max(a, b+1, c+2)
+		}
+	case *BlockExpr:
+		if len(e.Statements) == 1 {
+			if stmt, ok := e.Statements[0].(*ExpressionStmt); ok {
+				return leafRegsNeeded(stmt.Expr, params)
+			}
+		}
+	}
+	return unsupported
+}
+
+// generateLeafLambda emits lambda, which canOmitFrame accepted, without a frame
+func (fc *C67Compiler) generateLeafLambda(lambda *LambdaFunc) {
+	params := make(map[string]int, len(lambda.Params))
+	for i, name := range lambda.Params {
+		params[name] = i
+	}
+	result := fc.compileLeafExpr(lambda.Body, params, 0)
+	if result != "xmm0" {
+		fc.out.MovXmmToXmm("xmm0", result)
+	}
+	fc.out.Ret()
+}
+
+// compileLeafExpr evaluates expr and returns the register that holds it.
+// Values that are computed go to leafTempRegs[next] or above.
+func (fc *C67Compiler) compileLeafExpr(expr Expression, params map[string]int, next int) string {
+	switch e := expr.(type) {
+	case *NumberExpr:
+		dst := leafTempRegs[next]
+		fc.out.MovImmToReg("rax", strconv.FormatUint(math.Float64bits(e.Value), 10))
+		fc.out.MovqRegToXmm(dst, "rax")
+		return dst
+	case *IdentExpr:
+		return fmt.Sprintf("xmm%d", params[e.Name])
+	case *UnaryExpr:
+		// Multiply by -1.0, like compileExpression
+		dst := fc.leafOperandToTemp(e.Operand, params, next)
+		fc.out.MovImmToReg("rax", strconv.FormatUint(math.Float64bits(-1.0), 10))
+		fc.out.MovqRegToXmm("xmm15", "rax")
+		fc.out.MulsdXmm(dst, "xmm15")
+		return dst
+	case *BinaryExpr:
+		dst := fc.leafOperandToTemp(e.Left, params, next)
+		src := fc.compileLeafExpr(e.Right, params, next+1)
+		switch e.Operator {
+		case "+":
+			fc.out.AddsdXmm(dst, src)
+		case "-":
+			fc.out.SubsdXmm(dst, src)
+		case "*":
+			fc.out.MulsdXmm(dst, src)
+		case "/":
+			fc.compileLeafDivide(dst, src)
+		}
+		return dst
+	case *FMAExpr:
+		dst := fc.leafOperandToTemp(e.A, params, next)
+		mul := fc.compileLeafExpr(e.B, params, next+1)
+		add := fc.compileLeafExpr(e.C, params, next+2)
+		fc.compileLeafFMA(dst, mul, add, e.IsSub)
+		return dst
+	case *BlockExpr:
+		return fc.compileLeafExpr(e.Statements[0].(*ExpressionStmt).Expr, params, next)
+	}
+	compilerError("INTERNAL ERROR: %T in a leaf lambda", expr)
+	return ""
+}
+
+// leafOperandToTemp evaluates expr into leafTempRegs[next], which the caller
+// may then overwrite
+func (fc *C67Compiler) leafOperandToTemp(expr Expression, params map[string]int, next int) string {
+	dst := leafTempRegs[next]
+	if reg := fc.compileLeafExpr(expr, params, next); reg != dst {
+		fc.out.MovXmmToXmm(dst, reg)
+	}
+	return dst
+}
+
+// compileLeafDivide divides dst by src, or sets dst to the division by
+// zero error when src is 0, like compileExpression
+func (fc *C67Compiler) compileLeafDivide(dst, src string) {
+	fc.out.XorpdXmm("xmm15", "xmm15")
+	fc.out.Ucomisd(src, "xmm15")
+	safe := fc.forwardJump(JumpNotEqual)
+	fc.out.Emit([]byte{0x48, 0xb8})                                     // mov rax, immediate64
+	fc.out.Emit([]byte{0x00, 0x30, 0x76, 0x64, 0x00, 0x00, 0xf8, 0x7f}) // NaN with "dv0\0"
+	fc.out.MovqRegToXmm(dst, "rax")
+	done := fc.forwardJumpAlways()
+	fc.landForwardJump(safe)
+	fc.out.DivsdXmm(dst, src)
+	fc.landForwardJump(done)
+}
+
+// compileLeafFMA sets dst to dst * mul + add (or - add), fused when the CPU
+// has FMA, like compileFMA
+func (fc *C67Compiler) compileLeafFMA(dst, mul, add string, isSub bool) {
+	var d, m, a uint8
+	fmt.Sscanf(dst, "xmm%d", &d)
+	fmt.Sscanf(mul, "xmm%d", &m)
+	fmt.Sscanf(add, "xmm%d", &a)
+
+	fc.out.LeaSymbolToReg("rax", "cpu_has_fma")
+	fc.out.Emit([]byte{0x0f, 0xb6, 0x00}) // movzx eax, byte [rax]
+	fc.out.Emit([]byte{0x85, 0xc0})       // test eax, eax
+	fallback := fc.forwardJump(JumpEqual)
+
+	// vfmadd132sd dst, add, mul (or vfmsub132sd): VEX.LIG.66.0F38.W1 99 /r
+	opcode := byte(0x99)
+	if isSub {
+		opcode = 0x9b
+	}
+	vex1 := 0xe2 ^ (d>>3&1)<<7 ^ (m>>3&1)<<5
+	vex2 := 0x81 | (^a&0xf)<<3
+	fc.out.Emit([]byte{0xc4, vex1, vex2, opcode, 0xc0 | (d&7)<<3 | m&7})
+	done := fc.forwardJumpAlways()
+
+	fc.landForwardJump(fallback)
+	fc.out.MulsdXmm(dst, mul)
+	if isSub {
+		fc.out.SubsdXmm(dst, add)
+	} else {
+		fc.out.AddsdXmm(dst, add)
+	}
+	fc.landForwardJump(done)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+// TestOmitFramePointer tests --fomit-frame-pointer, which compiles leaf
+// lambdas without a frame
+func TestOmitFramePointer(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("--fomit-frame-pointer only applies to x86-64")
+	}
+	defer func() { OmitFramePointerFlag = false }()
+	OmitFramePointerFlag = true
+
+	code := `scale = (x, k) -> x * k + 1
+half = (a) -> a * -0.5
+div = (a, b) -> a / b
+mix = (a, b, c) -> (a + 1) * (b - 2) / (c + (a * (b + (c - a / 4))))
+fused = (a, b, c) -> (a * b + c) * (c * a - b) + b * c
+deep = (a) -> 1 + (2 + (3 + (4 + (5 + (6 + (7 + (8 + (9 + (10 + a)))))))))
+println(scale(3, 4))
+println(half(7))
+println(div(1, 0) or! 99)
+println(div(1, 4))
+println(mix(2, 6, 1))
+println(scale(scale(1, 2), 3))
+println(fused(2, 3, 4))
+println(deep(1))
+`
+	output := compileAndRun(t, code)
+	if output != "13\n-3.5\n99\n0.25\n0.8571428571428571\n10\n62\n56\n" {
+		t.Errorf("Expected '13\\n-3.5\\n99\\n0.25\\n1\\n10\\n56\\n', got %q", output)
+	}
+}
+
+// TestLeafRegsNeeded tests which lambda bodies can be compiled without a frame
+func TestLeafRegsNeeded(t *testing.T) {
+	params := map[string]int{"a": 0, "b": 1}
+	a, b := &IdentExpr{Name: "a"}, &IdentExpr{Name: "b"}
+	one := &NumberExpr{Value: 1}
+
+	tests := []struct {
+		name string
+		expr Expression
+		want int
+	}{
+		{"param", a, 0},
+		{"number", one, 1},
+		{"sum", &BinaryExpr{Left: a, Operator: "+", Right: b}, 1},
+		{"nested right", &BinaryExpr{Left: a, Operator: "*", Right: &BinaryExpr{Left: b, Operator: "-", Right: one}}, 3},
+		{"negation", &UnaryExpr{Operator: "-", Operand: a}, 1},
+		{"fused", &FMAExpr{A: a, B: b, C: one}, 3},
+	}
+	for _, tt := range tests {
+		if got := leafRegsNeeded(tt.expr, params); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// Anything else keeps the frame
+	for _, expr := range []Expression{
+		&IdentExpr{Name: "global"},
+		&BinaryExpr{Left: a, Operator: "mod", Right: b},
+		&CallExpr{Function: "f", Args: []Expression{a}},
+	} {
+		if got := leafRegsNeeded(expr, params); got <= len(leafTempRegs) {
+			t.Errorf("%s: got %d, want the frame to be kept", expr.String(), got)
+		}
+	}
+}
@@ -2,6 +2,7 @@
 package main
 
 import (
+	"slices"
 	"strings"
 	"unicode"
 )
@@ -70,6 +71,8 @@ const (
 	TOKEN_INCREMENT       // ++
 	TOKEN_DECREMENT       // --
 	TOKEN_FMA             // *+ (fused multiply-add)
+	TOKEN_INT_ADD         // +% -% +| -| (wrapping and saturating integer + and -)
+	TOKEN_INT_MUL         // *% *| (wrapping and saturating integer *)
 	TOKEN_BANG            // ! (move operator - transfers ownership)
 	TOKEN_OR_BANG         // or! (error handling / railway-oriented programming)
 	TOKEN_AND_BANG        // and! (success handler)
@@ -125,6 +128,7 @@ const (
 	TOKEN_DOT      // . (for namespaced calls)
 	TOKEN_DOTDOT   // .. (inclusive range operator)
 	TOKEN_DOTDOTLT // ..< (exclusive range operator)
+	TOKEN_DOTDOTGT // ..> (exclusive descending range operator)
 	TOKEN_ELLIPSIS // ... (variadic parameter marker)
 	TOKEN_UNSAFE   // unsafe (architecture-specific code blocks)
 	TOKEN_SYSCALL  // syscall (system call in unsafe blocks)
@@ -163,6 +167,7 @@ type Token struct {
 	Value  string
 	Line   int
 	Column int // Column position (1-indexed) where the token starts
+	Length int // Length of the token in the source, in bytes
 }
 
 // isHexDigit checks if a byte is a valid hexadecimal digit
@@ -206,8 +211,11 @@ type Lexer struct {
 	input     string
 	pos       int
 	line      int
-	column    int // Current column (1-indexed)
-	lineStart int // Position where current line starts
+	column    int            // Current column (1-indexed)
+	lineStart int            // Position where current line starts
+	docs      map[int]string // "## text" doc comment lines, by line number
+	tokenPos  int            // Position where the current token starts
+	open      []Token        // Brackets that are not closed yet, innermost last
 }
 
 func NewLexer(input string) *Lexer {
@@ -237,6 +245,15 @@ func (l *Lexer) peek() byte {
 	return 0
 }
 
+// integerArithToken returns the token of a wrapping (+%) or saturating (+|)
+// operator
+func (l *Lexer) integerArithToken(op string, column int) Token {
+	if op[0] == '*' {
+		return Token{Type: TOKEN_INT_MUL, Value: op, Line: l.line, Column: column}
+	}
+	return Token{Type: TOKEN_INT_ADD, Value: op, Line: l.line, Column: column}
+}
+
 // peekAhead looks n characters ahead (0-indexed from current position)
 func (l *Lexer) peekAhead(n int) byte {
 	if l.pos+1+n < len(l.input) {
@@ -245,6 +262,12 @@ func (l *Lexer) peekAhead(n int) byte {
 	return 0
 }
 
+// isDunder reports whether the input at the current position is two
+// underscores and a letter, as in __add
+func (l *Lexer) isDunder() bool {
+	return l.input[l.pos] == '_' && l.peek() == '_' && unicode.IsLetter(rune(l.peekAhead(1)))
+}
+
 func (l *Lexer) advance() {
 	if l.pos < len(l.input) {
 		l.pos++
@@ -253,22 +276,83 @@ func (l *Lexer) advance() {
 
 // LexerState represents a saved lexer state for lookahead
 type LexerState struct {
-	pos  int
-	line int
+	pos       int
+	line      int
+	lineStart int
+	open      []Token
 }
 
 // save returns the current lexer state
 func (l *Lexer) save() LexerState {
-	return LexerState{pos: l.pos, line: l.line}
+	return LexerState{pos: l.pos, line: l.line, lineStart: l.lineStart, open: slices.Clone(l.open)}
+}
+
+// isDocComment reports whether a "##" doc comment starts at the current
+// position. Only whitespace may come before it on the line, so that ##xs,
+// the length of a length, is not taken for one.
+func (l *Lexer) isDocComment() bool {
+	if !strings.HasPrefix(l.input[l.pos:], "##") {
+		return false
+	}
+	if l.pos+2 < len(l.input) && l.input[l.pos+2] != ' ' && l.input[l.pos+2] != '\t' && l.input[l.pos+2] != '\n' && l.input[l.pos+2] != '\r' {
+		return false
+	}
+	return strings.TrimSpace(l.input[l.lineStart:l.pos]) == ""
+}
+
+// docAbove returns the doc comment on the lines right above line, joined
+// with newlines, or "" if there is none
+func (l *Lexer) docAbove(line int) string {
+	first := line
+	for {
+		if _, ok := l.docs[first-1]; !ok {
+			break
+		}
+		first--
+	}
+	lines := make([]string, 0, line-first)
+	for n := first; n < line; n++ {
+		lines = append(lines, l.docs[n])
+	}
+	return strings.Join(lines, "\n")
 }
 
 // restore restores a previously saved lexer state
 func (l *Lexer) restore(state LexerState) {
 	l.pos = state.pos
 	l.line = state.line
+	l.lineStart = state.lineStart
+	l.open = state.open
 }
 
+// NextToken returns the next token, with its length, and keeps track of
+// the brackets that are open for diagnostics
 func (l *Lexer) NextToken() Token {
+	tok := l.nextToken()
+	tok.Length = l.pos - l.tokenPos
+	switch tok.Type {
+	case TOKEN_LPAREN, TOKEN_LBRACE, TOKEN_LBRACKET:
+		l.open = append(l.open, tok)
+	case TOKEN_RPAREN, TOKEN_RBRACE, TOKEN_RBRACKET:
+		if n := len(l.open); n > 0 && closingBracket[l.open[n-1].Value] == tok.Value {
+			l.open = l.open[:n-1]
+		}
+	}
+	return tok
+}
+
+// closingBracket maps opening brackets to the ones that close them
+var closingBracket = map[string]string{"(": ")", "{": "}", "[": "]"}
+
+// unclosed returns the innermost bracket that is not closed yet, if any
+func (l *Lexer) unclosed() (Token, bool) {
+	if len(l.open) == 0 {
+		return Token{}, false
+	}
+	return l.open[len(l.open)-1], true
+}
+
+func (l *Lexer) nextToken() Token {
 	// Update column based on current position
 	l.column = l.pos - l.lineStart + 1
 
@@ -277,17 +361,31 @@ func (l *Lexer) NextToken() Token {
 		l.pos++
 	}
 
+	// Keep doc comments (## text on a line of its own) for the parser
+	if l.isDocComment() {
+		start := l.pos + 2
+		for l.pos < len(l.input) && l.input[l.pos] != '\n' {
+			l.pos++
+		}
+		if l.docs == nil {
+			l.docs = make(map[int]string)
+		}
+		l.docs[l.line] = strings.TrimSpace(l.input[start:l.pos])
+		return l.nextToken()
+	}
+
 	// Skip comments (lines starting with //)
 	if l.pos < len(l.input)-1 && l.input[l.pos] == '/' && l.input[l.pos+1] == '/' {
 		for l.pos < len(l.input) && l.input[l.pos] != '\n' {
 			l.pos++
 		}
 		// Recursively get the next token after the comment
-		return l.NextToken()
+		return l.nextToken()
 	}
 
 	// Record token start column
 	tokenColumn := l.pos - l.lineStart + 1
+	l.tokenPos = l.pos
 
 	if l.pos >= len(l.input) {
 		return Token{Type: TOKEN_EOF, Line: l.line, Column: tokenColumn}
@@ -317,7 +415,9 @@ func (l *Lexer) NextToken() Token {
 			}
 		}
 		value := l.input[start:l.pos]
-		l.pos++ // skip closing "
+		if l.pos < len(l.input) {
+			l.pos++ // skip closing "
+		}
 		// Process escape sequences like \n, \t, etc.
 		value = processEscapeSequences(value)
 		return Token{Type: TOKEN_STRING, Value: value, Line: l.line, Column: tokenColumn}
@@ -375,8 +475,9 @@ func (l *Lexer) NextToken() Token {
 		return Token{Type: TOKEN_NUMBER, Value: l.input[start:l.pos], Line: l.line, Column: tokenColumn}
 	}
 
-	// Identifier or keyword (cannot start with underscore or digit)
-	if unicode.IsLetter(rune(ch)) {
+	// Identifier or keyword (cannot start with underscore or digit), except
+	// for the __name keys of operator methods (see operators.go)
+	if unicode.IsLetter(rune(ch)) || l.isDunder() {
 		start := l.pos
 		for l.pos < len(l.input) && (unicode.IsLetter(rune(l.input[l.pos])) || unicode.IsDigit(rune(l.input[l.pos])) || l.input[l.pos] == '_') {
 			l.pos++
@@ -396,7 +497,9 @@ func (l *Lexer) NextToken() Token {
 				}
 			}
 			fstringValue := l.input[fstringStart:l.pos]
-			l.pos++ // skip closing "
+			if l.pos < len(l.input) {
+				l.pos++ // skip closing "
+			}
 			return Token{Type: TOKEN_FSTRING, Value: fstringValue, Line: l.line, Column: tokenColumn}
 		}
 
@@ -454,6 +557,10 @@ func (l *Lexer) NextToken() Token {
 		case "defer":
 			return Token{Type: TOKEN_DEFER, Value: value, Line: l.line, Column: tokenColumn}
 		case "max":
+			// max(...) is the builtin, max 100 the loop and recursion limit
+			if l.pos < len(l.input) && l.input[l.pos] == '(' {
+				return Token{Type: TOKEN_IDENT, Value: value, Line: l.line, Column: tokenColumn}
+			}
 			return Token{Type: TOKEN_MAX, Value: value, Line: l.line, Column: tokenColumn}
 		case "inf":
 			return Token{Type: TOKEN_INF, Value: value, Line: l.line, Column: tokenColumn}
@@ -500,6 +607,11 @@ func (l *Lexer) NextToken() Token {
 			l.pos++
 			return Token{Type: TOKEN_PLUS_EQUALS, Value: "+=", Line: l.line, Column: tokenColumn}
 		}
+		// Check for +% and +|
+		if l.pos < len(l.input) && (l.input[l.pos] == '%' || l.input[l.pos] == '|') {
+			l.pos++
+			return l.integerArithToken(l.input[l.pos-2:l.pos], tokenColumn)
+		}
 		return Token{Type: TOKEN_PLUS, Value: "+", Line: l.line, Column: tokenColumn}
 	case '-':
 		// Check for -> (lambda arrow, can be inferred in assignment context)
@@ -517,6 +629,11 @@ func (l *Lexer) NextToken() Token {
 			l.pos += 2
 			return Token{Type: TOKEN_MINUS_EQUALS, Value: "-=", Line: l.line, Column: tokenColumn}
 		}
+		// Check for -% and -|
+		if l.peek() == '%' || l.peek() == '|' {
+			l.pos += 2
+			return l.integerArithToken(l.input[l.pos-2:l.pos], tokenColumn)
+		}
 		// Always emit MINUS as separate token - let parser handle unary negation
 		l.pos++
 		return Token{Type: TOKEN_MINUS, Value: "-", Line: l.line, Column: tokenColumn}
@@ -542,6 +659,11 @@ func (l *Lexer) NextToken() Token {
 			l.pos++
 			return Token{Type: TOKEN_STAR_EQUALS, Value: "*=", Line: l.line, Column: tokenColumn}
 		}
+		// Check for *% and *|
+		if l.pos < len(l.input) && (l.input[l.pos] == '%' || l.input[l.pos] == '|') {
+			l.pos++
+			return l.integerArithToken(l.input[l.pos-2:l.pos], tokenColumn)
+		}
 		return Token{Type: TOKEN_STAR, Value: "*", Line: l.line, Column: tokenColumn}
 	case '/':
 		l.pos++
@@ -683,7 +805,7 @@ func (l *Lexer) NextToken() Token {
 		l.pos++
 		return Token{Type: TOKEN_SEMICOLON, Value: ";", Line: l.line, Column: tokenColumn}
 	case '.':
-		// Check for ... (variadic marker) or ..< (exclusive) or .. (inclusive)
+		// Check for ... (variadic marker), ..< (exclusive), ..> (descending) or .. (inclusive)
 		if l.pos+1 < len(l.input) && l.input[l.pos+1] == '.' {
 			if l.pos+2 < len(l.input) {
 				if l.input[l.pos+2] == '.' {
@@ -694,6 +816,10 @@ func (l *Lexer) NextToken() Token {
 					// ..<
 					l.pos += 3
 					return Token{Type: TOKEN_DOTDOTLT, Value: "..<", Line: l.line, Column: tokenColumn}
+				} else if l.input[l.pos+2] == '>' {
+					// ..>
+					l.pos += 3
+					return Token{Type: TOKEN_DOTDOTGT, Value: "..>", Line: l.line, Column: tokenColumn}
 				}
 			}
 			// Just .. is inclusive range
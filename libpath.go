@@ -0,0 +1,116 @@
+// Completion: 100% - -L library search paths and --rpath/--runpath
+package main
+
+import (
+	"debug/elf"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// libpath.go - Where imported C libraries are found
+//
+//	c67 -L ./lib --runpath '$ORIGIN/lib' game.c67 -o game
+//
+// import name as alias looks for libname.so, then for a versioned
+// libname.so.N, in the -L directories in the order they were given,
+// before asking ldconfig. A library found there is linked by its
+// DT_SONAME, or by its file name if it has none, so the executable does
+// not depend on the directory it was built in. Libraries found through
+// pkg-config or ldconfig are linked by their soname too, such as
+// libSDL3.so.0 rather than the libSDL3.so development symlink.
+//
+// --rpath and --runpath write DT_RPATH and DT_RUNPATH into the output ELF,
+// which is how the dynamic linker finds libraries that ship next to the
+// program; $ORIGIN stands for the directory of the executable. Each flag
+// can be given more than once, and the directories are joined with ':'.
+
+// searchPathList is a flag that collects a directory each time it is given
+type searchPathList []string
+
+func (l *searchPathList) String() string {
+	return strings.Join(*l, ":")
+}
+
+func (l *searchPathList) Set(dir string) error {
+	*l = append(*l, dir)
+	return nil
+}
+
+// findLibraryInSearchPaths returns the path of the shared object for the
+// library libName in the -L directories, or "" if it is not there
+func findLibraryInSearchPaths(libName string) string {
+	name := libName
+	if !strings.HasPrefix(name, "lib") {
+		name = "lib" + name
+	}
+	if !strings.Contains(name, ".so") {
+		name += ".so"
+	}
+	for _, dir := range LibrarySearchPaths {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		versioned, _ := filepath.Glob(path + ".*")
+		if len(versioned) > 0 {
+			sort.Strings(versioned)
+			return versioned[0]
+		}
+	}
+	return ""
+}
+
+// sharedObjectName returns the DT_SONAME of the shared object at path, or
+// its file name if it has none
+func sharedObjectName(path string) string {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		if names, err := f.DynString(elf.DT_SONAME); err == nil && len(names) > 0 {
+			return names[0]
+		}
+	}
+	return filepath.Base(path)
+}
+
+// ldconfigCache is the output of ldconfig -p, read once
+var ldconfigCache = sync.OnceValue(func() string {
+	output, _ := exec.Command("ldconfig", "-p").Output()
+	return string(output)
+})
+
+// ldconfigLibraryPaths returns the paths ldconfig has for the shared object
+// name or for versions of it, so libm.so also finds libm.so.6
+func ldconfigLibraryPaths(name string) []string {
+	var paths []string
+	for _, line := range strings.Split(ldconfigCache(), "\n") {
+		soname, path, ok := strings.Cut(line, "=>")
+		fields := strings.Fields(soname)
+		if !ok || len(fields) == 0 {
+			continue
+		}
+		if fields[0] == name || strings.HasPrefix(fields[0], name+".") {
+			paths = append(paths, strings.TrimSpace(path))
+		}
+	}
+	return paths
+}
+
+// sharedObjectPaths returns the files that may be the shared object with
+// the given DT_NEEDED name, the -L directories first
+func sharedObjectPaths(soname string) []string {
+	if filepath.IsAbs(soname) {
+		return []string{soname}
+	}
+	var paths []string
+	for _, dir := range LibrarySearchPaths {
+		path := filepath.Join(dir, soname)
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return append(paths, ldconfigLibraryPaths(soname)...)
+}
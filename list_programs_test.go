@@ -1,6 +1,8 @@
 package main
 
 import (
+	"encoding/binary"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -164,6 +166,30 @@ println(#ys)
 	testInlineC67(t, "append_function", source, "10\n20\n30\n3\n")
 }
 
+// TestAppendGrowsPastInlineCopy tests append() on lists copied inline and by memcpy
+// Confidence that this function is working: 95%
+func TestAppendGrowsPastInlineCopy(t *testing.T) {
+	source := `xs := []
+@ i in 0..<20 {
+    xs <- append(xs, i * 2)
+}
+ys := [1]
+@ i in 0..<12 {
+    ys += i
+}
+total := 0
+@ i in 0..<20 {
+    total <- total + xs[i]
+}
+println(xs[7])
+println(xs[19])
+println(total)
+println(ys[12])
+println(#ys)
+`
+	testInlineC67(t, "append_grows", source, "14\n38\n380\n11\n13\n")
+}
+
 // TestPopMethod tests the .pop() method syntax sugar
 // Confidence that this function is working: 95%
 func TestPopMethod(t *testing.T) {
@@ -213,3 +239,237 @@ println(#ys)
 `
 	testInlineC67(t, "append_chaining", source, "1\n2\n3\n3\n")
 }
+
+// TestListComprehensions tests [expr : x in iterable if cond] and its map and @@ forms
+func TestListComprehensions(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name: "filtered_range",
+			source: `f = x -> x * x
+main = {
+    evens = [f(x) : x in 0..<10 if x % 2 == 0]
+    println(#evens)
+    println(evens[4])
+}
+`,
+			expected: "5\n64\n",
+		},
+		{
+			name: "list_source",
+			source: `xs = [4, 5, 6]
+ys = [x * 3 : x in xs if x != 5]
+println(#ys)
+println(ys[1])
+indexed = [i * 100 + x : i, x in [7, 8, 9]]
+println(indexed[2])
+`,
+			expected: "2\n18\n209\n",
+		},
+		{
+			name: "map_comprehension",
+			source: `keys = [1, 2, 3]
+squares = {k: k * k : k in keys}
+println(squares[3])
+m = {1: 5, 2: 6}
+doubled = {k: v * 2 : k, v in m if k > 1}
+println(#doubled)
+println(doubled[2])
+`,
+			expected: "9\n1\n12\n",
+		},
+		{
+			name: "parallel_comprehension",
+			source: `main = {
+    c := 3
+    xs = [x * c : @@ x in 0..<100]
+    println(#xs)
+    println(xs[99])
+    odd = [x : @@ x in 10..<30 by 3 if x % 2 == 1]
+    println(#odd)
+    println(odd[2])
+    sq = {x: x * x : @@ x in 0..<50 if x % 10 == 0}
+    println(#sq)
+    println(sq[40])
+}
+`,
+			expected: "100\n297\n3\n25\n5\n1600\n",
+		},
+		{
+			name: "inside_function",
+			source: `evens = n -> {
+    e = [x : x in 0..<n max 1000 if x % 2 == 0]
+    println(#e * 100 + e[4])
+}
+evens(9)
+`,
+			expected: "508\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testInlineC67(t, tt.name, tt.source, tt.expected)
+		})
+	}
+}
+
+// TestComprehensionErrors tests that comprehensions the loops cannot run are rejected
+func TestComprehensionErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		errMsg string
+	}{
+		{"missing_in", "xs = [x : x of 0..<10]\n", "expected 'in'"},
+		{"non_literal_range", "n = 5\nxs = [x : x in 0..<n]\n", "requires explicit 'max'"},
+		{"parallel_list", "ys = [1, 2]\nxs = [x : @@ x in ys]\n", "needs a range"},
+		{"parallel_bounds", "n = 5\nxs = [x : @@ x in 0..<n max 10]\n", "literal bounds"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParser(tt.source)
+			func() {
+				defer func() { recover() }() // ParseProgram panics after reporting errors
+				p.ParseProgram()
+			}()
+			if !p.errors.HasErrors() {
+				t.Fatalf("expected an error containing %q", tt.errMsg)
+			}
+			if report := p.errors.Report(false); !strings.Contains(report, tt.errMsg) {
+				t.Errorf("expected an error containing %q, got: %s", tt.errMsg, report)
+			}
+		})
+	}
+}
+
+// TestCopyAndDeepCopy tests that copies do not share entries with the original
+func TestCopyAndDeepCopy(t *testing.T) {
+	source := `main = {
+    xs := [1, 2, 3]
+    ys := copy(xs)
+    ys[0] <- 100
+    println(xs[0])
+    println(ys[0])
+    println(#ys)
+    inner := [5, 6]
+    outer := [inner, [7, 8], 9]
+    shallow := copy(outer)
+    deep := deepcopy(outer)
+    println(shallow[0] == outer[0])
+    println(deep[0] == outer[0])
+    println(deep[2])
+    m = {1: 10, 2: 20}
+    println(copy(m)[2])
+    println(copy(42))
+    arena {
+        t := deepcopy(xs)
+        println(t[2])
+    }
+}
+`
+	testInlineC67(t, "copy_and_deepcopy", source, "1\n100\n3\n1\n0\n9\n20\n42\n3\n")
+}
+
+// TestBigload tests bigload() on raw float64 files and numeric text lines
+func TestBigload(t *testing.T) {
+	dir := t.TempDir()
+	raw := make([]byte, 0, 32)
+	for _, v := range []float64{1.5, -2, 3.25, 1e6} {
+		raw = binary.LittleEndian.AppendUint64(raw, math.Float64bits(v))
+	}
+	rawPath := filepath.Join(dir, "data.f64")
+	if err := os.WriteFile(rawPath, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	linesPath := filepath.Join(dir, "data.txt")
+	if err := os.WriteFile(linesPath, []byte("10\n  2.5\nheader\n\n-4"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	source := `main = {
+    xs := bigload("` + rawPath + `")
+    println(#xs)
+    println(xs[2])
+    total := 0
+    @ x in xs { total <- total + x }
+    println(total)
+    ys := bigload("` + linesPath + `", "lines")
+    println(#ys)
+    println(ys[1] + ys[2])
+    println(#bigload("` + filepath.Join(dir, "missing") + `"))
+}
+`
+	testInlineC67(t, "bigload", source, "4\n3.25\n1000002.75\n3\n-1.5\n0\n")
+}
+
+// TestReductions tests sum, min, max and mean over lists
+func TestReductions(t *testing.T) {
+	raw := make([]byte, 0, 8*200001)
+	for i := 0; i <= 200000; i++ {
+		raw = binary.LittleEndian.AppendUint64(raw, math.Float64bits(float64(i)))
+	}
+	bigPath := filepath.Join(t.TempDir(), "big.f64")
+	if err := os.WriteFile(bigPath, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	source := `main = {
+    xs := [3, 1, 4, 1, 5, 9, 2, 6, 5]
+    println(sum(xs))
+    println(min(xs))
+    println(max(xs))
+    println(mean([1, 2, 3, 4]))
+    println(min(3, 7))
+    println(max(3, 7))
+    println(sum([]))
+    println(max([]).error)
+    big := bigload("` + bigPath + `")
+    println(sum(big))
+    println(min(big))
+    println(max(big))
+    println(mean(big))
+    @ i in 0..<2 max 5 { println(i) }
+}
+`
+	testInlineC67(t, "reductions", source, "36\n1\n9\n2.5\n3\n7\n0\narg\n20000100000\n0\n200000\n100000\n0\n1\n")
+}
+
+func TestSortedLists(t *testing.T) {
+	source := `main = {
+    xs := [1, 3, 3, 5, 9]
+    println(bsearch(xs, 3))
+    println(bsearch(xs, 4))
+    println(bsearch(xs, 10))
+    println(bsearch([], 2))
+    ys := sortedinsert(xs, 4)
+    ys <- sortedinsert(ys, 0)
+    ys <- sortedinsert(ys, 12)
+    @ y in ys { println(y) }
+    println(#xs)
+}
+`
+	testInlineC67(t, "sorted_lists", source, "1\n3\n5\n0\n0\n1\n3\n3\n4\n5\n9\n12\n5\n")
+}
+
+func TestArray2d(t *testing.T) {
+	source := `main = {
+    g := array2d(3, 2, 7)
+    println(#g)
+    g[1, 0] <- 5
+    g[2, 1] <- 9
+    println(g[1, 0] + g[2, 1])
+    println(g[5])
+    h := gridcopy(g)
+    gridfill(g, 0)
+    println(g[2, 1])
+    @ y in 0..<2 {
+        @ x in 0..<3 { print(h[x, y]) }
+        println("")
+    }
+}
+`
+	testInlineC67(t, "array2d", source, "6\n14\n9\n0\n757\n779\n")
+}
@@ -125,3 +125,50 @@ println(rest[1])
 		t.Errorf("Expected output to contain '2\\n3\\n', got: %s", result)
 	}
 }
+
+// TestSliceAssignment tests writing a list into a range of another list
+func TestSliceAssignment(t *testing.T) {
+	source := `xs := [1, 2, 3, 4, 5, 6]
+xs[1:4] <- [20, 30, 40]
+println(xs[1] + xs[2] + xs[3])
+xs[::2] <- [7, 8, 9]
+println(xs[0] * 100 + xs[2] * 10 + xs[4])
+xs[::-1] <- xs
+println(xs[0] * 100 + xs[1] * 10 + xs[5])
+xs[4:] <- [0, 0]
+xs[:1] <- [100]
+println(xs[0] + xs[4] + xs[5])
+xs[2:2] <- []
+println(#xs)
+`
+	result := compileAndRun(t, source)
+	expected := "90\n789\n697\n100\n6\n"
+	if !strings.Contains(result, expected) {
+		t.Errorf("Expected output to contain %q, got: %s", expected, result)
+	}
+}
+
+// TestSliceAssignmentErrors tests that slice assignment never grows, truncates or overruns a list
+func TestSliceAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		errMsg string
+	}{
+		{"too_few", "xs := [1, 2, 3]\nxs[0:2] <- [9]\nprintln(xs[0])\n", "length mismatch"},
+		{"too_many", "xs := [1, 2, 3]\nxs[0:1] <- [7, 8]\nprintln(xs[0])\n", "length mismatch"},
+		{"past_end", "xs := [1, 2, 3]\nxs[2:4] <- [7, 8]\nprintln(xs[0])\n", "out of bounds"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := compileAndRun(t, tt.source)
+			if !strings.Contains(result, tt.errMsg) {
+				t.Errorf("Expected output to contain %q, got: %s", tt.errMsg, result)
+			}
+			if strings.Contains(result, "\n7\n") || strings.HasPrefix(result, "9") {
+				t.Errorf("List was modified before the error: %s", result)
+			}
+		})
+	}
+}
@@ -0,0 +1,510 @@
+// Completion: 100% - Leveled, timestamped logging built-ins
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// logging.go - logdebug, loginfo, logwarn and logerror
+//
+// loginfo("listening", "port", 8080, "host", host) writes one line to stderr:
+//
+//	2026-01-02T15:04:05Z INFO listening port=8080 host=localhost
+//
+// or, with C67_LOG_FORMAT=json in the environment:
+//
+//	{"time":"2026-01-02T15:04:05Z","level":"info","msg":"listening","port":8080,"host":"localhost"}
+//
+// Lines below the minimum level are skipped before the message or fields are
+// evaluated. The minimum level is C67_LOG_LEVEL (debug, info, warn, error or
+// off), read once on the first log call, or --log-level when that is unset.
+//
+// A line is built in a fixed buffer and written with a single write(2), so
+// lines from concurrent processes do not interleave. Lines longer than the
+// buffer are truncated. Field values that are statically strings are printed
+// as strings. Other values, such as lambda parameters, are printed as strings
+// when they look like a pointer (see copy.go) and as numbers otherwise.
+
+// Log levels, in the order of logLevelNames
+const (
+	logLevelDebug = iota
+	logLevelInfo
+	logLevelWarn
+	logLevelError
+	logLevelOff
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error", "off"}
+
+// DefaultLogLevel is the minimum level when C67_LOG_LEVEL is not set, from --log-level
+var DefaultLogLevel = logLevelInfo
+
+// logLineSize is the size of the line buffer, including room for "}\n"
+const logLineSize = 1024
+
+// parseLogLevel returns the level for a --log-level value
+func parseLogLevel(name string) (int, error) {
+	for i, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q (use debug, info, warn, error or off)", name)
+}
+
+// compileLogCall compiles logdebug/loginfo/logwarn/logerror(msg, key, value, ...)
+func (fc *C67Compiler) compileLogCall(call *CallExpr) {
+	if len(call.Args) == 0 || len(call.Args)%2 != 1 {
+		compilerError("%s() takes a message followed by key, value pairs", call.Function)
+	}
+	if fc.eb.target.Arch() != ArchX86_64 || fc.eb.target.OS() == OSWindows {
+		compilerError("%s() is only supported on x86_64 Unix targets", call.Function)
+	}
+	level, _ := parseLogLevel(strings.TrimPrefix(call.Function, "log"))
+
+	fc.defineLogData()
+	fc.usesLogging = true
+
+	fc.out.MovImmToReg("rdi", strconv.Itoa(level))
+	fc.out.CallSymbol("_c67_log_begin")
+	fc.out.TestRegReg("rax", "rax")
+	disabled := fc.forwardJump(JumpEqual)
+
+	fc.compileLogString(call.Args[0], "_c67_log_str")
+	for i := 1; i < len(call.Args); i += 2 {
+		fc.compileLogString(call.Args[i], "_c67_log_key")
+		value := call.Args[i+1]
+		if fc.getExprType(value) == "string" {
+			fc.compileLogString(value, "_c67_log_str")
+		} else {
+			fc.compileExpression(value)
+			fc.out.CallSymbol("_c67_log_any")
+		}
+	}
+	fc.out.CallSymbol("_c67_log_end")
+
+	fc.landForwardJump(disabled)
+	fc.out.XorpdXmm("xmm0", "xmm0")
+}
+
+// compileLogString converts a string argument to a C string and passes it
+// to the log helper
+func (fc *C67Compiler) compileLogString(arg Expression, helper string) {
+	fc.compileExpression(arg)
+	fc.out.CallSymbol("c67_string_to_cstr")
+	fc.out.MovRegToReg("rdi", "rax")
+	fc.out.CallSymbol(helper)
+}
+
+// defineLogData defines the log buffers and strings and registers the libc
+// functions the helpers call. Runs during compilation, since helpers are only
+// generated once the data layout and PLT are fixed.
+func (fc *C67Compiler) defineLogData() {
+	// _c67_log_state: [0] minimum level + 1 (0 until read), [8] JSON flag
+	fc.eb.DefineWritable("_c67_log_state", strings.Repeat("\x00", 16))
+	fc.eb.DefineWritable("_c67_log_len", strings.Repeat("\x00", 8))
+	fc.eb.DefineWritable("_c67_log_line", strings.Repeat("\x00", logLineSize))
+
+	fc.eb.Define("_c67_log_env_level", "C67_LOG_LEVEL\x00")
+	fc.eb.Define("_c67_log_env_format", "C67_LOG_FORMAT\x00")
+	fc.eb.Define("_c67_log_time_fmt", "%Y-%m-%dT%H:%M:%SZ\x00")
+	fc.eb.Define("_c67_log_num_fmt", "%.15g\x00")
+	fc.eb.Define("_c67_log_hex", "0123456789abcdef")
+	fc.eb.Define("_c67_log_null", "null\x00")
+	fc.eb.Define("_c67_log_json_time", "{\"time\":\"\x00")
+	fc.eb.Define("_c67_log_json_level", "\",\"level\":\"\x00")
+	fc.eb.Define("_c67_log_json_msg", "\",\"msg\":\x00")
+	fc.eb.Define("_c67_log_json_key", ",\"\x00")
+	fc.eb.Define("_c67_log_json_colon", "\":\x00")
+
+	// Level names in 8-byte slots, indexed by level
+	var lower, upper strings.Builder
+	for _, name := range logLevelNames[:logLevelOff] {
+		lower.WriteString(name + strings.Repeat("\x00", 8-len(name)))
+		upper.WriteString(strings.ToUpper(name) + strings.Repeat("\x00", 8-len(name)))
+	}
+	fc.eb.Define("_c67_log_names", lower.String())
+	fc.eb.Define("_c67_log_names_upper", upper.String())
+
+	for _, name := range []string{"getenv", "time", "gmtime_r", "strftime", "snprintf", "write"} {
+		fc.usedFunctions[name] = true
+	}
+}
+
+// generateLogHelpers emits the runtime helpers behind the log built-ins
+func (fc *C67Compiler) generateLogHelpers() {
+	fc.generateLogPutc()
+	fc.generateLogPuts()
+	fc.generateLogBegin()
+	fc.generateLogStr()
+	fc.generateLogKey()
+	fc.generateLogNum()
+	fc.generateLogAny()
+	fc.generateLogEnd()
+}
+
+// generateLogPutc emits _c67_log_putc(r8=byte), which appends a byte to the
+// line unless only the room for "}\n" is left. Clobbers rcx, rdx and r9.
+func (fc *C67Compiler) generateLogPutc() {
+	fc.eb.MarkFunction("_c67_log_putc")
+	fc.out.LeaSymbolToReg("rcx", "_c67_log_len")
+	fc.out.MovMemToReg("r9", "rcx", 0)
+	fc.out.CmpRegToImm("r9", logLineSize-2)
+	full := fc.forwardJump(JumpAboveOrEqual)
+	fc.out.LeaSymbolToReg("rdx", "_c67_log_line")
+	fc.out.AddRegToReg("rdx", "r9")
+	fc.out.MovU8RegToMem("r8", "rdx", 0)
+	fc.out.IncReg("r9")
+	fc.out.MovRegToMem("r9", "rcx", 0)
+	fc.landForwardJump(full)
+	fc.out.Ret()
+}
+
+// emitLogPutc appends the byte c to the line
+func (fc *C67Compiler) emitLogPutc(c byte) {
+	fc.out.MovImmToReg("r8", strconv.Itoa(int(c)))
+	fc.out.CallSymbol("_c67_log_putc")
+}
+
+// generateLogPuts emits _c67_log_puts(rdi=C string, rsi=escape), which
+// appends the string, escaped for a JSON string when rsi is not 0.
+// Clobbers rdi, rcx, rdx, r8, r9 and r10.
+func (fc *C67Compiler) generateLogPuts() {
+	fc.eb.MarkFunction("_c67_log_puts")
+	loopStart := fc.eb.text.Len()
+	fc.out.MovU8MemToReg("r8", "rdi", 0)
+	fc.out.TestRegReg("r8", "r8")
+	done := fc.forwardJump(JumpEqual)
+	fc.out.TestRegReg("rsi", "rsi")
+	plain := []int{fc.forwardJump(JumpEqual)}
+
+	// JSON: backslash before '"' and '\', \u00XX for control characters
+	fc.out.CmpRegToImm("r8", '"')
+	quote := fc.forwardJump(JumpEqual)
+	fc.out.CmpRegToImm("r8", '\\')
+	backslash := fc.forwardJump(JumpEqual)
+	fc.out.CmpRegToImm("r8", 0x20)
+	plain = append(plain, fc.forwardJump(JumpAboveOrEqual))
+
+	fc.out.MovRegToReg("r10", "r8")
+	fc.emitLogPutc('\\')
+	fc.emitLogPutc('u')
+	fc.emitLogPutc('0')
+	fc.emitLogPutc('0')
+	fc.out.LeaSymbolToReg("rdx", "_c67_log_hex")
+	fc.out.MovRegToReg("rcx", "r10")
+	fc.out.ShrRegByImm("rcx", 4)
+	fc.out.AddRegToReg("rdx", "rcx")
+	fc.out.MovU8MemToReg("r8", "rdx", 0)
+	fc.out.CallSymbol("_c67_log_putc")
+	fc.out.LeaSymbolToReg("rdx", "_c67_log_hex")
+	fc.out.AndRegWithImm("r10", 0xF)
+	fc.out.AddRegToReg("rdx", "r10")
+	fc.out.MovU8MemToReg("r8", "rdx", 0)
+	fc.out.CallSymbol("_c67_log_putc")
+	next := fc.forwardJumpAlways()
+
+	fc.landForwardJump(quote)
+	fc.landForwardJump(backslash)
+	fc.out.MovRegToReg("r10", "r8")
+	fc.emitLogPutc('\\')
+	fc.out.MovRegToReg("r8", "r10")
+
+	for _, pos := range plain {
+		fc.landForwardJump(pos)
+	}
+	fc.out.CallSymbol("_c67_log_putc")
+	fc.landForwardJump(next)
+	fc.out.IncReg("rdi")
+	fc.out.JumpUnconditional(int32(loopStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(done)
+	fc.out.Ret()
+}
+
+// emitLogPutsSymbol appends the C string at label without escaping
+func (fc *C67Compiler) emitLogPutsSymbol(label string) {
+	fc.out.LeaSymbolToReg("rdi", label)
+	fc.out.XorRegWithReg("rsi", "rsi")
+	fc.out.CallSymbol("_c67_log_puts")
+}
+
+// emitLoadLogJSON sets rax to the JSON flag
+func (fc *C67Compiler) emitLoadLogJSON() {
+	fc.out.LeaSymbolToReg("rax", "_c67_log_state")
+	fc.out.MovMemToReg("rax", "rax", 8)
+}
+
+// generateLogBegin emits _c67_log_begin(rdi=level) -> rax, which returns 0
+// when the level is below the minimum and otherwise starts a new line with
+// the timestamp and level
+func (fc *C67Compiler) generateLogBegin() {
+	fc.eb.MarkFunction("_c67_log_begin")
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.SubImmFromReg("rsp", 64) // struct tm at [rsp], time_t at [rsp+56]
+
+	fc.out.MovRegToReg("rbx", "rdi") // rbx = level
+	fc.out.LeaSymbolToReg("r12", "_c67_log_state")
+	fc.out.MovMemToReg("rax", "r12", 0)
+	fc.out.TestRegReg("rax", "rax")
+	ready := fc.forwardJump(JumpNotEqual)
+
+	// First call: read C67_LOG_LEVEL and C67_LOG_FORMAT
+	fc.out.MovImmToReg("rax", strconv.Itoa(DefaultLogLevel+1))
+	fc.out.MovRegToMem("rax", "r12", 0)
+	fc.out.LeaSymbolToReg("rdi", "_c67_log_env_level")
+	fc.eb.GenerateCallInstruction("getenv")
+	fc.out.TestRegReg("rax", "rax")
+	noLevel := fc.forwardJump(JumpEqual)
+	fc.out.MovU8MemToReg("rcx", "rax", 0)
+	fc.out.OrRegWithImm("rcx", 0x20) // lower case
+	var matched []int
+	for level, name := range logLevelNames {
+		fc.out.CmpRegToImm("rcx", int64(name[0]))
+		skip := fc.forwardJump(JumpNotEqual)
+		fc.out.MovImmToReg("rax", strconv.Itoa(level+1))
+		fc.out.MovRegToMem("rax", "r12", 0)
+		matched = append(matched, fc.forwardJumpAlways())
+		fc.landForwardJump(skip)
+	}
+	for _, pos := range matched {
+		fc.landForwardJump(pos)
+	}
+	fc.landForwardJump(noLevel)
+
+	fc.out.LeaSymbolToReg("rdi", "_c67_log_env_format")
+	fc.eb.GenerateCallInstruction("getenv")
+	fc.out.TestRegReg("rax", "rax")
+	noFormat := fc.forwardJump(JumpEqual)
+	fc.out.MovU8MemToReg("rcx", "rax", 0)
+	fc.out.OrRegWithImm("rcx", 0x20)
+	fc.out.CmpRegToImm("rcx", 'j')
+	notJSON := fc.forwardJump(JumpNotEqual)
+	fc.out.MovImmToReg("rax", "1")
+	fc.out.MovRegToMem("rax", "r12", 8)
+	fc.landForwardJump(notJSON)
+	fc.landForwardJump(noFormat)
+
+	fc.landForwardJump(ready)
+	fc.out.MovMemToReg("rax", "r12", 0)
+	fc.out.DecReg("rax")
+	fc.out.XorRegWithReg("rcx", "rcx") // rcx = return value
+	fc.out.CmpRegToReg("rbx", "rax")
+	disabled := fc.forwardJump(JumpLess)
+
+	fc.out.LeaSymbolToReg("rax", "_c67_log_len")
+	fc.out.MovRegToMem("rcx", "rax", 0)
+	fc.emitLoadLogJSON()
+	fc.out.TestRegReg("rax", "rax")
+	textTime := fc.forwardJump(JumpEqual)
+	fc.emitLogPutsSymbol("_c67_log_json_time")
+	fc.landForwardJump(textTime)
+
+	// strftime(line + len, 32, "%Y-%m-%dT%H:%M:%SZ", gmtime_r(time(&t), &tm))
+	fc.out.MovRegToReg("rdi", "rsp")
+	fc.out.AddImmToReg("rdi", 56)
+	fc.eb.GenerateCallInstruction("time")
+	fc.out.MovRegToReg("rdi", "rsp")
+	fc.out.AddImmToReg("rdi", 56)
+	fc.out.MovRegToReg("rsi", "rsp")
+	fc.eb.GenerateCallInstruction("gmtime_r")
+	fc.out.LeaSymbolToReg("rdi", "_c67_log_line")
+	fc.out.LeaSymbolToReg("rax", "_c67_log_len")
+	fc.out.MovMemToReg("rax", "rax", 0)
+	fc.out.AddRegToReg("rdi", "rax")
+	fc.out.MovImmToReg("rsi", "32")
+	fc.out.LeaSymbolToReg("rdx", "_c67_log_time_fmt")
+	fc.out.MovRegToReg("rcx", "rsp")
+	fc.eb.GenerateCallInstruction("strftime")
+	fc.out.LeaSymbolToReg("rcx", "_c67_log_len")
+	fc.out.MovMemToReg("rdx", "rcx", 0)
+	fc.out.AddRegToReg("rdx", "rax")
+	fc.out.MovRegToMem("rdx", "rcx", 0)
+
+	// Level name: ","level":"info","msg": or " INFO "
+	fc.out.ShlImmReg("rbx", 3)
+	fc.emitLoadLogJSON()
+	fc.out.TestRegReg("rax", "rax")
+	textLevel := fc.forwardJump(JumpEqual)
+	fc.emitLogPutsSymbol("_c67_log_json_level")
+	fc.out.LeaSymbolToReg("rdi", "_c67_log_names")
+	fc.out.AddRegToReg("rdi", "rbx")
+	fc.out.XorRegWithReg("rsi", "rsi")
+	fc.out.CallSymbol("_c67_log_puts")
+	fc.emitLogPutsSymbol("_c67_log_json_msg")
+	levelDone := fc.forwardJumpAlways()
+	fc.landForwardJump(textLevel)
+	fc.emitLogPutc(' ')
+	fc.out.LeaSymbolToReg("rdi", "_c67_log_names_upper")
+	fc.out.AddRegToReg("rdi", "rbx")
+	fc.out.XorRegWithReg("rsi", "rsi")
+	fc.out.CallSymbol("_c67_log_puts")
+	fc.emitLogPutc(' ')
+	fc.landForwardJump(levelDone)
+	fc.out.MovImmToReg("rcx", "1")
+
+	fc.landForwardJump(disabled)
+	fc.out.MovRegToReg("rax", "rcx")
+	fc.out.AddImmToReg("rsp", 64)
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generateLogStr emits _c67_log_str(rdi=C string), which appends a string
+// value: quoted and escaped for JSON, as it is for text
+func (fc *C67Compiler) generateLogStr() {
+	fc.eb.MarkFunction("_c67_log_str")
+	fc.out.PushReg("rbx")
+	fc.out.MovRegToReg("rbx", "rdi")
+	fc.emitLoadLogJSON()
+	fc.out.TestRegReg("rax", "rax")
+	text := fc.forwardJump(JumpEqual)
+	fc.emitLogPutc('"')
+	fc.out.MovRegToReg("rdi", "rbx")
+	fc.out.MovImmToReg("rsi", "1")
+	fc.out.CallSymbol("_c67_log_puts")
+	fc.emitLogPutc('"')
+	done := fc.forwardJumpAlways()
+	fc.landForwardJump(text)
+	fc.out.MovRegToReg("rdi", "rbx")
+	fc.out.XorRegWithReg("rsi", "rsi")
+	fc.out.CallSymbol("_c67_log_puts")
+	fc.landForwardJump(done)
+	fc.out.PopReg("rbx")
+	fc.out.Ret()
+}
+
+// generateLogKey emits _c67_log_key(rdi=C string), which appends a field
+// name: ,"name": for JSON, " name=" for text
+func (fc *C67Compiler) generateLogKey() {
+	fc.eb.MarkFunction("_c67_log_key")
+	fc.out.PushReg("rbx")
+	fc.out.MovRegToReg("rbx", "rdi")
+	fc.emitLoadLogJSON()
+	fc.out.TestRegReg("rax", "rax")
+	text := fc.forwardJump(JumpEqual)
+	fc.emitLogPutsSymbol("_c67_log_json_key")
+	fc.out.MovRegToReg("rdi", "rbx")
+	fc.out.MovImmToReg("rsi", "1")
+	fc.out.CallSymbol("_c67_log_puts")
+	fc.emitLogPutsSymbol("_c67_log_json_colon")
+	done := fc.forwardJumpAlways()
+	fc.landForwardJump(text)
+	fc.emitLogPutc(' ')
+	fc.out.MovRegToReg("rdi", "rbx")
+	fc.out.XorRegWithReg("rsi", "rsi")
+	fc.out.CallSymbol("_c67_log_puts")
+	fc.emitLogPutc('=')
+	fc.landForwardJump(done)
+	fc.out.PopReg("rbx")
+	fc.out.Ret()
+}
+
+// generateLogNum emits _c67_log_num(xmm0=value), which appends a number
+// formatted with %.15g. JSON has no NaN or infinity, so those become null.
+func (fc *C67Compiler) generateLogNum() {
+	fc.eb.MarkFunction("_c67_log_num")
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+
+	fc.emitLoadLogJSON()
+	fc.out.TestRegReg("rax", "rax")
+	finite := []int{fc.forwardJump(JumpEqual)}
+	fc.out.MovqXmmToReg("rax", "xmm0")
+	fc.out.ShlImmReg("rax", 1)
+	fc.out.ShrRegByImm("rax", 53) // exponent
+	fc.out.CmpRegToImm("rax", 0x7FF)
+	finite = append(finite, fc.forwardJump(JumpNotEqual))
+	fc.emitLogPutsSymbol("_c67_log_null")
+	done := fc.forwardJumpAlways()
+
+	for _, pos := range finite {
+		fc.landForwardJump(pos)
+	}
+	// snprintf(line + len, room, "%.15g", value), room leaves space for "}\n"
+	fc.out.LeaSymbolToReg("rcx", "_c67_log_len")
+	fc.out.MovMemToReg("rcx", "rcx", 0)
+	fc.out.MovImmToReg("rsi", strconv.Itoa(logLineSize-2))
+	fc.out.SubRegFromReg("rsi", "rcx")
+	fc.out.LeaSymbolToReg("rdi", "_c67_log_line")
+	fc.out.AddRegToReg("rdi", "rcx")
+	fc.out.LeaSymbolToReg("rdx", "_c67_log_num_fmt")
+	fc.out.MovImmToReg("rax", "1") // one vector register argument
+	fc.eb.GenerateCallInstruction("snprintf")
+
+	// len += min(written, room - 1)
+	fc.out.LeaSymbolToReg("rcx", "_c67_log_len")
+	fc.out.MovMemToReg("rdx", "rcx", 0)
+	fc.out.MovImmToReg("rsi", strconv.Itoa(logLineSize-3))
+	fc.out.SubRegFromReg("rsi", "rdx")
+	fc.out.CmpRegToReg("rax", "rsi")
+	fits := fc.forwardJump(JumpLessOrEqual)
+	fc.out.MovRegToReg("rax", "rsi")
+	fc.landForwardJump(fits)
+	fc.out.AddRegToReg("rdx", "rax")
+	fc.out.MovRegToMem("rdx", "rcx", 0)
+
+	fc.landForwardJump(done)
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generateLogAny emits _c67_log_any(xmm0=value), which appends a value as a
+// string when it looks like a pointer, or as a number
+func (fc *C67Compiler) generateLogAny() {
+	fc.eb.MarkFunction("_c67_log_any")
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.MovqXmmToReg("rax", "xmm0")
+	number := fc.emitJumpIfNotCollection("rax")
+	fc.out.CallSymbol("c67_string_to_cstr")
+	fc.out.MovRegToReg("rdi", "rax")
+	fc.out.CallSymbol("_c67_log_str")
+	done := fc.forwardJumpAlways()
+	for _, pos := range number {
+		fc.landForwardJump(pos)
+	}
+	fc.out.CallSymbol("_c67_log_num")
+	fc.landForwardJump(done)
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generateLogEnd emits _c67_log_end(), which closes the line and writes it
+// to stderr
+func (fc *C67Compiler) generateLogEnd() {
+	fc.eb.MarkFunction("_c67_log_end")
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+
+	// The last two bytes of the line are kept free for "}\n"
+	fc.out.LeaSymbolToReg("rcx", "_c67_log_len")
+	fc.out.MovMemToReg("rsi", "rcx", 0)
+	fc.out.LeaSymbolToReg("rdx", "_c67_log_line")
+	fc.out.AddRegToReg("rdx", "rsi")
+	fc.emitLoadLogJSON()
+	fc.out.TestRegReg("rax", "rax")
+	text := fc.forwardJump(JumpEqual)
+	fc.out.MovImmToReg("r8", strconv.Itoa('}'))
+	fc.out.MovU8RegToMem("r8", "rdx", 0)
+	fc.out.IncReg("rdx")
+	fc.out.IncReg("rsi")
+	fc.landForwardJump(text)
+	fc.out.MovImmToReg("r8", strconv.Itoa('\n'))
+	fc.out.MovU8RegToMem("r8", "rdx", 0)
+	fc.out.IncReg("rsi")
+
+	fc.out.MovRegToReg("rdx", "rsi")
+	fc.out.LeaSymbolToReg("rsi", "_c67_log_line")
+	fc.out.MovImmToReg("rdi", "2") // stderr
+	fc.eb.GenerateCallInstruction("write")
+
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
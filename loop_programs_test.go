@@ -4,6 +4,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -63,6 +64,76 @@ println(sum)
 `,
 			expected: "10\n20\n30\n40\n",
 		},
+		{
+			name: "map_key_value_iteration",
+			source: `m = {1: 10, 2: 20, 5: 50}
+total := 0
+@ k, v in m {
+    println(k * 1000 + v)
+    total <- total + k * v
+}
+println(total)
+`,
+			expected: "1010\n2020\n5050\n300\n",
+		},
+		{
+			name: "map_key_value_break",
+			source: `m = {1: 10, 2: 20, 5: 50}
+@ k, v in m {
+    k == 5 {
+        ret @
+    }
+    println(v)
+}
+`,
+			expected: "10\n20\n",
+		},
+		{
+			name: "list_index_element_iteration",
+			source: `items = [7, 8, 9]
+@ i, x in items {
+    println(i * 100 + x)
+}
+xs := [1, 2, 3, 4]
+total := 0
+@ i, x in xs {
+    total <- total + i * x
+}
+println(total)
+show = list -> {
+    @ i, x in list {
+        println(i + x)
+    }
+}
+show([10, 20])
+`,
+			expected: "7\n108\n209\n20\n10\n21\n",
+		},
+		{
+			name: "stepped_range_loop",
+			source: `@ i in 0..<10 by 3 {
+    println(i)
+}
+@ i in 0..10 by 5 {
+    println(i)
+}
+`,
+			expected: "0\n3\n6\n9\n0\n5\n10\n",
+		},
+		{
+			name: "descending_range_loop",
+			source: `@ i in 3..>0 {
+    println(i)
+}
+@ i in 10..>0 by -4 {
+    println(i)
+}
+@ i in 10..0 by -5 {
+    println(i)
+}
+`,
+			expected: "3\n2\n1\n10\n6\n2\n10\n5\n0\n",
+		},
 	}
 
 	for _, tt := range tests {
@@ -137,6 +208,38 @@ func TestInclusiveRange(t *testing.T) {
 	testInlineC67(t, "inclusive_range", source, expected)
 }
 
+// TestRangeTripCount tests the iteration counts used for max checks and parallel work distribution
+func TestRangeTripCount(t *testing.T) {
+	tests := []struct {
+		source     string
+		start, end int64
+		expected   int64
+	}{
+		{"0..<100", 0, 100, 100},
+		{"0..100", 0, 100, 101},
+		{"0..<100 by 3", 0, 100, 34},
+		{"0..99 by 3", 0, 99, 34},
+		{"100..>0 by -2", 100, 0, 50},
+		{"100..0 by -2", 100, 0, 51},
+		{"5..>0", 5, 0, 5},
+		{"5..<0", 5, 0, 0},
+		{"0..>5", 0, 5, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			p := NewParser(tt.source)
+			rangeExpr, ok := p.parseRange().(*RangeExpr)
+			if !ok {
+				t.Fatalf("%q did not parse as a range", tt.source)
+			}
+			if got := rangeExpr.TripCount(tt.start, tt.end); got != tt.expected {
+				t.Errorf("TripCount(%d, %d) = %d, want %d", tt.start, tt.end, got, tt.expected)
+			}
+		})
+	}
+}
+
 // TestDeeplyNestedLoops tests loops with 5+ levels of nesting (uses stack-based counters)
 func TestDeeplyNestedLoops(t *testing.T) {
 	// Test 5-level nesting (levels 0-2 use registers, 3-4 use stack)
@@ -175,3 +278,102 @@ println(count)
 `
 	testInlineC67(t, "6_level_nesting", source6, "64\n")
 }
+
+// TestRangeStepErrors tests that steps going the wrong way or nowhere are rejected
+func TestRangeStepErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		errMsg string
+	}{
+		{"zero_step", "@ i in 0..<10 by 0 {\n    println(i)\n}\n", "must not be zero"},
+		{"negative_ascending", "@ i in 0..<10 by -1 {\n    println(i)\n}\n", "needs a positive step"},
+		{"positive_descending", "@ i in 10..>0 by 2 {\n    println(i)\n}\n", "needs a negative step"},
+		{"fractional_step", "@ i in 0..<10 by 0.5 {\n    println(i)\n}\n", "integer constant"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParser(tt.source)
+			func() {
+				defer func() { recover() }() // ParseProgram panics after reporting errors
+				p.ParseProgram()
+			}()
+			if !p.errors.HasErrors() {
+				t.Fatalf("expected an error containing %q", tt.errMsg)
+			}
+			if report := p.errors.Report(false); !strings.Contains(report, tt.errMsg) {
+				t.Errorf("expected an error containing %q, got: %s", tt.errMsg, report)
+			}
+		})
+	}
+}
+
+// TestDefaultLimits tests --default-loop-max and --default-recursion-max
+func TestDefaultLimits(t *testing.T) {
+	defer func() { DefaultLoopMax, DefaultRecursionMax = 0, 0 }()
+	DefaultLoopMax, DefaultRecursionMax = 100, 20
+
+	source := `depth = x -> x == 0 {
+    -> 0
+    ~> 1 + depth(x - 1)
+}
+
+main = {
+    count := 5
+    total := 0
+    @ i in 0..<count {
+        total <- total + i
+    }
+    println(total)
+    j := 0
+    @ j < count {
+        j <- j + 1
+    }
+    println(j)
+    println(loopmax())
+    println(recursionmax())
+    println(depth(count * 2))
+    println(depth(count * 10))
+}
+`
+	result := compileAndRun(t, source)
+	expected := "10\n5\n100\n20\n10\nError: recursion exceeded maximum depth\n"
+	if !strings.Contains(result, expected) {
+		t.Errorf("Expected output to contain: %s, got: %s", expected, result)
+	}
+
+	// Without a default, the max clause is required
+	DefaultLoopMax = 0
+	p := NewParser("n := 5\n@ i in 0..<n {\n    println(i)\n}\n")
+	func() {
+		defer func() { recover() }() // ParseProgram panics after reporting errors
+		p.ParseProgram()
+	}()
+	if report := p.errors.Report(false); !strings.Contains(report, "requires explicit 'max' clause") {
+		t.Errorf("expected a missing max error, got: %s", report)
+	}
+}
+
+// TestColdErrorPaths tests recursion checks that share an out-of-line error exit
+func TestColdErrorPaths(t *testing.T) {
+	source := `up = x -> x == 0 {
+    -> 0
+    ~> 1 + up(x - 1) max 30
+}
+twice = x -> x == 0 {
+    -> 0
+    ~> 2 + twice(x - 1) max 30
+}
+
+main = {
+    println(up(5) + twice(7))
+    println(twice(40))
+}
+`
+	result := compileAndRun(t, source)
+	expected := "19\nError: recursion exceeded maximum depth\n"
+	if !strings.Contains(result, expected) {
+		t.Errorf("Expected output to contain: %s, got: %s", expected, result)
+	}
+}
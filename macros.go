@@ -0,0 +1,375 @@
+// Completion: 100% - Macros
+package main
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// macros.go - Macros, expanded after parsing and before optimization
+//
+// A macro is a template of statements with parameters that are replaced by
+// the argument expressions of each call, not by their values:
+//
+//	macro times(n, body) {
+//	    @ i in 0..<n max 1000000 {
+//	        body
+//	    }
+//	}
+//
+//	times(3, { println("hi") })
+//
+// A parameter on a line of its own whose argument is a block is replaced by
+// the statements of the block. A call used as a statement is replaced by the
+// statements of the macro; used as a value, it becomes a block that is
+// evaluated in place and gives the value of its last statement.
+//
+// Expansion is hygienic: the names that the macro body binds, variables,
+// loop variables and lambda parameters, get a suffix that is unique to each
+// expansion (i becomes i$times1), so they can neither capture nor clobber
+// the variables of the caller. Other names refer to what they name where the
+// macro is used. A parameter that is assigned to or called stands for the
+// variable or function that its argument names.
+//
+// Macros are defined at module level and are visible in the file that
+// defines them, from anywhere in it. A macro may use other macros, and
+// itself, up to maxMacroDepth expansions deep.
+
+const maxMacroDepth = 64
+
+var (
+	statementType  = reflect.TypeOf((*Statement)(nil)).Elem()
+	expressionType = reflect.TypeOf((*Expression)(nil)).Elem()
+)
+
+// macroExpander expands the macros of one program
+type macroExpander struct {
+	macros     map[string]*MacroDecl
+	expansions int // numbers the expansions, for unique names
+	depth      int
+}
+
+// expandMacros removes the macro definitions from program and expands
+// every call to them
+func expandMacros(program *Program) *Program {
+	x := &macroExpander{macros: make(map[string]*MacroDecl)}
+	var statements []Statement
+	for _, stmt := range program.Statements {
+		if m, ok := stmt.(*MacroDecl); ok {
+			if _, exists := x.macros[m.Name]; exists {
+				compilerError("macro %s is defined twice", m.Name)
+			}
+			x.macros[m.Name] = m
+			continue
+		}
+		statements = append(statements, stmt)
+	}
+	if len(x.macros) == 0 {
+		return program
+	}
+	program.Statements = x.statements(statements)
+	return program
+}
+
+// statements expands the macros in a list of statements, splicing in the
+// body of each macro that is called as a statement
+func (x *macroExpander) statements(list []Statement) []Statement {
+	var out []Statement
+	for _, stmt := range list {
+		if es, ok := stmt.(*ExpressionStmt); ok {
+			if call, ok := es.Expr.(*CallExpr); ok && x.macros[call.Function] != nil {
+				out = append(out, x.expand(x.macros[call.Function], call)...)
+				continue
+			}
+		}
+		x.walk(reflect.ValueOf(stmt))
+		out = append(out, stmt)
+	}
+	return out
+}
+
+// walk expands the macros called anywhere below v, in place
+func (x *macroExpander) walk(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			x.walk(v.Elem())
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		if call, ok := v.Interface().(*CallExpr); ok && x.macros[call.Function] != nil && v.Type() == expressionType && v.CanSet() {
+			// Evaluated in place, like a desugared comprehension
+			block := &BlockExpr{Statements: x.expand(x.macros[call.Function], call), Comprehension: true}
+			v.Set(reflect.ValueOf(block))
+			return
+		}
+		x.walk(v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			x.walk(v.Field(i))
+		}
+	case reflect.Slice:
+		if v.Type().Elem() == statementType && v.CanSet() {
+			v.Set(reflect.ValueOf(x.statements(v.Interface().([]Statement))))
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			x.walk(v.Index(i))
+		}
+	}
+}
+
+// expand returns the statements of m for the call, with its own macro calls
+// expanded as well
+func (x *macroExpander) expand(m *MacroDecl, call *CallExpr) []Statement {
+	if len(call.Args) != len(m.Params) {
+		compilerError("macro %s expects %d arguments, got %d", m.Name, len(m.Params), len(call.Args))
+	}
+	x.depth++
+	if x.depth > maxMacroDepth {
+		compilerError("macro %s expands into itself more than %d levels deep", m.Name, maxMacroDepth)
+	}
+	defer func() { x.depth-- }()
+
+	x.expansions++
+	e := &macroExpansion{macro: m, args: make(map[string]Expression), renames: make(map[string]string)}
+	for i, param := range m.Params {
+		if call.Args[i] == nil {
+			compilerError("macro %s is called without argument %d (%s)", m.Name, i+1, param)
+		}
+		x.walk(reflect.ValueOf(&call.Args[i]).Elem())
+		e.args[param] = call.Args[i]
+	}
+	for _, stmt := range m.Body {
+		visitAST(reflect.ValueOf(stmt), func(node any) {
+			for _, name := range boundNames(node) {
+				if _, isParam := e.args[name]; !isParam && name != "" {
+					e.renames[name] = fmt.Sprintf("%s$%s%d", name, m.Name, x.expansions)
+				}
+			}
+		})
+	}
+
+	body := e.copy(reflect.ValueOf(m.Body)).Interface().([]Statement)
+	return x.statements(body)
+}
+
+// boundNames returns the names that node introduces, if any
+func boundNames(node any) []string {
+	switch n := node.(type) {
+	case *AssignStmt:
+		if !n.IsUpdate && !n.IsReuseMutable {
+			return []string{n.Name}
+		}
+	case *MultipleAssignStmt:
+		if !n.IsUpdate {
+			return n.Names
+		}
+	case *LoopStmt:
+		return []string{n.Iterator, n.KeyIterator}
+	case *LoopExpr:
+		return []string{n.Iterator}
+	case *ReceiveLoopStmt:
+		return []string{n.MessageVar, n.SenderVar}
+	case *LambdaExpr:
+		return append([]string{n.VariadicParam}, n.Params...)
+	case *VarPattern:
+		return []string{n.Name}
+	}
+	return nil
+}
+
+// visitAST calls fn for every pointer below v, v included
+func visitAST(v reflect.Value, fn func(any)) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			fn(v.Interface())
+			visitAST(v.Elem(), fn)
+		}
+	case reflect.Interface:
+		if !v.IsNil() {
+			visitAST(v.Elem(), fn)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			visitAST(v.Field(i), fn)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			visitAST(v.Index(i), fn)
+		}
+	}
+}
+
+// macroExpansion copies the body of a macro for one call
+type macroExpansion struct {
+	macro   *MacroDecl
+	args    map[string]Expression
+	renames map[string]string
+}
+
+// copy returns a deep copy of v with the parameters replaced by copies of
+// their arguments and the bound names renamed
+func (e *macroExpansion) copy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type().Elem())
+		out.Elem().Set(e.copy(v.Elem()))
+		e.rename(out.Interface())
+		return out
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type()).Elem()
+		switch n := v.Interface().(type) {
+		case *IdentExpr:
+			if arg, ok := e.args[n.Name]; ok {
+				out.Set(reflect.ValueOf(copyAST(arg)))
+				return out
+			}
+		case *CallExpr:
+			if arg, ok := e.args[n.Function]; ok {
+				if _, isIdent := arg.(*IdentExpr); !isIdent {
+					args := e.copy(reflect.ValueOf(n.Args)).Interface().([]Expression)
+					out.Set(reflect.ValueOf(&DirectCallExpr{Callee: copyAST(arg), Args: args}))
+					return out
+				}
+			}
+		}
+		out.Set(e.copy(v.Elem()))
+		return out
+
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			out.Field(i).Set(e.copy(v.Field(i)))
+		}
+		return out
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeSlice(v.Type(), 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if block := e.blockArgument(v.Index(i)); block != nil {
+				for _, stmt := range block.Statements {
+					out = reflect.Append(out, reflect.ValueOf(copyAST(stmt)))
+				}
+				continue
+			}
+			out = reflect.Append(out, e.copy(v.Index(i)))
+		}
+		return out
+
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := iter.Key()
+			if name, ok := key.Interface().(string); ok && e.renames[name] != "" {
+				key = reflect.ValueOf(e.renames[name])
+			}
+			out.SetMapIndex(key, e.copy(iter.Value()))
+		}
+		return out
+	}
+	return v
+}
+
+// blockArgument returns the block passed for a parameter that stands on a
+// line of its own, which replaces the line with its statements
+func (e *macroExpansion) blockArgument(v reflect.Value) *BlockExpr {
+	if v.Type() != statementType || v.IsNil() {
+		return nil
+	}
+	es, ok := v.Interface().(*ExpressionStmt)
+	if !ok {
+		return nil
+	}
+	ident, ok := es.Expr.(*IdentExpr)
+	if !ok {
+		return nil
+	}
+	block, _ := e.args[ident.Name].(*BlockExpr)
+	return block
+}
+
+// rename renames the names in a copied node
+func (e *macroExpansion) rename(node any) {
+	switch n := node.(type) {
+	case *IdentExpr:
+		n.Name = e.name(n.Name)
+	case *AssignStmt:
+		n.Name = e.target(n.Name)
+	case *MultipleAssignStmt:
+		for i, name := range n.Names {
+			n.Names[i] = e.target(name)
+		}
+	case *MapUpdateStmt:
+		n.MapName = e.target(n.MapName)
+	case *CallExpr:
+		n.Function = e.target(n.Function)
+	case *LoopStmt:
+		n.Iterator = e.name(n.Iterator)
+		n.KeyIterator = e.name(n.KeyIterator)
+	case *LoopExpr:
+		n.Iterator = e.name(n.Iterator)
+	case *ReceiveLoopStmt:
+		n.MessageVar = e.name(n.MessageVar)
+		n.SenderVar = e.name(n.SenderVar)
+	case *LambdaExpr:
+		for i, name := range n.Params {
+			n.Params[i] = e.name(name)
+		}
+		n.VariadicParam = e.name(n.VariadicParam)
+		for i, name := range n.CapturedVars {
+			n.CapturedVars[i] = e.name(name)
+		}
+	case *PatternLambdaExpr:
+		for i, name := range n.CapturedVars {
+			n.CapturedVars[i] = e.name(name)
+		}
+	case *VarPattern:
+		n.Name = e.name(n.Name)
+	}
+}
+
+// name returns the name that a name bound in the macro body gets
+func (e *macroExpansion) name(name string) string {
+	if renamed, ok := e.renames[name]; ok {
+		return renamed
+	}
+	return name
+}
+
+// target returns the name of the variable or function that is assigned to
+// or called: the one the argument names, for a parameter
+func (e *macroExpansion) target(name string) string {
+	arg, ok := e.args[name]
+	if !ok {
+		return e.name(name)
+	}
+	ident, ok := arg.(*IdentExpr)
+	if !ok {
+		compilerError("macro %s uses its parameter %s as a variable, so the argument has to be a name, not %s", e.macro.Name, name, arg.String())
+	}
+	return ident.Name
+}
+
+// copyAST returns a deep copy of an AST node
+func copyAST[T any](node T) T {
+	e := &macroExpansion{}
+	return e.copy(reflect.ValueOf(&node).Elem()).Interface().(T)
+}
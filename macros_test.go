@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestMacroExpansion tests macros used as statements, with a block argument, and as values
+func TestMacroExpansion(t *testing.T) {
+	source := `macro times(n, body) {
+    @ i in 0..<n max 1000 {
+        body
+    }
+}
+
+macro square(x) {
+    v := x
+    v * v
+}
+
+main = {
+    count := 0
+    times(4, {
+        count <- count + 1
+    })
+    println(count)
+    println(square(3 + 1))
+}
+`
+	result := compileAndRun(t, source)
+	if !strings.Contains(result, "4\n16\n") {
+		t.Errorf("Expected output to contain '4\\n16\\n', got: %s", result)
+	}
+}
+
+// TestMacroHygiene tests that the names a macro binds do not clash with the caller's
+func TestMacroHygiene(t *testing.T) {
+	source := `macro swap(a, b) {
+    tmp := a
+    a <- b
+    b <- tmp
+}
+
+main = {
+    tmp := 1
+    other := 2
+    swap(tmp, other)
+    println(tmp * 10 + other)
+}
+`
+	result := compileAndRun(t, source)
+	if !strings.Contains(result, "21\n") {
+		t.Errorf("Expected output to contain '21\\n', got: %s", result)
+	}
+}
+
+// TestMacroErrors tests calls with the wrong number of arguments and runaway recursion
+func TestMacroErrors(t *testing.T) {
+	_, err := compileTestCodeAllowError(t, `macro twice(x) {
+    x
+    x
+}
+main = {
+    twice(1, 2)
+}
+`)
+	if err == nil || !strings.Contains(err.Error(), "macro twice expects 1 arguments, got 2") {
+		t.Errorf("Expected an argument count error, got: %v", err)
+	}
+
+	_, err = compileTestCodeAllowError(t, `macro forever(x) {
+    forever(x)
+}
+main = {
+    forever(1)
+}
+`)
+	if err == nil || !strings.Contains(err.Error(), "more than 64 levels deep") {
+		t.Errorf("Expected a recursion depth error, got: %v", err)
+	}
+}
@@ -215,7 +215,8 @@ type BufferWrapper struct {
 type ExecutableBuilder struct {
 	target                  Target
 	consts                  map[string]*Const
-	labels                  map[string]int // Maps label names to their offsets in .text
+	labels                  map[string]int  // Maps label names to their offsets in .text
+	functions               map[string]bool // Labels that start a function, for .symtab
 	dynlinker               *DynamicLinker
 	useDynamicLinking       bool
 	neededFunctions         []string
@@ -225,6 +226,8 @@ type ExecutableBuilder struct {
 	rodataOffsetInELF       uint64
 	dataOffsetInELF         uint64
 	dynsymOffsetInELF       uint64
+	loadedSections          map[string]loadedSection // Dynamic sections, for the section headers
+	capabilities            []string                 // What the program can do, for .note.c67
 }
 
 func (eb *ExecutableBuilder) ELFWriter() Writer {
@@ -316,12 +319,7 @@ func (eb *ExecutableBuilder) patchX86_64PCRel(textBytes []byte, offset int, text
 	ripAddr := textAddr + uint64(offset) + 4 // RIP points after displacement
 	displacement := int64(targetAddr) - int64(ripAddr)
 
-	if displacement < -0x80000000 || displacement > 0x7FFFFFFF {
-		if VerboseMode {
-			fmt.Fprintf(os.Stderr, "Warning: x86-64 displacement too large: %d\n", displacement)
-		}
-		return
-	}
+	checkReach("the address of "+symbolName, offset, displacement, 32, 1)
 
 	disp32 := uint32(displacement)
 	textBytes[offset] = byte(disp32 & 0xFF)
@@ -353,13 +351,8 @@ func (eb *ExecutableBuilder) patchARM64PCRel(textBytes []byte, offset int, textA
 	targetPage := targetAddr & ^uint64(0xFFF)
 	pageOffset := int64(targetPage - instrPage)
 
-	// Check if page offset fits in 21 bits (signed, shifted)
-	if pageOffset < -0x100000000 || pageOffset > 0xFFFFFFFF {
-		if VerboseMode {
-			fmt.Fprintf(os.Stderr, "Warning: ARM64 page offset too large: %d\n", pageOffset)
-		}
-		return
-	}
+	// The page offset has to fit in 21 bits (signed, shifted)
+	checkReach("the ADRP of "+symbolName, offset, pageOffset, 21, 4096)
 
 	// Low 12 bits for ADD
 	low12 := uint32(targetAddr & 0xFFF)
@@ -414,12 +407,8 @@ func (eb *ExecutableBuilder) patchRISCV64PCRel(textBytes []byte, offset int, tex
 	instrAddr := textAddr + uint64(offset)
 	pcOffset := int64(targetAddr) - int64(instrAddr)
 
-	if pcOffset < -0x80000000 || pcOffset > 0x7FFFFFFF {
-		if VerboseMode {
-			fmt.Fprintf(os.Stderr, "Warning: RISC-V offset too large: %d\n", pcOffset)
-		}
-		return
-	}
+	// AUIPC adds a signed 20-bit upper part, rounded for the ADDI
+	checkReach("the AUIPC of "+symbolName, offset, pcOffset+0x800, 32, 1)
 
 	// Split into upper 20 bits and lower 12 bits
 	// If bit 11 is set, we need to add 1 to upper because ADDI sign-extends
@@ -582,22 +571,22 @@ func (eb *ExecutableBuilder) PatchCallSites(textAddr uint64) {
 			byteOffset := int64(targetAddr) - int64(currentAddr)
 			wordOffset := byteOffset / 4
 
-			// ARM64 BL uses 26-bit signed offset
-			if wordOffset < -0x2000000 || wordOffset >= 0x2000000 {
-				if VerboseMode {
-					fmt.Fprintf(os.Stderr, "Warning: ARM64/RISC-V call offset too large: %d words\n", wordOffset)
-				}
-				continue
-			}
-
 			// Read existing instruction and patch offset bits
 			instr := uint32(textBytes[patch.position]) |
 				(uint32(textBytes[patch.position+1]) << 8) |
 				(uint32(textBytes[patch.position+2]) << 16) |
 				(uint32(textBytes[patch.position+3]) << 24)
 
-			// For ARM64 BL: clear old offset (bits 0-25), set new offset
-			instr = (instr & 0xFC000000) | (uint32(wordOffset) & 0x03FFFFFF)
+			if eb.target.Arch() == ArchRiscv64 {
+				// RISC-V JAL: keep opcode and rd, set the 21-bit byte offset
+				checkReach("the call to "+patch.targetName, patch.position, byteOffset, 21, 1)
+				imm := uint32(byteOffset)
+				instr = (instr & 0xFFF) | (imm>>20&1)<<31 | (imm>>1&0x3FF)<<21 | (imm>>11&1)<<20 | (imm>>12&0xFF)<<12
+			} else {
+				// ARM64 BL uses 26-bit signed offset: clear old offset (bits 0-25), set new offset
+				checkReach("the call to "+patch.targetName, patch.position, byteOffset, 26, 4)
+				instr = (instr & 0xFC000000) | (uint32(wordOffset) & 0x03FFFFFF)
+			}
 
 			textBytes[patch.position] = byte(instr & 0xFF)
 			textBytes[patch.position+1] = byte((instr >> 8) & 0xFF)
@@ -613,12 +602,7 @@ func (eb *ExecutableBuilder) PatchCallSites(textAddr uint64) {
 			ripAddr := textAddr + uint64(patch.position) + 4 // RIP points after the rel32
 			displacement := int64(targetAddr) - int64(ripAddr)
 
-			if displacement < -0x80000000 || displacement > 0x7FFFFFFF {
-				if VerboseMode {
-					fmt.Fprintf(os.Stderr, "Warning: Call displacement too large: %d\n", displacement)
-				}
-				continue
-			}
+			checkReach("the call to "+patch.targetName, patch.position, displacement, 32, 1)
 
 			// Patch the 4-byte rel32 offset
 			disp32 := uint32(displacement)
@@ -745,6 +729,16 @@ func (eb *ExecutableBuilder) MarkLabel(label string) {
 	}
 }
 
+// MarkFunction marks the start of a function in .text, like MarkLabel, and
+// gives it a FUNC symbol in .symtab
+func (eb *ExecutableBuilder) MarkFunction(name string) {
+	eb.MarkLabel(name)
+	if eb.functions == nil {
+		eb.functions = make(map[string]bool)
+	}
+	eb.functions[name] = true
+}
+
 func (eb *ExecutableBuilder) LabelOffset(label string) int {
 	// Get the offset of a label in the text section
 	if offset, ok := eb.labels[label]; ok {
@@ -877,8 +871,9 @@ func (eb *ExecutableBuilder) GenerateCallInstruction(funcName string) error {
 	// Strip leading underscore for Mach-O compatibility, but NOT for:
 	// - Internal C67 runtime functions (starting with _c67)
 	// - Functions starting with double underscore (like __acrt_iob_func on Windows)
+	// - Other targets, where _exit is not exit
 	targetName := funcName
-	if strings.HasPrefix(funcName, "_") && !strings.HasPrefix(funcName, "_c67") && !strings.HasPrefix(funcName, "__") {
+	if eb.target.IsMachO() && strings.HasPrefix(funcName, "_") && !strings.HasPrefix(funcName, "_c67") && !strings.HasPrefix(funcName, "__") {
 		targetName = funcName[1:] // Remove underscore for external C functions
 	}
 
@@ -942,7 +937,7 @@ func (eb *ExecutableBuilder) EmitArenaRuntimeCode() {
 	// Argument: rdi = capacity
 	// Returns: rax = arena_ptr (pointer to 32-byte arena structure)
 	// Arena structure: [buffer_ptr, capacity, offset, alignment] = 32 bytes
-	eb.MarkLabel("c67_arena_create")
+	eb.MarkFunction("c67_arena_create")
 	out.PushReg("rbp")
 	out.MovRegToReg("rbp", "rsp")
 	out.PushReg("rbx")
@@ -1004,7 +999,7 @@ func (eb *ExecutableBuilder) EmitArenaRuntimeCode() {
 
 	// c67_arena_destroy(arena_ptr) - destroys an arena
 	// Argument: rdi = arena_ptr
-	eb.MarkLabel("c67_arena_destroy")
+	eb.MarkFunction("c67_arena_destroy")
 	out.PushReg("rbp")
 	out.MovRegToReg("rbp", "rsp")
 	out.PushReg("rbx")
@@ -1118,6 +1113,11 @@ func (eb *ExecutableBuilder) patchRodataInELF() {
 
 	rodataOffset := int(eb.rodataOffsetInELF)
 	rodataSize := len(newRodata)
+	// Symbols added after the ELF was laid out must not spill into .data
+	if dataOffset := int(eb.dataOffsetInELF); dataOffset > rodataOffset && rodataOffset+rodataSize > dataOffset {
+		rodataSize = dataOffset - rodataOffset
+		newRodata = newRodata[:rodataSize]
+	}
 
 	if rodataOffset > 0 && rodataOffset+rodataSize <= len(elfBuf) {
 		copy(elfBuf[rodataOffset:rodataOffset+rodataSize], newRodata)
@@ -1184,6 +1184,74 @@ var WPOTimeout float64
 var SingleFlag bool
 var CompressFlag bool
 
+// LazyFFIFlag makes namespaced C calls resolve with dlopen/dlsym at their
+// first call instead of linking the library, from --lazy-ffi
+var LazyFFIFlag bool
+
+// GCFlag allocates lists, maps and strings outside arena blocks from a
+// garbage-collected heap, from --gc
+var GCFlag bool
+
+// OmitFramePointerFlag compiles leaf lambdas without a frame, from
+// --fomit-frame-pointer (see leaf.go)
+var OmitFramePointerFlag bool
+
+// SandboxFlag rejects system access at compile time and installs a seccomp
+// filter at startup, from --sandbox (see sandbox.go)
+var SandboxFlag bool
+
+// EntryFlag names the function the program starts at instead of main, from
+// --entry (see entry.go)
+var EntryFlag string
+
+// NoMainFlag builds a freestanding static executable without libc, from
+// --no-main (see entry.go)
+var NoMainFlag bool
+
+// EmitManifestFlag writes a JSON manifest of the inputs next to the
+// executable, from --emit-manifest (see manifest.go)
+var EmitManifestFlag bool
+
+// AllocFlag is how the runtime allocates: "arena" or "malloc", from
+// --alloc (see alloc_malloc.go)
+var AllocFlag = "arena"
+
+// TraceCountsFlag counts the calls of lambdas and the allocations of arenas
+// and reports them at exit, from --trace-counts (see trace.go)
+var TraceCountsFlag bool
+
+// KeepTempsFlag writes the stages of the compilation to <output>.temps,
+// from --keep-temps (see keeptemps.go)
+var KeepTempsFlag bool
+
+// EmitHeaderFlag writes a C header declaring the exported functions next
+// to the executable, from --emit-header (see header.go)
+var EmitHeaderFlag bool
+
+// EmitPythonFlag writes a Python module that calls the exported functions
+// with ctypes, from --emit-python (see pybind.go)
+var EmitPythonFlag bool
+
+// OfflineFlag forbids cloning and fetching Git imports and dependencies,
+// from --offline (see offline.go)
+var OfflineFlag bool
+
+// RiscvZbbFlag lets riscv64 code use the Zbb bit manipulation instructions
+// for popcount, clz, ctz and bswap, from --zbb (see bits.go)
+var RiscvZbbFlag bool
+
+// LibrarySearchPaths are the -L directories searched for imported C
+// libraries before ldconfig, and RPathFlag and RunPathFlag the --rpath and
+// --runpath library search paths written into the executable
+var LibrarySearchPaths []string
+var RPathFlag string
+var RunPathFlag string
+
+// Limits for loops without a max clause and for recursive calls without a
+// max, from --default-loop-max and --default-recursion-max. 0 means no default.
+var DefaultLoopMax int64
+var DefaultRecursionMax int64
+
 func main() {
 	// Create default output filename in system temp directory
 	defaultOutputFilename := filepath.Join(os.TempDir(), "main")
@@ -1218,7 +1286,31 @@ func main() {
 	var singleFlag = flag.Bool("single", false, "compile single file only (don't load other .c67 files from directory)")
 	var singleShort = flag.Bool("s", false, "shorthand for --single")
 	var compressFlag = flag.Bool("compress", false, "enable executable compression (experimental)")
+	var lazyFFIFlag = flag.Bool("lazy-ffi", false, "resolve alias.Function C calls with dlopen/dlsym at their first call instead of linking the libraries (Linux x86-64)")
+	var gcFlag = flag.Bool("gc", false, "allocate lists, maps and strings outside arena blocks from a garbage-collected heap (Linux x86-64)")
+	var zbbFlag = flag.Bool("zbb", false, "use the Zbb instructions (cpop, clz, ctz, rev8) for popcount, clz, ctz and bswap on riscv64, which RVA22 cores have")
+	var sandboxFlag = flag.Bool("sandbox", false, "for untrusted scripts: reject unsafe blocks, C imports and built-ins that write files, run commands or use the network, and install a seccomp filter at startup (Linux x86-64)")
+	var entryFlag = flag.String("entry", "", "call this function instead of main when the program starts (x86-64)")
+	var noMainFlag = flag.Bool("no-main", false, "freestanding: build a static executable without libc whose _start calls main, or the --entry function, and exits with its result (Linux x86-64)")
+	var emitManifestFlag = flag.Bool("emit-manifest", false, "write <output>.manifest.json with the compiler version, target, source files, Git import commits and C libraries, with their SHA-256")
+	var allocFlag = flag.String("alloc", "arena", "how allocations are made: arena, or malloc for one malloc/free pair per allocation, so Valgrind and ASan can check them (Linux x86-64)")
+	var traceCountsFlag = flag.Bool("trace-counts", false, "count the calls and cycles of every lambda and the allocations of every arena, and write them to stderr at exit (Linux x86-64)")
+	var keepTempsFlag = flag.Bool("keep-temps", false, "write the tokens, the AST before and after optimization, the sections and the relocations to <output>.temps")
+	var emitHeaderFlag = flag.Bool("emit-header", false, "write a C header next to the executable, with a double fn(double, ...) prototype for each exported function")
+	var offlineFlag = flag.Bool("offline", false, "never clone or fetch Git imports and dependencies; fail if one is not in the cache")
+	var emitPythonFlag = flag.Bool("emit-python", false, "write a Python module next to the output that loads it with ctypes and wraps each exported function, for a shared library build")
+	var omitFramePointerFlag = flag.Bool("fomit-frame-pointer", false, "compile lambdas that only do arithmetic on their parameters without a stack frame (x86-64, ignored when DEBUG is set)")
+	var rpathFlag, runpathFlag searchPathList
+	flag.Var(defineFlag{}, "D", "define NAME=value for defined(\"NAME\") and cfg(\"NAME\"); NAME alone is 1 (can be repeated)")
+	flag.Var((*searchPathList)(&LibrarySearchPaths), "L", "directory to search for imported C libraries before ldconfig (can be repeated)")
+	flag.Var(&rpathFlag, "rpath", "directory to write into DT_RPATH of the executable, e.g. '$ORIGIN/lib' (can be repeated)")
+	flag.Var(&runpathFlag, "runpath", "directory to write into DT_RUNPATH of the executable, e.g. '$ORIGIN/lib' (can be repeated)")
 	_ = flag.Bool("tiny", false, "size optimization mode: remove debug strings and minimize runtime checks for demoscene/64k")
+	var defaultLoopMax = flag.Int64("default-loop-max", envInt64("C67_DEFAULT_LOOP_MAX"), "iteration limit for loops without a max clause (0: max is required)")
+	var defaultRecursionMax = flag.Int64("default-recursion-max", envInt64("C67_DEFAULT_RECURSION_MAX"), "depth limit for recursive calls without max (0: unlimited)")
+	var stackSize = flag.Int64("stack-size", DefaultStackSize, "largest stack frame in bytes that a function may need, checked at compile time (0: no check)")
+	var colorFlag = flag.String("color", "auto", "color diagnostics: auto (when stderr is a terminal and NO_COLOR is not set), always or never")
+	var logLevel = flag.String("log-level", "info", "minimum level for logdebug/loginfo/logwarn/logerror when C67_LOG_LEVEL is not set (debug, info, warn, error, off)")
 	flag.Parse()
 
 	// Set global update-deps flag (use whichever was specified)
@@ -1227,6 +1319,56 @@ func main() {
 	// Set global single flag (use whichever was specified)
 	SingleFlag = *singleFlag || *singleShort
 	CompressFlag = *compressFlag
+	LazyFFIFlag = *lazyFFIFlag
+	GCFlag = *gcFlag
+	OmitFramePointerFlag = *omitFramePointerFlag
+	RiscvZbbFlag = *zbbFlag
+	SandboxFlag = *sandboxFlag
+	EntryFlag = *entryFlag
+	NoMainFlag = *noMainFlag
+	EmitManifestFlag = *emitManifestFlag
+	TraceCountsFlag = *traceCountsFlag
+	KeepTempsFlag = *keepTempsFlag
+	EmitHeaderFlag = *emitHeaderFlag
+	EmitPythonFlag = *emitPythonFlag
+	OfflineFlag = *offlineFlag
+	if OfflineFlag && UpdateDepsFlag {
+		fmt.Fprintf(os.Stderr, "Error: --update-deps fetches from Git, which --offline does not allow\n")
+		os.Exit(1)
+	}
+	switch *allocFlag {
+	case "arena", "malloc":
+		AllocFlag = *allocFlag
+	default:
+		fmt.Fprintf(os.Stderr, "Error: --alloc must be arena or malloc, not %q\n", *allocFlag)
+		os.Exit(1)
+	}
+	RPathFlag = rpathFlag.String()
+	RunPathFlag = runpathFlag.String()
+	if *defaultLoopMax < 0 || *defaultRecursionMax < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --default-loop-max and --default-recursion-max must not be negative\n")
+		os.Exit(1)
+	}
+	if *stackSize < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --stack-size must not be negative\n")
+		os.Exit(1)
+	}
+	StackSizeLimit = *stackSize
+	switch *colorFlag {
+	case "auto", "always", "never":
+		ColorMode = *colorFlag
+	default:
+		fmt.Fprintf(os.Stderr, "Error: --color must be auto, always or never, not %q\n", *colorFlag)
+		os.Exit(1)
+	}
+	DefaultLoopMax = *defaultLoopMax
+	DefaultRecursionMax = *defaultRecursionMax
+	if level, err := parseLogLevel(*logLevel); err == nil {
+		DefaultLogLevel = level
+	} else {
+		fmt.Fprintf(os.Stderr, "Error: --log-level: %v\n", err)
+		os.Exit(1)
+	}
 
 	if *version || *versionShort {
 		fmt.Println(versionString)
@@ -1361,7 +1503,7 @@ func main() {
 	if len(inputFiles) > 0 {
 		firstArg := inputFiles[0]
 		// Check if it's a subcommand or looks like the new CLI style
-		if firstArg == "build" || firstArg == "run" || firstArg == "test" || firstArg == "help" ||
+		if firstArg == "build" || firstArg == "run" || firstArg == "test" || firstArg == "doc" || firstArg == "selftest" || firstArg == "hash" || firstArg == "inspect" || firstArg == "help" ||
 			(strings.HasSuffix(firstArg, ".c67") && *codeFlag == "") {
 			// Use new CLI system
 			// Only pass outputFilename if user explicitly provided it
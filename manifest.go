@@ -0,0 +1,171 @@
+// Completion: 100% - Build manifest with --emit-manifest
+package main
+
+import (
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// manifest.go - What went into an executable, from --emit-manifest
+//
+//	c67 --emit-manifest app.c67 -o app   # also writes app.manifest.json
+//
+// The manifest records the compiler version, the target, every .c67 file
+// that was compiled in (the input, its siblings, imports and dependencies),
+// the commit each Git import was checked out at, and the shared libraries
+// the executable needs, with the file each one resolves to here. Files have
+// their SHA-256, and the executable its build ID (see buildid.go), so a
+// build can be audited, or repeated and compared, and a deployed executable
+// traced back to it:
+//
+//	{
+//	  "compiler": "c67 1.5.2",
+//	  "targets": ["amd64-linux"],
+//	  "output": {"path": "app", "sha256": "9f2c...", "build_id": "5c0e..."},
+//	  "sources": [{"path": "/home/me/app/app.c67", "sha256": "41d8..."}],
+//	  "git_imports": [{"url": "https://github.com/xyproto/c67-math",
+//	                   "version": "v1.2.0", "commit": "3b18e51..."}],
+//	  "c_libraries": [{"soname": "libc.so.6",
+//	                   "path": "/usr/lib/libc.so.6", "sha256": "77ae..."}]
+//	}
+//
+// A library that can not be found here has no path, and a Git import that
+// is not a checkout has no commit.
+
+// BuildManifest is the manifest of one executable
+type BuildManifest struct {
+	Compiler   string             `json:"compiler"`
+	Targets    []string           `json:"targets"`
+	Output     ManifestFile       `json:"output"`
+	Sources    []ManifestFile     `json:"sources"`
+	GitImports []ManifestImport   `json:"git_imports"`
+	CLibraries []ManifestCLibrary `json:"c_libraries"`
+}
+
+// ManifestFile is a file and its SHA-256, and the build ID of an executable
+type ManifestFile struct {
+	Path    string `json:"path"`
+	SHA256  string `json:"sha256"`
+	BuildID string `json:"build_id,omitempty"`
+}
+
+// ManifestImport is a Git repository that code was imported from
+type ManifestImport struct {
+	URL     string `json:"url"`
+	Version string `json:"version,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+}
+
+// ManifestCLibrary is a shared library that the executable needs
+type ManifestCLibrary struct {
+	Soname string `json:"soname"`
+	Path   string `json:"path,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// buildManifest collects the manifest of the executable being built, and
+// is nil without --emit-manifest
+var buildManifest *BuildManifest
+
+// manifestPath returns where the manifest of the executable at outputPath goes
+func manifestPath(outputPath string) string {
+	return outputPath + ".manifest.json"
+}
+
+// sha256Hex returns the SHA-256 of data in hex
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// addTarget records a platform that the executable is built for
+func (m *BuildManifest) addTarget(platform Platform) {
+	if m == nil {
+		return
+	}
+	m.Targets = append(m.Targets, platform.FullString())
+}
+
+// addSource records a .c67 file that was compiled in
+func (m *BuildManifest) addSource(path string, content []byte) {
+	if m == nil {
+		return
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	for _, source := range m.Sources {
+		if source.Path == path {
+			return
+		}
+	}
+	m.Sources = append(m.Sources, ManifestFile{Path: path, SHA256: sha256Hex(content)})
+}
+
+// addGitImport records a Git repository checked out at repoPath
+func (m *BuildManifest) addGitImport(url, version, repoPath string) {
+	if m == nil {
+		return
+	}
+	for _, imp := range m.GitImports {
+		if imp.URL == url && imp.Version == version {
+			return
+		}
+	}
+	imp := ManifestImport{URL: url, Version: version}
+	if output, err := exec.Command("git", "-C", repoPath, "rev-parse", "HEAD").Output(); err == nil {
+		imp.Commit = strings.TrimSpace(string(output))
+	}
+	m.GitImports = append(m.GitImports, imp)
+}
+
+// write finishes the manifest with the executable at outputPath and the
+// libraries it needs, and writes it next to it
+func (m *BuildManifest) write(outputPath string) error {
+	executable, err := os.ReadFile(outputPath)
+	if err != nil {
+		return err
+	}
+	m.Output = ManifestFile{Path: outputPath, SHA256: sha256Hex(executable)}
+	m.Output.BuildID, _ = readBuildInfo(outputPath)
+
+	// Only ELF executables name their libraries this way
+	if f, err := elf.Open(outputPath); err == nil {
+		needed, _ := f.DynString(elf.DT_NEEDED)
+		f.Close()
+		for _, soname := range needed {
+			library := ManifestCLibrary{Soname: soname}
+			if paths := sharedObjectPaths(soname); len(paths) > 0 {
+				library.Path = paths[0]
+				if data, err := os.ReadFile(library.Path); err == nil {
+					library.SHA256 = sha256Hex(data)
+				}
+			}
+			m.CLibraries = append(m.CLibraries, library)
+		}
+	}
+
+	sort.Slice(m.Sources, func(i, j int) bool { return m.Sources[i].Path < m.Sources[j].Path })
+	sort.Slice(m.GitImports, func(i, j int) bool { return m.GitImports[i].URL < m.GitImports[j].URL })
+	if m.Sources == nil {
+		m.Sources = []ManifestFile{}
+	}
+	if m.GitImports == nil {
+		m.GitImports = []ManifestImport{}
+	}
+	if m.CLibraries == nil {
+		m.CLibraries = []ManifestCLibrary{}
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(manifestPath(outputPath), append(data, '\n'), 0o644)
+}
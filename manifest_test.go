@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestEmitManifest tests that --emit-manifest records the sources, with
+// imports, the target and the hash of the executable
+func TestEmitManifest(t *testing.T) {
+	defer func() { EmitManifestFlag = false }()
+	EmitManifestFlag = true
+
+	dir := t.TempDir()
+	app := filepath.Join(dir, "app.c67")
+	util := filepath.Join(dir, "lib", "util.c67")
+	if err := os.Mkdir(filepath.Join(dir, "lib"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(util, []byte("double = x -> x * 2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	appSource := []byte("import \"./lib\" as lib\nprintln(lib.double(21))\n")
+	if err := os.WriteFile(app, appSource, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "app")
+	if err := CompileC67(app, exe, Platform{Arch: ArchX86_64, OS: OSLinux}); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	data, err := os.ReadFile(manifestPath(exe))
+	if err != nil {
+		t.Fatalf("Expected a manifest: %v", err)
+	}
+	var manifest BuildManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("The manifest is not JSON: %v", err)
+	}
+	executable, _ := os.ReadFile(exe)
+	if manifest.Compiler != versionString || len(manifest.Targets) != 1 || manifest.Targets[0] != "amd64-linux" {
+		t.Errorf("Expected %s for amd64-linux, got %s for %v", versionString, manifest.Compiler, manifest.Targets)
+	}
+	if manifest.Output.SHA256 != sha256Hex(executable) {
+		t.Errorf("Expected the SHA-256 of the executable, got %s", manifest.Output.SHA256)
+	}
+	if len(manifest.Sources) != 2 || manifest.Sources[0].Path != app || manifest.Sources[1].Path != util {
+		t.Fatalf("Expected %s and %s as sources, got %v", app, util, manifest.Sources)
+	}
+	if manifest.Sources[0].SHA256 != sha256Hex(appSource) {
+		t.Errorf("Expected the SHA-256 of %s, got %s", app, manifest.Sources[0].SHA256)
+	}
+}
@@ -0,0 +1,682 @@
+// Completion: 100% - Hash-indexed maps
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// mapindex.go - Hash index for mutable maps
+//
+// A map literal is a constant in .rodata, and m[key] scans its entries, which
+// is fine for a handful of keys. A map that a program builds up,
+//
+//	seen := {}
+//	@ n in numbers {
+//	    seen[n] <- seen[n] + 1
+//	}
+//
+// would take O(n) per lookup and could not grow at all. A map literal bound
+// with := is therefore allocated with room to grow and a hash index in front
+// of the count:
+//
+//	[ptr-32] index: size control bytes, then size slots (uint64), or 0
+//	[ptr-24] index size, a power of two and at least one group, or 0
+//	[ptr-16] tombstones in the index
+//	[ptr-8]  entry capacity
+//	[ptr+0]  count (float64)
+//	[ptr+8]  key0, val0, key1, val1, ...
+//
+// The entries keep the ordinary map layout, in insertion order, so #m, loops
+// and everything else that reads a map work unchanged. The index is the open
+// addressing table of the C67HashMap model in hashmap.go: a probe compares a
+// group of 16 control bytes with the hash at once, using SSE2, and a slot
+// holds an entry number. Past a load factor of 0.85, counting tombstones, the
+// index is rebuilt, twice as large if the live keys need it. When the entries
+// are full they are moved to a block twice the size, so m[key] <- value
+// stores the map pointer back into m.
+//
+// Most maps never hold more than a few keys, and scanning up to
+// mapSmallLimit entries is as fast as probing, so a small map has no index:
+// the index pointer is 0 and lookups scan the entries, which live in the same
+// block as the header. Inserting the key past the limit builds the index.
+//
+// delete(m, key) marks the slot as a tombstone and moves the last entry into
+// the gap. Keys are compared as numbers, and -0 is hashed as 0. This is the
+// x86-64 runtime; on ARM64, map variables keep the linear layout.
+
+// mapHeaderSize is the number of bytes in front of the count field
+const mapHeaderSize = 32
+
+// mapMinCapacity is the smallest entry capacity of a hash-indexed map
+const mapMinCapacity = 8
+
+// isHashMapAssign reports whether s binds a map literal to a variable that
+// uses the hash index
+func (fc *C67Compiler) isHashMapAssign(s *AssignStmt) bool {
+	_, isMap := s.Value.(*MapExpr)
+	return isMap && fc.hashMapVars[s.Name]
+}
+
+// untrackHashMap stops using the hash index for the variable that s assigns,
+// unless s assigns a map literal, which gets an index of its own
+func (fc *C67Compiler) untrackHashMap(s *AssignStmt) {
+	if _, isMap := s.Value.(*MapExpr); !isMap {
+		delete(fc.hashMapVars, s.Name)
+	}
+}
+
+// loadMapArena loads the arena of the map in the variable name into reg:
+// the arena the variable was defined in, so that growing the map inside an
+// arena block does not move it into memory the block frees
+func (fc *C67Compiler) loadMapArena(reg, name string) {
+	if level, known := fc.varArenas[name]; known && level < fc.arenaLevel() && fc.threadArenaOffset == 0 {
+		fc.out.LeaSymbolToReg(reg, "_c67_arena_meta")
+		fc.out.MovMemToReg(reg, reg, 0)
+		fc.out.MovMemToReg(reg, reg, (level-1)*8)
+		return
+	}
+	fc.loadArenaPointer(reg)
+}
+
+// compileHashMapLiteral builds the map literal e for the variable name,
+// leaving the map pointer in xmm0
+func (fc *C67Compiler) compileHashMapLiteral(name string, e *MapExpr) {
+	fc.usesHashMap = true
+	fc.out.MovImmToReg("rdi", fmt.Sprintf("%d", max(len(e.Keys), mapMinCapacity)))
+	fc.loadMapArena("rsi", name)
+	fc.trackFunctionCall("_c67_map_new")
+	fc.eb.GenerateCallInstruction("_c67_map_new")
+
+	// The map pointer stays on the stack while keys and values are computed
+	fc.out.SubImmFromReg("rsp", 16)
+	fc.out.MovRegToMem("rax", "rsp", 0)
+	for i := range e.Keys {
+		fc.compileExpression(e.Keys[i])
+		fc.out.MovXmmToMem("xmm0", "rsp", StackSlotSize)
+		fc.compileExpression(e.Values[i])
+		fc.out.MovXmmToXmm("xmm1", "xmm0")
+		fc.out.MovMemToXmm("xmm0", "rsp", StackSlotSize)
+		fc.out.MovMemToReg("rdi", "rsp", 0)
+		fc.loadMapArena("rsi", name)
+		fc.trackFunctionCall("_c67_map_set")
+		fc.eb.GenerateCallInstruction("_c67_map_set")
+		fc.out.MovRegToMem("rax", "rsp", 0)
+	}
+	fc.out.MovMemToXmm("xmm0", "rsp", 0)
+	fc.out.AddImmToReg("rsp", 16)
+}
+
+// compileHashMapGet compiles name[key] for a hash-indexed map
+func (fc *C67Compiler) compileHashMapGet(name string, key Expression) {
+	fc.usesHashMap = true
+	fc.compileExpression(key)
+	fc.out.SubImmFromReg("rsp", 16)
+	fc.out.MovXmmToMem("xmm0", "rsp", 0)
+	fc.compileExpression(&IdentExpr{Name: name})
+	fc.out.MovqXmmToReg("rdi", "xmm0")
+	fc.out.MovMemToXmm("xmm0", "rsp", 0)
+	fc.out.AddImmToReg("rsp", 16)
+	fc.trackFunctionCall("_c67_map_get")
+	fc.eb.GenerateCallInstruction("_c67_map_get")
+}
+
+// compileHashMapSet compiles name[key] <- value for a hash-indexed map. The
+// map may move when it grows, so the new pointer is stored back into the
+// variable at baseReg-offset, or in .data for a global.
+func (fc *C67Compiler) compileHashMapSet(s *MapUpdateStmt, isGlobal bool, baseReg string, offset int) {
+	fc.usesHashMap = true
+	fc.compileExpression(s.Index)
+	fc.out.SubImmFromReg("rsp", 16)
+	fc.out.MovXmmToMem("xmm0", "rsp", 0)
+	fc.compileExpression(s.Value)
+	fc.compileEscapingStore(s.MapName, s.Value, true)
+	fc.out.MovXmmToMem("xmm0", "rsp", StackSlotSize)
+
+	if isGlobal {
+		fc.out.LeaSymbolToReg("rax", "_global_"+s.MapName)
+		fc.out.MovMemToReg("rdi", "rax", 0)
+	} else {
+		fc.out.MovMemToReg("rdi", baseReg, -offset)
+	}
+	fc.loadMapArena("rsi", s.MapName)
+	fc.out.MovMemToXmm("xmm0", "rsp", 0)
+	fc.out.MovMemToXmm("xmm1", "rsp", StackSlotSize)
+	fc.out.AddImmToReg("rsp", 16)
+	fc.trackFunctionCall("_c67_map_set")
+	fc.eb.GenerateCallInstruction("_c67_map_set")
+
+	if isGlobal {
+		fc.out.LeaSymbolToReg("rcx", "_global_"+s.MapName)
+		fc.out.MovRegToMem("rax", "rcx", 0)
+	} else {
+		fc.out.MovRegToMem("rax", baseReg, -offset)
+	}
+}
+
+// compileDeleteCall compiles delete(m, key), which removes key from the map
+// variable m and returns 1 if it was there, else 0
+func (fc *C67Compiler) compileDeleteCall(call *CallExpr) {
+	if len(call.Args) != 2 {
+		compilerError("delete() requires exactly 2 arguments (map, key)")
+	}
+	ident, ok := call.Args[0].(*IdentExpr)
+	if !ok || !fc.hashMapVars[ident.Name] {
+		compilerError("delete() needs a map variable defined with := {...}, got %s", call.Args[0])
+	}
+	fc.usesHashMap = true
+	fc.compileExpression(call.Args[1])
+	fc.out.SubImmFromReg("rsp", 16)
+	fc.out.MovXmmToMem("xmm0", "rsp", 0)
+	fc.compileExpression(ident)
+	fc.out.MovqXmmToReg("rdi", "xmm0")
+	fc.out.MovMemToXmm("xmm0", "rsp", 0)
+	fc.out.AddImmToReg("rsp", 16)
+	fc.trackFunctionCall("_c67_map_delete")
+	fc.eb.GenerateCallInstruction("_c67_map_delete")
+}
+
+// generateHashMapHelpers emits the runtime of hash-indexed maps. The public
+// helpers save the registers they use beyond rax, rcx, rdx, rsi, rdi, r8-r10
+// and xmm0-xmm5; r11 is never touched, since it holds the parent frame in
+// parallel loop bodies.
+func (fc *C67Compiler) generateHashMapHelpers() {
+	fc.generateMapProbe()
+	fc.generateMapPlace()
+	fc.generateMapRehash()
+	fc.generateMapNew()
+	fc.generateMapGet()
+	fc.generateMapSet()
+	fc.generateMapDelete()
+}
+
+// emitMapHash sets r13 to mapHash of the bits of xmm0. Clobbers rax and rdx.
+func (fc *C67Compiler) emitMapHash() {
+	fc.out.MovqXmmToReg("rax", "xmm0")
+	fc.out.MovImmToReg("rdx", strconv.FormatUint(mapHashMultiplier, 10))
+	fc.out.ImulRegWithReg("rax", "rdx")
+	fc.out.MovRegToReg("r13", "rax")
+	fc.out.ShrRegByImm("rax", 32)
+	fc.out.XorRegWithReg("r13", "rax")
+}
+
+// emitMapNormalizeKey turns a -0 key in xmm0 into 0, so both hash alike
+func (fc *C67Compiler) emitMapNormalizeKey() {
+	fc.out.XorpdXmm("xmm5", "xmm5")
+	fc.out.AddsdXmm("xmm0", "xmm5")
+}
+
+// emitMapIndexRegs loads the index of the map in r12: r10 = control bytes,
+// r8 = slots, r9 = size - 1, and rsi = the first group to probe for the
+// hash in r13
+func (fc *C67Compiler) emitMapIndexRegs() {
+	fc.out.MovMemToReg("r10", "r12", -32)
+	fc.out.MovMemToReg("r9", "r12", -24)
+	fc.out.Emit([]byte{0x4f, 0x8d, 0x04, 0x0a}) // lea r8, [r10+r9]
+	fc.out.DecReg("r9")
+	fc.out.MovRegToReg("rsi", "r13")
+	fc.out.ShrRegByImm("rsi", 3)
+	fc.out.AndRegWithReg("rsi", "r9")
+	fc.out.AndRegWithImm("rsi", -mapGroupSize)
+}
+
+// emitNextMapGroup moves rsi on to the next group, wrapping around, and
+// jumps back to loop
+func (fc *C67Compiler) emitNextMapGroup(loop int) {
+	fc.out.AddImmToReg("rsi", mapGroupSize)
+	fc.out.AndRegWithReg("rsi", "r9")
+	fc.out.JumpUnconditional(int32(loop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+}
+
+// emitMapIndexSize sets rdi to mapIndexSize of the count in rcx. Clobbers
+// rax and rcx.
+func (fc *C67Compiler) emitMapIndexSize() {
+	fc.out.ImulImmToReg("rcx", 100)
+	fc.out.MovImmToReg("rdi", fmt.Sprintf("%d", mapGroupSize))
+	loop := fc.eb.text.Len()
+	fc.out.MovRegToReg("rax", "rdi")
+	fc.out.ImulImmToReg("rax", mapMaxLoadPercent)
+	fc.out.CmpRegToReg("rcx", "rax")
+	sized := fc.forwardJump(JumpLessOrEqual)
+	fc.out.ShlRegByImm("rdi", 1)
+	fc.out.JumpUnconditional(int32(loop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(sized)
+}
+
+// generateMapProbe emits _c67_map_probe(r12=map, xmm0=key), which finds the
+// entry of key. Returns rax = the entry address or 0, rbx = the address of
+// its control byte, or 0 in a small map, and r13 = the hash of the key.
+// Clobbers rcx, rdx, rsi, rdi, r8-r10 and xmm1-xmm5.
+func (fc *C67Compiler) generateMapProbe() {
+	fc.eb.MarkFunction("_c67_map_probe")
+	fc.emitMapHash()
+	fc.out.MovMemToReg("rax", "r12", -32)
+	fc.out.TestRegReg("rax", "rax")
+	indexed := fc.forwardJump(JumpNotEqual)
+
+	// A small map: scan the entries
+	fc.out.XorRegWithReg("rbx", "rbx")
+	fc.out.MovMemToXmm("xmm5", "r12", 0)
+	fc.out.Cvttsd2si("rcx", "xmm5")
+	fc.out.LeaMemToReg("rax", "r12", 8)
+	scanLoop := fc.eb.text.Len()
+	fc.out.TestRegReg("rcx", "rcx")
+	scanned := fc.forwardJump(JumpEqual)
+	fc.out.MovMemToXmm("xmm5", "rax", 0)
+	fc.out.Ucomisd("xmm5", "xmm0")
+	unordered := fc.forwardJump(JumpParity)
+	next := fc.forwardJump(JumpNotEqual)
+	fc.out.Ret()
+	fc.landForwardJump(unordered)
+	fc.landForwardJump(next)
+	fc.out.AddImmToReg("rax", 16)
+	fc.out.DecReg("rcx")
+	fc.out.JumpUnconditional(int32(scanLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(scanned)
+	fc.out.XorRegWithReg("rax", "rax")
+	fc.out.Ret()
+	fc.landForwardJump(indexed)
+
+	// xmm1 = 16 copies of the control byte for the hash, xmm4 = of empty
+	fc.out.MovRegToReg("rax", "r13")
+	fc.out.ShrRegByImm("rax", 57)
+	fc.out.Emit([]byte{0x66, 0x0f, 0x6e, 0xc8})       // movd xmm1, eax
+	fc.out.Emit([]byte{0x66, 0x0f, 0x60, 0xc9})       // punpcklbw xmm1, xmm1
+	fc.out.Emit([]byte{0x66, 0x0f, 0x61, 0xc9})       // punpcklwd xmm1, xmm1
+	fc.out.Emit([]byte{0x66, 0x0f, 0x70, 0xc9, 0x00}) // pshufd xmm1, xmm1, 0
+	fc.out.MovImmToReg("rax", fmt.Sprintf("%d", mapCtrlEmpty))
+	fc.out.Emit([]byte{0x66, 0x0f, 0x6e, 0xe0})       // movd xmm4, eax
+	fc.out.Emit([]byte{0x66, 0x0f, 0x60, 0xe4})       // punpcklbw xmm4, xmm4
+	fc.out.Emit([]byte{0x66, 0x0f, 0x61, 0xe4})       // punpcklwd xmm4, xmm4
+	fc.out.Emit([]byte{0x66, 0x0f, 0x70, 0xe4, 0x00}) // pshufd xmm4, xmm4, 0
+	fc.emitMapIndexRegs()
+
+	groupLoop := fc.eb.text.Len()
+	fc.out.Emit([]byte{0xf3, 0x41, 0x0f, 0x6f, 0x14, 0x32}) // movdqu xmm2, [r10+rsi]
+	fc.out.Emit([]byte{0x66, 0x0f, 0x6f, 0xda})             // movdqa xmm3, xmm2
+	fc.out.Emit([]byte{0x66, 0x0f, 0x74, 0xd9})             // pcmpeqb xmm3, xmm1
+	fc.out.Emit([]byte{0x66, 0x0f, 0xd7, 0xd3})             // pmovmskb edx, xmm3
+
+	// Check the slots whose control byte matches, lowest first
+	matchLoop := fc.eb.text.Len()
+	fc.out.TestRegReg("rdx", "rdx")
+	noMatch := fc.forwardJump(JumpEqual)
+	fc.out.Emit([]byte{0x0f, 0xbc, 0xca})       // bsf ecx, edx
+	fc.out.Emit([]byte{0x0f, 0xb3, 0xca})       // btr edx, ecx
+	fc.out.Emit([]byte{0x48, 0x8d, 0x3c, 0x0e}) // lea rdi, [rsi+rcx]
+	fc.out.Emit([]byte{0x49, 0x8b, 0x04, 0xf8}) // mov rax, [r8+rdi*8]
+	fc.out.ShlRegByImm("rax", 4)
+	fc.out.AddRegToReg("rax", "r12")
+	fc.out.AddImmToReg("rax", 8) // rax = entry address
+	fc.out.MovMemToXmm("xmm5", "rax", 0)
+	fc.out.Ucomisd("xmm5", "xmm0")
+	fc.out.JumpConditional(JumpParity, int32(matchLoop-(fc.eb.text.Len()+ConditionalJumpSize)))
+	fc.out.JumpConditional(JumpNotEqual, int32(matchLoop-(fc.eb.text.Len()+ConditionalJumpSize)))
+	fc.out.Emit([]byte{0x49, 0x8d, 0x1c, 0x3a}) // lea rbx, [r10+rdi]
+	fc.out.Ret()
+
+	// An empty slot in the group ends the probe, else go on to the next group
+	fc.landForwardJump(noMatch)
+	fc.out.Emit([]byte{0x66, 0x0f, 0x6f, 0xda}) // movdqa xmm3, xmm2
+	fc.out.Emit([]byte{0x66, 0x0f, 0x74, 0xdc}) // pcmpeqb xmm3, xmm4
+	fc.out.Emit([]byte{0x66, 0x0f, 0xd7, 0xd3}) // pmovmskb edx, xmm3
+	fc.out.TestRegReg("rdx", "rdx")
+	notFound := fc.forwardJump(JumpNotEqual)
+	fc.emitNextMapGroup(groupLoop)
+
+	fc.landForwardJump(notFound)
+	fc.out.XorRegWithReg("rax", "rax")
+	fc.out.Ret()
+}
+
+// generateMapPlace emits _c67_map_place(r12=map, r13=hash, rdi=entry
+// number), which puts the entry in the first empty or deleted slot for the
+// hash. Clobbers rax, rcx, rdx, rsi, r8-r10 and xmm2.
+func (fc *C67Compiler) generateMapPlace() {
+	fc.eb.MarkFunction("_c67_map_place")
+	fc.emitMapIndexRegs()
+
+	// Empty and deleted control bytes are the ones with the top bit set
+	groupLoop := fc.eb.text.Len()
+	fc.out.Emit([]byte{0xf3, 0x41, 0x0f, 0x6f, 0x14, 0x32}) // movdqu xmm2, [r10+rsi]
+	fc.out.Emit([]byte{0x66, 0x0f, 0xd7, 0xd2})             // pmovmskb edx, xmm2
+	fc.out.TestRegReg("rdx", "rdx")
+	found := fc.forwardJump(JumpNotEqual)
+	fc.emitNextMapGroup(groupLoop)
+
+	fc.landForwardJump(found)
+	fc.out.Emit([]byte{0x0f, 0xbc, 0xca}) // bsf ecx, edx
+	fc.out.AddRegToReg("rsi", "rcx")
+	fc.out.Emit([]byte{0x41, 0x80, 0x3c, 0x32, mapCtrlDeleted}) // cmp byte [r10+rsi], mapCtrlDeleted
+	fresh := fc.forwardJump(JumpNotEqual)
+	fc.out.Emit([]byte{0x49, 0xff, 0x4c, 0x24, 0xf0}) // dec qword [r12-16]
+	fc.landForwardJump(fresh)
+	fc.out.MovRegToReg("rax", "r13")
+	fc.out.ShrRegByImm("rax", 57)
+	fc.out.Emit([]byte{0x41, 0x88, 0x04, 0x32}) // mov [r10+rsi], al
+	fc.out.Emit([]byte{0x49, 0x89, 0x3c, 0xf0}) // mov [r8+rsi*8], rdi
+	fc.out.Ret()
+}
+
+// emitMapArenaAlloc allocates rsi bytes from the arena in r15 for the map
+// in r12, returning the block in rax. Growing the arena reallocates its
+// buffer, which can move the map, so r12 is rebased on the buffer across
+// the call. Clobbers the caller-saved registers.
+func (fc *C67Compiler) emitMapArenaAlloc() {
+	fc.out.MovMemToReg("rcx", "r15", 0)
+	fc.out.SubRegFromReg("r12", "rcx")
+	fc.out.MovRegToReg("rdi", "r15")
+	fc.trackFunctionCall("c67_arena_alloc")
+	fc.out.CallSymbol("c67_arena_alloc")
+	fc.out.MovMemToReg("rcx", "r15", 0)
+	fc.out.AddRegToReg("r12", "rcx")
+}
+
+// generateMapRehash emits _c67_map_rehash(r12=map, r15=arena, rdi=size),
+// which builds a new index with size slots for the entries. Clobbers rax,
+// rcx, rdx, rsi, rdi, r8-r10 and xmm0-xmm2.
+func (fc *C67Compiler) generateMapRehash() {
+	fc.eb.MarkFunction("_c67_map_rehash")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+
+	// Control bytes and slots: size * 9 bytes
+	fc.out.MovRegToReg("r14", "rdi")
+	fc.out.MovRegToReg("rsi", "rdi")
+	fc.out.ImulImmToReg("rsi", 9)
+	fc.emitMapArenaAlloc()
+	fc.out.MovRegToMem("rax", "r12", -32)
+	fc.out.MovRegToMem("r14", "r12", -24)
+	fc.out.MovImmToMem(0, "r12", -16)
+
+	// Mark every slot empty
+	fc.out.MovRegToReg("rdi", "rax")
+	fc.out.MovRegToReg("rcx", "r14")
+	fc.out.MovImmToReg("rax", fmt.Sprintf("%d", mapCtrlEmpty))
+	fc.out.Emit([]byte{0xf3, 0xaa}) // rep stosb
+
+	// Place the entries: rbx = count, r14 = entry number
+	fc.out.MovMemToXmm("xmm0", "r12", 0)
+	fc.out.Cvttsd2si("rbx", "xmm0")
+	fc.out.XorRegWithReg("r14", "r14")
+	loop := fc.eb.text.Len()
+	fc.out.CmpRegToReg("r14", "rbx")
+	done := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovRegToReg("rax", "r14")
+	fc.out.ShlRegByImm("rax", 4)
+	fc.out.AddRegToReg("rax", "r12")
+	fc.out.MovMemToXmm("xmm0", "rax", 8)
+	fc.emitMapHash()
+	fc.out.MovRegToReg("rdi", "r14")
+	fc.out.CallSymbol("_c67_map_place")
+	fc.out.IncReg("r14")
+	fc.out.JumpUnconditional(int32(loop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(done)
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("rbx")
+	fc.out.Ret()
+}
+
+// generateMapNew emits _c67_map_new(rdi=capacity, rsi=arena) -> rax, an
+// empty map with room for capacity entries
+func (fc *C67Compiler) generateMapNew() {
+	fc.eb.MarkFunction("_c67_map_new")
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r15")
+	fc.out.SubImmFromReg("rsp", StackSlotSize) // Align for the arena calls
+
+	// Header, count and entries
+	fc.out.MovRegToReg("rbx", "rdi") // rbx = capacity
+	fc.out.MovRegToReg("r15", "rsi") // r15 = arena
+	fc.out.MovRegToReg("rdi", "r15")
+	fc.out.MovRegToReg("rsi", "rbx")
+	fc.out.ShlRegByImm("rsi", 4)
+	fc.out.AddImmToReg("rsi", mapHeaderSize+8)
+	fc.trackFunctionCall("c67_arena_alloc")
+	fc.out.CallSymbol("c67_arena_alloc")
+	fc.out.LeaMemToReg("r12", "rax", mapHeaderSize)
+	fc.out.MovRegToMem("rbx", "r12", -8)
+	fc.out.MovImmToMem(0, "r12", 0)
+
+	// A small map starts without an index, a larger one with the smallest
+	// index that has room for capacity keys
+	fc.out.CmpRegToImm("rbx", mapSmallLimit)
+	large := fc.forwardJump(JumpGreater)
+	fc.out.MovImmToMem(0, "r12", -32)
+	fc.out.MovImmToMem(0, "r12", -24)
+	fc.out.MovImmToMem(0, "r12", -16)
+	done := fc.forwardJumpAlways()
+	fc.landForwardJump(large)
+	fc.out.MovRegToReg("rcx", "rbx")
+	fc.emitMapIndexSize()
+	fc.out.CallSymbol("_c67_map_rehash")
+	fc.landForwardJump(done)
+
+	fc.out.MovRegToReg("rax", "r12")
+	fc.out.AddImmToReg("rsp", StackSlotSize)
+	fc.out.PopReg("r15")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generateMapGet emits _c67_map_get(rdi=map, xmm0=key) -> xmm0, the value
+// of key or 0 when it is missing, like a linear map lookup
+func (fc *C67Compiler) generateMapGet() {
+	fc.eb.MarkFunction("_c67_map_get")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+
+	fc.out.MovRegToReg("r12", "rdi")
+	fc.emitMapNormalizeKey()
+	fc.out.CallSymbol("_c67_map_probe")
+	fc.out.TestRegReg("rax", "rax")
+	missing := fc.forwardJump(JumpEqual)
+	fc.out.MovMemToXmm("xmm0", "rax", 8)
+	done := fc.forwardJumpAlways()
+	fc.landForwardJump(missing)
+	fc.out.XorpdXmm("xmm0", "xmm0")
+	fc.landForwardJump(done)
+
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.Ret()
+}
+
+// generateMapSet emits _c67_map_set(rdi=map, rsi=arena, xmm0=key,
+// xmm1=value) -> rax, the map, which has moved if it had to grow
+func (fc *C67Compiler) generateMapSet() {
+	fc.eb.MarkFunction("_c67_map_set")
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+	fc.out.PushReg("r15")
+	fc.out.SubImmFromReg("rsp", 24) // [rsp] = value, [rsp+8] = key, [rsp+16] = arena buffer
+
+	fc.out.MovRegToReg("r12", "rdi")
+	fc.out.MovRegToReg("r15", "rsi")
+	fc.emitMapNormalizeKey()
+	fc.out.MovXmmToMem("xmm1", "rsp", 0)
+	fc.out.MovXmmToMem("xmm0", "rsp", 8)
+	fc.out.CallSymbol("_c67_map_probe")
+	fc.out.TestRegReg("rax", "rax")
+	insert := fc.forwardJump(JumpEqual)
+	fc.out.MovMemToXmm("xmm1", "rsp", 0)
+	fc.out.MovXmmToMem("xmm1", "rax", 8)
+	done := fc.forwardJumpAlways()
+
+	// New key: move the entries to a block twice the size when they are full
+	fc.landForwardJump(insert)
+	fc.out.MovMemToXmm("xmm0", "r12", 0)
+	fc.out.Cvttsd2si("r14", "xmm0") // r14 = count
+	fc.out.MovMemToReg("rax", "r12", -8)
+	fc.out.CmpRegToReg("r14", "rax")
+	room := fc.forwardJump(JumpLess)
+	fc.out.MovMemToReg("rbx", "r12", -8)
+	fc.out.ShlRegByImm("rbx", 1) // rbx = new capacity
+	fc.out.MovMemToReg("rax", "r15", 0)
+	fc.out.MovRegToMem("rax", "rsp", 16) // The arena buffer before the allocation
+	fc.out.MovRegToReg("rsi", "rbx")
+	fc.out.ShlRegByImm("rsi", 4)
+	fc.out.AddImmToReg("rsi", mapHeaderSize+8)
+	fc.emitMapArenaAlloc()
+	fc.out.MovRegToReg("rdi", "rax")
+	fc.out.LeaMemToReg("rsi", "r12", -mapHeaderSize)
+	fc.out.MovRegToReg("rcx", "r14")
+	fc.out.ShlRegByImm("rcx", 4)
+	fc.out.AddImmToReg("rcx", mapHeaderSize+8)
+	fc.out.RepMovsb()
+	fc.out.LeaMemToReg("r12", "rax", mapHeaderSize)
+	fc.out.MovRegToMem("rbx", "r12", -8)
+
+	// The index moved with the arena buffer, if it did
+	fc.out.MovMemToReg("rax", "r12", -32)
+	fc.out.TestRegReg("rax", "rax")
+	unmoved := fc.forwardJump(JumpEqual)
+	fc.out.MovMemToReg("rcx", "r15", 0)
+	fc.out.AddRegToReg("rax", "rcx")
+	fc.out.MovMemToReg("rcx", "rsp", 16)
+	fc.out.SubRegFromReg("rax", "rcx")
+	fc.out.MovRegToMem("rax", "r12", -32)
+	fc.landForwardJump(unmoved)
+	fc.landForwardJump(room)
+
+	// A small map gets its index when the key past mapSmallLimit comes in
+	fc.out.MovMemToReg("rax", "r12", -32)
+	fc.out.TestRegReg("rax", "rax")
+	hasIndex := fc.forwardJump(JumpNotEqual)
+	fc.out.CmpRegToImm("r14", mapSmallLimit)
+	small := fc.forwardJump(JumpLess)
+	fc.out.LeaMemToReg("rcx", "r14", 1)
+	fc.emitMapIndexSize()
+	fc.out.CallSymbol("_c67_map_rehash")
+	promoted := fc.forwardJumpAlways()
+
+	// Rebuild the index when used and deleted slots pass the load factor,
+	// twice as large if count+1 keys fill more than half of it
+	fc.landForwardJump(hasIndex)
+	fc.out.MovRegToReg("rax", "r14")
+	fc.out.IncReg("rax") // rax = count + 1
+	fc.out.MovMemToReg("rcx", "r12", -16)
+	fc.out.AddRegToReg("rcx", "rax")
+	fc.out.ImulImmToReg("rcx", 100)
+	fc.out.MovMemToReg("rdi", "r12", -24)
+	fc.out.MovRegToReg("rdx", "rdi")
+	fc.out.ImulImmToReg("rdx", mapMaxLoadPercent)
+	fc.out.CmpRegToReg("rcx", "rdx")
+	indexed := fc.forwardJump(JumpLessOrEqual)
+	fc.out.ImulImmToReg("rax", 200)
+	fc.out.CmpRegToReg("rax", "rdx")
+	sameSize := fc.forwardJump(JumpLessOrEqual)
+	fc.out.ShlRegByImm("rdi", 1)
+	fc.landForwardJump(sameSize)
+	fc.out.CallSymbol("_c67_map_rehash")
+	fc.landForwardJump(indexed)
+	fc.landForwardJump(small)
+	fc.landForwardJump(promoted)
+
+	// Append the entry, publish the count and index the entry
+	fc.out.MovRegToReg("rax", "r14")
+	fc.out.ShlRegByImm("rax", 4)
+	fc.out.AddRegToReg("rax", "r12")
+	fc.out.MovMemToXmm("xmm0", "rsp", 8)
+	fc.out.MovXmmToMem("xmm0", "rax", 8)
+	fc.out.MovMemToXmm("xmm1", "rsp", 0)
+	fc.out.MovXmmToMem("xmm1", "rax", 16)
+	fc.out.LeaMemToReg("rcx", "r14", 1)
+	fc.out.Cvtsi2sd("xmm0", "rcx")
+	fc.out.MovXmmToMem("xmm0", "r12", 0)
+	fc.out.MovMemToReg("rax", "r12", -32)
+	fc.out.TestRegReg("rax", "rax")
+	unindexed := fc.forwardJump(JumpEqual)
+	fc.out.MovRegToReg("rdi", "r14")
+	fc.out.CallSymbol("_c67_map_place")
+	fc.landForwardJump(unindexed)
+
+	fc.landForwardJump(done)
+	fc.out.MovRegToReg("rax", "r12")
+	fc.out.AddImmToReg("rsp", 24)
+	fc.out.PopReg("r15")
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generateMapDelete emits _c67_map_delete(rdi=map, xmm0=key) -> xmm0, 1 if
+// the key was there, else 0
+func (fc *C67Compiler) generateMapDelete() {
+	fc.eb.MarkFunction("_c67_map_delete")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+
+	fc.out.MovRegToReg("r12", "rdi")
+	fc.emitMapNormalizeKey()
+	fc.out.CallSymbol("_c67_map_probe")
+	fc.out.TestRegReg("rax", "rax")
+	missing := fc.forwardJump(JumpEqual)
+	fc.out.TestRegReg("rbx", "rbx")
+	small := fc.forwardJump(JumpEqual)
+	fc.out.Emit([]byte{0xc6, 0x03, mapCtrlDeleted})   // mov byte [rbx], mapCtrlDeleted
+	fc.out.Emit([]byte{0x49, 0xff, 0x44, 0x24, 0xf0}) // inc qword [r12-16]
+	fc.landForwardJump(small)
+
+	// Drop the last entry from the count, and move it into the gap
+	fc.out.MovMemToXmm("xmm0", "r12", 0)
+	fc.out.Cvttsd2si("r14", "xmm0")
+	fc.out.DecReg("r14")
+	fc.out.Cvtsi2sd("xmm0", "r14")
+	fc.out.MovXmmToMem("xmm0", "r12", 0)
+	fc.out.MovRegToReg("rsi", "r14")
+	fc.out.ShlRegByImm("rsi", 4)
+	fc.out.AddRegToReg("rsi", "r12")
+	fc.out.AddImmToReg("rsi", 8) // rsi = last entry
+	fc.out.CmpRegToReg("rsi", "rax")
+	removed := fc.forwardJump(JumpEqual)
+	fc.out.MovMemToXmm("xmm0", "rsi", 0)
+	fc.out.MovXmmToMem("xmm0", "rax", 0)
+	fc.out.MovMemToXmm("xmm1", "rsi", 8)
+	fc.out.MovXmmToMem("xmm1", "rax", 8)
+	fc.out.MovRegToReg("r14", "rax")
+	fc.out.SubRegFromReg("r14", "r12")
+	fc.out.ShrRegByImm("r14", 4) // r14 = entry number of the gap
+	// The slot of the moved key still names the last entry: point it at the gap
+	fc.out.TestRegReg("rbx", "rbx")
+	unindexed := fc.forwardJump(JumpEqual)
+	fc.out.CallSymbol("_c67_map_probe")
+	fc.out.MovRegToReg("rcx", "rbx")
+	fc.out.SubRegFromReg("rcx", "r10")
+	fc.out.Emit([]byte{0x4d, 0x89, 0x34, 0xc8}) // mov [r8+rcx*8], r14
+
+	fc.landForwardJump(removed)
+	fc.landForwardJump(unindexed)
+	fc.out.MovImmToReg("rax", strconv.FormatUint(math.Float64bits(1.0), 10))
+	fc.out.MovqRegToXmm("xmm0", "rax")
+	done := fc.forwardJumpAlways()
+	fc.landForwardJump(missing)
+	fc.out.XorpdXmm("xmm0", "xmm0")
+	fc.landForwardJump(done)
+
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.Ret()
+}
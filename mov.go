@@ -154,6 +154,33 @@ func (o *Out) movX86RegToReg(dst, src string) {
 	}
 }
 
+// x86ImmMoveForm is an encoding of MOV reg, imm
+type x86ImmMoveForm int
+
+const (
+	movImm32ZeroExtend x86ImmMoveForm = iota // B8+r imm32, the upper half is cleared (5-6 bytes)
+	movImm32SignExtend                       // REX.W C7 /0 imm32 (7 bytes)
+	movImm64                                 // REX.W B8+r imm64 (10 bytes)
+	leaRIPRelative                           // REX.W 8D /r [rip + disp32], for addresses (7 bytes)
+)
+
+// selectX86ImmMoveForm picks the shortest encoding that loads imm into a
+// 64-bit register. Writing a 32-bit register clears the upper half, so a
+// value that fits in 32 unsigned bits needs neither REX.W nor sign extension.
+// An address above 4 GiB is loaded relative to RIP instead of as imm64.
+func selectX86ImmMoveForm(imm uint64, isAddress bool) x86ImmMoveForm {
+	switch {
+	case imm <= 0xFFFFFFFF:
+		return movImm32ZeroExtend
+	case isAddress:
+		return leaRIPRelative
+	case int64(imm) >= -0x80000000 && int64(imm) < 0:
+		return movImm32SignExtend
+	default:
+		return movImm64
+	}
+}
+
 // x86_64 immediate-to-register move
 func (o *Out) movX86ImmToReg(dst, imm string) {
 	dstReg, dstOk := GetRegister(o.target.Arch(), dst)
@@ -161,12 +188,9 @@ func (o *Out) movX86ImmToReg(dst, imm string) {
 		return
 	}
 
-	if VerboseMode {
-		fmt.Fprintf(os.Stderr, "mov %s, %s:", dst, imm)
-	}
-
 	// Parse immediate value (support both signed and unsigned)
 	var immVal uint64
+	isAddress := false
 	if val, err := strconv.ParseInt(imm, 0, 64); err == nil {
 		// Signed integer - convert to uint64 (preserves two's complement representation)
 		immVal = uint64(val)
@@ -176,26 +200,42 @@ func (o *Out) movX86ImmToReg(dst, imm string) {
 		if val, err := strconv.ParseUint(addr, 10, 64); err == nil {
 			immVal = val
 		}
+		_, isAddress = o.eb.consts[imm]
 	}
 
-	// REX prefix for 64-bit registers
+	// A 32-bit register takes B8+r imm32 as is
+	form := movImm32ZeroExtend
 	if dstReg.Size == 64 {
-		rex := uint8(0x48)
-		if dstReg.Encoding >= 8 {
-			rex |= 0x01 // REX.B
-		}
-		o.Write(rex)
+		form = selectX86ImmMoveForm(immVal, isAddress)
+	}
+	if form == leaRIPRelative {
+		o.leaX86SymbolToReg(dst, imm)
+		return
 	}
 
-	// MOV with immediate encoding
-	o.Write(0xC7) // MOV r/m64, imm32
-
-	// ModR/M byte for register direct addressing
-	modrm := uint8(0xC0) | (dstReg.Encoding & 7)
-	o.Write(modrm)
+	if VerboseMode {
+		fmt.Fprintf(os.Stderr, "mov %s, %s:", dst, imm)
+	}
 
-	// Write 32-bit immediate (sign-extended to 64-bit)
-	o.WriteUnsigned(uint(immVal))
+	switch form {
+	case movImm32ZeroExtend:
+		if dstReg.Encoding >= 8 {
+			o.Write(0x41) // REX.B
+		}
+		o.Write(0xB8 | (dstReg.Encoding & 7))
+		o.WriteUnsigned(uint(uint32(immVal)))
+	case movImm32SignExtend:
+		o.Write(0x48 | (dstReg.Encoding>>3)&1) // REX.W, REX.B
+		o.Write(0xC7)
+		o.Write(0xC0 | (dstReg.Encoding & 7))
+		o.WriteUnsigned(uint(uint32(immVal)))
+	case movImm64:
+		o.Write(0x48 | (dstReg.Encoding>>3)&1) // REX.W, REX.B
+		o.Write(0xB8 | (dstReg.Encoding & 7))
+		for i := 0; i < 8; i++ {
+			o.Write(uint8(immVal >> (8 * i)))
+		}
+	}
 
 	if VerboseMode {
 		fmt.Fprintln(os.Stderr)
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestMovImmToRegShortestForm tests that immediate moves use the shortest encoding
+func TestMovImmToRegShortestForm(t *testing.T) {
+	tests := []struct {
+		dst, imm string
+		want     []byte
+	}{
+		{"rax", "1", []byte{0xB8, 0x01, 0x00, 0x00, 0x00}},
+		{"rdi", "0", []byte{0xBF, 0x00, 0x00, 0x00, 0x00}},
+		{"r10", "1", []byte{0x41, 0xBA, 0x01, 0x00, 0x00, 0x00}},
+		{"rax", "0xFFFFFFFF", []byte{0xB8, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{"rax", "0x7FFFFFFFFFFFFFFF", []byte{0x48, 0xB8, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x7F}},
+		{"rax", "-1", []byte{0x48, 0xC7, 0xC0, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{"r9", "-2", []byte{0x49, 0xC7, 0xC1, 0xFE, 0xFF, 0xFF, 0xFF}},
+		{"rax", "0x10000000000", []byte{0x48, 0xB8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00}},
+		{"r11", "0x8000000000000000", []byte{0x49, 0xBB, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80}},
+		{"eax", "7", []byte{0xB8, 0x07, 0x00, 0x00, 0x00}},
+	}
+
+	for _, tt := range tests {
+		eb, err := New("x86_64")
+		if err != nil {
+			t.Fatalf("Failed to create ExecutableBuilder: %v", err)
+		}
+		out := NewOut(eb.target, &BufferWrapper{&eb.text}, eb)
+		out.MovImmToReg(tt.dst, tt.imm)
+		if got := eb.text.Bytes(); !bytes.Equal(got, tt.want) {
+			t.Errorf("mov %s, %s: got % x, want % x", tt.dst, tt.imm, got, tt.want)
+		}
+	}
+}
+
+// TestMovImmToRegAddressForm tests that an address above 4 GiB is loaded
+// relative to RIP instead of with a 64-bit immediate
+func TestMovImmToRegAddressForm(t *testing.T) {
+	tests := []struct {
+		dst  string
+		addr uint64
+		want []byte
+	}{
+		{"rax", 0x401000, []byte{0xB8, 0x00, 0x10, 0x40, 0x00}},
+		{"r12", 0x401000, []byte{0x41, 0xBC, 0x00, 0x10, 0x40, 0x00}},
+		{"rdi", 0x100001000, []byte{0x48, 0x8D, 0x3D, 0xEF, 0xBE, 0xAD, 0xDE}},
+		{"r12", 0x100001000, []byte{0x4C, 0x8D, 0x25, 0xEF, 0xBE, 0xAD, 0xDE}},
+	}
+
+	for _, tt := range tests {
+		eb, err := New("x86_64")
+		if err != nil {
+			t.Fatalf("Failed to create ExecutableBuilder: %v", err)
+		}
+		eb.Define("message", "Hello\x00")
+		eb.DefineAddr("message", tt.addr)
+		out := NewOut(eb.target, &BufferWrapper{&eb.text}, eb)
+		out.MovImmToReg(tt.dst, "message")
+		if got := eb.text.Bytes(); !bytes.Equal(got, tt.want) {
+			t.Errorf("mov %s, message at %#x: got % x, want % x", tt.dst, tt.addr, got, tt.want)
+		}
+		relocated := len(eb.pcRelocations) == 1 && eb.pcRelocations[0].symbolName == "message"
+		if wantLea := tt.want[1] == 0x8D; relocated != wantLea {
+			t.Errorf("mov %s, message at %#x: relocations %v", tt.dst, tt.addr, eb.pcRelocations)
+		}
+	}
+}
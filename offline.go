@@ -0,0 +1,39 @@
+// Completion: 100% - Builds without network access with --offline
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// offline.go - Builds that only use what is already on disk, from --offline
+//
+//	c67 --offline main.c67
+//
+// Git imports and the repositories of automatic dependencies are taken from
+// the cache (~/.cache/c67, or $XDG_CACHE_HOME/c67, which CI can point at a
+// vendored copy of the cache) and are never cloned or fetched. A build that
+// would need the network stops instead, with the repository it needs:
+//
+//	--offline: cloning github.com/xyproto/c67_math needs the network
+//
+// That is a repository that is not in the cache, a version range that the
+// tags of the cached clone do not satisfy, or --update-deps. A tag, branch
+// or commit that is not in the cached clone makes git checkout fail, as
+// without --offline.
+//
+// c67 uses the network for nothing else: C libraries are found with -L,
+// pkg-config and ldconfig, which only read the local system.
+
+// errOffline is returned, wrapped, for the network access that --offline
+// forbids
+var errOffline = errors.New("--offline")
+
+// requireNetwork returns an error if --offline is given, for action on the
+// remote repository repoURL
+func requireNetwork(action, repoURL string) error {
+	if OfflineFlag {
+		return fmt.Errorf("%w: %s %s needs the network", errOffline, action, repoURL)
+	}
+	return nil
+}
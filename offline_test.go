@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestOfflineGitImport tests that --offline imports from the cache and
+// stops on imports that would be fetched
+func TestOfflineGitImport(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	OfflineFlag = true
+	defer func() { OfflineFlag = false }()
+
+	missing := &ImportSpec{Source: "git.example.com/team/missing"}
+	if _, err := resolveGitRepo(missing); !errors.Is(err, errOffline) {
+		t.Fatalf("Expected an --offline error for a repository that is not cached, got %v", err)
+	} else if strings.Contains(err.Error(), privateImportHint) {
+		t.Errorf("The error of an import that was not fetched has the private repository hint: %v", err)
+	}
+
+	repo := filepath.Join(cache, "c67", "git.example.com", "team", "pkg")
+	if err := os.MkdirAll(repo, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "pkg.c67"), []byte("double = x -> x * 2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, output)
+		}
+	}
+	git("init", "-q")
+	git("add", "pkg.c67")
+	git("commit", "-q", "-m", "pkg")
+	git("tag", "v1.2.0")
+
+	for _, version := range []string{"", "v1.2.0", "^1.0"} {
+		files, err := resolveGitRepo(&ImportSpec{Source: "git.example.com/team/pkg", Version: version})
+		if err != nil || len(files) != 1 {
+			t.Errorf("Expected the cached pkg.c67 for version %q, got %v, %v", version, files, err)
+		}
+	}
+	if _, err := resolveGitRepo(&ImportSpec{Source: "git.example.com/team/pkg", Version: "^2.0"}); !errors.Is(err, errOffline) {
+		t.Errorf("Expected an --offline error for a range the cached tags do not satisfy, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+// Completion: 100% - Operator methods on maps
+package main
+
+import (
+	"math"
+	"strconv"
+)
+
+// operators.go - Operators that call methods of map records
+//
+// A map can define how operators work on it by binding a lambda to a
+// well-known key, so a vector or complex number library can be written in
+// C67 itself:
+//
+//	vadd = (a, b) -> { ... }
+//	v := {x: 1, y: 2, __add: vadd}
+//	w = v + v                 // vadd(v, v)
+//
+// When either operand of an operator below has the compile-time type map,
+// the operator looks up its key in that operand, the left one if both are
+// maps, and calls the method with both operands in source order. The method
+// is looked up at runtime, so a map without it, or with 0 under the key,
+// gets the ordinary operator. The keys are scanned in the universal layout,
+// which hash-indexed maps keep as well; records are small.
+//
+// There is no reflected __radd: 1 + v calls the __add of v as __add(1, v).
+// println(m) prints the string that m.__str(m) returns, if m has __str.
+
+// operatorMethods maps the operators that can be overloaded to their keys
+var operatorMethods = map[string]string{
+	"+":   "__add",
+	"-":   "__sub",
+	"*":   "__mul",
+	"/":   "__div",
+	"%":   "__mod",
+	"mod": "__mod",
+	"==":  "__eq",
+	"!=":  "__ne",
+	"<":   "__lt",
+	"<=":  "__le",
+	">":   "__gt",
+	">=":  "__ge",
+}
+
+// operatorReceiver returns the operand of e whose method the operator may
+// call, 0 for the left one and 1 for the right one, or -1 when neither is
+// known to be a map
+func (fc *C67Compiler) operatorReceiver(e *BinaryExpr) int {
+	if _, ok := operatorMethods[e.Operator]; !ok {
+		return -1
+	}
+	if fc.getExprType(e.Left) == "map" {
+		return 0
+	}
+	if fc.getExprType(e.Right) == "map" {
+		return 1
+	}
+	return -1
+}
+
+// emitOperatorMethodCall calls the method for operator on the operand given
+// by receiver, with the left operand in xmm0 and the right one in xmm1. It
+// returns the position of the jump to take past the ordinary operator, which
+// it falls through to with xmm0 and xmm1 unchanged when there is no method.
+func (fc *C67Compiler) emitOperatorMethodCall(operator string, receiver int) int {
+	fc.out.SubImmFromReg("rsp", 16)
+	fc.out.MovXmmToMem("xmm0", "rsp", 0)
+	fc.out.MovXmmToMem("xmm1", "rsp", StackSlotSize)
+	fc.out.MovMemToReg("rax", "rsp", receiver*StackSlotSize)
+	missing := fc.emitMethodLookup(operatorMethods[operator])
+	fc.out.MovMemToXmm("xmm0", "rsp", 0)
+	fc.out.MovMemToXmm("xmm1", "rsp", StackSlotSize)
+	fc.emitMethodCall()
+	fc.out.AddImmToReg("rsp", 16)
+	called := fc.forwardJumpAlways()
+
+	for _, pos := range missing {
+		fc.landForwardJump(pos)
+	}
+	fc.out.MovMemToXmm("xmm0", "rsp", 0)
+	fc.out.MovMemToXmm("xmm1", "rsp", StackSlotSize)
+	fc.out.AddImmToReg("rsp", 16)
+	return called
+}
+
+// emitStrMethodPrintln prints the string that the __str method of the map
+// in xmm0 gives, and a newline. It returns the position of the jump to take
+// past the ordinary printing, which it falls through to with xmm0 unchanged
+// when there is no method.
+func (fc *C67Compiler) emitStrMethodPrintln() int {
+	fc.out.SubImmFromReg("rsp", 16)
+	fc.out.MovXmmToMem("xmm0", "rsp", 0)
+	fc.out.MovqXmmToReg("rax", "xmm0")
+	missing := fc.emitMethodLookup("__str")
+	fc.emitMethodCall()
+	fc.out.AddImmToReg("rsp", 16)
+	fc.out.MovqXmmToReg("rdi", "xmm0")
+	fc.trackFunctionCall("_c67_println_syscall")
+	fc.eb.GenerateCallInstruction("_c67_println_syscall")
+	printed := fc.forwardJumpAlways()
+
+	for _, pos := range missing {
+		fc.landForwardJump(pos)
+	}
+	fc.out.MovMemToXmm("xmm0", "rsp", 0)
+	fc.out.AddImmToReg("rsp", 16)
+	return printed
+}
+
+// emitMethodLookup finds the value under the key method in the map whose
+// pointer is in rax and leaves it in r11. It returns the positions of the
+// jumps taken when rax is not a pointer or the map has no such method.
+// Clobbers rax, rcx, rdx, r8, r9 and xmm2.
+func (fc *C67Compiler) emitMethodLookup(method string) []int {
+	// Anything but a pointer, such as an error value, has no methods
+	fc.out.MovRegToReg("rcx", "rax")
+	fc.out.ShrRegByImm("rcx", 52)
+	notPointer := fc.forwardJump(JumpNotEqual)
+	fc.out.TestRegReg("rax", "rax")
+	null := fc.forwardJump(JumpEqual)
+
+	// rdx = next entry, rcx = entries left, r8 = the key
+	fc.out.MovMemToReg("rcx", "rax", 0)
+	fc.out.MovqRegToXmm("xmm2", "rcx")
+	fc.out.Cvttsd2si("rcx", "xmm2")
+	fc.out.LeaMemToReg("rdx", "rax", 8)
+	fc.out.MovImmToReg("r8", strconv.FormatUint(math.Float64bits(symbolValue(method)), 10))
+	scan := fc.eb.text.Len()
+	fc.out.TestRegReg("rcx", "rcx")
+	missing := fc.forwardJump(JumpLessOrEqual)
+	fc.out.MovMemToReg("r9", "rdx", 0)
+	fc.out.CmpRegToReg("r9", "r8")
+	found := fc.forwardJump(JumpEqual)
+	fc.out.AddImmToReg("rdx", 16)
+	fc.out.DecReg("rcx")
+	fc.out.JumpUnconditional(int32(scan - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(found)
+	fc.out.MovMemToReg("r11", "rdx", 8)
+	fc.out.TestRegReg("r11", "r11")
+	noMethod := fc.forwardJump(JumpEqual)
+	return []int{notPointer, null, missing, noMethod}
+}
+
+// emitMethodCall calls the closure in r11, [function][environment], with
+// the arguments already in their registers
+func (fc *C67Compiler) emitMethodCall() {
+	fc.out.MovMemToReg("r15", "r11", 8)
+	fc.out.MovMemToReg("r11", "r11", 0)
+	fc.out.CallRegister("r11")
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestOperatorMethods tests operators on maps that define __add, __lt and __str
+func TestOperatorMethods(t *testing.T) {
+	source := `vadd = (a, b) -> {
+    am: map = a
+    bm: map = b
+    ret vec(am.x + bm.x, am.y + bm.y)
+}
+vstr = v -> {
+    vm: map = v
+    ret f"({vm.x}, {vm.y})"
+}
+vlt = (a, b) -> {
+    am: map = a
+    bm: map = b
+    ret am.x * am.x + am.y * am.y < bm.x * bm.x + bm.y * bm.y
+}
+vec = (x, y) -> {
+    v := {x: x, y: y, __add: vadd, __str: vstr, __lt: vlt}
+    ret v
+}
+main = {
+    p: map = vec(1, 2)
+    q: map = vec(10, 20)
+    println(p + q + p)
+    println((p < q) * 10 + (q < p))
+}
+`
+	result := compileAndRun(t, source)
+	if !strings.Contains(result, "(12, 24)\n10\n") {
+		t.Errorf("Expected output to contain '(12, 24)\\n10\\n', got: %s", result)
+	}
+}
+
+// TestOperatorWithoutMethod tests that a map without the method gets the ordinary operator
+func TestOperatorWithoutMethod(t *testing.T) {
+	source := `same = (a, b) -> 1
+m := {x: 1, __eq: same}
+n := {x: 1}
+o := {x: 1}
+println((m == n) * 10 + (n == o))
+`
+	result := compileAndRun(t, source)
+	if !strings.Contains(result, "10\n") {
+		t.Errorf("Expected output to contain '10\\n', got: %s", result)
+	}
+}
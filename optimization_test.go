@@ -1,6 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"debug/elf"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -61,6 +66,79 @@ println(a * x * x + b * x + c)`,
 	}
 }
 
+func TestStringConstantFolding(t *testing.T) {
+	folded := foldConstantExpr(&FStringExpr{
+		Parts:     []Expression{&StringExpr{Value: "v"}, &NumberExpr{Value: 1}, &StringExpr{Value: "."}, &NumberExpr{Value: 2}},
+		Precision: []int{-1, -1, -1, -1},
+	})
+	if str, ok := folded.(*StringExpr); !ok || str.Value != "v1.2" {
+		t.Errorf("Expected f\"v{1}.{2}\" to fold to \"v1.2\", got %#v", folded)
+	}
+
+	folded = foldConstantExpr(&BinaryExpr{Left: &StringExpr{Value: "ab"}, Operator: "+", Right: &StringExpr{Value: "cd"}})
+	if str, ok := folded.(*StringExpr); !ok || str.Value != "abcd" {
+		t.Errorf("Expected \"ab\" + \"cd\" to fold to \"abcd\", got %#v", folded)
+	}
+
+	code := `name := "x"
+n := 3
+println(f"v{1}.{2}-{name}")
+println("ab" + "cd" + "ef")
+println(f"{n}{0.5}{n:.2}{10}")
+`
+	result := compileAndRun(t, code)
+	if result != "v1.2-x\nabcdef\n30.53.0010\n" {
+		t.Errorf("Expected 'v1.2-x\\nabcdef\\n30.53.0010\\n', got %q", result)
+	}
+}
+
+func TestCommonSubexpressionElimination(t *testing.T) {
+	index := func() Expression {
+		return &BinaryExpr{Left: &BinaryExpr{Left: &IdentExpr{Name: "i"}, Operator: "*", Right: &IdentExpr{Name: "n"}}, Operator: "+", Right: &IdentExpr{Name: "j"}}
+	}
+	stmts := eliminateCommonSubexpressions([]Statement{&AssignStmt{Name: "v", Value: &BinaryExpr{
+		Left:     &IndexExpr{List: &IdentExpr{Name: "xs"}, Index: index()},
+		Operator: "+",
+		Right:    &IndexExpr{List: &IdentExpr{Name: "ys"}, Index: index()},
+	}}})
+	if len(stmts) != 2 {
+		t.Fatalf("Expected a temporary before the statement, got %v", stmts)
+	}
+	temp := stmts[0].(*AssignStmt)
+	if temp.Value.String() != index().String() {
+		t.Errorf("Expected the temporary to hold %s, got %s", index(), temp.Value)
+	}
+	if want := "(xs[" + temp.Name + "] + ys[" + temp.Name + "])"; stmts[1].(*AssignStmt).Value.String() != want {
+		t.Errorf("Expected %s, got %s", want, stmts[1].(*AssignStmt).Value)
+	}
+
+	stmts = eliminateCommonSubexpressions([]Statement{&ExpressionStmt{Expr: &BinaryExpr{
+		Left:     &CallExpr{Function: "f", Args: []Expression{index()}},
+		Operator: "+",
+		Right:    index(),
+	}}})
+	if len(stmts) != 1 {
+		t.Errorf("Expected a statement with a nested call to be kept, got %v", stmts)
+	}
+
+	code := `n := 4
+xs := [1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12]
+total := 0
+@ i in 0..<3 {
+    @ j in 0..<4 {
+        total <- total + xs[i*n+j] * xs[i*n+j]
+    }
+    xs[i*n] <- xs[i*n] + 100
+}
+println(total)
+println(xs[4])
+`
+	result := compileAndRun(t, code)
+	if result != "650\n105\n" {
+		t.Errorf("Expected '650\\n105\\n', got %q", result)
+	}
+}
+
 func TestBitManipulationBuiltins(t *testing.T) {
 	tests := []struct {
 		name string
@@ -117,6 +195,21 @@ func TestBitManipulationBuiltins(t *testing.T) {
 			code: `println(popcount(255.0))`,
 			want: "8",
 		},
+		{
+			name: "popcount of -1",
+			code: `println(popcount(-1))`,
+			want: "64",
+		},
+		{
+			name: "bswap of 1",
+			code: `println(bswap(1))`,
+			want: "72057594037927936",
+		},
+		{
+			name: "bswap twice",
+			code: `println(bswap(bswap(4660)))`,
+			want: "4660",
+		},
 	}
 
 	for _, tt := range tests {
@@ -146,6 +239,67 @@ println(x * y + z)
 	}
 }
 
+// TestLazyCPUFeatureDetection tests that only programs that use the
+// cpu_has_* flags or a dispatched helper call _c67_runtime_init at startup.
+// List concatenation and repetition only reach a dispatch slot from inside
+// their runtime helpers, which the first pass does not generate.
+func TestLazyCPUFeatureDetection(t *testing.T) {
+	sources := map[string]bool{
+		"println(1)\n":                                      false,
+		"x := 6\nprintln(x * 7)\n":                          false,
+		"println(popcount(255))\n":                          true,
+		"a := [1, 2]\nb := [3]\nc := a + b\n":               true,
+		"s := \"ab\"\nprintln(s == \"ab\")\n":               true,
+		"xs := [1, 2] * 3\nprintln(#xs)\n":                  true,
+		"f = n -> { xs := [n] * 3\n #xs }\nprintln(f(2))\n": true,
+	}
+	for source, want := range sources {
+		tmpDir := t.TempDir()
+		srcFile := filepath.Join(tmpDir, "startup.c67")
+		exePath := filepath.Join(tmpDir, "startup")
+		if err := os.WriteFile(srcFile, []byte(source), 0644); err != nil {
+			t.Fatalf("Failed to write source file: %v", err)
+		}
+		if err := CompileC67(srcFile, exePath, Platform{Arch: ArchX86_64, OS: OSLinux}); err != nil {
+			t.Fatalf("%q: compilation failed: %v", source, err)
+		}
+		f, err := elf.Open(exePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		symbols, err := f.Symbols()
+		if err != nil {
+			t.Fatal(err)
+		}
+		text := f.Section(".text")
+		data, err := text.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		functions := make(map[string][]byte)
+		for _, sym := range symbols {
+			start := sym.Value - text.Addr
+			if sym.Value < text.Addr || start+sym.Size > uint64(len(data)) {
+				continue
+			}
+			functions[sym.Name] = data[start : start+sym.Size]
+		}
+		f.Close()
+		if bytes.Contains(functions["c67.main"], []byte{0x0f, 0xa2}) {
+			t.Errorf("%q: cpuid is inline in c67.main", source)
+		}
+		init, got := functions["_c67_runtime_init"]
+		if got != want {
+			t.Errorf("%q: expected _c67_runtime_init %v, got %v", source, want, got)
+		} else if got && !bytes.Contains(init, []byte{0x0f, 0xa2}) {
+			t.Errorf("%q: _c67_runtime_init has no cpuid", source)
+		}
+		if _, err := exec.Command(exePath).Output(); err != nil {
+			t.Errorf("%q: %v", source, err)
+		}
+	}
+}
+
 func TestFMAPrecisionOld(t *testing.T) {
 	// Test that FMA provides better precision than mul+add
 	// Simple test showing FMA works correctly with large numbers
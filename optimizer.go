@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"strconv"
 )
 
 // optimizer.go - Compiler optimization passes
@@ -15,6 +16,7 @@ import (
 // - Strength reduction (expensive ops → cheaper ops)
 // - Dead code elimination
 // - Function inlining
+// - Common subexpression elimination
 // - Purity analysis
 // - Closure analysis
 
@@ -24,7 +26,7 @@ func optimizeProgram(program *Program) *Program {
 		program.Statements[i] = foldConstants(stmt)
 	}
 
-	// Pass 2: Constant propagation (x = 5; y = x + 1 → y = 6)
+	// Pass 2: Constant propagation (x = 5; y = x + 1 → y = 6), into lambda bodies too (see constprop.go)
 	constMap := make(map[string]*NumberExpr)
 	for i, stmt := range program.Statements {
 		program.Statements[i] = propagateConstants(stmt, constMap)
@@ -75,6 +77,12 @@ func optimizeProgram(program *Program) *Program {
 		program.Statements[i] = foldConstants(stmt)
 	}
 
+	// Pass 7: Common subexpression elimination (xs[i*n+j] + ys[i*n+j] → t = i*n+j; xs[t] + ys[t])
+	program.Statements = eliminateCommonSubexpressions(program.Statements)
+
+	// Pass 8: Unroll loops with a few constant iterations (see unroll.go)
+	unrollTinyLoops(program)
+
 	return program
 }
 
@@ -134,6 +142,13 @@ func foldConstantExpr(expr Expression) Expression {
 			}
 		}
 
+		// "a" + "b" → "ab"
+		if leftStr, ok := e.Left.(*StringExpr); ok && e.Operator == "+" {
+			if rightStr, ok := e.Right.(*StringExpr); ok {
+				return &StringExpr{Value: leftStr.Value + rightStr.Value}
+			}
+		}
+
 		// Check if both operands are now constants
 		leftNum, leftOk := e.Left.(*NumberExpr)
 		rightNum, rightOk := e.Right.(*NumberExpr)
@@ -168,12 +183,21 @@ func foldConstantExpr(expr Expression) Expression {
 		return e
 
 	case *CallExpr:
+		// defined("NAME") and cfg("NAME") come from -D flags (see defines.go)
+		if isDefineCall(e) {
+			if folded, ok := foldDefineCall(e); ok {
+				return folded
+			}
+		}
 		// Fold arguments
 		for i, arg := range e.Args {
 			e.Args[i] = foldConstantExpr(arg)
 		}
 		return e
 
+	case *FStringExpr:
+		return foldFString(e)
+
 	case *RangeExpr:
 		// Fold range start and end
 		e.Start = foldConstantExpr(e.Start)
@@ -239,6 +263,56 @@ func foldConstantExpr(expr Expression) Expression {
 	}
 }
 
+// foldFString merges the parts of an f-string that are known at compile
+// time into single literals: f"v{1}.{2}-{name}" → f"v1.2-{name}". An
+// f-string that is all literal becomes a plain string in rodata.
+func foldFString(e *FStringExpr) Expression {
+	var parts []Expression
+	var precision []int
+	for i, part := range e.Parts {
+		part = foldConstantExpr(part)
+		text, ok := constantText(part, e.PrecisionOf(i))
+		if !ok {
+			parts = append(parts, part)
+			precision = append(precision, e.PrecisionOf(i))
+			continue
+		}
+		if n := len(parts); n > 0 {
+			if prev, isStr := parts[n-1].(*StringExpr); isStr {
+				parts[n-1] = &StringExpr{Value: prev.Value + text}
+				continue
+			}
+		}
+		parts = append(parts, &StringExpr{Value: text})
+		precision = append(precision, -1)
+	}
+	if len(parts) == 1 {
+		if str, ok := parts[0].(*StringExpr); ok {
+			return str
+		}
+	}
+	e.Parts = parts
+	e.Precision = precision
+	return e
+}
+
+// constantText returns the text an f-string part gives at runtime, if it is
+// known at compile time. Numbers are only folded when they are integers
+// small enough to be printed exactly, without a precision, since _c67_dtoa
+// formats the rest.
+func constantText(part Expression, precision int) (string, bool) {
+	switch p := part.(type) {
+	case *StringExpr:
+		return p.Value, true
+	case *NumberExpr:
+		if precision >= 0 || p.Value != math.Trunc(p.Value) || math.Abs(p.Value) >= 1e15 || math.Signbit(p.Value) && p.Value == 0 {
+			return "", false
+		}
+		return strconv.FormatFloat(p.Value, 'f', -1, 64), true
+	}
+	return "", false
+}
+
 // isPowerOfTwo checks if a float64 value is a power of 2
 func isPowerOfTwo(x float64) bool {
 	if x <= 0 {
@@ -585,7 +659,7 @@ func propagateConstants(stmt Statement, constMap map[string]*NumberExpr) Stateme
 		s.Value = strengthReduceExpr(s.Value)
 
 		// If this is an immutable assignment to a number literal, track it
-		if !s.Mutable && !s.IsUpdate {
+		if !s.Mutable && !s.IsUpdate && !s.IsReuseMutable {
 			if numExpr, ok := s.Value.(*NumberExpr); ok {
 				// Clone the number expression to avoid mutation issues
 				constMap[s.Name] = &NumberExpr{Value: numExpr.Value}
@@ -617,6 +691,7 @@ func propagateConstants(stmt Statement, constMap map[string]*NumberExpr) Stateme
 		}
 		// Remove iterator variable from constants (it changes each iteration)
 		delete(bodyConstMap, s.Iterator)
+		delete(bodyConstMap, s.KeyIterator)
 
 		for i, bodyStmt := range s.Body {
 			s.Body[i] = propagateConstants(bodyStmt, bodyConstMap)
@@ -624,6 +699,8 @@ func propagateConstants(stmt Statement, constMap map[string]*NumberExpr) Stateme
 		return s
 
 	default:
+		// Names assigned in statements that are not looked into are not constants after them
+		forgetAssignedNames(stmt, constMap)
 		return stmt
 	}
 }
@@ -674,8 +751,8 @@ func propagateConstantsExpr(expr Expression, constMap map[string]*NumberExpr) Ex
 		return e
 
 	case *LambdaExpr:
-		// Lambda creates new scope - don't propagate outer constants into lambda body
-		// (More sophisticated analysis could handle this)
+		// Lambda creates new scope - outer constants are visible unless a parameter shadows them (see constprop.go)
+		e.Body = propagateConstantsExpr(e.Body, lambdaConstMap(e, constMap))
 		return e
 
 	case *ParallelExpr:
@@ -719,6 +796,7 @@ func propagateConstantsExpr(expr Expression, constMap map[string]*NumberExpr) Ex
 		for i, stmt := range e.Statements {
 			e.Statements[i] = propagateConstants(stmt, blockConstMap)
 		}
+		specializeLocalLambdas(e.Statements)
 		return e
 
 	case *MoveExpr:
@@ -726,6 +804,10 @@ func propagateConstantsExpr(expr Expression, constMap map[string]*NumberExpr) Ex
 		// The variable must exist at runtime for move semantics to work
 		return e
 
+	case *TryExpr:
+		e.Expr = propagateConstantsExpr(e.Expr, constMap)
+		return e
+
 	case *FMAExpr:
 		e.A = propagateConstantsExpr(e.A, constMap)
 		e.B = propagateConstantsExpr(e.B, constMap)
@@ -748,6 +830,9 @@ func collectUsedVariables(stmt Statement, usedVars map[string]bool) {
 		collectUsedVariablesExpr(s.Iterable, usedVars)
 		// Mark iterator as used (even if not explicitly referenced)
 		usedVars[s.Iterator] = true
+		if s.KeyIterator != "" {
+			usedVars[s.KeyIterator] = true
+		}
 		for _, bodyStmt := range s.Body {
 			collectUsedVariables(bodyStmt, usedVars)
 		}
@@ -847,6 +932,8 @@ func collectUsedVariablesExpr(expr Expression, usedVars map[string]bool) {
 		}
 	case *PostfixExpr:
 		collectUsedVariablesExpr(e.Operand, usedVars)
+	case *TryExpr:
+		collectUsedVariablesExpr(e.Expr, usedVars)
 	case *VectorExpr:
 		for _, comp := range e.Components {
 			collectUsedVariablesExpr(comp, usedVars)
@@ -953,6 +1040,8 @@ func hasSideEffects(expr Expression) bool {
 		return hasSideEffects(e.List) || hasSideEffects(e.Index)
 	case *ParallelExpr:
 		return true // Parallel operations have side effects
+	case *TryExpr:
+		return true // May return from the enclosing function
 	case *PipeExpr:
 		return hasSideEffects(e.Left) || hasSideEffects(e.Right)
 	case *MatchExpr:
@@ -1035,6 +1124,8 @@ func analyzePurityExpr(expr Expression, pureFunctions map[string]bool) {
 	case *PipeExpr:
 		analyzePurityExpr(e.Left, pureFunctions)
 		analyzePurityExpr(e.Right, pureFunctions)
+	case *TryExpr:
+		analyzePurityExpr(e.Expr, pureFunctions)
 	case *MatchExpr:
 		analyzePurityExpr(e.Condition, pureFunctions)
 		for _, clause := range e.Clauses {
@@ -1113,6 +1204,8 @@ func callsImpureFunctions(expr Expression, pureFunctions map[string]bool) bool {
 		return false
 	case *BinaryExpr:
 		return callsImpureFunctions(e.Left, pureFunctions) || callsImpureFunctions(e.Right, pureFunctions)
+	case *TryExpr:
+		return callsImpureFunctions(e.Expr, pureFunctions)
 	case *ListExpr:
 		for _, elem := range e.Elements {
 			if callsImpureFunctions(elem, pureFunctions) {
@@ -1212,6 +1305,8 @@ func collectCapturedVarsExpr(expr Expression, paramSet map[string]bool, captured
 		if e.Value != nil {
 			collectCapturedVarsExpr(e.Value, paramSet, captured)
 		}
+	case *TryExpr:
+		collectCapturedVarsExpr(e.Expr, paramSet, captured)
 	case *FMAExpr:
 		collectCapturedVarsExpr(e.A, paramSet, captured)
 		collectCapturedVarsExpr(e.B, paramSet, captured)
@@ -1265,6 +1360,9 @@ func analyzeClosures(stmt Statement, availableVars map[string]bool, globalVars m
 			newAvailableVars[k] = v
 		}
 		newAvailableVars[s.Iterator] = true
+		if s.KeyIterator != "" {
+			newAvailableVars[s.KeyIterator] = true
+		}
 
 		analyzeClosuresExpr(s.Iterable, availableVars, globalVars)
 		for _, bodyStmt := range s.Body {
@@ -1370,6 +1468,12 @@ func analyzeClosuresExpr(expr Expression, availableVars map[string]bool, globalV
 		}
 	case *UnaryExpr:
 		analyzeClosuresExpr(e.Operand, availableVars, globalVars)
+	case *TryExpr:
+		analyzeClosuresExpr(e.Expr, availableVars, globalVars)
+	case *MultiLambdaExpr:
+		for _, lambda := range e.Lambdas {
+			analyzeClosuresExpr(lambda, availableVars, globalVars)
+		}
 	case *ParallelExpr:
 		analyzeClosuresExpr(e.List, availableVars, globalVars)
 		analyzeClosuresExpr(e.Operation, availableVars, globalVars)
@@ -1384,15 +1488,16 @@ func analyzeClosuresExpr(expr Expression, availableVars map[string]bool, globalV
 }
 
 // collectInlineCandidates identifies lambdas suitable for inlining
-// Criteria: immutable, small body (single expression), not in a loop
+// Criteria: immutable, small body (single expression), not in a loop,
+// unless the definition says inline or noinline (see inline.go)
 func collectInlineCandidates(stmt Statement, candidates map[string]*LambdaExpr) {
 	switch s := stmt.(type) {
 	case *AssignStmt:
 		// Only inline immutable assignments to lambdas
-		if !s.Mutable && !s.IsUpdate {
+		if !s.Mutable && !s.IsUpdate && s.Inline != "noinline" {
 			if lambda, ok := s.Value.(*LambdaExpr); ok {
 				// Only inline simple lambdas (single expression body, no blocks)
-				if !isComplexExpression(lambda.Body) {
+				if !isComplexExpression(lambda.Body) || (s.Inline == "inline" && inlineBlocker(lambda.Body) == nil) {
 					// Store a copy to avoid mutation
 					candidates[s.Name] = &LambdaExpr{
 						Params: lambda.Params,
@@ -1419,6 +1524,8 @@ func isComplexExpression(expr Expression) bool {
 		return true // Don't inline match expressions (can be large)
 	case *ParallelExpr:
 		return true // Don't inline parallel operations
+	case *TryExpr:
+		return true // Don't inline early returns into the caller
 	case *CallExpr:
 		// Allow simple function calls, but not nested complex calls
 		for _, arg := range e.Args {
@@ -1506,6 +1613,8 @@ func countCallsExpr(expr Expression, counts map[string]int) {
 		}
 	case *LambdaExpr:
 		countCallsExpr(e.Body, counts)
+	case *TryExpr:
+		countCallsExpr(e.Expr, counts)
 	case *FMAExpr:
 		countCallsExpr(e.A, counts)
 		countCallsExpr(e.B, counts)
@@ -1631,8 +1740,11 @@ func deepCopyExpr(expr Expression) Expression {
 			newArgs[i] = deepCopyExpr(arg)
 		}
 		return &CallExpr{
-			Function: e.Function,
-			Args:     newArgs,
+			Function:            e.Function,
+			Args:                newArgs,
+			MaxRecursionDepth:   e.MaxRecursionDepth,
+			NeedsRecursionCheck: e.NeedsRecursionCheck,
+			IsCFFI:              e.IsCFFI,
 		}
 	case *ListExpr:
 		newElements := make([]Expression, len(e.Elements))
@@ -1706,8 +1818,11 @@ func substituteParamsExpr(expr Expression, substMap map[string]Expression) Expre
 			newArgs[i] = substituteParamsExpr(arg, substMap)
 		}
 		return &CallExpr{
-			Function: e.Function,
-			Args:     newArgs,
+			Function:            e.Function,
+			Args:                newArgs,
+			MaxRecursionDepth:   e.MaxRecursionDepth,
+			NeedsRecursionCheck: e.NeedsRecursionCheck,
+			IsCFFI:              e.IsCFFI,
 		}
 	case *ListExpr:
 		newElements := make([]Expression, len(e.Elements))
@@ -1732,8 +1847,9 @@ func substituteParamsExpr(expr Expression, substMap map[string]Expression) Expre
 		newClauses := make([]*MatchClause, len(e.Clauses))
 		for i, clause := range e.Clauses {
 			newClause := &MatchClause{
-				Guard:  nil,
-				Result: substituteParamsExpr(clause.Result, substMap),
+				Guard:        nil,
+				Result:       substituteParamsExpr(clause.Result, substMap),
+				IsValueMatch: clause.IsValueMatch,
 			}
 			if clause.Guard != nil {
 				newClause.Guard = substituteParamsExpr(clause.Guard, substMap)
@@ -1745,9 +1861,10 @@ func substituteParamsExpr(expr Expression, substMap map[string]Expression) Expre
 			newDefault = substituteParamsExpr(e.DefaultExpr, substMap)
 		}
 		return &MatchExpr{
-			Condition:   substituteParamsExpr(e.Condition, substMap),
-			Clauses:     newClauses,
-			DefaultExpr: newDefault,
+			Condition:       substituteParamsExpr(e.Condition, substMap),
+			Clauses:         newClauses,
+			DefaultExpr:     newDefault,
+			DefaultExplicit: e.DefaultExplicit,
 		}
 	case *LambdaExpr:
 		// Don't substitute inside nested lambdas' parameters
@@ -1798,6 +1915,7 @@ func substituteParamsStmt(stmt Statement, substMap map[string]Expression) Statem
 		}
 		return &LoopStmt{
 			Iterator:      s.Iterator,
+			KeyIterator:   s.KeyIterator,
 			Iterable:      substituteParamsExpr(s.Iterable, substMap),
 			Body:          newBody,
 			MaxIterations: s.MaxIterations,
@@ -0,0 +1,73 @@
+// Completion: 100% - Parse
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// parse.go - Parsing without panics, for tools and fuzzing
+//
+// The parser reports errors by panicking: the syntax errors it collected,
+// compilerError from the macro expander and optimizer, and runtime errors
+// from its own bugs. CompileC67 recovers all of them into the one error it
+// returns. Parse does the same for the parser alone and keeps them apart, so
+// that a fuzz target can tell input that is rejected from input that
+// crashes the parser:
+//
+//	func FuzzParse(f *testing.F) {
+//	    f.Fuzz(func(t *testing.T, src string) {
+//	        var crash *InternalParseError
+//	        if _, err := Parse(src); errors.As(err, &crash) {
+//	            t.Fatal(crash)
+//	        }
+//	    })
+//	}
+//
+// Parse prints nothing, where ParseProgram prints the collected syntax
+// errors to stderr.
+//
+// The lexer, parser and Program are in package main with the rest of the
+// compiler, so Parse is for tests and fuzz targets in this repository
+// (FuzzParse in parse_test.go); other modules cannot import it.
+
+// InternalParseError is a runtime error inside the parser, which is a bug
+// in the parser whatever the input was
+type InternalParseError struct {
+	Value any    // what the parser panicked with
+	Stack string // where
+}
+
+func (e *InternalParseError) Error() string {
+	return fmt.Sprintf("internal parser error: %v\n%s", e.Value, e.Stack)
+}
+
+// Parse parses input as a C67 program. It returns the syntax errors as one
+// error with all of them, and an *InternalParseError if the parser crashes.
+func Parse(input string) (program *Program, err error) {
+	var p *Parser
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		program = nil
+		switch v := r.(type) {
+		case error:
+			if _, isRuntime := v.(runtime.Error); isRuntime {
+				err = &InternalParseError{Value: v, Stack: string(debug.Stack())}
+			} else if p != nil && p.errors.HasErrors() {
+				err = fmt.Errorf("%s", strings.TrimSpace(p.errors.Report(false)))
+			} else {
+				err = v
+			}
+		default:
+			err = &InternalParseError{Value: v, Stack: string(debug.Stack())}
+		}
+	}()
+	p = NewParser(input)
+	p.quiet = true
+	return p.ParseProgram(), nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	program, err := Parse("f = (a, b) -> a + b\nprintln(f(1, 2))\n")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(program.Statements) != 2 {
+		t.Errorf("expected 2 statements, got %d", len(program.Statements))
+	}
+
+	program, err = Parse("x = (1 + ")
+	if err == nil || program != nil {
+		t.Fatalf("expected a syntax error, got %v", program)
+	}
+	var crash *InternalParseError
+	if errors.As(err, &crash) {
+		t.Fatalf("syntax error reported as a crash: %v", err)
+	}
+	if !strings.Contains(err.Error(), "error") {
+		t.Errorf("expected the error report, got %q", err)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"println(\"Hello, World!\")",
+		"f = (a, b) -> a * b + 1\nexit(f(2, 3))",
+		"sum := 0\n@ i in 0..<10 { sum <- sum + i }",
+		"fact = n -> n { 0 => 1 ~> n * fact(n - 1) }",
+		"m := {name: \"Ada\", age: 36}\nprintln(f\"{m.age}\")",
+		"macro twice(body) {\n    body\n    body\n}\ntwice({ println(1) })",
+		"xs = [x * 2 for x in [1, 2, 3]]",
+		"x = (1 + ",
+		"{[(\"",
+		"macro twice(y) { y }\ntwice(,",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		var crash *InternalParseError
+		if _, err := Parse(src); errors.As(err, &crash) {
+			t.Fatal(crash)
+		}
+	})
+}
@@ -114,6 +114,10 @@ type Parser struct {
 	inConditionLoop bool                    // True when parsing condition loop expression (prevents 'max' consumption)
 	scopes          []map[string]bool       // Stack of variable scopes for shadow detection
 	lambdaParams    []string                // Temporary storage for lambda parameters being parsed
+	comprehensions  int                     // Number of comprehensions parsed, names their hidden accumulators
+	quiet           bool                    // True when errors are returned instead of printed (see Parse)
+	unoptimized     bool                    // True when the program is returned before optimization (see keeptemps.go)
+	fromUsed        bool                    // True when parsing a file of a use statement (see use.go)
 }
 
 type parserState struct {
@@ -205,22 +209,31 @@ func (p *Parser) error(msg string) {
 	}
 
 	// Railway-oriented: collect error and continue if possible
+	length := p.current.Length
+	if length == 0 {
+		length = len(p.current.Value)
+	}
 	err := SyntaxError(msg, SourceLocation{
 		File:   p.filename,
 		Line:   p.current.Line,
 		Column: p.current.Column,
-		Length: len(p.current.Value),
+		Length: length,
 	})
+	// At the end of the file, the likely cause is a bracket left open
+	if p.current.Type == TOKEN_EOF || p.peek.Type == TOKEN_EOF {
+		if open, ok := p.lexer.unclosed(); ok {
+			err.Notes = append(err.Notes, ErrorNote{
+				Message:  fmt.Sprintf("'%s' opened here is never closed", open.Value),
+				Location: SourceLocation{File: p.filename, Line: open.Line, Column: open.Column, Length: 1},
+			})
+		}
+	}
 	p.errors.AddError(err)
 
 	// For backwards compatibility during transition: if we hit max errors, panic
 	// This will be removed once all error handling is converted
 	if p.errors.ShouldStop() {
-		// Print all collected errors before panicking
-		report := p.errors.Report(true) // Use color
-		if report != "" {
-			fmt.Fprintln(os.Stderr, report)
-		}
+		p.reportErrors()
 		panic(fmt.Errorf("too many errors"))
 	}
 }
@@ -233,11 +246,7 @@ func (p *Parser) parseError(msg string, loc SourceLocation) {
 	err := SyntaxError(msg, loc)
 	p.errors.AddError(err)
 	if p.errors.ShouldStop() {
-		// Print all collected errors before panicking
-		report := p.errors.Report(true) // Use color
-		if report != "" {
-			fmt.Fprintln(os.Stderr, report)
-		}
+		p.reportErrors()
 		panic(fmt.Errorf("too many errors"))
 	}
 }
@@ -274,6 +283,17 @@ func (p *Parser) synchronize() {
 	}
 }
 
+// reportErrors prints the collected errors to stderr, unless the parser is
+// quiet because its caller returns them (see Parse)
+func (p *Parser) reportErrors() {
+	if p.quiet {
+		return
+	}
+	if report := p.errors.Report(useColor()); report != "" {
+		fmt.Fprintln(os.Stderr, report)
+	}
+}
+
 // speculativeError is used to signal parse failure during speculative parsing
 type speculativeError struct{}
 
@@ -346,7 +366,21 @@ func (p *Parser) ParseProgram() *Program {
 
 	p.skipNewlines()
 	for p.current.Type != TOKEN_EOF {
+		line := p.current.Line
 		stmt := p.parseStatement()
+		switch s := stmt.(type) {
+		case *AssignStmt:
+			s.Doc = p.lexer.docAbove(line)
+		case *CStructDecl:
+			s.Doc = p.lexer.docAbove(line)
+		case *UseStmt:
+			// Include the file in place of the use statement (see use.go)
+			program.Statements = append(program.Statements, p.includeUse(s)...)
+			stmt = nil
+		}
+		if stmt != nil && !p.defineOnce(stmt, line) {
+			stmt = nil
+		}
 		if stmt != nil {
 			// Handle alias statements: process them immediately and don't add to AST
 			if aliasStmt, ok := stmt.(*AliasStmt); ok {
@@ -371,16 +405,23 @@ func (p *Parser) ParseProgram() *Program {
 
 	// Check for parse errors
 	if p.errors.HasErrors() {
-		// Print all collected errors
-		fmt.Fprintln(os.Stderr, p.errors.Report(true))
+		p.reportErrors()
 		panic(fmt.Errorf("compilation failed with %d error(s)", p.errors.ErrorCount()))
 	}
 
 	// Don't add automatic exit(0) statement - the compiler will emit exit code
 	// after processing deferred statements (see lines 2658-2669 in compileStatement)
 
+	// Expand macros before the optimizer sees the program
+	program = expandMacros(program)
+
+	// Put in the values of pi, e and the other built-in constants
+	resolveBuiltinConstants(program)
+
 	// Apply optimizations
-	program = optimizeProgram(program)
+	if !p.unoptimized {
+		program = optimizeProgram(program)
+	}
 
 	return program
 }
@@ -525,6 +566,64 @@ func (p *Parser) parseArenaStmt() *ArenaStmt {
 	return &ArenaStmt{Body: body}
 }
 
+func (p *Parser) parseMacroDecl() *MacroDecl {
+	p.nextToken() // skip 'macro'
+	if p.functionDepth > 0 {
+		p.error("macros can only be defined at module level")
+	}
+	name := p.current.Value
+	p.nextToken() // skip macro name
+
+	if p.current.Type != TOKEN_LPAREN {
+		p.error("expected '(' after macro name")
+	}
+	p.nextToken() // skip '('
+	var params []string
+	for p.current.Type == TOKEN_IDENT {
+		params = append(params, p.current.Value)
+		p.nextToken()
+		if p.current.Type == TOKEN_COMMA {
+			p.nextToken()
+		}
+	}
+	if p.current.Type != TOKEN_RPAREN {
+		p.error("expected ')' after macro parameters")
+	}
+	p.nextToken() // skip ')'
+
+	if p.current.Type != TOKEN_LBRACE {
+		p.error("expected '{' to start the macro body")
+	}
+	p.nextToken() // skip '{'
+	p.skipNewlines()
+
+	// The body is parsed like a function body, which it ends up in
+	p.functionDepth++
+	p.pushScope()
+	defer func() {
+		p.functionDepth--
+		p.popScope()
+	}()
+	for _, param := range params {
+		p.declareVariable(param)
+	}
+
+	var body []Statement
+	for p.current.Type != TOKEN_RBRACE && p.current.Type != TOKEN_EOF {
+		stmt := p.parseStatement()
+		if stmt != nil {
+			body = append(body, stmt)
+		}
+		p.nextToken()
+		p.skipNewlines()
+	}
+	if p.current.Type != TOKEN_RBRACE {
+		p.error("expected '}' at end of macro body")
+	}
+
+	return &MacroDecl{Name: name, Params: params, Body: body}
+}
+
 func (p *Parser) parseDeferStmt() *DeferStmt {
 	p.nextToken() // skip 'defer'
 
@@ -957,6 +1056,16 @@ func (p *Parser) parseStatement() Statement {
 		return p.parseCStructDecl()
 	}
 
+	// Check for macro definition (macro is only a keyword before a name)
+	if p.current.Type == TOKEN_IDENT && p.current.Value == "macro" && p.peek.Type == TOKEN_IDENT {
+		return p.parseMacroDecl()
+	}
+
+	// Check for inline or noinline before a function definition (see inline.go)
+	if p.current.Type == TOKEN_IDENT && (p.current.Value == "inline" || p.current.Value == "noinline") && p.peek.Type == TOKEN_IDENT {
+		return p.parseInlineHint()
+	}
+
 	// Check for class keyword (class definition)
 	if p.current.Type == TOKEN_CLASS {
 		return p.parseClassDecl()
@@ -999,6 +1108,11 @@ func (p *Parser) parseStatement() Statement {
 		return p.parseJumpStatement()
 	}
 
+	// Check for unroll N @ (see unroll.go)
+	if p.current.Type == TOKEN_IDENT && p.current.Value == "unroll" && p.peek.Type == TOKEN_NUMBER {
+		return p.parseUnrollLoop()
+	}
+
 	// Check for @++ (continue current loop)
 	if p.current.Type == TOKEN_AT_PLUSPLUS {
 		return p.parseLoopStatement()
@@ -1154,6 +1268,17 @@ func (p *Parser) parseStatement() Statement {
 			return &ExpressionStmt{Expr: matchExpr}
 		}
 
+		// setfield(m, name, value) is m[hash(name)] <- value (see fields.go)
+		if call, ok := expr.(*CallExpr); ok && call.Function == "setfield" && len(call.Args) == 3 {
+			if m, ok := call.Args[0].(*IdentExpr); ok {
+				return &MapUpdateStmt{
+					MapName: m.Name,
+					Index:   &CallExpr{Function: "hash", Args: call.Args[1:2]},
+					Value:   call.Args[2],
+				}
+			}
+		}
+
 		return &ExpressionStmt{Expr: expr}
 	}
 
@@ -1230,6 +1355,7 @@ func (p *Parser) parseFString() Expression {
 	raw := p.current.Value // Raw f-string content without f" and "
 
 	var parts []Expression
+	var precisions []int
 	currentPos := 0
 
 	for currentPos < len(raw) {
@@ -1290,8 +1416,12 @@ func (p *Parser) parseFString() Expression {
 			return &StringExpr{Value: raw}
 		}
 
-		// Parse the expression inside {...}
-		exprCode := raw[exprStart:exprEnd]
+		// Parse the expression inside {...}, minus any :.N precision suffix
+		exprCode, precision := splitFStringPrecision(raw[exprStart:exprEnd])
+		if precision > dtoaMaxPrecision {
+			p.error(fmt.Sprintf("f-string precision is at most .%d", dtoaMaxPrecision))
+			precision = dtoaMaxPrecision
+		}
 		exprLexer := NewLexer(exprCode)
 		exprParser := NewParser(exprCode)
 		exprParser.lexer = exprLexer
@@ -1300,7 +1430,11 @@ func (p *Parser) parseFString() Expression {
 
 		expr := exprParser.parseExpression()
 
+		for len(precisions) < len(parts) {
+			precisions = append(precisions, -1)
+		}
 		parts = append(parts, expr)
+		precisions = append(precisions, precision)
 
 		currentPos = exprEnd + 1 // Skip past the }
 	}
@@ -1312,7 +1446,43 @@ func (p *Parser) parseFString() Expression {
 		}
 	}
 
-	return &FStringExpr{Parts: parts}
+	return &FStringExpr{Parts: parts, Precision: precisions}
+}
+
+// splitFStringPrecision splits "x:.2" or "x:.2f" into the expression and the
+// number of digits after the decimal point. Without a specifier the
+// precision is -1, meaning the shortest round-trip form.
+func splitFStringPrecision(code string) (string, int) {
+	trimmed := strings.TrimRight(code, " \t")
+	trimmed = strings.TrimSuffix(trimmed, "f")
+	end := len(trimmed)
+	start := end
+	for start > 0 && trimmed[start-1] >= '0' && trimmed[start-1] <= '9' {
+		start--
+	}
+	if start == end || start < 2 || trimmed[start-1] != '.' || trimmed[start-2] != ':' {
+		return code, -1
+	}
+
+	// The colon must be at the top level, not inside a slice or map literal
+	depth := 0
+	for _, ch := range trimmed[:start-2] {
+		switch ch {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		}
+	}
+	if depth != 0 {
+		return code, -1
+	}
+
+	precision, err := strconv.Atoi(trimmed[start:end])
+	if err != nil {
+		precision = math.MaxInt32
+	}
+	return trimmed[:start-2], precision
 }
 
 // Confidence that this function is working: 100%
@@ -1421,7 +1591,9 @@ func (p *Parser) parseAssignment() *AssignStmt {
 	switch v := value.(type) {
 	case *BlockExpr:
 		// Statement block -> wrap in zero-arg lambda
-		value = &LambdaExpr{Params: []string{}, VariadicParam: "", Body: v}
+		if !v.Comprehension {
+			value = &LambdaExpr{Params: []string{}, VariadicParam: "", Body: v}
+		}
 	case *MatchExpr:
 		// Only wrap guardless match blocks in zero-arg lambda
 		// Match expressions with a condition variable (e.g., `x { 5 => 42 }`) should execute immediately
@@ -1447,8 +1619,9 @@ func (p *Parser) parseAssignment() *AssignStmt {
 	// Check for multiple lambda dispatch: f = (x) -> x, (y) -> y + 1
 	if lambda, ok := value.(*LambdaExpr); ok && p.peek.Type == TOKEN_COMMA {
 		lambdas := []*LambdaExpr{lambda}
+		var merged Expression
 
-		for p.peek.Type == TOKEN_COMMA {
+		for merged == nil && p.peek.Type == TOKEN_COMMA {
 			p.nextToken() // move to comma
 			p.nextToken() // skip comma
 
@@ -1463,15 +1636,22 @@ func (p *Parser) parseAssignment() *AssignStmt {
 				nextExpr = p.parseExpression()
 			}
 
-			if nextLambda, ok := nextExpr.(*LambdaExpr); ok {
-				lambdas = append(lambdas, nextLambda)
-			} else {
+			switch next := nextExpr.(type) {
+			case *LambdaExpr:
+				lambdas = append(lambdas, next)
+			case *PatternLambdaExpr:
+				// (a, b) -> ..., (c) -> ... parses as a pattern lambda and takes the remaining clauses
+				merged = p.mergeLambdaClauses(lambdas, next)
+			default:
 				p.error("expected lambda expression after comma in multiple lambda dispatch")
 			}
 		}
 
 		// Wrap in MultiLambdaExpr
-		value = &MultiLambdaExpr{Lambdas: lambdas}
+		value = merged
+		if merged == nil {
+			value = &MultiLambdaExpr{Lambdas: lambdas}
+		}
 	}
 
 	// Transform compound assignment: x += 5  =>  x = x + 5
@@ -1635,9 +1815,34 @@ func (p *Parser) parseIndexedAssignment() Statement {
 		fmt.Fprintf(os.Stderr, "DEBUG parseIndexedAssignment: after skip '[', current=%v\n", p.current)
 	}
 
+	// Slice target: xs[start:end:step] <- other
+	target := &IdentExpr{Name: ptrName}
+	if p.current.Type == TOKEN_COLON || p.current.Type == TOKEN_COLONCOLON {
+		if p.current.Type == TOKEN_COLON {
+			p.nextToken() // skip ':'
+		}
+		return p.parseSliceAssignment(p.parseSliceRest(target, nil))
+	}
+
 	// Parse the index expression
 	indexExpr := p.parseExpression()
 
+	if p.peek.Type == TOKEN_COLON || p.peek.Type == TOKEN_COLONCOLON {
+		p.nextToken() // move to ':'
+		if p.current.Type == TOKEN_COLON {
+			p.nextToken() // skip ':'
+		}
+		return p.parseSliceAssignment(p.parseSliceRest(target, indexExpr))
+	}
+
+	// Grid cell: grid[x, y] <- value
+	var yExpr Expression
+	if p.peek.Type == TOKEN_COMMA {
+		p.nextToken() // move to ','
+		p.nextToken() // skip ','
+		yExpr = p.parseExpression()
+	}
+
 	if VerboseMode {
 		fmt.Fprintf(os.Stderr, "DEBUG parseIndexedAssignment: after index expr, current=%v, peek=%v\n", p.current, p.peek)
 	}
@@ -1665,6 +1870,13 @@ func (p *Parser) parseIndexedAssignment() Statement {
 	// Move to the last token of the expression
 	p.nextToken()
 
+	if yExpr != nil {
+		return &ExpressionStmt{Expr: &CallExpr{
+			Function: "_grid_set",
+			Args:     []Expression{&IdentExpr{Name: ptrName}, indexExpr, yExpr, valueExpr},
+		}}
+	}
+
 	// Check if this is an unsafe memory write (with cast) or array update (without cast)
 	castExpr, hasCast := valueExpr.(*CastExpr)
 
@@ -1722,6 +1934,64 @@ func (p *Parser) parseIndexedAssignment() Statement {
 	}
 }
 
+// parseSliceRest parses the end and step of a slice after the first ':'
+// and leaves the parser on the closing ']'
+func (p *Parser) parseSliceRest(list, startExpr Expression) *SliceExpr {
+	var endExpr Expression
+	if p.current.Type == TOKEN_RBRACKET {
+		// Case: [start:] or [:]
+		endExpr = nil
+	} else if p.current.Type == TOKEN_COLON || p.current.Type == TOKEN_COLONCOLON {
+		// Case: [start::step] or [::step]
+		endExpr = nil
+		// Don't skip the colon yet - let step handling do it
+	} else {
+		endExpr = p.parseExpression()
+	}
+
+	// Check for step parameter (second colon)
+	var stepExpr Expression
+	if p.peek.Type == TOKEN_COLON || p.current.Type == TOKEN_COLON || p.current.Type == TOKEN_COLONCOLON {
+		if p.current.Type != TOKEN_COLON && p.current.Type != TOKEN_COLONCOLON {
+			p.nextToken() // move to second colon
+		}
+		p.nextToken() // skip ':'
+
+		if p.current.Type == TOKEN_RBRACKET {
+			// Case: [start:end:] - step is nil
+			stepExpr = nil
+		} else {
+			stepExpr = p.parseExpression()
+			p.nextToken() // move to ']'
+		}
+	} else if endExpr != nil {
+		// We parsed an end expression, need to move to ']'
+		p.nextToken()
+	}
+
+	return &SliceExpr{List: list, Start: startExpr, End: endExpr, Step: stepExpr}
+}
+
+// parseSliceAssignment parses the '<- value' part of xs[start:end:step] <- value
+func (p *Parser) parseSliceAssignment(target *SliceExpr) Statement {
+	if p.current.Type != TOKEN_RBRACKET {
+		p.error("expected ']' after slice")
+	}
+	p.nextToken() // skip ']'
+
+	if p.current.Type != TOKEN_LEFT_ARROW {
+		p.error("expected '<-' for slice assignment")
+	}
+	p.nextToken() // skip '<-'
+
+	valueExpr := p.parseExpression()
+
+	// Move to the last token of the expression
+	p.nextToken()
+
+	return &SliceUpdateStmt{Target: target, Value: valueExpr}
+}
+
 // BlockType represents the type of block as determined by disambiguation
 type BlockType int
 
@@ -1734,15 +2004,17 @@ const (
 // parseMapLiteralBody parses the body of a map literal (assumes '{' already consumed)
 // Supports both identifier keys (hashed) and expression keys
 // Format: key: value, key2: value2, ...
-func (p *Parser) parseMapLiteralBody() *MapExpr {
+func (p *Parser) parseMapLiteralBody() Expression {
 	keys := []Expression{}
 	values := []Expression{}
 
 	if p.current.Type != TOKEN_RBRACE {
 		// Parse first key
 		var key Expression
+		keyName := "" // Identifier used as the first key, a variable in comprehensions
 		if p.current.Type == TOKEN_IDENT && p.peek.Type == TOKEN_COLON {
 			// String key: hash identifier to uint64
+			keyName = p.current.Value
 			hashValue := hashStringKey(p.current.Value)
 			key = &NumberExpr{Value: float64(hashValue)}
 			p.nextToken() // move past identifier
@@ -1760,6 +2032,15 @@ func (p *Parser) parseMapLiteralBody() *MapExpr {
 
 		// Parse value
 		value := p.parseExpression()
+		if p.peek.Type == TOKEN_COLON {
+			// {k: v : k in keys if cond} - map comprehension
+			if keyName != "" {
+				key = &IdentExpr{Name: keyName}
+			}
+			p.nextToken() // move to ':'
+			p.nextToken() // skip ':'
+			return p.parseMapComprehension(key, value)
+		}
 		keys = append(keys, key)
 		values = append(values, value)
 
@@ -1797,6 +2078,263 @@ func (p *Parser) parseMapLiteralBody() *MapExpr {
 	return &MapExpr{Keys: keys, Values: values}
 }
 
+// comprehension is the loop part of a list or map comprehension, which the
+// parser desugars into a block that fills a hidden accumulator variable
+type comprehension struct {
+	acc    string      // Name of the accumulator, also the prefix for other hidden variables
+	setup  []Statement // Statements that run before the loop
+	loop   *LoopStmt   // Loop over the iterable, the body is filled in by the caller
+	filter Expression  // Condition after 'if', nil when every element is kept
+}
+
+// parseComprehensionClause parses the part after the ':' in a comprehension:
+// [@@] x in iterable [max N] [if condition], or k, v in iterable for maps and lists
+func (p *Parser) parseComprehensionClause() *comprehension {
+	p.comprehensions++
+	c := &comprehension{
+		acc:  fmt.Sprintf("_comp%d", p.comprehensions),
+		loop: &LoopStmt{MaxIterations: math.MaxInt64},
+	}
+
+	if p.current.Type == TOKEN_AT_AT {
+		c.loop.NumThreads = -1
+		p.nextToken() // skip '@@'
+	}
+
+	if p.current.Type != TOKEN_IDENT {
+		p.error("expected loop variable after ':' in comprehension")
+	}
+	c.loop.Iterator = p.current.Value
+	p.nextToken() // skip iterator
+	if p.current.Type == TOKEN_COMMA {
+		p.nextToken() // skip ','
+		if p.current.Type != TOKEN_IDENT {
+			p.error("expected value variable after ',' in comprehension")
+		}
+		c.loop.KeyIterator = c.loop.Iterator
+		c.loop.Iterator = p.current.Value
+		p.nextToken() // skip value variable
+	}
+
+	if p.current.Type != TOKEN_IN {
+		p.error("expected 'in' keyword in comprehension")
+	}
+	p.nextToken() // skip 'in'
+	c.loop.Iterable = p.parseExpression()
+
+	if p.peek.Type == TOKEN_MAX {
+		p.nextToken() // skip iterable
+		p.nextToken() // skip 'max'
+		c.loop.NeedsMaxCheck = true
+		if p.current.Type == TOKEN_NUMBER {
+			maxInt, err := strconv.ParseInt(p.current.Value, 10, 64)
+			if err != nil || maxInt < 1 {
+				p.error("max iterations must be a positive integer or 'inf'")
+			}
+			c.loop.MaxIterations = maxInt
+		} else if p.current.Type != TOKEN_INF {
+			p.error("expected number or 'inf' after 'max' keyword in comprehension")
+		}
+	}
+
+	switch iterable := c.loop.Iterable.(type) {
+	case *RangeExpr:
+		startVal, startOk := iterable.Start.(*NumberExpr)
+		endVal, endOk := iterable.End.(*NumberExpr)
+		if startOk && endOk && !c.loop.NeedsMaxCheck {
+			c.loop.MaxIterations = iterable.TripCount(int64(startVal.Value), int64(endVal.Value))
+		} else if !c.loop.NeedsMaxCheck {
+			c.loop.MaxIterations = p.defaultLoopMax("comprehension over non-literal range requires explicit 'max' clause")
+			c.loop.NeedsMaxCheck = true
+		}
+	case *ListExpr:
+		if !c.loop.NeedsMaxCheck {
+			c.loop.MaxIterations = int64(len(iterable.Elements))
+		}
+	default:
+		// Evaluate the iterable once, so a map accumulator can be sized with #source
+		source := c.acc + "_src"
+		c.setup = append(c.setup, &AssignStmt{Name: source, Value: iterable})
+		c.loop.Iterable = &IdentExpr{Name: source}
+		c.loop.NeedsMaxCheck = true
+	}
+
+	if c.loop.NumThreads != 0 {
+		if _, isRange := c.loop.Iterable.(*RangeExpr); !isRange {
+			p.error("parallel comprehension needs a range to iterate over (e.g., @@ i in 0..<100)")
+		}
+		if c.loop.KeyIterator != "" {
+			p.error("parallel comprehension takes a single loop variable")
+		}
+	}
+
+	if p.peek.Type == TOKEN_IDENT && p.peek.Value == "if" {
+		p.nextToken() // skip iterable
+		p.nextToken() // skip 'if'
+		c.filter = p.parseExpression()
+	}
+	p.nextToken() // move to closing bracket or brace
+	return c
+}
+
+// guarded wraps statements in the comprehension filter, if there is one
+func (c *comprehension) guarded(stmts ...Statement) []Statement {
+	if c.filter == nil {
+		return stmts
+	}
+	return []Statement{&ExpressionStmt{Expr: &MatchExpr{
+		Condition:   c.filter,
+		Clauses:     []*MatchClause{{Result: &BlockExpr{Statements: stmts}}},
+		DefaultExpr: &NumberExpr{Value: 0},
+	}}}
+}
+
+// parseListComprehension parses the rest of [element : x in iterable if cond]
+// and desugars it into a block that appends each kept element to a new list
+func (p *Parser) parseListComprehension(element Expression) Expression {
+	c := p.parseComprehensionClause()
+	if p.current.Type != TOKEN_RBRACKET {
+		p.error("expected ']' at end of list comprehension")
+	}
+	if c.loop.NumThreads != 0 {
+		return p.parallelListComprehension(element, c)
+	}
+
+	acc := &IdentExpr{Name: c.acc}
+	stmts := append(c.setup, &AssignStmt{Name: c.acc, Value: &ListExpr{}, Mutable: true})
+	c.loop.Body = c.guarded(&AssignStmt{
+		Name:     c.acc,
+		Value:    &CallExpr{Function: "append", Args: []Expression{acc, element}},
+		Mutable:  true,
+		IsUpdate: true,
+	})
+	stmts = append(stmts, c.loop, &ExpressionStmt{Expr: acc})
+	return &BlockExpr{Statements: stmts, Comprehension: true}
+}
+
+// parallelListComprehension desugars [element : @@ x in start..<end if cond].
+// Threads write into a list allocated up front, one slot per iteration, so
+// the elements keep the order of the range. With a filter, a second list
+// marks the kept slots and a sequential loop collects them afterwards.
+func (p *Parser) parallelListComprehension(element Expression, c *comprehension) Expression {
+	rangeExpr := c.loop.Iterable.(*RangeExpr)
+	startVal, startOk := rangeExpr.Start.(*NumberExpr)
+	_, endOk := rangeExpr.End.(*NumberExpr)
+	if !startOk || !endOk {
+		p.error("parallel list comprehension needs a range with literal bounds")
+		return &ListExpr{}
+	}
+	count := &NumberExpr{Value: float64(c.loop.MaxIterations)}
+
+	// Slot of the current element: (x - start) / step
+	var slot Expression = &IdentExpr{Name: c.loop.Iterator}
+	if startVal.Value != 0 {
+		slot = &BinaryExpr{Left: slot, Operator: "-", Right: startVal}
+	}
+	if step := rangeExpr.StepValue(); step != 1 {
+		slot = &BinaryExpr{Left: slot, Operator: "/", Right: &NumberExpr{Value: float64(step)}}
+	}
+
+	acc := &IdentExpr{Name: c.acc}
+	stmts := []Statement{&AssignStmt{
+		Name:    c.acc,
+		Value:   &CallExpr{Function: "_list_alloc", Args: []Expression{count}},
+		Mutable: true,
+	}}
+	if c.filter == nil {
+		c.loop.Body = []Statement{&MapUpdateStmt{MapName: c.acc, Index: slot, Value: element}}
+		return &BlockExpr{Statements: append(stmts, c.loop, &ExpressionStmt{Expr: acc}), Comprehension: true}
+	}
+
+	keep := c.acc + "_keep"
+	out := &IdentExpr{Name: c.acc + "_out"}
+	index := &IdentExpr{Name: c.acc + "_i"}
+	stmts = append(stmts, &AssignStmt{
+		Name:    keep,
+		Value:   &CallExpr{Function: "_list_alloc", Args: []Expression{count}},
+		Mutable: true,
+	})
+	c.loop.Body = c.guarded(
+		&MapUpdateStmt{MapName: c.acc, Index: slot, Value: element},
+		&MapUpdateStmt{MapName: keep, Index: slot, Value: &NumberExpr{Value: 1}},
+	)
+	collect := &LoopStmt{
+		Iterator:      index.Name,
+		Iterable:      &RangeExpr{Start: &NumberExpr{Value: 0}, End: count},
+		MaxIterations: c.loop.MaxIterations,
+		Body: []Statement{&ExpressionStmt{Expr: &MatchExpr{
+			Condition: &IndexExpr{List: &IdentExpr{Name: keep}, Index: index},
+			Clauses: []*MatchClause{{Result: &BlockExpr{Statements: []Statement{&AssignStmt{
+				Name:     out.Name,
+				Value:    &CallExpr{Function: "append", Args: []Expression{out, &IndexExpr{List: acc, Index: index}}},
+				Mutable:  true,
+				IsUpdate: true,
+			}}}}},
+			DefaultExpr: &NumberExpr{Value: 0},
+		}}},
+	}
+	stmts = append(stmts, c.loop, &AssignStmt{Name: out.Name, Value: &ListExpr{}, Mutable: true}, collect, &ExpressionStmt{Expr: out})
+	return &BlockExpr{Statements: stmts, Comprehension: true}
+}
+
+// parseMapComprehension parses the rest of {key: value : x in iterable if cond}
+// and desugars it into a block that fills a map sized for the whole iterable.
+// The map is a sharedmap, so the same code serves @@ comprehensions.
+func (p *Parser) parseMapComprehension(key, value Expression) Expression {
+	c := p.parseComprehensionClause()
+	if p.current.Type != TOKEN_RBRACE {
+		p.error("expected '}' at end of map comprehension")
+	}
+
+	var capacity Expression
+	switch iterable := c.loop.Iterable.(type) {
+	case *RangeExpr:
+		if c.loop.MaxIterations != math.MaxInt64 {
+			capacity = &NumberExpr{Value: float64(c.loop.MaxIterations)}
+		} else {
+			// max inf: the range itself bounds the number of keys
+			capacity = &BinaryExpr{
+				Left:     &CallExpr{Function: "abs", Args: []Expression{&BinaryExpr{Left: iterable.End, Operator: "-", Right: iterable.Start}}},
+				Operator: "+",
+				Right:    &NumberExpr{Value: 1},
+			}
+		}
+	case *ListExpr:
+		capacity = &NumberExpr{Value: float64(len(iterable.Elements))}
+	default:
+		capacity = &LengthExpr{Operand: iterable}
+	}
+
+	stmts := append(c.setup, &AssignStmt{
+		Name:    c.acc,
+		Value:   &CallExpr{Function: "sharedmap", Args: []Expression{capacity}},
+		Mutable: true,
+	})
+	c.loop.Body = c.guarded(&MapUpdateStmt{MapName: c.acc, Index: key, Value: value})
+	stmts = append(stmts, c.loop, &ExpressionStmt{Expr: &IdentExpr{Name: c.acc}})
+	return &BlockExpr{Statements: stmts, Comprehension: true}
+}
+
+// defaultLoopMax returns the --default-loop-max limit for a loop without a
+// max clause, or reports msg when no default is set
+func (p *Parser) defaultLoopMax(msg string) int64 {
+	if DefaultLoopMax > 0 {
+		return DefaultLoopMax
+	}
+	p.error(msg)
+	return 0
+}
+
+// endsOperand reports whether a token of type t can end an operand, so that
+// a ':' after it separates a map key from its value
+func endsOperand(t TokenType) bool {
+	switch t {
+	case TOKEN_IDENT, TOKEN_NUMBER, TOKEN_STRING, TOKEN_RPAREN, TOKEN_RBRACKET, TOKEN_RBRACE:
+		return true
+	}
+	return false
+}
+
 // disambiguateBlock determines block type according to GRAMMAR.md rules:
 // 1. Contains ':' before any arrows → Map literal
 // 2. Contains '->' or '~>' → Match block
@@ -1817,12 +2355,16 @@ func (p *Parser) disambiguateBlock() BlockType {
 	}
 
 	braceDepth := 1 // Start at 1 because we're already inside the opening {
+	nestDepth := 0  // Brackets and parens, so slice colons (xs[1:3]) don't count
 	foundColon := false
 	foundArrow := false
+	prev := p.peek // The lexer is already past the token after {
 
 	// Scan tokens within this block
 	for i := 0; i < maxBlockIterations; i++ {
 		tok := tempLexer.NextToken()
+		last := prev
+		prev = tok
 
 		if tok.Type == TOKEN_EOF {
 			break
@@ -1836,9 +2378,17 @@ func (p *Parser) disambiguateBlock() BlockType {
 				// Exited the block
 				break
 			}
+		} else if tok.Type == TOKEN_LBRACKET || tok.Type == TOKEN_LPAREN {
+			nestDepth++
+		} else if tok.Type == TOKEN_RBRACKET || tok.Type == TOKEN_RPAREN {
+			nestDepth--
 		} else if braceDepth == 1 {
 			// At top level of this block
-			if tok.Type == TOKEN_COLON && !foundArrow {
+			if tok.Type == TOKEN_COLON && !endsOperand(last.Type) {
+				// Symbol literal (s := :name), not a key separator
+				continue
+			}
+			if tok.Type == TOKEN_COLON && !foundArrow && nestDepth <= 0 {
 				// Check if this is a type annotation (x: num = ...) vs map literal (x: value)
 				// Type annotations have a type keyword (as identifier) after the colon
 				nextTok := tempLexer.NextToken()
@@ -2245,6 +2795,7 @@ func (p *Parser) parseMatchTarget() Expression {
 
 	case TOKEN_RET, TOKEN_ERR:
 		// ret/err or ret @N or ret value or ret @N value
+		isErr := p.current.Type == TOKEN_ERR
 		p.nextToken() // skip 'ret'/'err'
 
 		label := 0 // 0 means return from function
@@ -2273,6 +2824,9 @@ func (p *Parser) parseMatchTarget() Expression {
 			value = p.parseExpression()
 			p.nextToken()
 		}
+		if isErr {
+			value = p.errValue(value)
+		}
 
 		// Return a JumpExpr with IsBreak semantics (ret exits loop)
 		return &JumpExpr{Label: label, Value: value, IsBreak: true}
@@ -2510,27 +3064,28 @@ func (p *Parser) parseLoopStatement() Statement {
 			condition := p.parseComparison()
 
 			// After parsing postfix expression, peek should be on 'max'
+			var maxIterations int64
 			if p.peek.Type != TOKEN_MAX {
-				p.error("condition loop requires 'max' clause (e.g., @ n < 5 max 10 { ... })")
-			}
-
-			p.nextToken() // move to 'max'
-			p.nextToken() // skip 'max', now current is on the number/inf
+				maxIterations = p.defaultLoopMax("condition loop requires 'max' clause (e.g., @ n < 5 max 10 { ... })")
+				p.nextToken() // skip the condition
+			} else {
+				p.nextToken() // move to 'max'
+				p.nextToken() // skip 'max', now current is on the number/inf
 
-			// Parse max iterations: either a number or 'inf'
-			var maxIterations int64
-			if p.current.Type == TOKEN_INF {
-				maxIterations = math.MaxInt64
-				p.nextToken() // skip 'inf'
-			} else if p.current.Type == TOKEN_NUMBER {
-				maxInt, err := strconv.ParseInt(p.current.Value, 10, 64)
-				if err != nil || maxInt < 1 {
-					p.error("max iterations must be a positive integer or 'inf'")
+				// Parse max iterations: either a number or 'inf'
+				if p.current.Type == TOKEN_INF {
+					maxIterations = math.MaxInt64
+					p.nextToken() // skip 'inf'
+				} else if p.current.Type == TOKEN_NUMBER {
+					maxInt, err := strconv.ParseInt(p.current.Value, 10, 64)
+					if err != nil || maxInt < 1 {
+						p.error("max iterations must be a positive integer or 'inf'")
+					}
+					maxIterations = maxInt
+					p.nextToken() // skip number
+				} else {
+					p.error("expected number or 'inf' after 'max' keyword")
 				}
-				maxIterations = maxInt
-				p.nextToken() // skip number
-			} else {
-				p.error("expected number or 'inf' after 'max' keyword")
 			}
 
 			// Skip newlines before '{'
@@ -2585,7 +3140,9 @@ func (p *Parser) parseLoopStatement() Statement {
 		firstIdent := p.current.Value
 		p.nextToken() // skip identifier
 
-		// Check if this is a receive loop: @ msg, from in ":5000"
+		// Check if this is a receive loop (@ msg, from in ":5000")
+		// or a key/value loop (@ k, v in m)
+		keyIterator := ""
 		if p.current.Type == TOKEN_COMMA {
 			p.nextToken() // skip comma
 
@@ -2596,54 +3153,60 @@ func (p *Parser) parseLoopStatement() Statement {
 
 			// Expect second identifier
 			if p.current.Type != TOKEN_IDENT {
-				p.error("expected identifier after comma in receive loop")
+				p.error("expected identifier after comma in loop")
 			}
 			secondIdent := p.current.Value
 			p.nextToken() // skip second identifier
 
 			// Expect 'in' keyword
 			if p.current.Type != TOKEN_IN {
-				p.error("expected 'in' in receive loop")
+				p.error("expected 'in' after loop variables")
 			}
-			p.nextToken() // skip 'in'
+			if p.peek.Type != TOKEN_STRING {
+				// Key/value loop: handled by the for-each loop below
+				keyIterator = firstIdent
+				firstIdent = secondIdent
+			} else {
+				p.nextToken() // skip 'in'
 
-			// Parse address expression
-			address := p.parseExpression()
+				// Parse address expression
+				address := p.parseExpression()
 
-			// Expect opening brace for body
-			if p.peek.Type != TOKEN_LBRACE {
-				p.error("expected '{' after receive loop address")
-			}
-			p.nextToken() // move to '{'
+				// Expect opening brace for body
+				if p.peek.Type != TOKEN_LBRACE {
+					p.error("expected '{' after receive loop address")
+				}
+				p.nextToken() // move to '{'
 
-			// Track loop depth for nested loops
-			oldDepth := p.loopDepth
-			p.loopDepth = label
-			defer func() { p.loopDepth = oldDepth }()
+				// Track loop depth for nested loops
+				oldDepth := p.loopDepth
+				p.loopDepth = label
+				defer func() { p.loopDepth = oldDepth }()
 
-			// Parse loop body
-			var body []Statement
-			for p.peek.Type != TOKEN_RBRACE && p.peek.Type != TOKEN_EOF {
-				p.nextToken()
-				if p.current.Type == TOKEN_NEWLINE {
-					continue
-				}
-				stmt := p.parseStatement()
-				if stmt != nil {
-					body = append(body, stmt)
+				// Parse loop body
+				var body []Statement
+				for p.peek.Type != TOKEN_RBRACE && p.peek.Type != TOKEN_EOF {
+					p.nextToken()
+					if p.current.Type == TOKEN_NEWLINE {
+						continue
+					}
+					stmt := p.parseStatement()
+					if stmt != nil {
+						body = append(body, stmt)
+					}
 				}
-			}
 
-			// Consume closing brace
-			if p.peek.Type == TOKEN_RBRACE {
-				p.nextToken() // move to '}'
-			}
+				// Consume closing brace
+				if p.peek.Type == TOKEN_RBRACE {
+					p.nextToken() // move to '}'
+				}
 
-			return &ReceiveLoopStmt{
-				MessageVar: firstIdent,
-				SenderVar:  secondIdent,
-				Address:    address,
-				Body:       body,
+				return &ReceiveLoopStmt{
+					MessageVar: firstIdent,
+					SenderVar:  secondIdent,
+					Address:    address,
+					Body:       body,
+				}
 			}
 		}
 
@@ -2655,6 +3218,9 @@ func (p *Parser) parseLoopStatement() Statement {
 
 			// Parse iterable expression
 			iterable := p.parseExpression()
+			if _, isRange := iterable.(*RangeExpr); isRange && keyIterator != "" {
+				p.error("@ k, v in ... needs a list or map, not a range")
+			}
 
 			// Determine max iterations and whether runtime checking is needed
 			var maxIterations int64
@@ -2686,7 +3252,7 @@ func (p *Parser) parseLoopStatement() Statement {
 			} else {
 				// No explicit max - check if we can determine iteration count at compile time
 				if rangeExpr, ok := iterable.(*RangeExpr); ok {
-					// Try to calculate max from range: end - start
+					// Try to calculate max from the range's trip count
 					startVal, startOk := rangeExpr.Start.(*NumberExpr)
 					endVal, endOk := rangeExpr.End.(*NumberExpr)
 
@@ -2694,19 +3260,21 @@ func (p *Parser) parseLoopStatement() Statement {
 						// Literal range - known at compile time, no runtime check needed
 						start := int64(startVal.Value)
 						end := int64(endVal.Value)
-						maxIterations = end - start
-						if maxIterations < 0 {
-							maxIterations = 0
-						}
+						maxIterations = rangeExpr.TripCount(start, end)
 						needsRuntimeCheck = false
 					} else {
 						// Range bounds are not literals, require explicit max
-						p.error("loop over non-literal range requires explicit 'max' clause")
+						maxIterations = p.defaultLoopMax("loop over non-literal range requires explicit 'max' clause")
+						needsRuntimeCheck = true
 					}
 				} else if listExpr, ok := iterable.(*ListExpr); ok {
 					// List literal - known at compile time, no runtime check needed
 					maxIterations = int64(len(listExpr.Elements))
 					needsRuntimeCheck = false
+				} else if mapExpr, ok := iterable.(*MapExpr); ok {
+					// Map literal - known at compile time, no runtime check needed
+					maxIterations = int64(len(mapExpr.Keys))
+					needsRuntimeCheck = false
 				} else if _, ok := iterable.(*IdentExpr); ok {
 					// Variable (could be a list or map) - use runtime length check
 					maxIterations = math.MaxInt64 // Use max value, will check length at runtime
@@ -2717,7 +3285,8 @@ func (p *Parser) parseLoopStatement() Statement {
 					needsRuntimeCheck = true
 				} else {
 					// Not a range expression or list literal, require explicit max
-					p.error("loop requires 'max' clause (or use range expression like 0..<10 or list literal)")
+					maxIterations = p.defaultLoopMax("loop requires 'max' clause (or use range expression like 0..<10 or list literal)")
+					needsRuntimeCheck = true
 				}
 				// Advance to next token after iterable expression
 				p.nextToken()
@@ -2764,6 +3333,7 @@ func (p *Parser) parseLoopStatement() Statement {
 
 			return &LoopStmt{
 				Iterator:      iterator,
+				KeyIterator:   keyIterator,
 				Iterable:      iterable,
 				Body:          body,
 				MaxIterations: maxIterations,
@@ -2827,7 +3397,7 @@ func (p *Parser) parseLoopStatement() Statement {
 		} else {
 			// No explicit max - check if we can determine iteration count at compile time
 			if rangeExpr, ok := iterable.(*RangeExpr); ok {
-				// Try to calculate max from range: end - start
+				// Try to calculate max from the range's trip count
 				startVal, startOk := rangeExpr.Start.(*NumberExpr)
 				endVal, endOk := rangeExpr.End.(*NumberExpr)
 
@@ -2835,14 +3405,12 @@ func (p *Parser) parseLoopStatement() Statement {
 					// Literal range - known at compile time, no runtime check needed
 					start := int64(startVal.Value)
 					end := int64(endVal.Value)
-					maxIterations = end - start
-					if maxIterations < 0 {
-						maxIterations = 0
-					}
+					maxIterations = rangeExpr.TripCount(start, end)
 					needsRuntimeCheck = false
 				} else {
 					// Range bounds are not literals, require explicit max
-					p.error("loop over non-literal range requires explicit 'max' clause")
+					maxIterations = p.defaultLoopMax("loop over non-literal range requires explicit 'max' clause")
+					needsRuntimeCheck = true
 				}
 			} else if listExpr, ok := iterable.(*ListExpr); ok {
 				// List literal - known at compile time, no runtime check needed
@@ -2858,7 +3426,8 @@ func (p *Parser) parseLoopStatement() Statement {
 				needsRuntimeCheck = true
 			} else {
 				// Not a range expression or list literal, require explicit max
-				p.error("loop requires 'max' clause (or use range expression like 0..<10 or list literal)")
+				maxIterations = p.defaultLoopMax("loop requires 'max' clause (or use range expression like 0..<10 or list literal)")
+				needsRuntimeCheck = true
 			}
 			// Advance to next token after iterable expression
 			p.nextToken()
@@ -3025,7 +3594,8 @@ handleJump:
 // ret @N - exit loop N and all inner loops
 // ret @N value - exit loop N and return value
 func (p *Parser) parseJumpStatement() Statement {
-	p.nextToken() // skip 'ret'
+	isErr := p.current.Type == TOKEN_ERR
+	p.nextToken() // skip 'ret'/'err'
 
 	label := 0 // 0 means return from function
 	var value Expression
@@ -3056,6 +3626,9 @@ func (p *Parser) parseJumpStatement() Statement {
 	if p.current.Type != TOKEN_NEWLINE && p.current.Type != TOKEN_RBRACE && p.current.Type != TOKEN_EOF {
 		value = p.parseExpression()
 	}
+	if isErr {
+		value = p.errValue(value)
+	}
 
 	// ret is always a break/return (IsBreak=true)
 	// label=0 means return from function
@@ -3064,6 +3637,56 @@ func (p *Parser) parseJumpStatement() Statement {
 	return &JumpStmt{IsBreak: true, Label: label, Value: value}
 }
 
+// mergeLambdaClauses combines the lambdas before a pattern lambda with its
+// clauses: into a MultiLambdaExpr if every clause only binds variables,
+// otherwise into one PatternLambdaExpr
+func (p *Parser) mergeLambdaClauses(lambdas []*LambdaExpr, patterns *PatternLambdaExpr) Expression {
+	all := append([]*LambdaExpr{}, lambdas...)
+	for _, clause := range patterns.Clauses {
+		lambda := &LambdaExpr{Params: []string{}, Body: clause.Body}
+		for _, pattern := range clause.Patterns {
+			v, ok := pattern.(*VarPattern)
+			if !ok {
+				lambda = nil
+				break
+			}
+			lambda.Params = append(lambda.Params, v.Name)
+		}
+		if lambda == nil {
+			break
+		}
+		all = append(all, lambda)
+	}
+	if len(all) == len(lambdas)+len(patterns.Clauses) {
+		return &MultiLambdaExpr{Lambdas: all}
+	}
+
+	clauses := make([]*PatternClause, 0, len(lambdas)+len(patterns.Clauses))
+	for _, lambda := range lambdas {
+		if lambda.VariadicParam != "" {
+			p.error("a variadic lambda cannot be combined with pattern clauses")
+		}
+		clause := &PatternClause{Body: lambda.Body}
+		for _, param := range lambda.Params {
+			clause.Patterns = append(clause.Patterns, &VarPattern{Name: param})
+		}
+		clauses = append(clauses, clause)
+	}
+	return &PatternLambdaExpr{Clauses: append(clauses, patterns.Clauses...)}
+}
+
+// errValue wraps the value of an err statement: err "msg" returns err("msg")
+func (p *Parser) errValue(value Expression) Expression {
+	if value == nil {
+		p.error("err requires a message (err \"message\")")
+	}
+	// err("msg") already parses as the constructor call
+	if call, ok := value.(*CallExpr); ok && call.Function == "err" {
+		return value
+	}
+	return &CallExpr{Function: "err", Args: []Expression{value}}
+}
+
 // parsePattern parses a single pattern (literal, variable, or wildcard)
 func (p *Parser) parsePattern() Pattern {
 	switch p.current.Type {
@@ -3390,17 +4013,56 @@ func (p *Parser) parseRange() Expression {
 	left := p.parseAdditive()
 
 	// Check for range operators
-	if p.peek.Type == TOKEN_DOTDOTLT || p.peek.Type == TOKEN_DOTDOT {
+	if p.peek.Type == TOKEN_DOTDOTLT || p.peek.Type == TOKEN_DOTDOTGT || p.peek.Type == TOKEN_DOTDOT {
 		p.nextToken() // move to left expr
-		inclusive := p.current.Type == TOKEN_DOTDOT
+		op := p.current.Type
 		p.nextToken() // skip range operator
 		right := p.parseAdditive()
-		return &RangeExpr{Start: left, End: right, Inclusive: inclusive}
+		rangeExpr := &RangeExpr{Start: left, End: right, Inclusive: op == TOKEN_DOTDOT, Descending: op == TOKEN_DOTDOTGT}
+
+		// Optional step: 0..<100 by 2, 100..>0 by -2
+		if p.peek.Type == TOKEN_IDENT && p.peek.Value == "by" {
+			p.nextToken() // skip right expr
+			p.nextToken() // skip 'by'
+			step := p.parseRangeStep()
+			rangeExpr.Step = int64(step)
+			switch {
+			case step == 0:
+				p.error("range step must not be zero")
+			case step != math.Trunc(step):
+				p.error("range step must be an integer constant")
+			case op == TOKEN_DOTDOTLT && rangeExpr.Step < 0:
+				p.error("ascending range ..< needs a positive step (use ..> to count down)")
+			case op == TOKEN_DOTDOTGT && rangeExpr.Step > 0:
+				p.error("descending range ..> needs a negative step")
+			case rangeExpr.Step < 0:
+				rangeExpr.Descending = true
+			}
+		}
+		return rangeExpr
 	}
 
 	return left
 }
 
+// parseRangeStep parses the number constant after 'by' in a range
+func (p *Parser) parseRangeStep() float64 {
+	negative := false
+	if p.current.Type == TOKEN_MINUS {
+		negative = true
+		p.nextToken()
+	}
+	if p.current.Type != TOKEN_NUMBER {
+		p.error("expected integer constant after 'by' in range")
+		return 1
+	}
+	value := p.parseNumberLiteral(p.current.Value)
+	if negative {
+		value = -value
+	}
+	return value
+}
+
 // parseLambdaBody parses the body of a lambda expression according to GRAMMAR.md:
 //
 // Lambda body can be:
@@ -3496,17 +4158,35 @@ func (p *Parser) parseLambdaBody() Expression {
 func (p *Parser) parseAdditive() Expression {
 	left := p.parseBitwise()
 
-	for p.peek.Type == TOKEN_PLUS || p.peek.Type == TOKEN_MINUS {
+	for p.peek.Type == TOKEN_PLUS || p.peek.Type == TOKEN_MINUS || p.peek.Type == TOKEN_INT_ADD {
 		p.nextToken()
 		op := p.current.Value
 		p.nextToken()
 		right := p.parseBitwise()
-		left = &BinaryExpr{Left: left, Operator: op, Right: right}
+		if op != "+" && op != "-" {
+			// +% and friends are integer operations, never part of an FMA
+			left = &BinaryExpr{Left: left, Operator: op, Right: right}
+			continue
+		}
+		left = fuseMultiplyAdd(left, op, right)
 	}
 
 	return left
 }
 
+// fuseMultiplyAdd turns an explicit fused multiply (a *+ b) on either side of
+// + or - into an FMAExpr, so that `a *+ b + c` and `c + a *+ b` are always
+// lowered to a single FMA instruction. Anything else stays a BinaryExpr.
+func fuseMultiplyAdd(left Expression, op string, right Expression) Expression {
+	if mul, ok := left.(*BinaryExpr); ok && mul.Operator == "*+" {
+		return &FMAExpr{A: mul.Left, B: mul.Right, C: right, IsSub: op == "-"}
+	}
+	if mul, ok := right.(*BinaryExpr); ok && mul.Operator == "*+" && op == "+" {
+		return &FMAExpr{A: mul.Left, B: mul.Right, C: left}
+	}
+	return &BinaryExpr{Left: left, Operator: op, Right: right}
+}
+
 func (p *Parser) parseBitwise() Expression {
 	left := p.parseMultiplicative()
 
@@ -3527,7 +4207,7 @@ func (p *Parser) parseBitwise() Expression {
 func (p *Parser) parseMultiplicative() Expression {
 	left := p.parsePower()
 
-	for p.peek.Type == TOKEN_STAR || p.peek.Type == TOKEN_SLASH || p.peek.Type == TOKEN_MOD || p.peek.Type == TOKEN_FMA {
+	for p.peek.Type == TOKEN_STAR || p.peek.Type == TOKEN_SLASH || p.peek.Type == TOKEN_MOD || p.peek.Type == TOKEN_FMA || p.peek.Type == TOKEN_INT_MUL {
 		p.nextToken()
 		op := p.current.Value
 		p.nextToken()
@@ -3612,54 +4292,38 @@ func (p *Parser) parsePostfix() Expression {
 			var firstExpr Expression
 			var isSlice bool
 			if p.current.Type == TOKEN_COLON {
-				// Case: [:end] or [::step]
+				// Case: [:end] or [: :step]
 				firstExpr = nil
 				isSlice = true
 				p.nextToken() // skip ':'
+			} else if p.current.Type == TOKEN_COLONCOLON {
+				// Case: [::step], the lexer reads both colons as one token
+				firstExpr = nil
+				isSlice = true
 			} else {
 				firstExpr = p.parseExpression()
 				// Check if this is a slice (has colon)
-				isSlice = p.peek.Type == TOKEN_COLON
+				isSlice = p.peek.Type == TOKEN_COLON || p.peek.Type == TOKEN_COLONCOLON
 				if isSlice {
 					p.nextToken() // move to colon
-					p.nextToken() // skip ':'
+					if p.current.Type == TOKEN_COLON {
+						p.nextToken() // skip ':'
+					}
 				}
 			}
 
 			if isSlice {
-				var endExpr Expression
-				if p.current.Type == TOKEN_RBRACKET {
-					// Case: [start:] or [:]
-					endExpr = nil
-				} else if p.current.Type == TOKEN_COLON {
-					// Case: [start::step] or [::step]
-					endExpr = nil
-					// Don't skip the colon yet - let step handling do it
-				} else {
-					endExpr = p.parseExpression()
-				}
-
-				// Check for step parameter (second colon)
-				var stepExpr Expression
-				if p.peek.Type == TOKEN_COLON || p.current.Type == TOKEN_COLON {
-					if p.current.Type != TOKEN_COLON {
-						p.nextToken() // move to second colon
-					}
-					p.nextToken() // skip ':'
-
-					if p.current.Type == TOKEN_RBRACKET {
-						// Case: [start:end:] - step is nil
-						stepExpr = nil
-					} else {
-						stepExpr = p.parseExpression()
-						p.nextToken() // move to ']'
-					}
-				} else if endExpr != nil {
-					// We parsed an end expression, need to move to ']'
-					p.nextToken()
+				expr = p.parseSliceRest(expr, firstExpr)
+			} else if p.peek.Type == TOKEN_COMMA {
+				// grid[x, y] reads a cell of an array2d() grid
+				p.nextToken() // move to ','
+				p.nextToken() // skip ','
+				y := p.parseExpression()
+				p.nextToken() // move to ']'
+				if p.current.Type != TOKEN_RBRACKET {
+					p.error("expected ']' after grid[x, y]")
 				}
-
-				expr = &SliceExpr{List: expr, Start: firstExpr, End: endExpr, Step: stepExpr}
+				expr = &CallExpr{Function: "_grid_get", Args: []Expression{expr, firstExpr, y}}
 			} else {
 				// Regular indexing
 				p.nextToken() // move to ']'
@@ -3699,6 +4363,11 @@ func (p *Parser) parsePostfix() Expression {
 						p.error("vec2 requires exactly 2 arguments")
 					}
 					expr = &VectorExpr{Components: args, Size: 2}
+				} else if ident.Name == "vec3" {
+					if len(args) != 3 {
+						p.error("vec3 requires exactly 3 arguments")
+					}
+					expr = &VectorExpr{Components: args, Size: 3}
 				} else if ident.Name == "vec4" {
 					if len(args) != 4 {
 						p.error("vec4 requires exactly 4 arguments")
@@ -3718,9 +4387,15 @@ func (p *Parser) parsePostfix() Expression {
 			op := p.current.Value
 			expr = &PostfixExpr{Operator: op, Operand: expr}
 		} else if p.peek.Type == TOKEN_BANG {
-			// Handle move operator: x! (transfers ownership)
 			p.nextToken() // skip to !
-			expr = &MoveExpr{Expr: expr}
+			switch expr.(type) {
+			case *CallExpr, *DirectCallExpr:
+				// Handle error propagation: f(x)! returns an error result to the caller
+				expr = &TryExpr{Expr: expr}
+			default:
+				// Handle move operator: x! (transfers ownership)
+				expr = &MoveExpr{Expr: expr}
+			}
 		} else if p.peek.Type == TOKEN_HASH {
 			// Handle postfix length operator: xs#
 			p.nextToken() // skip to #
@@ -3807,6 +4482,28 @@ func (p *Parser) parsePostfix() Expression {
 							p.error("cstruct '" + cstruct.Name + "' has no field '" + fieldName + "'")
 						}
 					}
+				} else if fieldName == "easy" && p.cImports[ident.Name] && p.peek.Type == TOKEN_DOT {
+					// Generated convenience wrapper: sdl.easy.window(...)
+					p.nextToken() // skip 'easy'
+					p.nextToken() // skip '.'
+					if p.current.Type != TOKEN_IDENT || p.peek.Type != TOKEN_LPAREN {
+						p.error("expected function call after '" + ident.Name + ".easy.'")
+					}
+					wrapperName := ident.Name + ".easy." + p.current.Value
+					p.nextToken() // skip wrapper name
+					p.nextToken() // skip '('
+					args := []Expression{}
+
+					if p.current.Type != TOKEN_RPAREN {
+						args = append(args, p.parseExpression())
+						for p.peek.Type == TOKEN_COMMA {
+							p.nextToken() // skip current
+							p.nextToken() // skip ','
+							args = append(args, p.parseExpression())
+						}
+						p.nextToken() // move to ')'
+					}
+					expr = &CallExpr{Function: wrapperName, Args: args}
 				} else if p.peek.Type == TOKEN_LPAREN {
 					// Check if this is a C import namespace (e.g., sdl, c) or a method call (e.g., xs.append)
 					if p.cImports[ident.Name] {
@@ -3914,6 +4611,14 @@ func (p *Parser) parsePostfix() Expression {
 
 func (p *Parser) parsePrimary() Expression {
 	switch p.current.Type {
+	case TOKEN_ERR:
+		// err("message") in an expression constructs an error value
+		if p.peek.Type != TOKEN_LPAREN {
+			p.error("expected '(' after err in expression (use err(\"message\"))")
+		}
+		p.current.Type = TOKEN_IDENT
+		return p.parsePrimary()
+
 	case TOKEN_ARROW:
 		// Explicit no-argument lambda: -> expr or -> { ... }
 		p.nextToken()               // skip '->'
@@ -3938,6 +4643,15 @@ func (p *Parser) parsePrimary() Expression {
 		val := p.parseNumberLiteral(p.current.Value)
 		return &NumberExpr{Value: val}
 
+	case TOKEN_COLON:
+		// Symbol literal: :name, the same key as the identifier in {name: v}
+		if p.peek.Type != TOKEN_IDENT || p.peek.Line != p.current.Line || p.peek.Column != p.current.Column+1 {
+			p.error("expected identifier directly after ':' in symbol literal")
+			return &NumberExpr{Value: 0}
+		}
+		p.nextToken() // skip ':'
+		return &NumberExpr{Value: symbolValue(p.current.Value)}
+
 	case TOKEN_INF:
 		return &NumberExpr{Value: math.Inf(1)}
 
@@ -4038,6 +4752,11 @@ func (p *Parser) parsePrimary() Expression {
 					p.error("vec2 requires exactly 2 arguments")
 				}
 				return &VectorExpr{Components: args, Size: 2}
+			} else if name == "vec3" {
+				if len(args) != 3 {
+					p.error("vec3 requires exactly 3 arguments")
+				}
+				return &VectorExpr{Components: args, Size: 3}
 			} else if name == "vec4" {
 				if len(args) != 4 {
 					p.error("vec4 requires exactly 4 arguments")
@@ -4247,7 +4966,14 @@ func (p *Parser) parsePrimary() Expression {
 		elements := []Expression{}
 
 		if p.current.Type != TOKEN_RBRACKET {
-			elements = append(elements, p.parseExpression())
+			first := p.parseExpression()
+			if p.peek.Type == TOKEN_COLON {
+				// [f(x) : x in xs if cond] - list comprehension
+				p.nextToken() // move to ':'
+				p.nextToken() // skip ':'
+				return p.parseListComprehension(first)
+			}
+			elements = append(elements, first)
 			for p.peek.Type == TOKEN_COMMA {
 				p.nextToken() // skip current
 				p.nextToken() // skip ','
@@ -4383,10 +5109,13 @@ func (p *Parser) parsePrimary() Expression {
 	// These are valid tokens that signal the end of an expression, not syntax errors
 	if p.current.Type == TOKEN_RBRACE || p.current.Type == TOKEN_RPAREN ||
 		p.current.Type == TOKEN_RBRACKET || p.current.Type == TOKEN_COMMA ||
-		p.current.Type == TOKEN_SEMICOLON || p.current.Type == TOKEN_NEWLINE ||
-		p.current.Type == TOKEN_EOF {
+		p.current.Type == TOKEN_SEMICOLON || p.current.Type == TOKEN_NEWLINE {
 		return nil // Valid delimiter, expression ends here
 	}
+	if p.current.Type == TOKEN_EOF {
+		p.error("unexpected end of input, expected an expression")
+		return nil
+	}
 
 	// Unrecognized token in expression position - this is a syntax error
 	p.error(fmt.Sprintf("unexpected '%s' in expression", p.current.Value))
@@ -4517,7 +5246,7 @@ func (p *Parser) parseLoopExpr() Expression {
 	} else {
 		// No explicit max - check if we can determine iteration count at compile time
 		if rangeExpr, ok := iterable.(*RangeExpr); ok {
-			// Try to calculate max from range: end - start
+			// Try to calculate max from the range's trip count
 			startVal, startOk := rangeExpr.Start.(*NumberExpr)
 			endVal, endOk := rangeExpr.End.(*NumberExpr)
 
@@ -4525,14 +5254,12 @@ func (p *Parser) parseLoopExpr() Expression {
 				// Literal range - known at compile time, no runtime check needed
 				start := int64(startVal.Value)
 				end := int64(endVal.Value)
-				maxIterations = end - start
-				if maxIterations < 0 {
-					maxIterations = 0
-				}
+				maxIterations = rangeExpr.TripCount(start, end)
 				needsRuntimeCheck = false
 			} else {
 				// Range bounds are not literals, require explicit max
-				p.error("loop expression over non-literal range requires explicit 'max' clause")
+				maxIterations = p.defaultLoopMax("loop expression over non-literal range requires explicit 'max' clause")
+				needsRuntimeCheck = true
 			}
 		} else if listExpr, ok := iterable.(*ListExpr); ok {
 			// List literal - known at compile time, no runtime check needed
@@ -4540,7 +5267,8 @@ func (p *Parser) parseLoopExpr() Expression {
 			needsRuntimeCheck = false
 		} else {
 			// Not a range expression or list literal, require explicit max
-			p.error("loop expression requires 'max' clause (or use range expression like 0..<10 or list literal)")
+			maxIterations = p.defaultLoopMax("loop expression requires 'max' clause (or use range expression like 0..<10 or list literal)")
+			needsRuntimeCheck = true
 		}
 	}
 
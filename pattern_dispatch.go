@@ -0,0 +1,213 @@
+// Completion: 100% - Decision-tree dispatch for pattern lambdas
+package main
+
+// pattern_dispatch.go - Dispatch on literal clauses of pattern lambdas
+//
+// A pattern lambda whose leading clauses match the first parameter against
+// number literals (opcode handlers, state machines) is dispatched on that
+// value instead of testing the clauses one by one:
+//
+//	op = (0) -> nop(), (1) -> load(), (2) -> store(), ..., (n) -> bad(n)
+//
+// Dense integer literals use a jump table, anything else a binary search over
+// the sorted values. Clauses that share a literal are still tried in source
+// order, and a value that matches none of them continues with the first
+// clause after the literal run, so matching behaves exactly like the linear
+// scan.
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+const (
+	patternDispatchMinValues  = 4    // Fewer distinct literals are cheaper to test linearly
+	patternJumpTableMaxSpan   = 4096 // Largest jump table, in entries
+	patternJumpTableMaxSparse = 4    // Jump table entries allowed per distinct literal
+	patternSearchLeafSize     = 3    // Compare this many values in a row at the leaves
+)
+
+// patternDispatch describes the literal run at the start of a pattern lambda
+type patternDispatch struct {
+	count       int             // Number of leading clauses with a literal first pattern
+	values      []float64       // Distinct literal values, sorted
+	firstClause map[float64]int // Clause index to jump to for each value
+	nextClause  []int           // Next clause with the same value, or -1
+}
+
+// patternJumpKind selects how a patternJump is patched
+type patternJumpKind int
+
+const (
+	patternJumpConditional patternJumpKind = iota // 6-byte jcc rel32
+	patternJumpAlways                             // 5-byte jmp rel32
+	patternJumpTableEntry                         // int32 relative to the table start
+)
+
+// patternJump is a jump to a clause label that is patched once all labels are marked
+type patternJump struct {
+	pos    int
+	target string
+	kind   patternJumpKind
+	base   int // Table start for patternJumpTableEntry
+}
+
+// planPatternDispatch returns the dispatch plan for the clauses, or nil if
+// they are better served by testing them in order
+func planPatternDispatch(clauses []*PatternClause) *patternDispatch {
+	d := &patternDispatch{firstClause: make(map[float64]int)}
+	lastClause := make(map[float64]int)
+	for i, clause := range clauses {
+		if len(clause.Patterns) == 0 {
+			break
+		}
+		lit, ok := clause.Patterns[0].(*LiteralPattern)
+		if !ok {
+			break
+		}
+		num, ok := lit.Value.(*NumberExpr)
+		if !ok || math.IsNaN(num.Value) {
+			break
+		}
+		v := num.Value
+		if v == 0 {
+			v = 0 // -0 and 0 compare equal, so they share a slot
+		}
+		d.nextClause = append(d.nextClause, -1)
+		if prev, seen := lastClause[v]; seen {
+			d.nextClause[prev] = i
+		} else {
+			d.firstClause[v] = i
+			d.values = append(d.values, v)
+		}
+		lastClause[v] = i
+		d.count = i + 1
+	}
+	if len(d.values) < patternDispatchMinValues {
+		return nil
+	}
+	sort.Float64s(d.values)
+	return d
+}
+
+// jumpTableSpan returns the number of jump table entries for the values, or 0
+// if they are not dense integers
+func (d *patternDispatch) jumpTableSpan() int {
+	lo, hi := d.values[0], d.values[len(d.values)-1]
+	for _, v := range d.values {
+		if v != math.Trunc(v) || math.Abs(v) >= math.MaxInt32 {
+			return 0
+		}
+	}
+	span := int(hi-lo) + 1
+	if span > patternJumpTableMaxSpan || span > patternJumpTableMaxSparse*len(d.values) {
+		return 0
+	}
+	return span
+}
+
+// emitPatternDispatch jumps to the first clause whose literal equals the
+// parameter stored at [rbp-paramOffset], or to defaultLabel if there is none.
+// Clobbers rax, rcx, xmm0 and xmm1.
+func (fc *C67Compiler) emitPatternDispatch(d *patternDispatch, paramOffset int, clauseLabels []string, defaultLabel string) []patternJump {
+	var jumps []patternJump
+	jumpTo := func(cond JumpCondition, target string) {
+		jumps = append(jumps, patternJump{pos: fc.eb.text.Len(), target: target, kind: patternJumpConditional})
+		fc.out.JumpConditional(cond, 0)
+	}
+	jumpAlways := func(target string) {
+		jumps = append(jumps, patternJump{pos: fc.eb.text.Len(), target: target, kind: patternJumpAlways})
+		fc.out.JumpUnconditional(0)
+	}
+	valueLabel := func(v float64) string {
+		return clauseLabels[d.firstClause[v]]
+	}
+
+	fc.out.MovMemToXmm("xmm0", "rbp", -paramOffset)
+	// NaN matches no literal
+	fc.out.Ucomisd("xmm0", "xmm0")
+	jumpTo(JumpParity, defaultLabel)
+
+	if span := d.jumpTableSpan(); span > 0 {
+		lo := int64(d.values[0])
+
+		// Only whole numbers inside [lo, lo+span) can hit a table entry
+		fc.out.Cvttsd2si("rax", "xmm0")
+		fc.out.Cvtsi2sd("xmm1", "rax")
+		fc.out.Ucomisd("xmm0", "xmm1")
+		jumpTo(JumpNotEqual, defaultLabel)
+		if lo != 0 {
+			fc.out.SubImmFromReg("rax", lo)
+		}
+		fc.out.CmpRegToImm("rax", int64(span-1))
+		jumpTo(JumpAbove, defaultLabel)
+
+		// rax = table + table[rax]; jmp rax
+		leaPos := fc.eb.text.Len()
+		fc.out.Emit([]byte{0x48, 0x8d, 0x0d, 0, 0, 0, 0}) // lea rcx, [rip+table]
+		fc.out.Emit([]byte{0x48, 0x63, 0x04, 0x81})       // movsxd rax, dword [rcx+rax*4]
+		fc.out.AddRegToReg("rax", "rcx")
+		fc.out.Emit([]byte{0xff, 0xe0}) // jmp rax
+
+		tableStart := fc.eb.text.Len()
+		fc.patchJumpImmediate(leaPos+3, int32(tableStart-(leaPos+7)))
+		for i := 0; i < span; i++ {
+			target := defaultLabel
+			if _, ok := d.firstClause[float64(lo+int64(i))]; ok {
+				target = valueLabel(float64(lo + int64(i)))
+			}
+			jumps = append(jumps, patternJump{pos: fc.eb.text.Len(), target: target, kind: patternJumpTableEntry, base: tableStart})
+			fc.out.Emit([]byte{0, 0, 0, 0})
+		}
+		return jumps
+	}
+
+	// Binary search over the sorted literals (the value is not NaN here)
+	var search func(lo, hi int)
+	search = func(lo, hi int) {
+		if hi-lo < patternSearchLeafSize {
+			for i := lo; i <= hi; i++ {
+				fc.emitLoadFloatConst("xmm1", d.values[i])
+				fc.out.Ucomisd("xmm0", "xmm1")
+				jumpTo(JumpEqual, valueLabel(d.values[i]))
+			}
+			jumpAlways(defaultLabel)
+			return
+		}
+		mid := (lo + hi) / 2
+		fc.emitLoadFloatConst("xmm1", d.values[mid])
+		fc.out.Ucomisd("xmm0", "xmm1")
+		jumpTo(JumpEqual, valueLabel(d.values[mid]))
+		below := fc.forwardJump(JumpBelow)
+		search(mid+1, hi)
+		fc.landForwardJump(below)
+		search(lo, mid-1)
+	}
+	search(0, len(d.values)-1)
+	return jumps
+}
+
+// emitLoadFloatConst loads a float64 constant into an XMM register through rax
+func (fc *C67Compiler) emitLoadFloatConst(xmm string, v float64) {
+	fc.out.MovImmToReg("rax", strconv.FormatUint(math.Float64bits(v), 10))
+	fc.out.MovqRegToXmm(xmm, "rax")
+}
+
+// patchPatternJumps resolves jumps emitted for a pattern lambda once its labels are marked
+func (fc *C67Compiler) patchPatternJumps(jumps []patternJump) {
+	for _, jump := range jumps {
+		targetOffset := fc.eb.LabelOffset(jump.target)
+		if targetOffset < 0 {
+			compilerError("pattern lambda jump target not found: %s", jump.target)
+		}
+		switch jump.kind {
+		case patternJumpConditional:
+			fc.patchJumpImmediate(jump.pos+2, int32(targetOffset-(jump.pos+6)))
+		case patternJumpAlways:
+			fc.patchJumpImmediate(jump.pos+1, int32(targetOffset-(jump.pos+5)))
+		case patternJumpTableEntry:
+			fc.patchJumpImmediate(jump.pos, int32(targetOffset-jump.base))
+		}
+	}
+}
@@ -0,0 +1,482 @@
+// Completion: 100% - Persistent lists and maps
+package main
+
+import (
+	"math"
+	"strconv"
+)
+
+// persistent.go - Persistent collections: ilist(xs), imap(m), with, without
+//
+// Pure code that wants "the same list, but with xs[3] set to 0" has to copy
+// the whole list. A persistent collection is a hash trie instead, so
+//
+//	v1 = ilist([10, 20, 30])
+//	v2 = with(v1, 1, 25)      // v1 is unchanged
+//
+// copies only the path from the root to the changed element, O(log n) of
+// it, and shares everything else with v1. The value is a small header that
+// looks like a map to #:
+//
+//	[ptr+0] count (float64)
+//	[ptr+8] root (0 when empty)
+//
+// A node has 16 slots, picked by 4 bits of the key hash per level (mapHash,
+// as for the hash-indexed maps, so -0 and 0 are one key). A slot is 0, a
+// node pointer, or a leaf pointer with the low bit set; a leaf is a key and
+// its value. mapHash is a bijection on the 64 key bits, so two keys always
+// part ways within 16 levels.
+//
+// ilist(xs) and imap(m) build the trie from the universal layout. An ilist is
+// keyed by position, whatever the keys in xs, so with(v, #v, x) appends.
+// without(v, key) drops a key, and a key that is not there gives back v
+// itself. c[key] reads a variable bound to one of these calls; a missing key
+// reads as 0, as for any map. This is the x86-64 runtime.
+
+// persistentNodeSize is the size of a trie node: 16 slots of 8 bytes
+const persistentNodeSize = 16 * 8
+
+// persistentBuiltins are the calls that give a persistent collection
+var persistentBuiltins = map[string]bool{"ilist": true, "imap": true, "with": true, "without": true}
+
+// trackPersistent records whether s binds a persistent collection, so that
+// name[key] reads it through the trie
+func (fc *C67Compiler) trackPersistent(s *AssignStmt) {
+	if fc.getExprType(s.Value) == "persistent" {
+		fc.persistentVars[s.Name] = true
+	} else {
+		delete(fc.persistentVars, s.Name)
+	}
+}
+
+// compilePersistentCall compiles ilist(xs), imap(m), with(v, key, value) and
+// without(v, key), leaving the new collection in xmm0
+func (fc *C67Compiler) compilePersistentCall(call *CallExpr) {
+	fc.usesPersistent = true
+	switch call.Function {
+	case "ilist", "imap":
+		if len(call.Args) > 1 {
+			compilerError("%s() takes at most 1 argument, got %d", call.Function, len(call.Args))
+		}
+		var source Expression = &ListExpr{}
+		if len(call.Args) == 1 {
+			source = call.Args[0]
+		}
+		if ident, ok := source.(*IdentExpr); ok && fc.persistentVars[ident.Name] {
+			compilerError("%s() needs a list or map, but '%s' is already persistent", call.Function, ident.Name)
+		}
+		fc.compileExpression(source)
+		fc.out.MovqXmmToReg("rdi", "xmm0")
+		fc.loadArenaPointer("rsi")
+		if call.Function == "ilist" {
+			fc.out.MovImmToReg("rdx", "1")
+		} else {
+			fc.out.XorRegWithReg("rdx", "rdx")
+		}
+		fc.trackFunctionCall("_c67_pmap_from")
+		fc.eb.GenerateCallInstruction("_c67_pmap_from")
+
+	case "with":
+		if len(call.Args) != 3 {
+			compilerError("with() requires exactly 3 arguments (collection, key, value)")
+		}
+		fc.checkPersistentArg(call)
+		fc.compileExpression(call.Args[0])
+		fc.out.SubImmFromReg("rsp", 16)
+		fc.out.MovXmmToMem("xmm0", "rsp", 0)
+		fc.compileExpression(call.Args[1])
+		fc.out.MovXmmToMem("xmm0", "rsp", StackSlotSize)
+		fc.compileExpression(call.Args[2])
+		fc.out.MovXmmToXmm("xmm1", "xmm0")
+		fc.out.MovMemToXmm("xmm0", "rsp", StackSlotSize)
+		fc.out.MovMemToReg("rdi", "rsp", 0)
+		fc.out.AddImmToReg("rsp", 16)
+		fc.loadArenaPointer("rsi")
+		fc.out.XorRegWithReg("rdx", "rdx")
+		fc.trackFunctionCall("_c67_pmap_with")
+		fc.eb.GenerateCallInstruction("_c67_pmap_with")
+
+	case "without":
+		if len(call.Args) != 2 {
+			compilerError("without() requires exactly 2 arguments (collection, key)")
+		}
+		fc.checkPersistentArg(call)
+		fc.compileExpression(call.Args[0])
+		fc.out.SubImmFromReg("rsp", 16)
+		fc.out.MovXmmToMem("xmm0", "rsp", 0)
+		fc.compileExpression(call.Args[1])
+		fc.out.MovMemToReg("rdi", "rsp", 0)
+		fc.out.AddImmToReg("rsp", 16)
+		fc.loadArenaPointer("rsi")
+		fc.trackFunctionCall("_c67_pmap_without")
+		fc.eb.GenerateCallInstruction("_c67_pmap_without")
+	}
+	fc.out.MovqRegToXmm("xmm0", "rax")
+}
+
+// checkPersistentArg rejects with() and without() on a variable that is
+// known to hold an ordinary list or map
+func (fc *C67Compiler) checkPersistentArg(call *CallExpr) {
+	ident, ok := call.Args[0].(*IdentExpr)
+	if !ok || fc.persistentVars[ident.Name] {
+		return
+	}
+	if t := fc.varTypes[ident.Name]; t == "list" || t == "map" || t == "string" {
+		compilerError("%s() needs a persistent collection, but '%s' is a %s; make one with ilist() or imap()", call.Function, ident.Name, t)
+	}
+}
+
+// compilePersistentGet compiles name[key] for a persistent collection
+func (fc *C67Compiler) compilePersistentGet(name string, key Expression) {
+	fc.usesPersistent = true
+	fc.compileExpression(key)
+	fc.out.SubImmFromReg("rsp", 16)
+	fc.out.MovXmmToMem("xmm0", "rsp", 0)
+	fc.compileExpression(&IdentExpr{Name: name})
+	fc.out.MovqXmmToReg("rdi", "xmm0")
+	fc.out.MovMemToXmm("xmm0", "rsp", 0)
+	fc.out.AddImmToReg("rsp", 16)
+	fc.trackFunctionCall("_c67_pmap_get")
+	fc.eb.GenerateCallInstruction("_c67_pmap_get")
+}
+
+// generatePersistentHelpers emits the runtime of persistent collections
+func (fc *C67Compiler) generatePersistentHelpers() {
+	fc.generatePersistentGet()
+	fc.generatePersistentWith()
+	fc.generatePersistentWithout()
+	fc.generatePersistentFrom()
+}
+
+// emitPersistentAlloc allocates size bytes from the arena in r15 into rax.
+// Clobbers the caller-saved registers.
+func (fc *C67Compiler) emitPersistentAlloc(size int) {
+	fc.out.MovRegToReg("rdi", "r15")
+	fc.out.MovImmToReg("rsi", strconv.Itoa(size))
+	fc.trackFunctionCall("c67_arena_alloc")
+	fc.out.CallSymbol("c67_arena_alloc")
+}
+
+// emitPersistentDescend stores the node in rax into the slot at r14 and
+// moves down to the child of that node for the hash in r13: r14 = its slot,
+// rbx = its contents, and r12 counts the hash bits used up
+func (fc *C67Compiler) emitPersistentDescend() {
+	fc.out.MovRegToMem("rax", "r14", 0)
+	fc.out.MovRegToReg("rcx", "r13")
+	fc.out.AndRegWithImm("rcx", 15)
+	fc.out.Emit([]byte{0x4c, 0x8d, 0x34, 0xc8}) // lea r14, [rax+rcx*8]
+	fc.out.MovMemToReg("rbx", "r14", 0)
+	fc.out.ShrRegByImm("r13", 4)
+	fc.out.AddImmToReg("r12", 4)
+}
+
+// emitPersistentPrologue sets up the frame of _c67_pmap_with and
+// _c67_pmap_without, with 48 bytes of locals at rsp
+func (fc *C67Compiler) emitPersistentPrologue() {
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+	fc.out.PushReg("r15")
+	fc.out.SubImmFromReg("rsp", 48)
+}
+
+// emitPersistentEpilogue returns from a frame set up by emitPersistentPrologue
+func (fc *C67Compiler) emitPersistentEpilogue() {
+	fc.out.AddImmToReg("rsp", 48)
+	fc.out.PopReg("r15")
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generatePersistentGet emits _c67_pmap_get(rdi=collection, xmm0=key) ->
+// xmm0, the value of key or 0 when it is missing. Clobbers rax, rcx, rdx
+// and xmm5.
+func (fc *C67Compiler) generatePersistentGet() {
+	fc.eb.MarkFunction("_c67_pmap_get")
+	fc.out.PushReg("r13")
+	fc.emitMapNormalizeKey()
+	fc.emitMapHash()
+	fc.out.MovMemToReg("rax", "rdi", 8)
+
+	loop := fc.eb.text.Len()
+	fc.out.TestRegReg("rax", "rax")
+	missing := fc.forwardJump(JumpEqual)
+	fc.out.Emit([]byte{0xa8, 0x01}) // test al, 1
+	leaf := fc.forwardJump(JumpNotEqual)
+	fc.out.MovRegToReg("rcx", "r13")
+	fc.out.AndRegWithImm("rcx", 15)
+	fc.out.Emit([]byte{0x48, 0x8b, 0x04, 0xc8}) // mov rax, [rax+rcx*8]
+	fc.out.ShrRegByImm("r13", 4)
+	fc.out.JumpUnconditional(int32(loop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(leaf)
+	fc.out.AndRegWithImm("rax", -2)
+	fc.out.MovMemToXmm("xmm5", "rax", 0)
+	fc.out.Ucomisd("xmm5", "xmm0")
+	unordered := fc.forwardJump(JumpParity)
+	other := fc.forwardJump(JumpNotEqual)
+	fc.out.MovMemToXmm("xmm0", "rax", 8)
+	done := fc.forwardJumpAlways()
+	fc.landForwardJump(missing)
+	fc.landForwardJump(unordered)
+	fc.landForwardJump(other)
+	fc.out.XorpdXmm("xmm0", "xmm0")
+	fc.landForwardJump(done)
+	fc.out.PopReg("r13")
+	fc.out.Ret()
+}
+
+// generatePersistentWith emits _c67_pmap_with(rdi=collection, rsi=arena,
+// rdx=in place, xmm0=key, xmm1=value) -> rax, the collection with key set
+// to value. The nodes on the path to the key are copied, unless rdx is not
+// 0, which _c67_pmap_from uses on a trie that nothing shares yet.
+func (fc *C67Compiler) generatePersistentWith() {
+	fc.eb.MarkFunction("_c67_pmap_with")
+	fc.emitPersistentPrologue()
+
+	// [rsp] = value, [rsp+8] = key, [rsp+16] = in place, [rsp+24] = new
+	// header, [rsp+32] = a node being split off, [rsp+40] = the hash
+	fc.out.MovRegToReg("r15", "rsi")
+	fc.emitMapNormalizeKey()
+	fc.out.MovXmmToMem("xmm1", "rsp", 0)
+	fc.out.MovXmmToMem("xmm0", "rsp", 8)
+	fc.out.MovRegToMem("rdx", "rsp", 16)
+	fc.out.MovRegToReg("rbx", "rdi")
+	fc.emitMapHash()
+
+	// The new header starts as a copy of the old one
+	fc.out.MovRegToReg("rax", "rbx")
+	fc.out.MovMemToReg("rcx", "rsp", 16)
+	fc.out.TestRegReg("rcx", "rcx")
+	inPlace := fc.forwardJump(JumpNotEqual)
+	fc.emitPersistentAlloc(16)
+	fc.out.MovMemToReg("rcx", "rbx", 0)
+	fc.out.MovRegToMem("rcx", "rax", 0)
+	fc.out.MovMemToReg("rcx", "rbx", 8)
+	fc.out.MovRegToMem("rcx", "rax", 8)
+	fc.landForwardJump(inPlace)
+	fc.out.MovRegToMem("rax", "rsp", 24)
+	fc.out.LeaMemToReg("r14", "rax", 8)
+	fc.out.MovMemToReg("rbx", "r14", 0)
+	fc.out.XorRegWithReg("r12", "r12")
+
+	// rbx is the slot contents at this level, r14 the slot in the new path
+	loop := fc.eb.text.Len()
+	fc.out.TestRegReg("rbx", "rbx")
+	empty := fc.forwardJump(JumpEqual)
+	fc.out.Emit([]byte{0xf6, 0xc3, 0x01}) // test bl, 1
+	leaf := fc.forwardJump(JumpNotEqual)
+
+	// A node: copy it, unless the trie is being built in place
+	fc.out.MovRegToReg("rax", "rbx")
+	fc.out.MovMemToReg("rcx", "rsp", 16)
+	fc.out.TestRegReg("rcx", "rcx")
+	owned := fc.forwardJump(JumpNotEqual)
+	fc.emitPersistentAlloc(persistentNodeSize)
+	fc.out.MovRegToReg("rdi", "rax")
+	fc.out.MovRegToReg("rsi", "rbx")
+	fc.out.MovImmToReg("rcx", strconv.Itoa(persistentNodeSize))
+	fc.out.RepMovsb()
+	fc.landForwardJump(owned)
+	descend := fc.eb.text.Len()
+	fc.emitPersistentDescend()
+	fc.out.JumpUnconditional(int32(loop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	// A leaf for another key: push it down into a new node and go on there
+	fc.landForwardJump(leaf)
+	fc.out.MovRegToReg("rax", "rbx")
+	fc.out.AndRegWithImm("rax", -2)
+	fc.out.MovMemToXmm("xmm0", "rax", 0)
+	fc.out.MovMemToXmm("xmm1", "rsp", 8)
+	fc.out.Ucomisd("xmm0", "xmm1")
+	unordered := fc.forwardJump(JumpParity)
+	sameKey := fc.forwardJump(JumpEqual)
+	fc.landForwardJump(unordered)
+	fc.emitPersistentAlloc(persistentNodeSize)
+	fc.out.MovRegToMem("rax", "rsp", 32)
+	fc.out.MovRegToReg("rdi", "rax")
+	fc.out.XorRegWithReg("rax", "rax")
+	fc.out.MovImmToReg("rcx", strconv.Itoa(persistentNodeSize))
+	fc.out.Emit([]byte{0xf3, 0xaa}) // rep stosb
+	fc.out.MovRegToReg("rax", "rbx")
+	fc.out.AndRegWithImm("rax", -2)
+	fc.out.MovMemToXmm("xmm0", "rax", 0)
+	fc.out.MovRegToMem("r13", "rsp", 40)
+	fc.emitMapHash()
+	fc.out.MovRegToReg("rcx", "r12")
+	fc.out.ShrClReg("r13", "cl")
+	fc.out.MovRegToReg("rdx", "r13")
+	fc.out.AndRegWithImm("rdx", 15)
+	fc.out.MovMemToReg("r13", "rsp", 40)
+	fc.out.MovMemToReg("rax", "rsp", 32)
+	fc.out.Emit([]byte{0x48, 0x89, 0x1c, 0xd0}) // mov [rax+rdx*8], rbx
+	fc.out.JumpUnconditional(int32(descend - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	// The key is new: one more entry
+	fc.landForwardJump(empty)
+	fc.out.MovMemToReg("rax", "rsp", 24)
+	fc.out.MovMemToXmm("xmm0", "rax", 0)
+	fc.out.MovImmToReg("rcx", strconv.FormatUint(math.Float64bits(1.0), 10))
+	fc.out.MovqRegToXmm("xmm1", "rcx")
+	fc.out.AddsdXmm("xmm0", "xmm1")
+	fc.out.MovXmmToMem("xmm0", "rax", 0)
+
+	// Write a new leaf into the slot, for a new key and for a new value
+	fc.landForwardJump(sameKey)
+	fc.emitPersistentAlloc(16)
+	fc.out.MovMemToXmm("xmm0", "rsp", 8)
+	fc.out.MovXmmToMem("xmm0", "rax", 0)
+	fc.out.MovMemToXmm("xmm1", "rsp", 0)
+	fc.out.MovXmmToMem("xmm1", "rax", 8)
+	fc.out.OrRegWithImm("rax", 1)
+	fc.out.MovRegToMem("rax", "r14", 0)
+
+	fc.out.MovMemToReg("rax", "rsp", 24)
+	fc.emitPersistentEpilogue()
+}
+
+// generatePersistentWithout emits _c67_pmap_without(rdi=collection,
+// rsi=arena, xmm0=key) -> rax, the collection without key. The nodes on the
+// path to the key are copied; a missing key gives back the collection.
+func (fc *C67Compiler) generatePersistentWithout() {
+	fc.eb.MarkFunction("_c67_pmap_without")
+	fc.emitPersistentPrologue()
+
+	fc.out.MovRegToReg("r15", "rsi")
+	fc.out.MovRegToReg("rbx", "rdi")
+	fc.emitMapNormalizeKey()
+	fc.emitMapHash()
+	fc.out.CallSymbol("_c67_pmap_contains")
+	fc.out.TestRegReg("rax", "rax")
+	found := fc.forwardJump(JumpNotEqual)
+	fc.out.MovRegToReg("rax", "rbx")
+	fc.emitPersistentEpilogue()
+
+	// Copy the header with one entry less, then the nodes down to the leaf
+	fc.landForwardJump(found)
+	fc.emitPersistentAlloc(16)
+	fc.out.MovMemToXmm("xmm0", "rbx", 0)
+	fc.out.MovImmToReg("rcx", strconv.FormatUint(math.Float64bits(1.0), 10))
+	fc.out.MovqRegToXmm("xmm1", "rcx")
+	fc.out.SubsdXmm("xmm0", "xmm1")
+	fc.out.MovXmmToMem("xmm0", "rax", 0)
+	fc.out.MovMemToReg("rcx", "rbx", 8)
+	fc.out.MovRegToMem("rcx", "rax", 8)
+	fc.out.MovRegToMem("rax", "rsp", 24)
+	fc.out.LeaMemToReg("r14", "rax", 8)
+	fc.out.MovMemToReg("rbx", "r14", 0)
+	fc.out.XorRegWithReg("r12", "r12")
+
+	loop := fc.eb.text.Len()
+	fc.out.Emit([]byte{0xf6, 0xc3, 0x01}) // test bl, 1
+	leaf := fc.forwardJump(JumpNotEqual)
+	fc.emitPersistentAlloc(persistentNodeSize)
+	fc.out.MovRegToReg("rdi", "rax")
+	fc.out.MovRegToReg("rsi", "rbx")
+	fc.out.MovImmToReg("rcx", strconv.Itoa(persistentNodeSize))
+	fc.out.RepMovsb()
+	fc.emitPersistentDescend()
+	fc.out.JumpUnconditional(int32(loop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(leaf)
+	fc.out.MovImmToMem(0, "r14", 0)
+	fc.out.MovMemToReg("rax", "rsp", 24)
+	fc.emitPersistentEpilogue()
+}
+
+// generatePersistentFrom emits _c67_pmap_from(rdi=list or map, rsi=arena,
+// rdx=by position) -> rax, a persistent collection with the same values,
+// keyed by the keys of the entries or, if rdx is not 0, by their positions,
+// and _c67_pmap_contains, which _c67_pmap_without needs
+func (fc *C67Compiler) generatePersistentFrom() {
+	fc.eb.MarkFunction("_c67_pmap_from")
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+	fc.out.PushReg("r15")
+	fc.out.SubImmFromReg("rsp", StackSlotSize) // Align for the calls
+	fc.out.MovRegToMem("rdx", "rsp", 0)
+
+	// r12 = source, rbx = its count, r13 = entry number, r14 = new header
+	fc.out.MovRegToReg("r12", "rdi")
+	fc.out.MovRegToReg("r15", "rsi")
+	fc.out.MovMemToXmm("xmm0", "r12", 0)
+	fc.out.Cvttsd2si("rbx", "xmm0")
+	fc.emitPersistentAlloc(16)
+	fc.out.MovImmToMem(0, "rax", 0)
+	fc.out.MovImmToMem(0, "rax", 8)
+	fc.out.MovRegToReg("r14", "rax")
+	fc.out.XorRegWithReg("r13", "r13")
+
+	loop := fc.eb.text.Len()
+	fc.out.CmpRegToReg("r13", "rbx")
+	done := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovRegToReg("rax", "r13")
+	fc.out.ShlRegByImm("rax", 4)
+	fc.out.AddRegToReg("rax", "r12")
+	fc.out.MovMemToXmm("xmm0", "rax", 8)
+	fc.out.MovMemToXmm("xmm1", "rax", 16)
+	fc.out.MovMemToReg("rcx", "rsp", 0)
+	fc.out.TestRegReg("rcx", "rcx")
+	byKey := fc.forwardJump(JumpEqual)
+	fc.out.Cvtsi2sd("xmm0", "r13")
+	fc.landForwardJump(byKey)
+	fc.out.MovRegToReg("rdi", "r14")
+	fc.out.MovRegToReg("rsi", "r15")
+	fc.out.MovImmToReg("rdx", "1")
+	fc.out.CallSymbol("_c67_pmap_with")
+	fc.out.IncReg("r13")
+	fc.out.JumpUnconditional(int32(loop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(done)
+	fc.out.MovRegToReg("rax", "r14")
+	fc.out.AddImmToReg("rsp", StackSlotSize)
+	fc.out.PopReg("r15")
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+
+	// _c67_pmap_contains(rdi=collection, xmm0=key) -> rax, 1 if key is there,
+	// else 0. Clobbers rcx, rdx and xmm5.
+	fc.eb.MarkFunction("_c67_pmap_contains")
+	fc.out.PushReg("r13")
+	fc.emitMapNormalizeKey()
+	fc.emitMapHash()
+	fc.out.MovMemToReg("rax", "rdi", 8)
+	walk := fc.eb.text.Len()
+	fc.out.TestRegReg("rax", "rax")
+	missing := fc.forwardJump(JumpEqual)
+	fc.out.Emit([]byte{0xa8, 0x01}) // test al, 1
+	leaf := fc.forwardJump(JumpNotEqual)
+	fc.out.MovRegToReg("rcx", "r13")
+	fc.out.AndRegWithImm("rcx", 15)
+	fc.out.Emit([]byte{0x48, 0x8b, 0x04, 0xc8}) // mov rax, [rax+rcx*8]
+	fc.out.ShrRegByImm("r13", 4)
+	fc.out.JumpUnconditional(int32(walk - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(leaf)
+	fc.out.AndRegWithImm("rax", -2)
+	fc.out.MovMemToXmm("xmm5", "rax", 0)
+	fc.out.XorRegWithReg("rax", "rax")
+	fc.out.Ucomisd("xmm5", "xmm0")
+	unordered := fc.forwardJump(JumpParity)
+	other := fc.forwardJump(JumpNotEqual)
+	fc.out.MovImmToReg("rax", "1")
+	fc.landForwardJump(missing)
+	fc.landForwardJump(unordered)
+	fc.landForwardJump(other)
+	fc.out.PopReg("r13")
+	fc.out.Ret()
+}
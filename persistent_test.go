@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestPersistentListWith tests that with() leaves the original list unchanged
+func TestPersistentListWith(t *testing.T) {
+	source := `v1 = ilist([10, 20, 30])
+v2 = with(v1, 1, 25)
+v3 = with(v2, #v2, 40)
+println(v1[1] + v2[1])
+println(#v1 * 10 + #v3)
+println(v3[3] + v1[3])
+`
+	result := compileAndRun(t, source)
+	if !strings.Contains(result, "45\n34\n40\n") {
+		t.Errorf("Expected output to contain '45\\n34\\n40\\n', got: %s", result)
+	}
+}
+
+// TestPersistentMapWithout tests imap(), without() and -0 as a key
+func TestPersistentMapWithout(t *testing.T) {
+	source := `m = imap({1: 100, 2: 200})
+m2 = without(m, 1)
+m3 = without(m2, 5)
+println(m[1] + m2[1] + m2[2])
+println(#m * 100 + #m2 * 10 + #m3)
+e = with(imap(), -0, 7)
+println(e[0])
+`
+	result := compileAndRun(t, source)
+	if !strings.Contains(result, "300\n211\n7\n") {
+		t.Errorf("Expected output to contain '300\\n211\\n7\\n', got: %s", result)
+	}
+}
+
+// TestPersistentVersions tests that every version of a growing collection keeps its elements
+func TestPersistentVersions(t *testing.T) {
+	source := `v := ilist()
+@ i in 0..<500 {
+    v <- with(v, i, i * 2)
+}
+old = v
+v <- with(v, 5, 0)
+v <- without(v, 499)
+println(#old)
+println(#v)
+println(old[5] + old[499] + v[5] + v[499] + v[498])
+`
+	result := compileAndRun(t, source)
+	if !strings.Contains(result, "500\n499\n2004\n") {
+		t.Errorf("Expected output to contain '500\\n499\\n2004\\n', got: %s", result)
+	}
+}
+
+// TestPersistentUpdateInPlace tests that a persistent collection cannot be updated with <-
+func TestPersistentUpdateInPlace(t *testing.T) {
+	code := `main = {
+    v := ilist([1, 2])
+    v[0] <- 5
+}
+`
+	_, err := compileTestCodeAllowError(t, code)
+	if err == nil || !strings.Contains(err.Error(), "use with(v, key, value)") {
+		t.Errorf("Expected an error that suggests with(), got: %v", err)
+	}
+}
@@ -47,6 +47,11 @@ func (ds *DynamicSections) GenerateGOT(functions []string, dynamicAddr uint64, p
 		}
 		binary.Write(&ds.got, binary.LittleEndian, pltPushAddr)
 	}
+
+	// GOT[n+1..] = data symbol addresses (filled by .rela.dyn)
+	for range ds.dataSymbols {
+		binary.Write(&ds.got, binary.LittleEndian, uint64(0))
+	}
 }
 
 // GetPLTOffset returns the offset within PLT for a function
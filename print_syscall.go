@@ -14,7 +14,7 @@ func (fc *C67Compiler) generatePrintSyscall() {
 		return
 	}
 
-	fc.eb.MarkLabel("_c67_print_syscall")
+	fc.eb.MarkFunction("_c67_print_syscall")
 
 	// Prologue
 	fc.out.PushReg("rbp")
@@ -56,11 +56,10 @@ func (fc *C67Compiler) generatePrintSyscall() {
 	fc.out.MovRegToMem("rdi", "r13", 0)
 
 	// write(1, buffer, 1)
-	fc.out.MovImmToReg("rax", "1")   // syscall: write
 	fc.out.MovImmToReg("rdi", "1")   // fd: stdout
 	fc.out.MovRegToReg("rsi", "r13") // buffer
 	fc.out.MovImmToReg("rdx", "1")   // length: 1
-	fc.out.Syscall()
+	fc.emitBufferedWrite()
 
 	// Increment index and loop
 	fc.out.IncReg("r14")
@@ -93,7 +92,7 @@ func (fc *C67Compiler) generatePrintlnSyscall() {
 		return
 	}
 
-	fc.eb.MarkLabel("_c67_println_syscall")
+	fc.eb.MarkFunction("_c67_println_syscall")
 
 	// Prologue
 	fc.out.PushReg("rbp")
@@ -135,11 +134,10 @@ func (fc *C67Compiler) generatePrintlnSyscall() {
 	fc.out.MovRegToMem("rdi", "r13", 0)
 
 	// write(1, buffer, 1)
-	fc.out.MovImmToReg("rax", "1")
 	fc.out.MovImmToReg("rdi", "1")
 	fc.out.MovRegToReg("rsi", "r13")
 	fc.out.MovImmToReg("rdx", "1")
-	fc.out.Syscall()
+	fc.emitBufferedWrite()
 
 	// Increment and loop
 	fc.out.IncReg("r14")
@@ -153,11 +151,10 @@ func (fc *C67Compiler) generatePrintlnSyscall() {
 	// Write newline
 	fc.out.MovImmToReg("rax", "10") // '\n'
 	fc.out.MovRegToMem("rax", "r13", 0)
-	fc.out.MovImmToReg("rax", "1")
 	fc.out.MovImmToReg("rdi", "1")
 	fc.out.MovRegToReg("rsi", "r13")
 	fc.out.MovImmToReg("rdx", "1")
-	fc.out.Syscall()
+	fc.emitBufferedWrite()
 
 	// Epilogue
 	fc.out.AddImmToReg("rsp", 8)
@@ -299,6 +299,291 @@ println(f"x={x}, y={y}")
 	}
 }
 
+// TestFloatPrinting tests shortest round-trip number printing and f-string precision
+func TestFloatPrinting(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("Syscall tests only run on Linux x86_64")
+	}
+
+	tests := []struct {
+		name       string
+		code       string
+		wantStdout string
+	}{
+		{
+			name:       "println fraction",
+			code:       `println(7.5)`,
+			wantStdout: "7.5\n",
+		},
+		{
+			name: "println shortest round-trip",
+			code: `
+println(0.1)
+println(0.1 + 0.2)
+println(1 / 3)
+println(-2.25)
+`,
+			wantStdout: "0.1\n0.30000000000000004\n0.3333333333333333\n-2.25\n",
+		},
+		{
+			name: "println whole numbers",
+			code: `
+println(0)
+println(-7)
+println(123456789012)
+`,
+			wantStdout: "0\n-7\n123456789012\n",
+		},
+		{
+			name: "println exponent notation",
+			code: `
+big := 1000000000000000000000.0
+println(big / 10)
+println(big)
+println(0.000001)
+println(0.0000001)
+`,
+			wantStdout: "100000000000000000000\n1e+21\n0.000001\n1e-7\n",
+		},
+		{
+			name: "println 17 digits",
+			code: `
+println(sqrt(2))
+println(1 / 7)
+println(2 / 3 * 10000000000000000000000 * 10000000000000000000000)
+`,
+			wantStdout: "1.4142135623730951\n0.14285714285714285\n6.666666666666666e+43\n",
+		},
+		{
+			name: "println extreme exponents",
+			code: `
+println(100000000000000000000000)
+println(123456789 / 1000000000000000000000000000000)
+big := 9007199254740991.0
+@ i in 0..<971 {
+    big <- big * 2
+}
+println(big)
+println(big / 3)
+`,
+			wantStdout: "1e+23\n1.23456789e-22\n1.7976931348623157e+308\n5.992310449541053e+307\n",
+		},
+		{
+			name: "println subnormals",
+			code: `
+tiny := 1.0
+@ i in 0..<1074 {
+    tiny <- tiny / 2
+}
+println(tiny)
+println(tiny * 3)
+println(-tiny * 4503599627370496)
+`,
+			wantStdout: "5e-324\n1.5e-323\n-2.2250738585072014e-308\n",
+		},
+		{
+			name: "println negative zero",
+			code: `
+println(-0.0)
+println(0.0)
+`,
+			wantStdout: "-0\n0\n",
+		},
+		{
+			name:       "println NaN",
+			code:       `println(0 / 0)`,
+			wantStdout: "NaN\n",
+		},
+		{
+			name:       "print fraction",
+			code:       `print(3.14)`,
+			wantStdout: "3.14",
+		},
+		{
+			name: "f-string shortest",
+			code: `
+x := 2.5
+println(f"x={x} half={x / 2}")
+`,
+			wantStdout: "x=2.5 half=1.25\n",
+		},
+		{
+			name: "f-string precision",
+			code: `
+x := 3.14159
+println(f"{x:.2} {x:.0} {x:.4f} {-0.125:.1}")
+`,
+			wantStdout: "3.14 3 3.1416 -0.1\n",
+		},
+		{
+			name: "f-string precision pads whole numbers",
+			code: `
+n := 42
+println(f"{n:.3} {0.05:.1}")
+`,
+			wantStdout: "42.000 0.1\n",
+		},
+		{
+			name: "f-string precision beyond 17 digits",
+			code: `
+h := 1.0
+@ i in 0..<21 {
+    h <- h / 2
+}
+println(f"{1/3:.20} {0.1:.30}")
+println(f"{h:.20} {h * 3:.20} {-0.0:.2}")
+`,
+			wantStdout: "0.33333333333333331483 0.100000000000000005551115123126\n" +
+				"0.00000047683715820312 0.00000143051147460938 -0.00\n",
+		},
+		{
+			name: "f-string precision of extreme numbers",
+			code: `
+big := 9007199254740991.0
+@ i in 0..<971 {
+    big <- big * 2
+}
+tiny := 1.0
+@ i in 0..<1074 {
+    tiny <- tiny / 2
+}
+println(f"{big:.2}")
+println(f"{-tiny * 4503599627370496:.3} {100000000000000000000000:.0}")
+`,
+			wantStdout: "179769313486231570814527423731704356798070567525844996598917476803157260780028538760589558632766878171540458953514382464234321326889464182768467546703537516986049910576551282076245490090389328944075868508455133942304583236903222948165808559332123348274797826204144723168738177180919299881250404026184124858368.00\n" +
+				"-0.000 99999999999999991611392\n",
+		},
+		{
+			name: "println list",
+			code: `
+xs := [1.5, 2, 3]
+println(xs)
+`,
+			wantStdout: "1.5\n2\n3\n",
+		},
+		{
+			name: "as string",
+			code: `
+s := 0.5 as string
+println(s + "!")
+`,
+			wantStdout: "0.5!\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binary := compileTestBinary(t, tt.code)
+			cmd := exec.Command(binary)
+			stdout, stderr, exitCode := runCommand(cmd)
+
+			if exitCode != 0 {
+				t.Errorf("Unexpected exit code %d, stderr: %s", exitCode, stderr)
+			}
+
+			if stdout != tt.wantStdout {
+				t.Errorf("stdout mismatch:\nwant: %q\ngot:  %q", tt.wantStdout, stdout)
+			}
+		})
+	}
+}
+
+// TestFStringPrecisionLimit tests that a precision above dtoaMaxPrecision is
+// a compile error rather than silently fewer digits
+func TestFStringPrecisionLimit(t *testing.T) {
+	if _, err := compileTestCodeAllowError(t, `println(f"{1/3:.100}")`); err != nil {
+		t.Errorf("Expected .100 to compile, got: %v", err)
+	}
+	if _, err := compileTestCodeAllowError(t, `println(f"{1/3:.101}")`); err == nil {
+		t.Error("Expected a compile error for .101")
+	}
+}
+
+// TestBufferedStdout tests that buffered stdout is flushed on exit and stays in order with stderr
+func TestBufferedStdout(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("Syscall tests only run on Linux x86_64")
+	}
+
+	tests := []struct {
+		name         string
+		code         string
+		wantOutput   string
+		wantExitCode int
+	}{
+		{
+			name:       "flushed at end of program",
+			code:       `print("no newline")`,
+			wantOutput: "no newline",
+		},
+		{
+			name: "flushed before exit",
+			code: `
+print("pending")
+exit(3)
+`,
+			wantOutput:   "pending",
+			wantExitCode: 3,
+		},
+		{
+			name: "ordered with stderr",
+			code: `
+print("a")
+eprintln("err")
+println("b")
+`,
+			wantOutput: "aerr\nb\n",
+		},
+		{
+			name: "literals around the inline limit",
+			code: `
+print("12345678")
+print("123456789")
+println("")
+println("0123456789012345678901234567890")
+println("01234567890123456789012345678901")
+`,
+			wantOutput: "12345678123456789\n0123456789012345678901234567890\n01234567890123456789012345678901\n",
+		},
+		{
+			name: "larger than the buffer",
+			code: `
+@ i in 0..<2000 {
+    print("abc")
+}
+println("")
+`,
+			wantOutput: strings.Repeat("abc", 2000) + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binary := compileTestBinary(t, tt.code)
+			var out strings.Builder
+			cmd := exec.Command(binary)
+			cmd.Stdout = &out
+			cmd.Stderr = &out
+			exitCode := 0
+			if err := cmd.Run(); err != nil {
+				exitError, ok := err.(*exec.ExitError)
+				if !ok {
+					t.Fatalf("Failed to run binary: %v", err)
+				}
+				exitCode = exitError.ExitCode()
+			}
+
+			if exitCode != tt.wantExitCode {
+				t.Errorf("exit code = %d, want %d", exitCode, tt.wantExitCode)
+			}
+
+			if out.String() != tt.wantOutput {
+				t.Errorf("output mismatch:\nwant: %q\ngot:  %q", tt.wantOutput, out.String())
+			}
+		})
+	}
+}
+
 // Helper: compile test code and return binary path
 func compileTestBinary(t *testing.T, code string) string {
 	t.Helper()
@@ -74,7 +74,7 @@ func EmitPrintfRuntimeX86_64(eb *ExecutableBuilder, out *Out) error {
 		fmt.Fprintf(os.Stderr, "DEBUG: Emitting _c67_printf for x86-64\n")
 	}
 
-	eb.MarkLabel("_c67_printf")
+	eb.MarkFunction("_c67_printf")
 
 	// Prologue: set up stack frame and preserve callee-saved registers
 	// We need significant stack space for:
@@ -258,7 +258,7 @@ func EmitPrintfRuntimeARM64(eb *ExecutableBuilder, out *Out) error {
 	// TODO: Implement ARM64-specific assembly
 	// For now, emit a stub that returns 0
 
-	eb.MarkLabel("_c67_printf")
+	eb.MarkFunction("_c67_printf")
 
 	// Stub: just return 0
 	// MOV x0, #0
@@ -295,7 +295,7 @@ func EmitPrintfRuntimeRISCV64(eb *ExecutableBuilder, out *Out) error {
 	// TODO: Implement RISC-V-specific assembly
 	// For now, emit a stub that returns 0
 
-	eb.MarkLabel("_c67_printf")
+	eb.MarkFunction("_c67_printf")
 
 	// Stub: just return 0
 	// li a0, 0
@@ -65,7 +65,7 @@ func EmitFloatToStringRuntime(eb *ExecutableBuilder, out *Out) error {
 		fmt.Fprintf(os.Stderr, "DEBUG: Emitting _c67_float_to_string runtime function\n")
 	}
 
-	eb.MarkLabel("_c67_float_to_string")
+	eb.MarkFunction("_c67_float_to_string")
 
 	// TODO: Implement a simplified float-to-string that handles common cases
 	// For now, just convert to integer and print that (MVP)
@@ -43,7 +43,7 @@ func (fc *C67Compiler) GeneratePrintfSyscallRuntime() {
 
 // generatePrintfMain generates the main printf dispatcher (UNUSED - see note above)
 func (fc *C67Compiler) generatePrintfMain() {
-	fc.eb.MarkLabel("_c67_printf_syscall")
+	fc.eb.MarkFunction("_c67_printf_syscall")
 
 	// Prologue
 	fc.out.PushReg("rbp")
@@ -97,8 +97,7 @@ func (fc *C67Compiler) generatePrintfMain() {
 	fc.out.MovImmToReg("rdi", "1")      // stdout
 	fc.out.LeaMemToReg("rsi", "r12", 0) // current char
 	fc.out.MovImmToReg("rdx", "1")      // length
-	fc.out.MovImmToReg("rax", "1")      // sys_write
-	fc.out.Syscall()
+	fc.emitBufferedWrite()
 
 	fc.out.IncReg("r12")
 	backToLoopPos1 := fc.eb.text.Len()
@@ -163,8 +162,7 @@ func (fc *C67Compiler) generatePrintfMain() {
 	fc.out.MovImmToReg("rdi", "1")
 	fc.out.LeaMemToReg("rsi", "r12", 0)
 	fc.out.MovImmToReg("rdx", "1")
-	fc.out.MovImmToReg("rax", "1")
-	fc.out.Syscall()
+	fc.emitBufferedWrite()
 	fc.out.IncReg("r12")
 	percentJump := fc.eb.text.Len()
 	fc.out.Emit([]byte{0xe9, 0x00, 0x00, 0x00, 0x00})
@@ -461,7 +459,8 @@ func (fc *C67Compiler) generatePrintfMain() {
 // Helper to patch short jumps (8-bit offset)
 func (fc *C67Compiler) patchShortJump(offsetPos int, targetPos int) {
 	bytes := fc.eb.text.Bytes()
-	offset := int8(targetPos - (offsetPos + 1))
+	offset := targetPos - (offsetPos + 1)
+	checkReach("the short jump", offsetPos-1, int64(offset), 8, 1)
 	bytes[offsetPos] = byte(offset)
 }
 
@@ -622,11 +621,10 @@ func (fc *C67Compiler) emitSyscallPrintLiteral(str string) {
 	fc.stringCounter++
 	fc.eb.Define(labelName, str)
 
-	fc.out.MovImmToReg("rax", "1") // sys_write
 	fc.out.MovImmToReg("rdi", "1") // stdout
 	fc.out.LeaSymbolToReg("rsi", labelName)
 	fc.out.MovImmToReg("rdx", fmt.Sprintf("%d", len(str)))
-	fc.out.Syscall()
+	fc.emitBufferedWrite()
 }
 
 // emitSyscallPrintChar emits code to print a single character
@@ -634,11 +632,10 @@ func (fc *C67Compiler) emitSyscallPrintChar(ch rune) {
 	fc.out.SubImmFromReg("rsp", 8)
 	fc.out.MovImmToReg("rax", fmt.Sprintf("%d", ch))
 	fc.out.MovRegToMem("rax", "rsp", 0)
-	fc.out.MovImmToReg("rax", "1") // sys_write
 	fc.out.MovImmToReg("rdi", "1") // stdout
 	fc.out.MovRegToReg("rsi", "rsp")
 	fc.out.MovImmToReg("rdx", "1")
-	fc.out.Syscall()
+	fc.emitBufferedWrite()
 	fc.out.AddImmToReg("rsp", 8)
 }
 
@@ -658,11 +655,10 @@ func (fc *C67Compiler) emitSyscallPrintInteger() {
 	fc.out.SubImmFromReg("rsp", 8)
 	fc.out.MovImmToReg("rax", "45") // '-'
 	fc.out.MovRegToMem("rax", "rsp", 0)
-	fc.out.MovImmToReg("rax", "1") // sys_write
 	fc.out.MovImmToReg("rdi", "1")
 	fc.out.MovRegToReg("rsi", "rsp")
 	fc.out.MovImmToReg("rdx", "1")
-	fc.out.Syscall()
+	fc.emitBufferedWrite()
 	fc.out.AddImmToReg("rsp", 8)
 	fc.out.PopReg("rax")
 	fc.out.NegReg("rax")
@@ -692,10 +688,9 @@ func (fc *C67Compiler) emitSyscallPrintInteger() {
 	fc.out.SubRegFromReg("rdx", "rbx")
 
 	// Write using syscall
-	fc.out.MovImmToReg("rax", "1")
 	fc.out.MovImmToReg("rdi", "1")
 	fc.out.MovRegToReg("rsi", "rbx")
-	fc.out.Syscall()
+	fc.emitBufferedWrite()
 
 	fc.out.AddImmToReg("rsp", 32)
 	fc.out.PopReg("rdx")
@@ -784,8 +779,7 @@ func (fc *C67Compiler) generatePrintString() {
 	// Write using syscall
 	fc.out.MovRegToReg("rsi", "rdi")
 	fc.out.MovImmToReg("rdi", "1")
-	fc.out.MovImmToReg("rax", "1")
-	fc.out.Syscall()
+	fc.emitBufferedWrite()
 
 	fc.out.PopReg("rbx")
 	fc.out.Ret()
@@ -809,8 +803,7 @@ func (fc *C67Compiler) generatePrintInteger() {
 	fc.out.MovImmToReg("rdi", "1")
 	fc.out.LeaSymbolToReg("rsi", "_printf_minus")
 	fc.out.MovImmToReg("rdx", "1")
-	fc.out.MovImmToReg("rax", "1")
-	fc.out.Syscall()
+	fc.emitBufferedWrite()
 	fc.out.PopReg("rax")
 	fc.out.NegReg("rax")
 
@@ -924,8 +917,7 @@ func (fc *C67Compiler) generatePrintFloat() {
 	fc.out.MovImmToReg("rdi", "1")
 	fc.out.LeaSymbolToReg("rsi", "_printf_minus")
 	fc.out.MovImmToReg("rdx", "1")
-	fc.out.MovImmToReg("rax", "1")
-	fc.out.Syscall()
+	fc.emitBufferedWrite()
 	fc.out.PopReg("rax")
 	fc.out.NegReg("rax")
 
@@ -1003,11 +995,10 @@ func (fc *C67Compiler) emitSyscallPrintFloatPrecise(precision int) {
 	fc.out.PushReg("rax")
 	fc.out.MovImmToReg("r15", "45") // '-'
 	fc.out.MovRegToMem("r15", "rsp", 8)
-	fc.out.MovImmToReg("rax", "1")
 	fc.out.MovImmToReg("rdi", "1")
 	fc.out.LeaMemToReg("rsi", "rsp", 8)
 	fc.out.MovImmToReg("rdx", "1")
-	fc.out.Syscall()
+	fc.emitBufferedWrite()
 	fc.out.PopReg("rax")
 	fc.out.NegReg("rax")
 
@@ -1035,10 +1026,9 @@ func (fc *C67Compiler) emitSyscallPrintFloatPrecise(precision int) {
 	fc.out.SubRegFromReg("rdx", "rbx")
 
 	// Write
-	fc.out.MovImmToReg("rax", "1")
 	fc.out.MovImmToReg("rdi", "1")
 	fc.out.MovRegToReg("rsi", "rbx")
-	fc.out.Syscall()
+	fc.emitBufferedWrite()
 
 	fc.out.PopReg("rdx")
 	fc.out.PopReg("rcx")
@@ -1047,11 +1037,10 @@ func (fc *C67Compiler) emitSyscallPrintFloatPrecise(precision int) {
 	// ===== Print decimal point INLINE =====
 	fc.out.MovImmToReg("rax", "46") // '.'
 	fc.out.MovRegToMem("rax", "rsp", 0)
-	fc.out.MovImmToReg("rax", "1")
 	fc.out.MovImmToReg("rdi", "1")
 	fc.out.MovRegToReg("rsi", "rsp")
 	fc.out.MovImmToReg("rdx", "1")
-	fc.out.Syscall()
+	fc.emitBufferedWrite()
 
 	// ===== Extract decimal digits - exact working assembly =====
 	fc.out.MovMemToXmm("xmm0", "rsp", 152)            // Load saved value
@@ -1086,11 +1075,10 @@ func (fc *C67Compiler) emitSyscallPrintFloatPrecise(precision int) {
 	}
 
 	// ===== Print 6 digits INLINE =====
-	fc.out.MovImmToReg("rax", "1")
 	fc.out.MovImmToReg("rdi", "1")
 	fc.out.LeaMemToReg("rsi", "rsp", 64)
 	fc.out.MovImmToReg("rdx", fmt.Sprintf("%d", precision))
-	fc.out.Syscall()
+	fc.emitBufferedWrite()
 
 	fc.out.AddImmToReg("rsp", 160) // Match the initial allocation
 }
@@ -0,0 +1,115 @@
+// Completion: 100% - Python ctypes module of the exported functions with --emit-python
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// pybind.go - Calling C67 functions from Python, from --emit-python
+//
+//	c67 --emit-python mathlib.c67 -o mathlib   # also writes mathlib.py
+//
+// The module loads the file that was built, from the directory the module
+// is in, with ctypes, and wraps each function that the C header declares
+// (see header.go) in a Python function that converts its arguments to
+// float:
+//
+//	import mathlib
+//	mathlib.add(1, 2)  # 3.0
+//
+// c67 builds executables, and ctypes can only load a shared library, so
+// the module is meant for a build of the same functions as a shared
+// library; loading it next to an executable fails with an OSError.
+
+// pythonKeywords can not be used as names in Python
+var pythonKeywords = map[string]bool{
+	"False": true, "None": true, "True": true, "and": true, "as": true,
+	"assert": true, "async": true, "await": true, "break": true, "class": true,
+	"continue": true, "def": true, "del": true, "elif": true, "else": true,
+	"except": true, "finally": true, "for": true, "from": true, "global": true,
+	"if": true, "import": true, "in": true, "is": true, "lambda": true,
+	"nonlocal": true, "not": true, "or": true, "pass": true, "raise": true,
+	"return": true, "try": true, "while": true, "with": true, "yield": true,
+}
+
+// pythonName returns name, with an underscore added if it is a keyword
+func pythonName(name string) string {
+	if pythonKeywords[name] {
+		return name + "_"
+	}
+	return name
+}
+
+// pythonModulePath returns where the module of the file at outputPath goes
+func pythonModulePath(outputPath string) string {
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".py"
+}
+
+// pythonParams returns the Python parameter names of a function with one
+// arity, from its signature, or a0, a1, ... for a pattern lambda
+func pythonParams(entry DocEntry) []string {
+	arity, _ := strconv.Atoi(entry.Arities[0])
+	list := strings.TrimSuffix(strings.TrimPrefix(entry.Signature, entry.Name+"("), ")")
+	names := strings.Split(list, ", ")
+	if list == "" || len(names) != arity || names[0] == "..." {
+		names = make([]string, arity)
+		for i := range names {
+			names[i] = fmt.Sprintf("a%d", i)
+		}
+	}
+	for i, name := range names {
+		names[i] = pythonName(name)
+	}
+	return names
+}
+
+// generatePythonModule returns the ctypes module that wraps the functions
+// documented in doc, for the file libraryName
+func generatePythonModule(doc *ModuleDoc, inputPath, libraryName string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by c67 from %s. Do not edit.\n", filepath.Base(inputPath))
+	fmt.Fprintf(&b, "\"\"\"Functions of %s, called with ctypes\"\"\"\n\n", filepath.Base(inputPath))
+	b.WriteString("import ctypes\nimport os\n\n")
+	fmt.Fprintf(&b, "_lib = ctypes.CDLL(os.path.join(os.path.dirname(os.path.abspath(__file__)), %q))\n", libraryName)
+
+	functions, others := fixedArityFunctions(doc)
+	for _, entry := range functions {
+		params := pythonParams(entry)
+		argtypes := strings.TrimSuffix(strings.Repeat("ctypes.c_double, ", len(params)), ", ")
+		args := make([]string, len(params))
+		for i, param := range params {
+			args[i] = "float(" + param + ")"
+		}
+		function := "_" + entry.Name // entry.Name may be a keyword
+		fmt.Fprintf(&b, "\n\n%s = _lib[%q]\n", function, entry.Name)
+		fmt.Fprintf(&b, "%s.argtypes = [%s]\n", function, argtypes)
+		fmt.Fprintf(&b, "%s.restype = ctypes.c_double\n\n\n", function)
+		fmt.Fprintf(&b, "def %s(%s):\n", pythonName(entry.Name), strings.Join(params, ", "))
+		if entry.Doc != "" {
+			fmt.Fprintf(&b, "    %s\n", strconv.QuoteToASCII(entry.Doc))
+		}
+		fmt.Fprintf(&b, "    return %s(%s)\n", function, strings.Join(args, ", "))
+	}
+	if len(others) > 0 {
+		b.WriteString("\n\n# Not wrapped, since they take more than one number of arguments:\n")
+		for _, signature := range others {
+			fmt.Fprintf(&b, "#   %s\n", signature)
+		}
+	}
+	return b.String()
+}
+
+// writePythonModule writes the module of the file at outputPath, with the
+// functions of inputPath, which are read the way writeHeader reads them
+func writePythonModule(inputPath, outputPath string) error {
+	doc, err := documentFile(inputPath)
+	if err != nil {
+		return err
+	}
+	module := generatePythonModule(doc, inputPath, filepath.Base(outputPath))
+	return os.WriteFile(pythonModulePath(outputPath), []byte(module), 0o644)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestEmitPython tests that --emit-python wraps the functions with one
+// arity, renaming Python keywords
+func TestEmitPython(t *testing.T) {
+	defer func() { EmitPythonFlag = false }()
+	EmitPythonFlag = true
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "mathlib.c67")
+	source := `## add returns the sum of a and b
+add = (a, b) -> a + b
+pass = { 42 }
+pick = (a) -> a, (a, b) -> b
+println(add(1, 2))
+`
+	if err := os.WriteFile(input, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CompileC67(input, filepath.Join(dir, "mathlib"), Platform{Arch: ArchX86_64, OS: OSLinux}); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+
+	module := filepath.Join(dir, "mathlib.py")
+	data, err := os.ReadFile(module)
+	if err != nil {
+		t.Fatalf("Expected a Python module: %v", err)
+	}
+	for _, want := range []string{
+		`"mathlib"))`,
+		"_add.argtypes = [ctypes.c_double, ctypes.c_double]\n",
+		"def add(a, b):\n    \"add returns the sum of a and b\"\n    return _add(float(a), float(b))\n",
+		"_pass = _lib[\"pass\"]\n",
+		"def pass_():\n",
+		"#   pick(...)\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected %q in the module, got:\n%s", want, data)
+		}
+	}
+
+	if python, err := exec.LookPath("python3"); err == nil {
+		check := "import ast, sys; ast.parse(open(sys.argv[1]).read())"
+		if output, err := exec.Command(python, "-c", check, module).CombinedOutput(); err != nil {
+			t.Errorf("The module is not valid Python: %v\n%s", err, output)
+		}
+	}
+}
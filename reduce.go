@@ -0,0 +1,368 @@
+// Completion: 100% - sum(), min(), max() and mean() list reductions
+package main
+
+import "fmt"
+
+// reduce.go - Reductions over lists of numbers
+//
+//	sum(xs)    total of the values, 0 for an empty list
+//	min(xs)    smallest value
+//	max(xs)    largest value
+//	mean(xs)   sum(xs) / #xs
+//	min(a, b)  smaller of two numbers, max(a, b) the larger
+//
+// min, max and mean of an empty list are error("arg").
+//
+// On x86-64 list values sit 16 bytes apart, interleaved with their keys, so
+// the range kernel gathers two values per XMM register with movsd + movhpd
+// and keeps two packed accumulators, four values per iteration. Lists with
+// at least reduceParallelMin values are split into one chunk per online CPU
+// (at most reduceMaxThreads) on Linux and macOS; the chunks are reduced by
+// pthreads and the partial results combined in order, so sum() adds the
+// same partial sums on every run with the same number of CPUs.
+
+const (
+	reduceParallelMin = 1 << 16 // values before a reduction is split over threads
+	reduceMaxThreads  = 16
+	reduceSlotSize    = 48 // per thread: list, start, end, result, pthread_t, started
+)
+
+// reduceOps are the list reductions with a kernel of their own; mean uses sum
+var reduceOps = []string{"sum", "min", "max"}
+
+// compileReduceCall compiles sum(), min(), max() and mean()
+func (fc *C67Compiler) compileReduceCall(call *CallExpr) {
+	if (call.Function == "min" || call.Function == "max") && len(call.Args) == 2 {
+		fc.compileExpression(call.Args[0])
+		fc.out.SubImmFromReg("rsp", 16)
+		fc.out.MovXmmToMem("xmm0", "rsp", 0)
+		fc.compileExpression(call.Args[1])
+		fc.out.MovXmmToXmm("xmm1", "xmm0")
+		fc.out.MovMemToXmm("xmm0", "rsp", 0)
+		fc.out.AddImmToReg("rsp", 16)
+		if call.Function == "min" {
+			fc.out.MinsdXmm("xmm0", "xmm1")
+		} else {
+			fc.out.MaxsdXmm("xmm0", "xmm1")
+		}
+		return
+	}
+	if len(call.Args) != 1 {
+		if call.Function == "min" || call.Function == "max" {
+			compilerError("%s() requires 1 argument (a list) or 2 numbers", call.Function)
+		}
+		compilerError("%s() requires exactly 1 argument (a list)", call.Function)
+	}
+
+	if fc.usesReduce == nil {
+		fc.usesReduce = make(map[string]bool)
+	}
+	fc.usesReduce[call.Function] = true
+	if call.Function == "mean" {
+		fc.usesReduce["sum"] = true
+	}
+	if fc.reduceThreaded() {
+		fc.trackFunctionCall("sysconf")
+		fc.trackFunctionCall("pthread_create")
+		fc.trackFunctionCall("pthread_join")
+	}
+
+	fc.compileExpression(call.Args[0])
+	fc.out.MovqXmmToReg("rdi", "xmm0")
+	fc.out.SubImmFromReg("rsp", StackSlotSize)
+	fc.out.CallSymbol("_c67_" + call.Function)
+	fc.out.AddImmToReg("rsp", StackSlotSize)
+}
+
+// reduceThreaded reports whether large reductions are split over pthreads
+func (fc *C67Compiler) reduceThreaded() bool {
+	return fc.eb.target.OS() == OSLinux || fc.eb.target.OS() == OSDarwin
+}
+
+// generateReduceHelpers emits the helpers for every reduction in use
+func (fc *C67Compiler) generateReduceHelpers() {
+	for _, op := range reduceOps {
+		if fc.usesReduce[op] {
+			fc.generateReduceKernel(op)
+			if fc.reduceThreaded() {
+				fc.generateReduceWorker(op)
+			}
+			fc.generateReduceDriver(op)
+		}
+	}
+	if fc.usesReduce["mean"] {
+		fc.generateReduceMean()
+	}
+}
+
+// emitReduceOp combines xmm src into xmm dst with op, packed or scalar
+func (fc *C67Compiler) emitReduceOp(op, dst, src string, packed bool) {
+	switch {
+	case op == "sum" && packed:
+		fc.out.AddpdXmm(dst, src)
+	case op == "sum":
+		fc.out.AddsdXmm(dst, src)
+	case op == "min" && packed:
+		fc.out.MinpdXmm(dst, src)
+	case op == "min":
+		fc.out.MinsdXmm(dst, src)
+	case op == "max" && packed:
+		fc.out.MaxpdXmm(dst, src)
+	default:
+		fc.out.MaxsdXmm(dst, src)
+	}
+}
+
+// generateReduceKernel emits _c67_reduce_<op>_range(rdi=list, rsi=start,
+// rdx=end) -> xmm0, op over the values start..<end. min and max need at
+// least one value. Leaf function; clobbers rax, rcx and xmm0-xmm3.
+func (fc *C67Compiler) generateReduceKernel(op string) {
+	fc.eb.MarkFunction("_c67_reduce_" + op + "_range")
+
+	// rax = address of value start, rcx = values left
+	fc.out.MovRegToReg("rax", "rsi")
+	fc.out.ShlImmReg("rax", 4)
+	fc.out.AddRegToReg("rax", "rdi")
+	fc.out.AddImmToReg("rax", 16)
+	fc.out.MovRegToReg("rcx", "rdx")
+	fc.out.SubRegFromReg("rcx", "rsi")
+
+	if op == "sum" {
+		fc.out.XorpdXmm("xmm2", "xmm2")
+		fc.out.XorpdXmm("xmm3", "xmm3")
+	} else {
+		// Both lanes of both accumulators start at the first value
+		for _, acc := range []string{"xmm2", "xmm3"} {
+			fc.out.MovMemToXmm(acc, "rax", 0)
+			fc.out.MovhpdMemToXmm(acc, "rax", 0)
+		}
+	}
+
+	// Four values per iteration, two in each accumulator
+	quadLoop := fc.eb.text.Len()
+	fc.out.CmpRegToImm("rcx", 4)
+	quadDone := fc.forwardJump(JumpLess)
+	fc.out.MovMemToXmm("xmm0", "rax", 0)
+	fc.out.MovhpdMemToXmm("xmm0", "rax", 16)
+	fc.out.MovMemToXmm("xmm1", "rax", 32)
+	fc.out.MovhpdMemToXmm("xmm1", "rax", 48)
+	fc.emitReduceOp(op, "xmm2", "xmm0", true)
+	fc.emitReduceOp(op, "xmm3", "xmm1", true)
+	fc.out.AddImmToReg("rax", 64)
+	fc.out.SubImmFromReg("rcx", 4)
+	fc.out.JumpUnconditional(int32(quadLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(quadDone)
+
+	// Fold the accumulators and their lanes into xmm0
+	fc.emitReduceOp(op, "xmm2", "xmm3", true)
+	fc.out.MovXmmToXmm("xmm0", "xmm2")
+	fc.out.UnpckhpdXmm("xmm2", "xmm2")
+	fc.emitReduceOp(op, "xmm0", "xmm2", false)
+
+	// Remaining values one at a time
+	tailLoop := fc.eb.text.Len()
+	fc.out.TestRegReg("rcx", "rcx")
+	tailDone := fc.forwardJump(JumpEqual)
+	fc.out.MovMemToXmm("xmm1", "rax", 0)
+	fc.emitReduceOp(op, "xmm0", "xmm1", false)
+	fc.out.AddImmToReg("rax", 16)
+	fc.out.DecReg("rcx")
+	fc.out.JumpUnconditional(int32(tailLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(tailDone)
+	fc.out.Ret()
+}
+
+// generateReduceWorker emits _c67_reduce_<op>_worker(rdi=slot), the
+// pthread start routine that reduces one chunk into its slot
+func (fc *C67Compiler) generateReduceWorker(op string) {
+	fc.eb.MarkFunction("_c67_reduce_" + op + "_worker")
+	fc.out.PushReg("rbx")
+	fc.out.MovRegToReg("rbx", "rdi")
+	fc.out.MovMemToReg("rdi", "rbx", 0)
+	fc.out.MovMemToReg("rsi", "rbx", 8)
+	fc.out.MovMemToReg("rdx", "rbx", 16)
+	fc.out.CallSymbol("_c67_reduce_" + op + "_range")
+	fc.out.MovXmmToMem("xmm0", "rbx", 24)
+	fc.out.XorRegWithReg("rax", "rax")
+	fc.out.PopReg("rbx")
+	fc.out.Ret()
+}
+
+// generateReduceDriver emits _c67_<op>(rdi=list) -> xmm0, reducing large
+// lists over several threads
+func (fc *C67Compiler) generateReduceDriver(op string) {
+	fc.eb.MarkFunction("_c67_" + op)
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+	fc.out.PushReg("r15")
+	// [rbp-48] = chunk index across pthread_create, [rbp-56] = result
+	fc.out.SubImmFromReg("rsp", 24)
+	fc.out.MovRegToReg("r12", "rdi") // r12 = list
+	fc.out.MovMemToXmm("xmm0", "r12", 0)
+	fc.out.Cvttsd2si("rbx", "xmm0") // rbx = count
+
+	var done []int
+	if op != "sum" {
+		fc.out.TestRegReg("rbx", "rbx")
+		nonEmpty := fc.forwardJump(JumpGreater)
+		fc.createErrorResult("arg")
+		done = append(done, fc.forwardJumpAlways())
+		fc.landForwardJump(nonEmpty)
+	}
+
+	if fc.reduceThreaded() {
+		fc.out.CmpRegToImm("rbx", reduceParallelMin)
+		serial := fc.forwardJump(JumpLess)
+
+		// r14 = threads: online CPUs, at most reduceMaxThreads
+		scNProcessorsOnln := "84"
+		if fc.eb.target.OS() == OSDarwin {
+			scNProcessorsOnln = "58"
+		}
+		fc.out.MovImmToReg("rdi", scNProcessorsOnln)
+		fc.eb.GenerateCallInstruction("sysconf")
+		fc.out.MovRegToReg("r14", "rax")
+		fc.out.CmpRegToImm("r14", reduceMaxThreads)
+		notTooMany := fc.forwardJump(JumpLessOrEqual)
+		fc.out.MovImmToReg("r14", fmt.Sprintf("%d", reduceMaxThreads))
+		fc.landForwardJump(notTooMany)
+		fc.out.CmpRegToImm("r14", 1)
+		oneCPU := fc.forwardJump(JumpLessOrEqual)
+
+		// r15 = slots, r13 = values per thread
+		fc.out.MovRegToReg("rax", "r14")
+		fc.out.ImulImmToReg("rax", reduceSlotSize)
+		fc.out.SubRegFromReg("rsp", "rax")
+		fc.out.AndRegWithImm("rsp", -16)
+		fc.out.MovRegToReg("r15", "rsp")
+		fc.out.MovRegToReg("rax", "rbx")
+		fc.out.XorRegWithReg("rdx", "rdx")
+		fc.out.DivRegByReg("rax", "r14")
+		fc.out.MovRegToReg("r13", "rax")
+
+		// Fill the slots and start a thread for every chunk but the first
+		fc.out.XorRegWithReg("rcx", "rcx")
+		fillLoop := fc.eb.text.Len()
+		fc.out.CmpRegToReg("rcx", "r14")
+		filled := fc.forwardJump(JumpGreaterOrEqual)
+		fc.out.MovRegToReg("rdi", "rcx")
+		fc.out.ImulImmToReg("rdi", reduceSlotSize)
+		fc.out.AddRegToReg("rdi", "r15")
+		fc.out.MovRegToMem("r12", "rdi", 0)
+		fc.out.MovRegToReg("rax", "rcx")
+		fc.out.ImulRegWithReg("rax", "r13")
+		fc.out.MovRegToMem("rax", "rdi", 8)
+		fc.out.AddRegToReg("rax", "r13")
+		fc.out.MovRegToReg("rdx", "rcx")
+		fc.out.IncReg("rdx")
+		fc.out.CmpRegToReg("rdx", "r14")
+		notLast := fc.forwardJump(JumpLess)
+		fc.out.MovRegToReg("rax", "rbx") // the last chunk takes the remainder
+		fc.landForwardJump(notLast)
+		fc.out.MovRegToMem("rax", "rdi", 16)
+		fc.out.MovImmToMem(0, "rdi", 40)
+		fc.out.TestRegReg("rcx", "rcx")
+		next := fc.forwardJump(JumpEqual)
+		fc.out.MovRegToMem("rcx", "rbp", -48)
+		fc.out.MovRegToReg("rcx", "rdi")
+		fc.out.LeaMemToReg("rdi", "rcx", 32)
+		fc.out.XorRegWithReg("rsi", "rsi")
+		fc.out.LeaSymbolToReg("rdx", "_c67_reduce_"+op+"_worker")
+		fc.eb.GenerateCallInstruction("pthread_create")
+		fc.out.MovMemToReg("rcx", "rbp", -48)
+		fc.out.TestRegReg("rax", "rax")
+		notStarted := fc.forwardJump(JumpNotEqual)
+		fc.out.MovRegToReg("rdi", "rcx")
+		fc.out.ImulImmToReg("rdi", reduceSlotSize)
+		fc.out.AddRegToReg("rdi", "r15")
+		fc.out.MovImmToMem(1, "rdi", 40)
+		fc.landForwardJump(notStarted)
+		fc.landForwardJump(next)
+		fc.out.IncReg("rcx")
+		fc.out.JumpUnconditional(int32(fillLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+		fc.landForwardJump(filled)
+
+		// Reduce every chunk that has no thread here, join the others and
+		// combine the partial results in chunk order
+		fc.out.XorRegWithReg("r13", "r13")
+		joinLoop := fc.eb.text.Len()
+		fc.out.CmpRegToReg("r13", "r14")
+		joined := fc.forwardJump(JumpGreaterOrEqual)
+		fc.out.MovRegToReg("rbx", "r13")
+		fc.out.ImulImmToReg("rbx", reduceSlotSize)
+		fc.out.AddRegToReg("rbx", "r15") // rbx = slot
+		fc.out.MovMemToReg("rax", "rbx", 40)
+		fc.out.TestRegReg("rax", "rax")
+		inline := fc.forwardJump(JumpEqual)
+		fc.out.MovMemToReg("rdi", "rbx", 32)
+		fc.out.XorRegWithReg("rsi", "rsi")
+		fc.eb.GenerateCallInstruction("pthread_join")
+		reduced := fc.forwardJumpAlways()
+		fc.landForwardJump(inline)
+		fc.out.MovRegToReg("rdi", "rbx")
+		fc.out.CallSymbol("_c67_reduce_" + op + "_worker")
+		fc.landForwardJump(reduced)
+		fc.out.TestRegReg("r13", "r13")
+		first := fc.forwardJump(JumpEqual)
+		fc.out.MovMemToXmm("xmm0", "rbp", -56)
+		fc.out.MovMemToXmm("xmm1", "rbx", 24)
+		fc.emitReduceOp(op, "xmm0", "xmm1", false)
+		fc.out.MovXmmToMem("xmm0", "rbp", -56)
+		combined := fc.forwardJumpAlways()
+		fc.landForwardJump(first)
+		fc.out.MovMemToXmm("xmm0", "rbx", 24)
+		fc.out.MovXmmToMem("xmm0", "rbp", -56)
+		fc.landForwardJump(combined)
+		fc.out.IncReg("r13")
+		fc.out.JumpUnconditional(int32(joinLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+		fc.landForwardJump(joined)
+		fc.out.MovMemToXmm("xmm0", "rbp", -56)
+		threaded := fc.forwardJumpAlways()
+
+		fc.landForwardJump(serial)
+		fc.landForwardJump(oneCPU)
+		done = append(done, threaded)
+	}
+
+	fc.out.MovRegToReg("rdi", "r12")
+	fc.out.XorRegWithReg("rsi", "rsi")
+	fc.out.MovRegToReg("rdx", "rbx")
+	fc.out.CallSymbol("_c67_reduce_" + op + "_range")
+
+	for _, pos := range done {
+		fc.landForwardJump(pos)
+	}
+	fc.out.LeaMemToReg("rsp", "rbp", -40)
+	fc.out.PopReg("r15")
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generateReduceMean emits _c67_mean(rdi=list) -> xmm0
+func (fc *C67Compiler) generateReduceMean() {
+	fc.eb.MarkFunction("_c67_mean")
+	fc.out.PushReg("rbx")
+	fc.out.MovRegToReg("rbx", "rdi")
+	fc.out.MovMemToXmm("xmm0", "rbx", 0)
+	fc.out.Cvttsd2si("rax", "xmm0")
+	fc.out.TestRegReg("rax", "rax")
+	nonEmpty := fc.forwardJump(JumpGreater)
+	fc.createErrorResult("arg")
+	done := fc.forwardJumpAlways()
+	fc.landForwardJump(nonEmpty)
+	fc.out.CallSymbol("_c67_sum")
+	fc.out.MovMemToXmm("xmm1", "rbx", 0)
+	fc.out.DivsdXmm("xmm0", "xmm1")
+	fc.landForwardJump(done)
+	fc.out.PopReg("rbx")
+	fc.out.Ret()
+}
@@ -120,3 +120,66 @@ code {
 		t.Errorf("Expected output to contain 'success', got: %s", result)
 	}
 }
+
+// TestErrConstructor tests err("message") and reading the message back with .error
+func TestErrConstructor(t *testing.T) {
+	source := `e := err("file not found")
+println(e.error)
+println(e or! 7)
+`
+	result := compileAndRun(t, source)
+	if result != "file not found\n7\n" {
+		t.Errorf("Expected %q, got: %q", "file not found\n7\n", result)
+	}
+}
+
+// TestErrStatement tests that err "message" returns an error value from a function
+func TestErrStatement(t *testing.T) {
+	source := `check = x -> {
+    x < 0 {
+        err "negative"
+    }
+    x
+}
+println(check(-1).error)
+println(check(3))
+`
+	result := compileAndRun(t, source)
+	if result != "negative\n3\n" {
+		t.Errorf("Expected %q, got: %q", "negative\n3\n", result)
+	}
+}
+
+// TestOrBangKeepsPlainNaN tests that or! only replaces error values, not ordinary NaN results
+func TestOrBangKeepsPlainNaN(t *testing.T) {
+	source := `x := sqrt(-1)
+println(x or! 5)
+println(x.error == "")
+`
+	result := compileAndRun(t, source)
+	if result != "NaN\n1\n" {
+		t.Errorf("Expected %q, got: %q", "NaN\n1\n", result)
+	}
+}
+
+// TestErrorPropagation tests that f(x)! returns an error to the caller and yields the value otherwise
+func TestErrorPropagation(t *testing.T) {
+	source := `safe_div = (a, b) -> {
+    b == 0 {
+        ret err("division by zero")
+    }
+    a / b
+}
+calc = (a, b) -> {
+    q := safe_div(a, b)!
+    q + 1
+}
+println(calc(8, 2))
+println(calc(8, 0).error)
+println(calc(8, 0) or! -1)
+`
+	result := compileAndRun(t, source)
+	if result != "5\ndivision by zero\n-1\n" {
+		t.Errorf("Expected %q, got: %q", "5\ndivision by zero\n-1\n", result)
+	}
+}
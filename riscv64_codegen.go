@@ -2,6 +2,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 )
 
@@ -94,6 +95,9 @@ func (rcg *RiscvCodeGen) compileExpression(expr Expression) error {
 	case *BinaryExpr:
 		return rcg.compileBinaryOp(e)
 
+	case *FMAExpr:
+		return rcg.compileFMA(e.A, e.B, e.C, e.IsSub)
+
 	case *IdentExpr:
 		// Load variable from stack
 		offset, ok := rcg.stackVars[e.Name]
@@ -136,7 +140,7 @@ func (rcg *RiscvCodeGen) compileBinaryOp(binop *BinaryExpr) error {
 		return rcg.out.Add("a0", "t0", "t1")
 	case "-":
 		return rcg.out.Sub("a0", "t0", "t1")
-	case "*":
+	case "*", "*+":
 		return rcg.out.Mul("a0", "t0", "t1")
 	case "/":
 		return rcg.out.Div("a0", "t0", "t1")
@@ -157,6 +161,61 @@ func (rcg *RiscvCodeGen) compileBinaryOp(binop *BinaryExpr) error {
 	}
 }
 
+// compileFMA compiles a * b + c (or a * b - c) to a single FMADD.D/FMSUB.D.
+// Operands are converted to double and spilled to the stack so that nested
+// expressions cannot clobber them; the result is converted back into a0.
+func (rcg *RiscvCodeGen) compileFMA(a, b, c Expression, isSub bool) error {
+	if err := rcg.compileExpression(a); err != nil {
+		return err
+	}
+	if err := rcg.out.AddImm("sp", "sp", -16); err != nil {
+		return err
+	}
+	if err := rcg.out.FcvtDL("ft0", "a0"); err != nil {
+		return err
+	}
+	if err := rcg.out.Fsd("ft0", "sp", 0); err != nil {
+		return err
+	}
+
+	if err := rcg.compileExpression(b); err != nil {
+		return err
+	}
+	if err := rcg.out.FcvtDL("ft0", "a0"); err != nil {
+		return err
+	}
+	if err := rcg.out.Fsd("ft0", "sp", 8); err != nil {
+		return err
+	}
+
+	if err := rcg.compileExpression(c); err != nil {
+		return err
+	}
+	if err := rcg.out.FcvtDL("ft2", "a0"); err != nil {
+		return err
+	}
+	if err := rcg.out.Fld("ft0", "sp", 0); err != nil {
+		return err
+	}
+	if err := rcg.out.Fld("ft1", "sp", 8); err != nil {
+		return err
+	}
+	if err := rcg.out.AddImm("sp", "sp", 16); err != nil {
+		return err
+	}
+
+	if isSub {
+		if err := rcg.out.FmsubD("fa0", "ft0", "ft1", "ft2"); err != nil {
+			return err
+		}
+	} else {
+		if err := rcg.out.FmaddD("fa0", "ft0", "ft1", "ft2"); err != nil {
+			return err
+		}
+	}
+	return rcg.out.FcvtLD("a0", "fa0")
+}
+
 // compileAssignment compiles an assignment statement
 func (rcg *RiscvCodeGen) compileAssignment(assign *AssignStmt) error {
 	// Compile the value
@@ -180,6 +239,13 @@ func (rcg *RiscvCodeGen) compileCall(call *CallExpr) error {
 		return rcg.compilePrintln(call)
 	case "exit":
 		return rcg.compileExit(call)
+	case "popcount", "clz", "ctz", "bswap":
+		return rcg.compileBitCall(call)
+	case "fma":
+		if len(call.Args) != 3 {
+			return fmt.Errorf("fma() requires 3 arguments: fma(a, b, c) = a * b + c")
+		}
+		return rcg.compileFMA(call.Args[0], call.Args[1], call.Args[2], false)
 	default:
 		return fmt.Errorf("unsupported function for RISC-V64: %s", call.Function)
 	}
@@ -227,18 +293,54 @@ func (rcg *RiscvCodeGen) compilePrintln(call *CallExpr) error {
 		// ecall
 		rcg.out.Ecall()
 
-	case *NumberExpr:
-		// For numbers, we need to convert to string
-		// This is complex - for now, just print a placeholder
-		return fmt.Errorf("println(number) not yet implemented for RISC-V64")
-
 	default:
-		return fmt.Errorf("unsupported println argument type for RISC-V64: %T", arg)
+		// Any other argument is a number, such as a * b + c: print its integer part
+		if err := rcg.compileExpression(arg); err != nil {
+			return err
+		}
+		return rcg.compilePrintInteger()
 	}
 
 	return nil
 }
 
+// compilePrintInteger writes a0 as a signed decimal and a newline to stdout.
+// The digits are built backwards in a 32-byte buffer on the stack.
+func (rcg *RiscvCodeGen) compilePrintInteger() error {
+	o := rcg.out
+	if err := errors.Join(
+		o.AddImm("sp", "sp", -32),
+		o.AddImm("t2", "sp", 31), // t2 = first character written
+		o.AddImm("t0", "zero", 10),
+		o.Sb("t0", "t2", 0), // '\n'
+		o.Slt("t4", "a0", "zero"),
+		o.Move("t3", "a0"),
+		o.Bge("a0", "zero", 8),
+		o.Sub("t3", "zero", "a0"),
+		// do { *--t2 = '0' + t3 % 10; t3 /= 10 } while t3 != 0
+		o.AddImm("t2", "t2", -1),
+		o.Remu("t1", "t3", "t0"),
+		o.AddImm("t1", "t1", '0'),
+		o.Sb("t1", "t2", 0),
+		o.Divu("t3", "t3", "t0"),
+		o.BranchNotEqual("t3", "zero", -20),
+		o.BranchEqual("t4", "zero", 16),
+		o.AddImm("t2", "t2", -1),
+		o.AddImm("t1", "zero", '-'),
+		o.Sb("t1", "t2", 0),
+		// write(1, t2, sp + 32 - t2)
+		o.LoadImm("a7", 64),
+		o.LoadImm("a0", 1),
+		o.Move("a1", "t2"),
+		o.AddImm("a2", "sp", 32),
+		o.Sub("a2", "a2", "t2"),
+	); err != nil {
+		return err
+	}
+	o.Ecall()
+	return o.AddImm("sp", "sp", 32)
+}
+
 // compileExit compiles an exit call
 func (rcg *RiscvCodeGen) compileExit(call *CallExpr) error {
 	exitCode := int64(0)
@@ -0,0 +1,289 @@
+// Completion: 100% - Compressed (RVC) encodings for the RISC-V backend
+package main
+
+import "encoding/binary"
+
+// riscv64_compress.go - 16-bit compressed instructions for RV64
+//
+// The C extension has 16-bit forms of the most common instructions, under
+// conditions on their registers and immediates: c.li for a small li, c.mv,
+// c.addi, c.ld and c.sd relative to sp or a register among x8-x15, c.j and
+// c.beqz for jumps that stay close, and so on. Every RV64GC core has it.
+//
+// compressRiscv64 runs once the code is complete and scheduled, and replaces
+// each instruction that has a compressed form with it. The text shrinks, so
+// the offsets of branches and jumps are recomputed, and the recorded
+// positions of relocations and call patches follow their instructions. A
+// branch or jump is only compressed if its original offset is in range:
+// offsets only get shorter, so it stays in range.
+//
+// Instructions that are patched later keep their 32-bit form: the AUIPC/ADDI
+// pair of a PC-relative address, and the placeholder JAL of a call.
+
+// compressRiscv64 compresses the RISC-V code in eb.text
+func compressRiscv64(eb *ExecutableBuilder) {
+	text := eb.text.Bytes()
+	n := len(text) / 4
+
+	keep := make([]bool, n)
+	for _, reloc := range eb.pcRelocations {
+		if i := int(reloc.offset / 4); i < n {
+			keep[i] = true
+			if i+1 < n {
+				keep[i+1] = true
+			}
+		}
+	}
+	for _, patch := range eb.callPatches {
+		if i := patch.position / 4; i < n {
+			keep[i] = true
+		}
+	}
+
+	words := make([]uint32, n)
+	compressed := make([]bool, n)
+	newPos := make([]int, n+1) // new byte offset of each instruction
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(text[4*i:])
+		if !keep[i] {
+			offset, _ := riscvBranchTarget(words[i])
+			_, compressed[i] = compressRiscvInstr(words[i], offset)
+		}
+		newPos[i+1] = newPos[i] + 4
+		if compressed[i] {
+			newPos[i+1] = newPos[i] + 2
+		}
+	}
+
+	// Branch targets move with the code
+	remap := func(i int, offset int32) int32 {
+		target := i + int(offset/4)
+		if offset == 0 || target < 0 || target > n || offset%4 != 0 {
+			return offset // a placeholder, or not an instruction of this text
+		}
+		return int32(newPos[target] - newPos[i])
+	}
+
+	out := make([]byte, 0, newPos[n])
+	for i, word := range words {
+		switch word & 0x7f {
+		case 0x63: // branch
+			offset, _ := riscvBranchTarget(word)
+			word = word&0x01fff07f | encodeRiscvBranchOffset(remap(i, offset))
+		case 0x6f: // jal
+			offset, _ := riscvBranchTarget(word)
+			word = word&0xfff | encodeRiscvJumpOffset(remap(i, offset))
+		}
+		if compressed[i] {
+			var offset int32
+			if target, ok := riscvBranchTarget(words[i]); ok {
+				offset = remap(i, target)
+			}
+			half, _ := compressRiscvInstr(word, offset)
+			out = binary.LittleEndian.AppendUint16(out, half)
+		} else {
+			out = binary.LittleEndian.AppendUint32(out, word)
+		}
+	}
+
+	for k := range eb.pcRelocations {
+		if i := int(eb.pcRelocations[k].offset / 4); i <= n {
+			eb.pcRelocations[k].offset = uint64(newPos[i])
+		}
+	}
+	for k := range eb.callPatches {
+		if i := eb.callPatches[k].position / 4; i <= n {
+			eb.callPatches[k].position = newPos[i] + eb.callPatches[k].position%4
+		}
+	}
+
+	eb.text.Reset()
+	eb.text.Write(out)
+}
+
+// encodeRiscvBranchOffset returns the immediate bits of a B-type instruction
+func encodeRiscvBranchOffset(imm int32) uint32 {
+	u := uint32(imm)
+	return (u>>12&1)<<31 | (u>>5&0x3f)<<25 | (u>>1&0xf)<<8 | (u>>11&1)<<7
+}
+
+// encodeRiscvJumpOffset returns the immediate bits of a J-type instruction
+func encodeRiscvJumpOffset(imm int32) uint32 {
+	u := uint32(imm)
+	return (u>>20&1)<<31 | (u>>1&0x3ff)<<21 | (u>>11&1)<<20 | (u>>12&0xff)<<12
+}
+
+// riscvCompressedReg returns the 3-bit field of a register among x8-x15
+// (or f8-f15), the only registers that most compressed instructions name
+func riscvCompressedReg(reg uint32) (uint32, bool) {
+	return reg - 8, reg >= 8 && reg <= 15
+}
+
+// fitsSigned reports whether v fits in a signed immediate of the given bits
+func fitsSigned(v int32, bits uint) bool {
+	return v >= -(1<<(bits-1)) && v < 1<<(bits-1)
+}
+
+// compressRiscvInstr returns the compressed form of a 32-bit instruction,
+// if it has one. For branches and jumps, offset is the offset to encode.
+func compressRiscvInstr(word uint32, offset int32) (uint16, bool) {
+	rd := word >> 7 & 0x1f
+	rs1 := word >> 15 & 0x1f
+	rs2 := word >> 20 & 0x1f
+	funct3 := word >> 12 & 7
+	funct7 := word >> 25
+	immI := int32(word) >> 20
+	immS := int32(word)>>25<<5 | int32(word>>7&0x1f)
+	rdC, rdOK := riscvCompressedReg(rd)
+	rs1C, rs1OK := riscvCompressedReg(rs1)
+	rs2C, rs2OK := riscvCompressedReg(rs2)
+
+	// ciImm places a 6-bit signed immediate at [12] and [6:2]
+	ciImm := func(imm int32) uint32 {
+		u := uint32(imm)
+		return (u>>5&1)<<12 | (u&0x1f)<<2
+	}
+	// clOffset places the offset of c.ld, c.sd, c.fld and c.fsd
+	clOffset := func(imm int32) uint32 {
+		u := uint32(imm)
+		return (u>>3&7)<<10 | (u>>6&3)<<5
+	}
+	// spOffset places the offset of c.ldsp and c.fldsp
+	spOffset := func(imm int32) uint32 {
+		u := uint32(imm)
+		return (u>>5&1)<<12 | (u>>3&3)<<5 | (u>>6&7)<<2
+	}
+	half := func(v uint32) (uint16, bool) { return uint16(v), true }
+
+	switch word & 0x7f {
+	case 0x13: // OP-IMM
+		switch funct3 {
+		case 0: // addi
+			switch {
+			case rd != 0 && rs1 == 0 && fitsSigned(immI, 6):
+				return half(0x4001 | rd<<7 | ciImm(immI)) // c.li
+			case rd != 0 && rs1 != 0 && immI == 0:
+				return half(0x8002 | rd<<7 | rs1<<2) // c.mv
+			case rd != 0 && rd == rs1 && immI != 0 && fitsSigned(immI, 6):
+				return half(0x0001 | rd<<7 | ciImm(immI)) // c.addi
+			case rd == 2 && rs1 == 2 && immI != 0 && immI%16 == 0 && fitsSigned(immI, 10):
+				u := uint32(immI)
+				return half(0x6101 | (u>>9&1)<<12 | (u>>4&1)<<6 | (u>>6&1)<<5 | (u>>7&3)<<3 | (u>>5&1)<<2) // c.addi16sp
+			case rdOK && rs1 == 2 && immI > 0 && immI%4 == 0 && immI < 1024:
+				u := uint32(immI)
+				return half(0x0000 | (u>>4&3)<<11 | (u>>6&0xf)<<7 | (u>>2&1)<<6 | (u>>3&1)<<5 | rdC<<2) // c.addi4spn
+			}
+		case 1: // slli
+			if rd != 0 && rd == rs1 && immI != 0 {
+				return half(0x0002 | rd<<7 | ciImm(immI&0x3f)) // c.slli
+			}
+		case 5: // srli, srai
+			if rdOK && rd == rs1 && immI&0x3f != 0 {
+				kind := uint32(0) // c.srli
+				if funct7>>1 == 0x10 {
+					kind = 1 // c.srai
+				}
+				return half(0x8001 | kind<<10 | rdC<<7 | ciImm(immI&0x3f))
+			}
+		case 7: // andi
+			if rdOK && rd == rs1 && fitsSigned(immI, 6) {
+				return half(0x8801 | rdC<<7 | ciImm(immI)) // c.andi
+			}
+		}
+	case 0x1b: // addiw
+		if funct3 == 0 && rd != 0 && rd == rs1 && fitsSigned(immI, 6) {
+			return half(0x2001 | rd<<7 | ciImm(immI)) // c.addiw
+		}
+	case 0x37: // lui
+		imm := int32(word) >> 12
+		if rd != 0 && rd != 2 && imm != 0 && fitsSigned(imm, 6) {
+			return half(0x6001 | rd<<7 | ciImm(imm)) // c.lui
+		}
+	case 0x33, 0x3b: // OP, OP-32
+		word32 := word&0x7f == 0x3b
+		if !word32 && funct3 == 0 && funct7 == 0 && rd != 0 && rs2 != 0 {
+			switch rd {
+			case rs1:
+				return half(0x9002 | rd<<7 | rs2<<2) // c.add
+			case rs2:
+				if rs1 != 0 {
+					return half(0x9002 | rd<<7 | rs1<<2) // c.add, commuted
+				}
+			}
+		}
+		if !rdOK || rd != rs1 || !rs2OK {
+			break
+		}
+		var op uint32
+		switch {
+		case funct3 == 0 && funct7 == 0x20:
+			op = 0 // c.sub, c.subw
+		case funct3 == 0 && funct7 == 0 && word32:
+			op = 1 // c.addw
+		case funct3 == 4 && funct7 == 0 && !word32:
+			op = 1 // c.xor
+		case funct3 == 6 && funct7 == 0 && !word32:
+			op = 2 // c.or
+		case funct3 == 7 && funct7 == 0 && !word32:
+			op = 3 // c.and
+		default:
+			return 0, false
+		}
+		wbit := uint32(0)
+		if word32 {
+			wbit = 1
+		}
+		return half(0x8c01 | wbit<<12 | rdC<<7 | op<<5 | rs2C<<2)
+	case 0x03, 0x07: // ld, fld
+		quadrant := uint32(0x6000) // c.ld, c.ldsp
+		if word&0x7f == 0x07 {
+			quadrant = 0x2000 // c.fld, c.fldsp
+		}
+		if funct3 != 3 || immI < 0 || immI%8 != 0 {
+			break
+		}
+		switch {
+		case rs1 == 2 && immI < 512 && (rd != 0 || word&0x7f == 0x07):
+			return half(quadrant | 0x2 | rd<<7 | spOffset(immI))
+		case rs1OK && rdOK && immI < 256:
+			return half(quadrant | rs1C<<7 | clOffset(immI) | rdC<<2)
+		}
+	case 0x23, 0x27: // sd, fsd
+		quadrant := uint32(0xe000) // c.sd, c.sdsp
+		if word&0x7f == 0x27 {
+			quadrant = 0xa000 // c.fsd, c.fsdsp
+		}
+		if funct3 != 3 || immS < 0 || immS%8 != 0 {
+			break
+		}
+		u := uint32(immS)
+		switch {
+		case rs1 == 2 && immS < 512:
+			return half(quadrant | 0x2 | (u>>3&7)<<10 | (u>>6&7)<<7 | rs2<<2)
+		case rs1OK && rs2OK && immS < 256:
+			return half(quadrant | rs1C<<7 | clOffset(immS) | rs2C<<2)
+		}
+	case 0x67: // jalr
+		if funct3 == 0 && immI == 0 && rs1 != 0 {
+			switch rd {
+			case 0:
+				return half(0x8002 | rs1<<7) // c.jr
+			case 1:
+				return half(0x9002 | rs1<<7) // c.jalr
+			}
+		}
+	case 0x6f: // jal
+		if rd == 0 && offset != 0 && fitsSigned(offset, 12) {
+			u := uint32(offset)
+			return half(0xa001 | (u>>11&1)<<12 | (u>>4&1)<<11 | (u>>8&3)<<9 | (u>>10&1)<<8 |
+				(u>>6&1)<<7 | (u>>7&1)<<6 | (u>>1&7)<<3 | (u>>5&1)<<2) // c.j
+		}
+	case 0x63: // beq, bne against zero
+		if rs2 == 0 && rs1OK && funct3 <= 1 && offset != 0 && fitsSigned(offset, 9) {
+			u := uint32(offset)
+			return half(0xc001 | funct3<<13 | (u>>8&1)<<12 | (u>>3&3)<<10 | rs1C<<7 |
+				(u>>6&3)<<5 | (u>>1&3)<<3 | (u>>5&1)<<2) // c.beqz, c.bnez
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestCompressRiscvInstr(t *testing.T) {
+	eb, _ := New("riscv64")
+	out := NewRiscvCodeGen(eb).out
+
+	// Encodings as printed by objdump for the same instructions
+	out.AddImm("sp", "sp", -32) // c.addi sp, -32
+	out.Store64("ra", "sp", 24) // c.sdsp ra, 24(sp)
+	out.Store64("s0", "sp", 16) // c.sdsp s0, 16(sp)
+	out.AddImm("s0", "sp", 32)  // c.addi4spn s0, sp, 32
+	out.Load64("ra", "sp", 24)  // c.ldsp ra, 24(sp)
+	out.LoadImm("a0", 0)        // c.li a0, 0
+	out.Move("a1", "a0")        // c.mv a1, a0
+	out.Add("a0", "a0", "a1")   // c.add a0, a1
+	out.Sub("a0", "a0", "a1")   // c.sub a0, a1
+	out.Return()                // c.jr ra
+	out.JumpAndLink("zero", 16) // c.j 16
+	out.Load64("a0", "s0", -8)  // negative offset: no compressed form
+	out.LoadImm("a7", 93)       // too large for c.li
+	out.Ecall()                 // no compressed form
+	want := []uint16{0x1101, 0xec06, 0xe822, 0x1000, 0x60e2, 0x4501, 0x85aa, 0x952e, 0x8d0d, 0x8082, 0xa801}
+
+	text := eb.text.Bytes()
+	for i := 0; i < len(text)/4; i++ {
+		word := binary.LittleEndian.Uint32(text[4*i:])
+		offset, _ := riscvBranchTarget(word)
+		half, ok := compressRiscvInstr(word, offset)
+		if i >= len(want) {
+			if ok {
+				t.Errorf("Instruction %08x should have no compressed form, got %04x", word, half)
+			}
+			continue
+		}
+		if !ok || half != want[i] {
+			t.Errorf("Instruction %08x: expected %04x, got %04x (%v)", word, want[i], half, ok)
+		}
+	}
+}
+
+func TestCompressRiscv64(t *testing.T) {
+	eb, _ := New("riscv64")
+	out := NewRiscvCodeGen(eb).out
+
+	out.BranchEqual("a0", "zero", 16) // to the ecall, over three instructions
+	out.LoadImm("a0", 1)
+	out.Load64("a1", "s0", -8)
+	out.Move("a2", "a1")
+	out.Ecall()
+	out.JumpAndLink("zero", -16) // back to li a0, 1
+	out.LeaSymbolToReg("a1", "msg")
+
+	compressRiscv64(eb)
+	text := eb.text.Bytes()
+
+	// c.beqz, c.li, ld, c.mv, ecall, c.j, auipc, addi
+	if len(text) != 2+2+4+2+4+2+4+4 {
+		t.Fatalf("Expected 24 bytes after compression, got %d: % x", len(text), text)
+	}
+	if half, _ := compressRiscvInstr(binary.LittleEndian.Uint32([]byte{0x63, 0x00, 0x05, 0x00}), 10); binary.LittleEndian.Uint16(text) != half {
+		t.Errorf("Expected the branch to skip 10 bytes, got %04x", binary.LittleEndian.Uint16(text))
+	}
+	if half, _ := compressRiscvInstr(0x6f, -12); binary.LittleEndian.Uint16(text[14:]) != half {
+		t.Errorf("Expected the jump to go back 12 bytes, got %04x", binary.LittleEndian.Uint16(text[14:]))
+	}
+	if len(eb.pcRelocations) != 1 || eb.pcRelocations[0].offset != 16 {
+		t.Errorf("Expected the relocation to move to offset 16, got %v", eb.pcRelocations)
+	}
+	if binary.LittleEndian.Uint32(text[16:])&0x7f != 0x17 || binary.LittleEndian.Uint32(text[20:])&0x7f != 0x13 {
+		t.Errorf("Expected the AUIPC/ADDI pair to stay uncompressed, got % x", text[16:])
+	}
+}
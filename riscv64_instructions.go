@@ -288,7 +288,16 @@ func (r *RiscvOut) JumpAndLink(dest string, offset int32) error {
 		return fmt.Errorf("JAL offset must be even: %d", offset)
 	}
 	if offset < -(1<<20) || offset >= (1<<20) {
-		return fmt.Errorf("JAL offset out of range: %d", offset)
+		// Out of reach: AUIPC+JALR reaches ±2GB (see jumprange.go). The
+		// link register holds the upper part, or t1 when there is none.
+		scratch := rd
+		if scratch == 0 {
+			scratch = riscvGPRegs["t1"]
+		}
+		hi := (offset + 0x800) >> 12
+		r.encodeInstr(r.encodeUType(0x17, scratch, uint32(hi)<<12))
+		r.encodeInstr(r.encodeIType(0x67, 0x0, rd, scratch, offset-hi<<12))
+		return nil
 	}
 
 	// JAL: opcode=1101111
@@ -320,50 +329,31 @@ func (r *RiscvOut) JumpAndLinkRegister(dest, base string, offset int32) error {
 
 // BEQ: beq rs1, rs2, offset
 func (r *RiscvOut) BranchEqual(src1, src2 string, offset int32) error {
-	rs1, ok := riscvGPRegs[src1]
-	if !ok {
-		return fmt.Errorf("invalid RISC-V register: %s", src1)
-	}
-	rs2, ok := riscvGPRegs[src2]
-	if !ok {
-		return fmt.Errorf("invalid RISC-V register: %s", src2)
-	}
-
-	// Offset must be even and within ±4KB
-	if offset%2 != 0 {
-		return fmt.Errorf("branch offset must be even: %d", offset)
-	}
-	if offset < -(1<<12) || offset >= (1<<12) {
-		return fmt.Errorf("branch offset out of range: %d", offset)
-	}
-
-	// BEQ: opcode=1100011, funct3=000
-	instr := r.encodeBType(0x63, 0x0, rs1, rs2, offset)
-	r.encodeInstr(instr)
-	return nil
+	return r.branch(0x0, src1, src2, offset) // BEQ: opcode=1100011, funct3=000
 }
 
 // BNE: bne rs1, rs2, offset
 func (r *RiscvOut) BranchNotEqual(src1, src2 string, offset int32) error {
-	rs1, ok := riscvGPRegs[src1]
-	if !ok {
-		return fmt.Errorf("invalid RISC-V register: %s", src1)
-	}
-	rs2, ok := riscvGPRegs[src2]
-	if !ok {
-		return fmt.Errorf("invalid RISC-V register: %s", src2)
-	}
+	return r.branch(0x1, src1, src2, offset) // BNE: opcode=1100011, funct3=001
+}
 
+// branch emits a conditional branch with funct3, which reaches ±4KB. Beyond
+// that, it emits the inverse branch over a JAL (see jumprange.go).
+func (r *RiscvOut) branch(funct3 uint32, src1, src2 string, offset int32) error {
+	rs1, ok1 := riscvGPRegs[src1]
+	rs2, ok2 := riscvGPRegs[src2]
+	if !ok1 || !ok2 {
+		return fmt.Errorf("invalid register in branch %s, %s", src1, src2)
+	}
 	if offset%2 != 0 {
 		return fmt.Errorf("branch offset must be even: %d", offset)
 	}
 	if offset < -(1<<12) || offset >= (1<<12) {
-		return fmt.Errorf("branch offset out of range: %d", offset)
+		// BEQ/BNE, BLT/BGE and BLTU/BGEU differ in the low bit of funct3
+		r.encodeInstr(r.encodeBType(0x63, funct3^1, rs1, rs2, 8))
+		return r.JumpAndLink("zero", offset-4)
 	}
-
-	// BNE: opcode=1100011, funct3=001
-	instr := r.encodeBType(0x63, 0x1, rs1, rs2, offset)
-	r.encodeInstr(instr)
+	r.encodeInstr(r.encodeBType(0x63, funct3, rs1, rs2, offset))
 	return nil
 }
 
@@ -759,6 +749,31 @@ func (r *RiscvOut) FsqrtD(dest, src string) error {
 	return nil
 }
 
+// FmaddD: fd = fs1 * fs2 + fs3 (double precision, single rounding)
+func (r *RiscvOut) FmaddD(dest, src1, src2, src3 string) error {
+	return r.encodeFusedD(0x43, "fmadd.d", dest, src1, src2, src3)
+}
+
+// FmsubD: fd = fs1 * fs2 - fs3 (double precision, single rounding)
+func (r *RiscvOut) FmsubD(dest, src1, src2, src3 string) error {
+	return r.encodeFusedD(0x47, "fmsub.d", dest, src1, src2, src3)
+}
+
+// encodeFusedD emits an R4-type fused multiply-add instruction
+// R4-type: rs3[31:27] | fmt[26:25] | rs2 | rs1 | rm | rd | opcode, fmt=01 (D)
+func (r *RiscvOut) encodeFusedD(opcode uint32, mnemonic, dest, src1, src2, src3 string) error {
+	fd, ok1 := riscvFPRegs[dest]
+	fs1, ok2 := riscvFPRegs[src1]
+	fs2, ok3 := riscvFPRegs[src2]
+	fs3, ok4 := riscvFPRegs[src3]
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		return fmt.Errorf("invalid FP register in %s %s, %s, %s, %s", mnemonic, dest, src1, src2, src3)
+	}
+	instr := r.encodeRType(opcode, 0x0, (fs3<<2)|0x01, fd, fs1, fs2)
+	r.encodeInstr(instr)
+	return nil
+}
+
 // FcvtDW: fd = (double)rs1 (int32 to double)
 func (r *RiscvOut) FcvtDW(dest, src string) error {
 	fd, ok1 := riscvFPRegs[dest]
@@ -841,54 +856,22 @@ func (r *RiscvOut) Fsd(src, base string, offset int32) error {
 
 // Blt: if (rs1 < rs2) goto PC + offset (signed)
 func (r *RiscvOut) Blt(src1, src2 string, offset int32) error {
-	rs1, ok1 := riscvGPRegs[src1]
-	rs2, ok2 := riscvGPRegs[src2]
-	if !ok1 || !ok2 {
-		return fmt.Errorf("invalid register in blt %s, %s", src1, src2)
-	}
-	// BLT: opcode=1100011, funct3=100
-	instr := r.encodeBType(0x63, 0x4, rs1, rs2, offset)
-	r.encodeInstr(instr)
-	return nil
+	return r.branch(0x4, src1, src2, offset) // BLT: opcode=1100011, funct3=100
 }
 
 // Bge: if (rs1 >= rs2) goto PC + offset (signed)
 func (r *RiscvOut) Bge(src1, src2 string, offset int32) error {
-	rs1, ok1 := riscvGPRegs[src1]
-	rs2, ok2 := riscvGPRegs[src2]
-	if !ok1 || !ok2 {
-		return fmt.Errorf("invalid register in bge %s, %s", src1, src2)
-	}
-	// BGE: opcode=1100011, funct3=101
-	instr := r.encodeBType(0x63, 0x5, rs1, rs2, offset)
-	r.encodeInstr(instr)
-	return nil
+	return r.branch(0x5, src1, src2, offset) // BGE: opcode=1100011, funct3=101
 }
 
 // Bltu: if (rs1 < rs2) goto PC + offset (unsigned)
 func (r *RiscvOut) Bltu(src1, src2 string, offset int32) error {
-	rs1, ok1 := riscvGPRegs[src1]
-	rs2, ok2 := riscvGPRegs[src2]
-	if !ok1 || !ok2 {
-		return fmt.Errorf("invalid register in bltu %s, %s", src1, src2)
-	}
-	// BLTU: opcode=1100011, funct3=110
-	instr := r.encodeBType(0x63, 0x6, rs1, rs2, offset)
-	r.encodeInstr(instr)
-	return nil
+	return r.branch(0x6, src1, src2, offset) // BLTU: opcode=1100011, funct3=110
 }
 
 // Bgeu: if (rs1 >= rs2) goto PC + offset (unsigned)
 func (r *RiscvOut) Bgeu(src1, src2 string, offset int32) error {
-	rs1, ok1 := riscvGPRegs[src1]
-	rs2, ok2 := riscvGPRegs[src2]
-	if !ok1 || !ok2 {
-		return fmt.Errorf("invalid register in bgeu %s, %s", src1, src2)
-	}
-	// BGEU: opcode=1100011, funct3=111
-	instr := r.encodeBType(0x63, 0x7, rs1, rs2, offset)
-	r.encodeInstr(instr)
-	return nil
+	return r.branch(0x7, src1, src2, offset) // BGEU: opcode=1100011, funct3=111
 }
 
 // Additional load/store instructions
@@ -1010,6 +993,32 @@ func (r *RiscvOut) Sb(src, base string, offset int32) error {
 	return nil
 }
 
+// Zbb (basic bit manipulation) instructions, which RVA22 cores have
+
+// zbbUnary encodes a Zbb instruction with one source register, an OP-IMM
+// with a fixed immediate
+func (r *RiscvOut) zbbUnary(name string, funct3 uint32, imm int32, dest, src string) error {
+	rd, ok1 := riscvGPRegs[dest]
+	rs1, ok2 := riscvGPRegs[src]
+	if !ok1 || !ok2 {
+		return fmt.Errorf("invalid register in %s %s, %s", name, dest, src)
+	}
+	r.encodeInstr(r.encodeIType(0x13, funct3, rd, rs1, imm))
+	return nil
+}
+
+// Clz: rd = number of leading zeros of rs1 (Zbb)
+func (r *RiscvOut) Clz(dest, src string) error { return r.zbbUnary("clz", 0x1, 0x600, dest, src) }
+
+// Ctz: rd = number of trailing zeros of rs1 (Zbb)
+func (r *RiscvOut) Ctz(dest, src string) error { return r.zbbUnary("ctz", 0x1, 0x601, dest, src) }
+
+// Cpop: rd = number of set bits of rs1 (Zbb)
+func (r *RiscvOut) Cpop(dest, src string) error { return r.zbbUnary("cpop", 0x1, 0x602, dest, src) }
+
+// Rev8: rd = rs1 with its bytes reversed (Zbb)
+func (r *RiscvOut) Rev8(dest, src string) error { return r.zbbUnary("rev8", 0x5, 0x6b8, dest, src) }
+
 // LeaSymbolToReg loads the effective address of a symbol (PC-relative)
 func (r *RiscvOut) LeaSymbolToReg(dst, symbol string) {
 	// Delegate to the RISC-V backend's implementation
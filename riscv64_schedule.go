@@ -0,0 +1,282 @@
+// Completion: 100% - List scheduling for in-order RISC-V cores
+package main
+
+import "encoding/binary"
+
+// riscv64_schedule.go - Instruction scheduling for in-order RV64 cores
+//
+// The RISC-V code generator evaluates an expression one step at a time, so a
+// load is usually followed right away by the instruction that needs it:
+//
+//	ld   a0, -8(s0)
+//	mv   t0, a0        # waits for the load
+//	ld   a0, -16(s0)
+//	mv   t1, a0        # waits again
+//
+// Out-of-order cores hide the wait, but the simple in-order cores of many
+// RV64 boards stall. scheduleRiscv64 reorders the instructions of each basic
+// block so that loads and other slow instructions start as soon as their
+// operands allow, and independent instructions fill the gap behind them.
+//
+// The order that must be kept is read from the encoded instructions: the
+// registers they read and write, and memory. Two loads may trade places,
+// and so may accesses with the same base register at offsets that do not
+// overlap; any other pair of memory accesses keeps its order. Branches,
+// jumps, system instructions, atomics and fences stay where they are, and so
+// do AUIPC and the instruction after it, which a relocation patches as a
+// pair. They end the blocks, as do the targets of branches.
+
+// riscvScheduleMaxBlock bounds the quadratic dependency scan; longer runs of
+// instructions are scheduled in pieces of this size
+const riscvScheduleMaxBlock = 128
+
+// rvSchedInstr is an instruction as the scheduler sees it
+type rvSchedInstr struct {
+	word    uint32
+	defs    []int // registers written: 0-31 are x0-x31, 32-63 are f0-f31
+	uses    []int // registers read
+	load    bool
+	store   bool
+	base    int   // base register of a load or store
+	offset  int32 // offset from the base register
+	size    int32 // bytes accessed
+	latency int   // cycles until the result can be used
+	fixed   bool  // never moved, ends a block
+}
+
+// rvSchedEdge orders instruction to after the one that owns the edge
+type rvSchedEdge struct {
+	to      int
+	latency int
+}
+
+// scheduleRiscv64 reorders the RISC-V instructions in text in place
+func scheduleRiscv64(text []byte) {
+	n := len(text) / 4
+	instrs := make([]rvSchedInstr, n)
+	blockStart := make([]bool, n+1)
+	for i := range instrs {
+		word := binary.LittleEndian.Uint32(text[4*i:])
+		instrs[i] = decodeRiscvForSchedule(word)
+		if target, ok := riscvBranchTarget(word); ok {
+			if t := i + int(target/4); t >= 0 && t <= n {
+				blockStart[t] = true
+			}
+		}
+		if i > 0 && word&0x7f != 0x17 && instrs[i-1].word&0x7f == 0x17 {
+			instrs[i].fixed = true // the ADDI or load patched with an AUIPC
+		}
+	}
+
+	start := 0
+	for i := 0; i <= n; i++ {
+		if i == n || instrs[i].fixed || blockStart[i] || i-start == riscvScheduleMaxBlock {
+			if i-start > 1 {
+				order := scheduleRiscvBlock(instrs[start:i])
+				for k, j := range order {
+					binary.LittleEndian.PutUint32(text[4*(start+k):], instrs[start+j].word)
+				}
+			}
+			start = i
+			if i < n && instrs[i].fixed {
+				start = i + 1
+			}
+		}
+	}
+}
+
+// scheduleRiscvBlock returns the order in which to emit a block, as indices
+func scheduleRiscvBlock(block []rvSchedInstr) []int {
+	n := len(block)
+	succs := make([][]rvSchedEdge, n)
+	preds := make([]int, n)
+	lastDef := make(map[int]int)   // register -> index of its last writer
+	readers := make(map[int][]int) // register -> readers since its last writer
+	version := make(map[int]int)   // register -> number of writes so far
+	baseVersion := make([]int, n)
+	addEdge := func(from, to, latency int) {
+		succs[from] = append(succs[from], rvSchedEdge{to, latency})
+		preds[to]++
+	}
+
+	for i, in := range block {
+		for _, r := range in.uses {
+			if j, ok := lastDef[r]; ok {
+				addEdge(j, i, block[j].latency)
+			}
+		}
+		for _, r := range in.defs {
+			if j, ok := lastDef[r]; ok {
+				addEdge(j, i, 1)
+			}
+			for _, j := range readers[r] {
+				if j != i {
+					addEdge(j, i, 0)
+				}
+			}
+		}
+		if in.load || in.store {
+			baseVersion[i] = version[in.base]
+			for j := 0; j < i; j++ {
+				other := block[j]
+				if !(other.load || other.store) || (in.load && other.load) {
+					continue
+				}
+				if other.base == in.base && baseVersion[j] == baseVersion[i] &&
+					(other.offset+other.size <= in.offset || in.offset+in.size <= other.offset) {
+					continue
+				}
+				addEdge(j, i, 1)
+			}
+		}
+		for _, r := range in.uses {
+			readers[r] = append(readers[r], i)
+		}
+		for _, r := range in.defs {
+			lastDef[r] = i
+			readers[r] = nil
+			version[r]++
+		}
+	}
+
+	// Priority: the longest latency path from an instruction to the block end
+	priority := make([]int, n)
+	for i := n - 1; i >= 0; i-- {
+		priority[i] = block[i].latency
+		for _, e := range succs[i] {
+			priority[i] = max(priority[i], e.latency+priority[e.to])
+		}
+	}
+
+	// Issue one instruction per cycle: the most critical one whose operands
+	// are ready, or else the one that is ready first
+	ready := make([]int, n) // cycle at which the operands are available
+	done := make([]bool, n)
+	order := make([]int, 0, n)
+	cycle := 0
+	for len(order) < n {
+		best := -1
+		for i := 0; i < n; i++ {
+			if done[i] || preds[i] > 0 {
+				continue
+			}
+			if best < 0 {
+				best = i
+				continue
+			}
+			iReady, bestReady := ready[i] <= cycle, ready[best] <= cycle
+			switch {
+			case iReady != bestReady:
+				if iReady {
+					best = i
+				}
+			case !iReady && ready[i] != ready[best]:
+				if ready[i] < ready[best] {
+					best = i
+				}
+			case priority[i] > priority[best]:
+				best = i
+			}
+		}
+		cycle = max(cycle, ready[best])
+		done[best] = true
+		order = append(order, best)
+		for _, e := range succs[best] {
+			preds[e.to]--
+			ready[e.to] = max(ready[e.to], cycle+e.latency)
+		}
+		cycle++
+	}
+	return order
+}
+
+// riscvBranchTarget returns the byte offset of the target of a branch or JAL
+func riscvBranchTarget(word uint32) (int32, bool) {
+	switch word & 0x7f {
+	case 0x63: // branch
+		imm := int32(word&0x80000000)>>19 | int32(word&0x80)<<4 | int32(word>>20)&0x7e0 | int32(word>>7)&0x1e
+		return imm, true
+	case 0x6f: // jal
+		imm := int32(word&0x80000000)>>11 | int32(word&0xff000) | int32(word>>9)&0x800 | int32(word>>20)&0x7fe
+		return imm, true
+	}
+	return 0, false
+}
+
+// decodeRiscvForSchedule finds the registers, memory access and latency of an
+// instruction. Anything it does not know is fixed in place.
+func decodeRiscvForSchedule(word uint32) rvSchedInstr {
+	in := rvSchedInstr{word: word, latency: 1}
+	rd := int(word>>7) & 0x1f
+	rs1 := int(word>>15) & 0x1f
+	rs2 := int(word>>20) & 0x1f
+	rs3 := int(word>>27) & 0x1f
+	funct3 := (word >> 12) & 7
+	funct7 := word >> 25
+	const fp = 32
+
+	switch word & 0x7f {
+	case 0x33, 0x3b: // OP, OP-32
+		in.defs, in.uses = []int{rd}, []int{rs1, rs2}
+		if funct7 == 1 { // M extension
+			in.latency = 3
+			if funct3 >= 4 {
+				in.latency = 20
+			}
+		}
+	case 0x13, 0x1b: // OP-IMM, OP-IMM-32
+		in.defs, in.uses = []int{rd}, []int{rs1}
+	case 0x37: // LUI
+		in.defs = []int{rd}
+	case 0x03, 0x07: // LOAD, LOAD-FP
+		in.defs, in.uses = []int{rd}, []int{rs1}
+		if word&0x7f == 0x07 {
+			in.defs = []int{fp + rd}
+		}
+		in.load, in.base, in.offset = true, rs1, int32(word)>>20
+		in.size = 1 << (funct3 & 3)
+		in.latency = 3
+	case 0x23, 0x27: // STORE, STORE-FP
+		in.uses = []int{rs1, rs2}
+		if word&0x7f == 0x27 {
+			in.uses = []int{rs1, fp + rs2}
+		}
+		in.store, in.base = true, rs1
+		in.offset = int32(word)>>25<<5 | int32(word>>7)&0x1f
+		in.size = 1 << (funct3 & 3)
+	case 0x43, 0x47, 0x4b, 0x4f: // fused multiply-add
+		in.defs, in.uses = []int{fp + rd}, []int{fp + rs1, fp + rs2, fp + rs3}
+		in.latency = 5
+	case 0x53: // OP-FP
+		dst, src := fp+rd, fp+rs1
+		switch funct7 {
+		case 0x50, 0x51, 0x60, 0x61, 0x70, 0x71: // compares, fcvt to integer, fmv.x, fclass
+			dst = rd
+		case 0x68, 0x69, 0x78, 0x79: // fcvt from integer, fmv from integer
+			src = rs1
+		}
+		in.defs, in.uses = []int{dst}, []int{src, fp + rs2}
+		in.latency = 4
+		if funct7 == 0x0c || funct7 == 0x0d || funct7 == 0x2c || funct7 == 0x2d { // fdiv, fsqrt
+			in.latency = 20
+		}
+	default: // branches, jumps, AUIPC, system, atomics, fences
+		in.fixed = true
+	}
+
+	// x0 is never written and always reads as zero
+	in.defs = dropRiscvZero(in.defs)
+	in.uses = dropRiscvZero(in.uses)
+	return in
+}
+
+// dropRiscvZero removes x0 from a register list
+func dropRiscvZero(regs []int) []int {
+	kept := regs[:0]
+	for _, r := range regs {
+		if r != 0 {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func riscvWords(text []byte) []uint32 {
+	words := make([]uint32, len(text)/4)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(text[4*i:])
+	}
+	return words
+}
+
+func TestScheduleRiscv64(t *testing.T) {
+	eb, _ := New("riscv64")
+	out := NewRiscvCodeGen(eb).out
+
+	// Two loads, each used right away, and an independent constant
+	out.Load64("a0", "s0", -8)
+	out.Move("t0", "a0")
+	out.Load64("a1", "s0", -16)
+	out.Move("t1", "a1")
+	out.AddImm("a2", "zero", 5)
+	out.Store64("t0", "s0", -24)
+	out.Load64("a3", "s0", -24)
+	out.Ecall()
+	out.Move("t2", "a0")
+	before := riscvWords(eb.text.Bytes())
+
+	scheduleRiscv64(eb.text.Bytes())
+	after := riscvWords(eb.text.Bytes())
+
+	position := make(map[uint32]int)
+	for i, word := range after {
+		position[word] = i
+	}
+	if len(position) != len(before) {
+		t.Fatalf("Expected the same instructions after scheduling, got %08x from %08x", after, before)
+	}
+	for _, word := range before {
+		if _, ok := position[word]; !ok {
+			t.Fatalf("Instruction %08x lost by scheduling", word)
+		}
+	}
+	ldA0, mvT0, ldA1, mvT1 := before[0], before[1], before[2], before[3]
+	sdT0, ldA3, ecall, mvT2 := before[5], before[6], before[7], before[8]
+
+	if position[ldA1] > position[mvT0] {
+		t.Errorf("Expected the second load to move ahead of the use of the first, got %08x", after)
+	}
+	if position[ldA0] > position[mvT0] || position[ldA1] > position[mvT1] {
+		t.Errorf("A use was moved ahead of its load: %08x", after)
+	}
+	if position[sdT0] < position[mvT0] || position[ldA3] < position[sdT0] {
+		t.Errorf("A store was reordered with its dependencies: %08x", after)
+	}
+	if position[ecall] != 7 || position[mvT2] != 8 {
+		t.Errorf("Expected ecall to end the block, got %08x", after)
+	}
+}
@@ -0,0 +1,218 @@
+// Completion: 100% - Module complete
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// runtimeinit.go - One-time runtime initialization at program entry (x86-64)
+//
+// _c67_runtime_init runs once, right after the program arguments are saved
+// and before the first statement and any @@ threads. It fills in the
+// cpu_has_* flags with CPUID and then the dispatch table of cpudispatch.go.
+// Detection of new CPU features belongs here, next to a cpu_has_* flag
+// defined in emitRuntimeInit.
+//
+// CPUID is cheap on bare metal, but a hypervisor traps it, so the call is
+// only made by programs that look at the flags. Every read of a cpu_has_*
+// flag and every callDispatched leaves a relocation to the symbol, and the
+// second code generation pass leaves the call and the helper out if the
+// first pass had none. The runtime helpers are only generated in the second
+// pass, and most of them whether they are called or not, so a helper counts
+// once the program code reaches it through calls. When one turns out to use
+// a dispatch slot, the pass runs once more with the call.
+
+// emitRuntimeInit emits the call to _c67_runtime_init at program entry
+func (fc *C67Compiler) emitRuntimeInit() {
+	fc.eb.DefineWritable("cpu_has_fma", "\x00")    // FMA3 support (Haswell 2013+)
+	fc.eb.DefineWritable("cpu_has_avx2", "\x00")   // AVX2 support (Haswell 2013+)
+	fc.eb.DefineWritable("cpu_has_popcnt", "\x00") // POPCNT support (Nehalem 2008+)
+	fc.eb.DefineWritable("cpu_has_avx512", "\x00") // AVX-512F support (Skylake-X 2017+)
+	fc.eb.DefineWritable("cpu_has_lzcnt", "\x00")  // LZCNT support (Haswell 2013+, AMD K10)
+	fc.eb.DefineWritable("cpu_has_bmi1", "\x00")   // BMI1 support, which has TZCNT (Haswell 2013+)
+
+	for _, entry := range cpuDispatchTable {
+		fc.eb.DefineWritable(entry.slot, "\x00\x00\x00\x00\x00\x00\x00\x00")
+	}
+
+	fc.usesRuntimeInit = true
+	fc.out.CallSymbol("_c67_runtime_init")
+}
+
+// isCPUFeatureSymbol reports whether symbol is a cpu_has_* flag or a dispatch slot
+func isCPUFeatureSymbol(symbol string) bool {
+	return strings.HasPrefix(symbol, "cpu_has_") || strings.HasPrefix(symbol, "_c67_dispatch_")
+}
+
+// usesCPUFeatures reports whether the code generated so far reads a cpu_has_*
+// flag or a dispatch slot. It is meant for the first pass, which has no
+// runtime helpers yet.
+func (fc *C67Compiler) usesCPUFeatures() bool {
+	for _, reloc := range fc.eb.pcRelocations {
+		if isCPUFeatureSymbol(reloc.symbolName) {
+			return true
+		}
+	}
+	return false
+}
+
+// reachesCPUFeatures reports whether the program code, everything before
+// runtimeHelpersStart, reads a cpu_has_* flag or a dispatch slot, directly or
+// through the runtime helpers it calls. Each relocation and call belongs to
+// the function whose label precedes it. _c67_runtime_init itself is left out.
+func (fc *C67Compiler) reachesCPUFeatures() bool {
+	type function struct {
+		name   string
+		offset int
+	}
+	var functions []function
+	for name := range fc.eb.functions {
+		if offset, ok := fc.eb.labels[name]; ok {
+			functions = append(functions, function{name, offset})
+		}
+	}
+	sort.Slice(functions, func(i, j int) bool { return functions[i].offset < functions[j].offset })
+	enclosing := func(position int) string {
+		i := sort.Search(len(functions), func(i int) bool { return functions[i].offset > position })
+		if i == 0 {
+			return ""
+		}
+		return functions[i-1].name
+	}
+
+	uses := make(map[string]bool)
+	for _, reloc := range fc.eb.pcRelocations {
+		if isCPUFeatureSymbol(reloc.symbolName) {
+			uses[enclosing(int(reloc.offset))] = true
+		}
+	}
+	callers := make(map[string][]string)
+	for _, patch := range fc.eb.callPatches {
+		if patch.targetName != "_c67_runtime_init" {
+			callers[patch.targetName] = append(callers[patch.targetName], enclosing(patch.position))
+		}
+	}
+	delete(uses, "_c67_runtime_init")
+
+	// Spread the use from each function to its callers
+	pending := make([]string, 0, len(uses))
+	for name := range uses {
+		pending = append(pending, name)
+	}
+	for len(pending) > 0 {
+		name := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, caller := range callers[name] {
+			if !uses[caller] {
+				uses[caller] = true
+				pending = append(pending, caller)
+			}
+		}
+	}
+
+	for name := range uses {
+		offset, ok := fc.eb.labels[name]
+		if name == "" || (ok && offset < fc.runtimeHelpersStart) {
+			return true
+		}
+	}
+	return false
+}
+
+// regenerateTextWithRuntimeInit runs the second pass, with the call to
+// _c67_runtime_init if the first pass used CPU features. If the program only
+// uses them through the runtime helpers of the second pass, it runs the pass
+// again with the call.
+func (fc *C67Compiler) regenerateTextWithRuntimeInit(program *Program, entryLabel string) error {
+	fc.usesRuntimeInit = fc.usesCPUFeatures()
+	if err := fc.regenerateText(program, entryLabel); err != nil {
+		return err
+	}
+	if fc.usesRuntimeInit || !fc.reachesCPUFeatures() {
+		return nil
+	}
+	fc.usesRuntimeInit = true
+	return fc.regenerateText(program, entryLabel)
+}
+
+// generateRuntimeInit emits _c67_runtime_init(), which fills in the cpu_has_*
+// flags and the dispatch table. AVX2 and AVX-512 are only reported when the
+// OS saves the YMM/ZMM state (XCR0). Clobbers rax, rcx, rdx, r8 and r9.
+func (fc *C67Compiler) generateRuntimeInit() {
+	fc.eb.MarkFunction("_c67_runtime_init")
+	fc.out.PushReg("rbx") // cpuid writes ebx
+
+	// Check CPUID leaf 1 for FMA and POPCNT
+	fc.out.MovImmToReg("rax", "1")     // CPUID leaf 1
+	fc.out.XorRegWithReg("rcx", "rcx") // subleaf 0
+	fc.out.Emit([]byte{0x0f, 0xa2})    // cpuid
+
+	// Test ECX bit 12 (FMA)
+	fc.out.Emit([]byte{0x0f, 0xba, 0xe1, 0x0c}) // bt ecx, 12
+	fc.out.Emit([]byte{0x0f, 0x92, 0xc0})       // setc al
+	fc.out.LeaSymbolToReg("rbx", "cpu_has_fma")
+	fc.out.MovByteRegToMem("rax", "rbx", 0)
+
+	// Test ECX bit 23 (POPCNT)
+	fc.out.Emit([]byte{0x0f, 0xba, 0xe1, 0x17}) // bt ecx, 23
+	fc.out.Emit([]byte{0x0f, 0x92, 0xc0})       // setc al
+	fc.out.LeaSymbolToReg("rbx", "cpu_has_popcnt")
+	fc.out.MovByteRegToMem("rax", "rbx", 0)
+
+	// r8 = XCR0 if the OS uses XSAVE (ECX bit 27), else 0
+	fc.out.Emit([]byte{0x45, 0x31, 0xc0})       // xor r8d, r8d
+	fc.out.Emit([]byte{0x0f, 0xba, 0xe1, 0x1b}) // bt ecx, 27
+	fc.out.Emit([]byte{0x73, 0x08})             // jnc +8 (skip xgetbv)
+	fc.out.Emit([]byte{0x31, 0xc9})             // xor ecx, ecx
+	fc.out.Emit([]byte{0x0f, 0x01, 0xd0})       // xgetbv
+	fc.out.Emit([]byte{0x49, 0x89, 0xc0})       // mov r8, rax
+
+	// Check CPUID leaf 7 for AVX2 and AVX-512
+	fc.out.MovImmToReg("rax", "7")     // CPUID leaf 7
+	fc.out.XorRegWithReg("rcx", "rcx") // subleaf 0
+	fc.out.Emit([]byte{0x0f, 0xa2})    // cpuid
+
+	// Test EBX bit 3 (BMI1)
+	fc.out.Emit([]byte{0x0f, 0xba, 0xe3, 0x03}) // bt ebx, 3
+	fc.out.Emit([]byte{0x0f, 0x92, 0xc0})       // setc al
+	fc.out.LeaSymbolToReg("rdx", "cpu_has_bmi1")
+	fc.out.MovByteRegToMem("rax", "rdx", 0)
+
+	// Test EBX bit 5 (AVX2) and XCR0 bits 1-2 (XMM and YMM state)
+	fc.out.Emit([]byte{0x0f, 0xba, 0xe3, 0x05}) // bt ebx, 5
+	fc.out.Emit([]byte{0x0f, 0x92, 0xc0})       // setc al
+	fc.out.Emit([]byte{0x4d, 0x89, 0xc1})       // mov r9, r8
+	fc.out.Emit([]byte{0x41, 0x83, 0xe1, 0x06}) // and r9d, 6
+	fc.out.Emit([]byte{0x41, 0x83, 0xf9, 0x06}) // cmp r9d, 6
+	fc.out.Emit([]byte{0x0f, 0x94, 0xc2})       // sete dl
+	fc.out.Emit([]byte{0x20, 0xd0})             // and al, dl
+	fc.out.LeaSymbolToReg("rdx", "cpu_has_avx2")
+	fc.out.MovByteRegToMem("rax", "rdx", 0)
+
+	// Test EBX bit 16 (AVX512F - foundation) and XCR0 bits 1-2, 5-7 (opmask and ZMM state)
+	fc.out.Emit([]byte{0x0f, 0xba, 0xe3, 0x10})                   // bt ebx, 16
+	fc.out.Emit([]byte{0x0f, 0x92, 0xc0})                         // setc al
+	fc.out.Emit([]byte{0x4d, 0x89, 0xc1})                         // mov r9, r8
+	fc.out.Emit([]byte{0x41, 0x81, 0xe1, 0xe6, 0x00, 0x00, 0x00}) // and r9d, 0xe6
+	fc.out.Emit([]byte{0x41, 0x81, 0xf9, 0xe6, 0x00, 0x00, 0x00}) // cmp r9d, 0xe6
+	fc.out.Emit([]byte{0x0f, 0x94, 0xc2})                         // sete dl
+	fc.out.Emit([]byte{0x20, 0xd0})                               // and al, dl
+	fc.out.LeaSymbolToReg("rdx", "cpu_has_avx512")
+	fc.out.MovByteRegToMem("rax", "rdx", 0)
+
+	// Check CPUID leaf 0x80000001 ECX bit 5 (LZCNT)
+	fc.out.MovImmToReg("rax", "2147483649") // CPUID leaf 0x80000001
+	fc.out.XorRegWithReg("rcx", "rcx")
+	fc.out.Emit([]byte{0x0f, 0xa2})             // cpuid
+	fc.out.Emit([]byte{0x0f, 0xba, 0xe1, 0x05}) // bt ecx, 5
+	fc.out.Emit([]byte{0x0f, 0x92, 0xc0})       // setc al
+	fc.out.LeaSymbolToReg("rdx", "cpu_has_lzcnt")
+	fc.out.MovByteRegToMem("rax", "rdx", 0)
+
+	// Select runtime helper variants now that the flags are known
+	fc.emitCPUDispatchInit()
+
+	fc.out.PopReg("rbx")
+	fc.out.Ret()
+}
@@ -0,0 +1,246 @@
+// Completion: 100% - --sandbox for untrusted scripts (Linux x86-64)
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// sandbox.go - Compiling untrusted scripts with --sandbox
+//
+//	c67 --sandbox plugin.c67
+//
+// With --sandbox, a program may compute, print, read files and use threads,
+// but not change files, start programs or talk to the network. This is
+// checked twice. At compile time, the constructs that reach the system
+// directly are errors: unsafe blocks, syscall, C imports and c.* calls,
+// dlopen and friends, call(), the raw read_* and write_* memory functions,
+// write_file(), run(), spawning processes and network ports. At run time,
+// before the first statement, the program installs a seccomp-bpf filter that
+// the kernel keeps for the rest of its life. The filter lets through the
+// system calls that computing, printing, reading files and threads need
+// (sandboxAllowedSyscalls), and any other fails with EPERM, so code that
+// gets past the compiler, such as a C library linked in some other way,
+// still cannot, among others:
+//
+//   - execve, execveat, fork, vfork, or clone without CLONE_THREAD
+//   - create sockets, connect, bind, listen or accept
+//   - open files for writing, create, remove, rename or link them, make
+//     or remove directories or device nodes, or change the mode, owner,
+//     times or extended attributes of files, even ones it has open
+//   - send signals to other processes or threads
+//   - ptrace, mount, or set up io_uring to do the above
+//
+// open and openat are let through without the flags for writing, clone
+// only for threads and ioctl only for the requests that ask about the
+// terminal. clone3 and openat2 fail with ENOSYS, since their flags are out
+// of the filter's reach and libc falls back to clone and openat. A system
+// call from another ABI than x86-64, such as x32 or int 0x80, kills the
+// process. A kernel that refuses the filter stops the program before it
+// starts.
+
+// sandboxDenied are the calls --sandbox rejects at compile time, by what
+// they would do
+var sandboxDenied = map[string]string{
+	"syscall":    "raw system calls",
+	"write_file": "writing files",
+	"run":        "running commands",
+	"dlopen":     "loading libraries", "dlsym": "loading libraries", "dlclose": "loading libraries",
+	"call": "calling function pointers",
+}
+
+// checkSandbox returns an error for the first construct in program that
+// --sandbox does not allow
+func (fc *C67Compiler) checkSandbox(program *Program) error {
+	if fc.eb.target.OS() != OSLinux || fc.eb.target.Arch() != ArchX86_64 {
+		return fmt.Errorf("--sandbox is only supported on Linux x86-64")
+	}
+	var denied string
+	visitAST(reflect.ValueOf(program.Statements), func(node any) {
+		if denied != "" {
+			return
+		}
+		switch n := node.(type) {
+		case *UnsafeExpr:
+			denied = "unsafe blocks"
+		case *SyscallStmt:
+			denied = "raw system calls"
+		case *CImportStmt:
+			denied = fmt.Sprintf("importing the C library %s", n.Library)
+		case *SpawnStmt:
+			denied = "spawning processes"
+		case *SendExpr, *ReceiveExpr, *ReceiveLoopStmt:
+			denied = "network ports"
+		case *CallExpr:
+			if n.IsCFFI {
+				denied = fmt.Sprintf("calling C functions (c.%s)", n.Function)
+			} else if what, ok := sandboxDenied[n.Function]; ok {
+				denied = fmt.Sprintf("%s (%s)", what, n.Function)
+			} else if builtinFunctions[n.Function] && n.Function != "read_file" &&
+				(strings.HasPrefix(n.Function, "read_") || strings.HasPrefix(n.Function, "write_")) {
+				denied = fmt.Sprintf("raw memory access (%s)", n.Function)
+			}
+		}
+	})
+	if denied != "" {
+		return fmt.Errorf("--sandbox does not allow %s", denied)
+	}
+	return nil
+}
+
+// Classic BPF opcodes and seccomp return values
+const (
+	bpfLoadWord  = 0x20 // BPF_LD | BPF_W | BPF_ABS
+	bpfJumpEqual = 0x15 // BPF_JMP | BPF_JEQ | BPF_K
+	bpfJumpAbove = 0x35 // BPF_JMP | BPF_JGE | BPF_K
+	bpfJumpSet   = 0x45 // BPF_JMP | BPF_JSET | BPF_K
+	bpfReturn    = 0x06 // BPF_RET | BPF_K
+
+	seccompKill  = 0x80000000 // SECCOMP_RET_KILL_PROCESS
+	seccompErrno = 0x00050000 // SECCOMP_RET_ERRNO, with the errno in the low 16 bits
+	seccompAllow = 0x7fff0000 // SECCOMP_RET_ALLOW
+
+	auditArchX86_64 = 0xc000003e
+	x32SyscallBit   = 0x40000000
+	errnoEPERM      = 1
+	errnoENOSYS     = 38
+	cloneThread     = 0x00010000
+	openWriteFlags  = 0x1 | 0x2 | 0x40 | 0x200 | 0x400 // O_WRONLY|O_RDWR|O_CREAT|O_TRUNC|O_APPEND
+)
+
+// Offsets in struct seccomp_data
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArgs = 16
+)
+
+// sandboxAllowedSyscalls are the x86-64 system calls that a sandboxed
+// program may make, the ones that computing, printing, reading files and
+// threads need. Any other system call fails with EPERM.
+var sandboxAllowedSyscalls = []uint32{
+	0, 1, 3, 8, 17, 19, 20, 72, 436, // read, write, close, lseek, pread64, readv, writev, fcntl, close_range
+	4, 5, 6, 262, 332, 21, 269, 439, // stat, fstat, lstat, newfstatat, statx, access, faccessat(2)
+	89, 267, 79, 78, 217, 137, 138, 221, // readlink(at), getcwd, getdents(64), statfs, fstatfs, fadvise64
+	9, 10, 11, 12, 25, 26, 27, 28, // mmap, mprotect, munmap, brk, mremap, msync, mincore, madvise
+	13, 14, 15, 131, // rt_sigaction, rt_sigprocmask, rt_sigreturn, sigaltstack
+	202, 273, 334, 218, 204, 24, 158, // futex, set_robust_list, rseq, set_tid_address, sched_getaffinity, sched_yield, arch_prctl
+	7, 23, 270, 271, 35, 230, // poll, select, pselect6, ppoll, nanosleep, clock_nanosleep
+	96, 201, 228, 229, 318, // gettimeofday, time, clock_gettime, clock_getres, getrandom
+	39, 186, 110, 102, 104, 107, 108, 63, 97, 99, 100, // getpid, gettid, getppid, get(e)uid, get(e)gid, uname, getrlimit, sysinfo, times
+	60, 231, 219, // exit, exit_group, restart_syscall
+}
+
+// sandboxFlaggedSyscalls are the system calls that are allowed unless an
+// argument has one of the flags, by system call, argument and flags
+var sandboxFlaggedSyscalls = []struct {
+	nr, arg, flags uint32
+}{
+	{2, 1, openWriteFlags},   // open(path, flags)
+	{257, 2, openWriteFlags}, // openat(dir, path, flags)
+}
+
+// sandboxIoctls are the ioctl requests that are allowed, which libc makes
+// to find out whether stdout is a terminal, and how wide it is
+var sandboxIoctls = []uint32{0x5401, 0x5413} // TCGETS, TIOCGWINSZ
+
+// sandboxFilter returns the seccomp-bpf program of --sandbox
+func sandboxFilter() []byte {
+	var filter []byte
+	emit := func(code uint16, jt, jf uint8, k uint32) {
+		filter = binary.LittleEndian.AppendUint16(filter, code)
+		filter = append(filter, jt, jf)
+		filter = binary.LittleEndian.AppendUint32(filter, k)
+	}
+	emit(bpfLoadWord, 0, 0, seccompDataArch)
+	emit(bpfJumpEqual, 1, 0, auditArchX86_64)
+	emit(bpfReturn, 0, 0, seccompKill)
+	emit(bpfLoadWord, 0, 0, seccompDataNr)
+	emit(bpfJumpAbove, 0, 1, x32SyscallBit)
+	emit(bpfReturn, 0, 0, seccompKill)
+
+	for _, nr := range sandboxAllowedSyscalls {
+		emit(bpfJumpEqual, 0, 1, nr)
+		emit(bpfReturn, 0, 0, seccompAllow)
+	}
+	for _, nr := range []uint32{435, 437} { // clone3, openat2
+		emit(bpfJumpEqual, 0, 1, nr)
+		emit(bpfReturn, 0, 0, seccompErrno|errnoENOSYS)
+	}
+
+	// The low half of the argument; the flags all fit in it
+	for _, s := range sandboxFlaggedSyscalls {
+		emit(bpfJumpEqual, 0, 4, s.nr)
+		emit(bpfLoadWord, 0, 0, seccompDataArgs+8*s.arg)
+		emit(bpfJumpSet, 0, 1, s.flags)
+		emit(bpfReturn, 0, 0, seccompErrno|errnoEPERM)
+		emit(bpfReturn, 0, 0, seccompAllow)
+	}
+	emit(bpfJumpEqual, 0, 4, 56) // clone(flags, ...)
+	emit(bpfLoadWord, 0, 0, seccompDataArgs)
+	emit(bpfJumpSet, 1, 0, cloneThread)
+	emit(bpfReturn, 0, 0, seccompErrno|errnoEPERM)
+	emit(bpfReturn, 0, 0, seccompAllow)
+	emit(bpfJumpEqual, 0, uint8(2*len(sandboxIoctls)+2), 16) // ioctl(fd, request, ...)
+	emit(bpfLoadWord, 0, 0, seccompDataArgs+8)
+	for _, request := range sandboxIoctls {
+		emit(bpfJumpEqual, 0, 1, request)
+		emit(bpfReturn, 0, 0, seccompAllow)
+	}
+	emit(bpfReturn, 0, 0, seccompErrno|errnoEPERM)
+
+	emit(bpfReturn, 0, 0, seccompErrno|errnoEPERM)
+	return filter
+}
+
+// sandboxFailedMsg is printed when the kernel does not take the filter
+const sandboxFailedMsg = "Error: could not install the --sandbox seccomp filter\n"
+
+// emitSandboxInit installs the seccomp filter at program entry
+func (fc *C67Compiler) emitSandboxInit() {
+	if !SandboxFlag {
+		return
+	}
+	filter := sandboxFilter()
+	fc.eb.Define("_c67_sandbox_filter", string(filter))
+	fc.eb.Define("_c67_sandbox_failed_msg", sandboxFailedMsg)
+
+	// prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0), which an unprivileged filter needs
+	fc.out.MovImmToReg("rax", "157")
+	fc.out.MovImmToReg("rdi", "38")
+	fc.out.MovImmToReg("rsi", "1")
+	fc.out.XorRegWithReg("rdx", "rdx")
+	fc.out.XorRegWithReg("r10", "r10")
+	fc.out.XorRegWithReg("r8", "r8")
+	fc.out.Syscall()
+	fc.out.TestRegReg("rax", "rax")
+	failed := fc.forwardJump(JumpNotEqual)
+
+	// seccomp(SECCOMP_SET_MODE_FILTER, 0, &struct sock_fprog{len, filter})
+	fc.out.SubImmFromReg("rsp", 16)
+	fc.out.MovImmToMem(int64(len(filter)/8), "rsp", 0)
+	fc.out.LeaSymbolToReg("rax", "_c67_sandbox_filter")
+	fc.out.MovRegToMem("rax", "rsp", 8)
+	fc.out.MovImmToReg("rax", "317")
+	fc.out.MovImmToReg("rdi", "1")
+	fc.out.XorRegWithReg("rsi", "rsi")
+	fc.out.MovRegToReg("rdx", "rsp")
+	fc.out.Syscall()
+	fc.out.AddImmToReg("rsp", 16)
+	fc.out.TestRegReg("rax", "rax")
+	installed := fc.forwardJump(JumpEqual)
+
+	fc.landForwardJump(failed)
+	fc.out.MovImmToReg("rdi", "2")
+	fc.out.LeaSymbolToReg("rsi", "_c67_sandbox_failed_msg")
+	fc.out.MovImmToReg("rdx", strconv.Itoa(len(sandboxFailedMsg)))
+	fc.out.MovImmToReg("rax", "1") // write
+	fc.out.Syscall()
+	fc.out.MovImmToReg("rdi", "1")
+	fc.out.MovImmToReg("rax", "231") // exit_group
+	fc.out.Syscall()
+	fc.landForwardJump(installed)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// TestSandboxRejects tests that --sandbox rejects system access at compile time
+func TestSandboxRejects(t *testing.T) {
+	defer func() { SandboxFlag = false }()
+	SandboxFlag = true
+	for _, source := range []string{
+		"run(\"ls\")\n",
+		"p = c.malloc(8)\n",
+		"import sdl3 as sdl\nprintln(1)\n",
+		"x = unsafe int64 {\n    rax <- 1\n} {\n    x0 <- 1\n} {\n    a0 <- 1\n}\n",
+		"f = x -> write_i8(x, 0, 1)\nf(0)\n",
+	} {
+		if _, err := compileTestCodeAllowError(t, source); err == nil || !strings.Contains(err.Error(), "--sandbox does not allow") {
+			t.Errorf("Expected --sandbox to reject %q, got %v", source, err)
+		}
+	}
+}
+
+// TestSandboxRuns tests that a sandboxed program can still print, read
+// files, start threads and read the clock and the time zone
+func TestSandboxRuns(t *testing.T) {
+	defer func() { SandboxFlag = false }()
+	SandboxFlag = true
+	source := `@@ i in 0..<4 { println(i * 0) }
+println(#read_file("/proc/self/cmdline") >= 0)
+println(uuid4()[14])
+println(#datefmt(now(), "YYYY"))
+`
+	want := "0\n0\n0\n0\n1\n52\n4\n"
+	if result := compileAndRun(t, source); result != want {
+		t.Errorf("Expected %q, got %q", want, result)
+	}
+}
+
+// TestSandboxFilter runs the seccomp filter on system calls and checks
+// what it decides
+func TestSandboxFilter(t *testing.T) {
+	filter := sandboxFilter()
+	run := func(arch, nr uint32, args ...uint64) uint32 {
+		data := make([]byte, 64)
+		binary.LittleEndian.PutUint32(data[seccompDataNr:], nr)
+		binary.LittleEndian.PutUint32(data[seccompDataArch:], arch)
+		for i, arg := range args {
+			binary.LittleEndian.PutUint64(data[seccompDataArgs+8*i:], arg)
+		}
+		var a uint32
+		for pc := 0; pc < len(filter)/8; pc++ {
+			ins := filter[8*pc:]
+			code, jt, jf, k := binary.LittleEndian.Uint16(ins), int(ins[2]), int(ins[3]), binary.LittleEndian.Uint32(ins[4:])
+			switch code {
+			case bpfLoadWord:
+				a = binary.LittleEndian.Uint32(data[k:])
+			case bpfJumpEqual, bpfJumpAbove, bpfJumpSet:
+				taken := (code == bpfJumpEqual && a == k) || (code == bpfJumpAbove && a >= k) || (code == bpfJumpSet && a&k != 0)
+				if taken {
+					pc += jt
+				} else {
+					pc += jf
+				}
+			case bpfReturn:
+				return k
+			default:
+				t.Fatalf("Unexpected BPF opcode %#x", code)
+			}
+		}
+		t.Fatalf("The filter ran off its end")
+		return 0
+	}
+
+	eperm, enosys := uint32(seccompErrno|errnoEPERM), uint32(seccompErrno|errnoENOSYS)
+	tests := []struct {
+		name string
+		got  uint32
+		want uint32
+	}{
+		{"write", run(auditArchX86_64, 1, 1), seccompAllow},
+		{"execve", run(auditArchX86_64, 59), eperm},
+		{"socket", run(auditArchX86_64, 41), eperm},
+		{"fchmod", run(auditArchX86_64, 91, 3, 0o777), eperm},
+		{"fchown", run(auditArchX86_64, 93, 3, 0, 0), eperm},
+		{"mknodat", run(auditArchX86_64, 259), eperm},
+		{"fsetxattr", run(auditArchX86_64, 190), eperm},
+		{"utimensat", run(auditArchX86_64, 280), eperm},
+		{"kill", run(auditArchX86_64, 62, 1, 9), eperm},
+		{"tgkill", run(auditArchX86_64, 234), eperm},
+		{"futex", run(auditArchX86_64, 202), seccompAllow},
+		{"isatty", run(auditArchX86_64, 16, 1, 0x5401), seccompAllow},
+		{"TIOCSTI", run(auditArchX86_64, 16, 0, 0x5412), eperm},
+		{"openat for reading", run(auditArchX86_64, 257, 0, 0, 0), seccompAllow},
+		{"openat for writing", run(auditArchX86_64, 257, 0, 0, 0x241), eperm},
+		{"open for appending", run(auditArchX86_64, 2, 0, 0x401), eperm},
+		{"thread", run(auditArchX86_64, 56, 0x3d0f00), seccompAllow},
+		{"fork through clone", run(auditArchX86_64, 56, 0x1200011), eperm},
+		{"clone3", run(auditArchX86_64, 435), enosys},
+		{"x32", run(auditArchX86_64, x32SyscallBit|1), seccompKill},
+		{"i386", run(0x40000003, 4), seccompKill},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: expected %#x, got %#x", tt.name, tt.want, tt.got)
+		}
+	}
+}
@@ -320,3 +320,87 @@ func TestSDL3ExampleCompiles(t *testing.T) {
 		t.Logf("Successfully compiled sdl3example.c67 for Windows: %d bytes", len(data))
 	})
 }
+
+func TestSDLEasyWrapperGeneration(t *testing.T) {
+	functions := map[string]*CFunctionSignature{
+		"SDL_Init":         {ReturnType: "bool", Params: []CFunctionParam{{Name: "flags", Type: "SDL_InitFlags"}}},
+		"SDL_CreateWindow": {ReturnType: "SDL_Window *", Params: []CFunctionParam{{Name: "title", Type: "const char *"}, {Name: "w", Type: "int"}, {Name: "h", Type: "int"}, {Name: "flags", Type: "SDL_WindowFlags"}}},
+		"SDL_SetRenderDrawColor": {ReturnType: "bool", Params: []CFunctionParam{{Name: "renderer", Type: "SDL_Renderer *"},
+			{Name: "r", Type: "Uint8"}, {Name: "g", Type: "Uint8"}, {Name: "b", Type: "Uint8"}, {Name: "a", Type: "Uint8"}}},
+		"SDL_PollEvent": {ReturnType: "bool", Params: []CFunctionParam{{Name: "event", Type: "SDL_Event *"}}},
+	}
+
+	window, err := generateSDLEasyWrapper("sdl", "window", functions)
+	if err != nil {
+		t.Fatalf("window: %v", err)
+	}
+	for _, want := range []string{"sdl_easy_window = (title, w, h) ->", "sdl.SDL_Init(32)", "sdl.SDL_CreateWindow(title as cstr, w, h, 0)"} {
+		if !strings.Contains(window, want) {
+			t.Errorf("window wrapper lacks %q:\n%s", want, window)
+		}
+	}
+
+	events, err := generateSDLEasyWrapper("gfx", "events", functions)
+	if err != nil {
+		t.Fatalf("events: %v", err)
+	}
+	for _, want := range []string{"gfx_easy_state := { quit: 0, keydown: 0, keyup: 0 }", "gfx.SDL_PollEvent(event)"} {
+		if !strings.Contains(events, want) {
+			t.Errorf("events wrapper lacks %q:\n%s", want, events)
+		}
+	}
+	program := NewParserWithFilename(window+events, "<sdl.easy>").ParseProgram()
+	if len(program.Statements) != 3 {
+		t.Errorf("expected 3 statements from the generated source, got %d", len(program.Statements))
+	}
+
+	// clear also needs SDL_RenderClear, which is missing
+	if _, err := generateSDLEasyWrapper("sdl", "clear", functions); err == nil || !strings.Contains(err.Error(), "SDL_RenderClear") {
+		t.Errorf("expected an error naming SDL_RenderClear, got %v", err)
+	}
+	if _, err := generateSDLEasyWrapper("sdl", "teapot", functions); err == nil || !strings.Contains(err.Error(), "available: clear, events") {
+		t.Errorf("expected an error listing the wrappers, got %v", err)
+	}
+}
+
+func TestSDLEasyWindows(t *testing.T) {
+	source := `import sdl3 as sdl
+
+renderer := sdl.easy.renderer(sdl.easy.window("Hello", 640, 480))
+running := 1
+@ running == 1 max inf {
+    sdl.easy.clear(renderer, 30, 30, 60)
+    sdl.easy.present(renderer)
+    e := sdl.easy.events()
+    running <- 1 - e.quit
+}
+`
+
+	// The wrappers are generated from the bundled SDL3 headers
+	tmpFile, err := os.CreateTemp("", "sdl_easy_windows_test_*.c67")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmpFile.WriteString(source); err != nil {
+		tmpFile.Close()
+		t.Fatalf("Failed to write source: %v", err)
+	}
+	tmpFile.Close()
+
+	outputPath := tmpPath + ".exe"
+	defer os.Remove(outputPath)
+
+	platform := Platform{
+		Arch: ArchX86_64,
+		OS:   OSWindows,
+	}
+	if err := CompileC67(tmpPath, outputPath, platform); err != nil {
+		t.Fatalf("Windows sdl.easy compilation failed: %v", err)
+	}
+	if _, err := os.Stat(outputPath); err != nil {
+		t.Errorf("Output file not created: %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+// Completion: 100% - sdl.easy wrappers generated from SDL3 signatures
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// sdl_easy.go - Beginner wrappers for SDL3
+//
+//	import sdl3 as sdl
+//	window := sdl.easy.window("Hello", 640, 480)
+//	renderer := sdl.easy.renderer(window)
+//	@ sdl.easy.events().quit == 0 max inf {
+//	    sdl.easy.clear(renderer, 30, 30, 60)
+//	    sdl.easy.present(renderer)
+//	}
+//
+// Each wrapper is generated as C67 source for the library it is used with.
+// A wrapper that forwards to an SDL function takes its parameter names and
+// types from the signature discovered for that function, passes strings on
+// as C strings and fills in the parameters it does not expose. Only the
+// wrappers a program calls are generated, and only when every SDL function
+// they call has a signature, so a mismatched SDL build fails at compile
+// time with the name of the missing function. alias.easy.name(...) calls
+// the generated alias_easy_name function.
+
+// sdlEasyWrapper describes one sdl.easy function
+type sdlEasyWrapper struct {
+	forward string   // SDL function whose leading parameters the wrapper takes
+	fixed   []string // values for the parameters of forward it does not take
+	text    []int    // forwarded parameters that take a string, if the signature does not say
+	params  []string // parameters of a wrapper that does not forward
+	before  []string // statements before the forwarded call
+	after   []string // statements after it, the last one giving the result
+	needs   []string // other SDL functions the statements call
+	returns string   // C67 type of the result, "" for number
+}
+
+// sdlEasyWrappers is the curated subset of SDL3 behind sdl.easy. In the
+// statements, {sdl} is the import alias, {state} the events map and {0},
+// {1}... the wrapper's parameters.
+var sdlEasyWrappers = map[string]sdlEasyWrapper{
+	// window(title, w, h) initializes video and opens a window
+	"window": {
+		forward: "SDL_CreateWindow",
+		fixed:   []string{"0"},
+		text:    []int{0},
+		before:  []string{"{sdl}.SDL_Init(32)"}, // SDL_INIT_VIDEO
+		needs:   []string{"SDL_Init"},
+	},
+	// renderer(window) creates the default renderer for a window
+	"renderer": {
+		forward: "SDL_CreateRenderer",
+		fixed:   []string{"0"},
+	},
+	// clear(renderer, r, g, b) fills the frame with an opaque color
+	"clear": {
+		forward: "SDL_SetRenderDrawColor",
+		fixed:   []string{"255"},
+		after:   []string{"{sdl}.SDL_RenderClear({0})"},
+		needs:   []string{"SDL_RenderClear"},
+	},
+	// present(renderer) shows the frame
+	"present": {
+		forward: "SDL_RenderPresent",
+	},
+	// events() drains the event queue into {quit, keydown, keyup}, where
+	// quit is 1 if the window was closed and keydown and keyup are the
+	// scancodes of the last key pressed and released, 0 for none
+	"events": {
+		before: []string{
+			"{state}[0] <- 0",
+			"{state}[1] <- 0",
+			"{state}[2] <- 0",
+			"event = c.malloc(128)", // sizeof(SDL_Event)
+			"@ {sdl}.SDL_PollEvent(event) != 0 max inf {",
+			"    kind = read_u32(event, 0)",
+			"    kind == 256 {", // SDL_EVENT_QUIT
+			"        {state}[0] <- 1",
+			"    }",
+			"    kind == 768 {", // SDL_EVENT_KEY_DOWN
+			"        {state}[1] <- read_u32(event, 6)",
+			"    }",
+			"    kind == 769 {", // SDL_EVENT_KEY_UP
+			"        {state}[2] <- read_u32(event, 6)",
+			"    }",
+			"}",
+			"c.free(event)",
+			"{state}",
+		},
+		needs:   []string{"SDL_PollEvent"},
+		returns: "map",
+	},
+	// key(scancode) is 1 while the key is held down
+	"key": {
+		params: []string{"scancode"},
+		before: []string{"read_u8({sdl}.SDL_GetKeyboardState(0), {0})"},
+		needs:  []string{"SDL_GetKeyboardState"},
+	},
+	// playwav(path) starts playing a .wav file on the default output and
+	// gives its audio stream, 0 if the file could not be loaded
+	"playwav": {
+		params: []string{"path"},
+		before: []string{
+			"{sdl}.SDL_Init(16)", // SDL_INIT_AUDIO
+			"spec = c.malloc(16)",
+			"data = c.malloc(8)",
+			"size = c.malloc(8)",
+			"stream := 0",
+			"{sdl}.SDL_LoadWAV({0} as cstr, spec, data, size) != 0 {",
+			"    stream <- {sdl}.SDL_OpenAudioDeviceStream(4294967295, spec, 0, 0)", // default playback device
+			"    {sdl}.SDL_PutAudioStreamData(stream, read_u64(data, 0), read_u32(size, 0))",
+			"    {sdl}.SDL_ResumeAudioStreamDevice(stream)",
+			"    {sdl}.SDL_free(read_u64(data, 0))",
+			"}",
+			"c.free(spec)",
+			"c.free(data)",
+			"c.free(size)",
+			"stream",
+		},
+		needs: []string{"SDL_Init", "SDL_LoadWAV", "SDL_OpenAudioDeviceStream", "SDL_PutAudioStreamData",
+			"SDL_ResumeAudioStreamDevice", "SDL_free"},
+	},
+}
+
+// sdlEasyName returns the generated function behind alias.easy.name(...),
+// or "" if call is not such a call
+func sdlEasyName(call string) string {
+	parts := strings.Split(call, ".")
+	if len(parts) != 3 || parts[1] != "easy" {
+		return ""
+	}
+	return parts[0] + "_easy_" + parts[2]
+}
+
+// sdlEasyParam turns a C parameter name into a usable C67 parameter name
+func sdlEasyParam(name string, i int) string {
+	if name == "" {
+		return fmt.Sprintf("arg%d", i)
+	}
+	if tok := NewLexer(name).NextToken(); tok.Type != TOKEN_IDENT || tok.Value != name {
+		return name + "_"
+	}
+	return name
+}
+
+// generateSDLEasyWrapper returns the C67 source of the wrapper name for the
+// SDL3 import alias, given the function signatures found for it
+func generateSDLEasyWrapper(alias, name string, functions map[string]*CFunctionSignature) (string, error) {
+	w, ok := sdlEasyWrappers[name]
+	if !ok {
+		names := make([]string, 0, len(sdlEasyWrappers))
+		for n := range sdlEasyWrappers {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("%s.easy has no function '%s' (available: %s)", alias, name, strings.Join(names, ", "))
+	}
+	needs := w.needs
+	if w.forward != "" {
+		needs = append([]string{w.forward}, needs...)
+	}
+	for _, fn := range needs {
+		if functions[fn] == nil {
+			return "", fmt.Errorf("%s.easy.%s needs %s, which was not found in the %s headers", alias, name, fn, alias)
+		}
+	}
+
+	params := w.params
+	var forwarded string
+	if w.forward != "" {
+		sig := functions[w.forward]
+		if len(sig.Params) < len(w.fixed) {
+			return "", fmt.Errorf("%s.easy.%s expects %s to take at least %d parameter(s), found %d",
+				alias, name, w.forward, len(w.fixed), len(sig.Params))
+		}
+		params = nil
+		var args []string
+		for i, p := range sig.Params[:len(sig.Params)-len(w.fixed)] {
+			param := sdlEasyParam(p.Name, i)
+			params = append(params, param)
+			if slices.Contains(w.text, i) || strings.Contains(p.Type, "char") && isPointerType(p.Type) {
+				param += " as cstr"
+			}
+			args = append(args, param)
+		}
+		args = append(args, w.fixed...)
+		forwarded = fmt.Sprintf("{sdl}.%s(%s)", w.forward, strings.Join(args, ", "))
+	}
+
+	var body []string
+	body = append(body, w.before...)
+	if forwarded != "" {
+		body = append(body, forwarded)
+	}
+	body = append(body, w.after...)
+
+	replacements := []string{"{sdl}", alias, "{state}", alias + "_easy_state"}
+	for i, param := range params {
+		replacements = append(replacements, fmt.Sprintf("{%d}", i), param)
+	}
+	r := strings.NewReplacer(replacements...)
+
+	var sb strings.Builder
+	if name == "events" {
+		sb.WriteString(r.Replace("{state} := { quit: 0, keydown: 0, keyup: 0 }\n"))
+	}
+	fmt.Fprintf(&sb, "%s_easy_%s = (%s) -> {\n", alias, name, strings.Join(params, ", "))
+	for _, line := range body {
+		sb.WriteString("    " + r.Replace(line) + "\n")
+	}
+	sb.WriteString("}\n")
+	return sb.String(), nil
+}
+
+// addSDLEasyWrappers generates the sdl.easy functions the program calls and
+// places them after the import they belong to
+func (fc *C67Compiler) addSDLEasyWrappers(program *Program) {
+	calls := make(map[string]bool)
+	for _, stmt := range program.Statements {
+		collectFunctionCallsFromStmt(stmt, calls)
+	}
+	used := make(map[string][]string) // alias -> wrapper names
+	for call := range calls {
+		if sdlEasyName(call) == "" {
+			continue
+		}
+		parts := strings.Split(call, ".")
+		if _, ok := fc.cImports[parts[0]]; ok {
+			used[parts[0]] = append(used[parts[0]], parts[2])
+		}
+	}
+	if len(used) == 0 {
+		return
+	}
+
+	var statements []Statement
+	for _, stmt := range program.Statements {
+		statements = append(statements, stmt)
+		cImport, ok := stmt.(*CImportStmt)
+		if !ok || used[cImport.Alias] == nil {
+			continue
+		}
+		if !strings.Contains(strings.ToLower(cImport.Library), "sdl3") {
+			compilerError("%s.easy is only available for SDL3 (%s imports %s)", cImport.Alias, cImport.Alias, cImport.Library)
+		}
+		var functions map[string]*CFunctionSignature
+		if constants := fc.cConstants[cImport.Alias]; constants != nil {
+			functions = constants.Functions
+		}
+		names := used[cImport.Alias]
+		sort.Strings(names)
+		var src strings.Builder
+		for _, name := range names {
+			wrapper, err := generateSDLEasyWrapper(cImport.Alias, name, functions)
+			if err != nil {
+				compilerError("%v", err)
+			}
+			src.WriteString(wrapper)
+			if sdlEasyWrappers[name].returns != "" {
+				fc.sdlEasyTypes[cImport.Alias+"_easy_"+name] = sdlEasyWrappers[name].returns
+			}
+		}
+		if VerboseMode {
+			fmt.Fprintf(os.Stderr, "Generated %s.easy wrappers:\n%s", cImport.Alias, src.String())
+		}
+		parser := NewParserWithFilename(src.String(), "<"+cImport.Alias+".easy>")
+		statements = append(statements, parser.ParseProgram().Statements...)
+		used[cImport.Alias] = nil
+	}
+	program.Statements = statements
+}
@@ -0,0 +1,223 @@
+// Completion: 100% - Secure random bytes and UUIDs (x86_64 Unix)
+package main
+
+import "strconv"
+
+// securerand.go - securerand(n) and uuid4()
+//
+// Both read the random bytes of the operating system, which are fit for
+// session tokens, keys and identifiers that must not be guessed, unlike a
+// seeded generator:
+//
+//	key = securerand(32)   // [183, 4, 92, ...], 32 numbers from 0 to 255
+//	id = uuid4()           // "1b4e28ba-2fa1-4d2b-a3e5-5f3c8a9e0d71"
+//
+// The bytes come from the getrandom(2) system call on Linux, as for ??, and
+// from getentropy on macOS and FreeBSD, in blocks of at most 256 bytes,
+// which either call gives in full. uuid4() is a version 4, variant 1 UUID
+// in lowercase. A negative n gives error("arg") and a failed read, such as
+// on a kernel without getrandom, gives error("io") rather than bytes that
+// are not random.
+
+// secureRandBlock is the most bytes read at a time
+const secureRandBlock = 256
+
+// compileSecureRandCall compiles securerand(n) and uuid4()
+func (fc *C67Compiler) compileSecureRandCall(call *CallExpr) {
+	if fc.eb.target.Arch() != ArchX86_64 || fc.eb.target.OS() == OSWindows {
+		compilerError("%s() is only supported on x86_64 Unix targets", call.Function)
+	}
+	if fc.eb.target.OS() != OSLinux {
+		fc.trackFunctionCall("getentropy")
+	}
+	fc.eb.Define("_c67_hex_digits", "0123456789abcdef")
+	fc.usesSecureRand = true
+
+	if call.Function == "uuid4" {
+		if len(call.Args) != 0 {
+			compilerError("uuid4() takes no arguments")
+		}
+		fc.trackFunctionCall("strlen") // cstr_to_c67_string
+		fc.out.CallSymbol("_c67_uuid4")
+		return
+	}
+	if len(call.Args) != 1 {
+		compilerError("securerand() requires exactly 1 argument (number of bytes)")
+	}
+	fc.compileExpression(call.Args[0])
+	fc.out.Cvttsd2si("rdi", "xmm0")
+	fc.out.CallSymbol("_c67_securerand")
+}
+
+// generateSecureRandHelpers emits the runtime helpers behind securerand and uuid4
+func (fc *C67Compiler) generateSecureRandHelpers() {
+	fc.generateSecureRandFill()
+	fc.generateSecureRand()
+	fc.generateUUID4()
+}
+
+// generateSecureRandFill emits _c67_securerand_fill(rdi=buffer, rsi=length),
+// which fills up to secureRandBlock bytes and returns rax=0 on success.
+// Expects an aligned stack, like a C function.
+func (fc *C67Compiler) generateSecureRandFill() {
+	fc.eb.MarkFunction("_c67_securerand_fill")
+
+	if fc.eb.target.OS() == OSLinux {
+		fc.out.MovRegToReg("r8", "rsi")
+		fc.out.MovImmToReg("rax", "318") // getrandom
+		fc.out.XorRegWithReg("rdx", "rdx")
+		fc.out.Syscall()
+		fc.out.SubRegFromReg("rax", "r8") // 0 when every byte was read
+		fc.out.Ret()
+		return
+	}
+	fc.out.SubImmFromReg("rsp", 8)
+	fc.trackFunctionCall("getentropy")
+	fc.eb.GenerateCallInstruction("getentropy")
+	fc.out.AddImmToReg("rsp", 8)
+	fc.out.Ret()
+}
+
+// generateSecureRand emits _c67_securerand(rdi=n) -> xmm0, a list of n random bytes
+func (fc *C67Compiler) generateSecureRand() {
+	fc.eb.MarkFunction("_c67_securerand")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+	fc.out.SubImmFromReg("rsp", secureRandBlock)
+	fc.out.AndRegWithImm("rsp", -16) // [rsp] = the current block of bytes
+
+	fc.out.MovRegToReg("r12", "rdi") // r12 = n
+	fc.out.TestRegReg("r12", "r12")
+	negative := fc.forwardJump(JumpLess)
+
+	// Allocate count (8) + n * 16 bytes
+	fc.out.ShlImmReg("rdi", 4)
+	fc.out.AddImmToReg("rdi", 8)
+	fc.callArenaAlloc()
+	fc.out.MovRegToReg("rbx", "rax") // rbx = list
+	fc.out.Cvtsi2sd("xmm0", "r12")
+	fc.out.MovXmmToMem("xmm0", "rbx", 0)
+
+	fc.out.XorRegWithReg("r13", "r13") // r13 = index
+	loopStart := fc.eb.text.Len()
+	fc.out.CmpRegToReg("r13", "r12")
+	done := fc.forwardJump(JumpGreaterOrEqual)
+
+	// Read the next block at the start of each one
+	fc.out.MovRegToReg("r14", "r13")
+	fc.out.AndRegWithImm("r14", secureRandBlock-1) // r14 = index in the block
+	inBlock := fc.forwardJump(JumpNotEqual)
+	fc.out.MovRegToReg("rsi", "r12")
+	fc.out.SubRegFromReg("rsi", "r13")
+	fc.out.CmpRegToImm("rsi", secureRandBlock)
+	short := fc.forwardJump(JumpLessOrEqual)
+	fc.out.MovImmToReg("rsi", strconv.Itoa(secureRandBlock))
+	fc.landForwardJump(short)
+	fc.out.MovRegToReg("rdi", "rsp")
+	fc.out.CallSymbol("_c67_securerand_fill")
+	fc.out.TestRegReg("rax", "rax")
+	failed := fc.forwardJump(JumpNotEqual)
+	fc.landForwardJump(inBlock)
+
+	fc.out.AddRegToReg("r14", "rsp")
+	fc.out.MovU8MemToReg("rax", "r14", 0)
+	fc.out.Cvtsi2sd("xmm0", "rax")
+	fc.out.Cvtsi2sd("xmm1", "r13")
+	fc.out.MovRegToReg("rcx", "r13")
+	fc.out.ShlImmReg("rcx", 4)
+	fc.out.AddRegToReg("rcx", "rbx")
+	fc.out.MovXmmToMem("xmm1", "rcx", 8)
+	fc.out.MovXmmToMem("xmm0", "rcx", 16)
+	fc.out.IncReg("r13")
+	fc.out.JumpUnconditional(int32(loopStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(done)
+	fc.out.MovqRegToXmm("xmm0", "rbx")
+	end := fc.forwardJumpAlways()
+
+	fc.landForwardJump(negative)
+	fc.createErrorResult("arg")
+	endNegative := fc.forwardJumpAlways()
+
+	fc.landForwardJump(failed)
+	fc.createErrorResult("io")
+
+	fc.landForwardJump(end)
+	fc.landForwardJump(endNegative)
+	fc.out.LeaMemToReg("rsp", "rbp", -32)
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generateUUID4 emits _c67_uuid4() -> xmm0, a random UUID string
+func (fc *C67Compiler) generateUUID4() {
+	fc.eb.MarkFunction("_c67_uuid4")
+
+	// [rsp] = 16 random bytes, [rsp+16] = the 36 characters and a terminator
+	const text = 16
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.SubImmFromReg("rsp", 64)
+	fc.out.AndRegWithImm("rsp", -16)
+
+	fc.out.MovRegToReg("rdi", "rsp")
+	fc.out.MovImmToReg("rsi", "16")
+	fc.out.CallSymbol("_c67_securerand_fill")
+	fc.out.TestRegReg("rax", "rax")
+	failed := fc.forwardJump(JumpNotEqual)
+
+	// The version in the high nibble of byte 6 and the variant in the top
+	// two bits of byte 8
+	fc.out.MovU8MemToReg("rax", "rsp", 6)
+	fc.out.AndRegWithImm("rax", 0x0f)
+	fc.out.OrRegWithImm("rax", 0x40)
+	fc.out.MovU8RegToMem("rax", "rsp", 6)
+	fc.out.MovU8MemToReg("rax", "rsp", 8)
+	fc.out.AndRegWithImm("rax", 0x3f)
+	fc.out.OrRegWithImm("rax", 0x80)
+	fc.out.MovU8RegToMem("rax", "rsp", 8)
+
+	// Two hex digits per byte, with dashes after bytes 4, 6, 8 and 10
+	fc.out.LeaSymbolToReg("rdx", "_c67_hex_digits")
+	pos := text
+	for i := 0; i < 16; i++ {
+		if i == 4 || i == 6 || i == 8 || i == 10 {
+			fc.out.MovImmToReg("rax", "45") // '-'
+			fc.out.MovU8RegToMem("rax", "rsp", pos)
+			pos++
+		}
+		fc.out.MovU8MemToReg("rcx", "rsp", i)
+		fc.out.MovRegToReg("rax", "rcx")
+		fc.out.ShrRegByImm("rax", 4)
+		fc.out.AddRegToReg("rax", "rdx")
+		fc.out.MovU8MemToReg("rax", "rax", 0)
+		fc.out.MovU8RegToMem("rax", "rsp", pos)
+		fc.out.AndRegWithImm("rcx", 0x0f)
+		fc.out.AddRegToReg("rcx", "rdx")
+		fc.out.MovU8MemToReg("rcx", "rcx", 0)
+		fc.out.MovU8RegToMem("rcx", "rsp", pos+1)
+		pos += 2
+	}
+	fc.out.XorRegWithReg("rax", "rax")
+	fc.out.MovU8RegToMem("rax", "rsp", pos)
+	fc.out.LeaMemToReg("rdi", "rsp", text)
+	fc.out.CallSymbol("cstr_to_c67_string")
+	done := fc.forwardJumpAlways()
+
+	fc.landForwardJump(failed)
+	fc.createErrorResult("io")
+
+	fc.landForwardJump(done)
+	fc.out.MovRegToReg("rsp", "rbp")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
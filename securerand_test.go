@@ -0,0 +1,34 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestSecureRand tests that securerand gives bytes and uuid4 version 4 UUIDs
+func TestSecureRand(t *testing.T) {
+	source := `k = securerand(300)
+println(#k)
+inRange := 1
+@ b in k { (b < 0) or (b > 255) { inRange <- 0 } }
+println(inRange)
+println(#securerand(0))
+println(securerand(-1).error)
+println(uuid4())
+println(uuid4())
+`
+	lines := strings.Split(compileAndRun(t, source), "\n")
+	if len(lines) < 6 || strings.Join(lines[:4], " ") != "300 1 0 arg" {
+		t.Fatalf("Expected 300 bytes from 0 to 255, an empty list and error arg, got %q", lines)
+	}
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	for _, id := range lines[4:6] {
+		if !uuid.MatchString(id) {
+			t.Errorf("Expected a version 4 UUID, got %q", id)
+		}
+	}
+	if lines[4] == lines[5] {
+		t.Errorf("Expected two different UUIDs, got %q twice", lines[4])
+	}
+}
@@ -0,0 +1,205 @@
+// Completion: 100% - c67 selftest
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// selftest.go - Checking a toolchain after installation
+//
+// `c67 selftest` compiles a built-in suite of small programs for every
+// target, runs them and compares what they print and their exit codes with
+// the expected values. Programs for the host run directly. On Linux, those
+// for the other architectures run under qemu-user (qemu-aarch64,
+// qemu-riscv64, qemu-x86_64) when it is installed, and are only compiled
+// when it is not. --arch limits the targets to a comma-separated list.
+//
+// The backends do not support the same parts of the language, so each case
+// lists the architectures it runs on.
+
+// selfTestCase is a program of the suite and what running it has to give
+type selfTestCase struct {
+	name   string
+	source string
+	stdout string
+	exit   int
+	arches []Arch
+}
+
+var (
+	allArches      = []Arch{ArchX86_64, ArchARM64, ArchRiscv64}
+	lambdaArches   = []Arch{ArchX86_64, ArchARM64}
+	x86_64OnlyArch = []Arch{ArchX86_64}
+)
+
+var selfTestCases = []selfTestCase{
+	{name: "hello", source: `println("Hello, World!")`, stdout: "Hello, World!\n", arches: allArches},
+	{name: "exit code", source: `exit(6 * 7)`, exit: 42, arches: allArches},
+	{name: "f-string", source: `println(f"{1 + 2} and {3.5}")`, stdout: "3 and 3.5\n", arches: x86_64OnlyArch},
+	{name: "arithmetic", source: `println(6 * 7)`, stdout: "42\n", arches: lambdaArches},
+	{name: "lambda", source: "f = (a, b) -> a * b + 1\nexit(f(2, 3))", exit: 7, arches: lambdaArches},
+	{name: "loop", source: "sum := 0\n@ i in 0..<10 { sum <- sum + i }\nexit(sum)", exit: 45, arches: lambdaArches},
+	{name: "list index", source: "xs = [1, 2, 3]\nexit(xs[1])", exit: 2, arches: lambdaArches},
+	{name: "recursion", source: "fact = n -> n { 0 => 1 ~> n * fact(n - 1) }\nprintln(fact(10))", stdout: "3628800\n", arches: x86_64OnlyArch},
+	{name: "match", source: "classify = x -> x { 0 => 10 ~> 20 }\nprintln(classify(0))\nprintln(classify(5))", stdout: "10\n20\n", arches: x86_64OnlyArch},
+	{name: "closure", source: "n = 10\nf = x -> x + n\nprintln(f(5))", stdout: "15\n", arches: x86_64OnlyArch},
+	{name: "map", source: "m := {name: \"Ada\", age: 36}\nprintln(m.age)", stdout: "36\n", arches: x86_64OnlyArch},
+	{name: "strings", source: "s := \"abc\" + \"def\"\nprintln(s)\nprintln(#s)", stdout: "abcdef\n6\n", arches: x86_64OnlyArch},
+	{name: "list loop", source: "total := 0\n@ x in [1, 2, 3, 4] { total <- total + x }\nprintln(total)", stdout: "10\n", arches: x86_64OnlyArch},
+	{name: "variadic", source: "count = (first, rest...) -> first + #rest\nprintln(count(1, 2, 3))", stdout: "3\n", arches: x86_64OnlyArch},
+}
+
+// selfTestSkip is the reason a program that compiled can not be run here
+type selfTestSkip string
+
+func (s selfTestSkip) Error() string { return string(s) }
+
+// selfTestResult counts the outcomes of a run of the suite
+type selfTestResult struct {
+	passed, failed, skipped int
+}
+
+// cmdSelfTest runs the built-in suite
+func cmdSelfTest(ctx *CommandContext, args []string) error {
+	host := GetDefaultPlatform()
+	targets := []Arch{host.Arch}
+	if host.OS == OSLinux {
+		targets = allArches
+	}
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--arch" && i+1 < len(args):
+			i++
+			targets = nil
+			for _, name := range strings.Split(args[i], ",") {
+				arch, err := ParseArch(strings.TrimSpace(name))
+				if err != nil {
+					return err
+				}
+				targets = append(targets, arch)
+			}
+		default:
+			return fmt.Errorf("usage: c67 selftest [--arch amd64,arm64,riscv64]")
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "c67-selftest-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	out := io.Writer(os.Stdout)
+	if ctx.Quiet {
+		out = io.Discard
+	}
+	result := runSelfTest(out, dir, host, targets, ctx.OptTimeout)
+	fmt.Fprintf(out, "\n%d passed, %d failed, %d skipped\n", result.passed, result.failed, result.skipped)
+	if result.failed > 0 {
+		return fmt.Errorf("%d self-test(s) failed", result.failed)
+	}
+	return nil
+}
+
+// runSelfTest compiles and runs the cases of the suite for each target in
+// dir, reporting each case to out
+func runSelfTest(out io.Writer, dir string, host Platform, targets []Arch, optTimeout float64) selfTestResult {
+	var result selfTestResult
+	for _, arch := range targets {
+		platform := Platform{Arch: arch, OS: host.OS}
+		for i, c := range selfTestCases {
+			if !slices.Contains(c.arches, arch) {
+				continue
+			}
+			fmt.Fprintf(out, "%-13s %-12s ", platform.FullString(), c.name)
+			err := c.run(filepath.Join(dir, fmt.Sprintf("%s_%d", arch, i)), platform, host, optTimeout)
+			switch {
+			case err == nil:
+				result.passed++
+				fmt.Fprintln(out, "PASS")
+			case errors.As(err, new(selfTestSkip)):
+				result.skipped++
+				fmt.Fprintf(out, "SKIP (%v)\n", err)
+			default:
+				result.failed++
+				fmt.Fprintf(out, "FAIL\n    %v\n", err)
+			}
+		}
+	}
+	return result
+}
+
+// run compiles the case to base, runs it and checks its output
+func (c selfTestCase) run(base string, platform, host Platform, optTimeout float64) error {
+	if err := os.WriteFile(base+".c67", []byte(c.source+"\n"), 0o644); err != nil {
+		return err
+	}
+	oldQuiet := QuietMode
+	QuietMode = true
+	err := CompileC67WithOptions(base+".c67", base, platform, optTimeout, false)
+	QuietMode = oldQuiet
+	if err != nil {
+		return fmt.Errorf("compilation failed: %v", err)
+	}
+
+	command, err := selfTestRunner(platform, host)
+	if err != nil {
+		return err
+	}
+	var stdout bytes.Buffer
+	command = append(command, base)
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdout = &stdout
+	exit := 0
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() < 0 {
+			return fmt.Errorf("running failed: %v", err)
+		}
+		exit = exitErr.ExitCode()
+	}
+	if stdout.String() != c.stdout {
+		return fmt.Errorf("printed %q, expected %q", stdout.String(), c.stdout)
+	}
+	if exit != c.exit {
+		return fmt.Errorf("exited with %d, expected %d", exit, c.exit)
+	}
+	return nil
+}
+
+// selfTestRunner returns the command that runs a program for platform, to
+// which the program is appended: none for the host
+func selfTestRunner(platform, host Platform) ([]string, error) {
+	if platform == host && runtime.GOOS == host.OS.String() {
+		return nil, nil
+	}
+	if host.OS != OSLinux || platform.OS != OSLinux {
+		return nil, selfTestSkip("can not run " + platform.FullString() + " programs here")
+	}
+	qemu := "qemu-" + platform.Arch.String()
+	path, err := exec.LookPath(qemu)
+	if err != nil {
+		return nil, selfTestSkip(qemu + " not found")
+	}
+	command := []string{path}
+	// The loader and libc of the target, for dynamically linked programs
+	if sysroot := "/usr/" + platform.Arch.String() + "-linux-gnu"; dirExists(sysroot) {
+		command = append(command, "-L", sysroot)
+	}
+	return command, nil
+}
+
+// dirExists reports whether path is a directory
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
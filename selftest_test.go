@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelfTestHost(t *testing.T) {
+	host := GetDefaultPlatform()
+	var out strings.Builder
+	result := runSelfTest(&out, t.TempDir(), host, []Arch{host.Arch}, 0)
+	if result.failed > 0 || result.passed == 0 {
+		t.Fatalf("self-test on %s: %d passed, %d failed\n%s", host.FullString(), result.passed, result.failed, out.String())
+	}
+}
+
+func TestSelfTestRunner(t *testing.T) {
+	host := Platform{Arch: ArchX86_64, OS: OSLinux}
+	if command, err := selfTestRunner(host, host); err != nil || command != nil {
+		t.Errorf("host programs should run directly, got %v, %v", command, err)
+	}
+	t.Setenv("PATH", t.TempDir())
+	_, err := selfTestRunner(Platform{Arch: ArchRiscv64, OS: OSLinux}, host)
+	if _, ok := err.(selfTestSkip); !ok || !strings.Contains(err.Error(), "qemu-riscv64") {
+		t.Errorf("expected a skip for missing qemu-riscv64, got %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+// Completion: 100% - Semver ranges for Git imports
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// semver.go - Version ranges for Git imports
+//
+//	import "github.com/user/pkg@^1.2" as p
+//
+// checks out the highest tag of the repository that is in the range, here
+// the highest v1.x.y from v1.2.0 up. Ranges are written as in npm:
+//
+//	^1.2.3  >=1.2.3 <2.0.0      (^0.2.3 is <0.3.0, ^0.0.3 is <0.0.4)
+//	~1.2.3  >=1.2.3 <1.3.0      (~1 is <2.0.0)
+//	1.x     >=1.0.0 <2.0.0      (also 1.*, 1.2.x and *)
+//	>=1.2 <1.5                  all of the comparisons
+//	^1.2 || ^2.0                either range
+//
+// Comparisons are separated by spaces or commas. Tags are semantic
+// versions, with or without a leading v; other tags and prereleases, like
+// v2.0.0-rc1, are not picked. A version without any of ^ ~ < > = * x is a
+// tag, branch or commit, as before.
+//
+// The tags of the cached checkout are tried first, so an import that is
+// already satisfied needs no network. Otherwise the tags of the remote are
+// listed with git ls-remote, and the tag that is picked is fetched, which
+// --offline does not allow (see offline.go).
+
+// semver is a version, from a tag
+type semver struct {
+	major, minor, patch int
+	pre                 string // "rc1" in v2.0.0-rc1
+}
+
+var semverPattern = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// parseSemver parses a tag like v1.2.3, with the minor and patch versions
+// 0 if they are left out
+func parseSemver(tag string) (semver, bool) {
+	m := semverPattern.FindStringSubmatch(tag)
+	if m == nil {
+		return semver{}, false
+	}
+	var v semver
+	v.major, _ = strconv.Atoi(m[1])
+	v.minor, _ = strconv.Atoi(m[2])
+	v.patch, _ = strconv.Atoi(m[3])
+	v.pre = m[4]
+	return v, true
+}
+
+// compare returns -1, 0 or 1 as v is lower than, equal to or higher than w
+func (v semver) compare(w semver) int {
+	for _, d := range [...]int{v.major - w.major, v.minor - w.minor, v.patch - w.patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	switch {
+	case v.pre == w.pre:
+		return 0
+	case v.pre == "": // a release is higher than its prereleases
+		return 1
+	case w.pre == "":
+		return -1
+	case v.pre < w.pre:
+		return -1
+	}
+	return 1
+}
+
+// isVersionRange reports whether the version of an import is a range
+// rather than a tag, branch or commit
+func isVersionRange(version string) bool {
+	if strings.ContainsAny(version, "^~<>=*|, ") {
+		return true
+	}
+	_, _, wildcard := partialVersion(version)
+	return wildcard
+}
+
+// partialVersion parses a version with up to three parts, where the parts
+// that are left out or are x, X or * are -1
+func partialVersion(s string) (parts [3]int, ok, wildcard bool) {
+	parts = [3]int{-1, -1, -1}
+	fields := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	if s == "" || len(fields) > 3 {
+		return parts, false, false
+	}
+	for i, field := range fields {
+		if field == "x" || field == "X" || field == "*" {
+			wildcard = true
+			for ; i < len(fields); i++ {
+				if f := fields[i]; f != "x" && f != "X" && f != "*" {
+					return parts, false, false
+				}
+			}
+			break
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return parts, false, false
+		}
+		parts[i] = n
+	}
+	return parts, true, wildcard
+}
+
+// versionBound is one comparison of a range, like >=1.2.0
+type versionBound struct {
+	op string // >=, >, <, <= or =
+	v  semver
+}
+
+func (b versionBound) allows(v semver) bool {
+	c := v.compare(b.v)
+	switch b.op {
+	case ">=":
+		return c >= 0
+	case ">":
+		return c > 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	}
+	return c == 0
+}
+
+// parseVersionRange parses a range into alternatives of comparisons that
+// must all hold
+func parseVersionRange(constraint string) ([][]versionBound, error) {
+	var alternatives [][]versionBound
+	for _, alternative := range strings.Split(constraint, "||") {
+		var bounds []versionBound
+		for _, term := range strings.FieldsFunc(alternative, func(r rune) bool { return r == ' ' || r == ',' }) {
+			termBounds, err := parseVersionTerm(term)
+			if err != nil {
+				return nil, fmt.Errorf("version range %q: %v", constraint, err)
+			}
+			bounds = append(bounds, termBounds...)
+		}
+		alternatives = append(alternatives, bounds)
+	}
+	return alternatives, nil
+}
+
+// parseVersionTerm turns one term of a range, like ^1.2, into comparisons
+func parseVersionTerm(term string) ([]versionBound, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(term, prefix) {
+			op, term = prefix, term[len(prefix):]
+			break
+		}
+	}
+	if term == "*" || term == "x" || term == "X" {
+		return nil, nil // any version
+	}
+	parts, ok, wildcard := partialVersion(term)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a version", term)
+	}
+	low := semver{max(parts[0], 0), max(parts[1], 0), max(parts[2], 0), ""}
+	if wildcard && op != "" && op != "=" {
+		return nil, fmt.Errorf("%s%s mixes %s with a wildcard", op, term, op)
+	}
+
+	// The version after the last part that was given, for ^, ~ and 1.2
+	next := func(i int) semver {
+		v := low
+		switch i {
+		case 0:
+			return semver{v.major + 1, 0, 0, ""}
+		case 1:
+			return semver{v.major, v.minor + 1, 0, ""}
+		}
+		return semver{v.major, v.minor, v.patch + 1, ""}
+	}
+	given := 0
+	for given < 3 && parts[given] >= 0 {
+		given++
+	}
+
+	switch op {
+	case ">=", ">", "<", "<=":
+		return []versionBound{{op, low}}, nil
+	case "^":
+		// The leftmost part that is not 0 may not change
+		i := 0
+		for i < given-1 && parts[i] == 0 {
+			i++
+		}
+		return []versionBound{{">=", low}, {"<", next(i)}}, nil
+	case "~":
+		return []versionBound{{">=", low}, {"<", next(min(given-1, 1))}}, nil
+	}
+	if given == 3 {
+		return []versionBound{{"=", low}}, nil
+	}
+	return []versionBound{{">=", low}, {"<", next(given - 1)}}, nil
+}
+
+// highestMatchingTag returns the tag of the highest release in tags that
+// is in the range constraint, or "" if none is
+func highestMatchingTag(tags []string, constraint string) (string, error) {
+	alternatives, err := parseVersionRange(constraint)
+	if err != nil {
+		return "", err
+	}
+	best, bestTag := semver{}, ""
+	for _, tag := range tags {
+		v, ok := parseSemver(tag)
+		if !ok || v.pre != "" {
+			continue
+		}
+		for _, bounds := range alternatives {
+			allowed := true
+			for _, bound := range bounds {
+				allowed = allowed && bound.allows(v)
+			}
+			if allowed && (bestTag == "" || v.compare(best) > 0) {
+				best, bestTag = v, tag
+			}
+		}
+	}
+	return bestTag, nil
+}
+
+// resolveVersionRange returns the tag of repoURL to check out for a range,
+// from the cached checkout at repoPath if it has one, or else from the
+// remote, whose tag is then fetched into the checkout if there is one
+func resolveVersionRange(repoURL, repoPath, constraint string) (string, error) {
+	cached := false
+	if _, err := os.Stat(filepath.Join(repoPath, ".git")); err == nil {
+		cached = true
+		if output, err := exec.Command("git", "-C", repoPath, "tag").Output(); err == nil {
+			tag, err := highestMatchingTag(strings.Fields(string(output)), constraint)
+			if err != nil || tag != "" {
+				return tag, err
+			}
+		}
+	}
+
+	if err := requireNetwork("finding a tag in the range "+constraint+" of", repoURL); err != nil {
+		return "", err
+	}
+	output, err := gitCommand(repoURL, "ls-remote", "--tags", "--refs", gitCloneURL(repoURL)).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to list the tags of %s: %w", repoURL, err)
+	}
+	var tags []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if _, ref, ok := strings.Cut(line, "\trefs/tags/"); ok {
+			tags = append(tags, ref)
+		}
+	}
+	tag, err := highestMatchingTag(tags, constraint)
+	if err != nil {
+		return "", err
+	}
+	if tag == "" {
+		return "", fmt.Errorf("no tag of %s is in the range %s (tags: %s)", repoURL, constraint, strings.Join(tags, ", "))
+	}
+	if cached {
+		fetch := gitCommand(repoURL, "-C", repoPath, "fetch", "origin", "tag", tag, "--no-tags")
+		fetch.Stdout = os.Stderr
+		fetch.Stderr = os.Stderr
+		if err := fetch.Run(); err != nil {
+			return "", fmt.Errorf("failed to fetch %s of %s: %w", tag, repoURL, err)
+		}
+	}
+	return tag, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestHighestMatchingTag(t *testing.T) {
+	tags := []string{"v0.2.1", "v0.2.5", "v0.3.0", "v1.1.9", "v1.2.0", "v1.4.2", "1.4.3", "v2.0.0-rc1", "v2.0.0", "v2.3.1", "nightly"}
+	tests := []struct {
+		constraint string
+		want       string
+	}{
+		{"^1.2", "1.4.3"},
+		{"^1.2.0", "1.4.3"},
+		{"^0.2", "v0.2.5"},
+		{"^0.2.3", "v0.2.5"},
+		{"~1.4.2", "1.4.3"},
+		{"~1", "1.4.3"},
+		{"1.x", "1.4.3"},
+		{"1.2.*", "v1.2.0"},
+		{"*", "v2.3.1"},
+		{">=1.2 <1.4", "v1.2.0"},
+		{">=1.2, <=2.0.0", "v2.0.0"},
+		{"^0.3 || ^2.2", "v2.3.1"},
+		{"=1.1.9", "v1.1.9"},
+		{"1.2", "v1.2.0"},
+		{"^3", ""},
+	}
+	for _, tt := range tests {
+		got, err := highestMatchingTag(tags, tt.constraint)
+		if err != nil {
+			t.Errorf("highestMatchingTag(%q) error = %v", tt.constraint, err)
+		} else if got != tt.want {
+			t.Errorf("highestMatchingTag(%q) = %q, want %q", tt.constraint, got, tt.want)
+		}
+	}
+
+	for _, constraint := range []string{"^1.x", ">=one", "1.2.3.4"} {
+		if _, err := highestMatchingTag(tags, constraint); err == nil {
+			t.Errorf("highestMatchingTag(%q) should fail", constraint)
+		}
+	}
+}
+
+func TestIsVersionRange(t *testing.T) {
+	for version, want := range map[string]bool{
+		"^1.2": true, "~1.2.3": true, ">=1.0 <2": true, "1.x": true, "1.2.*": true,
+		"v1.2.3": false, "main": false, "fix": false, "3b18e51": false, "latest": false,
+	} {
+		if got := isVersionRange(version); got != want {
+			t.Errorf("isVersionRange(%q) = %v, want %v", version, got, want)
+		}
+	}
+}
+
+// TestResolveVersionRange tests that a range is resolved against the tags
+// of a remote repository
+func TestResolveVersionRange(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	remote := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", remote, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, output)
+		}
+	}
+	git("init", "-q")
+	for _, tag := range []string{"v1.1.0", "v1.2.0", "v1.4.0", "v2.0.0"} {
+		git("commit", "-q", "--allow-empty", "-m", tag)
+		git("tag", tag)
+	}
+
+	tag, err := resolveVersionRange("file://"+remote, filepath.Join(t.TempDir(), "cache"), "^1.2")
+	if err != nil || tag != "v1.4.0" {
+		t.Errorf("Expected v1.4.0, got %q, %v", tag, err)
+	}
+	if _, err := resolveVersionRange("file://"+remote, filepath.Join(t.TempDir(), "cache"), "^3"); err == nil {
+		t.Error("Expected an error when no tag is in the range")
+	}
+}
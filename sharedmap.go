@@ -0,0 +1,176 @@
+// Completion: 100% - Module complete
+package main
+
+import "fmt"
+
+// sharedmap.go - Concurrent map for use inside @@ parallel loops
+//
+// A shared map uses the ordinary map layout, so m[key], #m and loops over it
+// work unchanged. It is preallocated with a fixed capacity and carries a small
+// header in front of the count:
+//
+//	[ptr-16] insert lock (0 = free, 1 = held)
+//	[ptr-8]  capacity (int64)
+//	[ptr+0]  count (float64)
+//	[ptr+8]  key0, val0, key1, val1, ...
+//
+// Entries are found by a linear scan, not by hashing. Overwriting an existing
+// key is a single 8-byte store and takes no lock. Inserting a new key takes
+// the one spin lock of the map, re-checks the entries published since the
+// unlocked scan, writes key and value and only then bumps count, so
+// concurrent readers never see a half-written entry. Inserts into the same
+// map are therefore serialized; there is no lock striping and no CAS on the
+// entries.
+//
+// Shared maps are only generated for x86-64 Linux.
+
+// sharedMapHeaderSize is the number of bytes in front of the count field
+const sharedMapHeaderSize = 16
+
+// sharedMapErrorMsg is printed when an insert finds the map full.
+// It is defined by the sharedmap() builtin rather than by the helper, since
+// ELF runtime helpers are only generated in the second pass, after .rodata
+// has been laid out.
+const sharedMapErrorMsg = "Error: sharedmap capacity exceeded\n"
+
+// trackSharedMap records whether the variable that s binds holds a shared
+// map, so that a rebind or an unrelated variable of the same name in another
+// function does not keep using _c67_sharedmap_set
+func (fc *C67Compiler) trackSharedMap(s *AssignStmt) {
+	if call, ok := s.Value.(*CallExpr); ok && call.Function == "sharedmap" {
+		fc.sharedMapVars[s.Name] = true
+	} else {
+		delete(fc.sharedMapVars, s.Name)
+	}
+}
+
+// generateSharedMapHelpers generates _c67_sharedmap_set(map_ptr, key, value)
+// Arguments: rdi = map pointer, xmm0 = key, xmm1 = value
+// Returns: xmm0 = value
+// Clobbers only rax, rcx, rdx, rsi and xmm2 - r11 holds the parent frame in
+// parallel loop bodies and must survive the call.
+func (fc *C67Compiler) generateSharedMapHelpers() {
+	fc.eb.MarkFunction("_c67_sharedmap_set")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+
+	// rsi = entry cursor, rdx = entries scanned, rcx = published count
+	fc.out.MovRegToReg("rsi", "rdi")
+	fc.out.AddImmToReg("rsi", 8)
+	fc.out.XorRegWithReg("rdx", "rdx")
+	fc.out.MovMemToXmm("xmm2", "rdi", 0)
+	fc.out.Cvttsd2si("rcx", "xmm2")
+
+	// Lock-free scan over the published entries
+	scanStart := fc.eb.text.Len()
+	fc.out.CmpRegToReg("rdx", "rcx")
+	scanDoneJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpGreaterOrEqual, 0)
+	scanDoneEnd := fc.eb.text.Len()
+	fc.out.MovMemToXmm("xmm2", "rsi", 0)
+	fc.out.Ucomisd("xmm2", "xmm0")
+	scanNaNJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpParity, 0)
+	scanNaNEnd := fc.eb.text.Len()
+	scanFoundJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpEqual, 0)
+	scanFoundEnd := fc.eb.text.Len()
+	scanNextPos := fc.eb.text.Len()
+	fc.patchJumpImmediate(scanNaNJump+2, int32(scanNextPos-scanNaNEnd))
+	fc.out.AddImmToReg("rsi", 16)
+	fc.out.IncReg("rdx")
+	fc.out.JumpUnconditional(int32(scanStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	// Key already present: overwrite the value in place, no lock needed
+	scanFoundPos := fc.eb.text.Len()
+	fc.patchJumpImmediate(scanFoundJump+2, int32(scanFoundPos-scanFoundEnd))
+	fc.out.MovXmmToMem("xmm1", "rsi", 8)
+	foundDoneJump := fc.eb.text.Len()
+	fc.out.JumpUnconditional(0)
+	foundDoneEnd := fc.eb.text.Len()
+
+	// Key missing: acquire the insert lock
+	lockPos := fc.eb.text.Len()
+	fc.patchJumpImmediate(scanDoneJump+2, int32(lockPos-scanDoneEnd))
+	fc.out.MovImmToReg("rax", "1")
+	fc.out.Emit([]byte{0x48, 0x87, 0x47, 0xf0}) // xchg [rdi-16], rax
+	fc.out.TestRegReg("rax", "rax")
+	lockedJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpEqual, 0)
+	lockedEnd := fc.eb.text.Len()
+	// Spin on a plain load until the lock looks free, then retry the xchg
+	spinPos := fc.eb.text.Len()
+	fc.out.Emit([]byte{0xf3, 0x90})                   // pause
+	fc.out.Emit([]byte{0x48, 0x83, 0x7f, 0xf0, 0x00}) // cmp qword [rdi-16], 0
+	fc.out.JumpConditional(JumpNotEqual, int32(spinPos-(fc.eb.text.Len()+ConditionalJumpSize)))
+	fc.out.JumpUnconditional(int32(lockPos - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	// Locked: scan entries published by other threads since the lock-free scan
+	lockedPos := fc.eb.text.Len()
+	fc.patchJumpImmediate(lockedJump+2, int32(lockedPos-lockedEnd))
+	fc.out.MovMemToXmm("xmm2", "rdi", 0)
+	fc.out.Cvttsd2si("rcx", "xmm2")
+	rescanStart := fc.eb.text.Len()
+	fc.out.CmpRegToReg("rdx", "rcx")
+	appendJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpGreaterOrEqual, 0)
+	appendEnd := fc.eb.text.Len()
+	fc.out.MovMemToXmm("xmm2", "rsi", 0)
+	fc.out.Ucomisd("xmm2", "xmm0")
+	rescanNaNJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpParity, 0)
+	rescanNaNEnd := fc.eb.text.Len()
+	rescanFoundJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpEqual, 0)
+	rescanFoundEnd := fc.eb.text.Len()
+	rescanNextPos := fc.eb.text.Len()
+	fc.patchJumpImmediate(rescanNaNJump+2, int32(rescanNextPos-rescanNaNEnd))
+	fc.out.AddImmToReg("rsi", 16)
+	fc.out.IncReg("rdx")
+	fc.out.JumpUnconditional(int32(rescanStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	// Another thread inserted the key first: overwrite its value
+	rescanFoundPos := fc.eb.text.Len()
+	fc.patchJumpImmediate(rescanFoundJump+2, int32(rescanFoundPos-rescanFoundEnd))
+	fc.out.MovXmmToMem("xmm1", "rsi", 8)
+	unlockJump := fc.eb.text.Len()
+	fc.out.JumpUnconditional(0)
+	unlockEnd := fc.eb.text.Len()
+
+	// Append a new entry if there is room, then publish the new count
+	appendPos := fc.eb.text.Len()
+	fc.patchJumpImmediate(appendJump+2, int32(appendPos-appendEnd))
+	fc.out.MovMemToReg("rax", "rdi", -8) // rax = capacity
+	fc.out.CmpRegToReg("rcx", "rax")
+	fullJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpGreaterOrEqual, 0)
+	fullEnd := fc.eb.text.Len()
+	fc.out.MovXmmToMem("xmm0", "rsi", 0)
+	fc.out.MovXmmToMem("xmm1", "rsi", 8)
+	fc.out.IncReg("rcx")
+	fc.out.Cvtsi2sd("xmm2", "rcx")
+	fc.out.MovXmmToMem("xmm2", "rdi", 0) // x86 stores are ordered: entry is visible before count
+
+	// Release the lock
+	unlockPos := fc.eb.text.Len()
+	fc.patchJumpImmediate(unlockJump+1, int32(unlockPos-unlockEnd))
+	fc.out.MovImmToMem(0, "rdi", -sharedMapHeaderSize)
+
+	donePos := fc.eb.text.Len()
+	fc.patchJumpImmediate(foundDoneJump+1, int32(donePos-foundDoneEnd))
+	fc.out.MovXmmToXmm("xmm0", "xmm1")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+
+	// Capacity exceeded: report on stderr and terminate every thread
+	fullPos := fc.eb.text.Len()
+	fc.patchJumpImmediate(fullJump+2, int32(fullPos-fullEnd))
+	fc.out.MovImmToReg("rdi", "2") // stderr
+	fc.out.LeaSymbolToReg("rsi", "_sharedmap_error_msg")
+	fc.out.MovImmToReg("rdx", fmt.Sprintf("%d", len(sharedMapErrorMsg)))
+	fc.emitBufferedWrite()
+	fc.out.MovImmToReg("rdi", "1")   // exit code 1
+	fc.out.MovImmToReg("rax", "231") // exit_group syscall (exit would only stop this thread)
+	fc.out.Syscall()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestSharedMapInsertAndOverwrite tests that sharedmap behaves like a map keyed by value
+func TestSharedMapInsertAndOverwrite(t *testing.T) {
+	source := `m := sharedmap(4)
+m[30] <- 1
+m[10] <- 2
+m[30] <- 3
+println(#m)
+println(m[30])
+println(m[10])
+`
+	result := compileAndRun(t, source)
+	if !strings.Contains(result, "2\n3\n2\n") {
+		t.Errorf("Expected output to contain '2\\n3\\n2\\n', got: %s", result)
+	}
+}
+
+// TestSharedMapParallelLoop tests that @@ threads can record results keyed by index
+func TestSharedMapParallelLoop(t *testing.T) {
+	source := `results := sharedmap(8)
+4 @ i in 0..<100 {
+    results[i % 5] <- i % 5 * 10
+}
+println(#results)
+println(results[4] + results[1])
+`
+	result := compileAndRun(t, source)
+	if !strings.Contains(result, "5\n50\n") {
+		t.Errorf("Expected output to contain '5\\n50\\n', got: %s", result)
+	}
+}
+
+// TestSharedMapCapacityExceeded tests that inserting past the capacity is reported
+func TestSharedMapCapacityExceeded(t *testing.T) {
+	source := `m := sharedmap(1)
+m[1] <- 1
+m[2] <- 2
+println(m[1])
+`
+	result := compileAndRun(t, source)
+	if !strings.Contains(result, "sharedmap capacity exceeded") {
+		t.Errorf("Expected capacity error, got: %s", result)
+	}
+}
+
+// TestSharedMapScopedToFunction tests that a shared map in one function does
+// not turn a plain map of the same name in another function into a shared one
+func TestSharedMapScopedToFunction(t *testing.T) {
+	source := `h = n -> {
+    m := sharedmap(2)
+    m[n] <- 1
+    #m
+}
+f = n -> {
+    m := {5: 1}
+    @ i in 0..<40 {
+        m[i + n] <- i
+    }
+    #m
+}
+println(f(40))
+`
+	result := compileAndRun(t, source)
+	if !strings.Contains(result, "41\n") || strings.Contains(result, "capacity exceeded") {
+		t.Errorf("Expected output to contain '41\\n', got: %s", result)
+	}
+}
@@ -697,4 +697,104 @@ func (o *Out) movupdXmmToMemX86(xmm, base string, offset int) {
 	}
 }
 
+// MovhpdMemToXmm - Load a double into the high lane of an XMM register, keeping the low lane
+// movhpd xmm, [base+offset]
+func (o *Out) MovhpdMemToXmm(xmm, base string, offset int) {
+	switch o.target.Arch() {
+	case ArchX86_64:
+		o.movhpdX86(0x16, xmm, base, offset)
+	}
+}
+
+// MovhpdXmmToMem - Store the high lane of an XMM register to memory
+// movhpd [base+offset], xmm
+func (o *Out) MovhpdXmmToMem(xmm, base string, offset int) {
+	switch o.target.Arch() {
+	case ArchX86_64:
+		o.movhpdX86(0x17, xmm, base, offset)
+	}
+}
+
+func (o *Out) movhpdX86(opcode uint8, xmm, base string, offset int) {
+	if VerboseMode {
+		fmt.Fprintf(os.Stderr, "movhpd %s, [%s+%d] (0f %02x): ", xmm, base, offset, opcode)
+	}
+	var xmmNum int
+	fmt.Sscanf(xmm, "xmm%d", &xmmNum)
+	baseReg, _ := GetRegister(o.target.Arch(), base)
+
+	// 66 prefix for packed double
+	o.Write(0x66)
+
+	// REX prefix only if needed (for extended registers)
+	if xmmNum >= 8 || baseReg.Encoding >= 8 {
+		rex := uint8(0x40)
+		if xmmNum >= 8 {
+			rex |= 0x04 // REX.R
+		}
+		if baseReg.Encoding >= 8 {
+			rex |= 0x01 // REX.B
+		}
+		o.Write(rex)
+	}
+
+	// 0F 16 - MOVHPD xmm, m64 / 0F 17 - MOVHPD m64, xmm
+	o.Write(0x0F)
+	o.Write(opcode)
+
+	// ModR/M byte
+	if offset == 0 && (baseReg.Encoding&7) != 5 { // rbp/r13 needs displacement
+		modrm := uint8(0x00) | (uint8(xmmNum&7) << 3) | (baseReg.Encoding & 7)
+		o.Write(modrm)
+		if (baseReg.Encoding & 7) == 4 { // rsp/r12 needs SIB
+			o.Write(0x24)
+		}
+	} else if offset < 128 && offset >= -128 {
+		modrm := uint8(0x40) | (uint8(xmmNum&7) << 3) | (baseReg.Encoding & 7)
+		o.Write(modrm)
+		if (baseReg.Encoding & 7) == 4 { // rsp/r12 needs SIB
+			o.Write(0x24)
+		}
+		o.Write(uint8(offset))
+	} else {
+		modrm := uint8(0x80) | (uint8(xmmNum&7) << 3) | (baseReg.Encoding & 7)
+		o.Write(modrm)
+		if (baseReg.Encoding & 7) == 4 { // rsp/r12 needs SIB
+			o.Write(0x24)
+		}
+		o.WriteUnsigned(uint(offset))
+	}
+
+	if VerboseMode {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
 // MovqRegToXmm - Move 64-bit integer from general-purpose register to XMM register
+
+// MinpdXmm - Minimum Packed Double (SSE2)
+// minpd xmm, xmm
+func (o *Out) MinpdXmm(dst, src string) {
+	switch o.target.Arch() {
+	case ArchX86_64:
+		o.sseXmmXmmX86("minpd", 0x66, 0x5D, dst, src)
+	}
+}
+
+// MaxpdXmm - Maximum Packed Double (SSE2)
+// maxpd xmm, xmm
+func (o *Out) MaxpdXmm(dst, src string) {
+	switch o.target.Arch() {
+	case ArchX86_64:
+		o.sseXmmXmmX86("maxpd", 0x66, 0x5F, dst, src)
+	}
+}
+
+// UnpckhpdXmm - Unpack High Packed Double (SSE2): dst = [dst.high, src.high]
+// unpckhpd xmm, xmm
+func (o *Out) UnpckhpdXmm(dst, src string) {
+	switch o.target.Arch() {
+	case ArchX86_64:
+		o.sseXmmXmmX86("unpckhpd", 0x66, 0x15, dst, src)
+	}
+}
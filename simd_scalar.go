@@ -820,3 +820,58 @@ func (o *Out) fxorRISCV(dst, src string) {
 		fmt.Fprintln(os.Stderr)
 	}
 }
+
+// MinsdXmm - Minimum Scalar Double (SSE2)
+// minsd xmm, xmm - returns src if either operand is NaN
+func (o *Out) MinsdXmm(dst, src string) {
+	switch o.target.Arch() {
+	case ArchX86_64:
+		o.sseXmmXmmX86("minsd", 0xF2, 0x5D, dst, src)
+	}
+}
+
+// MaxsdXmm - Maximum Scalar Double (SSE2)
+// maxsd xmm, xmm - returns src if either operand is NaN
+func (o *Out) MaxsdXmm(dst, src string) {
+	switch o.target.Arch() {
+	case ArchX86_64:
+		o.sseXmmXmmX86("maxsd", 0xF2, 0x5F, dst, src)
+	}
+}
+
+// sseXmmXmmX86 emits a register-to-register SSE2 instruction:
+// prefix [REX] 0F opcode ModR/M
+func (o *Out) sseXmmXmmX86(name string, prefix, opcode uint8, dst, src string) {
+	if VerboseMode {
+		fmt.Fprintf(os.Stderr, "%s %s, %s: ", name, dst, src)
+	}
+
+	var dstNum, srcNum int
+	fmt.Sscanf(dst, "xmm%d", &dstNum)
+	fmt.Sscanf(src, "xmm%d", &srcNum)
+
+	o.Write(prefix)
+
+	// REX if needed
+	if dstNum >= 8 || srcNum >= 8 {
+		rex := uint8(0x40)
+		if dstNum >= 8 {
+			rex |= 0x04 // REX.R
+		}
+		if srcNum >= 8 {
+			rex |= 0x01 // REX.B
+		}
+		o.Write(rex)
+	}
+
+	o.Write(0x0F)
+	o.Write(opcode)
+
+	// ModR/M
+	modrm := uint8(0xC0) | (uint8(dstNum&7) << 3) | uint8(srcNum&7)
+	o.Write(modrm)
+
+	if VerboseMode {
+		fmt.Fprintln(os.Stderr)
+	}
+}
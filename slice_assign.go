@@ -0,0 +1,246 @@
+// Completion: 100% - Slice assignment for mutable lists
+package main
+
+import "strconv"
+
+// slice_assign.go - Write through a slice: xs[2:5] <- other
+//
+// The destination range uses the same start, end and step rules as reading
+// a slice, so xs[::2] <- ys writes every other element and xs[::-1] <- ys
+// writes ys back to front. The list on the right must have exactly as many
+// elements as the range selects; a mismatch or a range that reaches outside
+// the list stops the program with an error instead of growing or
+// truncating it. Elements are copied in place, keys are left untouched.
+
+const (
+	sliceAssignLengthMsg = "ERROR: Slice assignment length mismatch (list size differs from slice size)\n"
+	sliceAssignBoundsMsg = "ERROR: Slice assignment out of bounds (slice reaches outside the list)\n"
+)
+
+// sliceBoundUnset marks a start or end left out of the slice, so the runtime
+// helper can pick the default that fits the sign of the step
+const sliceBoundUnset = "-9223372036854775808"
+
+// compileSliceUpdate compiles xs[start:end:step] <- value
+func (fc *C67Compiler) compileSliceUpdate(s *SliceUpdateStmt) {
+	ident, ok := s.Target.List.(*IdentExpr)
+	if !ok {
+		compilerError("slice assignment needs a list variable on the left of '<-', got %s", s.Target.List)
+	}
+	if !fc.mutableVars[ident.Name] && !fc.globalVarsMutable[ident.Name] {
+		_, isLocal := fc.variables[ident.Name]
+		_, isGlobal := fc.globalVars[ident.Name]
+		if isLocal || isGlobal {
+			compilerError("cannot modify immutable list '%s'", ident.Name)
+		}
+	}
+	if fc.varTypes[ident.Name] == "map" {
+		compilerError("slice assignment needs a list, but '%s' is a map", ident.Name)
+	}
+	if _, isNumber := s.Value.(*NumberExpr); isNumber {
+		compilerError("slice assignment needs a list on the right of '<-', got %s", s.Value)
+	}
+
+	// Copy the source first when it is the destination itself, so that
+	// xs[::-1] <- xs reverses instead of reading elements already overwritten
+	value := s.Value
+	if src, ok := value.(*IdentExpr); ok && src.Name == ident.Name {
+		value = &SliceExpr{List: src}
+	}
+
+	// Stack layout once everything is evaluated (rsp points to end):
+	// [source_ptr][list_ptr][step][start][end], all as int64
+	fc.compileExpression(value)
+	fc.out.SubImmFromReg("rsp", StackSlotSize)
+	fc.out.MovXmmToMem("xmm0", "rsp", 0)
+
+	fc.compileExpression(ident)
+	fc.out.SubImmFromReg("rsp", StackSlotSize)
+	fc.out.MovXmmToMem("xmm0", "rsp", 0)
+
+	if s.Target.Step != nil {
+		fc.compileExpression(s.Target.Step)
+		fc.out.Cvttsd2si("rax", "xmm0")
+	} else {
+		fc.out.MovImmToReg("rax", "1")
+	}
+	fc.out.SubImmFromReg("rsp", StackSlotSize)
+	fc.out.MovRegToMem("rax", "rsp", 0)
+
+	for _, bound := range []Expression{s.Target.Start, s.Target.End} {
+		if bound != nil {
+			fc.compileExpression(bound)
+			fc.out.Cvttsd2si("rax", "xmm0")
+		} else {
+			fc.out.MovImmToReg("rax", sliceBoundUnset)
+		}
+		fc.out.SubImmFromReg("rsp", StackSlotSize)
+		fc.out.MovRegToMem("rax", "rsp", 0)
+	}
+
+	// _c67_slice_assign(rdi=list_ptr, rsi=start, rdx=end, rcx=step, r8=source_ptr)
+	fc.out.MovMemToReg("rdx", "rsp", 0)
+	fc.out.MovMemToReg("rsi", "rsp", 8)
+	fc.out.MovMemToReg("rcx", "rsp", 16)
+	fc.out.MovMemToReg("rdi", "rsp", 24)
+	fc.out.MovMemToReg("r8", "rsp", 32)
+	fc.out.AddImmToReg("rsp", 5*StackSlotSize)
+
+	fc.usesSliceAssign = true
+	fc.trackFunctionCall("_c67_slice_assign")
+	fc.eb.GenerateCallInstruction("_c67_slice_assign")
+}
+
+// generateSliceAssignHelper emits _c67_slice_assign, which copies the
+// elements of the source list into list[start:end:step]
+func (fc *C67Compiler) generateSliceAssignHelper() {
+	fc.eb.MarkFunction("_c67_slice_assign")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+	fc.out.PushReg("r15")
+	fc.out.SubImmFromReg("rsp", StackSlotSize) // Align for the error path calls
+
+	fc.out.MovRegToReg("r12", "rdi") // r12 = destination list
+	fc.out.MovRegToReg("r13", "rsi") // r13 = start
+	fc.out.MovRegToReg("r14", "rdx") // r14 = end
+	fc.out.MovRegToReg("r9", "rcx")  // r9 = step
+	fc.out.MovRegToReg("rbx", "r8")  // rbx = source list
+
+	fc.out.MovMemToXmm("xmm0", "r12", 0)
+	fc.out.Cvttsd2si("r10", "xmm0") // r10 = destination length
+
+	// Fill in left-out bounds: 0 and length counting up, length-1 and -1 counting down
+	fc.out.MovImmToReg("rax", sliceBoundUnset)
+	fc.out.CmpRegToReg("r13", "rax")
+	startSet := fc.forwardJump(JumpNotEqual)
+	fc.out.XorRegWithReg("r13", "r13")
+	fc.out.CmpRegToImm("r9", 0)
+	startUp := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovRegToReg("r13", "r10")
+	fc.out.SubImmFromReg("r13", 1)
+	fc.landForwardJump(startUp)
+	fc.landForwardJump(startSet)
+
+	fc.out.CmpRegToReg("r14", "rax")
+	endSet := fc.forwardJump(JumpNotEqual)
+	fc.out.MovRegToReg("r14", "r10")
+	fc.out.CmpRegToImm("r9", 0)
+	endUp := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovImmToReg("r14", "-1")
+	fc.landForwardJump(endUp)
+	fc.landForwardJump(endSet)
+
+	// Element count, as for reading a slice:
+	// step > 0: (end - start + step - 1) / step
+	// step < 0: (start - end - step - 1) / -step
+	fc.out.CmpRegToImm("r9", 0)
+	stepDown := fc.forwardJump(JumpLess)
+	fc.out.MovRegToReg("rax", "r14")
+	fc.out.SubRegFromReg("rax", "r13")
+	fc.out.AddRegToReg("rax", "r9")
+	fc.out.SubImmFromReg("rax", 1)
+	fc.out.MovRegToReg("rcx", "r9")
+	counted := fc.forwardJumpAlways()
+	fc.landForwardJump(stepDown)
+	fc.out.MovRegToReg("rax", "r13")
+	fc.out.SubRegFromReg("rax", "r14")
+	fc.out.SubRegFromReg("rax", "r9")
+	fc.out.SubImmFromReg("rax", 1)
+	fc.out.MovRegToReg("rcx", "r9")
+	fc.out.NegReg("rcx")
+	fc.landForwardJump(counted)
+	fc.out.Emit([]byte{0x48, 0x99})       // cqo
+	fc.out.Emit([]byte{0x48, 0xF7, 0xF9}) // idiv rcx
+	fc.out.MovRegToReg("r15", "rax")      // r15 = element count
+	fc.out.CmpRegToImm("r15", 0)
+	countPositive := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.XorRegWithReg("r15", "r15")
+	fc.landForwardJump(countPositive)
+
+	// The source must fill the range exactly
+	fc.out.MovMemToXmm("xmm0", "rbx", 0)
+	fc.out.Cvttsd2si("rax", "xmm0")
+	fc.out.CmpRegToReg("rax", "r15")
+	lengthOk := fc.forwardJump(JumpEqual)
+	fc.emitRuntimeAbort("_slice_assign_length_msg", sliceAssignLengthMsg)
+	fc.landForwardJump(lengthOk)
+
+	fc.out.TestRegReg("r15", "r15")
+	empty := fc.forwardJump(JumpEqual)
+
+	// First and last destination index must both be inside the list
+	fc.out.MovRegToReg("rax", "r15")
+	fc.out.SubImmFromReg("rax", 1)
+	fc.out.ImulRegWithReg("rax", "r9")
+	fc.out.AddRegToReg("rax", "r13") // rax = start + (count-1)*step
+	var outside []int
+	for _, index := range []string{"r13", "rax"} {
+		fc.out.CmpRegToImm(index, 0)
+		outside = append(outside, fc.forwardJump(JumpLess))
+		fc.out.CmpRegToReg(index, "r10")
+		outside = append(outside, fc.forwardJump(JumpGreaterOrEqual))
+	}
+	inside := fc.forwardJumpAlways()
+	for _, pos := range outside {
+		fc.landForwardJump(pos)
+	}
+	fc.emitRuntimeAbort("_slice_assign_bounds_msg", sliceAssignBoundsMsg)
+	fc.landForwardJump(inside)
+
+	// Copy values: list[start + i*step] = source[i]
+	fc.out.XorRegWithReg("rcx", "rcx") // rcx = i
+	fc.out.MovRegToReg("rdx", "r13")   // rdx = destination index
+	loopStart := fc.eb.text.Len()
+	fc.out.CmpRegToReg("rcx", "r15")
+	done := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovRegToReg("rax", "rcx")
+	fc.out.ShlImmReg("rax", 4)
+	fc.out.AddRegToReg("rax", "rbx")
+	fc.out.MovMemToXmm("xmm0", "rax", 16) // source value i
+	fc.out.MovRegToReg("rax", "rdx")
+	fc.out.ShlImmReg("rax", 4)
+	fc.out.AddRegToReg("rax", "r12")
+	fc.out.MovXmmToMem("xmm0", "rax", 16) // destination value
+	fc.out.IncReg("rcx")
+	fc.out.AddRegToReg("rdx", "r9")
+	fc.out.JumpUnconditional(int32(loopStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(done)
+	fc.landForwardJump(empty)
+
+	fc.out.AddImmToReg("rsp", StackSlotSize)
+	fc.out.PopReg("r15")
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// emitRuntimeAbort prints a fixed message to stderr and exits with status 1.
+// Runtime helpers are generated after the PLT is laid out, so on Linux this
+// writes and exits with syscalls instead of calling into libc.
+func (fc *C67Compiler) emitRuntimeAbort(msgLabel, msg string) {
+	if fc.eb.target.OS() != OSLinux {
+		fc.out.LeaSymbolToReg("rdi", msgLabel)
+		fc.out.XorRegWithReg("rax", "rax") // AL=0 for variadic function
+		fc.trackFunctionCall("printf")
+		fc.eb.GenerateCallInstruction("printf")
+		fc.out.MovImmToReg("rdi", "1")
+		fc.trackFunctionCall("exit")
+		fc.eb.GenerateCallInstruction("exit")
+		return
+	}
+	fc.out.MovImmToReg("rdi", "2") // stderr
+	fc.out.LeaSymbolToReg("rsi", msgLabel)
+	fc.out.MovImmToReg("rdx", strconv.Itoa(len(msg)))
+	fc.emitBufferedWrite()
+	fc.out.MovImmToReg("rdi", "1")
+	fc.out.MovImmToReg("rax", "231") // exit_group
+	fc.out.Syscall()
+}
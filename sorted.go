@@ -0,0 +1,152 @@
+// Completion: 100% - bsearch() and sortedinsert() for sorted lists
+package main
+
+// sorted.go - Sorted lists
+//
+//	bsearch(xs, v)       index of the first value >= v, #xs if there is none
+//	sortedinsert(xs, v)  new list with v after any values <= v
+//
+// xs must be sorted in ascending order. bsearch returns where v is or
+// would go, so xs[bsearch(xs, v)] == v tells whether v is present.
+// sortedinsert keeps equal values in insertion order and, like append,
+// leaves xs unchanged. Both take O(log n) comparisons; sortedinsert also
+// copies the list.
+
+// compileSortedCall compiles bsearch() and sortedinsert()
+func (fc *C67Compiler) compileSortedCall(call *CallExpr) {
+	if len(call.Args) != 2 {
+		compilerError("%s() requires exactly 2 arguments (sorted list, value)", call.Function)
+	}
+	fc.usesSorted = true
+
+	fc.compileExpression(call.Args[0])
+	fc.out.SubImmFromReg("rsp", 16)
+	fc.out.MovXmmToMem("xmm0", "rsp", 0)
+	fc.compileExpression(call.Args[1])
+	fc.out.MovMemToReg("rdi", "rsp", 0)
+	if call.Function == "bsearch" {
+		fc.out.XorRegWithReg("rsi", "rsi")
+		fc.out.CallSymbol("_c67_bsearch")
+		fc.out.Cvtsi2sd("xmm0", "rax")
+	} else {
+		fc.out.CallSymbol("_c67_sortedinsert")
+		fc.out.MovqRegToXmm("xmm0", "rax")
+	}
+	fc.out.AddImmToReg("rsp", 16)
+}
+
+// generateSortedHelpers emits the helpers behind bsearch() and sortedinsert()
+func (fc *C67Compiler) generateSortedHelpers() {
+	fc.generateBsearch()
+	fc.generateSortedInsert()
+}
+
+// generateBsearch emits _c67_bsearch(rdi=list, xmm0=v, rsi=0 for the first
+// value >= v, 1 for the first value > v) -> rax. Leaf function; clobbers
+// rcx, rdx, r8 and xmm1.
+func (fc *C67Compiler) generateBsearch() {
+	fc.eb.MarkFunction("_c67_bsearch")
+
+	fc.out.XorRegWithReg("rax", "rax") // rax = lo
+	fc.out.MovMemToXmm("xmm1", "rdi", 0)
+	fc.out.Cvttsd2si("rdx", "xmm1") // rdx = hi
+	loop := fc.eb.text.Len()
+	fc.out.CmpRegToReg("rax", "rdx")
+	done := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovRegToReg("rcx", "rax")
+	fc.out.AddRegToReg("rcx", "rdx")
+	fc.out.ShrRegByImm("rcx", 1) // rcx = mid
+	fc.out.MovRegToReg("r8", "rcx")
+	fc.out.ShlImmReg("r8", 4)
+	fc.out.AddRegToReg("r8", "rdi")
+	fc.out.MovMemToXmm("xmm1", "r8", 16)
+	fc.out.TestRegReg("rsi", "rsi")
+	upper := fc.forwardJump(JumpNotEqual)
+	fc.out.Ucomisd("xmm0", "xmm1")
+	goRight := []int{fc.forwardJump(JumpAbove)}
+	goLeft := fc.forwardJumpAlways()
+	fc.landForwardJump(upper)
+	fc.out.Ucomisd("xmm0", "xmm1")
+	goRight = append(goRight, fc.forwardJump(JumpAboveOrEqual))
+	fc.landForwardJump(goLeft)
+	fc.out.MovRegToReg("rdx", "rcx") // hi = mid
+	fc.out.JumpUnconditional(int32(loop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	for _, pos := range goRight {
+		fc.landForwardJump(pos)
+	}
+	fc.out.MovRegToReg("rax", "rcx") // lo = mid + 1
+	fc.out.IncReg("rax")
+	fc.out.JumpUnconditional(int32(loop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(done)
+	fc.out.Ret()
+}
+
+// generateSortedInsert emits _c67_sortedinsert(rdi=list, xmm0=v) -> rax,
+// a new list with v inserted after the values <= v
+func (fc *C67Compiler) generateSortedInsert() {
+	fc.eb.MarkFunction("_c67_sortedinsert")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+	fc.out.SubImmFromReg("rsp", 16)
+	fc.out.MovXmmToMem("xmm0", "rsp", 0) // [rsp] = v
+	fc.out.MovRegToReg("rbx", "rdi")     // rbx = list
+	fc.out.MovMemToXmm("xmm1", "rbx", 0)
+	fc.out.Cvttsd2si("r12", "xmm1") // r12 = count
+
+	fc.out.MovImmToReg("rsi", "1")
+	fc.out.CallSymbol("_c67_bsearch")
+	fc.out.MovRegToReg("r13", "rax") // r13 = where v goes
+
+	// 8 + (count + 1) * 16 bytes
+	fc.out.MovRegToReg("rdi", "r12")
+	fc.out.IncReg("rdi")
+	fc.out.ShlImmReg("rdi", 4)
+	fc.out.AddImmToReg("rdi", 8)
+	fc.callArenaAlloc()
+	fc.out.MovRegToReg("r14", "rax") // r14 = new list
+	fc.out.MovRegToReg("rax", "r12")
+	fc.out.IncReg("rax")
+	fc.out.Cvtsi2sd("xmm0", "rax")
+	fc.out.MovXmmToMem("xmm0", "r14", 0)
+
+	// Entry i of the new list: old[i] before r13, v at r13, old[i - 1] after
+	fc.out.XorRegWithReg("rcx", "rcx")
+	loop := fc.eb.text.Len()
+	fc.out.CmpRegToReg("rcx", "r12")
+	done := fc.forwardJump(JumpGreater)
+	fc.out.MovRegToReg("rdx", "rcx")
+	fc.out.CmpRegToReg("rcx", "r13")
+	before := fc.forwardJump(JumpLess)
+	atInsert := fc.forwardJump(JumpEqual)
+	fc.out.DecReg("rdx")
+	fc.landForwardJump(before)
+	fc.out.ShlImmReg("rdx", 4)
+	fc.out.AddRegToReg("rdx", "rbx")
+	fc.out.MovMemToXmm("xmm0", "rdx", 16)
+	stored := fc.forwardJumpAlways()
+	fc.landForwardJump(atInsert)
+	fc.out.MovMemToXmm("xmm0", "rsp", 0)
+	fc.landForwardJump(stored)
+	fc.out.MovRegToReg("rdx", "rcx")
+	fc.out.ShlImmReg("rdx", 4)
+	fc.out.AddRegToReg("rdx", "r14")
+	fc.out.MovRegToMem("rcx", "rdx", 8)
+	fc.out.MovXmmToMem("xmm0", "rdx", 16)
+	fc.out.IncReg("rcx")
+	fc.out.JumpUnconditional(int32(loop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(done)
+
+	fc.out.MovRegToReg("rax", "r14")
+	fc.out.LeaMemToReg("rsp", "rbp", -32)
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
@@ -0,0 +1,58 @@
+// Completion: 100% - Stack frame size check
+package main
+
+// stackframe.go - A compile-time bound on the stack a function needs
+//
+// Every function gets a frame when it is called: a fixed part that its
+// prologue reserves, the top level reserves room for every variable it
+// collected and a lambda a fixed area for its parameters, captured variables
+// and temporaries, and a part that grows at runtime as variables are defined,
+// loops set up their counters and send/receive their buffers. Nothing checks
+// the stack pointer at runtime, so a frame deeper than the stack of the thread
+// that runs it writes over whatever lies below.
+//
+// While a function is compiled, growRuntimeStack and updateStackOffset record
+// the deepest either part gets. checkStackFrame then takes the larger of the
+// fixed part plus the runtime part and the deepest variable offset as a
+// conservative bound, and stops with an error when it is over StackSizeLimit.
+// Large data belongs in an arena block, which is not on the stack. The bound
+// is for one call: recursion multiplies it, which the recursion max limits.
+
+// DefaultStackSize is the stack size that frames are checked against unless
+// --stack-size says otherwise, the stack of the threads of a parallel loop
+const DefaultStackSize = 1024 * 1024
+
+// StackSizeLimit is the largest frame a function may need, from --stack-size.
+// 0 turns the check off.
+var StackSizeLimit int64 = DefaultStackSize
+
+// growRuntimeStack records that n more bytes were taken from the stack
+// with a sub from rsp
+func (fc *C67Compiler) growRuntimeStack(n int) {
+	fc.runtimeStack += n
+	if fc.runtimeStack > fc.runtimeStackPeak {
+		fc.runtimeStackPeak = fc.runtimeStack
+	}
+}
+
+// startStackFrame begins measuring the frame of a function
+func (fc *C67Compiler) startStackFrame() {
+	fc.runtimeStackPeak = fc.runtimeStack
+	fc.stackOffsetPeak = fc.stackOffset
+}
+
+// checkStackFrame stops compilation when the frame of the function name,
+// with fixed bytes reserved by its prologue, can be deeper than the stack
+func (fc *C67Compiler) checkStackFrame(name string, fixed int) {
+	bound := max(fixed+fc.runtimeStackPeak, fc.stackOffsetPeak)
+	if StackSizeLimit <= 0 || int64(bound) <= StackSizeLimit {
+		return
+	}
+	what := "the top level of the program"
+	if name != "" {
+		what = "function " + name
+	}
+	compilerError("%s needs up to %d bytes of stack, more than the stack size of %d bytes: "+
+		"keep large or many values in an arena block instead of in local variables, "+
+		"or raise the limit with --stack-size", what, bound, StackSizeLimit)
+}
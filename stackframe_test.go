@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestStackFrameLimit tests that a function whose frame can be deeper than
+// the stack size is rejected at compile time, and compiles under a larger one
+func TestStackFrameLimit(t *testing.T) {
+	saved := StackSizeLimit
+	defer func() { StackSizeLimit = saved }()
+
+	source := `sum4 = x -> {
+    a := x + 1
+    b := a + 1
+    c := b + 1
+    c + 1
+}
+main = {
+    println(sum4(1))
+}
+`
+	StackSizeLimit = 4096
+	_, err := compileTestCodeAllowError(t, source)
+	if err == nil {
+		t.Fatal("Expected a stack frame error")
+	}
+	for _, want := range []string{"function sum4 needs up to", "stack size of 4096 bytes", "arena"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in the error, got: %v", want, err)
+		}
+	}
+
+	StackSizeLimit = DefaultStackSize
+	if output := compileAndRun(t, source); output != "5\n" {
+		t.Errorf("Expected 5, got %q", output)
+	}
+}
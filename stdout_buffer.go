@@ -0,0 +1,274 @@
+// Completion: 100% - Buffered stdout writer for Linux
+package main
+
+// stdout_buffer.go - Buffered, locale-independent output runtime
+//
+// print, println, printf and the error printers write through _c67_write
+// instead of issuing one write(2) per fragment or calling libc printf.
+// Writes to stdout collect in a per-process buffer that is flushed when a
+// newline is written, when the buffer fills up and before the program exits,
+// so interactive output still appears line by line. Writes to any other file
+// descriptor flush stdout first and then go straight to write(2), which keeps
+// stdout and stderr in order. print and println of a short string literal
+// store it into the buffer inline instead of calling _c67_write.
+//
+// Layout of _c67_stdout (one writable symbol):
+//
+//	[0]   spinlock (0 = free), taken by @@ worker threads that print
+//	[8]   number of buffered bytes
+//	[16]  stdoutBufferSize bytes of data
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+const (
+	stdoutBufferSize   = 4096
+	stdoutLockOffset   = 0
+	stdoutLengthOffset = 8
+	stdoutDataOffset   = 16
+)
+
+// stdoutInlineMax is the longest string literal that print and println copy
+// into the stdout buffer at the call site instead of calling _c67_write
+const stdoutInlineMax = 32
+
+// generateStdoutBuffer generates _c67_write, _c67_flush and their subroutines.
+// _c67_stdout is defined with the other writable data in Compile.
+func (fc *C67Compiler) generateStdoutBuffer() {
+	if fc.eb.target.OS() != OSLinux {
+		return
+	}
+	fc.generateWriteAll()
+	fc.generateStdoutFlushLocked()
+	fc.generateStdoutFlush()
+	fc.generateBufferedWrite()
+}
+
+// emitBufferedWrite replaces a write(2) syscall: rdi = fd, rsi = buffer,
+// rdx = length. Only rax changes (to the number of bytes written).
+func (fc *C67Compiler) emitBufferedWrite() {
+	if fc.eb.target.OS() != OSLinux {
+		fc.out.MovImmToReg("rax", "1") // sys_write
+		fc.out.Syscall()
+		return
+	}
+	fc.trackFunctionCall("_c67_write")
+	fc.eb.GenerateCallInstruction("_c67_write")
+}
+
+// emitConstantWrite writes data, defined at label, to stdout. Up to
+// stdoutInlineMax bytes are stored straight into the buffer with immediate
+// moves, in 8-byte words, as long as the buffer has room for the words;
+// anything else goes through _c67_write. Clobbers rax, rdi, rsi and rdx.
+func (fc *C67Compiler) emitConstantWrite(label, data string) {
+	if fc.eb.target.OS() != OSLinux || len(data) > stdoutInlineMax {
+		fc.out.MovImmToReg("rdi", "1") // stdout
+		fc.out.LeaSymbolToReg("rsi", label)
+		fc.out.MovImmToReg("rdx", strconv.Itoa(len(data)))
+		fc.emitBufferedWrite()
+		return
+	}
+	words := (len(data) + 7) / 8
+
+	fc.out.PushReg("r8")
+	fc.out.PushReg("rcx")
+	fc.out.PushReg("r11")
+	fc.out.LeaSymbolToReg("r8", "_c67_stdout")
+	fc.emitStdoutLock()
+	fc.out.MovMemToReg("rdi", "r8", stdoutLengthOffset)
+	fc.out.CmpRegToImm("rdi", int64(stdoutBufferSize-8*words))
+	full := fc.forwardJump(JumpAbove)
+	fc.out.AddRegToReg("rdi", "r8")
+	for i := 0; i < words; i++ {
+		word := make([]byte, 8)
+		copy(word, data[8*i:])
+		fc.out.MovImmToReg("rax", strconv.FormatUint(binary.LittleEndian.Uint64(word), 10))
+		fc.out.MovRegToMem("rax", "rdi", stdoutDataOffset+8*i)
+	}
+	fc.out.MovMemToReg("rax", "r8", stdoutLengthOffset)
+	fc.out.AddImmToReg("rax", int64(len(data)))
+	fc.out.MovRegToMem("rax", "r8", stdoutLengthOffset)
+	if strings.Contains(data, "\n") {
+		fc.out.CallSymbol("_c67_stdout_flush_locked")
+	}
+	fc.emitStdoutUnlock()
+	done := fc.forwardJumpAlways()
+
+	fc.landForwardJump(full)
+	fc.emitStdoutUnlock()
+	fc.out.MovImmToReg("rdi", "1") // stdout
+	fc.out.LeaSymbolToReg("rsi", label)
+	fc.out.MovImmToReg("rdx", strconv.Itoa(len(data)))
+	fc.emitBufferedWrite()
+	fc.landForwardJump(done)
+	fc.out.PopReg("r11")
+	fc.out.PopReg("rcx")
+	fc.out.PopReg("r8")
+}
+
+// emitFlushStdout writes out any buffered stdout data; preserves all registers
+func (fc *C67Compiler) emitFlushStdout() {
+	if fc.eb.target.OS() != OSLinux {
+		return
+	}
+	fc.trackFunctionCall("_c67_flush")
+	fc.eb.GenerateCallInstruction("_c67_flush")
+}
+
+// emitStdoutLock spins until the stdout lock at [r8] is taken
+func (fc *C67Compiler) emitStdoutLock() {
+	spin := fc.eb.text.Len()
+	fc.out.MovImmToReg("rax", "1")
+	fc.out.Emit([]byte{0x49, 0x87, 0x00}) // xchg [r8], rax
+	fc.out.TestRegReg("rax", "rax")
+	acquired := fc.forwardJump(JumpEqual)
+	fc.out.Emit([]byte{0xf3, 0x90}) // pause
+	fc.out.JumpUnconditional(int32(spin - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(acquired)
+}
+
+// emitStdoutUnlock releases the stdout lock at [r8]
+func (fc *C67Compiler) emitStdoutUnlock() {
+	fc.out.XorRegWithReg("rax", "rax")
+	fc.out.MovRegToMem("rax", "r8", stdoutLockOffset)
+}
+
+// generateWriteAll generates _c67_write_all(rdi = fd, rsi = buffer, rdx = length),
+// which repeats write(2) until everything is written or an error occurs.
+// Clobbers rax, rcx, rsi, rdx and r11.
+func (fc *C67Compiler) generateWriteAll() {
+	fc.eb.MarkFunction("_c67_write_all")
+	loop := fc.eb.text.Len()
+	fc.out.TestRegReg("rdx", "rdx")
+	finished := fc.forwardJump(JumpLessOrEqual)
+	fc.out.MovImmToReg("rax", "1") // sys_write
+	fc.out.Syscall()
+	fc.out.TestRegReg("rax", "rax")
+	failed := fc.forwardJump(JumpLessOrEqual)
+	fc.out.AddRegToReg("rsi", "rax")
+	fc.out.SubRegFromReg("rdx", "rax")
+	fc.out.JumpUnconditional(int32(loop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(finished)
+	fc.landForwardJump(failed)
+	fc.out.Ret()
+}
+
+// generateStdoutFlushLocked generates _c67_stdout_flush_locked: writes the
+// buffer at r8 (lock held) and empties it. Clobbers rax, rcx and r11.
+func (fc *C67Compiler) generateStdoutFlushLocked() {
+	fc.eb.MarkFunction("_c67_stdout_flush_locked")
+	fc.out.PushReg("rdi")
+	fc.out.PushReg("rsi")
+	fc.out.PushReg("rdx")
+	fc.out.MovImmToReg("rdi", "1") // stdout
+	fc.out.LeaMemToReg("rsi", "r8", stdoutDataOffset)
+	fc.out.MovMemToReg("rdx", "r8", stdoutLengthOffset)
+	fc.out.CallSymbol("_c67_write_all")
+	fc.out.XorRegWithReg("rax", "rax")
+	fc.out.MovRegToMem("rax", "r8", stdoutLengthOffset)
+	fc.out.PopReg("rdx")
+	fc.out.PopReg("rsi")
+	fc.out.PopReg("rdi")
+	fc.out.Ret()
+}
+
+// generateStdoutFlush generates _c67_flush(), which preserves all registers
+func (fc *C67Compiler) generateStdoutFlush() {
+	fc.eb.MarkFunction("_c67_flush")
+	fc.out.PushReg("rax")
+	fc.out.PushReg("rcx")
+	fc.out.PushReg("r8")
+	fc.out.PushReg("r11")
+	fc.out.LeaSymbolToReg("r8", "_c67_stdout")
+	fc.emitStdoutLock()
+	fc.out.CallSymbol("_c67_stdout_flush_locked")
+	fc.emitStdoutUnlock()
+	fc.out.PopReg("r11")
+	fc.out.PopReg("r8")
+	fc.out.PopReg("rcx")
+	fc.out.PopReg("rax")
+	fc.out.Ret()
+}
+
+// generateBufferedWrite generates _c67_write(rdi = fd, rsi = buffer, rdx = length) -> rax.
+// Stdout is buffered and flushed on newline; other descriptors are written
+// directly after flushing stdout. Preserves every register except rax.
+func (fc *C67Compiler) generateBufferedWrite() {
+	fc.eb.MarkFunction("_c67_write")
+	fc.out.PushReg("rcx")
+	fc.out.PushReg("rdi")
+	fc.out.PushReg("rsi")
+	fc.out.PushReg("rdx")
+	fc.out.PushReg("r8")
+	fc.out.PushReg("r9")
+	fc.out.PushReg("r11")
+
+	fc.out.CmpRegToImm("rdi", 1)
+	buffered := fc.forwardJump(JumpEqual)
+
+	// Other descriptors: flush stdout, then a single write(2) as before
+	fc.out.CallSymbol("_c67_flush")
+	fc.out.MovImmToReg("rax", "1") // sys_write
+	fc.out.Syscall()
+	unbufferedDone := fc.forwardJumpAlways()
+
+	fc.landForwardJump(buffered)
+	fc.out.LeaSymbolToReg("r8", "_c67_stdout")
+	fc.emitStdoutLock()
+
+	// Make room; data larger than the whole buffer bypasses it
+	fc.out.MovMemToReg("rax", "r8", stdoutLengthOffset)
+	fc.out.AddRegToReg("rax", "rdx")
+	fc.out.CmpRegToImm("rax", stdoutBufferSize)
+	fits := fc.forwardJump(JumpBelowOrEqual)
+	fc.out.CallSymbol("_c67_stdout_flush_locked")
+	fc.out.CmpRegToImm("rdx", stdoutBufferSize)
+	fitsAfterFlush := fc.forwardJump(JumpBelowOrEqual)
+	fc.out.CallSymbol("_c67_write_all")
+	directDone := fc.forwardJumpAlways()
+	fc.landForwardJump(fits)
+	fc.landForwardJump(fitsAfterFlush)
+
+	// r9 = 1 if the data contains a newline
+	fc.out.XorRegWithReg("r9", "r9")
+	fc.out.TestRegReg("rdx", "rdx")
+	empty := fc.forwardJump(JumpEqual)
+	fc.out.MovRegToReg("rdi", "rsi")
+	fc.out.MovRegToReg("rcx", "rdx")
+	fc.out.MovImmToReg("rax", strconv.Itoa('\n'))
+	fc.out.Emit([]byte{0xf2, 0xae}) // repne scasb
+	noNewline := fc.forwardJump(JumpNotEqual)
+	fc.out.IncReg("r9")
+	fc.landForwardJump(noNewline)
+
+	// Append to the buffer
+	fc.out.LeaMemToReg("rdi", "r8", stdoutDataOffset)
+	fc.out.MovMemToReg("rax", "r8", stdoutLengthOffset)
+	fc.out.AddRegToReg("rdi", "rax")
+	fc.out.MovRegToReg("rcx", "rdx")
+	fc.out.Emit([]byte{0xf3, 0xa4}) // rep movsb
+	fc.out.AddRegToReg("rax", "rdx")
+	fc.out.MovRegToMem("rax", "r8", stdoutLengthOffset)
+	fc.out.TestRegReg("r9", "r9")
+	lineOpen := fc.forwardJump(JumpEqual)
+	fc.out.CallSymbol("_c67_stdout_flush_locked")
+	fc.landForwardJump(lineOpen)
+	fc.landForwardJump(empty)
+	fc.landForwardJump(directDone)
+	fc.emitStdoutUnlock()
+
+	// Report the whole length as written
+	fc.out.MovMemToReg("rax", "rsp", 24) // saved rdx
+	fc.landForwardJump(unbufferedDone)
+	fc.out.PopReg("r11")
+	fc.out.PopReg("r9")
+	fc.out.PopReg("r8")
+	fc.out.PopReg("rdx")
+	fc.out.PopReg("rsi")
+	fc.out.PopReg("rdi")
+	fc.out.PopReg("rcx")
+	fc.out.Ret()
+}
@@ -75,6 +75,20 @@ println("done")
 `,
 			expected: "\ndone\n",
 		},
+		{
+			// Long enough for the AVX2 variant's 4-character blocks plus a tail
+			name: "string_equality",
+			source: `a := "hello, world"
+b := "hello, world"
+c := "hello, worlD"
+d := "hello"
+println(a == b)
+println(a == c)
+println(a == d)
+println(d == "hello")
+`,
+			expected: "1\n0\n0\n1\n",
+		},
 	}
 
 	for _, tt := range tests {
@@ -127,6 +141,203 @@ println(len)
 `,
 			expected: "0\n",
 		},
+		{
+			// 7 keys: AVX2 (4) + SSE2 (2) + scalar (1) paths
+			name: "map_lookup_seven_keys",
+			source: `m = {10: 1, 20: 2, 30: 3, 40: 4, 50: 5, 60: 6, 70: 7}
+println(m[10] + m[20] * 10)
+println(m[40] + m[50] * 10)
+println(m[70])
+println(m[80])
+`,
+			expected: "21\n54\n7\n0\n",
+		},
+		{
+			// 15 keys: AVX-512 (8) + AVX2 (4) + SSE2 (2) + scalar (1) paths
+			name: "map_lookup_fifteen_keys",
+			source: `m = {1: 11, 2: 12, 3: 13, 4: 14, 5: 15, 6: 16, 7: 17, 8: 18, 9: 19, 10: 20, 11: 21, 12: 22, 13: 23, 14: 24, 15: 25}
+println(m[3])
+println(m[8])
+println(m[12])
+println(m[14])
+println(m[15])
+println(m[16])
+`,
+			expected: "13\n18\n22\n24\n25\n0\n",
+		},
+		{
+			// Grows past the initial capacity and index size, then leaves tombstones
+			name: "map_insert_and_delete",
+			source: `m := {}
+@ i in 0..<2000 {
+    m[i * 3] <- i
+}
+@ i in 0..<2000 {
+    i % 2 == 0 {
+        delete(m, i * 3)
+    }
+}
+println(#m)
+println(m[3] + m[5997])
+println(m[6])
+println(delete(m, 6))
+println(delete(m, 9))
+`,
+			expected: "1000\n2000\n0\n0\n1\n",
+		},
+		{
+			name: "map_reinsert_after_delete",
+			source: `m := {1: 10, 2: 20}
+@ i in 0..<500 {
+    m[3] <- i
+    delete(m, 3)
+}
+m[3] <- 30
+m[-0] <- 5
+println(#m)
+println(m[1] + m[2] + m[3] + m[0])
+`,
+			expected: "4\n65\n",
+		},
+		{
+			// Enough keys that the index and entries outgrow the arena
+			// buffer several times, which moves the map
+			name: "map_outgrows_arena",
+			source: `m := {}
+@ i in 0..<40000 {
+    m[i] <- i * 2
+}
+println(#m)
+println(m[0] + m[123] + m[39999])
+`,
+			expected: "40000\n80244\n",
+		},
+		{
+			name: "small_map_grows_an_index",
+			source: `m := {}
+@ i in 0..<8 {
+    m[i] <- i * 10
+}
+delete(m, 0)
+m[8] <- 80
+m[9] <- 90
+println(#m)
+println(m[0] + m[1] + m[7] + m[9])
+`,
+			expected: "9\n170\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := compileAndRun(t, tt.source)
+			if !strings.Contains(result, tt.expected) {
+				t.Errorf("Expected output to contain: %s, got: %s", tt.expected, result)
+			}
+		})
+	}
+}
+
+// TestSymbols tests :name literals, intern() and symbols as map keys
+func TestSymbols(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name: "symbol_equality",
+			source: `println(:red == :red)
+println(:red == :blue)
+`,
+			expected: "1\n0\n",
+		},
+		{
+			name: "symbol_map_keys",
+			source: `colors := {red: 1, :green: 2, blue: 3}
+s := :green
+println(colors[s])
+println(colors.green)
+`,
+			expected: "2\n2\n",
+		},
+		{
+			name: "intern_runtime_string",
+			source: `name := "bl" + "ue"
+println(intern(name) == :blue)
+println(intern("red") == :red)
+colors := {red: 1, blue: 3}
+println(colors[intern(name)])
+`,
+			expected: "1\n1\n3\n",
+		},
+		{
+			name: "hash_computed_field",
+			source: `point := {x: 3, y: 4}
+field := "" + "y"
+println(point[hash(field)])
+println(hash(field) == hash("y"))
+println(hash("y") == :y)
+`,
+			expected: "4\n1\n1\n",
+		},
+		{
+			name: "symbol_dispatch",
+			source: `cmd := intern("st" + "op")
+r := cmd {
+    :start -> 1
+    :stop -> 2
+    ~> 0
+}
+println(r)
+`,
+			expected: "2\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := compileAndRun(t, tt.source)
+			if !strings.Contains(result, tt.expected) {
+				t.Errorf("Expected output to contain: %s, got: %s", tt.expected, result)
+			}
+		})
+	}
+}
+
+// TestParseToml tests parsetoml() on TOML and INI text
+func TestParseToml(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name: "toml_values",
+			source: `cfg := parsetoml("# app\ntitle = \"My \\\"App\\\"\"\nport = 8080\nratio = 0.5 # half\ndebug = true\nports = [80, 443, 'x']\n")
+title: str = cfg.title
+println(title)
+println(cfg.port + cfg.ratio)
+println(cfg.debug)
+ports: list = cfg.ports
+println(#ports)
+println(ports[1])
+`,
+			expected: "My \"App\"\n8080.5\n1\n3\n443\n",
+		},
+		{
+			name: "ini_sections",
+			source: `cfg := parsetoml("; settings\r\n[db]\r\nuser = admin\r\n[web]\r\nport=80\r\n[db]\r\npass = secret\r\n")
+db: map = cfg.db
+user: str = db.user
+pass: str = db.pass
+println(user)
+println(pass)
+println(#user)
+println(#cfg)
+`,
+			expected: "admin\nsecret\n5\n2\n",
+		},
 	}
 
 	for _, tt := range tests {
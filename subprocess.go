@@ -0,0 +1,256 @@
+// Completion: 100% - run() and pipes through external commands
+package main
+
+// subprocess.go - External commands
+//
+// run(cmd) runs cmd with /bin/sh -c and returns what it wrote to stdout as a
+// string. Piping a string into run() feeds it to the command's stdin:
+//
+//	read_file("x.txt") | run("sort") | run("uniq")
+//
+// A chain of run() calls becomes one shell pipeline, (sort) | (uniq), so the
+// commands run at the same time and stream through OS pipes; only the
+// final stdout is collected. The input is written by a separate process, so
+// a command that produces output before it has read all of its input does
+// not block. Stderr is inherited.
+
+// isRunCall reports whether expr is a run(cmd) call
+func isRunCall(expr Expression) bool {
+	call, ok := expr.(*CallExpr)
+	return ok && call.Function == "run" && len(call.Args) == 1
+}
+
+// compileRunPipe compiles input | run(cmd), including the earlier run()
+// stages of the chain, into a single pipeline
+func (fc *C67Compiler) compileRunPipe(expr *PipeExpr) {
+	cmds := []Expression{expr.Right.(*CallExpr).Args[0]}
+	input := expr.Left
+	for {
+		pipe, ok := input.(*PipeExpr)
+		if !ok || !isRunCall(pipe.Right) {
+			break
+		}
+		cmds = append([]Expression{pipe.Right.(*CallExpr).Args[0]}, cmds...)
+		input = pipe.Left
+	}
+	if isRunCall(input) {
+		cmds = append([]Expression{input.(*CallExpr).Args[0]}, cmds...)
+		input = nil
+	}
+	fc.compileRun(cmds, input)
+}
+
+// compileRun runs the commands as a shell pipeline with input (if not nil)
+// on stdin, leaving the output string in xmm0
+func (fc *C67Compiler) compileRun(cmds []Expression, input Expression) {
+	if fc.eb.target.OS() == OSWindows {
+		compilerError("run() is not supported on Windows")
+	}
+	var cmd Expression = cmds[0]
+	if len(cmds) > 1 {
+		cmd = &StringExpr{Value: "("}
+		for i, stage := range cmds {
+			sep := ") | ("
+			if i == 0 {
+				sep = ""
+			}
+			cmd = &BinaryExpr{Left: cmd, Operator: "+", Right: &StringExpr{Value: sep}}
+			cmd = &BinaryExpr{Left: cmd, Operator: "+", Right: stage}
+		}
+		cmd = &BinaryExpr{Left: cmd, Operator: "+", Right: &StringExpr{Value: ")"}}
+	}
+
+	fc.eb.Define("_c67_run_shell", "/bin/sh\x00")
+	fc.eb.Define("_c67_run_sh", "sh\x00")
+	fc.eb.Define("_c67_run_c", "-c\x00")
+	for _, name := range []string{"pipe", "fork", "dup2", "close", "execv", "_exit", "read", "write", "waitpid", "malloc", "realloc", "free", "strlen"} {
+		fc.trackFunctionCall(name)
+	}
+	fc.usesRun = true
+
+	fc.compileExpression(cmd)
+	fc.out.CallSymbol("c67_string_to_cstr")
+	fc.out.SubImmFromReg("rsp", 16)
+	fc.out.MovRegToMem("rax", "rsp", 0)
+	if input != nil {
+		fc.compileExpression(input)
+		fc.out.CallSymbol("c67_string_to_cstr")
+		fc.out.MovRegToReg("rsi", "rax")
+	} else {
+		fc.out.XorRegWithReg("rsi", "rsi")
+	}
+	fc.out.MovMemToReg("rdi", "rsp", 0)
+	fc.out.CallSymbol("_c67_run")
+	fc.out.AddImmToReg("rsp", 16)
+}
+
+// emitRunCall calls the libc function name and sign-extends the int it
+// returns in eax to rax
+func (fc *C67Compiler) emitRunCall(name string) {
+	fc.eb.GenerateCallInstruction(name)
+	fc.out.Emit([]byte{0x48, 0x63, 0xc0}) // movsxd rax, eax
+}
+
+// generateRunHelper emits _c67_run(rdi=command, rsi=input or 0) -> xmm0,
+// the command's stdout as a string
+func (fc *C67Compiler) generateRunHelper() {
+	fc.eb.MarkFunction("_c67_run")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+	fc.out.PushReg("r15")
+	// [rsp] = stdout pipe, [rsp+8] = stdin pipe, [rsp+16] = argv for execv,
+	// [rsp+48] = wait status
+	fc.out.SubImmFromReg("rsp", 56)
+	fc.out.AndRegWithImm("rsp", -16)
+	fc.out.MovRegToReg("r12", "rdi") // r12 = command
+	fc.out.MovRegToReg("r13", "rsi") // r13 = input
+
+	fc.out.MovRegToReg("rdi", "rsp")
+	fc.emitRunCall("pipe")
+	fc.out.TestRegReg("r13", "r13")
+	noStdinPipe := fc.forwardJump(JumpEqual)
+	fc.out.LeaMemToReg("rdi", "rsp", 8)
+	fc.emitRunCall("pipe")
+	fc.landForwardJump(noStdinPipe)
+
+	fc.emitRunCall("fork")
+	fc.out.MovRegToReg("rbx", "rax") // rbx = pid
+	fc.out.TestRegReg("rbx", "rbx")
+	parent := fc.forwardJump(JumpNotEqual)
+
+	// Child: stdin from the input pipe, fed by a writer process of its own
+	fc.out.TestRegReg("r13", "r13")
+	noInput := fc.forwardJump(JumpEqual)
+	fc.emitRunCall("fork")
+	fc.out.TestRegReg("rax", "rax")
+	notWriter := fc.forwardJump(JumpNotEqual)
+	for _, fd := range []int{8, 0, 4} {
+		fc.out.MovU32MemToReg("rdi", "rsp", fd)
+		fc.emitRunCall("close")
+	}
+	fc.out.MovRegToReg("rdi", "r13")
+	fc.eb.GenerateCallInstruction("strlen")
+	fc.out.MovRegToReg("r14", "rax") // r14 = bytes left
+	writeLoop := fc.eb.text.Len()
+	fc.out.TestRegReg("r14", "r14")
+	written := []int{fc.forwardJump(JumpEqual)}
+	fc.out.MovU32MemToReg("rdi", "rsp", 12)
+	fc.out.MovRegToReg("rsi", "r13")
+	fc.out.MovRegToReg("rdx", "r14")
+	fc.eb.GenerateCallInstruction("write")
+	fc.out.TestRegReg("rax", "rax")
+	written = append(written, fc.forwardJump(JumpLessOrEqual))
+	fc.out.AddRegToReg("r13", "rax")
+	fc.out.SubRegFromReg("r14", "rax")
+	fc.out.JumpUnconditional(int32(writeLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	for _, pos := range written {
+		fc.landForwardJump(pos)
+	}
+	fc.out.XorRegWithReg("rdi", "rdi")
+	fc.eb.GenerateCallInstruction("_exit")
+
+	fc.landForwardJump(notWriter)
+	fc.out.MovU32MemToReg("rdi", "rsp", 8)
+	fc.out.XorRegWithReg("rsi", "rsi")
+	fc.emitRunCall("dup2")
+	for _, fd := range []int{8, 12} {
+		fc.out.MovU32MemToReg("rdi", "rsp", fd)
+		fc.emitRunCall("close")
+	}
+	fc.landForwardJump(noInput)
+
+	// Stdout to the output pipe, then exec the shell
+	fc.out.MovU32MemToReg("rdi", "rsp", 4)
+	fc.out.MovImmToReg("rsi", "1")
+	fc.emitRunCall("dup2")
+	for _, fd := range []int{0, 4} {
+		fc.out.MovU32MemToReg("rdi", "rsp", fd)
+		fc.emitRunCall("close")
+	}
+	fc.out.LeaSymbolToReg("rax", "_c67_run_sh")
+	fc.out.MovRegToMem("rax", "rsp", 16)
+	fc.out.LeaSymbolToReg("rax", "_c67_run_c")
+	fc.out.MovRegToMem("rax", "rsp", 24)
+	fc.out.MovRegToMem("r12", "rsp", 32)
+	fc.out.MovImmToMem(0, "rsp", 40)
+	fc.out.LeaSymbolToReg("rdi", "_c67_run_shell")
+	fc.out.LeaMemToReg("rsi", "rsp", 16)
+	fc.emitRunCall("execv")
+	fc.out.MovImmToReg("rdi", "127")
+	fc.eb.GenerateCallInstruction("_exit")
+
+	// Parent: close the child's ends and read its stdout to the end
+	fc.landForwardJump(parent)
+	fc.out.MovU32MemToReg("rdi", "rsp", 4)
+	fc.emitRunCall("close")
+	fc.out.TestRegReg("r13", "r13")
+	noInputPipe := fc.forwardJump(JumpEqual)
+	for _, fd := range []int{8, 12} {
+		fc.out.MovU32MemToReg("rdi", "rsp", fd)
+		fc.emitRunCall("close")
+	}
+	fc.landForwardJump(noInputPipe)
+
+	fc.out.MovImmToReg("r15", "4096") // r15 = capacity
+	fc.out.MovRegToReg("rdi", "r15")
+	fc.eb.GenerateCallInstruction("malloc")
+	fc.out.MovRegToReg("r12", "rax")   // r12 = output buffer
+	fc.out.XorRegWithReg("r14", "r14") // r14 = length
+	readLoop := fc.eb.text.Len()
+	fc.out.MovU32MemToReg("rdi", "rsp", 0)
+	fc.out.MovRegToReg("rsi", "r12")
+	fc.out.AddRegToReg("rsi", "r14")
+	fc.out.MovRegToReg("rdx", "r15")
+	fc.out.SubRegFromReg("rdx", "r14")
+	fc.out.DecReg("rdx") // room for the terminating zero
+	fc.eb.GenerateCallInstruction("read")
+	fc.out.TestRegReg("rax", "rax")
+	readDone := fc.forwardJump(JumpLessOrEqual)
+	fc.out.AddRegToReg("r14", "rax")
+	fc.out.MovRegToReg("rax", "r15")
+	fc.out.SubRegFromReg("rax", "r14")
+	fc.out.CmpRegToImm("rax", 1)
+	fc.out.JumpConditional(JumpGreater, int32(readLoop-(fc.eb.text.Len()+ConditionalJumpSize)))
+	fc.out.ShlImmReg("r15", 1)
+	fc.out.MovRegToReg("rdi", "r12")
+	fc.out.MovRegToReg("rsi", "r15")
+	fc.eb.GenerateCallInstruction("realloc")
+	fc.out.MovRegToReg("r12", "rax")
+	fc.out.JumpUnconditional(int32(readLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(readDone)
+
+	fc.out.MovRegToReg("rax", "r12")
+	fc.out.AddRegToReg("rax", "r14")
+	fc.out.XorRegWithReg("r8", "r8")
+	fc.out.MovU8RegToMem("r8", "rax", 0)
+	fc.out.MovU32MemToReg("rdi", "rsp", 0)
+	fc.emitRunCall("close")
+	fc.out.TestRegReg("rbx", "rbx")
+	forkFailed := fc.forwardJump(JumpLess)
+	fc.out.MovRegToReg("rdi", "rbx")
+	fc.out.LeaMemToReg("rsi", "rsp", 48)
+	fc.out.XorRegWithReg("rdx", "rdx")
+	fc.emitRunCall("waitpid")
+	fc.landForwardJump(forkFailed)
+
+	fc.out.MovRegToReg("rdi", "r12")
+	fc.out.CallSymbol("cstr_to_c67_string")
+	fc.out.MovXmmToMem("xmm0", "rsp", 0)
+	fc.out.MovRegToReg("rdi", "r12")
+	fc.eb.GenerateCallInstruction("free")
+	fc.out.MovMemToXmm("xmm0", "rsp", 0)
+
+	fc.out.LeaMemToReg("rsp", "rbp", -40)
+	fc.out.PopReg("r15")
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
@@ -0,0 +1,44 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// TestRunWriterExit tests that the process that writes the input of run()
+// exits with status 0 once it has written it. The command execs this test
+// binary as TestRunWriterHelper, which is then the parent of the writer and
+// waits for it, since the shell would reap it without a word.
+func TestRunWriterExit(t *testing.T) {
+	testBinary, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	code := `print("a\nb\n" | run("export C67_RUN_WRITER_HELPER=1; exec ` + testBinary + ` -test.run=^TestRunWriterHelper$"))
+`
+	result := compileAndRun(t, code)
+	if want := "writer exited with 0\n"; result != want {
+		t.Errorf("output = %q, want %q", result, want)
+	}
+}
+
+// TestRunWriterHelper reports how the writer of TestRunWriterExit exited
+func TestRunWriterHelper(t *testing.T) {
+	if os.Getenv("C67_RUN_WRITER_HELPER") != "1" {
+		t.Skip("run by TestRunWriterExit")
+	}
+	var status syscall.WaitStatus
+	if _, err := syscall.Wait4(-1, &status, 0, nil); err != nil {
+		fmt.Printf("wait4: %v\n", err)
+	} else if status.Signaled() {
+		fmt.Printf("writer killed by %v\n", status.Signal())
+	} else {
+		fmt.Printf("writer exited with %d\n", status.ExitStatus())
+	}
+	os.Exit(0)
+}
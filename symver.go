@@ -0,0 +1,102 @@
+// Completion: 100% - GNU symbol versions for imported functions
+package main
+
+import (
+	"debug/elf"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// symver.go - Symbol versions for dynamically linked functions
+//
+// glibc and many other libraries keep several versions of a symbol, such
+// as memcpy@GLIBC_2.2.5 and memcpy@@GLIBC_2.14, and the dynamic linker
+// binds an unversioned reference to the oldest one. Like a system linker,
+// the compiler looks up each imported function in the library that
+// provides it on the build machine and records the default version in
+// .gnu.version and .gnu.version_r (DT_VERSYM, DT_VERNEED). The program
+// then gets the implementation it was built against, and a system whose
+// library is too old to have it refuses to start with a "version not
+// found" error instead of misbehaving. Functions from libraries that
+// cannot be read, or that are built for another architecture, are left
+// unversioned.
+
+// symbolVersionCache maps a shared object path to its default symbol versions
+var symbolVersionCache sync.Map
+
+// elfMachines is the ELF machine of each target architecture
+var elfMachines = map[Arch]elf.Machine{
+	ArchX86_64:  elf.EM_X86_64,
+	ArchARM64:   elf.EM_AARCH64,
+	ArchRiscv64: elf.EM_RISCV,
+}
+
+// defaultSymbolVersions returns the default version of every symbol the
+// shared object at path defines, "" for an unversioned one, or nil if the
+// file cannot be read or is for another machine than the target
+func defaultSymbolVersions(path string, machine elf.Machine) map[string]string {
+	if versions, ok := symbolVersionCache.Load(path); ok {
+		return versions.(map[string]string)
+	}
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	if f.Machine != machine {
+		return nil
+	}
+	symbols, err := f.DynamicSymbols()
+	if err != nil {
+		return nil
+	}
+	versions := make(map[string]string)
+	for _, s := range symbols {
+		if s.Section == elf.SHN_UNDEF {
+			continue
+		}
+		if !s.HasVersion || s.VersionIndex.Index() <= 1 {
+			versions[s.Name] = ""
+		} else if !s.VersionIndex.IsHidden() {
+			versions[s.Name] = s.Version
+		}
+	}
+	symbolVersionCache.Store(path, versions)
+	return versions
+}
+
+// addSymbolVersions gives each function the version its library defines
+// it with, taking the libraries in DT_NEEDED order as the dynamic linker does
+func (fc *C67Compiler) addSymbolVersions(ds *DynamicSections, functions []string) {
+	machine, ok := elfMachines[fc.eb.target.Arch()]
+	if !ok {
+		return
+	}
+	pending := make(map[string]bool)
+	for _, fn := range functions {
+		pending[fn] = true
+	}
+	for _, lib := range ds.needed {
+		for _, path := range sharedObjectPaths(lib) {
+			versions := defaultSymbolVersions(path, machine)
+			if versions == nil {
+				continue
+			}
+			for fn := range pending {
+				version, found := versions[fn]
+				if !found {
+					continue
+				}
+				if version != "" {
+					ds.SetSymbolVersion(fn, lib, version)
+					if VerboseMode {
+						fmt.Fprintf(os.Stderr, "Symbol version: %s@%s (%s)\n", fn, version, lib)
+					}
+				}
+				delete(pending, fn)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Completion: 100% - Per-thread arenas in parallel loop bodies
+package main
+
+// threadarena.go - Arenas owned by the worker threads of @@ loops
+//
+//	@@ i in 0..<1000 {
+//	    xs := [i, i * 2, i * 3]
+//	    arena {
+//	        scratch := alloc(4096)
+//	    }
+//	}
+//
+// Each worker thread of a parallel loop creates an arena of its own when it
+// starts, and what the loop body allocates inline (alloc(), list, map and
+// string values, copy()) comes from that arena instead of the current one,
+// so threads never bump the same offset. An arena { } block in the body
+// remembers the offset of the thread arena and rolls it back at the end.
+//
+// At the barrier, a thread destroys its arena, unless the body stores
+// something other than a number into a variable, list or map from outside
+// the loop, such as the elements of a parallel comprehension. Those values
+// must outlive the thread, so the arena is kept for the rest of the program,
+// like the arena of the parent. Runtime helpers that allocate on their own,
+// such as the list cons cells, still use the global arena.
+
+// threadArenaSize is the initial capacity of the arena of a worker thread.
+// It is mapped lazily and grows like any other arena.
+const threadArenaSize = 1024 * 1024
+
+// loadArenaPointer loads the struct of the arena that allocations use into
+// reg: the thread arena in a parallel loop body, otherwise
+// _c67_arena_meta[currentArena-1]
+func (fc *C67Compiler) loadArenaPointer(reg string) {
+	if fc.threadArenaOffset != 0 {
+		fc.out.MovMemToReg(reg, "rbp", -fc.threadArenaOffset)
+		return
+	}
+	fc.out.LeaSymbolToReg(reg, "_c67_arena_meta")
+	fc.out.MovMemToReg(reg, reg, 0)                     // reg = meta-arena array pointer
+	fc.out.MovMemToReg(reg, reg, (fc.currentArena-1)*8) // reg = arena struct pointer
+}
+
+// compileThreadArenaBlock compiles the body of an arena block inside a
+// parallel loop body. The offset of the thread arena is saved in a frame slot
+// for the nesting depth and restored afterwards, which frees what the block
+// allocated. For an arena expression, the value of the last statement is left
+// in xmm0.
+func (fc *C67Compiler) compileThreadArenaBlock(body []Statement, isExpr bool) {
+	fc.threadArenaDepth++
+	if fc.threadArenaDepth > fc.threadArenaMarks {
+		compilerError("arena blocks nested too deeply in a parallel loop body")
+	}
+	mark := fc.threadArenaOffset + 8*fc.threadArenaDepth
+	fc.out.MovMemToReg("rax", "rbp", -fc.threadArenaOffset)
+	fc.out.MovMemToReg("rax", "rax", 16) // rax = offset of the thread arena
+	fc.out.MovRegToMem("rax", "rbp", -mark)
+
+	fc.pushDeferScope()
+	for _, stmt := range body {
+		fc.compileStatement(stmt)
+	}
+	if isExpr && len(body) > 0 {
+		if assign, ok := body[len(body)-1].(*AssignStmt); ok {
+			fc.compileExpression(&IdentExpr{Name: assign.Name})
+		}
+	}
+	fc.popDeferScope()
+
+	fc.out.MovMemToReg("rcx", "rbp", -mark)
+	fc.out.MovMemToReg("rax", "rbp", -fc.threadArenaOffset)
+	fc.out.MovRegToMem("rcx", "rax", 16) // roll the offset back
+	fc.threadArenaDepth--
+}
+
+// threadArenaSlot returns the rbp offset of the thread arena pointer in the
+// frame of a worker thread. The body's own variables and loop slots are
+// addressed from rbp with the offsets collectSymbols gave them, so the slot
+// goes below the deepest of them and below the fixed slots of the thread
+// entry. The saved offsets of nested arena blocks follow it.
+func (fc *C67Compiler) threadArenaSlot() int {
+	deepest := max(56, fc.maxStackOffset) // 56 is the iterator of the thread entry
+	return (deepest+7)&^7 + 8
+}
+
+// arenaNesting returns how deeply arena blocks and expressions nest in stmts
+func arenaNesting(stmts []Statement) int {
+	deepest := 0
+	for _, stmt := range stmts {
+		var body []Statement
+		depth := 0
+		switch s := stmt.(type) {
+		case *ArenaStmt:
+			body, depth = s.Body, 1
+		case *LoopStmt:
+			body = s.Body
+		case *WhileStmt:
+			body = s.Body
+		case *AssignStmt:
+			if e, ok := s.Value.(*ArenaExpr); ok {
+				body, depth = e.Body, 1
+			}
+		case *ExpressionStmt:
+			if e, ok := s.Expr.(*ArenaExpr); ok {
+				body, depth = e.Body, 1
+			}
+		}
+		deepest = max(deepest, depth+arenaNesting(body))
+	}
+	return deepest
+}
+
+// parallelBodyEscapes reports whether the body of a parallel loop may store
+// a value from the thread arena where it outlives the thread: a value that
+// is not a number, assigned or written into a variable the body did not
+// define
+func (fc *C67Compiler) parallelBodyEscapes(body []Statement, iterator string) bool {
+	defined := map[string]bool{iterator: true}
+	escapes := false
+	outside := func(name string, value Expression) {
+		if !defined[name] && fc.getExprType(value) != "number" {
+			escapes = true
+		}
+	}
+	var scanExpr func(Expression)
+	var scan func([]Statement)
+	scan = func(stmts []Statement) {
+		for _, stmt := range stmts {
+			switch s := stmt.(type) {
+			case *AssignStmt:
+				if s.IsUpdate || s.IsReuseMutable {
+					outside(s.Name, s.Value)
+				} else {
+					defined[s.Name] = true
+				}
+			case *MultipleAssignStmt:
+				for _, name := range s.Names {
+					if s.IsUpdate {
+						outside(name, s.Value)
+					} else {
+						defined[name] = true
+					}
+				}
+			case *MapUpdateStmt:
+				outside(s.MapName, s.Value)
+			case *SliceUpdateStmt:
+				if list, ok := s.Target.List.(*IdentExpr); !ok || !defined[list.Name] {
+					escapes = true
+				}
+			case *LoopStmt:
+				defined[s.Iterator] = true
+				scan(s.Body)
+			case *WhileStmt:
+				scan(s.Body)
+			case *ArenaStmt:
+				scan(s.Body)
+			case *ExpressionStmt:
+				scanExpr(s.Expr)
+			}
+		}
+	}
+	scanExpr = func(expr Expression) {
+		switch e := expr.(type) {
+		case *BlockExpr:
+			scan(e.Statements)
+		case *MatchExpr:
+			for _, clause := range e.Clauses {
+				scanExpr(clause.Result)
+			}
+			if e.DefaultExpr != nil {
+				scanExpr(e.DefaultExpr)
+			}
+		}
+	}
+	scan(body)
+	return escapes
+}
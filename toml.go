@@ -0,0 +1,579 @@
+// Completion: 100% - parsetoml() for TOML and INI configuration
+package main
+
+import "strconv"
+
+// toml.go - Configuration file parsing
+//
+// parsetoml(text) parses the common subset of TOML, which also covers INI
+// files, into nested maps:
+//
+//	# comment, ; comment    ignored
+//	name = value            stored under hash("name") in the current table
+//	[section]               a map stored under hash("section") at the top
+//	                        level; the following keys go into it
+//
+// Values are "strings" with \n, \t, \r, \\ and \" escapes, 'literal
+// strings', true and false (1 and 0), numbers (anything strtod reads in
+// full, including 0x hex, inf and nan), single-line arrays of those, and
+// otherwise the raw text up to a # comment, as in INI files. Keys and
+// section names may be quoted; dotted names are used as they are, so
+// [a.b] is the section "a.b". A key or section given twice keeps its last
+// value, and later keys of a repeated section go into the same map.
+//
+// The text is converted to a C string in the arena, which the parser
+// terminates and unescapes in place.
+
+// compileParseTomlCall compiles parsetoml(text)
+func (fc *C67Compiler) compileParseTomlCall(call *CallExpr) {
+	if len(call.Args) != 1 {
+		compilerError("parsetoml() requires exactly 1 argument")
+	}
+	if fc.eb.target.OS() == OSWindows {
+		compilerError("parsetoml() is not supported on Windows")
+	}
+	fc.compileExpression(call.Args[0])
+	fc.out.CallSymbol("c67_string_to_cstr")
+	fc.out.MovRegToReg("rdi", "rax")
+	fc.usesToml = true
+	fc.trackFunctionCall("strtod")
+	fc.trackFunctionCall("strlen") // cstr_to_c67_string
+	fc.out.SubImmFromReg("rsp", StackSlotSize)
+	fc.out.CallSymbol("_c67_parsetoml")
+	fc.out.AddImmToReg("rsp", StackSlotSize)
+	fc.out.MovqRegToXmm("xmm0", "rax")
+}
+
+// emitTomlSkipBlanks advances reg past spaces and tabs. Clobbers r8.
+func (fc *C67Compiler) emitTomlSkipBlanks(reg string) {
+	loopStart := fc.eb.text.Len()
+	fc.out.MovU8MemToReg("r8", reg, 0)
+	fc.out.CmpRegToImm("r8", ' ')
+	blank := []int{fc.forwardJump(JumpEqual)}
+	fc.out.CmpRegToImm("r8", '\t')
+	blank = append(blank, fc.forwardJump(JumpEqual))
+	done := fc.forwardJumpAlways()
+	for _, pos := range blank {
+		fc.landForwardJump(pos)
+	}
+	fc.out.IncReg(reg)
+	fc.out.JumpUnconditional(int32(loopStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(done)
+}
+
+// emitTomlScanTo advances reg to the first of the stop bytes or the
+// terminating zero. Clobbers r8.
+func (fc *C67Compiler) emitTomlScanTo(reg string, stops ...byte) {
+	loopStart := fc.eb.text.Len()
+	fc.out.MovU8MemToReg("r8", reg, 0)
+	fc.out.TestRegReg("r8", "r8")
+	done := []int{fc.forwardJump(JumpEqual)}
+	for _, stop := range stops {
+		fc.out.CmpRegToImm("r8", int64(stop))
+		done = append(done, fc.forwardJump(JumpEqual))
+	}
+	fc.out.IncReg(reg)
+	fc.out.JumpUnconditional(int32(loopStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	for _, pos := range done {
+		fc.landForwardJump(pos)
+	}
+}
+
+// emitTomlTrimEnd moves end back over spaces, tabs and carriage returns,
+// but not past start. Clobbers r8.
+func (fc *C67Compiler) emitTomlTrimEnd(start, end string) {
+	loopStart := fc.eb.text.Len()
+	fc.out.CmpRegToReg(end, start)
+	done := []int{fc.forwardJump(JumpBelowOrEqual)}
+	fc.out.MovU8MemToReg("r8", end, -1)
+	fc.out.CmpRegToImm("r8", ' ')
+	blank := []int{fc.forwardJump(JumpEqual)}
+	fc.out.CmpRegToImm("r8", '\t')
+	blank = append(blank, fc.forwardJump(JumpEqual))
+	fc.out.CmpRegToImm("r8", '\r')
+	blank = append(blank, fc.forwardJump(JumpEqual))
+	done = append(done, fc.forwardJumpAlways())
+	for _, pos := range blank {
+		fc.landForwardJump(pos)
+	}
+	fc.out.DecReg(end)
+	fc.out.JumpUnconditional(int32(loopStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	for _, pos := range done {
+		fc.landForwardJump(pos)
+	}
+}
+
+// generateTomlHelpers emits _c67_parsetoml and the helpers it calls
+func (fc *C67Compiler) generateTomlHelpers() {
+	fc.generateTomlParse()
+	fc.generateTomlEntry()
+	fc.generateTomlNewMap()
+	fc.generateTomlHash()
+	fc.generateTomlValue()
+	fc.generateTomlString()
+}
+
+// generateTomlParse emits _c67_parsetoml(rdi=C string) -> rax, the
+// top-level map
+func (fc *C67Compiler) generateTomlParse() {
+	fc.eb.MarkFunction("_c67_parsetoml")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+	fc.out.PushReg("r15")
+	// [rsp] = key, [rsp+8] = array index, [rsp+16] = array
+	fc.out.SubImmFromReg("rsp", 24)
+	fc.out.MovRegToReg("rbx", "rdi") // rbx = cursor
+
+	// r14 = number of lines, which bounds the entries of any table
+	fc.out.MovImmToReg("r14", "1")
+	fc.out.MovRegToReg("rcx", "rdi")
+	countLoop := fc.eb.text.Len()
+	fc.out.MovU8MemToReg("r8", "rcx", 0)
+	fc.out.TestRegReg("r8", "r8")
+	counted := fc.forwardJump(JumpEqual)
+	fc.out.CmpRegToImm("r8", '\n')
+	notNewline := fc.forwardJump(JumpNotEqual)
+	fc.out.IncReg("r14")
+	fc.landForwardJump(notNewline)
+	fc.out.IncReg("rcx")
+	fc.out.JumpUnconditional(int32(countLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(counted)
+
+	fc.out.MovRegToReg("rdi", "r14")
+	fc.out.CallSymbol("_c67_toml_new_map")
+	fc.out.MovRegToReg("r12", "rax") // r12 = top-level table
+	fc.out.MovRegToReg("r13", "rax") // r13 = current table
+
+	// Start of a line, or the rest of one after a blank
+	lineLoop := fc.eb.text.Len()
+	fc.emitTomlSkipBlanks("rbx")
+	fc.out.MovU8MemToReg("r8", "rbx", 0)
+	fc.out.TestRegReg("r8", "r8")
+	var finish, skipLine []int
+	finish = append(finish, fc.forwardJump(JumpEqual))
+	var newline []int
+	fc.out.CmpRegToImm("r8", '\n')
+	newline = append(newline, fc.forwardJump(JumpEqual))
+	fc.out.CmpRegToImm("r8", '\r')
+	newline = append(newline, fc.forwardJump(JumpEqual))
+	fc.out.CmpRegToImm("r8", '#')
+	skipLine = append(skipLine, fc.forwardJump(JumpEqual))
+	fc.out.CmpRegToImm("r8", ';')
+	skipLine = append(skipLine, fc.forwardJump(JumpEqual))
+	fc.out.CmpRegToImm("r8", '[')
+	section := fc.forwardJump(JumpEqual)
+
+	// Key, bare or quoted
+	fc.out.CmpRegToImm("r8", '"')
+	bareKey := fc.forwardJump(JumpNotEqual)
+	fc.out.IncReg("rbx")
+	fc.out.MovRegToReg("r15", "rbx")
+	fc.emitTomlScanTo("rbx", '"', '\n')
+	fc.out.MovRegToReg("rsi", "rbx")
+	fc.out.CmpRegToImm("r8", '"')
+	unterminated := fc.forwardJump(JumpNotEqual)
+	fc.out.IncReg("rbx")
+	fc.landForwardJump(unterminated)
+	haveKey := fc.forwardJumpAlways()
+	fc.landForwardJump(bareKey)
+	fc.out.MovRegToReg("r15", "rbx")
+	fc.emitTomlScanTo("rbx", '=', '\n')
+	fc.out.MovRegToReg("rsi", "rbx")
+	fc.emitTomlTrimEnd("r15", "rsi")
+	fc.landForwardJump(haveKey)
+	fc.out.MovRegToReg("rdi", "r15")
+	fc.out.CallSymbol("_c67_toml_hash")
+	fc.out.MovRegToMem("rax", "rsp", 0)
+
+	fc.emitTomlSkipBlanks("rbx")
+	fc.out.CmpRegToImm("r8", '=')
+	skipLine = append(skipLine, fc.forwardJump(JumpNotEqual))
+	fc.out.IncReg("rbx")
+	fc.emitTomlSkipBlanks("rbx")
+
+	// Value: string, array or raw text
+	var store, quoted []int
+	fc.out.CmpRegToImm("r8", '"')
+	quoted = append(quoted, fc.forwardJump(JumpEqual))
+	fc.out.CmpRegToImm("r8", '\'')
+	quoted = append(quoted, fc.forwardJump(JumpEqual))
+	fc.out.CmpRegToImm("r8", '[')
+	array := fc.forwardJump(JumpEqual)
+	fc.out.MovRegToReg("r15", "rbx")
+	fc.emitTomlScanTo("rbx", '\n', '#')
+	fc.out.MovRegToReg("rsi", "rbx")
+	fc.emitTomlTrimEnd("r15", "rsi")
+	fc.out.MovRegToReg("rdi", "r15")
+	fc.out.CallSymbol("_c67_toml_value")
+	store = append(store, fc.forwardJumpAlways())
+
+	for _, pos := range quoted {
+		fc.landForwardJump(pos)
+	}
+	fc.out.MovRegToReg("rsi", "r8")
+	fc.out.MovRegToReg("rdi", "rbx")
+	fc.out.IncReg("rdi")
+	fc.out.CallSymbol("_c67_toml_string")
+	fc.out.MovRegToReg("rbx", "rax")
+	store = append(store, fc.forwardJumpAlways())
+
+	// Single-line array, with room for one more element than there are commas
+	fc.landForwardJump(array)
+	fc.out.IncReg("rbx")
+	fc.out.MovImmToReg("rdi", "1")
+	fc.out.MovRegToReg("rcx", "rbx")
+	commaLoop := fc.eb.text.Len()
+	fc.out.MovU8MemToReg("r8", "rcx", 0)
+	fc.out.TestRegReg("r8", "r8")
+	commasCounted := []int{fc.forwardJump(JumpEqual)}
+	fc.out.CmpRegToImm("r8", '\n')
+	commasCounted = append(commasCounted, fc.forwardJump(JumpEqual))
+	fc.out.CmpRegToImm("r8", ',')
+	notComma := fc.forwardJump(JumpNotEqual)
+	fc.out.IncReg("rdi")
+	fc.landForwardJump(notComma)
+	fc.out.IncReg("rcx")
+	fc.out.JumpUnconditional(int32(commaLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	for _, pos := range commasCounted {
+		fc.landForwardJump(pos)
+	}
+	fc.out.CallSymbol("_c67_toml_new_map")
+	fc.out.MovRegToMem("rax", "rsp", 16)
+	fc.out.MovImmToMem(0, "rsp", 8)
+
+	elementLoop := fc.eb.text.Len()
+	fc.emitTomlSkipBlanks("rbx")
+	fc.out.TestRegReg("r8", "r8")
+	arrayDone := []int{fc.forwardJump(JumpEqual)}
+	fc.out.CmpRegToImm("r8", '\n')
+	arrayDone = append(arrayDone, fc.forwardJump(JumpEqual))
+	fc.out.CmpRegToImm("r8", ']')
+	closed := fc.forwardJump(JumpEqual)
+	fc.out.CmpRegToImm("r8", ',')
+	notSeparator := fc.forwardJump(JumpNotEqual)
+	fc.out.IncReg("rbx")
+	fc.out.JumpUnconditional(int32(elementLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(notSeparator)
+
+	var element, quotedElement []int
+	fc.out.CmpRegToImm("r8", '"')
+	quotedElement = append(quotedElement, fc.forwardJump(JumpEqual))
+	fc.out.CmpRegToImm("r8", '\'')
+	quotedElement = append(quotedElement, fc.forwardJump(JumpEqual))
+	fc.out.MovRegToReg("r15", "rbx")
+	fc.emitTomlScanTo("rbx", ',', ']', '\n')
+	fc.out.MovRegToReg("rsi", "rbx")
+	fc.emitTomlTrimEnd("r15", "rsi")
+	fc.out.MovRegToReg("rdi", "r15")
+	fc.out.CallSymbol("_c67_toml_value")
+	element = append(element, fc.forwardJumpAlways())
+	for _, pos := range quotedElement {
+		fc.landForwardJump(pos)
+	}
+	fc.out.MovRegToReg("rsi", "r8")
+	fc.out.MovRegToReg("rdi", "rbx")
+	fc.out.IncReg("rdi")
+	fc.out.CallSymbol("_c67_toml_string")
+	fc.out.MovRegToReg("rbx", "rax")
+	for _, pos := range element {
+		fc.landForwardJump(pos)
+	}
+	fc.out.MovMemToReg("rdi", "rsp", 16)
+	fc.out.MovMemToReg("rsi", "rsp", 8)
+	fc.out.CallSymbol("_c67_toml_entry")
+	fc.out.MovXmmToMem("xmm0", "rax", 8)
+	fc.out.MovMemToReg("rax", "rsp", 8)
+	fc.out.IncReg("rax")
+	fc.out.MovRegToMem("rax", "rsp", 8)
+	fc.out.JumpUnconditional(int32(elementLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(closed)
+	fc.out.IncReg("rbx")
+	for _, pos := range arrayDone {
+		fc.landForwardJump(pos)
+	}
+	fc.out.MovMemToXmm("xmm0", "rsp", 16)
+
+	// Store xmm0 under the key in the current table
+	for _, pos := range store {
+		fc.landForwardJump(pos)
+	}
+	fc.out.MovRegToReg("rdi", "r13")
+	fc.out.MovMemToReg("rsi", "rsp", 0)
+	fc.out.CallSymbol("_c67_toml_entry")
+	fc.out.MovXmmToMem("xmm0", "rax", 8)
+	skipLine = append(skipLine, fc.forwardJumpAlways())
+
+	// [section]: reuse the table if the name was seen before
+	fc.landForwardJump(section)
+	fc.out.IncReg("rbx")
+	fc.emitTomlSkipBlanks("rbx")
+	fc.out.CmpRegToImm("r8", '"')
+	bareSection := fc.forwardJump(JumpNotEqual)
+	fc.out.IncReg("rbx")
+	fc.out.MovRegToReg("r15", "rbx")
+	fc.emitTomlScanTo("rbx", '"', '\n')
+	fc.out.MovRegToReg("rsi", "rbx")
+	haveSection := fc.forwardJumpAlways()
+	fc.landForwardJump(bareSection)
+	fc.out.MovRegToReg("r15", "rbx")
+	fc.emitTomlScanTo("rbx", ']', '\n')
+	fc.out.MovRegToReg("rsi", "rbx")
+	fc.emitTomlTrimEnd("r15", "rsi")
+	fc.landForwardJump(haveSection)
+	fc.out.MovRegToReg("rdi", "r15")
+	fc.out.CallSymbol("_c67_toml_hash")
+	fc.out.MovRegToReg("rdi", "r12")
+	fc.out.MovRegToReg("rsi", "rax")
+	fc.out.CallSymbol("_c67_toml_entry")
+	fc.out.MovRegToReg("r15", "rax") // r15 = entry in the top-level table
+	fc.out.MovMemToReg("r13", "r15", 8)
+	notTable := fc.emitJumpIfNotCollection("r13")
+	skipLine = append(skipLine, fc.forwardJumpAlways())
+	for _, pos := range notTable {
+		fc.landForwardJump(pos)
+	}
+	fc.out.MovRegToReg("rdi", "r14")
+	fc.out.CallSymbol("_c67_toml_new_map")
+	fc.out.MovRegToReg("r13", "rax")
+	fc.out.MovRegToMem("rax", "r15", 8)
+
+	// Skip the rest of the line
+	for _, pos := range skipLine {
+		fc.landForwardJump(pos)
+	}
+	fc.emitTomlScanTo("rbx", '\n')
+	fc.out.TestRegReg("r8", "r8")
+	finish = append(finish, fc.forwardJump(JumpEqual))
+	for _, pos := range newline {
+		fc.landForwardJump(pos)
+	}
+	fc.out.IncReg("rbx")
+	fc.out.JumpUnconditional(int32(lineLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	for _, pos := range finish {
+		fc.landForwardJump(pos)
+	}
+	fc.out.MovRegToReg("rax", "r12")
+	fc.out.AddImmToReg("rsp", 24)
+	fc.out.PopReg("r15")
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generateTomlEntry emits _c67_toml_entry(rdi=map, rsi=key as integer) ->
+// rax, the address of the entry for the key. A new key is appended with the
+// value 0. Preserves xmm0.
+func (fc *C67Compiler) generateTomlEntry() {
+	fc.eb.MarkFunction("_c67_toml_entry")
+
+	fc.out.Cvtsi2sd("xmm1", "rsi")
+	fc.out.MovMemToXmm("xmm2", "rdi", 0)
+	fc.out.Cvttsd2si("rcx", "xmm2") // rcx = count
+	fc.out.MovRegToReg("rax", "rdi")
+	fc.out.AddImmToReg("rax", 8)
+	fc.out.XorRegWithReg("rdx", "rdx")
+	loopStart := fc.eb.text.Len()
+	fc.out.CmpRegToReg("rdx", "rcx")
+	notFound := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovMemToXmm("xmm2", "rax", 0)
+	fc.out.Ucomisd("xmm1", "xmm2")
+	found := fc.forwardJump(JumpEqual)
+	fc.out.AddImmToReg("rax", 16)
+	fc.out.IncReg("rdx")
+	fc.out.JumpUnconditional(int32(loopStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(notFound)
+	fc.out.MovXmmToMem("xmm1", "rax", 0)
+	fc.out.MovImmToMem(0, "rax", 8)
+	fc.out.IncReg("rcx")
+	fc.out.Cvtsi2sd("xmm2", "rcx")
+	fc.out.MovXmmToMem("xmm2", "rdi", 0)
+	fc.landForwardJump(found)
+	fc.out.Ret()
+}
+
+// generateTomlNewMap emits _c67_toml_new_map(rdi=capacity) -> rax, an empty
+// map with room for capacity entries
+func (fc *C67Compiler) generateTomlNewMap() {
+	fc.eb.MarkFunction("_c67_toml_new_map")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.ShlImmReg("rdi", 4)
+	fc.out.AddImmToReg("rdi", 8)
+	fc.callArenaAlloc()
+	fc.out.MovImmToMem(0, "rax", 0)
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generateTomlHash emits _c67_toml_hash(rdi=start, rsi=end) -> rax, the key
+// hashStringKey gives the bytes in between
+func (fc *C67Compiler) generateTomlHash() {
+	fc.eb.MarkFunction("_c67_toml_hash")
+
+	fc.out.MovImmToReg("rax", "14695981039346656037") // FNV-1a 64-bit offset basis
+	fc.out.MovImmToReg("r11", "1099511628211")        // FNV-1a 64-bit prime
+	loopStart := fc.eb.text.Len()
+	fc.out.CmpRegToReg("rdi", "rsi")
+	done := fc.forwardJump(JumpGreaterOrEqual)
+	fc.out.MovU8MemToReg("r8", "rdi", 0)
+	fc.out.XorRegWithReg("rax", "r8")
+	fc.out.ImulRegWithReg("rax", "r11")
+	fc.out.IncReg("rdi")
+	fc.out.JumpUnconditional(int32(loopStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(done)
+	fc.emitSymbolKeyFold()
+	fc.out.Ret()
+}
+
+// generateTomlValue emits _c67_toml_value(rdi=start, rsi=end) -> xmm0 for
+// unquoted text: 1 for true, 0 for false, the number if strtod reads all of
+// it, and the text as a string otherwise
+func (fc *C67Compiler) generateTomlValue() {
+	fc.eb.MarkFunction("_c67_toml_value")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.SubImmFromReg("rsp", 24) // [rsp] = strtod end pointer
+	fc.out.MovRegToReg("rbx", "rdi")
+	fc.out.MovRegToReg("r12", "rsi")
+
+	// Terminate the text, keeping the byte to put back
+	fc.out.MovU8MemToReg("r13", "r12", 0)
+	fc.out.XorRegWithReg("r8", "r8")
+	fc.out.MovU8RegToMem("r8", "r12", 0)
+
+	var done []int
+	fc.out.MovRegToReg("rcx", "r12")
+	fc.out.SubRegFromReg("rcx", "rbx") // rcx = length
+	for _, word := range []struct {
+		text  string
+		value string
+	}{{"true", "1"}, {"false", "0"}} {
+		fc.out.CmpRegToImm("rcx", int64(len(word.text)))
+		mismatch := []int{fc.forwardJump(JumpNotEqual)}
+		for i := 0; i < len(word.text); i++ {
+			fc.out.MovU8MemToReg("r8", "rbx", i)
+			fc.out.CmpRegToImm("r8", int64(word.text[i]))
+			mismatch = append(mismatch, fc.forwardJump(JumpNotEqual))
+		}
+		fc.out.MovImmToReg("rax", word.value)
+		fc.out.Cvtsi2sd("xmm0", "rax")
+		done = append(done, fc.forwardJumpAlways())
+		for _, pos := range mismatch {
+			fc.landForwardJump(pos)
+		}
+	}
+
+	fc.out.MovRegToReg("rdi", "rbx")
+	fc.out.MovRegToReg("rsi", "rsp")
+	fc.eb.GenerateCallInstruction("strtod")
+	fc.out.MovMemToReg("rax", "rsp", 0)
+	fc.out.CmpRegToReg("rax", "rbx")
+	text := []int{fc.forwardJump(JumpEqual)}
+	fc.out.CmpRegToReg("rax", "r12")
+	done = append(done, fc.forwardJump(JumpEqual))
+	for _, pos := range text {
+		fc.landForwardJump(pos)
+	}
+	fc.out.MovRegToReg("rdi", "rbx")
+	fc.out.CallSymbol("cstr_to_c67_string")
+
+	for _, pos := range done {
+		fc.landForwardJump(pos)
+	}
+	fc.out.MovU8RegToMem("r13", "r12", 0)
+	fc.out.AddImmToReg("rsp", 24)
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generateTomlString emits _c67_toml_string(rdi=text after the opening
+// quote, rsi=quote byte) -> xmm0, the string, and rax, the text after the
+// closing quote. Double-quoted strings are unescaped in place.
+func (fc *C67Compiler) generateTomlString() {
+	fc.eb.MarkFunction("_c67_toml_string")
+
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+	fc.out.MovRegToReg("rbx", "rdi") // rbx = start
+	fc.out.MovRegToReg("rcx", "rdi") // rcx = read position
+	fc.out.MovRegToReg("r14", "rdi") // r14 = write position
+
+	loopStart := fc.eb.text.Len()
+	fc.out.MovU8MemToReg("r8", "rcx", 0)
+	fc.out.TestRegReg("r8", "r8")
+	unterminated := []int{fc.forwardJump(JumpEqual)}
+	fc.out.CmpRegToImm("r8", '\n')
+	unterminated = append(unterminated, fc.forwardJump(JumpEqual))
+	fc.out.CmpRegToReg("r8", "rsi")
+	closing := fc.forwardJump(JumpEqual)
+	fc.out.CmpRegToImm("rsi", '"')
+	var plain []int
+	plain = append(plain, fc.forwardJump(JumpNotEqual))
+	fc.out.CmpRegToImm("r8", '\\')
+	plain = append(plain, fc.forwardJump(JumpNotEqual))
+	fc.out.IncReg("rcx")
+	fc.out.MovU8MemToReg("r8", "rcx", 0)
+	fc.out.TestRegReg("r8", "r8")
+	unterminated = append(unterminated, fc.forwardJump(JumpEqual))
+	for _, escape := range []struct{ letter, value byte }{{'n', '\n'}, {'t', '\t'}, {'r', '\r'}} {
+		fc.out.CmpRegToImm("r8", int64(escape.letter))
+		other := fc.forwardJump(JumpNotEqual)
+		fc.out.MovImmToReg("r8", strconv.Itoa(int(escape.value)))
+		plain = append(plain, fc.forwardJumpAlways())
+		fc.landForwardJump(other)
+	}
+	for _, pos := range plain {
+		fc.landForwardJump(pos)
+	}
+	fc.out.MovU8RegToMem("r8", "r14", 0)
+	fc.out.IncReg("r14")
+	fc.out.IncReg("rcx")
+	fc.out.JumpUnconditional(int32(loopStart - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.landForwardJump(closing)
+	fc.out.IncReg("rcx")
+	for _, pos := range unterminated {
+		fc.landForwardJump(pos)
+	}
+	fc.out.MovRegToReg("r12", "rcx") // r12 = text after the string
+
+	// Terminate the string, keeping the byte to put back in case the text
+	// continues right there
+	fc.out.MovU8MemToReg("r13", "r14", 0)
+	fc.out.XorRegWithReg("r8", "r8")
+	fc.out.MovU8RegToMem("r8", "r14", 0)
+	fc.out.MovRegToReg("rdi", "rbx")
+	fc.out.CallSymbol("cstr_to_c67_string")
+	fc.out.MovU8RegToMem("r13", "r14", 0)
+	fc.out.MovRegToReg("rax", "r12")
+
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
@@ -0,0 +1,260 @@
+// Completion: 100% - Call, cycle and allocation counts with --trace-counts
+package main
+
+// trace.go - Where a program spends its time, from --trace-counts
+//
+//	c67 --trace-counts app.c67 -o app && ./app
+//
+// The program counts the calls of every lambda and the time stamp counter
+// cycles spent in it, and the allocations and bytes of every arena, and
+// writes the counts to stderr when it exits:
+//
+//	--- trace counts ---
+//	       calls           cycles  lambda
+//	         177            41503  fib
+//	 allocations            bytes  arena
+//	        2000            48000  0
+//	        2000            64000  1
+//
+// Arena 0 is the global arena and arena n an arena block nested n deep.
+// Arenas that were destroyed, like the thread arenas of @@ loops, are added
+// up as "destroyed". Only lambdas that were called and arenas that were
+// allocated from are listed. Lambdas that were inlined where they are
+// called are not called at all, so they are not listed either.
+//
+// Each lambda starts with a small thunk that counts the call, reads the
+// time stamp counter and calls the body, and adds the cycles when the body
+// returns. Cycles include the callees, and for a recursive lambda only the
+// outermost call is timed, so the cycles of fib are those of the whole
+// computation, not a sum over the depth. Tail calls loop in the body and
+// are not counted again. The counts are kept with locked instructions, so
+// @@ loops are counted too, but cycles of a lambda that runs in several
+// threads at once are only added up for the last one to return.
+
+// Offsets in the arena struct where c67_arena_alloc counts
+const (
+	traceArenaAllocations = 40
+	traceArenaBytes       = 48
+)
+
+// Offsets in the counters of a lambda
+const (
+	traceCalls  = 0
+	traceCycles = 8
+	traceDepth  = 16
+)
+
+// traceText are the lines of the report, as dprintf formats
+var traceText = [][2]string{
+	{"_c67_trace_head", "--- trace counts ---\n       calls           cycles  lambda\n"},
+	{"_c67_trace_lambda", "%12lu %16lu  %s\n"},
+	{"_c67_trace_arenas", " allocations            bytes  arena\n"},
+	{"_c67_trace_arena", "%12lu %16lu  %lu\n"},
+	{"_c67_trace_destroyed", "%12lu %16lu  destroyed\n"},
+}
+
+// traceCountsEnabled reports whether the program counts calls and allocations
+func (fc *C67Compiler) traceCountsEnabled() bool {
+	return TraceCountsFlag && fc.eb.target.OS() == OSLinux && fc.eb.target.Arch() == ArchX86_64
+}
+
+// emitTraceCountsInit checks --trace-counts at program entry, and makes
+// dprintf and the data of the report part of the first pass, which decides
+// what is linked and where the data goes
+func (fc *C67Compiler) emitTraceCountsInit() {
+	if !TraceCountsFlag {
+		return
+	}
+	if !fc.traceCountsEnabled() {
+		compilerError("--trace-counts is only supported on Linux x86-64")
+	}
+	if NoMainFlag {
+		compilerError("--trace-counts needs libc, which --no-main does not link")
+	}
+	fc.eb.DefineWritable("_c67_trace_destroyed_counts", string(make([]byte, 16)))
+	for _, text := range traceText {
+		fc.eb.Define(text[0], text[1]+"\x00")
+	}
+	fc.trackFunctionCall("dprintf")
+}
+
+// traceCounters is the symbol of the counters of the lambda name
+func traceCounters(name string) string {
+	return "_c67_trace_" + name
+}
+
+// emitTraceThunk emits, at the label of a lambda, the thunk that counts and
+// times its calls. The body of the lambda follows it.
+func (fc *C67Compiler) emitTraceThunk(name string) {
+	if !fc.traceCountsEnabled() {
+		return
+	}
+	counters := traceCounters(name)
+	fc.eb.DefineWritable(counters, string(make([]byte, 24)))
+	fc.eb.Define(counters+"_name", name+"\x00")
+
+	fc.out.SubImmFromReg("rsp", 8) // the start, and keeps the body aligned
+	fc.out.PushReg("rax")
+	fc.out.PushReg("rdx")
+	fc.out.LeaSymbolToReg("rax", counters)
+	fc.out.Emit([]byte{0xf0, 0x48, 0xff, 0x00})             // lock inc qword [rax]
+	fc.out.Emit([]byte{0xf0, 0x48, 0xff, 0x40, traceDepth}) // lock inc qword [rax+16]
+	fc.emitReadTSC()
+	fc.out.MovRegToMem("rax", "rsp", 16)
+	fc.out.PopReg("rdx")
+	fc.out.PopReg("rax")
+	call := fc.eb.text.Len()
+	fc.out.Emit([]byte{0xe8, 0, 0, 0, 0}) // call the body
+
+	fc.out.PushReg("rax")
+	fc.out.PushReg("rdx")
+	fc.emitReadTSC()
+	fc.out.Emit([]byte{0x48, 0x2b, 0x44, 0x24, 0x10}) // sub rax, [rsp+16]
+	fc.out.LeaSymbolToReg("rdx", counters)
+	fc.out.Emit([]byte{0xf0, 0x48, 0xff, 0x4a, traceDepth}) // lock dec qword [rdx+16]
+	nested := fc.forwardJump(JumpNotEqual)
+	fc.out.Emit([]byte{0xf0, 0x48, 0x01, 0x42, traceCycles}) // lock add [rdx+8], rax
+	fc.landForwardJump(nested)
+	fc.out.PopReg("rdx")
+	fc.out.PopReg("rax")
+	fc.out.AddImmToReg("rsp", 8)
+	fc.out.Ret()
+	fc.patchJumpImmediate(call+1, int32(fc.eb.text.Len()-(call+5)))
+}
+
+// emitReadTSC reads the time stamp counter into rax. Clobbers rdx.
+func (fc *C67Compiler) emitReadTSC() {
+	fc.out.Emit([]byte{0x0f, 0x31}) // rdtsc
+	fc.out.ShlRegByImm("rdx", 32)
+	fc.out.OrRegWithReg("rax", "rdx")
+}
+
+// emitTraceArenaAlloc counts an allocation of rsi bytes from the arena in
+// rdi. It is emitted at the entry of c67_arena_alloc.
+func (fc *C67Compiler) emitTraceArenaAlloc() {
+	if !fc.traceCountsEnabled() {
+		return
+	}
+	fc.out.TestRegReg("rdi", "rdi")
+	noArena := fc.forwardJump(JumpEqual)
+	fc.out.Emit([]byte{0xf0, 0x48, 0x83, 0x47, traceArenaAllocations, 0x01}) // lock add qword [rdi+40], 1
+	fc.out.Emit([]byte{0xf0, 0x48, 0x01, 0x77, traceArenaBytes})             // lock add [rdi+48], rsi
+	fc.landForwardJump(noArena)
+}
+
+// emitTraceArenaDestroy adds the counts of the arena in rbx to the
+// destroyed arenas, from c67_arena_destroy. Clobbers rax and rcx.
+func (fc *C67Compiler) emitTraceArenaDestroy() {
+	if !fc.traceCountsEnabled() {
+		return
+	}
+	fc.out.LeaSymbolToReg("rcx", "_c67_trace_destroyed_counts")
+	fc.out.MovMemToReg("rax", "rbx", traceArenaAllocations)
+	fc.out.Emit([]byte{0xf0, 0x48, 0x01, 0x01}) // lock add [rcx], rax
+	fc.out.MovMemToReg("rax", "rbx", traceArenaBytes)
+	fc.out.Emit([]byte{0xf0, 0x48, 0x01, 0x41, 0x08}) // lock add [rcx+8], rax
+}
+
+// emitTraceReport writes the counts to stderr, before the program exits.
+// Preserves rdi.
+func (fc *C67Compiler) emitTraceReport() {
+	if fc.traceCountsEnabled() {
+		fc.out.CallSymbol("_c67_trace_report")
+	}
+}
+
+// generateTraceReport emits _c67_trace_report(), which lists the lambdas
+// that were called and the arenas that were allocated from
+func (fc *C67Compiler) generateTraceReport() {
+	fc.eb.MarkFunction("_c67_trace_report")
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rdi")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.AndRegWithImm("rsp", -16)
+
+	fc.emitTraceLine("_c67_trace_head", "", "", "")
+	var names []string
+	for _, lambda := range fc.lambdaFuncs {
+		names = append(names, lambda.Name)
+	}
+	for _, lambda := range fc.patternLambdaFuncs {
+		names = append(names, lambda.Name)
+	}
+	listed := make(map[string]bool)
+	for _, name := range names {
+		if listed[name] {
+			continue
+		}
+		listed[name] = true
+		fc.out.LeaSymbolToReg("rbx", traceCounters(name))
+		fc.out.MovMemToReg("rdx", "rbx", traceCalls)
+		fc.out.TestRegReg("rdx", "rdx")
+		uncalled := fc.forwardJump(JumpEqual)
+		fc.out.MovMemToReg("rcx", "rbx", traceCycles)
+		fc.out.LeaSymbolToReg("r8", traceCounters(name)+"_name")
+		fc.emitTraceLine("_c67_trace_lambda", "rdx", "rcx", "r8")
+		fc.landForwardJump(uncalled)
+	}
+
+	// Arenas by their slot in the meta-arena
+	fc.emitTraceLine("_c67_trace_arenas", "", "", "")
+	fc.out.LeaSymbolToReg("rbx", "_c67_arena_meta")
+	fc.out.MovMemToReg("rbx", "rbx", 0)
+	fc.out.XorRegWithReg("r12", "r12")
+	fc.out.TestRegReg("rbx", "rbx")
+	noArenas := fc.forwardJump(JumpEqual)
+	loop := fc.eb.text.Len()
+	fc.out.LeaSymbolToReg("rax", "_c67_arena_meta_len")
+	fc.out.MovMemToReg("rax", "rax", 0)
+	fc.out.CmpRegToReg("r12", "rax")
+	done := fc.forwardJump(JumpAboveOrEqual)
+	fc.out.MovMemToReg("r13", "rbx", 0)
+	fc.out.AddImmToReg("rbx", 8)
+	fc.out.TestRegReg("r13", "r13")
+	unusedSlot := fc.forwardJump(JumpEqual)
+	fc.out.MovMemToReg("rdx", "r13", traceArenaAllocations)
+	fc.out.TestRegReg("rdx", "rdx")
+	unusedArena := fc.forwardJump(JumpEqual)
+	fc.out.MovMemToReg("rcx", "r13", traceArenaBytes)
+	fc.emitTraceLine("_c67_trace_arena", "rdx", "rcx", "r12")
+	fc.landForwardJump(unusedArena)
+	fc.landForwardJump(unusedSlot)
+	fc.out.IncReg("r12")
+	fc.out.JumpUnconditional(int32(loop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.landForwardJump(done)
+	fc.landForwardJump(noArenas)
+
+	fc.out.LeaSymbolToReg("rbx", "_c67_trace_destroyed_counts")
+	fc.out.MovMemToReg("rdx", "rbx", 0)
+	fc.out.TestRegReg("rdx", "rdx")
+	noneDestroyed := fc.forwardJump(JumpEqual)
+	fc.out.MovMemToReg("rcx", "rbx", 8)
+	fc.emitTraceLine("_c67_trace_destroyed", "rdx", "rcx", "")
+	fc.landForwardJump(noneDestroyed)
+
+	fc.out.LeaMemToReg("rsp", "rbp", -32)
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rdi")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// emitTraceLine calls dprintf(2, format, a, b, c) with the registers a, b
+// and c, which may be "" when the format has fewer arguments
+func (fc *C67Compiler) emitTraceLine(format, a, b, c string) {
+	for i, reg := range []string{a, b, c} {
+		if to := []string{"rdx", "rcx", "r8"}[i]; reg != "" && reg != to {
+			fc.out.MovRegToReg(to, reg)
+		}
+	}
+	fc.out.MovImmToReg("rdi", "2")
+	fc.out.LeaSymbolToReg("rsi", format)
+	fc.out.XorRegWithReg("rax", "rax") // no vector arguments
+	fc.trackFunctionCall("dprintf")
+	fc.eb.GenerateCallInstruction("dprintf")
+}
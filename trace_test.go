@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestTraceCounts tests that --trace-counts reports the calls of a lambda
+// and the allocations of the global arena at exit
+func TestTraceCounts(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("--trace-counts is only supported on Linux x86-64")
+	}
+	defer func() { TraceCountsFlag = false }()
+	TraceCountsFlag = true
+
+	source := `tick = x -> {
+    y := x * 2
+    y / 2 + 1
+}
+total := 0
+@ k in 0..<100 {
+    total <- tick(total)
+}
+xs := [total, total + 1]
+println(xs[1])
+`
+	stdout, stderr, exitCode := runCommandSeparate(exec.Command(compileTestCode(t, source)))
+	if stdout != "101\n" || exitCode != 0 {
+		t.Fatalf("Expected \"101\\n\" and exit code 0, got %q and %d", stdout, exitCode)
+	}
+	lines := strings.Split(stderr, "\n")
+	if len(lines) < 6 || lines[0] != "--- trace counts ---" {
+		t.Fatalf("Expected a trace count report, got %q", stderr)
+	}
+	if fields := strings.Fields(lines[2]); len(fields) != 3 || fields[0] != "100" || fields[2] != "tick" {
+		t.Errorf("Expected 100 calls of tick, got %q", lines[2])
+	}
+	if fields := strings.Fields(lines[4]); len(fields) != 3 || fields[0] != "1" || fields[1] != "40" || fields[2] != "0" {
+		t.Errorf("Expected one allocation of 40 bytes in arena 0, got %q", lines[4])
+	}
+}
@@ -109,7 +109,7 @@ printf("%f\n", x)
 x: cdouble = 3.14159
 printf("%f\n", x)
 `,
-			expected: "3.141589\n", // Rounding: off by 1 in last digit (IEEE 754 precision limits)
+			expected: "3.141590\n",
 		},
 	}
 
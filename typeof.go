@@ -0,0 +1,98 @@
+// Completion: 100% - typeof(x)
+package main
+
+// typeof.go - The kind of a value as a symbol
+//
+// typeof(x) returns one of the symbols :number, :string, :list, :map,
+// :lambda, :cptr, :none, :error and :persistent, so that a generic function
+// can match on it:
+//
+//	typeof(x) {
+//	    :string -> println(x)
+//	    :error -> println(x.error)
+//	    ~> println(f"{x}")
+//	}
+//
+// Only errors carry a tag at runtime (a quiet NaN with a payload, see
+// createErrorResult). Lists, maps and strings are all pointers to the
+// universal [count][key][value]... layout, and lambdas are pointers too, so
+// the kind comes from the compile-time type of x when it is known. When it
+// is not, as for a lambda parameter or the result of a call, the bits
+// decide: an error is :error, a nonzero value with a zero exponent is a
+// pointer, which is reported as :map since every collection is one, and
+// anything else is :number. A C pointer or C string that is 0 is :none.
+
+// typeofKinds maps compile-time types to the kind typeof reports
+var typeofKinds = map[string]string{
+	"string":     "string",
+	"list":       "list",
+	"map":        "map",
+	"persistent": "persistent",
+	"cstring":    "cptr",
+	"cpointer":   "cptr",
+	"pointer":    "cptr",
+}
+
+// typeofKind returns the kind of expr known at compile time, or "" when the
+// value has to be inspected at runtime
+func (fc *C67Compiler) typeofKind(expr Expression) string {
+	switch e := expr.(type) {
+	case *LambdaExpr, *PatternLambdaExpr, *MultiLambdaExpr:
+		return "lambda"
+	case *IdentExpr:
+		if fc.lambdaVars[e.Name] {
+			return "lambda"
+		}
+	}
+	return typeofKinds[fc.getExprType(expr)]
+}
+
+// compileTypeofCall compiles typeof(x)
+func (fc *C67Compiler) compileTypeofCall(call *CallExpr) {
+	if len(call.Args) != 1 {
+		compilerError("typeof() requires exactly 1 argument")
+	}
+	kind := fc.typeofKind(call.Args[0])
+	switch kind {
+	case "cptr":
+		fc.compileExpression(call.Args[0])
+		fc.out.MovqXmmToReg("rax", "xmm0")
+		fc.out.TestRegReg("rax", "rax")
+		null := fc.forwardJump(JumpEqual)
+		fc.compileExpression(&NumberExpr{Value: symbolValue("cptr")})
+		done := fc.forwardJumpAlways()
+		fc.landForwardJump(null)
+		fc.compileExpression(&NumberExpr{Value: symbolValue("none")})
+		fc.landForwardJump(done)
+		return
+	case "":
+	default:
+		if _, isIdent := call.Args[0].(*IdentExpr); !isIdent {
+			fc.compileExpression(call.Args[0])
+		}
+		fc.compileExpression(&NumberExpr{Value: symbolValue(kind)})
+		return
+	}
+
+	fc.compileExpression(call.Args[0])
+	notError := fc.emitJumpIfNotError()
+	fc.compileExpression(&NumberExpr{Value: symbolValue("error")})
+	errorDone := fc.forwardJumpAlways()
+	for _, pos := range notError {
+		fc.landForwardJump(pos)
+	}
+	// A pointer has a zero exponent and sign, unlike any number but 0
+	fc.out.MovqXmmToReg("rax", "xmm0")
+	fc.out.MovRegToReg("rcx", "rax")
+	fc.out.ShrRegByImm("rcx", 52)
+	notPointer := fc.forwardJump(JumpNotEqual)
+	fc.out.TestRegReg("rax", "rax")
+	zero := fc.forwardJump(JumpEqual)
+	fc.compileExpression(&NumberExpr{Value: symbolValue("map")})
+	pointerDone := fc.forwardJumpAlways()
+	fc.landForwardJump(notPointer)
+	fc.landForwardJump(zero)
+	fc.compileExpression(&NumberExpr{Value: symbolValue("number")})
+	fc.landForwardJump(errorDone)
+	fc.landForwardJump(pointerDone)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestTypeofKnownTypes tests typeof() on values whose type is known at compile time
+func TestTypeofKnownTypes(t *testing.T) {
+	source := `f = x -> x + 1
+p = 0 as ptr
+q = 4096 as ptr
+println((typeof(1) == :number) + (typeof("hi") == :string) + (typeof([1, 2]) == :list))
+println((typeof({a: 1}) == :map) + (typeof(f) == :lambda) + (typeof(ilist()) == :persistent))
+println((typeof(p) == :none) + (typeof(q) == :cptr) + (typeof(error("arg")) == :error))
+`
+	result := compileAndRun(t, source)
+	if !strings.Contains(result, "3\n3\n3\n") {
+		t.Errorf("Expected output to contain '3\\n3\\n3\\n', got: %s", result)
+	}
+}
+
+// TestTypeofRuntime tests typeof() on lambda parameters, which are inspected at runtime
+func TestTypeofRuntime(t *testing.T) {
+	source := `kind = x -> typeof(x) {
+    :number -> 1
+    :map -> 2
+    :error -> 3
+    ~> 4
+}
+println(kind(0) * 1000 + kind(-2.5) * 100 + kind([1, 2]) * 10 + kind({a: 1}))
+println(kind(0 / 0))
+`
+	result := compileAndRun(t, source)
+	if !strings.Contains(result, "1122\n3\n") {
+		t.Errorf("Expected output to contain '1122\\n3\\n', got: %s", result)
+	}
+}
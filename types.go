@@ -74,6 +74,21 @@ func (t *C67Type) IsNative() bool {
 	}
 }
 
+// ExprType returns the getExprType name for a native string, list or map
+// type, and "" for anything else
+func (t *C67Type) ExprType() string {
+	switch t.Kind {
+	case TypeString:
+		return "string"
+	case TypeList:
+		return "list"
+	case TypeMap:
+		return "map"
+	default:
+		return ""
+	}
+}
+
 // IsForeign returns true if this is a C foreign type
 func (t *C67Type) IsForeign() bool {
 	return !t.IsNative() && t.Kind != TypeUnknown
@@ -231,3 +231,33 @@ println(result)
 		}
 	}
 }
+
+// TestUndefinedNameSuggestions tests that misspelled functions, variables
+// and C functions get "did you mean" suggestions
+func TestUndefinedNameSuggestions(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      string
+		want      string
+		needsLibm bool
+	}{
+		{"function", "square = x -> x * x\nmain = {\n    println(squar(3))\n}\n", "squar (did you mean: square", false},
+		{"builtin", "main = {\n    println(sqrtt(16))\n}\n", "sqrtt (did you mean: sqrt?)", false},
+		{"variable", "main = {\n    counter := 1\n    println(countr)\n}\n", "Did you mean: counter?", false},
+		{"C function", "import \"m\" as m\nmain = {\n    println(m.sqrtt(16.0))\n}\n", "unknown C function m.sqrtt. Did you mean: m.sqrt", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.needsLibm && runtime.GOOS != "linux" {
+				t.Skip("needs the symbols of libm")
+			}
+			_, err := compileTestCodeAllowError(t, tt.code)
+			if err == nil {
+				t.Fatal("Expected a compilation error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected %q in the error, got: %v", tt.want, err)
+			}
+		})
+	}
+}
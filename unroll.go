@@ -0,0 +1,117 @@
+// Completion: 100% - unroll N @ and unrolling of tiny constant loops
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// unroll.go - Loop unrolling
+//
+//	unroll 4 @ i in 0..<n {
+//	    sum <- sum + xs[i]
+//	}
+//
+// compiles the body four times in a row, each copy with the next value of
+// i, so the loop jumps back once per four iterations. Each copy checks the
+// end of the range and the max of the loop first, so n does not have to be
+// a multiple of 4, and no iterations are left over for a scalar loop after
+// it. unroll 1 keeps a loop from being unrolled.
+//
+// Without unroll, a range loop with constant bounds that takes at most
+// autoUnrollTrips iterations, and whose body is small, is unrolled
+// completely:
+//
+//	@ i in 0..<3 { println(i) }   # println(0); println(1); println(2)
+//
+// unroll is only a keyword before a number and @. Parallel loops and loops
+// over lists are not unrolled, and neither are bodies that define lambdas
+// or defer statements, which can not be compiled twice. ARM64 ignores
+// unroll.
+
+const (
+	autoUnrollTrips = 4  // most iterations of a loop that is unrolled without unroll
+	autoUnrollNodes = 40 // most AST nodes in its body
+)
+
+// parseUnrollLoop parses unroll N and the loop after it
+func (p *Parser) parseUnrollLoop() Statement {
+	p.nextToken() // skip 'unroll'
+	copies, err := strconv.Atoi(p.current.Value)
+	if err != nil || copies < 1 {
+		p.error("unroll must be followed by a positive integer")
+	}
+	p.nextToken() // skip the number
+	if p.current.Type != TOKEN_AT {
+		p.error("expected @ after unroll " + strconv.Itoa(copies))
+		return nil
+	}
+	stmt := p.parseLoopStatement()
+	loop, ok := stmt.(*LoopStmt)
+	if !ok || loop.NumThreads != 0 {
+		p.error("unroll needs a sequential loop over a range, like @ i in 0..<n")
+		return stmt
+	}
+	if _, isRange := loop.Iterable.(*RangeExpr); !isRange {
+		p.error("unroll needs a sequential loop over a range, like @ i in 0..<n")
+		return stmt
+	}
+	if blocker := unrollBlocker(loop.Body); blocker != "" && copies > 1 {
+		p.error(fmt.Sprintf("unroll %d: the loop body has %s, which can not be compiled twice", copies, blocker))
+	}
+	loop.Unroll = copies
+	return loop
+}
+
+// unrollBlocker returns what in body keeps it from being unrolled, or ""
+func unrollBlocker(body []Statement) string {
+	blocker := ""
+	visitAST(reflect.ValueOf(body), func(node any) {
+		if blocker != "" {
+			return
+		}
+		switch n := node.(type) {
+		case *LambdaExpr:
+			blocker = "a lambda"
+		case *DeferStmt:
+			blocker = "a defer statement"
+		case *ParallelExpr, *BackgroundExpr, *SpawnStmt:
+			blocker = "a parallel operation"
+		case *LoopStmt:
+			if n.NumThreads != 0 {
+				blocker = "a parallel loop"
+			}
+		}
+	})
+	return blocker
+}
+
+// unrollTinyLoops marks the range loops of program with constant bounds,
+// few iterations and a small body to be unrolled completely
+func unrollTinyLoops(program *Program) {
+	visitAST(reflect.ValueOf(program.Statements), func(node any) {
+		loop, ok := node.(*LoopStmt)
+		if !ok || loop.Unroll != 0 || loop.NumThreads != 0 {
+			return
+		}
+		rangeExpr, ok := loop.Iterable.(*RangeExpr)
+		if !ok {
+			return
+		}
+		start, startOK := rangeExpr.Start.(*NumberExpr)
+		end, endOK := rangeExpr.End.(*NumberExpr)
+		if !startOK || !endOK {
+			return
+		}
+		trips := rangeExpr.TripCount(int64(start.Value), int64(end.Value))
+		if trips < 2 || trips > autoUnrollTrips || unrollBlocker(loop.Body) != "" {
+			return
+		}
+		nodes := 0
+		visitAST(reflect.ValueOf(loop.Body), func(any) { nodes++ })
+		if nodes <= autoUnrollNodes {
+			loop.Unroll = int(trips)
+		}
+	})
+}
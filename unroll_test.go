@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestUnrollLoop tests unroll N with a trip count that is not a multiple
+// of N, with @++ and ret @ in the body
+func TestUnrollLoop(t *testing.T) {
+	source := `xs := [1, 2, 3, 4, 5, 6, 7]
+sum := 0
+unroll 4 @ i in 0..<7 {
+    sum <- sum + xs[i]
+}
+println(sum)
+n := 10
+count := 0
+unroll 3 @ i in 0..<n max 20 {
+    i % 2 == 0 {
+        @++
+    }
+    i == 9 {
+        ret @
+    }
+    t := i * 10
+    count <- count + t
+}
+println(count)
+total := 0
+@ i in 0..<4 {
+    @ j in 3..>0 {
+        total <- total + i * j
+    }
+}
+println(total)
+`
+	result := compileAndRun(t, source)
+	if !strings.Contains(result, "28\n160\n36\n") {
+		t.Errorf("Expected output to contain '28\\n160\\n36\\n', got: %s", result)
+	}
+}
+
+// TestUnrollTinyLoops tests which loops are unrolled without unroll
+func TestUnrollTinyLoops(t *testing.T) {
+	source := `@ i in 0..<3 {
+    println(i)
+}
+@ i in 0..<100 {
+    println(i)
+}
+unroll 1 @ i in 0..<2 {
+    println(i)
+}
+@ i in 0..<2 {
+    f = x -> x + i
+    println(f(1))
+}
+`
+	program := NewParser(source).ParseProgram()
+	var got []int
+	visitAST(reflect.ValueOf(program.Statements), func(node any) {
+		if loop, ok := node.(*LoopStmt); ok {
+			got = append(got, loop.Unroll)
+		}
+	})
+	if want := []int{3, 0, 1, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected unroll %v, got %v", want, got)
+	}
+}
+
+// TestUnrollErrors tests unroll on loops it can not unroll
+func TestUnrollErrors(t *testing.T) {
+	tests := []struct {
+		source, want string
+	}{
+		{"unroll 2 @ i in 0..<4 {\n    f = x -> x + i\n    println(f(1))\n}\n", "unroll 2: the loop body has a lambda, which can not be compiled twice"},
+		{"xs := [1, 2]\nunroll 2 @ x in xs {\n    println(x)\n}\n", "unroll needs a sequential loop over a range"},
+		{"unroll 0 @ i in 0..<4 {\n    println(i)\n}\n", "unroll must be followed by a positive integer"},
+	}
+	for _, tt := range tests {
+		parser := NewParser(tt.source)
+		parser.quiet = true
+		func() {
+			defer func() { recover() }()
+			parser.ParseProgram()
+		}()
+		if report := parser.errors.Report(false); !strings.Contains(report, tt.want) {
+			t.Errorf("Expected %q, got:\n%s", tt.want, report)
+		}
+	}
+}
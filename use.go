@@ -0,0 +1,156 @@
+// Completion: 100% - use "file" includes a file once per compilation
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// use.go - Shared files of constants and cstructs
+//
+//	use "limits.c67"
+//
+// includes the statements of limits.c67, found next to the file with the
+// use statement, in place of the use statement, without a namespace, so
+// MAX_PLAYERS and the cstructs of the file are used by their own names,
+// and MAX_PLAYERS and Point.size are replaced by their values when the
+// file that uses them is parsed. Files may use other files.
+//
+// Each file is parsed once per compilation, and its statements are only
+// included the first time it is used, like a C header with an include
+// guard. A file that uses it after that, or a file that uses itself, only
+// gets its constants and cstructs.
+//
+// A name that is defined with = or as a cstruct in a used file and in
+// another file must be defined the same way in both, and is then defined
+// once. Otherwise the compilation stops with both places:
+//
+//	MAX defined as 10 at limits.c67:1 and as 20 at game.c67:3
+//
+// Only module-level use statements are included.
+
+// useState is what the parsers of one compilation know of used files
+type useState struct {
+	files       map[string]*usedFile     // by absolute path
+	definitions map[string]useDefinition // module-level definitions, by name
+}
+
+// usedFile is what a used file declares for the parser of a file that
+// uses it
+type usedFile struct {
+	constants map[string]Expression
+	cstructs  map[string]*CStructDecl
+}
+
+// useDefinition is where a name was first defined, and how
+type useDefinition struct {
+	stmt     Statement
+	file     string
+	line     int
+	fromUsed bool // in a used file
+}
+
+// usedFiles is reset for each program that is compiled
+var usedFiles = newUseState()
+
+func newUseState() *useState {
+	return &useState{files: make(map[string]*usedFile), definitions: make(map[string]useDefinition)}
+}
+
+// includeUse returns the statements of the file of a module-level use
+// statement, or none if it was included already, and declares its
+// constants and cstructs in p
+func (p *Parser) includeUse(use *UseStmt) []Statement {
+	path := use.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(p.filename), path)
+	}
+	path, _ = filepath.Abs(path)
+
+	var statements []Statement
+	file, parsed := usedFiles.files[path]
+	if !parsed {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			p.error(fmt.Sprintf("use %q: %v", use.Path, err))
+			return nil
+		}
+		buildManifest.addSource(path, content)
+		usedFiles.files[path] = &usedFile{} // a file that uses itself is included once
+		child := NewParserWithFilename(string(content), path)
+		child.fromUsed = true
+		child.unoptimized = true // optimized with the statements of p
+		statements = child.ParseProgram().Statements
+		file = &usedFile{constants: child.constants, cstructs: child.cstructs}
+		usedFiles.files[path] = file
+	}
+
+	for name, value := range file.constants {
+		if _, ok := p.constants[name]; !ok {
+			p.constants[name] = value
+		}
+	}
+	for name, decl := range file.cstructs {
+		if _, ok := p.cstructs[name]; !ok {
+			p.cstructs[name] = decl
+		}
+	}
+	return statements
+}
+
+// defineOnce records the module-level definition stmt, on line, and
+// reports whether it is to be kept. A definition that is the same as one
+// in a used file, or in a file that uses it, is left out, and one that is
+// not stops the compilation. Definitions in files that are not used, like
+// siblings, are not compared.
+func (p *Parser) defineOnce(stmt Statement, line int) bool {
+	name := useDefinitionName(stmt)
+	if name == "" || p.errors.HasErrors() {
+		return true
+	}
+	earlier, seen := usedFiles.definitions[name]
+	if !seen {
+		usedFiles.definitions[name] = useDefinition{stmt: stmt, file: p.filename, line: line, fromUsed: p.fromUsed}
+		return true
+	}
+	if earlier.file == p.filename || (!earlier.fromUsed && !p.fromUsed) {
+		return true
+	}
+	if hashAST(earlier.stmt) != hashAST(stmt) {
+		p.error(fmt.Sprintf("%s defined as %s at %s:%d and as %s at %s:%d",
+			name, useDefinitionText(earlier.stmt), filepath.Base(earlier.file), earlier.line,
+			useDefinitionText(stmt), filepath.Base(p.filename), line))
+	}
+	return false
+}
+
+// useDefinitionName returns the name that stmt defines with = or as a
+// cstruct, or "" if it defines none
+func useDefinitionName(stmt Statement) string {
+	switch s := stmt.(type) {
+	case *AssignStmt:
+		if !s.Mutable && !s.IsUpdate && !s.IsReuseMutable {
+			return s.Name
+		}
+	case *CStructDecl:
+		return s.Name
+	}
+	return ""
+}
+
+// useDefinitionText is what a definition defines its name as, for messages
+func useDefinitionText(stmt Statement) string {
+	switch s := stmt.(type) {
+	case *AssignStmt:
+		return s.Value.String()
+	case *CStructDecl:
+		fields := make([]string, len(s.Fields))
+		for i, field := range s.Fields {
+			fields[i] = field.Name + " as " + field.Type
+		}
+		return "cstruct { " + strings.Join(fields, ", ") + " }"
+	}
+	return stmt.String()
+}
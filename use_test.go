@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeUseFiles writes the files, by name, to a new directory and returns
+// the path of main.c67
+func writeUseFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, source := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "main.c67")
+}
+
+// TestUseOnce tests that files that use each other are included once, with
+// their constants and cstructs, and that the same definition in two files
+// is allowed
+func TestUseOnce(t *testing.T) {
+	main := writeUseFiles(t, map[string]string{
+		"limits.c67": "use \"shapes.c67\"\nMIN = 1\nMAX = 10\n",
+		"shapes.c67": "use \"limits.c67\"\nMAX = 10\ncstruct Point { x as int32, y as int32 }\n",
+		"main.c67":   "use \"shapes.c67\"\nuse \"limits.c67\"\nuse \"shapes.c67\"\nprintln(MAX + MIN)\nprintln(Point.size)\n",
+	})
+	exe := strings.TrimSuffix(main, ".c67")
+	if err := CompileC67(main, exe, Platform{Arch: ArchX86_64, OS: OSLinux}); err != nil {
+		t.Fatalf("Compilation failed: %v", err)
+	}
+	output, err := exec.Command(exe).Output()
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if string(output) != "11\n8\n" {
+		t.Errorf("Expected 11 and 8, got %q", output)
+	}
+}
+
+// TestUseConflict tests that a name that is defined differently in two
+// files stops the compilation with both places
+func TestUseConflict(t *testing.T) {
+	main := writeUseFiles(t, map[string]string{
+		"limits.c67": "MIN = 1\nMAX = 10\n",
+		"main.c67":   "use \"limits.c67\"\n\nMAX = 20\nprintln(MAX)\n",
+	})
+	source, _ := os.ReadFile(main)
+	usedFiles = newUseState()
+	parser := NewParserWithFilename(string(source), main)
+	parser.quiet = true
+	func() {
+		defer func() { recover() }()
+		parser.ParseProgram()
+	}()
+	want := "MAX defined as 10 at limits.c67:2 and as 20 at main.c67:3"
+	if report := parser.errors.Report(false); !strings.Contains(report, want) {
+		t.Errorf("Expected %q, got:\n%s", want, report)
+	}
+}
@@ -2,8 +2,11 @@
 package main
 
 import (
+	"fmt"
 	"hash/fnv"
+	"os"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -14,17 +17,55 @@ import (
 
 // hashStringKey hashes a string identifier to a uint64 for use as a map key.
 // Uses FNV-1a hash algorithm for deterministic, collision-resistant hashing.
-// Currently limited to 30-bit hash due to compiler integer literal limitations.
-// Sets bit 30 to distinguish symbolic keys from typical numeric indices.
+// Map keys are float64 values, so the 64-bit hash is folded to 52 bits and
+// bit 52 is set: every key in 2^52..2^53-1 is an exact float64 integer, and
+// bit 52 distinguishes symbolic keys from typical numeric indices.
+// The runtime hash(s) and intern(s) helper must match this (see intern.go).
 func hashStringKey(s string) uint64 {
 	h := fnv.New64a()
 	h.Write([]byte(s))
-	// Use FNV-1a 32-bit variant for now, mask to 30 bits (0x3FFFFFFF)
-	// Then set bit 30 (0x40000000) to distinguish symbolic keys
-	// This gives us range 0x40000000 to 0x7FFFFFFF (1073741824 to 2147483647)
-	h32 := fnv.New32a()
-	h32.Write([]byte(s))
-	return uint64((h32.Sum32() & 0x3FFFFFFF) | 0x40000000)
+	key := (h.Sum64() & symbolKeyMask) | symbolKeyBit
+	recordKeyName(key, s)
+	return key
+}
+
+// keyNames maps the keys that hashStringKey has given out back to the names
+// they were hashed from, for fieldnames(m) (see fields.go) and to catch two
+// names with the same key. It is reset for each program that is compiled.
+var keyNames = map[uint64]string{}
+
+// recordKeyName records that name hashed to key, and stops the compilation
+// if another name in the program did too, since the two fields would then
+// be the same field
+func recordKeyName(key uint64, name string) {
+	if other, ok := keyNames[key]; ok && other != name {
+		first, second := other, name
+		if second < first {
+			first, second = second, first
+		}
+		compilerError("the names %q and %q hash to the same map key (0x%x), so they would be the same field; rename one of them", first, second, key)
+	}
+	keyNames[key] = name
+}
+
+const (
+	symbolKeyBit  = 1 << 52
+	symbolKeyMask = symbolKeyBit - 1
+)
+
+// envInt64 returns the integer in environment variable name, or 0 when it is
+// unset or not a number
+func envInt64(name string) int64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring %s=%q: not an integer\n", name, value)
+		return 0
+	}
+	return n
 }
 
 // levenshteinDistance calculates the edit distance between two strings
@@ -77,6 +118,17 @@ func min(a, b int) int {
 
 // findSimilarIdentifiers finds identifiers similar to the given name
 func findSimilarIdentifiers(name string, availableVars map[string]int, maxSuggestions int) []string {
+	names := make([]string, 0, len(availableVars))
+	for varName := range availableVars {
+		names = append(names, varName)
+	}
+	return findSimilarNames(name, names, maxSuggestions)
+}
+
+// findSimilarNames returns up to maxSuggestions of names that are a few
+// edits away from name, closest first. A name that differs only in case is
+// as close as can be.
+func findSimilarNames(name string, names []string, maxSuggestions int) []string {
 	type suggestion struct {
 		name     string
 		distance int
@@ -84,11 +136,20 @@ func findSimilarIdentifiers(name string, availableVars map[string]int, maxSugges
 
 	var suggestions []suggestion
 	threshold := 3 // Maximum edit distance for suggestions
+	seen := make(map[string]bool)
 
-	for varName := range availableVars {
-		dist := levenshteinDistance(name, varName)
-		if dist <= threshold && dist > 0 {
-			suggestions = append(suggestions, suggestion{varName, dist})
+	for _, candidate := range names {
+		if seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+		dist := levenshteinDistance(name, candidate)
+		if strings.EqualFold(name, candidate) {
+			dist = 0
+		}
+		// Every one-letter name is close to every other one
+		if candidate != name && dist <= threshold && dist < max(len(name), 2) {
+			suggestions = append(suggestions, suggestion{candidate, dist})
 		}
 	}
 
@@ -0,0 +1,414 @@
+// Completion: 100% - Module complete
+package main
+
+// vecmath.go - Vector and matrix builtins for game code
+//
+// vec2(x, y), vec3(x, y, z) and vec4(x, y, z, w) build ordinary lists, so
+// v[i], #v and println work on vectors as on any other list. The builtins
+// below accept any list of numbers and return new lists:
+//
+//	vadd(a, b), vsub(a, b), vmul(a, b), vdiv(a, b)  component-wise, length min(#a, #b)
+//	vscale(v, s)                                    every component times s
+//	dot(a, b)                                       sum of component products (a number)
+//	cross(a, b)                                     3D cross product of two vec3
+//	normalize(v)                                    v / sqrt(dot(v, v)), zero stays zero
+//	mat4mul(a, b)                                   product of two row-major 4x4 matrices
+//	                                                stored as lists of 16 numbers
+//
+// On x86-64, list values sit 16 bytes apart (interleaved with their keys),
+// so two components are gathered into one XMM register with movsd + movhpd
+// and processed with packed SSE2 instructions.
+
+// vectorListFuncs are the vector builtins that return a new list
+var vectorListFuncs = map[string]bool{
+	"vadd": true, "vsub": true, "vmul": true, "vdiv": true,
+	"vscale": true, "cross": true, "normalize": true, "mat4mul": true,
+}
+
+// compileVectorCall compiles a call to one of the vector builtins
+func (fc *C67Compiler) compileVectorCall(call *CallExpr) {
+	if call.Function == "normalize" {
+		if len(call.Args) != 1 {
+			compilerError("normalize() requires exactly 1 argument")
+		}
+	} else if len(call.Args) != 2 {
+		compilerError("%s() requires exactly 2 arguments", call.Function)
+	}
+
+	if call.Function == "normalize" {
+		fc.compileExpression(call.Args[0])
+		fc.out.MovqXmmToReg("rdi", "xmm0")
+	} else {
+		fc.compileExpression(call.Args[0])
+		fc.out.SubImmFromReg("rsp", 16)
+		fc.out.MovXmmToMem("xmm0", "rsp", 0)
+		fc.compileExpression(call.Args[1])
+		if call.Function == "vscale" {
+			// The scale factor stays in xmm0
+			fc.out.MovMemToReg("rdi", "rsp", 0)
+		} else {
+			fc.out.MovqXmmToReg("rsi", "xmm0")
+			fc.out.MovMemToReg("rdi", "rsp", 0)
+		}
+		fc.out.AddImmToReg("rsp", 16)
+	}
+
+	helper := map[string]string{
+		"vadd": "_c67_vec_add", "vsub": "_c67_vec_sub",
+		"vmul": "_c67_vec_mul", "vdiv": "_c67_vec_div",
+		"vscale": "_c67_vec_scale", "dot": "_c67_vec_dot",
+		"cross": "_c67_vec_cross", "normalize": "_c67_vec_normalize",
+		"mat4mul": "_c67_mat4_mul",
+	}[call.Function]
+
+	fc.usesVecMath = true
+	fc.out.SubImmFromReg("rsp", StackSlotSize)
+	fc.out.CallSymbol(helper)
+	fc.out.AddImmToReg("rsp", StackSlotSize)
+
+	// dot returns a number in xmm0, the others a list pointer in rax
+	if call.Function != "dot" {
+		fc.out.MovqRegToXmm("xmm0", "rax")
+	}
+}
+
+// generateVecMathHelpers generates the runtime helpers behind the vector builtins
+func (fc *C67Compiler) generateVecMathHelpers() {
+	fc.generateVecBinop("_c67_vec_add", fc.out.AddpdXmm, fc.out.AddsdXmm)
+	fc.generateVecBinop("_c67_vec_sub", fc.out.SubpdXmm, fc.out.SubsdXmm)
+	fc.generateVecBinop("_c67_vec_mul", fc.out.MulpdXmm, fc.out.MulsdXmm)
+	fc.generateVecBinop("_c67_vec_div", fc.out.DivpdXmm, fc.out.DivsdXmm)
+	fc.generateVecBinop("_c67_vec_scale", fc.out.MulpdXmm, fc.out.MulsdXmm)
+	fc.generateVecDot()
+	fc.generateVecNormalize()
+	fc.generateVecCross()
+	fc.generateMat4Mul()
+}
+
+// emitVecAllocResult allocates a list with r14 entries and keys 0..r14-1
+// Returns: rbx = new list. Clobbers rax, rcx, rdx, rdi, rsi and caller-saved registers.
+func (fc *C67Compiler) emitVecAllocResult() {
+	fc.out.MovRegToReg("rdi", "r14")
+	fc.out.ShlRegImm("rdi", "4")
+	fc.out.AddImmToReg("rdi", 8)
+	fc.callArenaAlloc()
+	fc.out.MovRegToReg("rbx", "rax")
+
+	fc.out.Cvtsi2sd("xmm0", "r14")
+	fc.out.MovXmmToMem("xmm0", "rbx", 0)
+
+	// Keys are the integer indices, as in list literals
+	fc.out.XorRegWithReg("rcx", "rcx")
+	fc.out.MovRegToReg("rdx", "rbx")
+	fc.out.AddImmToReg("rdx", 8)
+	keyLoop := fc.eb.text.Len()
+	fc.out.CmpRegToReg("rcx", "r14")
+	keysDoneJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpGreaterOrEqual, 0)
+	keysDoneEnd := fc.eb.text.Len()
+	fc.out.MovRegToMem("rcx", "rdx", 0)
+	fc.out.IncReg("rcx")
+	fc.out.AddImmToReg("rdx", 16)
+	fc.out.JumpUnconditional(int32(keyLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+	fc.patchJumpImmediate(keysDoneJump+2, int32(fc.eb.text.Len()-keysDoneEnd))
+}
+
+// generateVecBinop generates label(a, b) -> new list with a[i] op b[i]
+// Arguments: rdi = a, rsi = b (for _c67_vec_scale: rdi = v, xmm0 = scale)
+// Returns: rax = new list
+func (fc *C67Compiler) generateVecBinop(label string, packedOp, scalarOp func(dst, src string)) {
+	scale := label == "_c67_vec_scale"
+
+	fc.eb.MarkFunction(label)
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+	fc.out.SubImmFromReg("rsp", 16) // scale factor slot, keeps rsp aligned
+
+	fc.out.MovRegToReg("r12", "rdi")
+	fc.out.MovMemToXmm("xmm1", "r12", 0)
+	fc.out.Cvttsd2si("r14", "xmm1")
+	if scale {
+		fc.out.MovXmmToMem("xmm0", "rsp", 0)
+	} else {
+		// Use the shorter length
+		fc.out.MovRegToReg("r13", "rsi")
+		fc.out.MovMemToXmm("xmm1", "r13", 0)
+		fc.out.Cvttsd2si("rax", "xmm1")
+		fc.out.CmpRegToReg("r14", "rax")
+		fc.out.Emit([]byte{0x4c, 0x0f, 0x4f, 0xf0}) // cmovg r14, rax
+	}
+
+	fc.emitVecAllocResult()
+
+	// r8 = a values, r9 = b values, r10 = result values, rcx = components left
+	fc.out.MovRegToReg("r8", "r12")
+	fc.out.AddImmToReg("r8", 16)
+	if scale {
+		fc.out.MovMemToXmm("xmm1", "rsp", 0)
+		fc.out.Emit([]byte{0x66, 0x0f, 0x14, 0xc9}) // unpcklpd xmm1, xmm1
+	} else {
+		fc.out.MovRegToReg("r9", "r13")
+		fc.out.AddImmToReg("r9", 16)
+	}
+	fc.out.MovRegToReg("r10", "rbx")
+	fc.out.AddImmToReg("r10", 16)
+	fc.out.MovRegToReg("rcx", "r14")
+
+	// Two components per iteration
+	pairLoop := fc.eb.text.Len()
+	fc.out.CmpRegToImm("rcx", 2)
+	tailJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpLess, 0)
+	tailEnd := fc.eb.text.Len()
+	fc.out.MovMemToXmm("xmm0", "r8", 0)
+	fc.out.MovhpdMemToXmm("xmm0", "r8", 16)
+	if !scale {
+		fc.out.MovMemToXmm("xmm1", "r9", 0)
+		fc.out.MovhpdMemToXmm("xmm1", "r9", 16)
+	}
+	packedOp("xmm0", "xmm1")
+	fc.out.MovXmmToMem("xmm0", "r10", 0)
+	fc.out.MovhpdXmmToMem("xmm0", "r10", 16)
+	fc.out.AddImmToReg("r8", 32)
+	if !scale {
+		fc.out.AddImmToReg("r9", 32)
+	}
+	fc.out.AddImmToReg("r10", 32)
+	fc.out.SubImmFromReg("rcx", 2)
+	fc.out.JumpUnconditional(int32(pairLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	// Odd component left over
+	fc.patchJumpImmediate(tailJump+2, int32(fc.eb.text.Len()-tailEnd))
+	fc.out.TestRegReg("rcx", "rcx")
+	doneJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpEqual, 0)
+	doneEnd := fc.eb.text.Len()
+	fc.out.MovMemToXmm("xmm0", "r8", 0)
+	if !scale {
+		fc.out.MovMemToXmm("xmm1", "r9", 0)
+	}
+	scalarOp("xmm0", "xmm1")
+	fc.out.MovXmmToMem("xmm0", "r10", 0)
+	fc.patchJumpImmediate(doneJump+2, int32(fc.eb.text.Len()-doneEnd))
+
+	fc.out.MovRegToReg("rax", "rbx")
+	fc.out.AddImmToReg("rsp", 16)
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generateVecDot generates _c67_vec_dot(a, b) -> number
+// Arguments: rdi = a, rsi = b
+// Returns: xmm0 = sum of a[i] * b[i] over the shorter length
+// Leaf function: clobbers only rax, rcx, r8, r9 and xmm0-xmm2.
+func (fc *C67Compiler) generateVecDot() {
+	fc.eb.MarkFunction("_c67_vec_dot")
+
+	fc.out.MovMemToXmm("xmm0", "rdi", 0)
+	fc.out.Cvttsd2si("rcx", "xmm0")
+	fc.out.MovMemToXmm("xmm0", "rsi", 0)
+	fc.out.Cvttsd2si("rax", "xmm0")
+	fc.out.CmpRegToReg("rcx", "rax")
+	fc.out.Emit([]byte{0x48, 0x0f, 0x4f, 0xc8}) // cmovg rcx, rax
+
+	fc.out.MovRegToReg("r8", "rdi")
+	fc.out.AddImmToReg("r8", 16)
+	fc.out.MovRegToReg("r9", "rsi")
+	fc.out.AddImmToReg("r9", 16)
+	fc.out.XorpdXmm("xmm2", "xmm2")
+
+	pairLoop := fc.eb.text.Len()
+	fc.out.CmpRegToImm("rcx", 2)
+	tailJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpLess, 0)
+	tailEnd := fc.eb.text.Len()
+	fc.out.MovMemToXmm("xmm0", "r8", 0)
+	fc.out.MovhpdMemToXmm("xmm0", "r8", 16)
+	fc.out.MovMemToXmm("xmm1", "r9", 0)
+	fc.out.MovhpdMemToXmm("xmm1", "r9", 16)
+	fc.out.MulpdXmm("xmm0", "xmm1")
+	fc.out.AddpdXmm("xmm2", "xmm0")
+	fc.out.AddImmToReg("r8", 32)
+	fc.out.AddImmToReg("r9", 32)
+	fc.out.SubImmFromReg("rcx", 2)
+	fc.out.JumpUnconditional(int32(pairLoop - (fc.eb.text.Len() + UnconditionalJumpSize)))
+
+	fc.patchJumpImmediate(tailJump+2, int32(fc.eb.text.Len()-tailEnd))
+	fc.out.TestRegReg("rcx", "rcx")
+	sumJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpEqual, 0)
+	sumEnd := fc.eb.text.Len()
+	fc.out.MovMemToXmm("xmm0", "r8", 0)
+	fc.out.MovMemToXmm("xmm1", "r9", 0)
+	fc.out.MulsdXmm("xmm0", "xmm1")
+	fc.out.AddsdXmm("xmm2", "xmm0") // low lane only
+
+	// Horizontal sum of the two lanes
+	fc.patchJumpImmediate(sumJump+2, int32(fc.eb.text.Len()-sumEnd))
+	fc.out.Emit([]byte{0x66, 0x0f, 0x28, 0xc2}) // movapd xmm0, xmm2
+	fc.out.Emit([]byte{0x66, 0x0f, 0x15, 0xd2}) // unpckhpd xmm2, xmm2
+	fc.out.AddsdXmm("xmm0", "xmm2")
+	fc.out.Ret()
+}
+
+// generateVecNormalize generates _c67_vec_normalize(v) -> new list
+// Arguments: rdi = v
+// Returns: rax = v scaled to length 1, or all zeros if v has length 0
+func (fc *C67Compiler) generateVecNormalize() {
+	fc.eb.MarkFunction("_c67_vec_normalize")
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.SubImmFromReg("rsp", StackSlotSize)
+
+	fc.out.MovRegToReg("rbx", "rdi")
+	fc.out.MovRegToReg("rsi", "rdi")
+	fc.out.CallSymbol("_c67_vec_dot")
+	fc.out.Sqrtsd("xmm0", "xmm0")
+
+	// Scale by 1/length, or by 0 for the zero vector
+	fc.out.XorpdXmm("xmm1", "xmm1")
+	fc.out.Ucomisd("xmm0", "xmm1")
+	zeroJump := fc.eb.text.Len()
+	fc.out.JumpConditional(JumpEqual, 0)
+	zeroEnd := fc.eb.text.Len()
+	fc.out.MovImmToReg("rax", "1")
+	fc.out.Cvtsi2sd("xmm1", "rax")
+	fc.out.DivsdXmm("xmm1", "xmm0")
+	fc.patchJumpImmediate(zeroJump+2, int32(fc.eb.text.Len()-zeroEnd))
+	fc.out.MovXmmToXmm("xmm0", "xmm1")
+
+	fc.out.MovRegToReg("rdi", "rbx")
+	fc.out.CallSymbol("_c67_vec_scale")
+
+	fc.out.AddImmToReg("rsp", StackSlotSize)
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generateVecCross generates _c67_vec_cross(a, b) -> new vec3
+// Arguments: rdi = a, rsi = b (first three components are used)
+// Returns: rax = [a1*b2 - a2*b1, a2*b0 - a0*b2, a0*b1 - a1*b0]
+func (fc *C67Compiler) generateVecCross() {
+	fc.eb.MarkFunction("_c67_vec_cross")
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+
+	fc.out.MovRegToReg("r12", "rdi")
+	fc.out.MovRegToReg("r13", "rsi")
+	fc.out.MovImmToReg("r14", "3")
+	fc.emitVecAllocResult()
+
+	// Component i of a list is at offset 16 + 16*i
+	// x and y together: [a1, a2] * [b2, b0] - [a2, a0] * [b1, b2]
+	fc.out.MovMemToXmm("xmm0", "r12", 32)
+	fc.out.MovhpdMemToXmm("xmm0", "r12", 48)
+	fc.out.MovMemToXmm("xmm1", "r13", 48)
+	fc.out.MovhpdMemToXmm("xmm1", "r13", 16)
+	fc.out.MovMemToXmm("xmm2", "r12", 48)
+	fc.out.MovhpdMemToXmm("xmm2", "r12", 16)
+	fc.out.MovMemToXmm("xmm3", "r13", 32)
+	fc.out.MovhpdMemToXmm("xmm3", "r13", 48)
+	fc.out.MulpdXmm("xmm0", "xmm1")
+	fc.out.MulpdXmm("xmm2", "xmm3")
+	fc.out.SubpdXmm("xmm0", "xmm2")
+	fc.out.MovXmmToMem("xmm0", "rbx", 16)
+	fc.out.MovhpdXmmToMem("xmm0", "rbx", 32)
+
+	// z: a0*b1 - a1*b0
+	fc.out.MovMemToXmm("xmm0", "r12", 16)
+	fc.out.MovMemToXmm("xmm1", "r13", 32)
+	fc.out.MulsdXmm("xmm0", "xmm1")
+	fc.out.MovMemToXmm("xmm2", "r12", 32)
+	fc.out.MovMemToXmm("xmm3", "r13", 16)
+	fc.out.MulsdXmm("xmm2", "xmm3")
+	fc.out.SubsdXmm("xmm0", "xmm2")
+	fc.out.MovXmmToMem("xmm0", "rbx", 48)
+
+	fc.out.MovRegToReg("rax", "rbx")
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
+
+// generateMat4Mul generates _c67_mat4_mul(a, b) -> new 16-element list
+// Arguments: rdi = a, rsi = b (row-major 4x4 matrices)
+// Returns: rax = a * b
+// Each result row is accumulated in xmm4 (columns 0-1) and xmm5 (columns 2-3)
+// as the sum over k of a[row][k] broadcast times row k of b.
+func (fc *C67Compiler) generateMat4Mul() {
+	fc.eb.MarkFunction("_c67_mat4_mul")
+	fc.out.PushReg("rbp")
+	fc.out.MovRegToReg("rbp", "rsp")
+	fc.out.PushReg("rbx")
+	fc.out.PushReg("r12")
+	fc.out.PushReg("r13")
+	fc.out.PushReg("r14")
+
+	fc.out.MovRegToReg("r12", "rdi")
+	fc.out.MovRegToReg("r13", "rsi")
+	fc.out.MovImmToReg("r14", "16")
+	fc.emitVecAllocResult()
+
+	// rsi = a[row][k], r10 = result row, rcx = rows left
+	fc.out.MovRegToReg("rsi", "r12")
+	fc.out.AddImmToReg("rsi", 16)
+	fc.out.MovRegToReg("r10", "rbx")
+	fc.out.AddImmToReg("r10", 16)
+	fc.out.MovImmToReg("rcx", "4")
+
+	rowLoop := fc.eb.text.Len()
+	fc.out.XorpdXmm("xmm4", "xmm4")
+	fc.out.XorpdXmm("xmm5", "xmm5")
+	fc.out.MovRegToReg("r9", "r13") // r9 = row k of b
+	fc.out.AddImmToReg("r9", 16)
+	fc.out.MovImmToReg("rdx", "4")
+
+	kLoop := fc.eb.text.Len()
+	fc.out.MovMemToXmm("xmm0", "rsi", 0)
+	fc.out.Emit([]byte{0x66, 0x0f, 0x14, 0xc0}) // unpcklpd xmm0, xmm0
+	fc.out.MovMemToXmm("xmm1", "r9", 0)
+	fc.out.MovhpdMemToXmm("xmm1", "r9", 16)
+	fc.out.MovMemToXmm("xmm2", "r9", 32)
+	fc.out.MovhpdMemToXmm("xmm2", "r9", 48)
+	fc.out.MulpdXmm("xmm1", "xmm0")
+	fc.out.MulpdXmm("xmm2", "xmm0")
+	fc.out.AddpdXmm("xmm4", "xmm1")
+	fc.out.AddpdXmm("xmm5", "xmm2")
+	fc.out.AddImmToReg("rsi", 16)
+	fc.out.AddImmToReg("r9", 64)
+	fc.out.SubImmFromReg("rdx", 1)
+	fc.out.JumpConditional(JumpNotEqual, int32(kLoop-(fc.eb.text.Len()+ConditionalJumpSize)))
+
+	fc.out.MovXmmToMem("xmm4", "r10", 0)
+	fc.out.MovhpdXmmToMem("xmm4", "r10", 16)
+	fc.out.MovXmmToMem("xmm5", "r10", 32)
+	fc.out.MovhpdXmmToMem("xmm5", "r10", 48)
+	fc.out.AddImmToReg("r10", 64)
+	fc.out.SubImmFromReg("rcx", 1)
+	fc.out.JumpConditional(JumpNotEqual, int32(rowLoop-(fc.eb.text.Len()+ConditionalJumpSize)))
+
+	fc.out.MovRegToReg("rax", "rbx")
+	fc.out.PopReg("r14")
+	fc.out.PopReg("r13")
+	fc.out.PopReg("r12")
+	fc.out.PopReg("rbx")
+	fc.out.PopReg("rbp")
+	fc.out.Ret()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestVectorBuiltins tests vec3, component-wise ops, dot, cross, normalize and mat4mul
+func TestVectorBuiltins(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name: "vec3_is_list",
+			source: `v := vec3(1, 2, 3)
+println(#v)
+println(v[2])
+`,
+			expected: "3\n3\n",
+		},
+		{
+			name: "componentwise",
+			source: `a := vec3(1, 2, 3)
+b := vec3(4, 5, 6)
+c := vadd(a, b)
+d := vsub(b, a)
+e := vmul(a, b)
+f := vdiv(b, a)
+println(c[0] + c[1] + c[2])
+println(d[2])
+println(e[2])
+println(f[1] * 10)
+`,
+			expected: "21\n3\n18\n25\n",
+		},
+		{
+			name: "vscale_vec4",
+			source: `s := vscale(vec4(1, 2, 3, 4), 2.5)
+println(s[3])
+println(#s)
+`,
+			expected: "10\n4\n",
+		},
+		{
+			name: "dot_cross",
+			source: `a := vec3(1, 2, 3)
+b := vec3(4, 5, 6)
+println(dot(a, b))
+c := cross(a, b)
+println(c[0])
+println(c[1])
+println(c[2])
+`,
+			expected: "32\n-3\n6\n-3\n",
+		},
+		{
+			name: "normalize",
+			source: `n := normalize(vec2(3, 4))
+println(f"{n[0]:.3} {n[1]:.3}")
+z := normalize(vec3(0, 0, 0))
+println(z[1])
+`,
+			expected: "0.600 0.800\n0\n",
+		},
+		{
+			name: "mat4mul",
+			source: `m := [1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16]
+q := mat4mul(m, m)
+println(q[0])
+println(q[3])
+println(q[12])
+println(q[15])
+`,
+			expected: "90\n120\n426\n600\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := compileAndRun(t, tt.source)
+			if !strings.Contains(result, tt.expected) {
+				t.Errorf("Expected output to contain: %s, got: %s", tt.expected, result)
+			}
+		})
+	}
+}
@@ -69,15 +69,21 @@ func (o *Out) xorX86RegWithReg(dst, src string) {
 		fmt.Fprintf(os.Stderr, "xor %s, %s:", dst, src)
 	}
 
-	// REX prefix for 64-bit operation
+	// Zeroing a register needs no REX.W, since writing the 32-bit half
+	// clears the upper half: xor eax, eax is one byte shorter
 	rex := uint8(0x48)
+	if dstReg.Encoding == srcReg.Encoding {
+		rex = 0x40
+	}
 	if (dstReg.Encoding & 8) != 0 {
 		rex |= 0x01 // REX.B
 	}
 	if (srcReg.Encoding & 8) != 0 {
 		rex |= 0x04 // REX.R
 	}
-	o.Write(rex)
+	if rex != 0x40 {
+		o.Write(rex)
+	}
 
 	// XOR opcode (0x31 for r/m64, r64)
 	o.Write(0x31)
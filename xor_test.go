@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestXorRegWithRegZeroing tests that zeroing a register uses the 32-bit
+// operand form and that other XORs keep REX.W
+func TestXorRegWithRegZeroing(t *testing.T) {
+	tests := []struct {
+		dst, src string
+		want     []byte
+	}{
+		{"rax", "rax", []byte{0x31, 0xC0}},
+		{"rdi", "rdi", []byte{0x31, 0xFF}},
+		{"r8", "r8", []byte{0x45, 0x31, 0xC0}},
+		{"r15", "r15", []byte{0x45, 0x31, 0xFF}},
+		{"rax", "rbx", []byte{0x48, 0x31, 0xD8}},
+		{"r9", "rcx", []byte{0x49, 0x31, 0xC9}},
+		{"rax", "r10", []byte{0x4C, 0x31, 0xD0}},
+	}
+
+	for _, tt := range tests {
+		eb, err := New("x86_64")
+		if err != nil {
+			t.Fatalf("Failed to create ExecutableBuilder: %v", err)
+		}
+		out := NewOut(eb.target, &BufferWrapper{&eb.text}, eb)
+		out.XorRegWithReg(tt.dst, tt.src)
+		if got := eb.text.Bytes(); !bytes.Equal(got, tt.want) {
+			t.Errorf("xor %s, %s: got % x, want % x", tt.dst, tt.src, got, tt.want)
+		}
+	}
+}